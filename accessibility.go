@@ -0,0 +1,126 @@
+package library
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The accessible formats a book can be available in.
+const (
+	FormatLargePrint = "large-print"
+	FormatBraille    = "braille"
+	FormatDAISYAudio = "daisy-audio"
+	FormatDAISYText  = "daisy-text"
+)
+
+var accessibleFormats = map[string]bool{
+	FormatLargePrint: true,
+	FormatBraille:    true,
+	FormatDAISYAudio: true,
+	FormatDAISYText:  true,
+}
+
+// onixAccessibleFormats maps ONIX product form detail codes (codelist 175)
+// to accessible formats. A201-A212 are the DAISY 2 and DAISY 3 variants,
+// ordered from full audio to full text.
+var onixAccessibleFormats = map[string][]string{
+	"A201": {FormatDAISYAudio},
+	"A202": {FormatDAISYAudio},
+	"A203": {FormatDAISYAudio, FormatDAISYText},
+	"A204": {FormatDAISYAudio, FormatDAISYText},
+	"A205": {FormatDAISYAudio, FormatDAISYText},
+	"A206": {FormatDAISYText},
+	"A207": {FormatDAISYAudio},
+	"A208": {FormatDAISYAudio},
+	"A209": {FormatDAISYAudio, FormatDAISYText},
+	"A210": {FormatDAISYAudio, FormatDAISYText},
+	"A211": {FormatDAISYAudio, FormatDAISYText},
+	"A212": {FormatDAISYText},
+}
+
+// AccessibleFormatsFromONIX converts ONIX product form detail codes to the
+// accessible formats of a book. Codes which do not describe an accessible
+// format are ignored.
+func AccessibleFormatsFromONIX(codes []string) []string {
+	seen := make(map[string]bool)
+	var formats []string
+	for _, code := range codes {
+		for _, format := range onixAccessibleFormats[code] {
+			if !seen[format] {
+				seen[format] = true
+				formats = append(formats, format)
+			}
+		}
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// validateAccessibleFormats checks that every format is known and only
+// listed once.
+func validateAccessibleFormats(formats []string) error {
+	seen := make(map[string]bool, len(formats))
+	for _, format := range formats {
+		if !accessibleFormats[format] {
+			return fmt.Errorf("unknown accessible format %q", format)
+		}
+		if seen[format] {
+			return fmt.Errorf("accessible format %q listed twice", format)
+		}
+		seen[format] = true
+	}
+	return nil
+}
+
+// hasAccessibleFormats reports whether the book is available in all of the
+// given formats.
+func hasAccessibleFormats(b Book, formats []string) bool {
+	for _, want := range formats {
+		found := false
+		for _, format := range b.AccessibleFormats {
+			if format == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// attachAccessibleFormats reads the accessible formats of the books from
+// the database and adds them to the books. An empty isbn reads the formats of
+// all books.
+func attachAccessibleFormats(db Querier, books []Book, isbn string) []Book {
+	if len(books) == 0 {
+		return books
+	}
+	query := "SELECT isbn, format FROM book_accessibility"
+	var args []interface{}
+	if isbn != "" {
+		query += " WHERE isbn=?"
+		args = append(args, isbn)
+	}
+	rows, err := db.Query(query+" ORDER BY isbn, format;", args...)
+	if err != nil {
+		handleErr("Failed to QUERY the accessible formats from the database", err)
+		return books
+	}
+	defer rows.Close()
+
+	formats := make(map[string][]string)
+	for rows.Next() {
+		var bookISBN, format string
+		if err := rows.Scan(&bookISBN, &format); err != nil {
+			handleErr("Failed to read the accessible formats from the database", err)
+			return books
+		}
+		formats[bookISBN] = append(formats[bookISBN], format)
+	}
+	for i := range books {
+		books[i].AccessibleFormats = formats[books[i].ISBN]
+	}
+	return books
+}
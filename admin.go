@@ -0,0 +1,231 @@
+package library
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed ui/templates/*.html
+var uiTemplates embed.FS
+
+// adminPages are the pages of the admin UI, each parsed together with the
+// layout.
+var adminPages = map[string]*template.Template{
+	"books":  parseAdminPage("books.html"),
+	"book":   parseAdminPage("book.html"),
+	"import": parseAdminPage("import.html"),
+	"login":  parseAdminPage("login.html"),
+}
+
+func parseAdminPage(name string) *template.Template {
+	return template.Must(template.New(name).Funcs(template.FuncMap{"asset": assetURL}).
+		ParseFS(uiTemplates, "ui/templates/layout.html", "ui/templates/"+name))
+}
+
+func renderAdminPage(w http.ResponseWriter, code int, page string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := adminPages[page].ExecuteTemplate(w, "layout.html", data); err != nil {
+		handleErr("Failed to render the admin page", err)
+	}
+}
+
+// matchesQuery reports whether the book matches a search of the admin UI.
+func matchesQuery(b Book, query string) bool {
+	fields := []string{b.ISBN, b.Title, b.Publisher, b.Description}
+	if b.Author != nil {
+		fields = append(fields, b.Author.FirstName+" "+b.Author.LastName)
+	}
+	query = strings.ToLower(query)
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminLoginPath is the page of the admin UI where the staff log in.
+const adminLoginPath = "/admin/login"
+
+type adminLoginPage struct {
+	Error string
+	Next  string // Where the page goes once logged in
+}
+
+// adminPage lets only the staff use a page of the admin UI, since it shows
+// the drafts and embargoed books. The staff are the librarians and admins,
+// and the trainees, whose changes become revisions. Requests which are not
+// logged in are redirected to the login page.
+func (s *Server) adminPage(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, err := s.sessionMember(r)
+		if errors.Is(err, errNoMember) {
+			http.Redirect(w, r, adminLoginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		} else if err != nil {
+			handleErr("Failed to read the session", err)
+			renderAdminPage(w, http.StatusInternalServerError, "login", adminLoginPage{Error: "Failed to read the session"})
+			return
+		}
+		if !hasRole(m, RoleLibrarian) && !hasRole(m, RoleAdmin) && !hasRole(m, RoleTrainee) {
+			renderAdminPage(w, http.StatusForbidden, "login", adminLoginPage{
+				Error: "Only librarians, admins and trainees can use the admin UI", Next: r.URL.RequestURI()})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// AdminLogin is the page which logs in to the admin UI through the login of
+// the API, and then goes to the page given by the next query parameter.
+func (s *Server) AdminLogin(w http.ResponseWriter, r *http.Request) {
+	next := r.URL.Query().Get("next")
+	// Only pages of the admin UI, so that the login can not be used to
+	// redirect elsewhere
+	if !strings.HasPrefix(next, "/admin/") {
+		next = "/admin/books"
+	}
+	renderAdminPage(w, http.StatusOK, "login", adminLoginPage{Next: next})
+}
+
+// AdminHome redirects to the start page of the admin UI.
+func (s *Server) AdminHome(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/admin/books", http.StatusFound)
+}
+
+// AdminListBooks is the admin page which lists the books, filtered by the q
+// query parameter. Embargoed books are included.
+func (s *Server) AdminListBooks(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	books := ReadDatabaseList(s.db)
+	if query != "" {
+		books = filterBooks(books, func(b Book) bool { return matchesQuery(b, query) })
+	}
+	sortBooks(books, "title", s.locale)
+	renderAdminPage(w, http.StatusOK, "books", struct {
+		Query string
+		Books []Book
+	}{query, books})
+}
+
+type adminBookPage struct {
+	Book      Book
+	Error     string
+	Saved     bool
+	Proposed  bool // The change was proposed as a revision
+	CSRFToken string
+	// LockPath is where the page renews the lock of the book every
+	// LockInterval seconds, "" unless the member holds the lock
+	LockPath     string
+	LockInterval int
+}
+
+// AdminGetBook is the admin page with the form which edits a book. The book
+// is locked for librarians and admins while the page is open, and the page
+// tells who is editing the book when another member holds the lock.
+func (s *Server) AdminGetBook(w http.ResponseWriter, r *http.Request) {
+	book := FindSpecificBook(s.db, mux.Vars(r)["isbn"])
+	if book.ISBN == "" {
+		http.NotFound(w, r)
+		return
+	}
+	page := adminBookPage{
+		Book:      book,
+		Saved:     r.URL.Query().Get("saved") == "true",
+		Proposed:  r.URL.Query().Get("proposed") == "true",
+		CSRFToken: s.csrfToken(w, r),
+	}
+	if m, err := s.lockingMember(r); err == nil {
+		err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+			_, err := acquireBookLock(tx, book.ISBN, m, time.Now(), s.lockTTL)
+			return err
+		})
+		var le *lockedError
+		switch {
+		case errors.As(err, &le):
+			page.Error = le.Error()
+		case err != nil:
+			handleErr("Failed to lock the book", err)
+		default:
+			page.LockPath = "/api/v1/books/" + book.ISBN + ":lock"
+			page.LockInterval = int(s.lockTTL.Seconds() / 2)
+		}
+	}
+	renderAdminPage(w, http.StatusOK, "book", page)
+}
+
+// AdminUpdateBook saves the form of the admin book page. The fields which
+// are not in the form keep their values. Forms without the CSRF token of the
+// browser are rejected, and the forms of trainees are proposed as revisions.
+func (s *Server) AdminUpdateBook(w http.ResponseWriter, r *http.Request) {
+	isbn := mux.Vars(r)["isbn"]
+	book := FindSpecificBook(s.db, isbn)
+	if book.ISBN == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderAdminPage(w, http.StatusBadRequest, "book", adminBookPage{Book: book, Error: "Failed to read the form",
+			CSRFToken: s.csrfToken(w, r)})
+		return
+	}
+	if !s.checkCSRF(r) {
+		renderAdminPage(w, http.StatusForbidden, "book", adminBookPage{Book: book,
+			Error: "The form has expired, please submit it again", CSRFToken: s.csrfToken(w, r)})
+		return
+	}
+	book.Title = r.PostForm.Get("title")
+	book.Author = &Author{FirstName: r.PostForm.Get("firstName"), LastName: r.PostForm.Get("lastName")}
+	book.Publisher = r.PostForm.Get("publisher")
+	book.Classification = r.PostForm.Get("classification")
+	book.CallNumber = r.PostForm.Get("callNumber")
+
+	saved := "saved"
+	editor, err := s.editor(r)
+	if err == nil {
+		err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+			if isTrainee(editor) {
+				saved = "proposed"
+				_, err := s.proposeRevision(tx, isbn, book, editor, time.Now())
+				return err
+			}
+			if err := checkBookLock(tx, isbn, editor.ID, time.Now()); err != nil {
+				return err
+			}
+			_, err := s.updateBook(tx, isbn, book)
+			return err
+		})
+	}
+	if err != nil {
+		code, msg := http.StatusInternalServerError, "Failed to store the book"
+		var se *statusError
+		var le *lockedError
+		switch {
+		case errors.As(err, &se):
+			code, msg = se.code, se.msg
+		case errors.As(err, &le):
+			code, msg = http.StatusLocked, le.Error()
+		}
+		renderAdminPage(w, code, "book", adminBookPage{Book: book, Error: msg, CSRFToken: s.csrfToken(w, r)})
+		return
+	}
+	http.Redirect(w, r, "/admin/books/"+isbn+"?"+saved+"=true", http.StatusSeeOther)
+}
+
+// AdminImport is the admin page which imports books from a file. The page
+// previews the import through the API, where the columns of a workbook can
+// be mapped to other fields before the books are imported.
+func (s *Server) AdminImport(w http.ResponseWriter, r *http.Request) {
+	renderAdminPage(w, http.StatusOK, "import", struct {
+		Fields []string
+	}{importFields})
+}
@@ -0,0 +1,141 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminUI(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	for isbn, title := range map[string]string{
+		"1233211233215": "a new hope",
+		"1233211233213": "the empire strikes back",
+	} {
+		jsonBytes, _ := json.Marshal(Book{
+			ISBN:      isbn,
+			Title:     title,
+			Author:    &Author{FirstName: "george", LastName: "lucas"},
+			Publisher: "adlibris",
+		})
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code)
+	}
+	librarian := createMemberSession(t, db, "astrid", RoleLibrarian)
+	patron := createMemberSession(t, db, "emil")
+
+	t.Run("Lets only the staff use the admin UI", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/admin/books?q=EMPIRE", nil, db)
+		require.Equal(t, http.StatusSeeOther, response.Code)
+		require.Equal(t, "/admin/login?next=%2Fadmin%2Fbooks%3Fq%3DEMPIRE", response.Header().Get("Location"))
+		require.NotContains(t, response.Body.String(), "the empire strikes back")
+		for _, path := range []string{"/admin/books/1233211233215", "/admin/import"} {
+			require.Equal(t, http.StatusSeeOther, createNewRequest(http.MethodGet, path, nil, db).Code, path)
+		}
+		require.Equal(t, http.StatusSeeOther, createNewRequest(http.MethodPost, "/admin/books/1233211233215", nil, db).Code)
+
+		response = createNewMemberRequest(http.MethodGet, "/admin/books", nil, db, patron)
+		require.Equal(t, http.StatusForbidden, response.Code)
+		require.NotContains(t, response.Body.String(), "a new hope")
+	})
+
+	t.Run("Shows the login page", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/admin/login?next=%2Fadmin%2Fimport", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Contains(t, response.Body.String(), `data-next="/admin/import"`)
+
+		response = createNewRequest(http.MethodGet, "/admin/login?next=https%3A%2F%2Fexample.com", nil, db)
+		require.Contains(t, response.Body.String(), `data-next="/admin/books"`, "the login only goes to the admin UI")
+	})
+
+	t.Run("Lists and searches the books", func(t *testing.T) {
+		response := createNewMemberRequest(http.MethodGet, "/admin/books?q=EMPIRE", nil, db, librarian)
+		require.Equal(t, http.StatusOK, response.Code)
+		assertContentType(t, response, "text/html; charset=utf-8", "Should get an html page")
+		require.Contains(t, response.Body.String(), "the empire strikes back")
+		require.NotContains(t, response.Body.String(), "a new hope")
+	})
+
+	t.Run("Shows the edit form", func(t *testing.T) {
+		response := createNewMemberRequest(http.MethodGet, "/admin/books/1233211233215", nil, db, librarian)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Contains(t, response.Body.String(), `name="title" value="a new hope"`)
+
+		response = createNewMemberRequest(http.MethodGet, "/admin/books/1233211233210", nil, db, librarian)
+		require.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	server := NewServer(db)
+	// The CSRF cookie of the browser, set when the form is shown
+	form := httptest.NewRecorder()
+	formRequest := httptest.NewRequest(http.MethodGet, "/admin/books/1233211233215", nil)
+	formRequest.Header.Set("Authorization", "Bearer "+librarian)
+	server.ServeHTTP(form, formRequest)
+	require.Len(t, form.Result().Cookies(), 1)
+	csrf := form.Result().Cookies()[0]
+	require.Contains(t, form.Body.String(), `name="csrf_token" value="`+csrf.Value+`"`)
+
+	postForm := func(isbn string, form url.Values) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodPost, "/admin/books/"+isbn,
+			bytes.NewReader([]byte(form.Encode())))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "Bearer "+librarian)
+		request.AddCookie(csrf)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("Rejects forms without the CSRF token", func(t *testing.T) {
+		response := postForm("1233211233215", url.Values{"title": {"a new hope"},
+			"firstName": {"george"}, "lastName": {"lucas"}, "publisher": {"lucasfilm"}})
+		require.Equal(t, http.StatusForbidden, response.Code)
+		response = postForm("1233211233215", url.Values{"title": {"a new hope"}, csrfField: {"forged"},
+			"firstName": {"george"}, "lastName": {"lucas"}, "publisher": {"lucasfilm"}})
+		require.Equal(t, http.StatusForbidden, response.Code)
+		require.Equal(t, "adlibris", FindSpecificBook(db, "1233211233215").Publisher)
+	})
+
+	t.Run("Reports validation errors on the form", func(t *testing.T) {
+		response := postForm("1233211233215", url.Values{"title": {"a new hope"}, csrfField: {csrf.Value},
+			"firstName": {"george"}, "lastName": {"lucas"}, "publisher": {"not-a-publisher!"}})
+		require.Equal(t, http.StatusNotAcceptable, response.Code)
+		require.Contains(t, response.Body.String(), `class="error"`)
+		require.Equal(t, "adlibris", FindSpecificBook(db, "1233211233215").Publisher)
+	})
+
+	t.Run("Saves the form", func(t *testing.T) {
+		response := postForm("1233211233215", url.Values{"title": {"a new hope"}, csrfField: {csrf.Value},
+			"firstName": {"george"}, "lastName": {"lucas"}, "publisher": {"lucasfilm"}})
+		require.Equal(t, http.StatusSeeOther, response.Code)
+		require.Equal(t, "/admin/books/1233211233215?saved=true", response.Header().Get("Location"))
+		require.Equal(t, "lucasfilm", FindSpecificBook(db, "1233211233215").Publisher)
+	})
+
+	t.Run("Serves the assets with content-hashed URLs", func(t *testing.T) {
+		response := createNewMemberRequest(http.MethodGet, "/admin/books", nil, db, librarian)
+		href := assetURL("admin.css")
+		require.Regexp(t, `^/admin/assets/admin\.[0-9a-f]{12}\.css$`, href)
+		require.Contains(t, response.Body.String(), href)
+
+		response = createNewRequest(http.MethodGet, href, nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "public, max-age=31536000, immutable", response.Header().Get("Cache-Control"))
+		require.Contains(t, response.Header().Get("Content-Type"), "text/css")
+		etag := response.Header().Get("ETag")
+
+		request, _ := http.NewRequest(http.MethodGet, "/admin/assets/admin.css", nil)
+		request.Header.Set("If-None-Match", etag)
+		response = httptest.NewRecorder()
+		NewServer(db).ServeHTTP(response, request)
+		require.Equal(t, http.StatusNotModified, response.Code)
+		require.Equal(t, "no-cache", response.Header().Get("Cache-Control"))
+	})
+}
@@ -0,0 +1,198 @@
+// Package alerts notifies the operators when something needs attention, e.g.
+// a failed migration. Alerts are fired at hooks such as a Slack channel or
+// PagerDuty.
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// The conditions which fire alerts.
+const (
+	ConditionMigrationFailure = "migration-failure"
+	ConditionJobFailure       = "job-failure"
+	ConditionReplicaLag       = "replica-lag"
+	ConditionBackupStale      = "backup-stale"
+)
+
+// The severities of alerts.
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+)
+
+// Alert describes a condition which needs attention.
+type Alert struct {
+	Condition string    `json:"condition"`
+	Severity  string    `json:"severity"`
+	Summary   string    `json:"summary"`
+	Details   string    `json:"details,omitempty"`
+	Source    string    `json:"source"` // The host which fired the alert
+	Time      time.Time `json:"time"`
+}
+
+// Hook delivers alerts, e.g. to a chat channel or a paging service.
+type Hook interface {
+	Fire(ctx context.Context, a Alert) error
+}
+
+// Alerter fires alerts at its hooks. A nil Alerter discards every alert.
+type Alerter struct {
+	hooks      []Hook
+	conditions map[string]bool
+}
+
+// Option configures an Alerter.
+type Option func(*Alerter)
+
+// WithHook adds a hook which every alert is fired at.
+func WithHook(h Hook) Option {
+	return func(a *Alerter) {
+		a.hooks = append(a.hooks, h)
+	}
+}
+
+// WithConditions limits the alerts to the given conditions. By default every
+// condition fires alerts.
+func WithConditions(conditions ...string) Option {
+	return func(a *Alerter) {
+		a.conditions = make(map[string]bool)
+		for _, c := range conditions {
+			a.conditions[c] = true
+		}
+	}
+}
+
+// New creates an Alerter.
+func New(opts ...Option) *Alerter {
+	a := &Alerter{}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Fire fires the alert at every hook. A failing hook does not stop the alert
+// from being fired at the other hooks, the errors of all hooks are returned.
+func (a *Alerter) Fire(ctx context.Context, alert Alert) error {
+	if a == nil || (a.conditions != nil && !a.conditions[alert.Condition]) {
+		return nil
+	}
+	if alert.Time.IsZero() {
+		alert.Time = time.Now()
+	}
+	if alert.Source == "" {
+		alert.Source, _ = os.Hostname()
+	}
+	if alert.Severity == "" {
+		alert.Severity = SeverityError
+	}
+	var errs []string
+	for _, h := range a.hooks {
+		if err := h.Fire(ctx, alert); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New("fire alert err, " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// httpClient is used by the hooks which post to HTTP endpoints.
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func postJSON(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// WebhookHook posts the alerts as JSON to a URL.
+type WebhookHook struct {
+	URL string
+}
+
+// Fire implements Hook.
+func (h WebhookHook) Fire(ctx context.Context, a Alert) error {
+	if err := postJSON(ctx, h.URL, a); err != nil {
+		return fmt.Errorf("webhook err, %w", err)
+	}
+	return nil
+}
+
+// SlackHook posts the alerts to a Slack channel through an incoming webhook.
+type SlackHook struct {
+	WebhookURL string
+}
+
+// Fire implements Hook.
+func (h SlackHook) Fire(ctx context.Context, a Alert) error {
+	text := fmt.Sprintf("*[%s] %s* on %s: %s", strings.ToUpper(a.Severity), a.Condition, a.Source, a.Summary)
+	if a.Details != "" {
+		text += "\n```" + a.Details + "```"
+	}
+	if err := postJSON(ctx, h.WebhookURL, map[string]string{"text": text}); err != nil {
+		return fmt.Errorf("slack err, %w", err)
+	}
+	return nil
+}
+
+// pagerDutyEventsURL is the endpoint of the PagerDuty Events API v2.
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyHook triggers PagerDuty incidents through the Events API v2.
+type PagerDutyHook struct {
+	RoutingKey string // The integration key of the service
+	URL        string // Defaults to the Events API v2 endpoint
+}
+
+// Fire implements Hook. Alerts of the same condition from the same source
+// are deduplicated into one incident.
+func (h PagerDutyHook) Fire(ctx context.Context, a Alert) error {
+	url := h.URL
+	if url == "" {
+		url = pagerDutyEventsURL
+	}
+	event := map[string]interface{}{
+		"routing_key":  h.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    a.Source + "/" + a.Condition,
+		"payload": map[string]interface{}{
+			"summary":        a.Summary,
+			"source":         a.Source,
+			"severity":       a.Severity,
+			"timestamp":      a.Time.Format(time.RFC3339),
+			"class":          a.Condition,
+			"custom_details": map[string]string{"details": a.Details},
+		},
+	}
+	if err := postJSON(ctx, url, event); err != nil {
+		return fmt.Errorf("pagerduty err, %w", err)
+	}
+	return nil
+}
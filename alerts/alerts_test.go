@@ -0,0 +1,73 @@
+package alerts_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/alerts"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlerter(t *testing.T) {
+	var received []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received = append(received, body)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	alert := alerts.Alert{
+		Condition: alerts.ConditionMigrationFailure,
+		Severity:  alerts.SeverityCritical,
+		Summary:   "Database migration failed",
+		Source:    "library-1",
+	}
+
+	t.Run("Fires at every hook", func(t *testing.T) {
+		received = nil
+		a := alerts.New(
+			alerts.WithHook(alerts.WebhookHook{URL: srv.URL + "/webhook"}),
+			alerts.WithHook(alerts.SlackHook{WebhookURL: srv.URL + "/slack"}),
+			alerts.WithHook(alerts.PagerDutyHook{RoutingKey: "key", URL: srv.URL + "/pagerduty"}),
+		)
+		require.NoError(t, a.Fire(context.Background(), alert))
+		require.Len(t, received, 3)
+		require.Equal(t, "migration-failure", received[0]["condition"])
+		require.Equal(t, "*[CRITICAL] migration-failure* on library-1: Database migration failed",
+			received[1]["text"])
+		require.Equal(t, "trigger", received[2]["event_action"])
+		require.Equal(t, "library-1/migration-failure", received[2]["dedup_key"])
+	})
+
+	t.Run("Reports failing hooks after firing at the others", func(t *testing.T) {
+		received = nil
+		a := alerts.New(
+			alerts.WithHook(alerts.WebhookHook{URL: srv.URL + "/broken"}),
+			alerts.WithHook(alerts.WebhookHook{URL: srv.URL + "/webhook"}),
+		)
+		require.Error(t, a.Fire(context.Background(), alert))
+		require.Len(t, received, 2)
+	})
+
+	t.Run("Only fires the configured conditions", func(t *testing.T) {
+		received = nil
+		a := alerts.New(
+			alerts.WithHook(alerts.WebhookHook{URL: srv.URL + "/webhook"}),
+			alerts.WithConditions(alerts.ConditionJobFailure),
+		)
+		require.NoError(t, a.Fire(context.Background(), alert))
+		require.Empty(t, received)
+	})
+
+	t.Run("A nil Alerter discards alerts", func(t *testing.T) {
+		var a *alerts.Alerter
+		require.NoError(t, a.Fire(context.Background(), alert))
+	})
+}
@@ -0,0 +1,112 @@
+// Package antivirus scans uploaded files for malware before they are made
+// available, e.g. with the clamd daemon of ClamAV.
+package antivirus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Result is the verdict of a scan.
+type Result struct {
+	Infected  bool
+	Signature string // The name of the malware, e.g. Eicar-Test-Signature
+}
+
+// Scanner scans the content of files.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// chunkSize is the size of the chunks streamed to clamd.
+const chunkSize = 64 << 10
+
+// Clamd scans with the clamd daemon of ClamAV, which is sent the content
+// with the INSTREAM command. The content must not exceed the StreamMaxLength
+// of clamd.conf, which defaults to 25 MB.
+type Clamd struct {
+	Network string // tcp or unix, defaults to tcp
+	Address string // e.g. localhost:3310 or /run/clamav/clamd.ctl
+}
+
+// Scan streams the content to clamd and reads its verdict.
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	network := c.Network
+	if network == "" {
+		network = "tcp"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, c.Address)
+	if err != nil {
+		return Result{}, fmt.Errorf("connect to clamd err, %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return Result{}, fmt.Errorf("set clamd deadline err, %w", err)
+		}
+	}
+	// Unblock the reads and writes when the context is canceled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return Result{}, fmt.Errorf("write clamd command err, %w", err)
+	}
+	// The content is sent in chunks prefixed by their length, and a chunk
+	// of length zero ends the stream
+	chunk := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, err := conn.Write(chunk[:4+n]); err != nil {
+				return Result{}, fmt.Errorf("write clamd stream err, %w", err)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("read content err, %w", err)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, fmt.Errorf("write clamd stream err, %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && ctx.Err() != nil {
+		return Result{}, fmt.Errorf("read clamd reply err, %w", ctx.Err())
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("read clamd reply err, %w", err)
+	}
+	return parseReply(string(bytes.TrimSuffix(reply, []byte{0})))
+}
+
+// parseReply parses the reply of clamd, e.g. "stream: OK" or
+// "stream: Eicar-Test-Signature FOUND".
+func parseReply(reply string) (Result, error) {
+	verdict := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case verdict == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	}
+	return Result{}, fmt.Errorf("clamd err, %s", reply)
+}
@@ -0,0 +1,84 @@
+package antivirus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// eicar is the EICAR anti-malware test file.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd reads INSTREAM commands and finds the EICAR test file.
+func fakeClamd(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				command := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+					_, _ = io.WriteString(conn, "UNKNOWN COMMAND\x00")
+					return
+				}
+				var content bytes.Buffer
+				for {
+					var size uint32
+					if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					if _, err := io.CopyN(&content, conn, int64(size)); err != nil {
+						return
+					}
+				}
+				if strings.Contains(content.String(), eicar) {
+					_, _ = io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+					return
+				}
+				_, _ = io.WriteString(conn, "stream: OK\x00")
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestClamd(t *testing.T) {
+	ctx := context.Background()
+	scanner := &Clamd{Address: fakeClamd(t)}
+
+	result, err := scanner.Scan(ctx, strings.NewReader("%PDF-1.4 errata"))
+	require.NoError(t, err)
+	require.Equal(t, Result{}, result)
+
+	// The test file is split over several chunks
+	content := strings.Repeat("x", chunkSize-10) + eicar
+	result, err = scanner.Scan(ctx, strings.NewReader(content))
+	require.NoError(t, err)
+	require.Equal(t, Result{Infected: true, Signature: "Eicar-Test-Signature"}, result)
+
+	_, err = (&Clamd{Address: "127.0.0.1:1"}).Scan(ctx, strings.NewReader("x"))
+	require.Error(t, err)
+}
+
+func TestParseReply(t *testing.T) {
+	_, err := parseReply("INSTREAM size limit exceeded. ERROR")
+	require.Error(t, err)
+	result, err := parseReply("stream: Win.Test.EICAR_HDB-1 FOUND")
+	require.NoError(t, err)
+	require.Equal(t, "Win.Test.EICAR_HDB-1", result.Signature)
+}
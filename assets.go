@@ -0,0 +1,93 @@
+package library
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed ui/assets
+var uiAssets embed.FS
+
+// assetsPath is where the assets of the admin UI are served.
+const assetsPath = "/admin/assets/"
+
+// asset is a static file of the admin UI.
+type asset struct {
+	name        string // e.g. admin.css
+	hashedName  string // e.g. admin.3f2a9c1b.css
+	contentType string
+	etag        string
+	body        []byte
+}
+
+// adminAssets are the assets by both their plain and hashed names.
+var adminAssets = loadAssets(uiAssets, "ui/assets")
+
+func loadAssets(fsys fs.FS, dir string) map[string]*asset {
+	assets := make(map[string]*asset)
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	for _, e := range entries {
+		body, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])[:12]
+		ext := path.Ext(e.Name())
+		a := &asset{
+			name:        e.Name(),
+			hashedName:  strings.TrimSuffix(e.Name(), ext) + "." + hash + ext,
+			contentType: mime.TypeByExtension(ext),
+			etag:        `"` + hash + `"`,
+			body:        body,
+		}
+		assets[a.name] = a
+		assets[a.hashedName] = a
+	}
+	return assets
+}
+
+// assetURL returns the content-hashed URL of an asset, which changes when the
+// asset does so that it can be cached forever.
+func assetURL(name string) string {
+	a, ok := adminAssets[name]
+	if !ok {
+		return assetsPath + name
+	}
+	return assetsPath + a.hashedName
+}
+
+// ServeAsset serves an asset of the admin UI. Assets requested by their
+// hashed name are cached forever, by their plain name they are revalidated
+// with the ETag.
+func (s *Server) ServeAsset(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	a, ok := adminAssets[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", a.contentType)
+	w.Header().Set("ETag", a.etag)
+	if name == a.hashedName {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && (match == a.etag || match == "*") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(a.body)
+}
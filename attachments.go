@@ -0,0 +1,293 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NicolaiMordrup/library/antivirus"
+	"github.com/NicolaiMordrup/library/blobs"
+	"github.com/gorilla/mux"
+)
+
+// maxAttachmentBytes limits the size of the files attached to books.
+const maxAttachmentBytes = 20 << 20
+
+// attachmentTypes are the content types of files which can be attached.
+var attachmentTypes = map[string]bool{
+	"application/pdf": true,
+	"text/plain":      true,
+	"text/markdown":   true,
+	"text/csv":        true,
+	"image/png":       true,
+	"image/jpeg":      true,
+}
+
+// Attachment is a supplementary file of a book, e.g. a table of contents or
+// errata. The content is kept in the blob store of the server.
+type Attachment struct {
+	ID          string    `json:"id"`
+	ISBN        string    `json:"isbn"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	CreateTime  time.Time `json:"createTime"`
+}
+
+// WithBlobStore sets where the files attached to books are stored. Without
+// it, no files can be attached.
+func WithBlobStore(store blobs.Store) ServerOption {
+	return func(s *Server) {
+		s.blobStore = store
+	}
+}
+
+// blobKey returns the key of a blob of the server, below the namespace of
+// the server if it has one, e.g. tenants/stockholm/covers/...
+func (s *Server) blobKey(key string) string {
+	if s.namespace == "" {
+		return key
+	}
+	return namespaceDir + "/" + s.namespace + "/" + key
+}
+
+func (s *Server) attachmentKey(a Attachment) string {
+	return s.blobKey("attachments/" + a.ISBN + "/" + a.ID)
+}
+
+func validateAttachmentName(name string) error {
+	if strings.TrimSpace(name) == "" || len(name) > 255 || strings.ContainsAny(name, "/\\\"\r\n") {
+		return fmt.Errorf("validation failed, field error(s):%v. Fix these error before proceeding", " name ")
+	}
+	return nil
+}
+
+// readAttachments reads the attachments matching the where clause ordered
+// by creation.
+func readAttachments(db Querier, where string, args ...interface{}) ([]Attachment, error) {
+	rows, err := db.Query("SELECT id, isbn, name, contentType, size, createTime FROM attachment WHERE "+where+
+		" ORDER BY createTime, id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query attachments err, %w", err)
+	}
+	defer rows.Close()
+	attachments := []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.ISBN, &a.Name, &a.ContentType, &a.Size, &a.CreateTime); err != nil {
+			return nil, fmt.Errorf("scan attachment err, %w", err)
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// FindAttachment reads an attachment of a book. It returns sql.ErrNoRows if
+// there is no such attachment.
+func FindAttachment(db Querier, isbn, id string) (Attachment, error) {
+	attachments, err := readAttachments(db, "isbn = ? AND id = ?", isbn, id)
+	if err != nil {
+		return Attachment{}, err
+	}
+	if len(attachments) == 0 {
+		return Attachment{}, sql.ErrNoRows
+	}
+	return attachments[0], nil
+}
+
+// GetAttachments retrieves the metadata of the attachments of a book.
+func (s *Server) GetAttachments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	isbn := mux.Vars(r)["isbn"]
+	if FindSpecificBook(s.db, isbn).ISBN == "" {
+		HandleErr(w, http.StatusNotFound, "The book did not exist in the library")
+		return
+	}
+	attachments, err := readAttachments(s.db, "isbn = ?", isbn)
+	if err != nil {
+		handleErr("Failed to read the attachments", err)
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the attachments")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(attachments); err != nil {
+		handleErr("Failed to encode attachments", err)
+		return
+	}
+}
+
+// UploadAttachment attaches the body of the request to a book. The body is
+// streamed to the blob store, and the file is named by the name query
+// parameter and typed by the Content-Type of the request. Infected files
+// are rejected if a malware scanner is configured.
+func (s *Server) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if s.blobStore == nil {
+		HandleErr(w, http.StatusConflict, "No blob store is configured")
+		return
+	}
+	a := Attachment{
+		ID:         s.idGenerator.NewID(),
+		ISBN:       mux.Vars(r)["isbn"],
+		Name:       r.URL.Query().Get("name"),
+		CreateTime: time.Now(),
+	}
+	if FindSpecificBook(s.db, a.ISBN).ISBN == "" {
+		HandleErr(w, http.StatusNotFound, "The book did not exist in the library")
+		return
+	}
+	if err := validateAttachmentName(a.Name); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !attachmentTypes[contentType] {
+		HandleErr(w, http.StatusUnsupportedMediaType,
+			"The attachment must be a PDF, a text file, a PNG or a JPEG image")
+		return
+	}
+	a.ContentType = contentType
+
+	a.Size, err = s.blobStore.Put(r.Context(), s.attachmentKey(a), r.Body)
+	if err != nil {
+		handleDecodeErr(w, err, "Failed to store the attachment")
+		return
+	}
+	if s.scanner != nil {
+		// The upload is scanned from the store, since it is not kept in memory
+		result, err := s.scanAttachment(r, a)
+		if err != nil || result.Infected {
+			if err := s.blobStore.Delete(r.Context(), s.attachmentKey(a)); err != nil {
+				handleErr("Failed to delete the blob of the attachment", err)
+			}
+		}
+		if err != nil {
+			handleErr("Failed to scan the attachment", err)
+			HandleErr(w, http.StatusInternalServerError, "Failed to scan the attachment")
+			return
+		}
+		if result.Infected {
+			HandleErr(w, http.StatusUnprocessableEntity, "The attachment is infected with "+result.Signature)
+			return
+		}
+	}
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if err := s.checkStorageQuota(tx, a.Size); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO attachment (id, isbn, name, contentType, size, createTime) VALUES(?,?,?,?,?,?)",
+			a.ID, a.ISBN, a.Name, a.ContentType, a.Size, a.CreateTime)
+		return err
+	})
+	if err != nil {
+		if err := s.blobStore.Delete(r.Context(), s.attachmentKey(a)); err != nil {
+			handleErr("Failed to delete the blob of the attachment", err)
+		}
+		handleMemberErr(w, err, "Failed to store the attachment")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(a); err != nil {
+		handleErr("Failed to encode attachment", err)
+		return
+	}
+}
+
+func (s *Server) scanAttachment(r *http.Request, a Attachment) (antivirus.Result, error) {
+	content, err := s.blobStore.Get(r.Context(), s.attachmentKey(a))
+	if err != nil {
+		return antivirus.Result{}, err
+	}
+	defer content.Close()
+	return s.scanUpload(r, content, QuarantinedUpload{Kind: "attachment",
+		ISBN: a.ISBN, Name: a.Name, ContentType: a.ContentType, Size: a.Size})
+}
+
+// DownloadAttachment writes the content of an attachment, or redirects to a
+// signed URL of it if the blob store hands out URLs.
+func (s *Server) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	a, err := FindAttachment(s.db, vars["isbn"], vars["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The attachment does not exist")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the attachment")
+		return
+	}
+	if s.blobStore == nil {
+		HandleErr(w, http.StatusConflict, "No blob store is configured")
+		return
+	}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})
+	if signer, ok := s.blobStore.(blobs.URLSigner); ok {
+		u, err := signer.SignedURL(s.attachmentKey(a), downloadURLLifetime, a.ContentType, disposition)
+		if err != nil {
+			handleErr("Failed to sign the URL of the attachment", err)
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the attachment")
+			return
+		}
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	content, err := s.blobStore.Get(r.Context(), s.attachmentKey(a))
+	if err != nil {
+		handleErr("Failed to read the blob of the attachment", err)
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the attachment")
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, content); err != nil {
+		handleErr("Failed to write attachment", err)
+		return
+	}
+}
+
+// DeleteAttachment removes an attachment and its content.
+func (s *Server) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	vars := mux.Vars(r)
+	var a Attachment
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		var err error
+		a, err = FindAttachment(tx, vars["isbn"], vars["id"])
+		if errors.Is(err, sql.ErrNoRows) {
+			return &statusError{http.StatusNotFound, "The attachment does not exist"}
+		} else if err != nil {
+			return err
+		}
+		_, err = tx.Exec("DELETE FROM attachment WHERE id = ?", a.ID)
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to delete the attachment")
+		return
+	}
+	// A blob left behind is only wasted space, the attachment is gone
+	if s.blobStore != nil {
+		if err := s.blobStore.Delete(r.Context(), s.attachmentKey(a)); err != nil {
+			handleErr("Failed to delete the blob of the attachment", err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,98 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/NicolaiMordrup/library/blobs"
+	"github.com/stretchr/testify/require"
+)
+
+// signingStore hands out URLs of the blobs like the S3 store.
+type signingStore struct {
+	blobs.Store
+}
+
+func (signingStore) SignedURL(key string, expires time.Duration, contentType, contentDisposition string) (string, error) {
+	return "https://blobs.example.com/" + key + "?type=" + url.QueryEscape(contentType), nil
+}
+
+func TestAttachments(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	store, err := blobs.NewDir(t.TempDir())
+	require.NoError(t, err)
+	server := NewServer(db, WithBlobStore(store))
+
+	serve := func(method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	jsonBytes, _ := json.Marshal(Book{ISBN: "9789129688313", Title: "Pippi Langstrump",
+		Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben"})
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/9789129688313", jsonContentType, jsonBytes).Code)
+
+	const path = "/api/v1/books/9789129688313/attachments"
+	var errata Attachment
+
+	t.Run("Rejects invalid attachments", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/api/v1/books/9780000000000/attachments?name=a.pdf", "application/pdf", []byte("%PDF")).Code)
+		require.Equal(t, http.StatusNotAcceptable, serve(http.MethodPost, path, "application/pdf", []byte("%PDF")).Code)
+		require.Equal(t, http.StatusNotAcceptable, serve(http.MethodPost, path+"?name=../a.pdf", "application/pdf", []byte("%PDF")).Code)
+		require.Equal(t, http.StatusUnsupportedMediaType, serve(http.MethodPost, path+"?name=a.exe", "application/x-msdownload", []byte("MZ")).Code)
+		response := serve(http.MethodPost, path+"?name=big.pdf", "application/pdf", make([]byte, maxAttachmentBytes+1))
+		require.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	})
+
+	t.Run("Uploads an attachment", func(t *testing.T) {
+		response := serve(http.MethodPost, path+"?name=errata.pdf", "application/pdf", []byte("%PDF-1.4 errata"))
+		require.Equal(t, http.StatusCreated, response.Code, response.Body.String())
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&errata))
+		require.Equal(t, "errata.pdf", errata.Name)
+		require.Equal(t, "application/pdf", errata.ContentType)
+		require.Equal(t, int64(15), errata.Size)
+
+		response = serve(http.MethodPost, path+"?name=contents.txt", "text/plain; charset=utf-8", []byte("1. Pippi"))
+		require.Equal(t, http.StatusCreated, response.Code)
+
+		response = serve(http.MethodGet, path, "", nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		var attachments []Attachment
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&attachments))
+		require.Len(t, attachments, 2)
+		require.Equal(t, errata.ID, attachments[0].ID)
+		require.Equal(t, "text/plain", attachments[1].ContentType)
+	})
+
+	t.Run("Downloads an attachment", func(t *testing.T) {
+		response := serve(http.MethodGet, path+"/"+errata.ID, "", nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "application/pdf", response.Header().Get("Content-Type"))
+		require.Contains(t, response.Header().Get("Content-Disposition"), "errata.pdf")
+		require.Equal(t, "%PDF-1.4 errata", response.Body.String())
+		require.Equal(t, http.StatusNotFound, serve(http.MethodGet, path+"/missing", "", nil).Code)
+
+		server.blobStore = signingStore{store}
+		defer func() { server.blobStore = store }()
+		response = serve(http.MethodGet, path+"/"+errata.ID, "", nil)
+		require.Equal(t, http.StatusFound, response.Code, "the content is downloaded from the store")
+		require.Equal(t, "https://blobs.example.com/"+server.attachmentKey(errata)+"?type=application%2Fpdf", response.Header().Get("Location"))
+	})
+
+	t.Run("Deletes an attachment", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, serve(http.MethodDelete, path+"/"+errata.ID, "", nil).Code)
+		require.Equal(t, http.StatusNotFound, serve(http.MethodGet, path+"/"+errata.ID, "", nil).Code)
+		require.Equal(t, http.StatusNotFound, serve(http.MethodDelete, path+"/"+errata.ID, "", nil).Code)
+		_, err := store.Get(context.Background(), server.attachmentKey(errata))
+		require.ErrorIs(t, err, blobs.ErrNotFound, "the content is deleted too")
+	})
+}
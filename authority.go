@@ -0,0 +1,222 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NicolaiMordrup/library/marc"
+)
+
+// The kinds of authority records.
+const (
+	AuthorityName    = "name"
+	AuthoritySubject = "subject"
+)
+
+// Authority is an authorized heading of a name or subject together with its
+// variant forms.
+type Authority struct {
+	ControlNumber string    `json:"controlNumber"`
+	Kind          string    `json:"kind"`
+	Heading       string    `json:"heading"`
+	Variants      []string  `json:"variants,omitempty"`
+	UpdateTime    time.Time `json:"updateTime"`
+}
+
+// AuthorityImport summarizes an import of an authority file.
+type AuthorityImport struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"` // Records which are not name or subject headings
+}
+
+// authorityFields are the heading fields of MARC authority records which are
+// imported, with the kind of authority and the tracing field which holds the
+// variants of the heading.
+var authorityFields = []struct{ tag, kind, tracing string }{
+	{"100", AuthorityName, "400"},    // Personal name
+	{"110", AuthorityName, "410"},    // Corporate name
+	{"150", AuthoritySubject, "450"}, // Topical term
+	{"151", AuthoritySubject, "451"}, // Geographic name
+}
+
+// AuthorityFromMARC maps a MARC 21 authority record to an authority. It
+// returns false if the record is not a name or subject heading.
+func AuthorityFromMARC(rec marc.Record) (Authority, bool) {
+	a := Authority{}
+	for _, f := range rec.ControlFields {
+		if f.Tag == "001" {
+			a.ControlNumber = strings.TrimSpace(f.Value)
+		}
+	}
+	for _, field := range authorityFields {
+		fields := rec.Fields(field.tag)
+		if len(fields) == 0 {
+			continue
+		}
+		a.Kind = field.kind
+		a.Heading = authorityHeading(fields[0])
+		for _, tracing := range rec.Fields(field.tracing) {
+			if variant := authorityHeading(tracing); variant != "" {
+				a.Variants = append(a.Variants, variant)
+			}
+		}
+		break
+	}
+	return a, a.ControlNumber != "" && a.Heading != ""
+}
+
+// authorityHeading formats a heading field. Subject subdivisions are joined
+// with "--", e.g. "Children's stories--History and criticism".
+func authorityHeading(f marc.DataField) string {
+	var heading string
+	for _, sf := range f.Subfields {
+		v := strings.TrimSpace(sf.Value)
+		switch {
+		case v == "":
+		case sf.Code == "v" || sf.Code == "x" || sf.Code == "y" || sf.Code == "z":
+			heading = marc.TrimPunctuation(heading) + "--" + v
+		case sf.Code >= "a" && sf.Code <= "d" || sf.Code == "q":
+			if heading != "" {
+				heading += " "
+			}
+			heading += v
+		}
+	}
+	return marc.TrimPunctuation(heading)
+}
+
+// ImportAuthorities stores the authorities, replacing existing authorities
+// with the same control number.
+func ImportAuthorities(db Querier, authorities []Authority) error {
+	now := time.Now()
+	for _, a := range authorities {
+		if _, err := db.Exec("DELETE FROM authority_variant WHERE controlNumber = ?", a.ControlNumber); err != nil {
+			return fmt.Errorf("delete authority variants err, %w", err)
+		}
+		_, err := db.Exec("INSERT OR REPLACE INTO authority (controlNumber, kind, heading, updateTime) VALUES(?,?,?,?)",
+			a.ControlNumber, a.Kind, a.Heading, now)
+		if err != nil {
+			return fmt.Errorf("insert authority err, %w", err)
+		}
+		for _, variant := range a.Variants {
+			_, err := db.Exec("INSERT INTO authority_variant (controlNumber, variant) VALUES(?,?)",
+				a.ControlNumber, variant)
+			if err != nil {
+				return fmt.Errorf("insert authority variant err, %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// ReadAuthorities reads the authorities of the given kind ordered by heading.
+// An empty kind reads all authorities.
+func ReadAuthorities(db Querier, kind string) ([]Authority, error) {
+	query := "SELECT controlNumber, kind, heading, updateTime FROM authority"
+	var args []interface{}
+	if kind != "" {
+		query += " WHERE kind = ?"
+		args = append(args, kind)
+	}
+	rows, err := db.Query(query+" ORDER BY heading", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query authorities err, %w", err)
+	}
+	defer rows.Close()
+	var authorities []Authority
+	index := make(map[string]int)
+	for rows.Next() {
+		var a Authority
+		if err := rows.Scan(&a.ControlNumber, &a.Kind, &a.Heading, &a.UpdateTime); err != nil {
+			return nil, fmt.Errorf("scan authority err, %w", err)
+		}
+		index[a.ControlNumber] = len(authorities)
+		authorities = append(authorities, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	variants, err := db.Query("SELECT controlNumber, variant FROM authority_variant ORDER BY controlNumber, variant")
+	if err != nil {
+		return nil, fmt.Errorf("query authority variants err, %w", err)
+	}
+	defer variants.Close()
+	for variants.Next() {
+		var controlNumber, variant string
+		if err := variants.Scan(&controlNumber, &variant); err != nil {
+			return nil, fmt.Errorf("scan authority variant err, %w", err)
+		}
+		if i, ok := index[controlNumber]; ok {
+			authorities[i].Variants = append(authorities[i].Variants, variant)
+		}
+	}
+	return authorities, variants.Err()
+}
+
+// ImportAuthorityFile imports the name and subject headings of a MARCXML
+// authority file. Other records in the file are skipped. Only librarians
+// and admins can import authorities, since the import replaces them.
+func (s *Server) ImportAuthorityFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.librarianMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	records, err := marc.ReadRecords(r.Body)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to read the authority file")
+		return
+	}
+	var res AuthorityImport
+	var authorities []Authority
+	for _, rec := range records {
+		a, ok := AuthorityFromMARC(rec)
+		if !ok {
+			res.Skipped++
+			continue
+		}
+		authorities = append(authorities, a)
+	}
+
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		return ImportAuthorities(tx, authorities)
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to import the authorities")
+		return
+	}
+	res.Imported = len(authorities)
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the import result")
+		return
+	}
+}
+
+// ListAuthorities retrieves the authorities, filtered by the kind query
+// parameter if given.
+func (s *Server) ListAuthorities(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != AuthorityName && kind != AuthoritySubject {
+		HandleErr(w, http.StatusBadRequest, "Invalid authority kind, must be one of name or subject")
+		return
+	}
+	authorities, err := ReadAuthorities(s.db, kind)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the authorities")
+		return
+	}
+	if authorities == nil {
+		authorities = []Authority{}
+	}
+	if err := json.NewEncoder(w).Encode(authorities); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the authorities")
+		return
+	}
+}
@@ -0,0 +1,97 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const authorityFile = `<?xml version="1.0" encoding="UTF-8"?>
+<collection xmlns="http://www.loc.gov/MARC21/slim">
+  <record>
+    <leader>00582cz  a2200169n  4500</leader>
+    <controlfield tag="001">n  79021790</controlfield>
+    <datafield tag="100" ind1="1" ind2=" ">
+      <subfield code="a">Lindgren, Astrid,</subfield>
+      <subfield code="d">1907-2002</subfield>
+    </datafield>
+    <datafield tag="400" ind1="1" ind2=" ">
+      <subfield code="a">Ericsson, Astrid Anna Emilia,</subfield>
+      <subfield code="d">1907-2002</subfield>
+    </datafield>
+  </record>
+  <record>
+    <leader>00455cz  a2200145n  4500</leader>
+    <controlfield tag="001">sh 85024013</controlfield>
+    <datafield tag="150" ind1=" " ind2=" ">
+      <subfield code="a">Children's stories</subfield>
+      <subfield code="x">History and criticism</subfield>
+    </datafield>
+    <datafield tag="450" ind1=" " ind2=" ">
+      <subfield code="a">Stories for children</subfield>
+    </datafield>
+  </record>
+  <record>
+    <leader>00300cz  a2200100n  4500</leader>
+    <controlfield tag="001">t1</controlfield>
+    <datafield tag="130" ind1=" " ind2="0">
+      <subfield code="a">Bible.</subfield>
+    </datafield>
+  </record>
+</collection>`
+
+func TestAuthorities(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	librarian := createMemberSession(t, db, "astrid", RoleLibrarian)
+
+	t.Run("Lets only librarians and admins import authorities", func(t *testing.T) {
+		response := createNewRequest(http.MethodPost, "/api/v1/admin/authorities:import",
+			[]byte(authorityFile), db)
+		require.Equal(t, http.StatusUnauthorized, response.Code)
+		member := createMemberSession(t, db, "emil")
+		response = createNewMemberRequest(http.MethodPost, "/api/v1/admin/authorities:import",
+			[]byte(authorityFile), db, member)
+		require.Equal(t, http.StatusForbidden, response.Code)
+	})
+
+	t.Run("Imports name and subject headings", func(t *testing.T) {
+		// Importing twice replaces the authorities
+		for i := 0; i < 2; i++ {
+			response := createNewMemberRequest(http.MethodPost, "/api/v1/admin/authorities:import",
+				[]byte(authorityFile), db, librarian)
+			require.Equal(t, http.StatusOK, response.Code)
+
+			var got AuthorityImport
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+			require.Equal(t, AuthorityImport{Imported: 2, Skipped: 1}, got)
+		}
+
+		authorities, err := ReadAuthorities(db, "")
+		require.NoError(t, err)
+		require.Len(t, authorities, 2)
+		require.Equal(t, "Children's stories--History and criticism", authorities[0].Heading)
+		require.Equal(t, []string{"Stories for children"}, authorities[0].Variants)
+		require.Equal(t, "Lindgren, Astrid, 1907-2002", authorities[1].Heading)
+		require.Equal(t, []string{"Ericsson, Astrid Anna Emilia, 1907-2002"}, authorities[1].Variants)
+	})
+
+	t.Run("Filters the authorities by kind", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/admin/authorities?kind=name", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		var got []Authority
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Len(t, got, 1)
+		require.Equal(t, "n  79021790", got[0].ControlNumber)
+		require.Equal(t, AuthorityName, got[0].Kind)
+	})
+
+	t.Run("Rejects unknown kinds", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/admin/authorities?kind=title", nil, db)
+		assertStatus(t, response.Code, http.StatusBadRequest, "Should get status "+
+			"code 400: status bad request")
+	})
+}
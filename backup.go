@@ -0,0 +1,302 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxRestoreBytes limits the size of uploaded backups, which are larger than
+// the other request bodies.
+const maxRestoreBytes = 1 << 30
+
+// sqliteHeader starts every SQLite database file.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// errSchemaMismatch is returned when a backup was taken with another version
+// of the schema than the running server.
+var errSchemaMismatch = errors.New("the backup has another schema version")
+
+// BackupTo writes a consistent copy of the database to path, which must not
+// exist. Writes are not blocked while the backup is taken.
+func BackupTo(db *sql.DB, path string) error {
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("backup err, %w", err)
+	}
+	return nil
+}
+
+// restoreDB replaces the contents of every table with the contents of the
+// backup at path. The backup must have the same schema version as the
+// database.
+func restoreDB(ctx context.Context, db *sql.DB, path string) error {
+	// Attached databases belong to a connection, so a single one is used
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("restore connection err, %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS backup", path); err != nil {
+		return fmt.Errorf("attach backup err, %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE backup")
+
+	var version int
+	var dirty bool
+	err = conn.QueryRowContext(ctx, "SELECT version, dirty FROM backup.schema_migrations").Scan(&version, &dirty)
+	if err != nil {
+		return fmt.Errorf("read backup schema version err, %w", err)
+	}
+	if version != schemaVersion || dirty {
+		return errSchemaMismatch
+	}
+
+	// The search index is rebuilt rather than copied, its shadow tables can
+	// not be written to directly
+	rows, err := conn.QueryContext(ctx, "SELECT name FROM main.sqlite_master WHERE type = 'table' "+
+		"AND name NOT IN ('schema_migrations', 'sqlite_sequence') AND name NOT LIKE 'sqlite_%' "+
+		"AND name NOT LIKE ?", searchTable+"%")
+	if err != nil {
+		return fmt.Errorf("query tables err, %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan table err, %w", err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin restore err, %w", err)
+	}
+	defer tx.Rollback()
+	for _, table := range tables {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM main.%q", table)); err != nil {
+			return fmt.Errorf("clear %s err, %w", table, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("INSERT INTO main.%q SELECT * FROM backup.%q", table, table)); err != nil {
+			return fmt.Errorf("restore %s err, %w", table, err)
+		}
+	}
+	if err := rebuildSearchIndex(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// backupFileName names backups by the time they were taken, so that they
+// sort chronologically.
+func backupFileName(t time.Time) string {
+	return "library-" + t.UTC().Format("20060102T150405Z") + ".db"
+}
+
+// BackupSchedule takes a backup to Dir every Interval and keeps the Keep
+// latest backups, or all of them if Keep is zero.
+type BackupSchedule struct {
+	Dir      string
+	Interval time.Duration
+	Keep     int
+	// OnError is called when a backup fails, e.g. to alert that the backups
+	// are getting stale.
+	OnError func(error)
+}
+
+// Run takes backups until the context is canceled.
+func (b BackupSchedule) Run(ctx context.Context, db *sql.DB) {
+	ticker := time.NewTicker(b.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := b.backup(db, time.Now()); err != nil {
+			log.Printf("backup: failed to take a backup, %v\n", err)
+			if b.OnError != nil {
+				b.OnError(err)
+			}
+		}
+	}
+}
+
+func (b BackupSchedule) backup(db *sql.DB, now time.Time) error {
+	if err := os.MkdirAll(b.Dir, 0o755); err != nil {
+		return fmt.Errorf("create backup dir err, %w", err)
+	}
+	if err := BackupTo(db, filepath.Join(b.Dir, backupFileName(now))); err != nil {
+		return err
+	}
+	if b.Keep <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(filepath.Join(b.Dir, "library-*.db"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > b.Keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("remove old backup err, %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// tempPath returns a path in the temporary directory which does not exist,
+// as required by VACUUM INTO.
+func tempPath(pattern string) (string, error) {
+	dir, err := ioutil.TempDir("", pattern)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "library.db"), nil
+}
+
+// adminMember returns the member of the session when it is an admin.
+func (s *Server) adminMember(r *http.Request) (Member, error) {
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		return Member{}, &statusError{http.StatusUnauthorized, "The request is not logged in"}
+	} else if err != nil {
+		return Member{}, err
+	}
+	if !hasRole(m, RoleAdmin) {
+		return Member{}, &statusError{http.StatusForbidden, "Only admins can do this"}
+	}
+	return m, nil
+}
+
+// librarianMember returns the member of the session when it is a librarian
+// or an admin, who may see the books which are not public.
+func (s *Server) librarianMember(r *http.Request) (Member, error) {
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		return Member{}, &statusError{http.StatusUnauthorized, "The request is not logged in"}
+	} else if err != nil {
+		return Member{}, err
+	}
+	if !hasRole(m, RoleLibrarian) && !hasRole(m, RoleAdmin) {
+		return Member{}, &statusError{http.StatusForbidden, "Only librarians and admins can do this"}
+	}
+	return m, nil
+}
+
+// Backup downloads a consistent copy of the database to an admin. The
+// backup is streamed from a temporary file, it is not buffered by the
+// timeout.
+func (s *Server) Backup(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	path, err := tempPath("backup")
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to take the backup")
+		return
+	}
+	defer os.RemoveAll(filepath.Dir(path))
+	if err := BackupTo(s.db, path); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to take the backup")
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to take the backup")
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", backupFileName(time.Now())))
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("Failed to send the backup, %v \n", err)
+	}
+}
+
+// Restore replaces the contents of the database with a backup uploaded by
+// an admin. The backup must have been taken with the same schema version.
+func (s *Server) Restore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	path, err := tempPath("restore")
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to restore the backup")
+		return
+	}
+	defer os.RemoveAll(filepath.Dir(path))
+
+	f, err := os.Create(path)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to restore the backup")
+		return
+	}
+	_, err = io.Copy(f, r.Body)
+	f.Close()
+	if err != nil {
+		handleDecodeErr(w, err, "Failed to read the backup")
+		return
+	}
+	header := make([]byte, len(sqliteHeader))
+	if f, err := os.Open(path); err == nil {
+		io.ReadFull(f, header)
+		f.Close()
+	}
+	if !bytes.Equal(header, sqliteHeader) {
+		HandleErr(w, http.StatusBadRequest, "The backup is not an SQLite database")
+		return
+	}
+
+	if err := s.lockWrites(r.Context()); err != nil {
+		HandleErr(w, http.StatusServiceUnavailable, "Failed to restore the backup")
+		return
+	}
+	err = restoreDB(r.Context(), s.db, path)
+	s.unlockWrites()
+	s.suggestions.invalidate()
+	if errors.Is(err, errSchemaMismatch) {
+		HandleErr(w, http.StatusConflict, fmt.Sprintf("The backup must have schema version %d", schemaVersion))
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to restore the backup")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bodyLimit returns the size limit of request bodies of the route.
+func (s *Server) bodyLimit(path string) int64 {
+	if strings.HasSuffix(path, "/admin/restore") {
+		return maxRestoreBytes
+	}
+	if strings.HasSuffix(path, "/ebook/file") {
+		return maxEbookBytes
+	}
+	if strings.HasSuffix(path, "/attachments") {
+		return maxAttachmentBytes
+	}
+	if strings.HasSuffix(path, "/cover") {
+		return maxCoverBytes
+	}
+	return s.maxBodyBytes
+}
@@ -0,0 +1,91 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	isbn := "1233211233215"
+	jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: "a new hope",
+		Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "adlibris"})
+	require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+
+	server := NewServer(db)
+	serve := func(path, token string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	session := func(id string, roles ...string) string {
+		t.Helper()
+		m := Member{ID: id, Email: id + "@example.com", FirstName: id, LastName: "Admin", EmailVerified: true, CreateTime: time.Now()}
+		require.NoError(t, server.InsertMember(db, m, ""))
+		require.NoError(t, setRoles(db, id, roles))
+		s, err := server.startSession(db, m)
+		require.NoError(t, err)
+		return s.Token
+	}
+	admin, librarian := session("admin", RoleAdmin), session("astrid", RoleLibrarian)
+
+	t.Run("Only lets admins take and restore backups", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, serve("/api/v1/admin/backup", "", nil).Code)
+		require.Equal(t, http.StatusForbidden, serve("/api/v1/admin/backup", librarian, nil).Code)
+		require.Equal(t, http.StatusUnauthorized, serve("/api/v1/admin/restore", "", []byte("not a database")).Code)
+		require.Equal(t, http.StatusForbidden, serve("/api/v1/admin/restore", librarian, []byte("not a database")).Code)
+	})
+
+	var backup []byte
+	t.Run("Downloads a backup", func(t *testing.T) {
+		response := serve("/api/v1/admin/backup", admin, nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "application/vnd.sqlite3", response.Header().Get("Content-Type"))
+		backup = response.Body.Bytes()
+		require.True(t, bytes.HasPrefix(backup, sqliteHeader))
+	})
+
+	t.Run("Restores the backup", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodDelete, "/api/v1/books/"+isbn, nil, db).Code)
+		require.Empty(t, FindSpecificBook(db, isbn).ISBN)
+
+		response := serve("/api/v1/admin/restore", admin, backup)
+		require.Equal(t, http.StatusNoContent, response.Code, response.Body.String())
+		require.Equal(t, "a new hope", FindSpecificBook(db, isbn).Title)
+		tombstones, err := ReadTombstones(db)
+		require.NoError(t, err)
+		require.Empty(t, tombstones)
+	})
+
+	t.Run("Rejects files which are not backups", func(t *testing.T) {
+		response := serve("/api/v1/admin/restore", admin, []byte("not a database"))
+		assertStatus(t, response.Code, http.StatusBadRequest, "Should get status "+
+			"code 400: status bad request")
+	})
+
+	t.Run("Keeps the latest scheduled backups", func(t *testing.T) {
+		schedule := BackupSchedule{Dir: filepath.Join(t.TempDir(), "backups"), Keep: 2}
+		start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < 3; i++ {
+			require.NoError(t, schedule.backup(db, start.Add(time.Duration(i)*time.Hour)))
+		}
+		entries, err := os.ReadDir(schedule.Dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		require.Equal(t, backupFileName(start.Add(time.Hour)), entries[0].Name())
+	})
+}
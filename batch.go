@@ -0,0 +1,158 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// The maximum number of operations in one batch request.
+const maxBatchOperations = 1000
+
+// BatchOperation is one write in a batch request.
+type BatchOperation struct {
+	Method string `json:"method"` // One of "create", "update" or "delete"
+	ISBN   string `json:"isbn"`
+	Book   *Book  `json:"book,omitempty"`  // Not used by deletes
+	Force  bool   `json:"force,omitempty"` // Create books which resemble existing books
+}
+
+// BatchResult is the outcome of one operation in a batch request.
+type BatchResult struct {
+	ISBN   string `json:"isbn"`
+	Status int    `json:"status"` // The status the operation would get as a single request
+	Error  string `json:"error,omitempty"`
+	Book   *Book  `json:"book,omitempty"`
+	// Candidates are the existing books which a created book resembles
+	Candidates []Book `json:"candidates,omitempty"`
+}
+
+// errBatchFailed rolls back a batch in which an operation failed.
+var errBatchFailed = errors.New("batch operation failed")
+
+// BatchBooks executes a list of create, update and delete operations in a
+// single transaction. If any operation fails the transaction is rolled back,
+// the failed operations keep their own status and the others get status 424:
+// failed dependency. It writes the per-operation results to the stream. A
+// successful batch can be undone through the operation in the X-Operation-ID
+// header. Trainees can not edit books in batches.
+func (s *Server) BatchBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var ops []BatchOperation
+	if err := decodeJSON(r, &ops); err != nil {
+		handleDecodeErr(w, err, "Failed to decode batch operations")
+		return
+	}
+	if len(ops) > maxBatchOperations {
+		HandleErr(w, http.StatusRequestEntityTooLarge, "Too many operations in batch")
+		return
+	}
+
+	editor, err := s.editor(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if isTrainee(editor) {
+		HandleErr(w, errTraineeEdit.code, errTraineeEdit.msg)
+		return
+	}
+	results := make([]BatchResult, len(ops))
+	var operationID string
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		failed := false
+		j := &journal{q: tx}
+		for i, op := range ops {
+			before := FindSpecificBook(tx, op.ISBN)
+			results[i] = s.executeBatchOperation(tx, op, editor)
+			if results[i].Status != http.StatusOK {
+				failed = true
+				continue
+			}
+			j.record(op.ISBN, before)
+		}
+		if failed {
+			return errBatchFailed
+		}
+		var err error
+		operationID, err = s.journalOperation(tx, OperationBatch, j)
+		return err
+	})
+
+	status := http.StatusOK
+	if errors.Is(err, errBatchFailed) {
+		status = http.StatusUnprocessableEntity
+		for i := range results {
+			if results[i].Status == http.StatusOK {
+				results[i].Status = http.StatusFailedDependency
+				results[i].Book = nil
+			}
+		}
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	if operationID != "" {
+		w.Header().Set(operationIDHeader, operationID)
+	}
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the batch results")
+		return
+	}
+}
+
+// executeBatchOperation executes a single operation of a batch. Operations
+// on books which are locked by another member than the editor fail.
+func (s *Server) executeBatchOperation(q Querier, op BatchOperation, editor Member) BatchResult {
+	res := BatchResult{ISBN: op.ISBN, Status: http.StatusOK}
+	var book Book
+	if op.Book != nil {
+		book = *op.Book
+	}
+
+	var err error
+	switch op.Method {
+	case "create":
+		if book.ISBN != op.ISBN {
+			err = &statusError{http.StatusForbidden, "The ISBN of the book does not match the operation"}
+			break
+		}
+		if err = checkBookLock(q, op.ISBN, editor.ID, time.Now()); err != nil {
+			break
+		}
+		book, err = s.createBook(q, book, op.Force)
+	case "update":
+		if err = checkBookLock(q, op.ISBN, editor.ID, time.Now()); err != nil {
+			break
+		}
+		book, err = s.updateBook(q, op.ISBN, book)
+	case "delete":
+		if err = checkBookLock(q, op.ISBN, editor.ID, time.Now()); err != nil {
+			break
+		}
+		_, err = s.deleteBook(q, op.ISBN)
+	default:
+		err = &statusError{http.StatusBadRequest, "Unknown batch method, must be one of create, update or delete"}
+	}
+
+	var se *statusError
+	var de *duplicateError
+	var le *lockedError
+	switch {
+	case errors.As(err, &de):
+		res.Status, res.Error, res.Candidates = http.StatusConflict, de.Error(), de.candidates
+	case errors.As(err, &le):
+		res.Status, res.Error = http.StatusLocked, le.Error()
+	case errors.As(err, &se):
+		res.Status, res.Error = se.code, se.msg
+	case err != nil:
+		res.Status, res.Error = http.StatusInternalServerError, "Failed to store the book"
+	case op.Method != "delete":
+		res.Book = &book
+	}
+	return res
+}
@@ -0,0 +1,64 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchBooks(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	newBook := func(isbn, title string) *Book {
+		return &Book{
+			ISBN:      isbn,
+			Title:     title,
+			Author:    &Author{FirstName: "george", LastName: "lucas"},
+			Publisher: "adlibris",
+		}
+	}
+	doBatch := func(t *testing.T, ops []BatchOperation) (int, []BatchResult) {
+		t.Helper()
+		jsonBytes, err := json.Marshal(ops)
+		require.NoError(t, err)
+		response := createNewRequest(http.MethodPost, "/api/v1/books:batch",
+			jsonBytes, db)
+		var results []BatchResult
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&results))
+		return response.Code, results
+	}
+
+	t.Run("Executes all operations", func(t *testing.T) {
+		code, results := doBatch(t, []BatchOperation{
+			{Method: "create", ISBN: "1233211233215", Book: newBook("1233211233215", "star wars")},
+			{Method: "create", ISBN: "1233211233213", Book: newBook("1233211233213", "revenge of the sith")},
+			{Method: "delete", ISBN: "1233211233213"},
+		})
+
+		assertStatus(t, code, http.StatusOK, "Should get status code 200: status OK")
+		require.Len(t, results, 3)
+		for _, res := range results {
+			require.Equal(t, http.StatusOK, res.Status)
+		}
+		require.Len(t, ReadDatabaseList(db), 1)
+	})
+
+	t.Run("Rolls back every operation when one fails", func(t *testing.T) {
+		code, results := doBatch(t, []BatchOperation{
+			{Method: "create", ISBN: "1233211233210", Book: newBook("1233211233210", "phantom menace")},
+			{Method: "delete", ISBN: "1233211233215"},
+			{Method: "delete", ISBN: "1233211233219"},
+		})
+
+		assertStatus(t, code, http.StatusUnprocessableEntity, "Should get status "+
+			"code 422: status unprocessable entity")
+		require.Equal(t, http.StatusFailedDependency, results[0].Status)
+		require.Equal(t, http.StatusFailedDependency, results[1].Status)
+		require.Equal(t, http.StatusNotFound, results[2].Status)
+		assertDeletedBook(t, "1233211233210", db, "The create should be rolled back")
+		require.Len(t, ReadDatabaseList(db), 1)
+	})
+}
@@ -0,0 +1,130 @@
+// Package blobs stores files, e.g. the attachments of books, by key. The
+// metadata of the files is kept in the database, while their content is in
+// a blob store.
+package blobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// ErrNotFound is returned when there is no blob with the key.
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps the content of blobs. The keys are slash-separated paths,
+// e.g. attachments/9789129688313/01f1.
+type Store interface {
+	// Put stores the content of r under the key, replacing any existing
+	// blob once the content is read to the end. It returns the size of the
+	// blob.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Get opens the blob of the key, or returns ErrNotFound.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob of the key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+// URLSigner is implemented by stores which can hand out time-limited URLs
+// of blobs, so that they are downloaded from the store directly instead of
+// through the server.
+type URLSigner interface {
+	// SignedURL returns a URL of the blob which expires after the duration.
+	// The content type and disposition are those of the response.
+	SignedURL(key string, expires time.Duration, contentType, contentDisposition string) (string, error)
+}
+
+var keyPattern = regexp.MustCompile(`^[a-zA-Z0-9_\-]+(?:\.[a-zA-Z0-9_\-]+)*(?:/[a-zA-Z0-9_\-]+(?:\.[a-zA-Z0-9_\-]+)*)*$`)
+
+// ValidKey reports whether the key can be stored, i.e. that it is a
+// relative path of letters, digits, dashes, underscores and dots which does
+// not step out of the store.
+func ValidKey(key string) bool {
+	return keyPattern.MatchString(key)
+}
+
+// Dir stores the blobs as files below a directory.
+type Dir struct {
+	path string
+}
+
+// NewDir creates a store in the directory, which is created if it does not
+// exist.
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0o750); err != nil {
+		return nil, fmt.Errorf("create blob dir err, %w", err)
+	}
+	return &Dir{path: path}, nil
+}
+
+func (d *Dir) file(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(d.path, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file which is renamed when the content
+// is complete, so that a failed upload does not replace the blob.
+func (d *Dir) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	path, err := d.file(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return 0, fmt.Errorf("put blob err, %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.upload")
+	if err != nil {
+		return 0, fmt.Errorf("put blob err, %w", err)
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("put blob err, %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return n, fmt.Errorf("put blob err, %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return n, fmt.Errorf("put blob err, %w", err)
+	}
+	return n, nil
+}
+
+// Get opens the file of the blob.
+func (d *Dir) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := d.file(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get blob err, %w", err)
+	}
+	return f, nil
+}
+
+// Delete removes the file of the blob.
+func (d *Dir) Delete(ctx context.Context, key string) error {
+	path, err := d.file(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete blob err, %w", err)
+	}
+	return nil
+}
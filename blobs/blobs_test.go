@@ -0,0 +1,44 @@
+package blobs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestDir(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDir(t.TempDir())
+	require.NoError(t, err)
+
+	n, err := store.Put(ctx, "attachments/9789129688313/errata.pdf", strings.NewReader("errata"))
+	require.NoError(t, err)
+	require.Equal(t, int64(6), n)
+
+	_, err = store.Put(ctx, "attachments/9789129688313/errata.pdf", io.MultiReader(strings.NewReader("partial"), failingReader{}))
+	require.Error(t, err)
+	r, err := store.Get(ctx, "attachments/9789129688313/errata.pdf")
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, "errata", string(b), "a failed upload keeps the blob")
+
+	require.NoError(t, store.Delete(ctx, "attachments/9789129688313/errata.pdf"))
+	require.NoError(t, store.Delete(ctx, "attachments/9789129688313/errata.pdf"))
+	_, err = store.Get(ctx, "attachments/9789129688313/errata.pdf")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	for _, key := range []string{"", "../secret", "a/../../b", "/etc/passwd", "a//b", "a/.hidden"} {
+		_, err := store.Put(ctx, key, strings.NewReader("x"))
+		require.Error(t, err, key)
+	}
+}
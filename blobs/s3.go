@@ -0,0 +1,389 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPartSize = 8 << 20
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// now is replaced by the tests to sign with fixed times.
+var now = time.Now
+
+// S3 stores the blobs in a bucket of S3 or of an S3-compatible service, e.g.
+// MinIO. The requests are signed with AWS Signature Version 4, and the
+// bucket is addressed by path, e.g. http://minio:9000/bucket/key, which
+// both S3 and MinIO support.
+type S3 struct {
+	Endpoint        string // e.g. https://s3.eu-north-1.amazonaws.com or http://minio:9000
+	Region          string // Defaults to us-east-1
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Encryption is the server-side encryption of the objects, AES256 or
+	// aws:kms, or "" for the default encryption of the bucket
+	Encryption string
+	KMSKeyID   string       // The key of aws:kms encryption, "" for the AWS managed key
+	PartSize   int64        // Larger blobs are uploaded in parts of this size, defaults to 8 MiB
+	HTTPClient *http.Client // Defaults to http.DefaultClient
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+	return s.Region
+}
+
+// objectURL returns the URL of the object of a key, with the query
+// encoded as it is signed.
+func (s *S3) objectURL(key string, query url.Values) (*url.URL, error) {
+	if !ValidKey(key) {
+		return nil, fmt.Errorf("invalid blob key %q", key)
+	}
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + key)
+	if err != nil {
+		return nil, fmt.Errorf("parse s3 endpoint err, %w", err)
+	}
+	u.RawQuery = canonicalQuery(query)
+	return u, nil
+}
+
+// encryptionHeader returns the headers which encrypt new objects.
+func (s *S3) encryptionHeader() http.Header {
+	h := http.Header{}
+	if s.Encryption != "" {
+		h.Set("X-Amz-Server-Side-Encryption", s.Encryption)
+	}
+	if s.KMSKeyID != "" {
+		h.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.KMSKeyID)
+	}
+	return h
+}
+
+// do sends a signed request and returns the response unless its status is
+// an error. A missing object is ErrNotFound.
+func (s *S3) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create s3 request err, %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]), now())
+	httpClient := s.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 request err, %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode == http.StatusNotFound && bytes.Contains(msg, []byte("NoSuchKey")) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("s3 request err, unexpected status %s: %s", resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// Put uploads blobs up to the part size in a single request, and larger
+// blobs in a multipart upload, so that at most one part is held in memory.
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		u, err := s.objectURL(key, nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := s.do(ctx, http.MethodPut, u, s.encryptionHeader(), buf[:n])
+		if err != nil {
+			return int64(n), fmt.Errorf("put blob err, %w", err)
+		}
+		resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return int64(n), fmt.Errorf("put blob err, %w", err)
+	}
+	return s.putMultipart(ctx, key, buf, r)
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// putMultipart uploads the full first part in buf and the rest of r in a
+// multipart upload, which is aborted if any part fails.
+func (s *S3) putMultipart(ctx context.Context, key string, buf []byte, r io.Reader) (int64, error) {
+	u, err := s.objectURL(key, url.Values{"uploads": {""}})
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.do(ctx, http.MethodPost, u, s.encryptionHeader(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("create multipart upload err, %w", err)
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return 0, fmt.Errorf("decode multipart upload err, %w", err)
+	}
+
+	size, err := s.uploadParts(ctx, key, initiated.UploadID, buf, r)
+	if err != nil {
+		// The parts are kept, and billed, until the upload is aborted, also
+		// when the request is canceled
+		u, _ := s.objectURL(key, url.Values{"uploadId": {initiated.UploadID}})
+		if resp, abortErr := s.do(context.Background(), http.MethodDelete, u, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return size, fmt.Errorf("put blob err, %w", err)
+	}
+	return size, nil
+}
+
+func (s *S3) uploadParts(ctx context.Context, key, uploadID string, buf []byte, r io.Reader) (int64, error) {
+	var complete completeMultipartUpload
+	var size int64
+	part, done := buf, false
+	for len(part) > 0 {
+		partNumber := len(complete.Parts) + 1
+		u, err := s.objectURL(key, url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}})
+		if err != nil {
+			return size, err
+		}
+		resp, err := s.do(ctx, http.MethodPut, u, nil, part)
+		if err != nil {
+			return size, fmt.Errorf("upload part err, %w", err)
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+		size += int64(len(part))
+		if done {
+			break
+		}
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			done = true
+		} else if err != nil {
+			return size, err
+		}
+		part = buf[:n]
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return size, err
+	}
+	u, err := s.objectURL(key, url.Values{"uploadId": {uploadID}})
+	if err != nil {
+		return size, err
+	}
+	resp, err := s.do(ctx, http.MethodPost, u, nil, body)
+	if err != nil {
+		return size, fmt.Errorf("complete multipart upload err, %w", err)
+	}
+	defer resp.Body.Close()
+	// The completion can fail after the status 200 is sent
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return size, fmt.Errorf("decode complete multipart upload err, %w", err)
+	}
+	if result.XMLName.Local == "Error" {
+		return size, fmt.Errorf("complete multipart upload err, %s: %s", result.Code, result.Message)
+	}
+	return size, nil
+}
+
+// Get downloads the object of the blob.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	u, err := s.objectURL(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, u, nil, nil)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("get blob err, %w", err)
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the object of the blob. S3 does not report missing
+// objects.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	u, err := s.objectURL(key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, u, nil, nil)
+	if err != nil {
+		return fmt.Errorf("delete blob err, %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// SignedURL returns a presigned GET URL of the object.
+func (s *S3) SignedURL(key string, expires time.Duration, contentType, contentDisposition string) (string, error) {
+	query := url.Values{}
+	if contentType != "" {
+		query.Set("response-content-type", contentType)
+	}
+	if contentDisposition != "" {
+		query.Set("response-content-disposition", contentDisposition)
+	}
+	u, err := s.objectURL(key, query)
+	if err != nil {
+		return "", err
+	}
+	return s.presign(u, expires, now()), nil
+}
+
+// sign adds the Signature Version 4 authorization of the request, which
+// covers the host and all the headers of the request.
+func (s *S3) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	names := []string{"host"}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		names = append(names, name)
+		headers[name] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	scope, signature := s.signature(t, req.Method, req.URL, canonicalHeaders.String(), signedHeaders, payloadHash)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// presign returns the URL with its Signature Version 4 authorization in
+// the query.
+func (s *S3) presign(u *url.URL, expires time.Duration, t time.Time) string {
+	t = t.UTC()
+	signed := *u
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKeyID+"/"+t.Format("20060102")+"/"+s.region()+"/s3/aws4_request")
+	query.Set("X-Amz-Date", t.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	signed.RawQuery = canonicalQuery(query)
+	_, signature := s.signature(t, http.MethodGet, &signed, "host:"+u.Host+"\n", "host", unsignedPayload)
+	return signed.String() + "&X-Amz-Signature=" + signature
+}
+
+// signature signs the canonical request, and returns the scope of the
+// credential and the signature.
+func (s *S3) signature(t time.Time, method string, u *url.URL, canonicalHeaders, signedHeaders, payloadHash string) (string, string) {
+	date := t.Format("20060102")
+	scope := date + "/" + s.region() + "/s3/aws4_request"
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{method, uriEncode(path, false), canonicalQuery(u.Query()),
+		canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format(amzDateFormat) + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	for _, part := range []string{s.region(), "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes the query sorted by name, with the encoding of
+// Signature Version 4.
+func canonicalQuery(query url.Values) string {
+	encoded := make(map[string][]string, len(query))
+	names := make([]string, 0, len(query))
+	for name, values := range query {
+		name = uriEncode(name, true)
+		names = append(names, name)
+		for _, v := range values {
+			encoded[name] = append(encoded[name], uriEncode(v, true))
+		}
+		sort.Strings(encoded[name])
+	}
+	sort.Strings(names)
+	var params []string
+	for _, name := range names {
+		for _, v := range encoded[name] {
+			params = append(params, name+"="+v)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes all bytes but the unreserved characters of RFC
+// 3986, and the slashes of paths.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -1,28 +1,121 @@
 package library
 
 import (
+	"encoding/xml"
 	"fmt"
 	"regexp"
 	"strings"
 	"time"
+
+	"golang.org/x/text/language"
 )
 
 // Struct for the book properties.
 type Book struct {
-	ISBN       string    `json:"isbn"` // The identification of the books
-	Title      string    `json:"title"`
-	CreateTime time.Time `json:"createTime"` // The time of creation of book instance
-	UpdateTime time.Time `json:"updateTime"` // The time of update for book instance
-	Publisher  string    `json:"publisher"`
+	XMLName    xml.Name  `json:"-" xml:"book" yaml:"-"`
+	ID         string    `json:"id,omitempty" xml:"id,omitempty" yaml:"id,omitempty"` // Internal id, stable across updates
+	ISBN       string    `json:"isbn" xml:"isbn" yaml:"isbn"`                         // The identification of the books
+	Title      string    `json:"title" xml:"title" yaml:"title"`
+	CreateTime time.Time `json:"createTime" xml:"createTime" yaml:"createTime"` // The time of creation of book instance
+	UpdateTime time.Time `json:"updateTime" xml:"updateTime" yaml:"updateTime"` // The time of update for book instance
+	Publisher  string    `json:"publisher" xml:"publisher" yaml:"publisher"`
 	// Note(sn): since this is a pointer, I expect that it could be nil, which
 	// is not the case.
-	Author *Author `json:"author"` // Embedded author struct
+	Author *Author `json:"author" xml:"author" yaml:"author"` // Embedded author struct
+	// The descriptive and physical details of the edition
+	Description     string `json:"description,omitempty" xml:"description,omitempty" yaml:"description,omitempty"`
+	PageCount       int    `json:"pageCount,omitempty" xml:"pageCount,omitempty" yaml:"pageCount,omitempty"`
+	Language        string `json:"language,omitempty" xml:"language,omitempty" yaml:"language,omitempty"` // BCP 47 language tag
+	PublicationYear int    `json:"publicationYear,omitempty" xml:"publicationYear,omitempty" yaml:"publicationYear,omitempty"`
+	Format          string `json:"format,omitempty" xml:"format,omitempty" yaml:"format,omitempty"` // One of the Formats
+	// Status is the workflow status of the record, one of the Statuses.
+	// Drafts are hidden from the public endpoints
+	Status string `json:"status,omitempty" xml:"status,omitempty" yaml:"status,omitempty"`
+	// PublishAt schedules the publication of a draft, it is published by
+	// the PublicationScheduler once this time has passed
+	PublishAt *time.Time `json:"publishAt,omitempty" xml:"publishAt,omitempty" yaml:"publishAt,omitempty"`
+	// OriginalTitle is only set when Title has been replaced by a translation
+	OriginalTitle string        `json:"originalTitle,omitempty" xml:"originalTitle,omitempty" yaml:"originalTitle,omitempty"`
+	Translations  []Translation `json:"translations,omitempty" xml:"translations>translation,omitempty" yaml:"translations,omitempty"`
+	// AccessibleFormats lists the accessible formats, e.g. braille, that the
+	// book is available in
+	AccessibleFormats []string `json:"accessibleFormats,omitempty" xml:"accessibleFormats>format,omitempty" yaml:"accessibleFormats,omitempty"`
+	// AvailableFrom embargoes the book, it is hidden from the public
+	// endpoints until this time has passed
+	AvailableFrom *time.Time `json:"availableFrom,omitempty" xml:"availableFrom,omitempty" yaml:"availableFrom,omitempty"`
+	// Classification is the shelf classification, e.g. a Dewey number
+	Classification string `json:"classification,omitempty" xml:"classification,omitempty" yaml:"classification,omitempty"`
+	// CallNumber is generated from the classification and author unless it
+	// is set manually
+	CallNumber string `json:"callNumber,omitempty" xml:"callNumber,omitempty" yaml:"callNumber,omitempty"`
+	// WorkID is the id of the work which the book is an edition of
+	WorkID string `json:"workId,omitempty" xml:"workId,omitempty" yaml:"workId,omitempty"`
+	// SeriesID is the id of the series which the book is volume
+	// SeriesVolume of, volumes are numbered from 1
+	SeriesID     string `json:"seriesId,omitempty" xml:"seriesId,omitempty" yaml:"seriesId,omitempty"`
+	SeriesVolume int    `json:"seriesVolume,omitempty" xml:"seriesVolume,omitempty" yaml:"seriesVolume,omitempty"`
+	// NextInSeries is the ISBN of the next volume of the series, only set
+	// when a single book is retrieved
+	NextInSeries string `json:"nextInSeries,omitempty" xml:"nextInSeries,omitempty" yaml:"nextInSeries,omitempty"`
+	// AverageRating is the average of the visible ratings of the book, only
+	// set when a single book is retrieved
+	AverageRating float64 `json:"averageRating,omitempty" xml:"averageRating,omitempty" yaml:"averageRating,omitempty"`
+	RatingCount   int     `json:"ratingCount,omitempty" xml:"ratingCount,omitempty" yaml:"ratingCount,omitempty"`
+	// OtherEditions are the ISBNs of the other editions of the work, only
+	// set when a single book is retrieved
+	OtherEditions []string `json:"otherEditions,omitempty" xml:"otherEditions>isbn,omitempty" yaml:"otherEditions,omitempty"`
+	// Reviews are the latest visible reviews of the book, only set when a
+	// single book is retrieved with ?expand=reviews
+	Reviews []Review `json:"reviews,omitempty" xml:"reviews>review,omitempty" yaml:"reviews,omitempty"`
+}
+
+// The physical or digital formats of a book.
+const (
+	FormatHardcover = "hardcover"
+	FormatPaperback = "paperback"
+	FormatEbook     = "ebook"
+	FormatAudiobook = "audiobook"
+)
+
+// Formats are the valid values of the format of a book.
+var Formats = []string{FormatHardcover, FormatPaperback, FormatEbook, FormatAudiobook}
+
+// validFormat reports whether format is one of the Formats.
+func validFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// The workflow statuses of a book. A draft is staged by the cataloguers
+// until it is complete and published, and a withdrawn book keeps its record
+// but can not be borrowed.
+const (
+	StatusDraft     = "draft"
+	StatusPublished = "published"
+	StatusWithdrawn = "withdrawn"
+)
+
+// Statuses are the valid values of the status of a book.
+var Statuses = []string{StatusDraft, StatusPublished, StatusWithdrawn}
+
+// validStatus reports whether status is one of the Statuses.
+func validStatus(status string) bool {
+	for _, s := range Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
 }
 
 // Struct for the books Author properties.
 type Author struct {
-	FirstName string `json:"firstName"`
-	LastName  string `json:"lastName"`
+	FirstName string `json:"firstName" xml:"firstName" yaml:"firstName"`
+	LastName  string `json:"lastName" xml:"lastName" yaml:"lastName"`
 }
 
 // The regex patterns for the validate function
@@ -38,22 +131,65 @@ var (
 // if correct we return boolean true, otherwise boolean false.
 func validate(b Book) error {
 	var fieldErrors []string
+	if b.Author == nil {
+		b.Author = &Author{}
+	}
+	// Drafts may leave out the title, author and publisher until they are
+	// published, a scheduled draft must be complete since it is published
+	// without further checks
+	incomplete := b.Status == StatusDraft && b.PublishAt == nil
+	required := func(pattern *regexp.Regexp, value string) bool {
+		return (incomplete && value == "") || pattern.MatchString(value)
+	}
 
 	if matchedISBN := isbnPattern.MatchString(b.ISBN); !matchedISBN {
 		fieldErrors = append(fieldErrors, " isbn ")
 	}
-	if matchedTitle := titlePattern.MatchString(b.Title); !matchedTitle {
+	if matchedTitle := required(titlePattern, b.Title); !matchedTitle {
 		fieldErrors = append(fieldErrors, " title ")
 	}
-	if matchedFirstName := firstNamePattern.MatchString(b.Author.FirstName); !matchedFirstName {
+	if matchedFirstName := required(firstNamePattern, b.Author.FirstName); !matchedFirstName {
 		fieldErrors = append(fieldErrors, " authors firstname ")
 	}
-	if matchedLastName := LastNamePattern.MatchString(b.Author.LastName); !matchedLastName {
+	if matchedLastName := required(LastNamePattern, b.Author.LastName); !matchedLastName {
 		fieldErrors = append(fieldErrors, " authors lastname ")
 	}
-	if matchedPublisher := publisherPattern.MatchString(b.Publisher); !matchedPublisher {
+	if matchedPublisher := required(publisherPattern, b.Publisher); !matchedPublisher {
 		fieldErrors = append(fieldErrors, " Publishers name")
 	}
+	if err := validateTranslations(b.Translations); err != nil {
+		fieldErrors = append(fieldErrors, " translations ")
+	}
+	if err := validateAccessibleFormats(b.AccessibleFormats); err != nil {
+		fieldErrors = append(fieldErrors, " accessible formats ")
+	}
+	if b.PageCount < 0 {
+		fieldErrors = append(fieldErrors, " page count ")
+	}
+	if b.Language != "" {
+		if _, err := language.Parse(b.Language); err != nil {
+			fieldErrors = append(fieldErrors, " language ")
+		}
+	}
+	// Printing with movable type started in the 1450s
+	if b.PublicationYear != 0 && (b.PublicationYear < 1450 || b.PublicationYear > time.Now().Year()+1) {
+		fieldErrors = append(fieldErrors, " publication year ")
+	}
+	if b.Format != "" && !validFormat(b.Format) {
+		fieldErrors = append(fieldErrors, " format ")
+	}
+	if b.Status != "" && !validStatus(b.Status) {
+		fieldErrors = append(fieldErrors, " status ")
+	}
+	if b.PublishAt != nil && b.Status != StatusDraft {
+		fieldErrors = append(fieldErrors, " publish at ")
+	}
+	if err := validateClassification(b.Classification); err != nil {
+		fieldErrors = append(fieldErrors, " classification ")
+	}
+	if (b.SeriesID == "" && b.SeriesVolume != 0) || (b.SeriesID != "" && b.SeriesVolume < 1) {
+		fieldErrors = append(fieldErrors, " series volume ")
+	}
 
 	if len(fieldErrors) != 0 {
 		return fmt.Errorf("validation failed, field error(s):%v. Fix these error before proceeding",
@@ -0,0 +1,339 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NicolaiMordrup/library/xlsx"
+)
+
+// The formats of the files which can be imported, the CSV exports of
+// Goodreads and LibraryThing and Excel workbooks.
+const (
+	ImportGoodreads    = "goodreads"
+	ImportLibraryThing = "librarything"
+	ImportXLSX         = "xlsx"
+)
+
+// goodreadsShelves are the shelves which every Goodreads account has. They
+// record what the owner has read rather than what the book is about, so
+// they are not imported as tags.
+var goodreadsShelves = map[string]bool{"read": true, "to-read": true, "currently-reading": true}
+
+// ImportRow is the outcome of one row of an imported file. Row is the line
+// number in the file, the header is line 1.
+type ImportRow struct {
+	Row int `json:"row"`
+	BatchResult
+	// Tags are the shelves or tags of the row, which become subjects
+	Tags []string `json:"tags,omitempty"`
+}
+
+// BookImport is the response of an import.
+type BookImport struct {
+	Preview  bool `json:"preview,omitempty"` // Nothing was stored
+	Imported int  `json:"imported"`
+	// Columns are the header of a workbook and the fields they were mapped
+	// to, only set for XLSX imports
+	Columns []ImportColumn `json:"columns,omitempty"`
+	Rows    []ImportRow    `json:"rows"`
+}
+
+// importedBook is a book read from a row of an imported file.
+type importedBook struct {
+	row  int
+	book Book
+	tags []string
+}
+
+// errImportFailed rolls back an import in which a row failed, and
+// errImportPreview rolls back a preview.
+var (
+	errImportFailed  = errors.New("import failed")
+	errImportPreview = errors.New("import preview")
+)
+
+// readCSV reads the rows of a CSV file, the header first.
+func readCSV(r io.Reader) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read csv err, %w", err)
+	}
+	return rows, nil
+}
+
+// headerColumns maps the names in the header of a CSV export to their
+// columns, so that columns which are added to the exports later are
+// ignored.
+func headerColumns(header []string) map[string]int {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	return columns
+}
+
+// mapImportRows maps the rows below the header to books. columns maps the
+// names which mapRow asks for to the columns of the rows, and empty rows
+// are skipped.
+func mapImportRows(rows [][]string, columns map[string]int, mapRow func(field func(string) string) importedBook) []importedBook {
+	var books []importedBook
+	for i := 1; i < len(rows); i++ {
+		record := rows[i]
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		b := mapRow(func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		})
+		b.row = i + 1
+		books = append(books, b)
+	}
+	return books
+}
+
+// goodreadsBook maps a row of a Goodreads export to a book. The bookshelves
+// become tags, except for the shelves which every account has.
+func goodreadsBook(field func(string) string) importedBook {
+	isbn := importISBN(field("ISBN13"))
+	if isbn == "" {
+		isbn = importISBN(field("ISBN"))
+	}
+	b := importedBook{book: Book{
+		ISBN:      isbn,
+		Title:     field("Title"),
+		Author:    importAuthor(field("Author l-f")),
+		Publisher: field("Publisher"),
+	}}
+	b.book.PageCount, _ = strconv.Atoi(field("Number of Pages"))
+	b.book.PublicationYear, _ = strconv.Atoi(field("Year Published"))
+	for _, shelf := range importTags(field("Bookshelves")) {
+		if !goodreadsShelves[shelf] {
+			b.tags = append(b.tags, shelf)
+		}
+	}
+	return b
+}
+
+// libraryThingBook maps a row of a LibraryThing export to a book. The
+// publisher is the part of the publication before the year, e.g. "Puffin"
+// of "Puffin (2005), Paperback, 160 pages".
+func libraryThingBook(field func(string) string) importedBook {
+	publisher := field("Publication")
+	if i := strings.IndexAny(publisher, "(,"); i >= 0 {
+		publisher = strings.TrimSpace(publisher[:i])
+	}
+	b := importedBook{book: Book{
+		ISBN:      importISBN(field("ISBN")),
+		Title:     field("Title"),
+		Author:    importAuthor(field("Primary Author")),
+		Publisher: publisher,
+	}, tags: importTags(field("Tags"))}
+	b.book.PageCount, _ = strconv.Atoi(field("Page Count"))
+	b.book.PublicationYear, _ = strconv.Atoi(field("Date"))
+	return b
+}
+
+// importISBN cleans up an ISBN of an export, which Goodreads writes as
+// ="9789129688313" and LibraryThing as [9789129688313]. ISBN-10s are
+// converted to ISBN-13s.
+func importISBN(s string) string {
+	isbn := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == 'X' || r == 'x' {
+			return r
+		}
+		return -1
+	}, s)
+	if len(isbn) != 10 {
+		return isbn
+	}
+	isbn = "978" + isbn[:9]
+	sum := 0
+	for i, r := range isbn {
+		d := int(r - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return isbn + strconv.Itoa((10-sum%10)%10)
+}
+
+// importAuthor maps an author written as "Lindgren, Astrid" or as "Astrid
+// Lindgren" to an author.
+func importAuthor(s string) *Author {
+	if names := strings.SplitN(s, ",", 2); len(names) == 2 {
+		return &Author{FirstName: strings.TrimSpace(names[1]), LastName: strings.TrimSpace(names[0])}
+	}
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, " "); i >= 0 {
+		return &Author{FirstName: strings.TrimSpace(s[:i]), LastName: s[i+1:]}
+	}
+	return &Author{LastName: s}
+}
+
+// importTags splits a comma separated list of shelves or tags.
+func importTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// tagBook adds the subjects named by the tags to a book. A tag which is not
+// the name of a top term becomes a new top term.
+func (s *Server) tagBook(q Querier, isbn string, tags []string) error {
+	for _, tag := range tags {
+		var id string
+		err := q.QueryRow("SELECT id FROM subject WHERE broaderId = '' AND name = ?", tag).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			subject := Subject{ID: s.idGenerator.NewID(), Name: tag, CreateTime: time.Now()}
+			if err := InsertSubject(q, subject); err != nil {
+				return err
+			}
+			id = subject.ID
+		} else if err != nil {
+			return fmt.Errorf("query subject err, %w", err)
+		}
+		if _, err := q.Exec("INSERT OR IGNORE INTO book_subject (isbn, subjectId) VALUES(?,?)", isbn, id); err != nil {
+			return fmt.Errorf("tag book err, %w", err)
+		}
+	}
+	return nil
+}
+
+// ImportBooks imports the books of a CSV export of Goodreads or
+// LibraryThing or of the first worksheet of an Excel workbook, given by
+// ?format. The columns of a workbook are mapped by spreadsheetColumns. The books are created like the creates of
+// a batch, in a single transaction which is rolled back if any row fails,
+// and the shelves or tags become subjects. With ?preview=true the import is
+// rolled back anyway, so that the mapping of the rows can be checked before
+// they are stored. A successful import can be undone through the operation
+// in the X-Operation-ID header.
+func (s *Server) ImportBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	format := r.URL.Query().Get("format")
+	if format != ImportGoodreads && format != ImportLibraryThing && format != ImportXLSX {
+		HandleErr(w, http.StatusBadRequest, "format must be one of goodreads, librarything or xlsx")
+		return
+	}
+	preview := r.URL.Query().Get("preview") == "true"
+	force := r.URL.Query().Get("force") == "true"
+	editor, err := s.editor(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if isTrainee(editor) {
+		HandleErr(w, errTraineeEdit.code, errTraineeEdit.msg)
+		return
+	}
+	var books []importedBook
+	var columns []ImportColumn
+	switch format {
+	case ImportXLSX:
+		rows, err := readXLSX(r.Body)
+		if errors.Is(err, xlsx.ErrTooLarge) {
+			HandleErr(w, http.StatusRequestEntityTooLarge, "The XLSX file is too large")
+			return
+		} else if err != nil {
+			HandleErr(w, http.StatusBadRequest, "Failed to read the XLSX file")
+			return
+		}
+		var mapping map[string]int
+		columns, mapping, err = spreadsheetColumns(rows, r.URL.Query()["column"])
+		if err != nil {
+			HandleErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		books = mapImportRows(rows, mapping, spreadsheetBook)
+	default:
+		rows, err := readCSV(r.Body)
+		if err != nil || len(rows) == 0 {
+			HandleErr(w, http.StatusBadRequest, "Failed to read the CSV file")
+			return
+		}
+		mapRow := goodreadsBook
+		if format == ImportLibraryThing {
+			mapRow = libraryThingBook
+		}
+		books = mapImportRows(rows, headerColumns(rows[0]), mapRow)
+	}
+	if len(books) > maxBatchOperations {
+		HandleErr(w, http.StatusRequestEntityTooLarge, "Too many rows in file")
+		return
+	}
+
+	res := BookImport{Preview: preview, Columns: columns, Rows: make([]ImportRow, len(books))}
+	var operationID string
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		failed := false
+		j := &journal{q: tx}
+		for i, b := range books {
+			op := BatchOperation{Method: "create", ISBN: b.book.ISBN, Book: &b.book, Force: force}
+			res.Rows[i] = ImportRow{Row: b.row, BatchResult: s.executeBatchOperation(tx, op, editor), Tags: b.tags}
+			if res.Rows[i].Status != http.StatusOK {
+				failed = true
+				// Show how the row was mapped even though it failed
+				res.Rows[i].Book = &books[i].book
+				continue
+			}
+			if err := s.tagBook(tx, b.book.ISBN, b.tags); err != nil {
+				return err
+			}
+			j.record(b.book.ISBN, Book{})
+		}
+		switch {
+		case failed:
+			return errImportFailed
+		case preview:
+			return errImportPreview
+		}
+		var err error
+		operationID, err = s.journalOperation(tx, OperationImport, j)
+		return err
+	})
+
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, errImportFailed):
+		status = http.StatusUnprocessableEntity
+		for i := range res.Rows {
+			if res.Rows[i].Status == http.StatusOK {
+				res.Rows[i].Status = http.StatusFailedDependency
+			}
+		}
+	case errors.Is(err, errImportPreview):
+	case err != nil:
+		HandleErr(w, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	default:
+		res.Imported = len(books)
+	}
+
+	if operationID != "" {
+		w.Header().Set(operationIDHeader, operationID)
+	}
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the import result")
+		return
+	}
+}
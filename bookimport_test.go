@@ -0,0 +1,70 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportBooks(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	goodreads := `Book Id,Title,Author,Author l-f,Additional Authors,ISBN,ISBN13,My Rating,Publisher,Number of Pages,Year Published,Bookshelves,Exclusive Shelf
+1,Pippi Langstrump,Astrid Lindgren,"Lindgren, Astrid",,"=""9129688316""","=""9789129688313""",5,raben,160,1945,"childrens, to-read",to-read
+2,Mio min Mio,Astrid Lindgren,"Lindgren, Astrid",,"=""""","=""9789129657470""",4,raben,180,1954,childrens,read
+`
+	importFile := func(query, body string) (int, BookImport) {
+		t.Helper()
+		response := createNewRequest(http.MethodPost, "/api/v1/books:import?"+query, []byte(body), db)
+		var res BookImport
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		return response.Code, res
+	}
+
+	t.Run("Previews the mapping of the rows", func(t *testing.T) {
+		code, res := importFile("format=goodreads&preview=true", goodreads)
+		require.Equal(t, http.StatusOK, code)
+		require.Len(t, res.Rows, 2)
+		require.Equal(t, 2, res.Rows[0].Row)
+		require.Equal(t, "9789129688313", res.Rows[0].Book.ISBN)
+		require.Equal(t, Author{FirstName: "Astrid", LastName: "Lindgren"}, *res.Rows[0].Book.Author)
+		require.Equal(t, 1945, res.Rows[0].Book.PublicationYear)
+		require.Equal(t, []string{"childrens"}, res.Rows[0].Tags)
+		require.Zero(t, res.Imported)
+		require.Equal(t, "", FindSpecificBook(db, "9789129688313").ISBN)
+	})
+
+	t.Run("Imports the rows and tags the books", func(t *testing.T) {
+		code, res := importFile("format=goodreads", goodreads)
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 2, res.Imported)
+		require.Equal(t, "Mio min Mio", FindSpecificBook(db, "9789129657470").Title)
+
+		var n int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM book_subject INNER JOIN subject ON subject.id = book_subject.subjectId WHERE subject.name = 'childrens'").Scan(&n))
+		require.Equal(t, 2, n)
+	})
+
+	t.Run("Imports nothing if a row fails", func(t *testing.T) {
+		librarything := `"Book Id","Title","Primary Author","Publication","Date","Tags","ISBN","Page Count"
+"1","Bröderna Lejonhjärta","Lindgren, Astrid","raben (1973), Paperback","1973","fantasy","[9129655523]","240"
+"2","Pippi Langstrump","Lindgren, Astrid","raben (1945)","1945","","[9789129688313]","160"
+`
+		code, res := importFile("format=librarything", librarything)
+		require.Equal(t, http.StatusUnprocessableEntity, code)
+		require.Equal(t, http.StatusFailedDependency, res.Rows[0].Status)
+		require.Equal(t, "9789129655520", res.Rows[0].Book.ISBN)
+		require.Equal(t, "raben", res.Rows[0].Book.Publisher)
+		require.Equal(t, http.StatusConflict, res.Rows[1].Status)
+		require.Equal(t, "", FindSpecificBook(db, "9789129655520").ISBN)
+	})
+
+	t.Run("Rejects unknown formats", func(t *testing.T) {
+		response := createNewRequest(http.MethodPost, "/api/v1/books:import?format=csv", []byte(strings.TrimSpace(goodreads)), db)
+		require.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
@@ -0,0 +1,128 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// bulkDeleteSampleSize is the number of books listed in the response of a
+// bulk delete.
+const bulkDeleteSampleSize = 10
+
+// BulkDeleteResult is the response to a bulk delete. Sample lists the first
+// of the matching books.
+type BulkDeleteResult struct {
+	Count  int    `json:"count"`
+	DryRun bool   `json:"dryRun,omitempty"`
+	Sample []Book `json:"sample"`
+	// OperationID is the id of the operation which undoes the deletion, it
+	// is not set by dry runs
+	OperationID string `json:"operationId,omitempty"`
+}
+
+// bookFilter selects the books of a bulk operation.
+type bookFilter struct {
+	publisher     string
+	createdBefore time.Time
+	createdAfter  time.Time
+}
+
+// parseBookFilter reads the filter from the query parameters. At least one
+// filter must be given so that a mistake can not select every book.
+func parseBookFilter(r *http.Request) (bookFilter, string) {
+	var f bookFilter
+	query := r.URL.Query()
+	f.publisher = query.Get("publisher")
+	for param, t := range map[string]*time.Time{
+		"created_before": &f.createdBefore,
+		"created_after":  &f.createdAfter,
+	} {
+		if v := query.Get(param); v != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return f, param + " must be an RFC3339 timestamp"
+			}
+		}
+	}
+	if f.publisher == "" && f.createdBefore.IsZero() && f.createdAfter.IsZero() {
+		return f, "at least one of publisher, created_before or created_after is required"
+	}
+	return f, ""
+}
+
+func (f bookFilter) matches(b Book) bool {
+	if f.publisher != "" && b.Publisher != f.publisher {
+		return false
+	}
+	if !f.createdBefore.IsZero() && !b.CreateTime.Before(f.createdBefore) {
+		return false
+	}
+	if !f.createdAfter.IsZero() && !b.CreateTime.After(f.createdAfter) {
+		return false
+	}
+	return true
+}
+
+// DeleteBooks deletes the books matching the filter in the query, e.g. to
+// weed withdrawn stock. Embargoed books are included. The deletion must be
+// confirmed with confirm=true, and dry_run=true reports what would be
+// deleted without deleting anything. The deletion can be undone through the
+// operation in the result. Nothing is deleted if any of the books is locked
+// by another member, see LockBook.
+func (s *Server) DeleteBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	filter, msg := parseBookFilter(r)
+	if msg != "" {
+		HandleErr(w, http.StatusBadRequest, msg)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	if !dryRun && r.URL.Query().Get("confirm") != "true" {
+		HandleErr(w, http.StatusBadRequest, "confirm=true is required to delete books, use dry_run=true to see what would be deleted")
+		return
+	}
+	editor, err := s.bookEditor(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+
+	var res BulkDeleteResult
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		res = BulkDeleteResult{DryRun: dryRun, Sample: []Book{}}
+		j := &journal{q: tx}
+		for _, b := range filterBooks(ReadDatabaseList(tx), filter.matches) {
+			res.Count++
+			if len(res.Sample) < bulkDeleteSampleSize {
+				res.Sample = append(res.Sample, b)
+			}
+			if dryRun {
+				continue
+			}
+			if err := checkBookLock(tx, b.ISBN, editor.ID, time.Now()); err != nil {
+				return err
+			}
+			deleted, err := s.deleteBook(tx, b.ISBN)
+			if err != nil {
+				return err
+			}
+			j.record(b.ISBN, deleted)
+		}
+		var err error
+		res.OperationID, err = s.journalOperation(tx, OperationBulkDelete, j)
+		return err
+	})
+	if err != nil {
+		handleBookErr(w, err)
+		return
+	}
+	if res.OperationID != "" {
+		w.Header().Set(operationIDHeader, res.OperationID)
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the result")
+		return
+	}
+}
@@ -0,0 +1,58 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteBooks(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	publishers := map[string]string{
+		"1233211233215": "withdrawn",
+		"1233211233213": "withdrawn",
+		"1233211233210": "adlibris",
+	}
+	for isbn, publisher := range publishers {
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: starWarsTitles[isbn],
+			Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: publisher})
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+	}
+
+	t.Run("Rejects invalid requests", func(t *testing.T) {
+		for _, query := range []string{
+			"?confirm=true",
+			"?publisher=withdrawn",
+			"?created_before=yesterday&confirm=true",
+		} {
+			response := createNewRequest(http.MethodDelete, "/api/v1/books"+query, nil, db)
+			require.Equal(t, http.StatusBadRequest, response.Code, query)
+		}
+	})
+
+	t.Run("Reports what a dry run would delete", func(t *testing.T) {
+		response := createNewRequest(http.MethodDelete, "/api/v1/books?publisher=withdrawn&dry_run=true", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var res BulkDeleteResult
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		require.True(t, res.DryRun)
+		require.Equal(t, 2, res.Count)
+		require.Len(t, res.Sample, 2)
+		require.Len(t, ReadDatabaseList(db), 3)
+	})
+
+	t.Run("Deletes the matching books", func(t *testing.T) {
+		response := createNewRequest(http.MethodDelete, "/api/v1/books?publisher=withdrawn&confirm=true", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var res BulkDeleteResult
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		require.Equal(t, 2, res.Count)
+		books := ReadDatabaseList(db)
+		require.Len(t, books, 1)
+		require.Equal(t, "1233211233210", books[0].ISBN)
+	})
+}
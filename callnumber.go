@@ -0,0 +1,128 @@
+package library
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// CallNumberScheme generates the call number of a book from its
+// classification and author.
+type CallNumberScheme interface {
+	CallNumber(b Book) string
+}
+
+// The call number schemes which can be selected by name.
+var callNumberSchemes = map[string]CallNumberScheme{
+	"cutter":  CutterScheme{},
+	"surname": SurnameScheme{},
+}
+
+// CallNumberSchemeByName returns the scheme with the given name, either
+// "cutter" or "surname".
+func CallNumberSchemeByName(name string) (CallNumberScheme, error) {
+	scheme, ok := callNumberSchemes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown call number scheme %q", name)
+	}
+	return scheme, nil
+}
+
+// CutterScheme builds call numbers from the classification followed by a
+// Cutter number of the author's last name, e.g. "823.914 L56".
+type CutterScheme struct{}
+
+// CallNumber implements CallNumberScheme.
+func (CutterScheme) CallNumber(b Book) string {
+	if b.Author == nil {
+		return b.Classification
+	}
+	return strings.TrimSpace(b.Classification + " " + CutterNumber(b.Author.LastName))
+}
+
+// SurnameScheme builds call numbers from the classification followed by the
+// author's last name, which is how Swedish public libraries mark their
+// shelves, e.g. "Hc Lindgren".
+type SurnameScheme struct{}
+
+// CallNumber implements CallNumberScheme.
+func (SurnameScheme) CallNumber(b Book) string {
+	if b.Author == nil {
+		return b.Classification
+	}
+	return strings.TrimSpace(b.Classification + " " + b.Author.LastName)
+}
+
+// CutterNumber returns the Cutter number of a name according to the Library
+// of Congress Cutter table, using two digits: the initial letter followed
+// by digits for the second and third letter, e.g. "Lindgren" is "L56".
+func CutterNumber(name string) string {
+	var letters []rune
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) && r < unicode.MaxASCII {
+			letters = append(letters, r)
+		}
+	}
+	if len(letters) == 0 {
+		return ""
+	}
+
+	initial := letters[0]
+	cutter := string(unicode.ToUpper(initial))
+	rest := letters[1:]
+	var first byte
+
+	switch {
+	case strings.ContainsRune("aeiou", initial):
+		first, rest = cutterDigit(rest, "b2 d3 l4 m4 n5 p6 r7 s8 t8 u9 v9 w9 x9 y9"), skip(rest)
+	case initial == 's':
+		if len(rest) >= 2 && rest[0] == 'c' && rest[1] == 'h' {
+			first, rest = '3', rest[2:]
+		} else {
+			first, rest = cutterDigit(rest, "a2 e4 h5 i5 m6 n6 o6 p6 t7 u8 w9 x9 y9 z9"), skip(rest)
+		}
+	case initial == 'q':
+		if len(rest) >= 1 && rest[0] == 'u' {
+			first, rest = cutterDigit(rest[1:], "a3 e4 i5 o6 r7 t8 y9"), skip(rest[1:])
+		} else {
+			first = '2'
+		}
+	default:
+		first, rest = cutterDigit(rest, "a3 e4 i5 o6 r7 u8 y9"), skip(rest)
+	}
+	if first != 0 {
+		cutter += string(first)
+	}
+	if second := cutterDigit(rest, "a3 b3 c3 d3 e4 f4 g4 h4 i5 j5 k5 l5 m6 n6 o6 p7 q7 r7 s7 t8 u8 v8 w9 x9 y9 z9"); second != 0 {
+		cutter += string(second)
+	}
+	return cutter
+}
+
+// cutterDigit looks up the first letter in a table of letter-digit pairs.
+// Letters which are not in the table sort before the next listed letter, so
+// they get the digit of the closest preceding letter.
+func cutterDigit(letters []rune, table string) byte {
+	if len(letters) == 0 {
+		return 0
+	}
+	var digit byte
+	for _, entry := range strings.Fields(table) {
+		if rune(entry[0]) > letters[0] {
+			break
+		}
+		digit = entry[1]
+	}
+	if digit == 0 {
+		// The letter sorts before every letter in the table
+		digit = strings.Fields(table)[0][1] - 1
+	}
+	return digit
+}
+
+func skip(letters []rune) []rune {
+	if len(letters) == 0 {
+		return letters
+	}
+	return letters[1:]
+}
@@ -0,0 +1,84 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCutterNumber(t *testing.T) {
+	for name, want := range map[string]string{
+		"Lindgren":    "L56",
+		"Adams":       "A33",
+		"Shakespeare": "S53",
+		"Schmidt":     "S36",
+		"Quinn":       "Q56",
+		"Qaddafi":     "Q23",
+		"Orwell":      "O79",
+		"":            "",
+	} {
+		require.Equal(t, want, CutterNumber(name), name)
+	}
+}
+
+func TestCallNumbers(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	create := func(book Book) Book {
+		t.Helper()
+		jsonBytes, _ := json.Marshal(book)
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+book.ISBN, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var got Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		return got
+	}
+
+	t.Run("Generates the call number from the classification", func(t *testing.T) {
+		got := create(Book{
+			ISBN:           "1233211233215",
+			Title:          "pippi longstocking",
+			Author:         &Author{FirstName: "astrid", LastName: "Lindgren"},
+			Publisher:      "raben",
+			Classification: "839.73",
+		})
+		require.Equal(t, "839.73 L56", got.CallNumber)
+		require.Equal(t, "839.73 L56", FindSpecificBook(db, "1233211233215").CallNumber)
+	})
+
+	t.Run("Keeps a manually set call number", func(t *testing.T) {
+		got := create(Book{
+			ISBN:           "1233211233213",
+			Title:          "star wars",
+			Author:         &Author{FirstName: "george", LastName: "lucas"},
+			Publisher:      "adlibris",
+			Classification: "791.43",
+			CallNumber:     "791.43 STA",
+		})
+		require.Equal(t, "791.43 STA", got.CallNumber)
+	})
+
+	t.Run("Leaves books without classification alone", func(t *testing.T) {
+		got := create(Book{
+			ISBN:      "1233211233210",
+			Title:     "american graffiti",
+			Author:    &Author{FirstName: "george", LastName: "lucas"},
+			Publisher: "adlibris",
+		})
+		require.Empty(t, got.CallNumber)
+	})
+
+	t.Run("Uses the configured scheme", func(t *testing.T) {
+		scheme, err := CallNumberSchemeByName("surname")
+		require.NoError(t, err)
+		require.Equal(t, "Hc Lindgren", scheme.CallNumber(Book{
+			Classification: "Hc",
+			Author:         &Author{LastName: "Lindgren"},
+		}))
+		_, err = CallNumberSchemeByName("dewey")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,175 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// maxCapturedBody is the number of bytes kept of captured request and
+// response bodies.
+const maxCapturedBody = 16 << 10
+
+// sensitiveHeaders are redacted from captures.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization", "X-Api-Key"}
+
+// sensitiveFields matches JSON fields whose values are redacted from
+// captured bodies: the credentials, the TOTP and recovery codes, and the
+// personal data of members. The values are strings or arrays of strings.
+var sensitiveFields = regexp.MustCompile(`(?i)("(?:[a-z_]*password|[a-z_]*token|secret|api_?key|[a-z_]*codes?|[a-z_]*email|[a-z_]*phone)"\s*:\s*)(?:"(?:[^"\\]|\\.)*"|\[[^\]]*\])`)
+
+// Capture is a failed request and the response to it.
+type Capture struct {
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeader   http.Header `json:"requestHeader"`
+	RequestBody     string      `json:"requestBody,omitempty"`
+	Status          int         `json:"status"`
+	ResponseHeader  http.Header `json:"responseHeader"`
+	ResponseBody    string      `json:"responseBody,omitempty"`
+	DurationSeconds float64     `json:"durationSeconds"`
+}
+
+// captureBuffer is a ring buffer of the latest captures.
+type captureBuffer struct {
+	mu       sync.Mutex
+	captures []Capture
+	next     int
+	full     bool
+}
+
+func newCaptureBuffer(size int) *captureBuffer {
+	return &captureBuffer{captures: make([]Capture, size)}
+}
+
+func (b *captureBuffer) add(c Capture) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.captures[b.next] = c
+	b.next = (b.next + 1) % len(b.captures)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// list returns the captures, newest first.
+func (b *captureBuffer) list() []Capture {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.next
+	if b.full {
+		n = len(b.captures)
+	}
+	res := make([]Capture, 0, n)
+	for i := 1; i <= n; i++ {
+		res = append(res, b.captures[(b.next-i+len(b.captures))%len(b.captures)])
+	}
+	return res
+}
+
+// WithFailureCapture records the latest size requests which failed with a
+// 5xx status, together with their responses, so that they can be retrieved
+// by admins. Credentials are redacted.
+func WithFailureCapture(size int) ServerOption {
+	return func(s *Server) {
+		if size > 0 {
+			s.captures = newCaptureBuffer(size)
+		}
+	}
+}
+
+// capturingResponseWriter keeps the status and the start of the body of the
+// response.
+type capturingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *capturingResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *capturingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if room := maxCapturedBody - w.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		w.body.Write(b[:room])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// serveCaptured serves the request and captures it if it fails.
+func (s *Server) serveCaptured(w http.ResponseWriter, req *http.Request, next http.Handler) {
+	start := time.Now()
+	var reqBody []byte
+	if req.Body != nil {
+		// Keep the start of the body for the capture and pass on all of it
+		reqBody, _ = ioutil.ReadAll(io.LimitReader(req.Body, maxCapturedBody))
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(reqBody), req.Body), req.Body}
+	}
+	cw := &capturingResponseWriter{ResponseWriter: w}
+	next.ServeHTTP(cw, req)
+	if cw.status < 500 {
+		return
+	}
+	s.captures.add(Capture{
+		Time:            start,
+		Method:          req.Method,
+		URL:             req.URL.String(),
+		RequestHeader:   redactHeader(req.Header),
+		RequestBody:     redactBody(reqBody),
+		Status:          cw.status,
+		ResponseHeader:  redactHeader(w.Header()),
+		ResponseBody:    redactBody(cw.body.Bytes()),
+		DurationSeconds: time.Since(start).Seconds(),
+	})
+}
+
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range sensitiveHeaders {
+		if h.Get(name) != "" {
+			h.Set(name, "REDACTED")
+		}
+	}
+	return h
+}
+
+func redactBody(b []byte) string {
+	return sensitiveFields.ReplaceAllString(string(b), `$1"REDACTED"`)
+}
+
+// ListCaptures retrieves the captured failed requests, newest first. Only
+// admins can see the captures.
+func (s *Server) ListCaptures(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if s.captures == nil {
+		HandleErr(w, http.StatusNotFound, "Capturing of failed requests is not enabled")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(s.captures.list()); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the captures")
+		return
+	}
+}
@@ -0,0 +1,58 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailureCapture(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	s := NewServer(db, WithFailureCapture(2))
+
+	admin := createMemberSession(t, db, "admin", RoleAdmin)
+	serve := func(method, path, token string, body []byte) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, bytes.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+		s.ServeHTTP(response, request)
+		return response
+	}
+
+	// Successful requests are not captured
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/stats", "secret", nil).Code)
+
+	// Break the authorities so that the requests of them fail
+	_, err := db.Exec("DROP TABLE authority")
+	require.NoError(t, err)
+	for _, path := range []string{"/api/v1/admin/authorities", "/api/v1/admin/authorities?kind=subject", "/api/v1/admin/authorities?kind=name"} {
+		response := serve(http.MethodGet, path, "secret", []byte(`{"password": "hunter2", "title": "star wars"}`))
+		require.Equal(t, http.StatusInternalServerError, response.Code)
+	}
+	response := serve(http.MethodGet, "/api/v1/admin/authorities", "secret",
+		[]byte(`{"email": "astrid@example.com", "password": "hunter2", "code": "123456", "phone": "+46 8 123 45",`+
+			` "recoveryCodes": ["abcd-efgh", "ijkl-mnop"]}`))
+	require.Equal(t, http.StatusInternalServerError, response.Code)
+
+	require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/admin/captures", "secret", nil).Code)
+	response = serve(http.MethodGet, "/api/v1/admin/captures", admin, nil)
+	require.Equal(t, http.StatusOK, response.Code)
+	var got []Capture
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+
+	// Only the latest two failures are kept, newest first
+	require.Len(t, got, 2)
+	require.Equal(t, "/api/v1/admin/authorities", got[0].URL)
+	require.Equal(t, "/api/v1/admin/authorities?kind=name", got[1].URL)
+	require.Equal(t, http.StatusInternalServerError, got[1].Status)
+	require.Equal(t, "REDACTED", got[1].RequestHeader.Get("Authorization"))
+	require.Equal(t, `{"password": "REDACTED", "title": "star wars"}`, got[1].RequestBody)
+	require.Equal(t, "Failed to read the authorities", got[1].ResponseBody)
+	require.Equal(t, `{"email": "REDACTED", "password": "REDACTED", "code": "REDACTED", "phone": "REDACTED",`+
+		` "recoveryCodes": "REDACTED"}`, got[0].RequestBody, "the codes and personal data of a login are redacted")
+}
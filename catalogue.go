@@ -0,0 +1,149 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/NicolaiMordrup/library/events"
+)
+
+// readCataloguePosition reads the id of the latest event applied from the
+// source, 0 if none has been applied.
+func readCataloguePosition(db Querier, source string) (int64, error) {
+	var id int64
+	err := db.QueryRow("SELECT eventId FROM catalogue_position WHERE source = ?", source).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read catalogue position err, %w", err)
+	}
+	return id, nil
+}
+
+func writeCataloguePosition(db Querier, source string, id int64) error {
+	if _, err := db.Exec("INSERT OR REPLACE INTO catalogue_position (source, eventId) VALUES(?,?)", source, id); err != nil {
+		return fmt.Errorf("write catalogue position err, %w", err)
+	}
+	return nil
+}
+
+// ApplyEvent applies a book event of the upstream catalogue source to the
+// library. Events which have already been applied are skipped, so that
+// events which are delivered twice are harmless. The books keep the ids and
+// times of the upstream catalogue.
+func (s *Server) ApplyEvent(source string, e events.Event) error {
+	if e.Version != events.SchemaVersion {
+		return fmt.Errorf("event %d has schema version %d, expected %d", e.ID, e.Version, events.SchemaVersion)
+	}
+	return s.inTx(context.Background(), func(tx *sql.Tx) error {
+		applied, err := readCataloguePosition(tx, source)
+		if err != nil || e.ID <= applied {
+			return err
+		}
+		exists := FindSpecificBook(tx, e.Subject)
+		switch e.Type {
+		case events.TypeBookCreated, events.TypeBookUpdated:
+			var book Book
+			if err := json.Unmarshal(e.Data, &book); err != nil {
+				return fmt.Errorf("decode book of event %d err, %w", e.ID, err)
+			}
+			if book.ISBN != e.Subject {
+				return fmt.Errorf("event %d is about %s but has the book %s", e.ID, e.Subject, book.ISBN)
+			}
+			if err := validate(book); err != nil {
+				return fmt.Errorf("event %d err, %w", e.ID, err)
+			}
+			if exists.ISBN != "" {
+				if err := DeleteBookFromDB(tx, exists.ISBN); err != nil {
+					return err
+				}
+			}
+			if err := InsertIntoDatabase(tx, book); err != nil {
+				return err
+			}
+			if err := RemoveTombstone(tx, book.ISBN); err != nil {
+				return err
+			}
+			typ := events.TypeBookUpdated
+			if exists.ISBN == "" {
+				typ = events.TypeBookCreated
+			}
+			if err := s.recordEvent(tx, typ, book.ISBN, &book); err != nil {
+				return err
+			}
+		case events.TypeBookDeleted:
+			if exists.ISBN != "" {
+				if err := DeleteBookFromDB(tx, exists.ISBN); err != nil {
+					return err
+				}
+				if err := releaseUpdateClaim(tx, exists.ISBN); err != nil {
+					return err
+				}
+				if err := InsertTombstone(tx, exists.ISBN, e.Time); err != nil {
+					return err
+				}
+				if err := s.recordEvent(tx, events.TypeBookDeleted, exists.ISBN, nil); err != nil {
+					return err
+				}
+			}
+		default:
+			// Events of other kinds of changes are of no interest
+		}
+		return writeCataloguePosition(tx, source, e.ID)
+	})
+}
+
+// CatalogueConsumer keeps the library in sync with an upstream catalogue by
+// applying the book events it publishes, e.g. to run the library as a read
+// replica of a cataloguing system.
+type CatalogueConsumer struct {
+	Subscriber events.Subscriber
+	Source     string        // Names the upstream catalogue, defaults to "upstream"
+	RetryDelay time.Duration // Before subscribing again, defaults to 10 seconds
+	// OnError is called when the subscription is lost or an event can not be
+	// applied, e.g. to alert that the library is getting stale.
+	OnError func(error)
+}
+
+// Run applies the events until the context is done. An event which can not
+// be applied is reported and skipped, so that a single bad event does not
+// stop the replication.
+func (c CatalogueConsumer) Run(ctx context.Context, s *Server) {
+	source := c.Source
+	if source == "" {
+		source = "upstream"
+	}
+	delay := c.RetryDelay
+	if delay == 0 {
+		delay = 10 * time.Second
+	}
+	report := func(err error) {
+		if c.OnError != nil {
+			c.OnError(err)
+		}
+	}
+	for {
+		err := c.Subscriber.Subscribe(ctx, func(e events.Event) error {
+			if err := s.ApplyEvent(source, e); err != nil {
+				report(err)
+			}
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			report(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/NicolaiMordrup/library/events"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSubscriber delivers the events, closes delivered and then waits for
+// the context.
+type fakeSubscriber struct {
+	events    []events.Event
+	delivered chan struct{}
+}
+
+func (f *fakeSubscriber) Subscribe(ctx context.Context, handle func(events.Event) error) error {
+	for _, e := range f.events {
+		if err := handle(e); err != nil {
+			return err
+		}
+	}
+	close(f.delivered)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCatalogueConsumer(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	server := NewServer(db)
+
+	isbn := "1233211233215"
+	bookEvent := func(id int64, typ, title string) events.Event {
+		e := events.Event{Version: events.SchemaVersion, ID: id, Type: typ, Subject: isbn, Time: time.Now()}
+		if title != "" {
+			e.Data, _ = json.Marshal(Book{ISBN: isbn, Title: title, CreateTime: time.Now(),
+				Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "lucasfilm"})
+		}
+		return e
+	}
+	consume := func(evs ...events.Event) []error {
+		var errs []error
+		ctx, cancel := context.WithCancel(context.Background())
+		subscriber := &fakeSubscriber{events: evs, delivered: make(chan struct{})}
+		consumer := CatalogueConsumer{
+			Subscriber: subscriber,
+			OnError:    func(err error) { errs = append(errs, err) },
+		}
+		done := make(chan struct{})
+		go func() {
+			consumer.Run(ctx, server)
+			close(done)
+		}()
+		<-subscriber.delivered
+		cancel()
+		<-done
+		return errs
+	}
+
+	t.Run("Applies upserts", func(t *testing.T) {
+		require.Empty(t, consume(bookEvent(1, events.TypeBookCreated, "a new hope"),
+			bookEvent(2, events.TypeBookUpdated, "star wars")))
+		require.Equal(t, "star wars", FindSpecificBook(db, isbn).Title)
+	})
+
+	t.Run("Skips applied events", func(t *testing.T) {
+		require.Empty(t, consume(bookEvent(1, events.TypeBookCreated, "a new hope"),
+			bookEvent(2, events.TypeBookUpdated, "star wars")))
+		require.Equal(t, "star wars", FindSpecificBook(db, isbn).Title)
+	})
+
+	t.Run("Applies deletes", func(t *testing.T) {
+		require.Empty(t, consume(bookEvent(3, events.TypeBookDeleted, "")))
+		require.Empty(t, FindSpecificBook(db, isbn).ISBN)
+		tombstones, err := ReadTombstones(db)
+		require.NoError(t, err)
+		require.Len(t, tombstones, 1)
+	})
+
+	t.Run("Rejects unknown schema versions", func(t *testing.T) {
+		e := bookEvent(4, events.TypeBookCreated, "a new hope")
+		e.Version = events.SchemaVersion + 1
+		require.Len(t, consume(e), 1)
+		require.Empty(t, FindSpecificBook(db, isbn).ISBN)
+	})
+}
@@ -0,0 +1,215 @@
+package library
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The citation styles.
+const (
+	CitationAPA     = "apa"
+	CitationHarvard = "harvard"
+	CitationMLA     = "mla"
+)
+
+// CSLName is a name in CSL-JSON, the format of the Citation Style Language
+// which citation managers import.
+type CSLName struct {
+	Family string `json:"family,omitempty"`
+	Given  string `json:"given,omitempty"`
+}
+
+// CSLDate is a date in CSL-JSON, a list of [year, month, day] parts of which
+// only the year is known for books.
+type CSLDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+// CSLItem is the CSL-JSON item of a book.
+type CSLItem struct {
+	ID            string    `json:"id"`
+	Type          string    `json:"type"`
+	Title         string    `json:"title"`
+	Author        []CSLName `json:"author,omitempty"`
+	Publisher     string    `json:"publisher,omitempty"`
+	Issued        *CSLDate  `json:"issued,omitempty"`
+	ISBN          string    `json:"ISBN"`
+	Language      string    `json:"language,omitempty"`
+	NumberOfPages int       `json:"number-of-pages,omitempty"`
+	Abstract      string    `json:"abstract,omitempty"`
+}
+
+// Citation is a book cited in a style, as plain text and as HTML in which
+// the title is in italics, with the CSL-JSON item it was formatted from.
+type Citation struct {
+	Style string  `json:"style"`
+	Text  string  `json:"text"`
+	HTML  string  `json:"html"`
+	Item  CSLItem `json:"item"`
+}
+
+// cslItemFromBook maps a book to CSL-JSON.
+func cslItemFromBook(b Book) CSLItem {
+	item := CSLItem{ID: b.ISBN, Type: "book", Title: b.Title, Publisher: b.Publisher, ISBN: b.ISBN,
+		Language: b.Language, NumberOfPages: b.PageCount, Abstract: b.Description}
+	if b.Author != nil && (b.Author.FirstName != "" || b.Author.LastName != "") {
+		item.Author = []CSLName{{Family: b.Author.LastName, Given: b.Author.FirstName}}
+	}
+	if b.PublicationYear != 0 {
+		item.Issued = &CSLDate{DateParts: [][]int{{b.PublicationYear}}}
+	}
+	return item
+}
+
+// citationPart is a part of a citation, italic parts are italicized in
+// HTML.
+type citationPart struct {
+	text   string
+	italic bool
+}
+
+// formatCitation formats the item in a style. The styles follow the book
+// references of APA 7, Harvard (Cite Them Right) and MLA 9, for a single
+// author, without the place of publication, which the books do not have.
+func formatCitation(item CSLItem, style string) (text, htmlText string) {
+	var parts []citationPart
+	add := func(s string, italic bool) {
+		if s != "" {
+			parts = append(parts, citationPart{s, italic})
+		}
+	}
+	year := ""
+	if item.Issued != nil && len(item.Issued.DateParts) != 0 && len(item.Issued.DateParts[0]) != 0 {
+		year = strconv.Itoa(item.Issued.DateParts[0][0])
+	}
+	var author CSLName
+	if len(item.Author) != 0 {
+		author = item.Author[0]
+	}
+
+	switch style {
+	case CitationAPA:
+		// Lindgren, A. (1945). Pippi Langstrump. Raben.
+		if year == "" {
+			year = "n.d."
+		}
+		if name := initialedName(author); name != "" {
+			add(sentence(name)+" ", false)
+			add("("+year+"). ", false)
+			add(sentence(item.Title), true)
+		} else {
+			add(sentence(item.Title), true)
+			add(" ("+year+").", false)
+		}
+		if item.Publisher != "" {
+			add(" "+sentence(item.Publisher), false)
+		}
+	case CitationHarvard:
+		// Lindgren, A. (1945) Pippi Langstrump. Raben.
+		if year == "" {
+			year = "no date"
+		}
+		if name := initialedName(author); name != "" {
+			add(sentence(name)+" ("+year+") ", false)
+			add(sentence(item.Title), true)
+		} else {
+			add(item.Title, true)
+			add(" ("+year+").", false)
+		}
+		if item.Publisher != "" {
+			add(" "+sentence(item.Publisher), false)
+		}
+	case CitationMLA:
+		// Lindgren, Astrid. Pippi Langstrump. Raben, 1945.
+		name := author.Family
+		if author.Given != "" {
+			name = strings.TrimPrefix(name+", "+author.Given, ", ")
+		}
+		if name != "" {
+			add(sentence(name)+" ", false)
+		}
+		add(sentence(item.Title), true)
+		var publication []string
+		for _, s := range []string{item.Publisher, year} {
+			if s != "" {
+				publication = append(publication, s)
+			}
+		}
+		if len(publication) != 0 {
+			add(" "+sentence(strings.Join(publication, ", ")), false)
+		}
+	}
+
+	var t, h strings.Builder
+	for _, p := range parts {
+		t.WriteString(p.text)
+		if p.italic {
+			h.WriteString("<i>" + html.EscapeString(p.text) + "</i>")
+		} else {
+			h.WriteString(html.EscapeString(p.text))
+		}
+	}
+	return t.String(), h.String()
+}
+
+// initialedName formats a name with the initials of the given names, e.g.
+// "Lindgren, A." of Astrid Lindgren and "Sartre, J.-P." of Jean-Paul Sartre.
+func initialedName(n CSLName) string {
+	var initials []string
+	for _, given := range strings.Fields(n.Given) {
+		var hyphenated []string
+		for _, part := range strings.Split(given, "-") {
+			if r := []rune(part); len(r) != 0 {
+				hyphenated = append(hyphenated, string(r[0])+".")
+			}
+		}
+		initials = append(initials, strings.Join(hyphenated, "-"))
+	}
+	switch {
+	case n.Family == "":
+		return strings.Join(initials, " ")
+	case len(initials) == 0:
+		return n.Family
+	}
+	return n.Family + ", " + strings.Join(initials, " ")
+}
+
+// sentence ends s with a period unless it already ends with punctuation.
+func sentence(s string) string {
+	if s == "" || strings.ContainsAny(s[len(s)-1:], ".?!") {
+		return s
+	}
+	return s + "."
+}
+
+// GetCitation retrieves the citation of a book in the style of ?style, one
+// of apa, harvard or mla, by default apa. The response has the formatted
+// citation and the CSL-JSON item, which citation managers import.
+func (s *Server) GetCitation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	style := r.URL.Query().Get("style")
+	if style == "" {
+		style = CitationAPA
+	}
+	if style != CitationAPA && style != CitationHarvard && style != CitationMLA {
+		HandleErr(w, http.StatusBadRequest, "style must be one of apa, harvard or mla")
+		return
+	}
+	book := FindPublicBook(s.db, mux.Vars(r)["isbn"], time.Now())
+	if book.ISBN == "" {
+		HandleErr(w, http.StatusNotFound, "The book did not exist in the library")
+		return
+	}
+	c := Citation{Style: style, Item: cslItemFromBook(book)}
+	c.Text, c.HTML = formatCitation(c.Item, style)
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the citation")
+		return
+	}
+}
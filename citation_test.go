@@ -0,0 +1,57 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCitation(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	isbn := "9789129688313"
+	jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: "Pippi Langstrump", PublicationYear: 1945,
+		Author: &Author{FirstName: "Astrid Anna", LastName: "Lindgren"}, Publisher: "raben"})
+	require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+
+	cite := func(style string) Citation {
+		t.Helper()
+		response := createNewRequest(http.MethodGet, "/api/v1/books/"+isbn+"/citation?style="+style, nil, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		var c Citation
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&c))
+		return c
+	}
+
+	t.Run("Formats the styles", func(t *testing.T) {
+		c := cite("")
+		require.Equal(t, CitationAPA, c.Style)
+		require.Equal(t, "Lindgren, A. A. (1945). Pippi Langstrump. raben.", c.Text)
+		require.Equal(t, "Lindgren, A. A. (1945). <i>Pippi Langstrump.</i> raben.", c.HTML)
+		require.Equal(t, "Lindgren, A. A. (1945) Pippi Langstrump. raben.", cite(CitationHarvard).Text)
+		require.Equal(t, "Lindgren, Astrid Anna. Pippi Langstrump. raben, 1945.", cite(CitationMLA).Text)
+	})
+
+	t.Run("Includes the CSL-JSON item", func(t *testing.T) {
+		item := cite(CitationAPA).Item
+		require.Equal(t, "book", item.Type)
+		require.Equal(t, []CSLName{{Family: "Lindgren", Given: "Astrid Anna"}}, item.Author)
+		require.Equal(t, [][]int{{1945}}, item.Issued.DateParts)
+	})
+
+	t.Run("Rejects unknown styles and books", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, createNewRequest(http.MethodGet, "/api/v1/books/"+isbn+"/citation?style=chicago", nil, db).Code)
+		require.Equal(t, http.StatusNotFound, createNewRequest(http.MethodGet, "/api/v1/books/9789129657470/citation", nil, db).Code)
+	})
+}
+
+func TestFormatCitation(t *testing.T) {
+	item := CSLItem{Title: "What Is Literature?", Author: []CSLName{{Family: "Sartre", Given: "Jean-Paul"}}}
+	text, _ := formatCitation(item, CitationAPA)
+	require.Equal(t, "Sartre, J.-P. (n.d.). What Is Literature?", text)
+	text, _ = formatCitation(CSLItem{Title: "Edda", Publisher: "raben"}, CitationHarvard)
+	require.Equal(t, "Edda (no date). raben.", text)
+}
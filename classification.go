@@ -0,0 +1,129 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// The classification systems which a classification can belong to.
+const (
+	ClassificationDewey = "dewey" // Dewey Decimal Classification, e.g. "839.73"
+	ClassificationSAB   = "sab"   // The Swedish SAB system, e.g. "Hc.01"
+)
+
+var (
+	// Three digits for the class, and optionally decimals
+	deweyPattern = regexp.MustCompile(`^\d{3}(\.\d+)?$`)
+	// An upper-case main class and lower-case subdivisions, optionally
+	// prefixed by u for children's books, followed by decimals and the
+	// auxiliary tables for form (-), place (:), language (=) and time (())
+	sabPattern = regexp.MustCompile(`^u?[A-ZÄÖ][a-zåäö]*(\.\d+)*([-:=][a-zåäö0-9.]+|\([a-zåäö0-9.]+\))*$`)
+)
+
+// classificationSystem returns the system of a classification, or "" if it
+// belongs to none of them.
+func classificationSystem(classification string) string {
+	switch {
+	case deweyPattern.MatchString(classification):
+		return ClassificationDewey
+	case sabPattern.MatchString(classification):
+		return ClassificationSAB
+	}
+	return ""
+}
+
+// validateClassification checks that a classification is either a Dewey
+// number or an SAB code.
+func validateClassification(classification string) error {
+	if classification != "" && classificationSystem(classification) == "" {
+		return fmt.Errorf("classification %q is neither a Dewey number nor an SAB code", classification)
+	}
+	return nil
+}
+
+// compareCallNumbers orders call numbers by their classification, and then
+// by the rest of the call number, e.g. the author. Dewey numbers have a
+// fixed number of digits before the decimal point, so comparing them as
+// strings orders them as decimal numbers. Empty call numbers come last.
+func compareCallNumbers(a, b string, compare func(a, b string) int) int {
+	if a == "" || b == "" {
+		return len(b) - len(a)
+	}
+	aParts, bParts := strings.SplitN(a, " ", 2), strings.SplitN(b, " ", 2)
+	if deweyPattern.MatchString(aParts[0]) && deweyPattern.MatchString(bParts[0]) {
+		if aParts[0] != bParts[0] {
+			return strings.Compare(aParts[0], bParts[0])
+		}
+	} else if cmp := compare(aParts[0], bParts[0]); cmp != 0 {
+		return cmp
+	}
+	aRest, bRest := "", ""
+	if len(aParts) == 2 {
+		aRest = aParts[1]
+	}
+	if len(bParts) == 2 {
+		bRest = bParts[1]
+	}
+	return compare(aRest, bRest)
+}
+
+// ClassificationSuggestion is a classification which is used by books
+// related to a new book.
+type ClassificationSuggestion struct {
+	Classification string `json:"classification"`
+	System         string `json:"system"`
+	Books          int    `json:"books"` // The number of related books with the classification
+}
+
+// The maximum number of suggested classifications.
+const maxClassificationSuggestions = 5
+
+// SuggestClassifications returns the classifications of the books by the
+// same author, of the same work or in the same series, the most used first.
+func SuggestClassifications(db Querier, authorLastName, authorFirstName, workID, seriesID string) ([]ClassificationSuggestion, error) {
+	rows, err := db.Query(`SELECT library.classification, COUNT(*) FROM library INNER JOIN author ON library.isbn = author.isbn
+		WHERE library.classification != '' AND (
+			(author.lastName = ? COLLATE NOCASE AND (? = '' OR author.firstName = ? COLLATE NOCASE))
+			OR (? != '' AND library.workId = ?) OR (? != '' AND library.seriesId = ?))
+		GROUP BY library.classification ORDER BY COUNT(*) DESC, library.classification LIMIT ?`,
+		authorLastName, authorFirstName, authorFirstName, workID, workID, seriesID, seriesID, maxClassificationSuggestions)
+	if err != nil {
+		return nil, fmt.Errorf("query classifications err, %w", err)
+	}
+	defer rows.Close()
+	suggestions := []ClassificationSuggestion{}
+	for rows.Next() {
+		var s ClassificationSuggestion
+		if err := rows.Scan(&s.Classification, &s.Books); err != nil {
+			return nil, fmt.Errorf("scan classification err, %w", err)
+		}
+		s.System = classificationSystem(s.Classification)
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
+
+// GetClassificationSuggestions suggests classifications for a new book from
+// the books by the same author, of the same work or in the same series. The
+// author is given by the author and first_name query parameters, and the
+// work and series by their ids.
+func (s *Server) GetClassificationSuggestions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	if query.Get("author") == "" && query.Get("work") == "" && query.Get("series") == "" {
+		HandleErr(w, http.StatusBadRequest, "One of author, work or series must be given")
+		return
+	}
+	suggestions, err := SuggestClassifications(s.db, query.Get("author"), query.Get("first_name"), query.Get("work"), query.Get("series"))
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the classifications")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(suggestions); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the suggestions")
+		return
+	}
+}
@@ -0,0 +1,94 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+)
+
+func TestClassificationSystem(t *testing.T) {
+	for classification, want := range map[string]string{
+		"839.73":     ClassificationDewey,
+		"823":        ClassificationDewey,
+		"Hc":         ClassificationSAB,
+		"Hc.01":      ClassificationSAB,
+		"uHc":        ClassificationSAB,
+		"Oeac-c":     ClassificationSAB,
+		"Hc(x)":      ClassificationSAB,
+		"Ä":          ClassificationSAB,
+		"83":         "",
+		"839.":       "",
+		"hc":         "",
+		"Hc Lindgre": "",
+	} {
+		require.Equal(t, want, classificationSystem(classification), classification)
+	}
+}
+
+func TestSortByCallNumber(t *testing.T) {
+	books := []Book{
+		{ISBN: "1", CallNumber: "839.73 L56"},
+		{ISBN: "2", CallNumber: ""},
+		{ISBN: "3", CallNumber: "823.914 T65"},
+		{ISBN: "4", CallNumber: "839.7 S76"},
+		{ISBN: "5", CallNumber: "823.914 A12"},
+	}
+	sortBooks(books, "callNumber", language.Und)
+	var isbns []string
+	for _, b := range books {
+		isbns = append(isbns, b.ISBN)
+	}
+	require.Equal(t, []string{"5", "3", "4", "1", "2"}, isbns)
+}
+
+func TestClassifications(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	lindgren := &Author{FirstName: "Astrid", LastName: "Lindgren"}
+	for _, b := range []Book{
+		{ISBN: "9789129688313", Title: "Pippi Langstrump", Classification: "Hc", Author: lindgren, Publisher: "raben"},
+		{ISBN: "9789129657470", Title: "Broderna Lejonhjarta", Classification: "Hc", Author: lindgren, Publisher: "raben"},
+		{ISBN: "9789129703788", Title: "Mio min Mio", Classification: "839.73", Author: lindgren, Publisher: "raben"},
+		{ISBN: "9789174293786", Title: "Hobbit", Classification: "823.912",
+			Author: &Author{FirstName: "John", LastName: "Tolkien"}, Publisher: "norstedts"},
+	} {
+		jsonBytes, _ := json.Marshal(b)
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+b.ISBN, jsonBytes, db).Code)
+	}
+
+	t.Run("Rejects unknown classification systems", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(Book{ISBN: "9789129688320", Title: "Emil", Classification: "x.y",
+			Author: lindgren, Publisher: "raben"})
+		response := createNewRequest(http.MethodPost, "/api/v1/books/9789129688320", jsonBytes, db)
+		require.Equal(t, http.StatusNotAcceptable, response.Code)
+		require.Contains(t, response.Body.String(), "classification")
+	})
+
+	t.Run("Sorts the listing by call number", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books?sort=-callNumber", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var books []Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&books))
+		require.Len(t, books, 4)
+		require.Equal(t, "Hc L56", books[0].CallNumber)
+		require.Equal(t, "823.912 T65", books[3].CallNumber)
+	})
+
+	t.Run("Suggests the classifications of the author", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/classifications:suggest?author=lindgren", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var suggestions []ClassificationSuggestion
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&suggestions))
+		require.Equal(t, []ClassificationSuggestion{
+			{Classification: "Hc", System: ClassificationSAB, Books: 2},
+			{Classification: "839.73", System: ClassificationDewey, Books: 1},
+		}, suggestions)
+
+		response = createNewRequest(http.MethodGet, "/api/v1/classifications:suggest", nil, db)
+		require.Equal(t, http.StatusBadRequest, response.Code)
+	})
+}
@@ -0,0 +1,64 @@
+// Command librarydiff compares two library catalogs and prints the books that
+// were added, removed or changed as JSON. Each catalog is either a sqlite
+// database or a JSON export file (the response body of GET /api/books).
+//
+// It exits with status 2 when the catalogs differ, which makes it usable for
+// verifying migrations and replicas in scripts.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	library "github.com/NicolaiMordrup/library"
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	oldPath := flag.String("old", "", "old catalog (sqlite database or .json export)")
+	newPath := flag.String("new", "", "new catalog (sqlite database or .json export)")
+	flag.Parse()
+	if *oldPath == "" || *newPath == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	oldBooks, err := loadCatalog(*oldPath)
+	check(err, "failed to load old catalog")
+	newBooks, err := loadCatalog(*newPath)
+	check(err, "failed to load new catalog")
+
+	diff := library.DiffCatalogs(oldBooks, newBooks)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	check(enc.Encode(diff), "failed to encode diff")
+	if !diff.Empty() {
+		os.Exit(2)
+	}
+}
+
+// loadCatalog reads all books from either a JSON export or a sqlite database.
+func loadCatalog(path string) ([]library.Book, error) {
+	if strings.HasSuffix(path, ".json") {
+		return library.ReadExportFile(path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := library.NewDB(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return library.ReadDatabaseList(db), nil
+}
+
+func check(err error, msg string) {
+	if err != nil {
+		fmt.Printf("%v, err: %v\n", msg, err)
+		os.Exit(1)
+	}
+}
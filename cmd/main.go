@@ -1,13 +1,27 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	library "github.com/NicolaiMordrup/library"
+	"github.com/NicolaiMordrup/library/alerts"
+	"github.com/NicolaiMordrup/library/antivirus"
+	"github.com/NicolaiMordrup/library/blobs"
+	"github.com/NicolaiMordrup/library/events"
+	"github.com/NicolaiMordrup/library/ids"
+	"github.com/NicolaiMordrup/library/kms"
+	"github.com/NicolaiMordrup/library/marc"
+	"github.com/NicolaiMordrup/library/notifications"
+	"github.com/NicolaiMordrup/library/oidc"
+	"github.com/NicolaiMordrup/library/opensearch"
 	"go.uber.org/zap"
+	"golang.org/x/text/language"
 	_ "modernc.org/sqlite"
 )
 
@@ -27,28 +41,412 @@ func main() {
 	}
 	minDurationBetweenUpdates, err := time.ParseDuration(minDurationBetweenUpdatesStr)
 	check(err, "failed to parse min duration between updates")
-	_ = minDurationBetweenUpdates
+	localeStr := "und"
+	if envVal := os.Getenv("COLLATION_LOCALE"); envVal != "" {
+		localeStr = envVal
+	}
+	locale, err := language.Parse(localeStr)
+	check(err, "failed to parse collation locale")
+	callNumberSchemeStr := "cutter"
+	if envVal := os.Getenv("CALL_NUMBER_SCHEME"); envVal != "" {
+		callNumberSchemeStr = envVal
+	}
+	callNumberScheme, err := library.CallNumberSchemeByName(callNumberSchemeStr)
+	check(err, "failed to parse call number scheme")
+	// Comma-separated SRU servers used for copy cataloging
+	var catalogSources []library.CatalogSource
+	if envVal := os.Getenv("COPY_CATALOG_SRU_URLS"); envVal != "" {
+		for _, u := range strings.Split(envVal, ",") {
+			catalogSources = append(catalogSources, marc.NewSRUClient(strings.TrimSpace(u)))
+		}
+	}
+
+	idStrategy := "uuidv7"
+	if envVal := os.Getenv("ID_STRATEGY"); envVal != "" {
+		idStrategy = envVal
+	}
+	var snowflakeNode int64
+	if envVal := os.Getenv("SNOWFLAKE_NODE_ID"); envVal != "" {
+		snowflakeNode, err = strconv.ParseInt(envVal, 10, 64)
+		check(err, "failed to parse snowflake node id")
+	}
+	idGenerator, err := ids.ByName(idStrategy, snowflakeNode)
+	check(err, "failed to create id generator")
+	oaiRepository := library.OAIRepository{
+		Name:       "Library",
+		Identifier: "library",
+		AdminEmail: "admin@localhost",
+	}
+	if envVal := os.Getenv("OAI_REPOSITORY_NAME"); envVal != "" {
+		oaiRepository.Name = envVal
+	}
+	if envVal := os.Getenv("OAI_REPOSITORY_IDENTIFIER"); envVal != "" {
+		oaiRepository.Identifier = envVal
+	}
+	if envVal := os.Getenv("OAI_ADMIN_EMAIL"); envVal != "" {
+		oaiRepository.AdminEmail = envVal
+	}
 
 	// Setup logger
 	structuredLogger, _ := zap.NewProduction()
 	log := structuredLogger.Sugar()
 
+	// Alert the operators through the configured hooks
+	var alertOpts []alerts.Option
+	if envVal := os.Getenv("ALERT_WEBHOOK_URL"); envVal != "" {
+		alertOpts = append(alertOpts, alerts.WithHook(alerts.WebhookHook{URL: envVal}))
+	}
+	if envVal := os.Getenv("ALERT_SLACK_WEBHOOK_URL"); envVal != "" {
+		alertOpts = append(alertOpts, alerts.WithHook(alerts.SlackHook{WebhookURL: envVal}))
+	}
+	if envVal := os.Getenv("ALERT_PAGERDUTY_ROUTING_KEY"); envVal != "" {
+		alertOpts = append(alertOpts, alerts.WithHook(alerts.PagerDutyHook{RoutingKey: envVal}))
+	}
+	if envVal := os.Getenv("ALERT_CONDITIONS"); envVal != "" {
+		alertOpts = append(alertOpts, alerts.WithConditions(strings.Split(envVal, ",")...))
+	}
+	alerter := alerts.New(alertOpts...)
+
 	// Connect to database
 	// Note(sn): add storage constructor stuff here
 	// Note(sn): add logger to database (call it log)
 	db, err := library.NewDB(connstr)
 	check(err, "failed to open sqlite connection")
-	check(library.EnsureSchema(db), "migration failed")
+	if err := library.EnsureSchema(db); err != nil {
+		fireErr := alerter.Fire(context.Background(), alerts.Alert{
+			Condition: alerts.ConditionMigrationFailure,
+			Severity:  alerts.SeverityCritical,
+			Summary:   "Database migration failed, the server did not start",
+			Details:   err.Error(),
+		})
+		if fireErr != nil {
+			log.Errorw("failed to fire alert", "err", fireErr)
+		}
+		check(err, "migration failed")
+	}
+
+	// Send queued notifications if an SMTP server is configured
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		sender := notifications.NewSMTPSender(smtpAddr, os.Getenv("SMTP_FROM"),
+			os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+		queue := notifications.NewQueue(db, notifications.WithErrorHandler(func(err error) {
+			fireErr := alerter.Fire(context.Background(), alerts.Alert{
+				Condition: alerts.ConditionJobFailure,
+				Summary:   "Failed to process the notification deliveries",
+				Details:   err.Error(),
+			})
+			if fireErr != nil {
+				log.Errorw("failed to fire alert", "err", fireErr)
+			}
+		}))
+		go queue.Run(context.Background(), sender, time.Minute)
+	}
+
+	// Take scheduled backups if a backup directory is configured
+	if backupDir := os.Getenv("BACKUP_DIR"); backupDir != "" {
+		schedule := library.BackupSchedule{Dir: backupDir, Interval: 24 * time.Hour}
+		if envVal := os.Getenv("BACKUP_INTERVAL"); envVal != "" {
+			schedule.Interval, err = time.ParseDuration(envVal)
+			check(err, "failed to parse backup interval")
+		}
+		if envVal := os.Getenv("BACKUP_KEEP"); envVal != "" {
+			schedule.Keep, err = strconv.Atoi(envVal)
+			check(err, "failed to parse the number of backups to keep")
+		}
+		schedule.OnError = func(err error) {
+			fireErr := alerter.Fire(context.Background(), alerts.Alert{
+				Condition: alerts.ConditionBackupStale,
+				Summary:   "Failed to take a scheduled backup",
+				Details:   err.Error(),
+			})
+			if fireErr != nil {
+				log.Errorw("failed to fire alert", "err", fireErr)
+			}
+		}
+		go schedule.Run(context.Background(), db)
+	}
+
+	// Mirror the books into OpenSearch and search there if it is configured
+	var searchBackend library.SearchBackend
+	if searchURL := os.Getenv("OPENSEARCH_URL"); searchURL != "" {
+		index := "books"
+		if envVal := os.Getenv("OPENSEARCH_INDEX"); envVal != "" {
+			index = envVal
+		}
+		searchBackend = opensearch.NewClient(searchURL, index)
+		indexer := library.SearchIndexer{Backend: searchBackend, Interval: 10 * time.Second}
+		if envVal := os.Getenv("SEARCH_INDEX_INTERVAL"); envVal != "" {
+			indexer.Interval, err = time.ParseDuration(envVal)
+			check(err, "failed to parse search index interval")
+		}
+		indexer.OnError = func(err error) {
+			fireErr := alerter.Fire(context.Background(), alerts.Alert{
+				Condition: alerts.ConditionJobFailure,
+				Summary:   "Failed to send the book changes to the search backend",
+				Details:   err.Error(),
+			})
+			if fireErr != nil {
+				log.Errorw("failed to fire alert", "err", fireErr)
+			}
+		}
+		go indexer.Run(context.Background(), db)
+	}
+
+	// Publish the book changes to NATS if it is configured
+	var natsPublisher *events.NATSPublisher
+	if natsURL := os.Getenv("NATS_URL"); natsURL != "" {
+		subjectPrefix := "library"
+		if envVal := os.Getenv("NATS_SUBJECT_PREFIX"); envVal != "" {
+			subjectPrefix = envVal
+		}
+		natsPublisher, err = events.NewNATSPublisher(natsURL, subjectPrefix)
+		check(err, "failed to parse NATS URL")
+		dispatcher := library.OutboxDispatcher{Publisher: natsPublisher, Interval: time.Second}
+		if envVal := os.Getenv("EVENT_OUTBOX_INTERVAL"); envVal != "" {
+			dispatcher.Interval, err = time.ParseDuration(envVal)
+			check(err, "failed to parse event outbox interval")
+		}
+		dispatcher.OnError = func(err error) {
+			fireErr := alerter.Fire(context.Background(), alerts.Alert{
+				Condition: alerts.ConditionJobFailure,
+				Summary:   "Failed to publish the book events",
+				Details:   err.Error(),
+			})
+			if fireErr != nil {
+				log.Errorw("failed to fire alert", "err", fireErr)
+			}
+		}
+		go dispatcher.Run(context.Background(), db)
+	}
 
 	// Initialize and start server
-	// Note(sn): add min duration to server constructor
 	// Note(sn): add logger to server
-	myServer := library.NewServer(db)
+	serverOpts := []library.ServerOption{
+		library.WithLocale(locale),
+		library.WithCallNumberScheme(callNumberScheme),
+		library.WithCatalogSources(catalogSources...),
+		library.WithOAIRepository(oaiRepository),
+		library.WithIDGenerator(idGenerator),
+		library.WithMinDurationBetweenUpdates(minDurationBetweenUpdates),
+	}
+	if searchBackend != nil {
+		serverOpts = append(serverOpts, library.WithSearchBackend(searchBackend))
+	}
+	if natsPublisher != nil {
+		serverOpts = append(serverOpts, library.WithEventOutbox())
+	}
+	// Log in members through an OpenID Connect provider, with the roles of
+	// the groups in OIDC_GROUP_ROLES, e.g. librarians=librarian,admins=admin
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		login := library.OIDCLogin{
+			Provider: oidc.NewProvider(issuer, os.Getenv("OIDC_CLIENT_ID"),
+				os.Getenv("OIDC_CLIENT_SECRET"), os.Getenv("OIDC_REDIRECT_URL")),
+			GroupsClaim: os.Getenv("OIDC_GROUPS_CLAIM"),
+			GroupRoles:  make(map[string]string),
+		}
+		if envVal := os.Getenv("OIDC_GROUP_ROLES"); envVal != "" {
+			for _, pair := range strings.Split(envVal, ",") {
+				parts := strings.SplitN(pair, "=", 2)
+				if len(parts) != 2 {
+					check(fmt.Errorf("%q is not group=role", pair), "failed to parse OIDC group roles")
+				}
+				login.GroupRoles[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			}
+		}
+		serverOpts = append(serverOpts, library.WithOIDCLogin(login))
+	}
+	// The key which signs the session cookies, so that the browsers stay
+	// logged in when the server restarts or runs in several instances
+	if envVal := os.Getenv("SESSION_KEY"); envVal != "" {
+		serverOpts = append(serverOpts, library.WithSessionKey([]byte(envVal)))
+	}
+	// Session timeouts, e.g. SESSION_IDLE_TIMEOUT=24h
+	sessionTimeouts := library.SessionTimeouts{Idle: 24 * time.Hour, Absolute: 30 * 24 * time.Hour}
+	for env, d := range map[string]*time.Duration{
+		"SESSION_IDLE_TIMEOUT":     &sessionTimeouts.Idle,
+		"SESSION_ABSOLUTE_TIMEOUT": &sessionTimeouts.Absolute,
+	} {
+		if envVal := os.Getenv(env); envVal != "" {
+			*d, err = time.ParseDuration(envVal)
+			check(err, "failed to parse "+strings.ToLower(env))
+		}
+	}
+	serverOpts = append(serverOpts, library.WithSessionTimeouts(sessionTimeouts))
+	// Lockout after failed logins, e.g. LOCKOUT_ACCOUNT_FAILURES=5
+	lockout := library.LockoutPolicy{AccountFailures: 5, IPFailures: 20,
+		BaseDelay: time.Minute, MaxDelay: 24 * time.Hour, ResetAfter: 24 * time.Hour}
+	for env, n := range map[string]*int{
+		"LOCKOUT_ACCOUNT_FAILURES": &lockout.AccountFailures,
+		"LOCKOUT_IP_FAILURES":      &lockout.IPFailures,
+	} {
+		if envVal := os.Getenv(env); envVal != "" {
+			*n, err = strconv.Atoi(envVal)
+			check(err, "failed to parse "+strings.ToLower(env))
+		}
+	}
+	for env, d := range map[string]*time.Duration{
+		"LOCKOUT_BASE_DELAY": &lockout.BaseDelay,
+		"LOCKOUT_MAX_DELAY":  &lockout.MaxDelay,
+	} {
+		if envVal := os.Getenv(env); envVal != "" {
+			*d, err = time.ParseDuration(envVal)
+			check(err, "failed to parse "+strings.ToLower(env))
+		}
+	}
+	serverOpts = append(serverOpts, library.WithLockoutPolicy(lockout))
+	// The key encryption key of the secrets and the personal data in the
+	// database, in a key file (32 bytes in base64) or in Vault
+	if path := os.Getenv("ENCRYPTION_KEY_FILE"); path != "" {
+		kek, err := kms.ReadKeyFile(path)
+		check(err, "failed to read encryption key file")
+		serverOpts = append(serverOpts, library.WithKeyEncrypter(kek))
+	} else if key := os.Getenv("VAULT_TRANSIT_KEY"); key != "" {
+		serverOpts = append(serverOpts, library.WithKeyEncrypter(&kms.VaultTransit{
+			URL:   os.Getenv("VAULT_ADDR"),
+			Mount: os.Getenv("VAULT_TRANSIT_MOUNT"),
+			Key:   key,
+			Token: os.Getenv("VAULT_TOKEN"),
+		}))
+	}
+	// The roles which must log in with a TOTP code, e.g. librarian,admin
+	if envVal := os.Getenv("TWO_FACTOR_ROLES"); envVal != "" {
+		serverOpts = append(serverOpts, library.WithTwoFactorRoles(strings.Split(envVal, ",")...))
+	}
+	// Keep the latest failed requests for debugging
+	if envVal := os.Getenv("CAPTURE_FAILED_REQUESTS"); envVal != "" {
+		size, err := strconv.Atoi(envVal)
+		check(err, "failed to parse the number of failed requests to capture")
+		serverOpts = append(serverOpts, library.WithFailureCapture(size))
+	}
+	if envVal := os.Getenv("MAX_REQUEST_BODY_BYTES"); envVal != "" {
+		n, err := strconv.ParseInt(envVal, 10, 64)
+		check(err, "failed to parse the maximum request body size")
+		serverOpts = append(serverOpts, library.WithMaxBodySize(n))
+	}
+	// Request timeouts, e.g. READ_TIMEOUT=2s
+	timeouts := library.Timeouts{Read: 2 * time.Second, Write: 10 * time.Second, Import: time.Minute}
+	for env, d := range map[string]*time.Duration{
+		"READ_TIMEOUT":   &timeouts.Read,
+		"WRITE_TIMEOUT":  &timeouts.Write,
+		"IMPORT_TIMEOUT": &timeouts.Import,
+	} {
+		if envVal := os.Getenv(env); envVal != "" {
+			*d, err = time.ParseDuration(envVal)
+			check(err, "failed to parse "+strings.ToLower(env))
+		}
+	}
+	serverOpts = append(serverOpts, library.WithTimeouts(timeouts))
+	// How long deletes and batches can be undone, e.g. UNDO_WINDOW=1h
+	if envVal := os.Getenv("UNDO_WINDOW"); envVal != "" {
+		undoWindow, err := time.ParseDuration(envVal)
+		check(err, "failed to parse undo window")
+		serverOpts = append(serverOpts, library.WithUndoWindow(undoWindow))
+	}
+	// Scan the uploads with clamd, e.g. CLAMD_ADDRESS=localhost:3310 or the
+	// path of its socket
+	if envVal := os.Getenv("CLAMD_ADDRESS"); envVal != "" {
+		scanner := &antivirus.Clamd{Address: envVal}
+		if strings.HasPrefix(envVal, "/") {
+			scanner.Network = "unix"
+		}
+		serverOpts = append(serverOpts, library.WithScanner(scanner))
+	}
+	// The directory of the uploaded e-book files of the digital loans
+	if envVal := os.Getenv("EBOOK_DIR"); envVal != "" {
+		serverOpts = append(serverOpts, library.WithEbookDir(envVal))
+	}
+	// The files attached to books are stored in an S3 bucket, e.g. of MinIO,
+	// or in a directory
+	if bucket := os.Getenv("S3_BUCKET"); bucket != "" {
+		blobStore := &blobs.S3{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          bucket,
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Encryption:      os.Getenv("S3_SERVER_SIDE_ENCRYPTION"),
+			KMSKeyID:        os.Getenv("S3_KMS_KEY_ID"),
+		}
+		if envVal := os.Getenv("S3_PART_SIZE"); envVal != "" {
+			blobStore.PartSize, err = strconv.ParseInt(envVal, 10, 64)
+			check(err, "failed to parse S3 part size")
+		}
+		serverOpts = append(serverOpts, library.WithBlobStore(blobStore))
+	} else if envVal := os.Getenv("BLOB_DIR"); envVal != "" {
+		blobStore, err := blobs.NewDir(envVal)
+		check(err, "failed to create blob store")
+		serverOpts = append(serverOpts, library.WithBlobStore(blobStore))
+	}
+	// The readiness probe fails until the warmup is done
+	warmup := os.Getenv("WARMUP") == "true"
+	if warmup {
+		serverOpts = append(serverOpts, library.WithWarmup())
+	}
+	myServer := library.NewServer(db, serverOpts...)
+	if warmup {
+		go func() {
+			start := time.Now()
+			if err := myServer.Warmup(context.Background()); err != nil {
+				log.Errorw("warmup failed", "err", err)
+				return
+			}
+			log.Infow("warmup done", "duration", time.Since(start))
+		}()
+	}
+	// Apply the book events of an upstream catalogue if it is configured
+	if catalogueURL := os.Getenv("CATALOGUE_NATS_URL"); catalogueURL != "" {
+		subject := "catalogue.book.*"
+		if envVal := os.Getenv("CATALOGUE_SUBJECT"); envVal != "" {
+			subject = envVal
+		}
+		subscriber, err := events.NewNATSSubscriber(catalogueURL, subject)
+		check(err, "failed to parse catalogue NATS URL")
+		consumer := library.CatalogueConsumer{Subscriber: subscriber, Source: os.Getenv("CATALOGUE_SOURCE")}
+		consumer.OnError = func(err error) {
+			log.Errorw("failed to apply the catalogue events", "err", err)
+			fireErr := alerter.Fire(context.Background(), alerts.Alert{
+				Condition: alerts.ConditionReplicaLag,
+				Summary:   "Failed to apply the events of the upstream catalogue",
+				Details:   err.Error(),
+			})
+			if fireErr != nil {
+				log.Errorw("failed to fire alert", "err", fireErr)
+			}
+		}
+		go consumer.Run(context.Background(), myServer)
+	}
+	// Publish the drafts which are scheduled for publication
+	scheduler := library.PublicationScheduler{Interval: time.Minute}
+	if envVal := os.Getenv("PUBLICATION_INTERVAL"); envVal != "" {
+		scheduler.Interval, err = time.ParseDuration(envVal)
+		check(err, "failed to parse publication interval")
+	}
+	scheduler.OnError = func(err error) {
+		fireErr := alerter.Fire(context.Background(), alerts.Alert{
+			Condition: alerts.ConditionJobFailure,
+			Summary:   "Failed to publish the scheduled drafts",
+			Details:   err.Error(),
+		})
+		if fireErr != nil {
+			log.Errorw("failed to fire alert", "err", fireErr)
+		}
+	}
+	go scheduler.Run(context.Background(), myServer)
+	var handler http.Handler = myServer
+	// Host several libraries, each with a database in TENANTS_DIR, instead
+	// of the library in SQLITE_DB_CONN. The tenants are managed with
+	// TENANT_ADMIN_TOKEN as the bearer token. The tenants do not use the
+	// search backend or the event outbox, and keep their files apart
+	if tenantsDir := os.Getenv("TENANTS_DIR"); tenantsDir != "" {
+		tenants, err := library.NewTenants(tenantsDir, os.Getenv("TENANT_DOMAIN"), os.Getenv("TENANT_ADMIN_TOKEN"), serverOpts...)
+		check(err, "failed to open the tenants")
+		handler = tenants
+	}
 	addr := fmt.Sprintf(":%v", portStr)
 	log.Infow("starting server",
 		"addr", addr,
 	)
-	log.Fatal(http.ListenAndServe(addr, myServer))
+	log.Fatal(http.ListenAndServe(addr, handler))
 }
 
 func check(err error, msg string) {
@@ -0,0 +1,68 @@
+// Command rotatekeys re-encrypts the secrets and the personal data of a
+// library database with a new data key. It also encrypts the fields which
+// were stored before encryption was enabled.
+//
+// To change the key encryption key, pass the current one with -old-key-file
+// (or -old-vault-key) and the new one with -key-file (or -vault-key), and
+// restart the servers with the new one. Vault is reached through VAULT_ADDR
+// and VAULT_TOKEN.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	library "github.com/NicolaiMordrup/library"
+	"github.com/NicolaiMordrup/library/kms"
+	_ "modernc.org/sqlite"
+)
+
+func main() {
+	dbPath := flag.String("db", "", "sqlite database")
+	keyFile := flag.String("key-file", "", "file with the key encryption key")
+	vaultKey := flag.String("vault-key", "", "name of the key encryption key in the transit engine of Vault")
+	oldKeyFile := flag.String("old-key-file", "", "file with the current key encryption key, if it changes")
+	oldVaultKey := flag.String("old-vault-key", "", "name of the current key encryption key in Vault, if it changes")
+	flag.Parse()
+	if *dbPath == "" || (*keyFile == "") == (*vaultKey == "") {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	newKEK, err := keyEncrypter(*keyFile, *vaultKey)
+	check(err, "failed to read the key encryption key")
+	oldKEK := newKEK
+	if *oldKeyFile != "" || *oldVaultKey != "" {
+		oldKEK, err = keyEncrypter(*oldKeyFile, *oldVaultKey)
+		check(err, "failed to read the current key encryption key")
+	}
+
+	db, err := library.NewDB(*dbPath)
+	check(err, "failed to open the database")
+	defer db.Close()
+	n, err := library.RotateKeys(context.Background(), db, oldKEK, newKEK)
+	check(err, "failed to rotate the keys")
+	fmt.Printf("re-encrypted %d rows\n", n)
+}
+
+// keyEncrypter returns the key encryption key of a key file or Vault.
+func keyEncrypter(keyFile, vaultKey string) (kms.KeyEncrypter, error) {
+	if keyFile != "" {
+		return kms.ReadKeyFile(keyFile)
+	}
+	return &kms.VaultTransit{
+		URL:   os.Getenv("VAULT_ADDR"),
+		Mount: os.Getenv("VAULT_TRANSIT_MOUNT"),
+		Key:   vaultKey,
+		Token: os.Getenv("VAULT_TOKEN"),
+	}, nil
+}
+
+func check(err error, msg string) {
+	if err != nil {
+		fmt.Printf("%v, err: %v\n", msg, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+package library
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// bookSortKeys are the supported values of the sort query parameter. A
+// leading "-" sorts in descending order.
+var bookSortKeys = map[string]bool{"title": true, "author": true, "callNumber": true}
+
+// validSortKey reports whether key is a supported sort order.
+func validSortKey(key string) bool {
+	return bookSortKeys[strings.TrimPrefix(key, "-")]
+}
+
+// collationLocale returns the locale whose collation rules sort a listing.
+// A listing filtered by language is sorted by the rules of that language,
+// e.g. ?language=sv sorts Å, Ä and Ö last even if the server locale is
+// English.
+func (s *Server) collationLocale(filter language.Tag) language.Tag {
+	if filter != language.Und {
+		return filter
+	}
+	return s.locale
+}
+
+// sortBooks sorts the books by title, by author (last name, then first
+// name) or by call number using the collation rules of the given locale, so
+// that for example Swedish titles starting with Å, Ä and Ö end up last.
+func sortBooks(books []Book, key string, locale language.Tag) {
+	// A collator keeps internal buffers, so it can not be shared between
+	// requests
+	c := collate.New(locale, collate.Loose)
+	descending := strings.HasPrefix(key, "-")
+	key = strings.TrimPrefix(key, "-")
+
+	compare := func(a, b Book) int {
+		if key == "author" {
+			var aAuthor, bAuthor Author
+			if a.Author != nil {
+				aAuthor = *a.Author
+			}
+			if b.Author != nil {
+				bAuthor = *b.Author
+			}
+			if cmp := c.CompareString(aAuthor.LastName, bAuthor.LastName); cmp != 0 {
+				return cmp
+			}
+			return c.CompareString(aAuthor.FirstName, bAuthor.FirstName)
+		}
+		if key == "callNumber" {
+			return compareCallNumbers(a.CallNumber, b.CallNumber, c.CompareString)
+		}
+		return c.CompareString(a.Title, b.Title)
+	}
+	sort.SliceStable(books, func(i, j int) bool {
+		if descending {
+			return compare(books[i], books[j]) > 0
+		}
+		return compare(books[i], books[j]) < 0
+	})
+}
@@ -0,0 +1,47 @@
+package library
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultMinDurationBetweenUpdates is the default cooldown between two
+// updates of a book.
+const defaultMinDurationBetweenUpdates = 10 * time.Second
+
+// WithMinDurationBetweenUpdates sets the cooldown between two updates of a
+// book, updates during the cooldown are rejected with 425 Too Early. The
+// default is 10 seconds.
+func WithMinDurationBetweenUpdates(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.minDurationBetweenUpdates = d
+	}
+}
+
+// claimUpdate records that the book with the given isbn is updated at now,
+// unless it was updated less than cooldown ago. The check and the write are
+// a single statement, so that the cooldown holds also when several
+// processes update the same database. It reports whether the update was
+// claimed.
+func claimUpdate(db Querier, isbn string, now time.Time, cooldown time.Duration) (bool, error) {
+	res, err := db.Exec("INSERT INTO book_update_claim (isbn, updateTime) VALUES(?1, ?2) "+
+		"ON CONFLICT (isbn) DO UPDATE SET updateTime = ?2 WHERE updateTime <= ?3",
+		isbn, now.UnixMilli(), now.Add(-cooldown).UnixMilli())
+	if err != nil {
+		return false, fmt.Errorf("claim update err, %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("claim update err, %w", err)
+	}
+	return n == 1, nil
+}
+
+// releaseUpdateClaim forgets the latest update of a deleted book, so that a
+// book created again with the same isbn can be updated right away.
+func releaseUpdateClaim(db Querier, isbn string) error {
+	if _, err := db.Exec("DELETE FROM book_update_claim WHERE isbn = ?", isbn); err != nil {
+		return fmt.Errorf("release update claim err, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package library
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimUpdate(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	isbn := "1233211233215"
+	now := time.Now()
+
+	claimed, err := claimUpdate(db, isbn, now, 10*time.Second)
+	require.NoError(t, err)
+	require.True(t, claimed, "the first update should be claimed")
+
+	claimed, err = claimUpdate(db, isbn, now.Add(5*time.Second), 10*time.Second)
+	require.NoError(t, err)
+	require.False(t, claimed, "an update during the cooldown should not be claimed")
+
+	claimed, err = claimUpdate(db, isbn, now.Add(10*time.Second), 10*time.Second)
+	require.NoError(t, err)
+	require.True(t, claimed, "an update after the cooldown should be claimed")
+
+	require.NoError(t, releaseUpdateClaim(db, isbn))
+	claimed, err = claimUpdate(db, isbn, now.Add(11*time.Second), 10*time.Second)
+	require.NoError(t, err)
+	require.True(t, claimed, "an update of a deleted book should be claimed")
+}
@@ -0,0 +1,160 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/NicolaiMordrup/library/marc"
+	"golang.org/x/text/language"
+)
+
+// CatalogSource looks up the bibliographic record of an ISBN, e.g. a
+// marc.SRUClient.
+type CatalogSource interface {
+	FindByISBN(ctx context.Context, isbn string) (marc.Record, error)
+}
+
+// catalogSourceTimeout limits the time spent on copy cataloging while a book
+// is created.
+const catalogSourceTimeout = 5 * time.Second
+
+// WithCatalogSources sets the sources which are queried, in order, to
+// pre-fill the fields which are missing from a book when it is created.
+func WithCatalogSources(sources ...CatalogSource) ServerOption {
+	return func(s *Server) {
+		s.catalogSources = sources
+	}
+}
+
+// prefillBook fills the empty fields of the book from the first catalog
+// source which has a record of it. The book is returned unchanged if no
+// source has a record.
+func (s *Server) prefillBook(ctx context.Context, book Book) Book {
+	if len(s.catalogSources) == 0 || isCataloged(book) {
+		return book
+	}
+	ctx, cancel := context.WithTimeout(ctx, catalogSourceTimeout)
+	defer cancel()
+	for _, source := range s.catalogSources {
+		rec, err := source.FindByISBN(ctx, book.ISBN)
+		if errors.Is(err, marc.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("copy cataloging: failed to look up %s, %v\n", book.ISBN, err)
+			continue
+		}
+		return mergeBooks(book, bookFromMARC(rec))
+	}
+	return book
+}
+
+// isCataloged reports whether the fields which can be copied from a MARC
+// record are already set.
+func isCataloged(b Book) bool {
+	return b.Title != "" && b.Publisher != "" && b.Classification != "" &&
+		b.Author != nil && b.Author.FirstName != "" && b.Author.LastName != ""
+}
+
+// mergeBooks sets the empty fields of b to the values of the copied book.
+func mergeBooks(b, copied Book) Book {
+	if b.Title == "" {
+		b.Title = copied.Title
+	}
+	if b.Publisher == "" {
+		b.Publisher = copied.Publisher
+	}
+	if b.Classification == "" {
+		b.Classification = copied.Classification
+	}
+	if b.Description == "" {
+		b.Description = copied.Description
+	}
+	if b.PageCount == 0 {
+		b.PageCount = copied.PageCount
+	}
+	if b.Language == "" {
+		b.Language = copied.Language
+	}
+	if b.PublicationYear == 0 {
+		b.PublicationYear = copied.PublicationYear
+	}
+	if copied.Author != nil {
+		author := Author{}
+		if b.Author != nil {
+			author = *b.Author
+		}
+		if author.FirstName == "" {
+			author.FirstName = copied.Author.FirstName
+		}
+		if author.LastName == "" {
+			author.LastName = copied.Author.LastName
+		}
+		b.Author = &author
+	}
+	return b
+}
+
+// bookFromMARC maps a MARC 21 bibliographic record to a book.
+func bookFromMARC(rec marc.Record) Book {
+	var b Book
+	title := marc.TrimPunctuation(rec.Subfield("245", "a"))
+	if subtitle := marc.TrimPunctuation(rec.Subfield("245", "b")); subtitle != "" {
+		title += ": " + subtitle
+	}
+	b.Title = title
+
+	// Main entry personal names are inverted, e.g. "Lindgren, Astrid, 1907-2002."
+	if name := rec.Subfield("100", "a"); name != "" {
+		parts := strings.SplitN(marc.TrimPunctuation(name), ",", 2)
+		b.Author = &Author{LastName: strings.TrimSpace(parts[0])}
+		if len(parts) == 2 {
+			b.Author.FirstName = marc.TrimPunctuation(parts[1])
+		}
+	}
+
+	// RDA records put the publisher in 264, older records in 260
+	b.Publisher = marc.TrimPunctuation(rec.Subfield("264", "b"))
+	if b.Publisher == "" {
+		b.Publisher = marc.TrimPunctuation(rec.Subfield("260", "b"))
+	}
+
+	// Dewey numbers are segmented with slashes, e.g. "839.73/7"
+	b.Classification = strings.ReplaceAll(marc.TrimPunctuation(rec.Subfield("082", "a")), "/", "")
+
+	b.Description = strings.TrimSpace(rec.Subfield("520", "a"))
+	// The extent is free text, e.g. "352 p. :" or "xii, 352 pages"
+	b.PageCount = lastNumber(rec.Subfield("300", "a"))
+
+	// The fixed length data elements hold the date of publication in
+	// positions 7-10 and the MARC language code in positions 35-37
+	fixed := rec.Control("008")
+	year := rec.Subfield("264", "c")
+	if year == "" {
+		year = rec.Subfield("260", "c")
+	}
+	if year == "" && len(fixed) >= 11 {
+		year = fixed[7:11]
+	}
+	b.PublicationYear = lastNumber(year)
+	if len(fixed) >= 38 {
+		if tag, err := language.ParseBase(fixed[35:38]); err == nil {
+			b.Language = tag.String()
+		}
+	}
+	return b
+}
+
+// lastNumber returns the last number in s, or 0 if there is none.
+func lastNumber(s string) int {
+	n := 0
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsDigit(r) }) {
+		n, _ = strconv.Atoi(field)
+	}
+	return n
+}
@@ -0,0 +1,73 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/marc"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCatalogSource map[string]marc.Record
+
+func (s fakeCatalogSource) FindByISBN(_ context.Context, isbn string) (marc.Record, error) {
+	rec, ok := s[isbn]
+	if !ok {
+		return marc.Record{}, marc.ErrNotFound
+	}
+	return rec, nil
+}
+
+func TestCopyCataloging(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	source := fakeCatalogSource{
+		"1233211233215": {ControlFields: []marc.ControlField{
+			{Tag: "008", Value: "950308s1945    sw a          000 1 swe  "},
+		}, DataFields: []marc.DataField{
+			{Tag: "082", Subfields: []marc.Subfield{{Code: "a", Value: "839.73/7"}}},
+			{Tag: "100", Subfields: []marc.Subfield{{Code: "a", Value: "Lindgren, Astrid,"}}},
+			{Tag: "245", Subfields: []marc.Subfield{{Code: "a", Value: "Pippi Longstocking /"}}},
+			{Tag: "264", Subfields: []marc.Subfield{{Code: "b", Value: "Raben,"}, {Code: "c", Value: "[2015]"}}},
+			{Tag: "300", Subfields: []marc.Subfield{{Code: "a", Value: "223 sidor :"}}},
+			{Tag: "520", Subfields: []marc.Subfield{{Code: "a", Value: "The strongest girl in the world."}}},
+		}},
+	}
+	create := func(book Book) *httptest.ResponseRecorder {
+		jsonBytes, _ := json.Marshal(book)
+		request, _ := http.NewRequest(http.MethodPost, "/api/v1/books/"+book.ISBN,
+			bytes.NewReader(jsonBytes))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		NewServer(db, WithCatalogSources(source)).ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("Pre-fills the missing fields from the record", func(t *testing.T) {
+		response := create(Book{ISBN: "1233211233215", Title: "pippi"})
+		require.Equal(t, http.StatusOK, response.Code)
+
+		var got Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Equal(t, "pippi", got.Title)
+		require.Equal(t, &Author{FirstName: "Astrid", LastName: "Lindgren"}, got.Author)
+		require.Equal(t, "Raben", got.Publisher)
+		require.Equal(t, "839.737", got.Classification)
+		require.Equal(t, "839.737 L56", got.CallNumber)
+		require.Equal(t, "The strongest girl in the world.", got.Description)
+		require.Equal(t, 223, got.PageCount)
+		require.Equal(t, 2015, got.PublicationYear)
+		require.Equal(t, "sv", got.Language)
+	})
+
+	t.Run("Validates books without a record as usual", func(t *testing.T) {
+		response := create(Book{ISBN: "1233211233213", Title: "star wars"})
+		assertStatus(t, response.Code, http.StatusNotAcceptable, "Should get status "+
+			"code 406: status not acceptable")
+	})
+}
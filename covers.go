@@ -0,0 +1,258 @@
+package library
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NicolaiMordrup/library/blobs"
+	"github.com/gorilla/mux"
+)
+
+// maxCoverBytes limits the size of uploaded cover images.
+const maxCoverBytes = 20 << 20
+
+// maxCoverPixels limits the size of decoded cover images, since a small
+// file can decode to a huge image.
+const maxCoverPixels = 50_000_000
+
+// coverSizes are the renditions of the covers, by the longest side in
+// pixels, so that e.g. list views do not load the full scans.
+var coverSizes = []struct {
+	name   string
+	pixels int
+}{
+	{"thumb", 200},
+	{"medium", 600},
+	{"large", 1200},
+}
+
+// errCoverTooLarge is returned for images of more than maxCoverPixels.
+var errCoverTooLarge = errors.New("the cover image has too many pixels")
+
+// Cover is the cover image of a book. Its renditions are kept in the blob
+// store as JPEG.
+type Cover struct {
+	ISBN       string    `json:"isbn"`
+	Width      int       `json:"width"` // The size of the upright upload
+	Height     int       `json:"height"`
+	UpdateTime time.Time `json:"updateTime"`
+}
+
+func (s *Server) coverKey(isbn, size string) string {
+	return s.blobKey("covers/" + isbn + "/" + size + ".jpg")
+}
+
+// FindCover reads the cover of a book. It returns sql.ErrNoRows if the book
+// has no cover.
+func FindCover(db Querier, isbn string) (Cover, error) {
+	var c Cover
+	err := db.QueryRow("SELECT isbn, width, height, updateTime FROM cover WHERE isbn = ?", isbn).
+		Scan(&c.ISBN, &c.Width, &c.Height, &c.UpdateTime)
+	return c, err
+}
+
+// renderCover decodes a JPEG or PNG image and encodes its renditions, by
+// the name of the size. The renditions are upright and have none of the
+// metadata of the image, e.g. the EXIF location of a photo.
+func renderCover(b []byte) (map[string][]byte, image.Point, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, image.Point{}, fmt.Errorf("decode cover err, %w", err)
+	}
+	if config.Width*config.Height > maxCoverPixels {
+		return nil, image.Point{}, errCoverTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, image.Point{}, fmt.Errorf("decode cover err, %w", err)
+	}
+	upright := flatten(img)
+	if format == "jpeg" {
+		upright = orient(upright, jpegOrientation(b))
+	}
+	renditions := make(map[string][]byte, len(coverSizes))
+	for _, size := range coverSizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, fitWithin(upright, size.pixels), &jpeg.Options{Quality: 85}); err != nil {
+			return nil, image.Point{}, fmt.Errorf("encode cover err, %w", err)
+		}
+		renditions[size.name] = buf.Bytes()
+	}
+	return renditions, upright.Bounds().Size(), nil
+}
+
+// PutCover replaces the cover of a book with the JPEG or PNG image of the
+// request body, which is stored as renditions of each of the coverSizes.
+func (s *Server) PutCover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if s.blobStore == nil {
+		HandleErr(w, http.StatusConflict, "No blob store is configured")
+		return
+	}
+	isbn := mux.Vars(r)["isbn"]
+	if FindSpecificBook(s.db, isbn).ISBN == "" {
+		HandleErr(w, http.StatusNotFound, "The book did not exist in the library")
+		return
+	}
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || contentType != "image/jpeg" && contentType != "image/png" {
+		HandleErr(w, http.StatusUnsupportedMediaType, "The cover must be a JPEG or a PNG image")
+		return
+	}
+	// The image is decoded in memory anyway
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		handleDecodeErr(w, err, "Failed to read the cover")
+		return
+	}
+	if s.scanner != nil {
+		result, err := s.scanUpload(r, bytes.NewReader(b), QuarantinedUpload{Kind: "cover",
+			ISBN: isbn, Name: isbn, ContentType: contentType, Size: int64(len(b))})
+		if err != nil {
+			handleErr("Failed to scan the cover", err)
+			HandleErr(w, http.StatusInternalServerError, "Failed to scan the cover")
+			return
+		}
+		if result.Infected {
+			HandleErr(w, http.StatusUnprocessableEntity, "The cover is infected with "+result.Signature)
+			return
+		}
+	}
+
+	renditions, size, err := renderCover(b)
+	if errors.Is(err, errCoverTooLarge) {
+		HandleErr(w, http.StatusUnprocessableEntity, fmt.Sprintf("The cover must be at most %d pixels", maxCoverPixels))
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusUnprocessableEntity, "The cover is not a valid image")
+		return
+	}
+	for name, rendition := range renditions {
+		if _, err := s.blobStore.Put(r.Context(), s.coverKey(isbn, name), bytes.NewReader(rendition)); err != nil {
+			handleErr("Failed to store the cover", err)
+			HandleErr(w, http.StatusInternalServerError, "Failed to store the cover")
+			return
+		}
+	}
+	c := Cover{ISBN: isbn, Width: size.X, Height: size.Y, UpdateTime: time.Now()}
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT OR REPLACE INTO cover (isbn, width, height, updateTime) VALUES(?,?,?,?)",
+			c.ISBN, c.Width, c.Height, c.UpdateTime)
+		return err
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the cover")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		handleErr("Failed to encode cover", err)
+		return
+	}
+}
+
+// GetCover writes a rendition of the cover of a book, of the size query
+// parameter, which defaults to medium, e.g. ?size=thumb for list views.
+// It redirects to a signed URL of the rendition if the blob store hands
+// out URLs.
+func (s *Server) GetCover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = "medium"
+	}
+	valid := false
+	for _, cs := range coverSizes {
+		valid = valid || cs.name == size
+	}
+	if !valid {
+		HandleErr(w, http.StatusBadRequest, "The size must be thumb, medium or large")
+		return
+	}
+	c, err := FindCover(s.db, mux.Vars(r)["isbn"])
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The book has no cover")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the cover")
+		return
+	}
+	if s.blobStore == nil {
+		HandleErr(w, http.StatusConflict, "No blob store is configured")
+		return
+	}
+	if signer, ok := s.blobStore.(blobs.URLSigner); ok {
+		u, err := signer.SignedURL(s.coverKey(c.ISBN, size), downloadURLLifetime, "image/jpeg", "")
+		if err != nil {
+			handleErr("Failed to sign the URL of the cover", err)
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the cover")
+			return
+		}
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	etag := `"` + strconv.FormatInt(c.UpdateTime.UnixNano(), 36) + "-" + size + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if match := r.Header.Get("If-None-Match"); match != "" && (match == etag || match == "*") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	content, err := s.blobStore.Get(r.Context(), s.coverKey(c.ISBN, size))
+	if err != nil {
+		handleErr("Failed to read the blob of the cover", err)
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the cover")
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", "image/jpeg")
+	if _, err := io.Copy(w, content); err != nil {
+		handleErr("Failed to write cover", err)
+		return
+	}
+}
+
+// DeleteCover removes the cover of a book and its renditions.
+func (s *Server) DeleteCover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	isbn := mux.Vars(r)["isbn"]
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if _, err := FindCover(tx, isbn); errors.Is(err, sql.ErrNoRows) {
+			return &statusError{http.StatusNotFound, "The book has no cover"}
+		} else if err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM cover WHERE isbn = ?", isbn)
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to delete the cover")
+		return
+	}
+	if s.blobStore != nil {
+		for _, size := range coverSizes {
+			if err := s.blobStore.Delete(r.Context(), s.coverKey(isbn, size.name)); err != nil {
+				handleErr("Failed to delete the blob of the cover", err)
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,131 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/blobs"
+	"github.com/stretchr/testify/require"
+)
+
+// withOrientation inserts an EXIF segment with the orientation after the
+// start of a JPEG image.
+func withOrientation(t *testing.T, b []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	for _, v := range []interface{}{uint16(42), uint32(8), uint16(1),
+		uint16(0x0112), uint16(3), uint32(1), orientation, uint16(0), uint32(0)} {
+		require.NoError(t, binary.Write(&tiff, binary.BigEndian, v))
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	return append(append(append([]byte{}, b[:2]...), append(app1, segment...)...), b[2:]...)
+}
+
+func TestCoverImages(t *testing.T) {
+	// A red pixel to the left of a blue pixel
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	src.Set(1, 0, color.RGBA{0, 0, 255, 255})
+	rotated := orient(src, 6)
+	require.Equal(t, image.Pt(1, 2), rotated.Bounds().Size())
+	require.Equal(t, color.RGBA{255, 0, 0, 255}, rotated.At(0, 0), "rotated clockwise, the left pixel is on top")
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil))
+	require.Equal(t, 1, jpegOrientation(buf.Bytes()))
+	require.Equal(t, 6, jpegOrientation(withOrientation(t, buf.Bytes(), 6)))
+	_, err := jpeg.Decode(bytes.NewReader(withOrientation(t, buf.Bytes(), 6)))
+	require.NoError(t, err)
+
+	scaled := fitWithin(image.NewRGBA(image.Rect(0, 0, 1000, 400)), 200)
+	require.Equal(t, image.Pt(200, 80), scaled.Bounds().Size())
+	require.Equal(t, image.Pt(50, 20), fitWithin(image.NewRGBA(image.Rect(0, 0, 50, 20)), 200).Bounds().Size())
+}
+
+func TestCovers(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	store, err := blobs.NewDir(t.TempDir())
+	require.NoError(t, err)
+	server := NewServer(db, WithBlobStore(store))
+
+	serve := func(method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	jsonBytes, _ := json.Marshal(Book{ISBN: "9789129688313", Title: "Pippi Langstrump",
+		Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben"})
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/9789129688313", jsonContentType, jsonBytes).Code)
+	const path = "/api/v1/books/9789129688313/cover"
+
+	renditionSize := func(size string) image.Point {
+		t.Helper()
+		response := serve(http.MethodGet, path+"?size="+size, "", nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "image/jpeg", response.Header().Get("Content-Type"))
+		config, err := jpeg.DecodeConfig(response.Body)
+		require.NoError(t, err)
+		return image.Pt(config.Width, config.Height)
+	}
+
+	t.Run("Rejects invalid covers", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, serve(http.MethodGet, path, "", nil).Code)
+		require.Equal(t, http.StatusUnsupportedMediaType, serve(http.MethodPut, path, "image/gif", []byte("GIF89a")).Code)
+		require.Equal(t, http.StatusUnprocessableEntity, serve(http.MethodPut, path, "image/png", []byte("not a png")).Code)
+	})
+
+	t.Run("Stores the renditions", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 1000, 1500))))
+		response := serve(http.MethodPut, path, "image/png", buf.Bytes())
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		var c Cover
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&c))
+		require.Equal(t, 1000, c.Width)
+		require.Equal(t, 1500, c.Height)
+
+		require.Equal(t, image.Pt(133, 200), renditionSize("thumb"))
+		require.Equal(t, image.Pt(400, 600), renditionSize(""))
+		require.Equal(t, image.Pt(800, 1200), renditionSize("large"))
+		require.Equal(t, http.StatusBadRequest, serve(http.MethodGet, path+"?size=huge", "", nil).Code)
+
+		response = serve(http.MethodGet, path+"?size=thumb", "", nil)
+		req := httptest.NewRequest(http.MethodGet, path+"?size=thumb", nil)
+		req.Header.Set("If-None-Match", response.Header().Get("ETag"))
+		cached := httptest.NewRecorder()
+		server.ServeHTTP(cached, req)
+		require.Equal(t, http.StatusNotModified, cached.Code)
+	})
+
+	t.Run("Turns photos upright", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 100)), nil))
+		response := serve(http.MethodPut, path, "image/jpeg", withOrientation(t, buf.Bytes(), 6))
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		require.Equal(t, image.Pt(67, 200), renditionSize("thumb"))
+
+		response = serve(http.MethodGet, path+"?size=large", "", nil)
+		require.NotContains(t, response.Body.String(), "Exif", "the EXIF data is stripped")
+	})
+
+	t.Run("Deletes the cover", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, serve(http.MethodDelete, path, "", nil).Code)
+		require.Equal(t, http.StatusNotFound, serve(http.MethodGet, path, "", nil).Code)
+		_, err := store.Get(context.Background(), server.coverKey("9789129688313", "thumb"))
+		require.ErrorIs(t, err, blobs.ErrNotFound)
+	})
+}
@@ -2,7 +2,6 @@ package library
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
 	"time"
 
@@ -14,42 +13,86 @@ import (
 // Struct should contain the sql database
 // Server should call this storage
 
-// DatabaseQuery Prepers a database query and executes the query on the
-// database. It takes as input a query string and gives as output the rows
-func InsertIntoDatabase(db *sql.DB, b Book) {
-	stmtL, errL := db.Prepare("INSERT INTO library (isbn,title ,createTime,updateTime, publisher) VALUES(?,?,?,?,?)")
-	stmtA, errA := db.Prepare("INSERT INTO author(isbn,firstName, lastName) VALUES(?,?,?)")
+// Querier is implemented by both *sql.DB and *sql.Tx so that the database
+// functions can be used inside of a transaction.
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
 
-	if errL != nil || errA != nil {
-		err := errors.New(errL.Error())
-		err = fmt.Errorf("%w, %s", err, errA.Error())
+// InsertIntoDatabase inserts the book and its author into the database.
+func InsertIntoDatabase(db Querier, b Book) error {
+	_, err := db.Exec("INSERT INTO author(isbn,firstName, lastName) VALUES(?,?,?)",
+		b.ISBN, b.Author.FirstName, b.Author.LastName)
+	if err != nil {
+		handleErr("Failed to insert into database", err)
+		return err
+	}
+	var availableFrom sql.NullTime
+	if b.AvailableFrom != nil {
+		availableFrom = sql.NullTime{Time: *b.AvailableFrom, Valid: true}
+	}
+	var publishAt sql.NullTime
+	if b.PublishAt != nil {
+		publishAt = sql.NullTime{Time: *b.PublishAt, Valid: true}
+	}
+	// Books from before the workflow, e.g. in old events, are published
+	status := b.Status
+	if status == "" {
+		status = StatusPublished
+	}
+	_, err = db.Exec("INSERT INTO library (isbn,title ,createTime,updateTime, publisher, availableFrom, classification, callNumber, id, workId, seriesId, seriesVolume, description, pageCount, language, publicationYear, format, status, publishAt) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)",
+		b.ISBN, b.Title, b.CreateTime, b.UpdateTime, b.Publisher, availableFrom, b.Classification, b.CallNumber, b.ID, b.WorkID,
+		b.SeriesID, b.SeriesVolume, b.Description, b.PageCount, b.Language, b.PublicationYear, b.Format, status, publishAt)
+	if err != nil {
 		handleErr("Failed to insert into database", err)
-		return
+		return err
 	}
-	stmtA.Exec(b.ISBN, b.Author.FirstName, b.Author.LastName)
-	stmtL.Exec(b.ISBN, b.Title, b.CreateTime, b.UpdateTime, b.Publisher)
+	for _, t := range b.Translations {
+		_, err = db.Exec("INSERT INTO book_translation (isbn, language, title, description) VALUES(?,?,?,?)",
+			b.ISBN, t.Language, t.Title, t.Description)
+		if err != nil {
+			handleErr("Failed to insert into database", err)
+			return err
+		}
+	}
+	for _, format := range b.AccessibleFormats {
+		_, err = db.Exec("INSERT INTO book_accessibility (isbn, format) VALUES(?,?)",
+			b.ISBN, format)
+		if err != nil {
+			handleErr("Failed to insert into database", err)
+			return err
+		}
+	}
+	return indexBook(db, b)
 }
 
+// selectBooks queries the columns of the books which scanBook reads.
+const selectBooks = "SELECT library.isbn, library.title, library.createTime,library.updateTime,author.firstName, author.lastName ,library.publisher, library.availableFrom, library.classification, library.callNumber, library.id, library.workId, library.seriesId, library.seriesVolume, library.description, library.pageCount, library.language, library.publicationYear, library.format, library.status, library.publishAt FROM library INNER JOIN author ON library.isbn = author.isbn"
+
 // ReadDatabase reads the information that we get from the database.
-func ReadDatabaseList(db *sql.DB) []Book {
-	rows, err := db.Query("SELECT library.isbn, library.title, library.createTime,library.updateTime,author.firstName, author.lastName ,library.publisher FROM library INNER JOIN author ON library.isbn = author.isbn;")
+func ReadDatabaseList(db Querier) []Book {
+	rows, err := db.Query(selectBooks + ";")
 	var b []Book
 	if err != nil {
 		handleErr("Failed to QUERY the statment to the database", err)
 		return b
 	}
-	return ReadRows(rows, b)
+	books := attachTranslations(db, ReadRows(rows, b), "")
+	return attachAccessibleFormats(db, books, "")
 }
 
 //Reads from the database and find a specific book that exists.
-func FindSpecificBook(db *sql.DB, isbnToFind string) Book {
-	rows, err := db.Query(fmt.Sprintf("SELECT library.isbn, library.title,library.createTime,library.updateTime,author.firstName, author.lastName ,library.publisher FROM library INNER JOIN author ON library.isbn = author.isbn WHERE library.isbn=%s;", isbnToFind))
+func FindSpecificBook(db Querier, isbnToFind string) Book {
+	rows, err := db.Query(selectBooks+" WHERE library.isbn=?;", isbnToFind)
 	var b []Book
 	if err != nil {
 		handleErr("Failed to QUERY the statment to the database", err)
 		return Book{}
 	}
-	res := ReadRows(rows, b)
+	res := attachTranslations(db, ReadRows(rows, b), isbnToFind)
+	res = attachAccessibleFormats(db, res, isbnToFind)
 	if len(res) != 0 {
 		return res[0]
 	}
@@ -58,6 +101,15 @@ func FindSpecificBook(db *sql.DB, isbnToFind string) Book {
 
 //ReadRows gets the information from the query and stores it in the Book slice.
 func ReadRows(rows *sql.Rows, b []Book) []Book {
+	for rows.Next() {
+		b = append(b, scanBook(rows))
+	}
+	return b
+}
+
+// scanBook reads the book of the current row of a query of the columns of
+// ReadDatabaseList.
+func scanBook(rows *sql.Rows) Book {
 	var isbndb string
 	var titledb string
 	var createTimedb time.Time
@@ -65,32 +117,108 @@ func ReadRows(rows *sql.Rows, b []Book) []Book {
 	var firstNamedb string
 	var lastNamedb string
 	var publisherdb string
+	var availableFromdb sql.NullTime
+	var classificationdb string
+	var callNumberdb string
+	var iddb string
+	var workIDdb string
+	var seriesIDdb string
+	var seriesVolumedb int
+	var descriptiondb string
+	var pageCountdb int
+	var languagedb string
+	var publicationYeardb int
+	var formatdb string
+	var statusdb string
+	var publishAtdb sql.NullTime
+
+	rows.Scan(
+		&isbndb,
+		&titledb,
+		&createTimedb,
+		&updateTimedb,
+		&firstNamedb,
+		&lastNamedb,
+		&publisherdb,
+		&availableFromdb,
+		&classificationdb,
+		&callNumberdb,
+		&iddb,
+		&workIDdb,
+		&seriesIDdb,
+		&seriesVolumedb,
+		&descriptiondb,
+		&pageCountdb,
+		&languagedb,
+		&publicationYeardb,
+		&formatdb,
+		&statusdb,
+		&publishAtdb,
+	)
+	book := Book{ISBN: isbndb, Title: titledb, CreateTime: createTimedb,
+		UpdateTime: updateTimedb, Author: &Author{FirstName: firstNamedb,
+			LastName: lastNamedb}, Publisher: publisherdb,
+		Classification: classificationdb, CallNumber: callNumberdb, ID: iddb,
+		WorkID: workIDdb, SeriesID: seriesIDdb, SeriesVolume: seriesVolumedb,
+		Description: descriptiondb, PageCount: pageCountdb, Language: languagedb,
+		PublicationYear: publicationYeardb, Format: formatdb, Status: statusdb}
+	if availableFromdb.Valid {
+		availableFrom := availableFromdb.Time
+		book.AvailableFrom = &availableFrom
+	}
+	if publishAtdb.Valid {
+		publishAt := publishAtdb.Time
+		book.PublishAt = &publishAt
+	}
+	return book
+}
 
+// attachTranslations reads the translations of the books from the database
+// and adds them to the books. An empty isbn reads the translations of all
+// books.
+func attachTranslations(db Querier, books []Book, isbn string) []Book {
+	if len(books) == 0 {
+		return books
+	}
+	query := "SELECT isbn, language, title, description FROM book_translation"
+	var args []interface{}
+	if isbn != "" {
+		query += " WHERE isbn=?"
+		args = append(args, isbn)
+	}
+	rows, err := db.Query(query+" ORDER BY isbn, language;", args...)
+	if err != nil {
+		handleErr("Failed to QUERY the translations from the database", err)
+		return books
+	}
+	defer rows.Close()
+
+	translations := make(map[string][]Translation)
 	for rows.Next() {
-		rows.Scan(
-			&isbndb,
-			&titledb,
-			&createTimedb,
-			&updateTimedb,
-			&firstNamedb,
-			&lastNamedb,
-			&publisherdb,
-		)
-		b = append(b, Book{ISBN: isbndb, Title: titledb, CreateTime: createTimedb,
-			UpdateTime: updateTimedb, Author: &Author{FirstName: firstNamedb,
-				LastName: lastNamedb}, Publisher: publisherdb})
+		var bookISBN string
+		var t Translation
+		if err := rows.Scan(&bookISBN, &t.Language, &t.Title, &t.Description); err != nil {
+			handleErr("Failed to read the translations from the database", err)
+			return books
+		}
+		translations[bookISBN] = append(translations[bookISBN], t)
 	}
-	return b
+	for i := range books {
+		books[i].Translations = translations[books[i].ISBN]
+	}
+	return books
 }
 
 //Deletes a specific book from the database
-func DeleteBookFromDB(db *sql.DB, isbn string) {
-	for _, table := range []string{"library", "author"} {
-		_, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE isbn=%s;", table, isbn))
+func DeleteBookFromDB(db Querier, isbn string) error {
+	for _, table := range []string{"library", "author", "book_translation", "book_accessibility", searchTable} {
+		_, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE isbn=?;", table), isbn)
 		if err != nil {
 			handleErr(fmt.Sprintf("failed to delete %s from database", isbn), err)
+			return err
 		}
 	}
+	return queueSearchUpdate(db, isbn)
 }
 
 //Handles the error printing
@@ -1,31 +1,134 @@
 package library
 
 import (
+	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/sqlite"
 	"github.com/golang-migrate/migrate/v4/source/httpfs"
-	_ "modernc.org/sqlite"
+	sqlitedriver "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 //go:embed migrations
 var migrations embed.FS
 
-const schemaVersion = 2
+const schemaVersion = 41
+
+// sqlitePragmas are run on every connection. In WAL mode readers do not
+// block the writer, and the busy timeout makes a connection wait for the
+// lock instead of failing with "database is locked".
+var sqlitePragmas = []string{
+	"journal_mode(WAL)",
+	"busy_timeout(5000)",
+	"synchronous(NORMAL)",
+}
 
 // NewDb opens a connection to the sqlite database.
 func NewDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open("sqlite", withPragmas(dbPath))
 	if err != nil {
 		return nil, fmt.Errorf("open sqlite db err, %w", err)
 	}
 	return db, nil
 }
 
+// withPragmas adds the sqlitePragmas to the data source name, so that the
+// driver runs them on every new connection of the pool.
+func withPragmas(dsn string) string {
+	params := make([]string, len(sqlitePragmas))
+	for i, p := range sqlitePragmas {
+		params[i] = "_pragma=" + p
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + strings.Join(params, "&")
+}
+
+// isBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED, including
+// their extended codes, which means that the transaction may succeed if it
+// is retried.
+func isBusy(err error) bool {
+	var sqliteErr *sqlitedriver.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// busyRetries are the waits before retrying a transaction which failed
+// because the database was busy.
+var busyRetries = []time.Duration{50 * time.Millisecond, 200 * time.Millisecond, time.Second}
+
+// inTx runs fn in a transaction which is committed if fn succeeds. The
+// transactions of the server are serialized so that they do not fail on each
+// other's locks, and transactions which fail because another process holds
+// the lock are retried. The in-memory indexes are rebuilt after a commit.
+//
+// The transaction is rolled back when ctx ends, e.g. when the request times
+// out, and a request which times out while it waits for another transaction
+// gives up its turn.
+func (s *Server) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if err := s.lockWrites(ctx); err != nil {
+		return err
+	}
+	defer s.unlockWrites()
+	for i := 0; ; i++ {
+		err := runTx(ctx, s.db, fn)
+		if err == nil {
+			s.suggestions.invalidate()
+		}
+		if !isBusy(err) || i == len(busyRetries) {
+			return err
+		}
+		select {
+		case <-time.After(busyRetries[i]):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// lockWrites waits for the turn of the caller to write to the database,
+// or for ctx to end.
+func (s *Server) lockWrites(ctx context.Context) error {
+	select {
+	case s.writeMu <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// unlockWrites ends the turn of the caller to write to the database.
+func (s *Server) unlockWrites() {
+	<-s.writeMu
+}
+
+// runTx runs fn in a transaction, which database/sql rolls back when ctx
+// ends. A transaction whose context ended before it was committed fails.
+func runTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction err, %w", err)
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // EnsureSchema runs migrations from the embedded filesystem against the
 // provided database connection.
 func EnsureSchema(db *sql.DB) error {
@@ -0,0 +1,46 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentWrites(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	var mode string
+	require.NoError(t, db.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	require.Equal(t, "wal", mode)
+
+	server := NewServer(db)
+	var wg sync.WaitGroup
+	codes := make([]int, 20)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			isbn := fmt.Sprintf("12332112332%02d", i)
+			jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: "volume " + isbn,
+				Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "adlibris"})
+			request, _ := http.NewRequest(http.MethodPost, "/api/v1/books/"+isbn+"?force=true",
+				bytes.NewReader(jsonBytes))
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+			codes[i] = response.Code
+		}(i)
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		require.Equal(t, http.StatusOK, code, "book %d", i)
+	}
+	require.Len(t, ReadDatabaseList(db), len(codes))
+}
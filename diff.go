@@ -0,0 +1,166 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// CatalogDiff contains the differences between two catalogs. Added and
+// Removed are relative to the old catalog.
+type CatalogDiff struct {
+	Added   []Book       `json:"added"`
+	Removed []Book       `json:"removed"`
+	Changed []BookChange `json:"changed"`
+}
+
+// BookChange describes a book which exists in both catalogs but with
+// different field values.
+type BookChange struct {
+	ISBN   string   `json:"isbn"`
+	Fields []string `json:"fields"` // The names of the fields which differ
+	Old    Book     `json:"old"`
+	New    Book     `json:"new"`
+}
+
+// Empty reports whether the two compared catalogs were identical.
+func (d CatalogDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffCatalogs compares two lists of books by ISBN and reports which books
+// were added, removed or changed in newBooks compared to oldBooks.
+func DiffCatalogs(oldBooks, newBooks []Book) CatalogDiff {
+	oldByISBN := make(map[string]Book, len(oldBooks))
+	for _, b := range oldBooks {
+		oldByISBN[b.ISBN] = b
+	}
+	newByISBN := make(map[string]Book, len(newBooks))
+	for _, b := range newBooks {
+		newByISBN[b.ISBN] = b
+	}
+
+	var d CatalogDiff
+	for isbn, nb := range newByISBN {
+		ob, exists := oldByISBN[isbn]
+		if !exists {
+			d.Added = append(d.Added, nb)
+			continue
+		}
+		if fields := changedFields(ob, nb); len(fields) != 0 {
+			d.Changed = append(d.Changed, BookChange{
+				ISBN: isbn, Fields: fields, Old: ob, New: nb,
+			})
+		}
+	}
+	for isbn, ob := range oldByISBN {
+		if _, exists := newByISBN[isbn]; !exists {
+			d.Removed = append(d.Removed, ob)
+		}
+	}
+
+	// Sort the result so that the output is stable between runs
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].ISBN < d.Added[j].ISBN })
+	sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].ISBN < d.Removed[j].ISBN })
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].ISBN < d.Changed[j].ISBN })
+	return d
+}
+
+// changedFields returns the json names of the fields which differ between
+// the two books.
+func changedFields(a, b Book) []string {
+	var fields []string
+	if a.Title != b.Title {
+		fields = append(fields, "title")
+	}
+	if a.Publisher != b.Publisher {
+		fields = append(fields, "publisher")
+	}
+	if !a.CreateTime.Equal(b.CreateTime) {
+		fields = append(fields, "createTime")
+	}
+	if !a.UpdateTime.Equal(b.UpdateTime) {
+		fields = append(fields, "updateTime")
+	}
+	if !reflect.DeepEqual(a.Translations, b.Translations) {
+		fields = append(fields, "translations")
+	}
+	if !equalTimePtr(a.AvailableFrom, b.AvailableFrom) {
+		fields = append(fields, "availableFrom")
+	}
+	if !reflect.DeepEqual(a.AccessibleFormats, b.AccessibleFormats) {
+		fields = append(fields, "accessibleFormats")
+	}
+	if a.Classification != b.Classification {
+		fields = append(fields, "classification")
+	}
+	if a.CallNumber != b.CallNumber {
+		fields = append(fields, "callNumber")
+	}
+	if a.WorkID != b.WorkID {
+		fields = append(fields, "workId")
+	}
+	if a.SeriesID != b.SeriesID || a.SeriesVolume != b.SeriesVolume {
+		fields = append(fields, "series")
+	}
+	if a.Description != b.Description {
+		fields = append(fields, "description")
+	}
+	if a.PageCount != b.PageCount {
+		fields = append(fields, "pageCount")
+	}
+	if a.Language != b.Language {
+		fields = append(fields, "language")
+	}
+	if a.PublicationYear != b.PublicationYear {
+		fields = append(fields, "publicationYear")
+	}
+	if a.Format != b.Format {
+		fields = append(fields, "format")
+	}
+	if a.Status != b.Status {
+		fields = append(fields, "status")
+	}
+	if !equalTimePtr(a.PublishAt, b.PublishAt) {
+		fields = append(fields, "publishAt")
+	}
+	var aAuthor, bAuthor Author
+	if a.Author != nil {
+		aAuthor = *a.Author
+	}
+	if b.Author != nil {
+		bAuthor = *b.Author
+	}
+	if aAuthor.FirstName != bAuthor.FirstName {
+		fields = append(fields, "author.firstName")
+	}
+	if aAuthor.LastName != bAuthor.LastName {
+		fields = append(fields, "author.lastName")
+	}
+	return fields
+}
+
+func equalTimePtr(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// ReadExportFile reads a list of books from a JSON export file, i.e. a file
+// containing the response body of GET /api/books.
+func ReadExportFile(path string) ([]Book, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open export file err, %w", err)
+	}
+	defer f.Close()
+	var books []Book
+	if err := json.NewDecoder(f).Decode(&books); err != nil {
+		return nil, fmt.Errorf("decode export file err, %w", err)
+	}
+	return books, nil
+}
@@ -0,0 +1,57 @@
+package library
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffCatalogs(t *testing.T) {
+	created := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	starWars := Book{
+		ISBN:       "1233211233215",
+		Title:      "star wars",
+		CreateTime: created,
+		UpdateTime: created,
+		Author:     &Author{FirstName: "george", LastName: "lucas"},
+		Publisher:  "adlibris",
+	}
+	sith := Book{
+		ISBN:       "1233211233213",
+		Title:      "star wars revenge of the sith",
+		CreateTime: created,
+		UpdateTime: created,
+		Author:     &Author{FirstName: "george", LastName: "lucas"},
+		Publisher:  "adlibris",
+	}
+	menace := Book{
+		ISBN:       "1233211233210",
+		Title:      "star wars phantom menance",
+		CreateTime: created,
+		UpdateTime: created,
+		Author:     &Author{FirstName: "george", LastName: "lucas"},
+		Publisher:  "adlibris",
+	}
+
+	t.Run("identical catalogs have no differences", func(t *testing.T) {
+		d := DiffCatalogs([]Book{starWars, sith}, []Book{sith, starWars})
+		require.True(t, d.Empty())
+	})
+
+	t.Run("reports added, removed and changed books", func(t *testing.T) {
+		changed := sith
+		changed.Title = "star wars episode three"
+		changed.Author = &Author{FirstName: "george", LastName: "walton lucas"}
+
+		d := DiffCatalogs([]Book{starWars, sith}, []Book{changed, menace})
+		require.False(t, d.Empty())
+		require.Len(t, d.Added, 1)
+		require.Equal(t, menace.ISBN, d.Added[0].ISBN)
+		require.Len(t, d.Removed, 1)
+		require.Equal(t, starWars.ISBN, d.Removed[0].ISBN)
+		require.Len(t, d.Changed, 1)
+		require.Equal(t, sith.ISBN, d.Changed[0].ISBN)
+		require.Equal(t, []string{"title", "author.lastName"}, d.Changed[0].Fields)
+	})
+}
@@ -0,0 +1,187 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// duplicateThreshold is the title similarity, between 0 and 1, from which
+// books by the same author are considered to be duplicates.
+const duplicateThreshold = 0.85
+
+// DuplicateConflict is the response when a created book resembles existing
+// books, e.g. another edition of the same work.
+type DuplicateConflict struct {
+	Error      string `json:"error"`
+	Candidates []Book `json:"candidates"`
+}
+
+// duplicateError is returned when a created book resembles existing books.
+type duplicateError struct {
+	candidates []Book
+}
+
+func (e *duplicateError) Error() string {
+	return "The book resembles existing books, use force=true to create it anyway"
+}
+
+// writeDuplicateConflict writes the candidates of the error with status 409.
+func writeDuplicateConflict(w http.ResponseWriter, err *duplicateError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(DuplicateConflict{Error: err.Error(), Candidates: err.candidates})
+}
+
+// normalizeText lower cases the text, removes diacritics and punctuation, and
+// collapses whitespace, so that "Rabén & Sjögren" becomes "raben sjogren".
+func normalizeText(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Combining marks, the diacritics of the decomposed letters
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() != 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// leadingArticles are ignored when comparing titles.
+var leadingArticles = []string{"the ", "a ", "an ", "en ", "ett ", "der ", "die ", "das ", "le ", "la ", "les "}
+
+// normalizeTitle normalizes a title for comparison. Subtitles are dropped
+// since they often differ between editions.
+func normalizeTitle(title string) string {
+	if i := strings.Index(title, ":"); i > 0 {
+		title = title[:i]
+	}
+	title = normalizeText(title)
+	for _, article := range leadingArticles {
+		if strings.HasPrefix(title, article) {
+			return strings.TrimPrefix(title, article)
+		}
+	}
+	return title
+}
+
+// similarity returns the similarity of two strings between 0 and 1, based
+// on their Levenshtein distance.
+func similarity(a, b string) float64 {
+	ar, br := []rune(a), []rune(b)
+	if len(ar) == 0 && len(br) == 0 {
+		return 1
+	}
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	longest := len(ar)
+	if len(br) > longest {
+		longest = len(br)
+	}
+	return 1 - float64(prev[len(br)])/float64(longest)
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// authorKey is the normalized name of an author, "last first".
+func authorKey(lastName, firstName string) string {
+	return normalizeText(lastName) + " " + normalizeText(firstName)
+}
+
+// authorityNameKey is the author key of an inverted authority heading such
+// as "Lindgren, Astrid, 1907-2002".
+func authorityNameKey(heading string) string {
+	parts := strings.SplitN(heading, ",", 3)
+	if len(parts) < 2 {
+		return authorKey(parts[0], "")
+	}
+	return authorKey(parts[0], parts[1])
+}
+
+// readAuthorAliases maps the author keys of the variant names in the name
+// authorities to the key of the authorized name, so that for example books
+// by a pseudonym match books by the real name.
+func readAuthorAliases(db Querier) (map[string]string, error) {
+	authorities, err := ReadAuthorities(db, AuthorityName)
+	if err != nil {
+		return nil, err
+	}
+	aliases := make(map[string]string)
+	for _, a := range authorities {
+		heading := authorityNameKey(a.Heading)
+		for _, v := range a.Variants {
+			aliases[authorityNameKey(v)] = heading
+		}
+	}
+	return aliases, nil
+}
+
+// FindDuplicates reads the books with another ISBN whose normalized author
+// is the same and whose normalized title is similar to the book. Editions of
+// the work which the book belongs to are expected to be similar and are not
+// duplicates.
+func FindDuplicates(db Querier, b Book) ([]Book, error) {
+	if b.Author == nil {
+		return nil, nil
+	}
+	aliases, err := readAuthorAliases(db)
+	if err != nil {
+		return nil, err
+	}
+	resolve := func(a *Author) string {
+		if a == nil {
+			return ""
+		}
+		key := authorKey(a.LastName, a.FirstName)
+		if heading, ok := aliases[key]; ok {
+			return heading
+		}
+		return key
+	}
+
+	author, title := resolve(b.Author), normalizeTitle(b.Title)
+	var candidates []Book
+	for _, existing := range ReadDatabaseList(db) {
+		if existing.ISBN == b.ISBN || resolve(existing.Author) != author ||
+			(b.WorkID != "" && existing.WorkID == b.WorkID) {
+			continue
+		}
+		if similarity(title, normalizeTitle(existing.Title)) >= duplicateThreshold {
+			candidates = append(candidates, existing)
+		}
+	}
+	return candidates, nil
+}
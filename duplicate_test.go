@@ -0,0 +1,73 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuplicateDetection(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	create := func(path string, book Book) (int, []byte) {
+		jsonBytes, _ := json.Marshal(book)
+		response := createNewRequest(http.MethodPost, path, jsonBytes, db)
+		return response.Code, response.Body.Bytes()
+	}
+	pippi := Book{
+		ISBN:      "1233211233215",
+		Title:     "Pippi Longstocking",
+		Author:    &Author{FirstName: "Astrid", LastName: "Lindgren"},
+		Publisher: "raben",
+	}
+	code, _ := create("/api/v1/books/"+pippi.ISBN, pippi)
+	require.Equal(t, http.StatusOK, code)
+
+	anotherEdition := Book{
+		ISBN:      "1233211233213",
+		Title:     "The Pippi Longstockings: anniversary edition",
+		Author:    &Author{FirstName: "astrid", LastName: "lindgren"},
+		Publisher: "oxford",
+	}
+
+	t.Run("Rejects near duplicates with the candidates", func(t *testing.T) {
+		code, body := create("/api/v1/books/"+anotherEdition.ISBN, anotherEdition)
+		require.Equal(t, http.StatusConflict, code)
+
+		var got DuplicateConflict
+		require.NoError(t, json.Unmarshal(body, &got))
+		require.Len(t, got.Candidates, 1)
+		require.Equal(t, pippi.ISBN, got.Candidates[0].ISBN)
+	})
+
+	t.Run("Matches authors by their authority variants", func(t *testing.T) {
+		librarian := createMemberSession(t, db, "astrid", RoleLibrarian)
+		response := createNewMemberRequest(http.MethodPost, "/api/v1/admin/authorities:import",
+			[]byte(authorityFile), db, librarian)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		pseudonym := anotherEdition
+		pseudonym.Author = &Author{FirstName: "Astrid Anna Emilia", LastName: "Ericsson"}
+		candidates, err := FindDuplicates(db, pseudonym)
+		require.NoError(t, err)
+		require.Len(t, candidates, 1)
+	})
+
+	t.Run("Creates different books by the same author", func(t *testing.T) {
+		code, _ := create("/api/v1/books/1233211233210", Book{
+			ISBN:      "1233211233210",
+			Title:     "Emil of Lonneberga",
+			Author:    &Author{FirstName: "Astrid", LastName: "Lindgren"},
+			Publisher: "raben",
+		})
+		require.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("Creates near duplicates when forced", func(t *testing.T) {
+		code, _ := create("/api/v1/books/"+anotherEdition.ISBN+"?force=true", anotherEdition)
+		require.Equal(t, http.StatusOK, code)
+	})
+}
@@ -0,0 +1,465 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxEbookBytes limits the size of uploaded e-book files.
+const maxEbookBytes = 100 << 20
+
+// downloadURLLifetime is how long a download URL of a digital loan can be
+// used. A new URL is issued whenever the loans of the member are listed.
+const downloadURLLifetime = 15 * time.Minute
+
+// Ebook is the digital lending terms of a book. Its content is either at an
+// external URL, e.g. of the distributor, or a file uploaded to the e-book
+// directory of the server.
+type Ebook struct {
+	ISBN     string `json:"isbn"`
+	URL      string `json:"url,omitempty"` // Only shown when the e-book is stored
+	HasFile  bool   `json:"hasFile"`
+	Licenses int    `json:"licenses"` // The number of concurrent digital loans
+	LoanDays int    `json:"loanDays"`
+	// Available is the number of licenses which are not lent out
+	Available int `json:"available"`
+
+	fileType string
+}
+
+// DigitalLoan gives a member access to an e-book until the end time.
+type DigitalLoan struct {
+	ID          string    `json:"id"`
+	ISBN        string    `json:"isbn"`
+	MemberID    string    `json:"memberId"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+	DownloadURL string    `json:"downloadUrl,omitempty"` // Only set for the member of active loans
+}
+
+// WithEbookDir sets the directory where uploaded e-book files are stored.
+// Without it, e-books can only link to external URLs.
+func WithEbookDir(dir string) ServerOption {
+	return func(s *Server) {
+		s.ebookDir = dir
+	}
+}
+
+func validateEbook(e Ebook) error {
+	var fieldErrors []string
+	if e.URL != "" {
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			fieldErrors = append(fieldErrors, " url ")
+		}
+	}
+	if e.Licenses < 1 {
+		fieldErrors = append(fieldErrors, " licenses ")
+	}
+	if e.LoanDays < 1 {
+		fieldErrors = append(fieldErrors, " loan days ")
+	}
+	if len(fieldErrors) != 0 {
+		return fmt.Errorf("validation failed, field error(s):%v. Fix these error before proceeding",
+			strings.Join(fieldErrors, ", "))
+	}
+	return nil
+}
+
+// FindEbook reads an e-book with the number of licenses which are available
+// at the given time. It returns sql.ErrNoRows if the book is not lent
+// digitally.
+func FindEbook(db Querier, isbn string, now time.Time) (Ebook, error) {
+	var e Ebook
+	var lent int
+	err := db.QueryRow(`SELECT isbn, url, fileType, licenses, loanDays,
+			(SELECT COUNT(*) FROM digital_loan WHERE digital_loan.isbn = ebook.isbn AND endTime > ?)
+		FROM ebook WHERE isbn = ?`, now.Unix(), isbn).Scan(&e.ISBN, &e.URL, &e.fileType, &e.Licenses, &e.LoanDays, &lent)
+	e.HasFile = e.fileType != ""
+	e.Available = e.Licenses - lent
+	if e.Available < 0 {
+		e.Available = 0
+	}
+	return e, err
+}
+
+// StoreEbook inserts or updates the lending terms of an e-book. The file of
+// the e-book is kept.
+func StoreEbook(db Querier, e Ebook) error {
+	_, err := db.Exec(`INSERT INTO ebook (isbn, url, licenses, loanDays) VALUES(?,?,?,?)
+		ON CONFLICT (isbn) DO UPDATE SET url = excluded.url, licenses = excluded.licenses, loanDays = excluded.loanDays`,
+		e.ISBN, e.URL, e.Licenses, e.LoanDays)
+	if err != nil {
+		return fmt.Errorf("store ebook err, %w", err)
+	}
+	return nil
+}
+
+// readDigitalLoans reads the digital loans matching the where clause, the
+// latest first.
+func readDigitalLoans(db Querier, where string, args ...interface{}) ([]DigitalLoan, error) {
+	rows, err := db.Query("SELECT id, isbn, memberId, startTime, endTime FROM digital_loan WHERE "+where+" ORDER BY startTime DESC, id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query digital loans err, %w", err)
+	}
+	defer rows.Close()
+	loans := []DigitalLoan{}
+	for rows.Next() {
+		var l DigitalLoan
+		var startTime, endTime int64
+		if err := rows.Scan(&l.ID, &l.ISBN, &l.MemberID, &startTime, &endTime); err != nil {
+			return nil, fmt.Errorf("scan digital loan err, %w", err)
+		}
+		l.StartTime, l.EndTime = time.Unix(startTime, 0).UTC(), time.Unix(endTime, 0).UTC()
+		loans = append(loans, l)
+	}
+	return loans, rows.Err()
+}
+
+// findDigitalLoan reads a digital loan. It returns sql.ErrNoRows if there is
+// no such loan.
+func findDigitalLoan(db Querier, id string) (DigitalLoan, error) {
+	loans, err := readDigitalLoans(db, "id = ?", id)
+	if err != nil {
+		return DigitalLoan{}, err
+	}
+	if len(loans) == 0 {
+		return DigitalLoan{}, sql.ErrNoRows
+	}
+	return loans[0], nil
+}
+
+// downloadURL returns a signed URL which downloads the e-book of the loan
+// until the URL expires, at the latest when the loan ends. prefix is the
+// API prefix of the request.
+func (s *Server) downloadURL(r *http.Request, prefix string, l DigitalLoan, now time.Time) string {
+	expires := now.Add(downloadURLLifetime)
+	if l.EndTime.Before(expires) {
+		expires = l.EndTime
+	}
+	token := s.signCookie(l.ID + "." + strconv.FormatInt(expires.Unix(), 10))
+	return externalURL(r, prefix+"/ebooks/download/"+token)
+}
+
+// apiPrefix returns the part of the path of the request before the marker,
+// e.g. "/api/v1" of "/api/v1/books/..." with the marker "/books/".
+func apiPrefix(r *http.Request, marker string) string {
+	if i := strings.Index(r.URL.Path, marker); i >= 0 {
+		return r.URL.Path[:i]
+	}
+	return ""
+}
+
+// ebookFiles returns the directory of the e-book files of the server, which
+// is below the e-book directory if the server has a namespace.
+func (s *Server) ebookFiles() string {
+	if s.namespace == "" {
+		return s.ebookDir
+	}
+	return filepath.Join(s.ebookDir, namespaceDir, s.namespace)
+}
+
+// ebookPath returns the path of the file of an e-book.
+func (s *Server) ebookPath(isbn string) string {
+	return filepath.Join(s.ebookFiles(), isbn)
+}
+
+// GetEbook retrieves the lending terms and the available licenses of an
+// e-book. The URL of the content is left out, it is only reached through
+// the download URLs of the loans.
+func (s *Server) GetEbook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e, err := FindEbook(s.db, mux.Vars(r)["isbn"], time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The e-book does not exist")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the e-book")
+		return
+	}
+	e.URL = ""
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the e-book")
+		return
+	}
+}
+
+// PutEbook sets the lending terms of an e-book.
+func (s *Server) PutEbook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var e Ebook
+	if err := decodeJSON(r, &e); err != nil {
+		handleDecodeErr(w, err, "Failed to decode e-book")
+		return
+	}
+	e.ISBN = mux.Vars(r)["isbn"]
+	if err := validateEbook(e); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if FindSpecificBook(tx, e.ISBN).ISBN == "" {
+			return &statusError{http.StatusNotFound, "The book did not exist in the library"}
+		}
+		if err := StoreEbook(tx, e); err != nil {
+			return err
+		}
+		var err error
+		e, err = FindEbook(tx, e.ISBN, time.Now())
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to store the e-book")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the e-book")
+		return
+	}
+}
+
+// UploadEbookFile stores the file of an e-book, which is downloaded instead
+// of its URL. The Content-Type of the request is kept for the downloads.
+func (s *Server) UploadEbookFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if s.ebookDir == "" {
+		HandleErr(w, http.StatusConflict, "No e-book directory is configured")
+		return
+	}
+	isbn := mux.Vars(r)["isbn"]
+	if _, err := FindEbook(s.db, isbn, time.Now()); errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The e-book does not exist")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the e-book")
+		return
+	}
+	fileType := r.Header.Get("Content-Type")
+	if fileType == "" || fileType == jsonContentType {
+		fileType = "application/octet-stream"
+	}
+
+	// The file is replaced only when the upload is complete
+	if err := os.MkdirAll(s.ebookFiles(), 0o750); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the e-book file")
+		return
+	}
+	f, err := os.CreateTemp(s.ebookFiles(), isbn+".*.upload")
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the e-book file")
+		return
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		handleDecodeErr(w, err, "Failed to read the e-book file")
+		return
+	}
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if err := os.Rename(f.Name(), s.ebookPath(isbn)); err != nil {
+			return err
+		}
+		_, err := tx.Exec("UPDATE ebook SET fileType = ? WHERE isbn = ?", fileType, isbn)
+		return err
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the e-book file")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BorrowEbook lends an e-book to the member of the session, if a license
+// is available. The loan ends by itself after the loan days of the e-book.
+// Drafts, embargoed and withdrawn books are not lent.
+func (s *Server) BorrowEbook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		HandleErr(w, http.StatusUnauthorized, "The request is not logged in")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the session")
+		return
+	}
+	now := time.Now()
+	loan := DigitalLoan{ID: s.idGenerator.NewID(), ISBN: mux.Vars(r)["isbn"], MemberID: m.ID,
+		StartTime: time.Unix(now.Unix(), 0).UTC()}
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		e, err := FindEbook(tx, loan.ISBN, now)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &statusError{http.StatusNotFound, "The e-book does not exist"}
+		} else if err != nil {
+			return err
+		}
+		switch FindPublicBook(tx, loan.ISBN, now).Status {
+		case "":
+			// Drafts and embargoed books are not lent either
+			return &statusError{http.StatusNotFound, "The book did not exist in the library"}
+		case StatusWithdrawn:
+			return &statusError{http.StatusConflict, "The book has been withdrawn"}
+		}
+		active, err := readDigitalLoans(tx, "isbn = ? AND memberId = ? AND endTime > ?", loan.ISBN, m.ID, now.Unix())
+		if err != nil {
+			return err
+		}
+		if len(active) > 0 {
+			return &statusError{http.StatusConflict, "The member already borrows the e-book"}
+		}
+		if e.Available == 0 {
+			return &statusError{http.StatusConflict, "Every license of the e-book is lent out"}
+		}
+		loan.EndTime = loan.StartTime.AddDate(0, 0, e.LoanDays)
+		_, err = tx.Exec("INSERT INTO digital_loan (id, isbn, memberId, startTime, endTime) VALUES(?,?,?,?,?)",
+			loan.ID, loan.ISBN, loan.MemberID, loan.StartTime.Unix(), loan.EndTime.Unix())
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to borrow the e-book")
+		return
+	}
+	loan.DownloadURL = s.downloadURL(r, apiPrefix(r, "/books/"), loan, now)
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(loan); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the digital loan")
+		return
+	}
+}
+
+// GetDigitalLoans retrieves the active digital loans of the member of the
+// session, with new download URLs.
+func (s *Server) GetDigitalLoans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		HandleErr(w, http.StatusUnauthorized, "The request is not logged in")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the session")
+		return
+	}
+	now := time.Now()
+	loans, err := readDigitalLoans(s.db, "memberId = ? AND endTime > ?", m.ID, now.Unix())
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the digital loans")
+		return
+	}
+	for i := range loans {
+		loans[i].DownloadURL = s.downloadURL(r, apiPrefix(r, "/me/"), loans[i], now)
+	}
+	if err := json.NewEncoder(w).Encode(loans); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the digital loans")
+		return
+	}
+}
+
+// ReturnDigitalLoan ends a digital loan of the member of the session early,
+// which frees its license.
+func (s *Server) ReturnDigitalLoan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		HandleErr(w, http.StatusUnauthorized, "The request is not logged in")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the session")
+		return
+	}
+	now := time.Now()
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		res, err := tx.Exec("UPDATE digital_loan SET endTime = ? WHERE id = ? AND memberId = ? AND endTime > ?",
+			now.Unix(), mux.Vars(r)["id"], m.ID, now.Unix())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return &statusError{http.StatusNotFound, "The digital loan does not exist or has ended"}
+		}
+		return nil
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to return the digital loan")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DownloadEbook serves the e-book of a signed download URL, or redirects to
+// its external URL, as long as the URL has not expired and the loan is
+// active.
+func (s *Server) DownloadEbook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	parts := strings.SplitN(s.verifyCookie(mux.Vars(r)["token"]), ".", 2)
+	if len(parts) != 2 {
+		HandleErr(w, http.StatusForbidden, "The download URL is invalid")
+		return
+	}
+	now := time.Now()
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() >= expires {
+		HandleErr(w, http.StatusGone, "The download URL has expired")
+		return
+	}
+	loan, err := findDigitalLoan(s.db, parts[0])
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the digital loan")
+		return
+	}
+	if err != nil || !loan.EndTime.After(now) {
+		HandleErr(w, http.StatusGone, "The digital loan has ended")
+		return
+	}
+	e, err := FindEbook(s.db, loan.ISBN, now)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the e-book")
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	if !e.HasFile || s.ebookDir == "" {
+		if e.URL == "" {
+			HandleErr(w, http.StatusNotFound, "The e-book has no content")
+			return
+		}
+		http.Redirect(w, r, e.URL, http.StatusFound)
+		return
+	}
+	f, err := os.Open(s.ebookPath(e.ISBN))
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the e-book file")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the e-book file")
+		return
+	}
+	w.Header().Set("Content-Type", e.fileType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.ISBN))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
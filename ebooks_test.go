@@ -0,0 +1,144 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigitalLending(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	server := NewServer(db, WithEbookDir(t.TempDir()))
+
+	serve := func(method, path, token string, body []byte, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	putJSON := func(path string, v interface{}) *httptest.ResponseRecorder {
+		jsonBytes, _ := json.Marshal(v)
+		return serve(http.MethodPut, path, "", jsonBytes, jsonContentType)
+	}
+
+	lindgren := &Author{FirstName: "Astrid", LastName: "Lindgren"}
+	for isbn, title := range map[string]string{"9789129688313": "Pippi Langstrump", "9789129657470": "Broderna Lejonhjarta"} {
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: title, Author: lindgren, Publisher: "raben", Format: FormatEbook})
+		require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/"+isbn, "", jsonBytes, jsonContentType).Code)
+	}
+	session := func(id string) string {
+		t.Helper()
+		m := Member{ID: id, Email: id + "@example.com", FirstName: id, LastName: "member", EmailVerified: true, CreateTime: time.Now()}
+		require.NoError(t, server.InsertMember(db, m, ""))
+		s, err := server.startSession(db, m)
+		require.NoError(t, err)
+		return s.Token
+	}
+	astrid, emil := session("astrid"), session("emil")
+
+	borrow := func(isbn, token string) (int, DigitalLoan) {
+		response := serve(http.MethodPost, "/api/v1/books/"+isbn+"/ebook:borrow", token, nil, "")
+		var loan DigitalLoan
+		if response.Code == http.StatusCreated {
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&loan))
+		}
+		return response.Code, loan
+	}
+	download := func(loan DigitalLoan) *httptest.ResponseRecorder {
+		u, err := url.Parse(loan.DownloadURL)
+		require.NoError(t, err)
+		return serve(http.MethodGet, u.Path, "", nil, "")
+	}
+
+	t.Run("Sets the lending terms", func(t *testing.T) {
+		require.Equal(t, http.StatusNotAcceptable, putJSON("/api/v1/books/9789129688313/ebook", Ebook{URL: "ftp://x", Licenses: 1, LoanDays: 14}).Code)
+		require.Equal(t, http.StatusNotFound, putJSON("/api/v1/books/9780000000000/ebook", Ebook{Licenses: 1, LoanDays: 14}).Code)
+		response := putJSON("/api/v1/books/9789129688313/ebook", Ebook{URL: "https://cdn.example.com/pippi.epub", Licenses: 1, LoanDays: 14})
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+
+		response = serve(http.MethodGet, "/api/v1/books/9789129688313/ebook", "", nil, "")
+		require.Equal(t, http.StatusOK, response.Code)
+		var e Ebook
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&e))
+		require.Equal(t, Ebook{ISBN: "9789129688313", Licenses: 1, LoanDays: 14, Available: 1}, e, "the URL is not shown")
+	})
+
+	t.Run("Lends the licenses", func(t *testing.T) {
+		code, _ := borrow("9789129688313", "")
+		require.Equal(t, http.StatusUnauthorized, code)
+		code, loan := borrow("9789129688313", astrid)
+		require.Equal(t, http.StatusCreated, code)
+		require.WithinDuration(t, time.Now().AddDate(0, 0, 14), loan.EndTime, time.Minute)
+		code, _ = borrow("9789129688313", astrid)
+		require.Equal(t, http.StatusConflict, code, "the member already borrows it")
+		code, _ = borrow("9789129688313", emil)
+		require.Equal(t, http.StatusConflict, code, "the only license is lent out")
+
+		response := download(loan)
+		require.Equal(t, http.StatusFound, response.Code)
+		require.Equal(t, "https://cdn.example.com/pippi.epub", response.Header().Get("Location"))
+
+		forged := loan
+		forged.DownloadURL = loan.DownloadURL[:len(loan.DownloadURL)-2] + "xx"
+		require.Equal(t, http.StatusForbidden, download(forged).Code)
+
+		response = serve(http.MethodPost, "/api/v1/me/digital-loans/"+loan.ID+":return", emil, nil, "")
+		require.Equal(t, http.StatusNotFound, response.Code, "only the member can return the loan")
+		response = serve(http.MethodPost, "/api/v1/me/digital-loans/"+loan.ID+":return", astrid, nil, "")
+		require.Equal(t, http.StatusNoContent, response.Code)
+		require.Equal(t, http.StatusGone, download(loan).Code, "the access ends with the loan")
+
+		code, _ = borrow("9789129688313", emil)
+		require.Equal(t, http.StatusCreated, code)
+	})
+
+	t.Run("Ends the loans by themselves", func(t *testing.T) {
+		response := serve(http.MethodGet, "/api/v1/me/digital-loans", emil, nil, "")
+		var loans []DigitalLoan
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&loans))
+		require.Len(t, loans, 1)
+		require.NotEmpty(t, loans[0].DownloadURL)
+
+		_, err := db.Exec("UPDATE digital_loan SET endTime = ? WHERE id = ?", time.Now().Add(-time.Second).Unix(), loans[0].ID)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusGone, download(loans[0]).Code)
+		response = serve(http.MethodGet, "/api/v1/me/digital-loans", emil, nil, "")
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&loans))
+		require.Empty(t, loans)
+		code, _ := borrow("9789129688313", astrid)
+		require.Equal(t, http.StatusCreated, code)
+	})
+
+	t.Run("Serves uploaded files", func(t *testing.T) {
+		response := serve(http.MethodPut, "/api/v1/books/9789129657470/ebook/file", "", []byte("epub"), "application/epub+zip")
+		require.Equal(t, http.StatusNotFound, response.Code, "the lending terms are set first")
+		require.Equal(t, http.StatusOK, putJSON("/api/v1/books/9789129657470/ebook", Ebook{Licenses: 2, LoanDays: 7}).Code)
+		response = serve(http.MethodPut, "/api/v1/books/9789129657470/ebook/file", "", []byte("epub"), "application/epub+zip")
+		require.Equal(t, http.StatusNoContent, response.Code, response.Body.String())
+
+		code, loan := borrow("9789129657470", emil)
+		require.Equal(t, http.StatusCreated, code)
+		response = download(loan)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, "application/epub+zip", response.Header().Get("Content-Type"))
+		require.Equal(t, "epub", response.Body.String())
+	})
+
+	t.Run("Does not lend withdrawn books", func(t *testing.T) {
+		response := serve(http.MethodPost, "/api/v1/books/9789129657470:withdraw", "", nil, "")
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		code, _ := borrow("9789129657470", astrid)
+		require.Equal(t, http.StatusConflict, code)
+	})
+}
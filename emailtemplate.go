@@ -0,0 +1,389 @@
+package library
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// EmailTemplate is a notification email template. Templates are never
+// changed in place, every edit is stored as a new version.
+type EmailTemplate struct {
+	Name       string    `json:"name"`
+	Version    int       `json:"version"`
+	Subject    string    `json:"subject"`
+	Body       string    `json:"body"`
+	CreateTime time.Time `json:"createTime"`
+}
+
+// The maximum size of a rendered template, protects against templates which
+// generate huge outputs with range loops.
+const maxRenderedTemplateSize = 64 << 10
+
+// The maximum number of range iterations and template calls when a template
+// is rendered, protects against templates which loop for a long time without
+// output, e.g. {{range 10000000000}}{{end}}.
+const maxTemplateSteps = 100000
+
+// stepFunc is counted once for every iteration of a range loop and every
+// call of a template, see addTemplateSteps.
+const stepFunc = "templateStep"
+
+var (
+	templateNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+	errTemplateTooLarge = errors.New("rendered template is too large")
+	errTemplateTooLong  = errors.New("rendered template takes too many steps")
+)
+
+// templateFuncs is the only set of functions available to templates on top of
+// the text/template builtins.
+var templateFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"date": func(t time.Time, layout string) string {
+		return t.Format(layout)
+	},
+}
+
+// sampleTemplateData is used when previewing a template without providing
+// data.
+var sampleTemplateData = map[string]interface{}{
+	"Member": map[string]interface{}{
+		"FirstName": "Astrid",
+		"LastName":  "Lindgren",
+	},
+	"Book": Book{
+		ISBN:      "9789129688313",
+		Title:     "Pippi Longstocking",
+		Author:    &Author{FirstName: "Astrid", LastName: "Lindgren"},
+		Publisher: "Rabén och Sjögren",
+	},
+	"DueDate": time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC),
+	"Token":   "q3Jt0xGkVZ2Yb7wLmN5sAe",
+}
+
+// validateEmailTemplate checks the name of the template and that both the
+// subject and the body parse.
+func validateEmailTemplate(t EmailTemplate) error {
+	var fieldErrors []string
+	if !templateNamePattern.MatchString(t.Name) {
+		fieldErrors = append(fieldErrors, " name ")
+	}
+	if _, err := parseTemplate(t.Subject); err != nil || t.Subject == "" {
+		fieldErrors = append(fieldErrors, " subject ")
+	}
+	if _, err := parseTemplate(t.Body); err != nil || t.Body == "" {
+		fieldErrors = append(fieldErrors, " body ")
+	}
+	if len(fieldErrors) != 0 {
+		return fmt.Errorf("validation failed, field error(s):%v. Fix these error before proceeding",
+			strings.Join(fieldErrors, ", "))
+	}
+	return nil
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("").Funcs(templateFuncs).Funcs(templateSteps(nil)).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	step, err := template.New("").Funcs(templateSteps(nil)).Parse("{{" + stepFunc + "}}")
+	if err != nil {
+		return nil, err
+	}
+	// Every template starts with a step too, since a template which calls
+	// itself twice takes exponential time
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			addTemplateSteps(t.Tree.Root, step.Tree.Root.Nodes[0])
+			t.Tree.Root.Nodes = append([]parse.Node{step.Tree.Root.Nodes[0]}, t.Tree.Root.Nodes...)
+		}
+	}
+	return tmpl, nil
+}
+
+// templateSteps returns the step function of a rendering, which fails once
+// the template has taken maxTemplateSteps steps. The steps are counted in
+// *steps, nil when the template is only parsed.
+func templateSteps(steps *int) template.FuncMap {
+	return template.FuncMap{stepFunc: func() (string, error) {
+		if steps != nil {
+			if *steps++; *steps > maxTemplateSteps {
+				return "", errTemplateTooLong
+			}
+		}
+		return "", nil
+	}}
+}
+
+// addTemplateSteps inserts the step action at the start of the body of every
+// range loop under the node.
+func addTemplateSteps(node parse.Node, step parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			addTemplateSteps(child, step)
+		}
+	case *parse.IfNode:
+		addTemplateSteps(n.List, step)
+		addTemplateSteps(n.ElseList, step)
+	case *parse.WithNode:
+		addTemplateSteps(n.List, step)
+		addTemplateSteps(n.ElseList, step)
+	case *parse.RangeNode:
+		addTemplateSteps(n.List, step)
+		addTemplateSteps(n.ElseList, step)
+		n.List.Nodes = append([]parse.Node{step}, n.List.Nodes...)
+	}
+}
+
+// limitedBuffer is a buffer which refuses to grow beyond its limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, errTemplateTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+// RenderEmailTemplate executes the subject and body of the template with the
+// given data.
+func RenderEmailTemplate(t EmailTemplate, data interface{}) (subject, body string, err error) {
+	render := func(text string) (string, error) {
+		tmpl, err := parseTemplate(text)
+		if err != nil {
+			return "", err
+		}
+		buf := &limitedBuffer{limit: maxRenderedTemplateSize}
+		var steps int
+		if err := tmpl.Funcs(templateSteps(&steps)).Execute(buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	if subject, err = render(t.Subject); err != nil {
+		return "", "", fmt.Errorf("render subject err, %w", err)
+	}
+	if body, err = render(t.Body); err != nil {
+		return "", "", fmt.Errorf("render body err, %w", err)
+	}
+	return subject, body, nil
+}
+
+// SaveEmailTemplate stores the template as a new version and returns the
+// stored template. It runs in the transaction of the caller, so that two
+// saves do not get the same version.
+func SaveEmailTemplate(tx Querier, t EmailTemplate) (EmailTemplate, error) {
+	var latest int
+	err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM email_template WHERE name = ?",
+		t.Name).Scan(&latest)
+	if err != nil {
+		return EmailTemplate{}, fmt.Errorf("read latest template version err, %w", err)
+	}
+	t.Version = latest + 1
+	t.CreateTime = time.Now()
+	_, err = tx.Exec("INSERT INTO email_template (name, version, subject, body, createTime) VALUES(?,?,?,?,?)",
+		t.Name, t.Version, t.Subject, t.Body, t.CreateTime)
+	if err != nil {
+		return EmailTemplate{}, fmt.Errorf("insert template err, %w", err)
+	}
+	return t, nil
+}
+
+// FindEmailTemplate reads a version of a template. Version 0 reads the latest
+// version. It returns sql.ErrNoRows if there is no such template.
+func FindEmailTemplate(db *sql.DB, name string, version int) (EmailTemplate, error) {
+	query := "SELECT name, version, subject, body, createTime FROM email_template WHERE name = ? ORDER BY version DESC LIMIT 1"
+	args := []interface{}{name}
+	if version != 0 {
+		query = "SELECT name, version, subject, body, createTime FROM email_template WHERE name = ? AND version = ?"
+		args = append(args, version)
+	}
+	var t EmailTemplate
+	err := db.QueryRow(query, args...).Scan(&t.Name, &t.Version, &t.Subject, &t.Body, &t.CreateTime)
+	return t, err
+}
+
+// ListEmailTemplateVersions reads all versions of a template, newest first.
+func ListEmailTemplateVersions(db *sql.DB, name string) ([]EmailTemplate, error) {
+	rows, err := db.Query("SELECT name, version, subject, body, createTime FROM email_template WHERE name = ? ORDER BY version DESC",
+		name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var templates []EmailTemplate
+	for rows.Next() {
+		var t EmailTemplate
+		if err := rows.Scan(&t.Name, &t.Version, &t.Subject, &t.Body, &t.CreateTime); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// GetEmailTemplate retrieves the latest version of a template, or the version
+// given by the version query parameter. The templates are only for admins.
+func (s *Server) GetEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var version int
+	if v := r.URL.Query().Get("version"); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil || version < 1 {
+			HandleErr(w, http.StatusBadRequest, "Invalid template version")
+			return
+		}
+	}
+
+	t, err := FindEmailTemplate(s.db, mux.Vars(r)["name"], version)
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The template does not exist")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the template")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(t); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the template")
+		return
+	}
+}
+
+// ListEmailTemplateVersions retrieves every version of a template.
+func (s *Server) ListEmailTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	templates, err := ListEmailTemplateVersions(s.db, mux.Vars(r)["name"])
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the template")
+		return
+	}
+	if len(templates) == 0 {
+		HandleErr(w, http.StatusNotFound, "The template does not exist")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(templates); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the template")
+		return
+	}
+}
+
+// UpdateEmailTemplate stores a new version of a template. The template is
+// created if it does not exist.
+func (s *Server) UpdateEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var t EmailTemplate
+	if err := decodeJSON(r, &t); err != nil {
+		handleDecodeErr(w, err, "Failed to decode template")
+		return
+	}
+	t.Name = mux.Vars(r)["name"]
+	if err := validateEmailTemplate(t); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		var err error
+		t, err = SaveEmailTemplate(tx, t)
+		return err
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the template")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(t); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the template")
+		return
+	}
+}
+
+// previewRequest is the body of a preview request. Subject and Body can be
+// set to preview an edit before it is saved.
+type previewRequest struct {
+	Subject string                 `json:"subject"`
+	Body    string                 `json:"body"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// PreviewEmailTemplate renders a template with the given data, or with sample
+// data if no data was given.
+func (s *Server) PreviewEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var req previewRequest
+	if err := decodeJSON(r, &req); err != nil && err != io.EOF {
+		handleDecodeErr(w, err, "Failed to decode preview request")
+		return
+	}
+
+	t := EmailTemplate{Name: mux.Vars(r)["name"], Subject: req.Subject, Body: req.Body}
+	if t.Subject == "" || t.Body == "" {
+		stored, err := FindEmailTemplate(s.db, t.Name, 0)
+		if errors.Is(err, sql.ErrNoRows) {
+			HandleErr(w, http.StatusNotFound, "The template does not exist")
+			return
+		}
+		if err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the template")
+			return
+		}
+		if t.Subject == "" {
+			t.Subject = stored.Subject
+		}
+		if t.Body == "" {
+			t.Body = stored.Body
+		}
+	}
+	var data interface{} = sampleTemplateData
+	if req.Data != nil {
+		data = req.Data
+	}
+
+	subject, body, err := RenderEmailTemplate(t, data)
+	if err != nil {
+		HandleErr(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	err = json.NewEncoder(w).Encode(map[string]string{"subject": subject, "body": body})
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the preview")
+		return
+	}
+}
@@ -0,0 +1,103 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailTemplates(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	admin := createMemberSession(t, db, "admin", RoleAdmin)
+
+	t.Run("Lets only admins read and change the templates", func(t *testing.T) {
+		librarian := createMemberSession(t, db, "astrid", RoleLibrarian)
+		for _, r := range []struct{ method, path string }{
+			{http.MethodGet, "/api/admin/templates/overdue"},
+			{http.MethodGet, "/api/admin/templates/overdue/versions"},
+			{http.MethodPut, "/api/admin/templates/overdue"},
+			{http.MethodPost, "/api/admin/templates/overdue:preview"},
+		} {
+			response := createNewRequest(r.method, r.path, nil, db)
+			require.Equal(t, http.StatusUnauthorized, response.Code, r.path)
+			response = createNewMemberRequest(r.method, r.path, nil, db, librarian)
+			require.Equal(t, http.StatusForbidden, response.Code, r.path)
+		}
+	})
+
+	t.Run("Every update stores a new version", func(t *testing.T) {
+		for _, subject := range []string{"Overdue", "Overdue: {{.Book.Title}}"} {
+			jsonBytes, err := json.Marshal(EmailTemplate{
+				Subject: subject,
+				Body:    "Hi {{.Member.FirstName}}, return it by {{date .DueDate \"2006-01-02\"}}",
+			})
+			require.NoError(t, err)
+			response := createNewMemberRequest(http.MethodPut,
+				"/api/admin/templates/overdue", jsonBytes, db, admin)
+			assertStatus(t, response.Code, http.StatusOK, "Should get status "+
+				"code 200: status OK")
+		}
+
+		versions, err := ListEmailTemplateVersions(db, "overdue")
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+		require.Equal(t, 2, versions[0].Version)
+	})
+
+	t.Run("Previews the latest version with sample data", func(t *testing.T) {
+		response := createNewMemberRequest(http.MethodPost,
+			"/api/admin/templates/overdue:preview", nil, db, admin)
+
+		var got map[string]string
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		assertStatus(t, response.Code, http.StatusOK, "Should get status "+
+			"code 200: status OK")
+		require.Equal(t, "Overdue: Pippi Longstocking", got["subject"])
+		require.Equal(t, "Hi Astrid, return it by 2021-10-01", got["body"])
+	})
+
+	t.Run("Previewing with missing data fails", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(previewRequest{
+			Data: map[string]interface{}{"Member": map[string]string{}},
+		})
+		response := createNewMemberRequest(http.MethodPost,
+			"/api/admin/templates/overdue:preview", jsonBytes, db, admin)
+
+		assertStatus(t, response.Code, http.StatusUnprocessableEntity, "Should "+
+			"get status code 422: status unprocessable entity")
+	})
+
+	t.Run("Templates which do not parse are rejected", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(EmailTemplate{
+			Subject: "{{.Book.Title",
+			Body:    "{{exec \"rm\"}}",
+		})
+		response := createNewMemberRequest(http.MethodPut,
+			"/api/admin/templates/overdue", jsonBytes, db, admin)
+
+		assertStatus(t, response.Code, http.StatusNotAcceptable, "Should get "+
+			"status code 406: status not acceptable")
+	})
+
+	t.Run("Templates which loop too long fail to render", func(t *testing.T) {
+		data := map[string]interface{}{"Items": make([]int, 1000)}
+		_, _, err := RenderEmailTemplate(EmailTemplate{Subject: "Overdue",
+			Body: "{{range .Items}}{{range $.Items}}{{end}}{{end}}"}, data)
+		require.ErrorIs(t, err, errTemplateTooLong)
+
+		_, _, err = RenderEmailTemplate(EmailTemplate{Subject: "Overdue", Body: "{{range 10000000000}}{{end}}"}, data)
+		require.Error(t, err)
+		_, _, err = RenderEmailTemplate(EmailTemplate{Subject: "Overdue",
+			Body: `{{define "a"}}{{template "a"}}{{template "a"}}{{end}}{{template "a"}}`}, data)
+		require.Error(t, err)
+
+		subject, body, err := RenderEmailTemplate(EmailTemplate{Subject: "{{range .Items}}{{end}}Overdue",
+			Body: "{{range $i, $_ := .Items}}{{if eq $i 1}}Return it{{end}}{{end}}"}, data)
+		require.NoError(t, err)
+		require.Equal(t, "Overdue", subject)
+		require.Equal(t, "Return it", body)
+	})
+}
@@ -0,0 +1,31 @@
+package library
+
+import "time"
+
+// availableAt reports whether the book is visible on the public endpoints at
+// the given time. Drafts are never visible.
+func (b Book) availableAt(now time.Time) bool {
+	return b.Status != StatusDraft && (b.AvailableFrom == nil || !b.AvailableFrom.After(now))
+}
+
+// ReadPublicBookList reads the books which are not drafts and not embargoed
+// at the given time.
+func ReadPublicBookList(db Querier, now time.Time) []Book {
+	var books []Book
+	for _, b := range ReadDatabaseList(db) {
+		if b.availableAt(now) {
+			books = append(books, b)
+		}
+	}
+	return books
+}
+
+// FindPublicBook reads a book if it exists, is not a draft and is not
+// embargoed at the given time, otherwise it returns an empty book.
+func FindPublicBook(db Querier, isbn string, now time.Time) Book {
+	b := FindSpecificBook(db, isbn)
+	if !b.availableAt(now) {
+		return Book{}
+	}
+	return b
+}
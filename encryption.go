@@ -0,0 +1,348 @@
+package library
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NicolaiMordrup/library/kms"
+)
+
+// The kinds of data keys.
+const (
+	dataKeyEncrypt = "data"  // Encrypts the fields, the latest one is used
+	dataKeyIndex   = "index" // Hashes the email addresses for lookups
+)
+
+// encryptedPrefix starts the encrypted fields, followed by the id of the
+// data key, e.g. enc:1:<base64>.
+const encryptedPrefix = "enc:"
+
+// errNoEncryptionKey is returned when a secret is stored without a key
+// encryption key.
+var errNoEncryptionKey = errors.New("no encryption key")
+
+// errNoDataKey is returned when there is no data key of a kind yet.
+var errNoDataKey = errors.New("no data key")
+
+// WithKeyEncrypter encrypts the secrets and the personal data of the members
+// in the database with envelope encryption. The fields are encrypted with
+// data keys, which are stored wrapped with the key encryption key. Without
+// it secrets such as the TOTP secrets can not be stored, and the personal
+// data is stored in plain text.
+func WithKeyEncrypter(kek kms.KeyEncrypter) ServerOption {
+	return func(s *Server) {
+		s.keys = newKeyring(kek)
+	}
+}
+
+// keyring encrypts with the data keys of the database, which are unwrapped
+// on first use. A nil keyring stores the fields in plain text.
+type keyring struct {
+	kek  kms.KeyEncrypter
+	mu   sync.Mutex
+	keys map[int64][]byte // Unwrapped data keys by id
+}
+
+func newKeyring(kek kms.KeyEncrypter) *keyring {
+	return &keyring{kek: kek, keys: make(map[int64][]byte)}
+}
+
+// key returns the unwrapped data key with the given id.
+func (k *keyring) key(q Querier, id int64) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[id]; ok {
+		return key, nil
+	}
+	var wrapped string
+	err := q.QueryRow("SELECT wrappedKey FROM data_key WHERE id = ?", id).Scan(&wrapped)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("read data key err, no data key %d", id)
+	} else if err != nil {
+		return nil, fmt.Errorf("read data key err, %w", err)
+	}
+	key, err := k.kek.UnwrapKey(context.Background(), wrapped)
+	if err != nil {
+		return nil, err
+	}
+	k.keys[id] = key
+	return key, nil
+}
+
+// latestKey returns the latest data key of the kind. When there is none, a
+// key is created if create is set and errNoDataKey is returned otherwise.
+func (k *keyring) latestKey(q Querier, kind string, create bool) (int64, []byte, error) {
+	var id int64
+	if err := q.QueryRow("SELECT COALESCE(MAX(id), 0) FROM data_key WHERE kind = ?", kind).Scan(&id); err != nil {
+		return 0, nil, fmt.Errorf("read data key err, %w", err)
+	}
+	if id == 0 && !create {
+		return 0, nil, errNoDataKey
+	}
+	if id == 0 {
+		return k.newKey(q, kind)
+	}
+	key, err := k.key(q, id)
+	return id, key, err
+}
+
+// newKey creates a data key of the kind, which becomes the latest one.
+func (k *keyring) newKey(q Querier, kind string) (int64, []byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return 0, nil, fmt.Errorf("create data key err, %w", err)
+	}
+	wrapped, err := k.kek.WrapKey(context.Background(), key)
+	if err != nil {
+		return 0, nil, err
+	}
+	res, err := q.Exec("INSERT INTO data_key (kind, wrappedKey, createTime) VALUES(?,?,?)", kind, wrapped, time.Now().Unix())
+	if err != nil {
+		return 0, nil, fmt.Errorf("insert data key err, %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, nil, fmt.Errorf("insert data key err, %w", err)
+	}
+	k.mu.Lock()
+	k.keys[id] = key
+	k.mu.Unlock()
+	return id, key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher err, %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt encrypts and authenticates the plaintext with the latest data key.
+func (k *keyring) encrypt(q Querier, plaintext []byte) (string, error) {
+	if k == nil {
+		return "", errNoEncryptionKey
+	}
+	id, key, err := k.latestKey(q, dataKeyEncrypt, true)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("create nonce err, %w", err)
+	}
+	return encryptedPrefix + strconv.FormatInt(id, 10) + ":" +
+		base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// decrypt decrypts a ciphertext of encrypt.
+func (k *keyring) decrypt(q Querier, ciphertext string) ([]byte, error) {
+	if k == nil {
+		return nil, errNoEncryptionKey
+	}
+	parts := strings.SplitN(strings.TrimPrefix(ciphertext, encryptedPrefix), ":", 2)
+	if len(parts) != 2 || !strings.HasPrefix(ciphertext, encryptedPrefix) {
+		return nil, errors.New("decrypt err, malformed ciphertext")
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.New("decrypt err, malformed ciphertext")
+	}
+	key, err := k.key(q, id)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(b) < aead.NonceSize() {
+		return nil, errors.New("decrypt err, malformed ciphertext")
+	}
+	plaintext, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt err, %w", err)
+	}
+	return plaintext, nil
+}
+
+// sealField encrypts a field of personal data, which is kept in plain text
+// without a key encryption key.
+func (k *keyring) sealField(q Querier, value string) (string, error) {
+	if k == nil || value == "" {
+		return value, nil
+	}
+	return k.encrypt(q, []byte(value))
+}
+
+// openField decrypts a field of sealField. Fields which were stored before
+// the encryption was enabled are in plain text.
+func (k *keyring) openField(q Querier, stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		return stored, nil
+	}
+	b, err := k.decrypt(q, stored)
+	return string(b), err
+}
+
+// emailHash returns the value of member.emailHash of an email, a keyed hash
+// so that the encrypted email addresses can be looked up, ignoring case.
+func (k *keyring) emailHash(q Querier, email string, create bool) (string, error) {
+	email = strings.ToLower(email)
+	if k == nil {
+		return email, nil
+	}
+	_, key, err := k.latestKey(q, dataKeyIndex, create)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(email))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// emailHashes returns the values of member.emailHash which the member with
+// the email may have, the plain one if it was stored before the encryption
+// was enabled.
+func (k *keyring) emailHashes(q Querier, email string) ([]interface{}, error) {
+	hashes := []interface{}{strings.ToLower(email)}
+	hash, err := k.emailHash(q, email, false)
+	if errors.Is(err, errNoDataKey) {
+		return hashes, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return append(hashes, hash), nil
+}
+
+// RotateKeys encrypts the encrypted fields of the database with a new data
+// key, wrapped with newKEK. The index key is wrapped with newKEK too, and
+// the previous data keys are deleted, so that oldKEK is no longer needed
+// once it returns. Fields which were stored in plain text are encrypted.
+// The servers must be restarted with newKEK if it differs from oldKEK. It
+// returns the number of rows which were encrypted.
+func RotateKeys(ctx context.Context, db *sql.DB, oldKEK, newKEK kms.KeyEncrypter) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx err, %w", err)
+	}
+	defer tx.Rollback()
+	old, next := newKeyring(oldKEK), newKeyring(newKEK)
+
+	indexID, indexKey, err := old.latestKey(tx, dataKeyIndex, false)
+	if errors.Is(err, errNoDataKey) {
+		_, _, err = next.newKey(tx, dataKeyIndex)
+	} else if err == nil {
+		var wrapped string
+		if wrapped, err = newKEK.WrapKey(ctx, indexKey); err == nil {
+			_, err = tx.Exec("UPDATE data_key SET wrappedKey = ? WHERE id = ?", wrapped, indexID)
+			next.keys[indexID] = indexKey
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	dataID, _, err := next.newKey(tx, dataKeyEncrypt)
+	if err != nil {
+		return 0, err
+	}
+
+	type member struct{ id, email, phone string }
+	var members []member
+	rows, err := tx.Query("SELECT id, email, phone FROM member")
+	if err != nil {
+		return 0, fmt.Errorf("query members err, %w", err)
+	}
+	for rows.Next() {
+		var m member
+		if err := rows.Scan(&m.id, &m.email, &m.phone); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan member err, %w", err)
+		}
+		members = append(members, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("query members err, %w", err)
+	}
+	for _, m := range members {
+		email, err := old.openField(tx, m.email)
+		if err != nil {
+			return 0, err
+		}
+		phone, err := old.openField(tx, m.phone)
+		if err != nil {
+			return 0, err
+		}
+		emailHash, err := next.emailHash(tx, email, false)
+		if err != nil {
+			return 0, err
+		}
+		if m.email, err = next.sealField(tx, email); err != nil {
+			return 0, err
+		}
+		if m.phone, err = next.sealField(tx, phone); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("UPDATE member SET email = ?, emailHash = ?, phone = ? WHERE id = ?", m.email, emailHash, m.phone, m.id); err != nil {
+			return 0, fmt.Errorf("update member err, %w", err)
+		}
+	}
+
+	type secret struct{ memberID, secret string }
+	var secrets []secret
+	rows, err = tx.Query("SELECT memberId, secret FROM member_totp")
+	if err != nil {
+		return 0, fmt.Errorf("query totp err, %w", err)
+	}
+	for rows.Next() {
+		var s secret
+		if err := rows.Scan(&s.memberID, &s.secret); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan totp err, %w", err)
+		}
+		secrets = append(secrets, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("query totp err, %w", err)
+	}
+	for _, s := range secrets {
+		b, err := old.decrypt(tx, s.secret)
+		if err != nil {
+			return 0, err
+		}
+		if s.secret, err = next.encrypt(tx, b); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("UPDATE member_totp SET secret = ? WHERE memberId = ?", s.secret, s.memberID); err != nil {
+			return 0, fmt.Errorf("update totp err, %w", err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM data_key WHERE kind = ? AND id != ?", dataKeyEncrypt, dataID); err != nil {
+		return 0, fmt.Errorf("delete data keys err, %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit err, %w", err)
+	}
+	return len(members) + len(secrets), nil
+}
@@ -0,0 +1,88 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NicolaiMordrup/library/kms"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemberEncryption(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	oldKEK, err := kms.NewLocalKey(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	newKEK, err := kms.NewLocalKey(bytes.Repeat([]byte{2}, 32))
+	require.NoError(t, err)
+
+	stored := func(id string) (email, emailHash, phone string) {
+		t.Helper()
+		require.NoError(t, db.QueryRow("SELECT email, emailHash, phone FROM member WHERE id = ?", id).Scan(&email, &emailHash, &phone))
+		return email, emailHash, phone
+	}
+
+	// Stored before the encryption was enabled
+	plain := NewServer(db)
+	require.NoError(t, plain.InsertMember(db, Member{ID: "emil", Email: "emil@example.com", FirstName: "emil",
+		LastName: "svensson", Phone: "+46 8 123 456", CreateTime: time.Now().UTC()}, ""))
+	email, _, phone := stored("emil")
+	require.Equal(t, "emil@example.com", email)
+	require.Equal(t, "+46 8 123 456", phone)
+
+	server := NewServer(db, WithKeyEncrypter(oldKEK))
+	t.Run("Encrypts the personal data", func(t *testing.T) {
+		require.NoError(t, server.InsertMember(db, Member{ID: "astrid", Email: "Astrid@example.com", FirstName: "astrid",
+			LastName: "lindgren", Phone: "+46 8 654 321", CreateTime: time.Now().UTC()}, ""))
+		email, emailHash, phone := stored("astrid")
+		require.Regexp(t, `^enc:\d+:`, email)
+		require.Regexp(t, `^hmac:[0-9a-f]{64}$`, emailHash)
+		require.Regexp(t, `^enc:\d+:`, phone)
+
+		m, err := server.FindMemberByEmail(db, "astrid@EXAMPLE.com")
+		require.NoError(t, err)
+		require.Equal(t, "Astrid@example.com", m.Email)
+		require.Equal(t, "+46 8 654 321", m.Phone)
+		_, err = plain.FindMember(db, "astrid")
+		require.ErrorIs(t, err, errNoEncryptionKey)
+	})
+
+	t.Run("Finds the members stored in plain text", func(t *testing.T) {
+		m, err := server.FindMemberByEmail(db, "emil@example.com")
+		require.NoError(t, err)
+		require.Equal(t, "emil", m.ID)
+	})
+
+	t.Run("Rotates the keys", func(t *testing.T) {
+		secret, err := server.keys.encrypt(db, []byte("totp secret"))
+		require.NoError(t, err)
+		_, err = db.Exec("INSERT INTO member_totp (memberId, secret) VALUES('astrid', ?)", secret)
+		require.NoError(t, err)
+
+		n, err := RotateKeys(context.Background(), db, oldKEK, newKEK)
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		email, emailHash, phone := stored("emil")
+		require.Regexp(t, `^enc:\d+:`, email)
+		require.Regexp(t, `^hmac:`, emailHash)
+		require.Regexp(t, `^enc:\d+:`, phone)
+
+		rotated := NewServer(db, WithKeyEncrypter(newKEK))
+		for email, id := range map[string]string{"ASTRID@example.com": "astrid", "emil@example.com": "emil"} {
+			m, err := rotated.FindMemberByEmail(db, email)
+			require.NoError(t, err)
+			require.Equal(t, id, m.ID)
+		}
+		plaintext, _, _, err := rotated.readTOTP(db, "astrid")
+		require.NoError(t, err)
+		require.Equal(t, []byte("totp secret"), plaintext)
+
+		var dataKeys int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM data_key WHERE kind = ?", dataKeyEncrypt).Scan(&dataKeys))
+		require.Equal(t, 1, dataKeys)
+		_, err = NewServer(db, WithKeyEncrypter(oldKEK)).FindMember(db, "astrid")
+		require.Error(t, err, "the old key encryption key should no longer be needed")
+	})
+}
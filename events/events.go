@@ -0,0 +1,276 @@
+// Package events publishes the changes of the library to a message bus, so
+// that other systems can react to them. The events are written to an outbox
+// in the transaction of the change and published by a background dispatcher,
+// see library.OutboxDispatcher. The events of an upstream catalogue can be
+// received too, see library.CatalogueConsumer.
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The types of events.
+const (
+	TypeBookCreated = "book.created"
+	TypeBookUpdated = "book.updated"
+	TypeBookDeleted = "book.deleted"
+)
+
+// SchemaVersion is the version of the JSON schema of the events, it is
+// increased when a change would break consumers.
+const SchemaVersion = 1
+
+// Event is a change of the library. Events are published at least once, so
+// consumers should skip the IDs they have already seen.
+type Event struct {
+	Version int             `json:"version"` // The SchemaVersion of the event
+	ID      int64           `json:"id"`      // Increasing in the order of the changes
+	Type    string          `json:"type"`    // One of the Type constants
+	Subject string          `json:"subject"` // The ISBN of the changed book
+	Time    time.Time       `json:"time"`
+	Data    json.RawMessage `json:"data,omitempty"` // The book after the change
+}
+
+// Publisher sends an event to a message bus.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// Subscriber receives the events of a message bus and passes them to handle,
+// in the order they were published. Subscribe returns when the context is
+// done or the connection is lost.
+type Subscriber interface {
+	Subscribe(ctx context.Context, handle func(Event) error) error
+}
+
+// NATSPublisher publishes events to a NATS server, on the subject of the
+// prefix and the event type, e.g. "library.book.created". The connection is
+// opened on the first publish and opened again after an error.
+type NATSPublisher struct {
+	Addr          string // host:port of the NATS server
+	SubjectPrefix string
+	Timeout       time.Duration // Of each publish, defaults to 10 seconds
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewNATSPublisher creates a publisher for the NATS server at the URL, e.g.
+// nats://localhost:4222.
+func NewNATSPublisher(rawURL, subjectPrefix string) (*NATSPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q", rawURL)
+	}
+	return &NATSPublisher{Addr: u.Host, SubjectPrefix: subjectPrefix}, nil
+}
+
+// Publish sends the event and waits until the server has received it. The
+// server is pinged after the message, since NATS does not acknowledge
+// messages unless JetStream is used.
+func (p *NATSPublisher) Publish(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode event err, %w", err)
+	}
+	subject := e.Type
+	if p.SubjectPrefix != "" {
+		subject = p.SubjectPrefix + "." + e.Type
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.publish(ctx, subject, payload); err != nil {
+		if p.conn != nil {
+			p.conn.Close()
+			p.conn = nil
+		}
+		return fmt.Errorf("publish event %d to NATS err, %w", e.ID, err)
+	}
+	return nil
+}
+
+func (p *NATSPublisher) publish(ctx context.Context, subject string, payload []byte) error {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if p.conn == nil {
+		if err := p.connect(ctx, deadline); err != nil {
+			return err
+		}
+	}
+	if err := p.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(p.conn, "PUB %s %d\r\n%s\r\nPING\r\n", subject, len(payload), payload); err != nil {
+		return err
+	}
+	for {
+		line, err := readNATSLine(p.r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// connect opens the connection.
+func (p *NATSPublisher) connect(ctx context.Context, deadline time.Time) error {
+	conn, r, err := dialNATS(ctx, p.Addr, deadline)
+	if err != nil {
+		return err
+	}
+	p.conn, p.r = conn, r
+	return nil
+}
+
+// Close closes the connection to the server.
+func (p *NATSPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// NATSSubscriber receives the events published on a NATS subject, e.g.
+// "catalogue.book.*". NATS does not store messages, so events published
+// while the subscriber is disconnected are missed.
+type NATSSubscriber struct {
+	Addr    string // host:port of the NATS server
+	Subject string
+}
+
+// NewNATSSubscriber creates a subscriber for the NATS server at the URL, e.g.
+// nats://localhost:4222.
+func NewNATSSubscriber(rawURL, subject string) (*NATSSubscriber, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q", rawURL)
+	}
+	return &NATSSubscriber{Addr: u.Host, Subject: subject}, nil
+}
+
+// Subscribe receives the events until the context is done. Messages which
+// are not events are skipped, an error from handle ends the subscription.
+func (s *NATSSubscriber) Subscribe(ctx context.Context, handle func(Event) error) error {
+	conn, r, err := dialNATS(ctx, s.Addr, time.Now().Add(10*time.Second))
+	if err != nil {
+		return fmt.Errorf("connect to NATS err, %w", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	if _, err := fmt.Fprintf(conn, "SUB %s 1\r\n", s.Subject); err != nil {
+		return err
+	}
+	for {
+		line, err := readNATSLine(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("read from NATS err, %w", err)
+		}
+		fields := strings.Fields(line)
+		switch {
+		case line == "PING":
+			if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case len(fields) >= 4 && fields[0] == "MSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			n, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return fmt.Errorf("invalid message size %q", line)
+			}
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return fmt.Errorf("read from NATS err, %w", err)
+			}
+			var e Event
+			if err := json.Unmarshal(payload[:n], &e); err != nil {
+				continue
+			}
+			if err := handle(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// dialNATS opens a connection to a NATS server, the server greets with an
+// INFO line which is answered by CONNECT.
+func dialNATS(ctx context.Context, addr string, deadline time.Time) (net.Conn, *bufio.Reader, error) {
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	line, err := readNATSLine(r)
+	if err == nil && !strings.HasPrefix(line, "INFO") {
+		err = fmt.Errorf("unexpected greeting %q", line)
+	}
+	if err == nil {
+		_, err = conn.Write([]byte(`CONNECT {"verbose":false,"pedantic":false,"name":"library"}` + "\r\n"))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, r, nil
+}
+
+func readNATSLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
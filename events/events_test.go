@@ -0,0 +1,130 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNATSServer accepts a single connection and sends the subjects and
+// payloads of the published messages on the channel.
+func fakeNATSServer(t *testing.T, published chan<- [2]string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PUB":
+				n, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, n+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				published <- [2]string{fields[1], string(payload[:n])}
+			case "PING":
+				fmt.Fprintf(conn, "PONG\r\n")
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestNATSPublisher(t *testing.T) {
+	published := make(chan [2]string, 2)
+	p, err := NewNATSPublisher("nats://"+fakeNATSServer(t, published), "library")
+	require.NoError(t, err)
+	defer p.Close()
+
+	for i, typ := range []string{TypeBookCreated, TypeBookDeleted} {
+		e := Event{ID: int64(i + 1), Type: typ, Subject: "1233211233215", Time: time.Now()}
+		require.NoError(t, p.Publish(context.Background(), e))
+
+		msg := <-published
+		require.Equal(t, "library."+typ, msg[0])
+		var got Event
+		require.NoError(t, json.Unmarshal([]byte(msg[1]), &got))
+		require.Equal(t, e.ID, got.ID)
+		require.Equal(t, e.Subject, got.Subject)
+	}
+
+	_, err = NewNATSPublisher("http://localhost:4222", "library")
+	require.Error(t, err, "only nats:// URLs should be accepted")
+}
+
+func TestNATSPublisherUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	p := &NATSPublisher{Addr: addr, Timeout: time.Second}
+	err = p.Publish(context.Background(), Event{ID: 1, Type: TypeBookCreated})
+	require.Error(t, err)
+}
+
+func TestNATSSubscriber(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	subscribed := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if fields := strings.Fields(line); fields[0] == "SUB" {
+				subscribed <- fields[1]
+				for _, payload := range []string{"not an event", `{"version":1,"id":7,"type":"book.deleted","subject":"1233211233215"}`} {
+					fmt.Fprintf(conn, "PING\r\nMSG %s 1 %d\r\n%s\r\n", fields[1], len(payload), payload)
+				}
+			}
+		}
+	}()
+
+	s, err := NewNATSSubscriber("nats://"+l.Addr().String(), "catalogue.book.*")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var received []Event
+	err = s.Subscribe(ctx, func(e Event) error {
+		received = append(received, e)
+		cancel()
+		return nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, "catalogue.book.*", <-subscribed)
+	require.Len(t, received, 1, "messages which are not events should be skipped")
+	require.Equal(t, int64(7), received[0].ID)
+	require.Equal(t, TypeBookDeleted, received[0].Type)
+}
@@ -0,0 +1,28 @@
+package library
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// bookExpansions are the related resources which can be embedded in a book
+// with the expand query parameter.
+var bookExpansions = map[string]bool{"reviews": true}
+
+// parseExpand reads the expand query parameter, e.g. ?expand=reviews.
+func parseExpand(r *http.Request) (map[string]bool, error) {
+	expand := make(map[string]bool)
+	param := r.URL.Query().Get("expand")
+	if param == "" {
+		return expand, nil
+	}
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if !bookExpansions[name] {
+			return nil, fmt.Errorf("expand must be a list of reviews, %q can not be expanded", name)
+		}
+		expand[name] = true
+	}
+	return expand, nil
+}
@@ -0,0 +1,71 @@
+package library
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// facetColumns are the facets which can be counted, by the column of the
+// library table which they group by.
+var facetColumns = map[string]string{
+	"publisher": "publisher",
+	"language":  "language",
+	"format":    "format",
+}
+
+// FacetCount is the number of results with a value of a facet.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// parseFacets reads the facets query parameter, e.g. ?facets=publisher,format.
+func parseFacets(r *http.Request) ([]string, error) {
+	param := r.URL.Query().Get("facets")
+	if param == "" {
+		return nil, nil
+	}
+	var facets []string
+	for _, name := range strings.Split(param, ",") {
+		if _, ok := facetColumns[name]; !ok {
+			names := make([]string, 0, len(facetColumns))
+			for n := range facetColumns {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("facets must be a list of %s, %q is not a facet", strings.Join(names, ", "), name)
+		}
+		facets = append(facets, name)
+	}
+	return facets, nil
+}
+
+// CountFacet counts the books with the given isbns by the values of a facet,
+// the most common value first. Books without a value are not counted.
+func CountFacet(db Querier, facet string, isbns []string) ([]FacetCount, error) {
+	counts := []FacetCount{}
+	if len(isbns) == 0 {
+		return counts, nil
+	}
+	column := facetColumns[facet]
+	args := make([]interface{}, len(isbns))
+	for i, isbn := range isbns {
+		args[i] = isbn
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT %[1]s, COUNT(*) FROM library WHERE isbn IN (?%[2]s) AND %[1]s != '' "+
+		"GROUP BY %[1]s ORDER BY COUNT(*) DESC, %[1]s", column, strings.Repeat(",?", len(isbns)-1)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("count %s facet err, %w", facet, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c FacetCount
+		if err := rows.Scan(&c.Value, &c.Count); err != nil {
+			return nil, fmt.Errorf("scan %s facet err, %w", facet, err)
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,100 @@
+package library
+
+import (
+	"encoding/xml"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// feedSize is the number of books in the feed of new books.
+const feedSize = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Author    *atomPerson `xml:"author,omitempty"`
+	Link      atomLink    `xml:"link"`
+	Summary   string      `xml:"summary,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+// externalURL returns the absolute URL of path on the host of the request.
+func externalURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// GetBookFeed retrieves the most recently created books as an Atom feed so
+// that patrons can subscribe to new arrivals.
+func (s *Server) GetBookFeed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	books := ReadPublicBookList(s.db, time.Now())
+	sort.SliceStable(books, func(i, j int) bool {
+		return books[i].CreateTime.After(books[j].CreateTime)
+	})
+	if len(books) > feedSize {
+		books = books[:feedSize]
+	}
+
+	// The links point at the books in the same version of the API
+	booksPath := strings.TrimSuffix(r.URL.Path, "/feed.atom")
+	feed := atomFeed{
+		Title: "New books",
+		ID:    externalURL(r, r.URL.Path),
+		Link: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: externalURL(r, r.URL.Path)},
+		},
+		Updated: time.Unix(0, 0).UTC().Format(time.RFC3339),
+	}
+	for i, b := range books {
+		created := b.CreateTime.UTC().Format(time.RFC3339)
+		if i == 0 {
+			feed.Updated = created
+		}
+		entry := atomEntry{
+			Title:     b.Title,
+			ID:        "urn:isbn:" + b.ISBN,
+			Updated:   created,
+			Published: created,
+			Link:      atomLink{Rel: "alternate", Href: externalURL(r, booksPath+"/"+b.ISBN)},
+		}
+		if b.Author != nil {
+			entry.Author = &atomPerson{Name: strings.TrimSpace(b.Author.FirstName + " " + b.Author.LastName)}
+		}
+		if b.Publisher != "" {
+			entry.Summary = "Published by " + b.Publisher
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the feed")
+		return
+	}
+}
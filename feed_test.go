@@ -0,0 +1,54 @@
+package library
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBookFeed(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	embargo := time.Now().Add(time.Hour)
+	for _, b := range []Book{
+		{ISBN: "1233211233215", Title: "a new hope"},
+		{ISBN: "1233211233213", Title: "the empire strikes back"},
+		{ISBN: "1233211233210", Title: "return of the jedi", AvailableFrom: &embargo},
+	} {
+		b.Author = &Author{FirstName: "george", LastName: "lucas"}
+		b.Publisher = "adlibris"
+		jsonBytes, _ := json.Marshal(b)
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+b.ISBN, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code)
+	}
+
+	response := createNewRequest(http.MethodGet, "/api/v1/books/feed.atom", nil, db)
+	require.Equal(t, http.StatusOK, response.Code)
+	assertContentType(t, response, "application/atom+xml; charset=utf-8", "Should get an atom feed")
+
+	var got struct {
+		Entries []struct {
+			Title  string `xml:"title"`
+			ID     string `xml:"id"`
+			Author string `xml:"author>name"`
+			Link   struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+	require.NoError(t, xml.NewDecoder(response.Body).Decode(&got))
+
+	// Newest first, without the embargoed book
+	require.Len(t, got.Entries, 2)
+	require.Equal(t, "the empire strikes back", got.Entries[0].Title)
+	require.Equal(t, "urn:isbn:1233211233213", got.Entries[0].ID)
+	require.Equal(t, "george lucas", got.Entries[0].Author)
+	require.True(t, strings.HasSuffix(got.Entries[0].Link.Href, "/api/v1/books/1233211233213"))
+	require.Equal(t, "a new hope", got.Entries[1].Title)
+}
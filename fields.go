@@ -0,0 +1,134 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fieldSelection is the tree of fields selected by the fields query
+// parameter, e.g. ?fields=isbn,author.last_name. A field without children is
+// selected as a whole, and a nil selection selects everything.
+type fieldSelection map[string]fieldSelection
+
+// bookFields are the paths of the fields of a book which can be selected.
+var bookFields = fieldPaths(reflect.TypeOf(Book{}), "")
+
+// fieldPaths returns the dot separated JSON names of the fields of t and of
+// the fields nested in them.
+func fieldPaths(t reflect.Type, prefix string) map[string]bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	paths := make(map[string]bool)
+	if t.Kind() != reflect.Struct {
+		return paths
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.PkgPath != "" || name == "-" || name == "" {
+			continue
+		}
+		paths[prefix+name] = true
+		if f.Type == reflect.TypeOf(time.Time{}) {
+			continue
+		}
+		for p := range fieldPaths(f.Type, prefix+name+".") {
+			paths[p] = true
+		}
+	}
+	return paths
+}
+
+// camelCase converts a snake case field name to the camel case used by the
+// JSON encoding, e.g. last_name to lastName.
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// parseFields reads the fields query parameter. Field names may be given in
+// snake case or in camel case. Fields are only supported in JSON and YAML,
+// XML has no generic representation of a partial book and JSON:API has
+// sparse fieldsets of its own.
+func parseFields(r *http.Request) (fieldSelection, error) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, nil
+	}
+	if ct := negotiateContentType(r.Header.Get("Accept")); ct != jsonContentType && ct != yamlContentType {
+		return nil, fmt.Errorf("fields is only supported in JSON and YAML")
+	}
+	var unknown []string
+	sel := make(fieldSelection)
+	for _, field := range strings.Split(param, ",") {
+		path := camelCase(strings.TrimSpace(field))
+		if !bookFields[path] {
+			unknown = append(unknown, field)
+			continue
+		}
+		node := sel
+		for _, name := range strings.Split(path, ".") {
+			child, ok := node[name]
+			if !ok {
+				child = make(fieldSelection)
+				node[name] = child
+			}
+			node = child
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown fields: %v", strings.Join(unknown, ", "))
+	}
+	return sel, nil
+}
+
+// apply returns v reduced to the selected fields. v is converted to its
+// generic JSON representation, which encodes the same way in JSON and YAML.
+func (sel fieldSelection) apply(v interface{}) (interface{}, error) {
+	if sel == nil {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	return sel.prune(generic), nil
+}
+
+func (sel fieldSelection) prune(v interface{}) interface{} {
+	if len(sel) == 0 {
+		return v
+	}
+	switch v := v.(type) {
+	case []interface{}:
+		for i := range v {
+			v[i] = sel.prune(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		pruned := make(map[string]interface{}, len(sel))
+		for name, child := range sel {
+			if field, ok := v[name]; ok {
+				pruned[name] = child.prune(field)
+			}
+		}
+		return pruned
+	}
+	return v
+}
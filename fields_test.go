@@ -0,0 +1,48 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldSelection(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	isbn := "1233211233215"
+	jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: starWarsTitles[isbn],
+		Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "adlibris",
+		Description: "A long time ago in a galaxy far, far away"})
+	require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+
+	t.Run("Lists only the selected fields", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books?fields=isbn,title,author.last_name", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var got []map[string]interface{}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Equal(t, []map[string]interface{}{{
+			"isbn":   isbn,
+			"title":  starWarsTitles[isbn],
+			"author": map[string]interface{}{"lastName": "lucas"},
+		}}, got)
+	})
+
+	t.Run("Selects the fields of a single book", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books/"+isbn+"?fields=author,createTime", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var got map[string]interface{}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Len(t, got, 2)
+		require.Equal(t, map[string]interface{}{"firstName": "george", "lastName": "lucas"}, got["author"])
+		require.Contains(t, got, "createTime")
+	})
+
+	t.Run("Rejects unknown fields", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books?fields=isbn,author.middle_name", nil, db)
+		require.Equal(t, http.StatusBadRequest, response.Code)
+		require.Contains(t, response.Body.String(), "author.middle_name")
+	})
+}
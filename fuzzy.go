@@ -0,0 +1,111 @@
+package library
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sparseResults is the number of results below which a search is also run
+// with its misspelled terms corrected.
+const sparseResults = 3
+
+// maxEdits is the largest edit distance at which a term is corrected. Short
+// terms allow fewer edits, since most short words are a couple of edits
+// from each other.
+func maxEdits(term string) int {
+	if len([]rune(term)) <= 4 {
+		return 1
+	}
+	return 2
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b, the Levenshtein distance where swapping two adjacent letters, as in
+// "lucsa", counts as a single edit.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	d := make([][]int, len(ar)+1)
+	for i := range d {
+		d[i] = make([]int, len(br)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ar[i-1] == br[j-2] && ar[i-2] == br[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(ar)][len(br)]
+}
+
+// searchTerm is a term of the full-text index and the number of books which
+// contain it.
+type searchTerm struct {
+	term string
+	docs int
+}
+
+// readSearchTerms reads the terms of the full-text index.
+func readSearchTerms(db Querier) ([]searchTerm, error) {
+	rows, err := db.Query("SELECT term, doc FROM book_search_vocab")
+	if err != nil {
+		return nil, fmt.Errorf("query search terms err, %w", err)
+	}
+	defer rows.Close()
+	var terms []searchTerm
+	for rows.Next() {
+		var t searchTerm
+		if err := rows.Scan(&t.term, &t.docs); err != nil {
+			return nil, fmt.Errorf("scan search term err, %w", err)
+		}
+		terms = append(terms, t)
+	}
+	return terms, rows.Err()
+}
+
+// correctQuery replaces the terms of the query which are not in the index
+// by the closest term which is, preferring the most common term when
+// several are equally close. It reports whether any term was replaced.
+func correctQuery(db Querier, query string) (string, bool, error) {
+	vocabulary, err := readSearchTerms(db)
+	if err != nil {
+		return "", false, err
+	}
+	known := make(map[string]bool, len(vocabulary))
+	for _, t := range vocabulary {
+		known[t.term] = true
+	}
+
+	corrected := false
+	terms := strings.Fields(normalizeText(query))
+	for i, term := range terms {
+		if known[term] {
+			continue
+		}
+		limit := maxEdits(term)
+		best, bestDistance, bestDocs := "", limit+1, 0
+		for _, t := range vocabulary {
+			d := editDistance(term, t.term)
+			if d > limit {
+				continue
+			}
+			if d < bestDistance || (d == bestDistance && t.docs > bestDocs) {
+				best, bestDistance, bestDocs = t.term, d, t.docs
+			}
+		}
+		if best != "" {
+			terms[i] = best
+			corrected = true
+		}
+	}
+	return strings.Join(terms, " "), corrected, nil
+}
@@ -0,0 +1,300 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// MemberExport is the archive of the data of a member, for the right of
+// access of the GDPR.
+type MemberExport struct {
+	ExportTime       time.Time         `json:"exportTime"`
+	Member           Member            `json:"member"`
+	Identities       []MemberIdentity  `json:"identities"`
+	TwoFactorEnabled bool              `json:"twoFactorEnabled"`
+	Sessions         []ExportedSession `json:"sessions"`
+	Reviews          []Review          `json:"reviews"`
+	ReadingLists     []ExportedList    `json:"readingLists"`
+	DigitalLoans     []DigitalLoan     `json:"digitalLoans"`
+	SecurityEvents   []SecurityEvent   `json:"securityEvents"`
+	Erasure          *MemberErasure    `json:"erasure,omitempty"`
+}
+
+// MemberIdentity is an OIDC subject which logs in as the member.
+type MemberIdentity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+// ExportedSession is a session of the member, without its token.
+type ExportedSession struct {
+	ExpireTime  time.Time `json:"expireTime"`
+	LastUseTime time.Time `json:"lastUseTime"`
+}
+
+// ExportedList is a reading list of the member with the ISBNs of all of its
+// books.
+type ExportedList struct {
+	ReadingList
+	ISBNs []string `json:"isbns"`
+}
+
+// MemberErasure is the audit record of the erasure of a member.
+type MemberErasure struct {
+	MemberID  string    `json:"memberId"`
+	ErasedBy  string    `json:"erasedBy"` // The id of the member who requested it
+	EraseTime time.Time `json:"eraseTime"`
+}
+
+// memberAccess returns the member of the session when it is the member with
+// the given id, or an admin.
+func (s *Server) memberAccess(r *http.Request, memberID string) (Member, error) {
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		return Member{}, &statusError{http.StatusUnauthorized, "The request is not logged in"}
+	} else if err != nil {
+		return Member{}, err
+	}
+	if m.ID != memberID && !hasRole(m, RoleAdmin) {
+		return Member{}, &statusError{http.StatusForbidden, "Only the member or an admin can access the data of the member"}
+	}
+	return m, nil
+}
+
+// queryStrings reads the single string column of a query.
+func queryStrings(q Querier, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// findErasure reads the audit record of the erasure of a member, nil if the
+// member has not been erased.
+func findErasure(q Querier, memberID string) (*MemberErasure, error) {
+	e := MemberErasure{MemberID: memberID}
+	var eraseTime int64
+	err := q.QueryRow("SELECT erasedBy, eraseTime FROM member_erasure WHERE memberId = ?", memberID).Scan(&e.ErasedBy, &eraseTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read erasure err, %w", err)
+	}
+	e.EraseTime = time.Unix(eraseTime, 0).UTC()
+	return &e, nil
+}
+
+// exportMember collects the data of a member.
+func (s *Server) exportMember(q Querier, memberID string, now time.Time) (MemberExport, error) {
+	export := MemberExport{ExportTime: now.UTC(), Identities: []MemberIdentity{}, Sessions: []ExportedSession{},
+		ReadingLists: []ExportedList{}, SecurityEvents: []SecurityEvent{}}
+	var err error
+	if export.Member, err = s.FindMember(q, memberID); err != nil {
+		return export, err
+	}
+
+	rows, err := q.Query("SELECT issuer, subject FROM member_identity WHERE memberId = ? ORDER BY issuer, subject", memberID)
+	if err != nil {
+		return export, fmt.Errorf("query identities err, %w", err)
+	}
+	for rows.Next() {
+		var i MemberIdentity
+		if err := rows.Scan(&i.Issuer, &i.Subject); err != nil {
+			rows.Close()
+			return export, fmt.Errorf("scan identity err, %w", err)
+		}
+		export.Identities = append(export.Identities, i)
+	}
+	rows.Close()
+
+	rows, err = q.Query("SELECT expireTime, lastUseTime FROM member_token WHERE memberId = ? AND kind = ? AND expireTime > ? ORDER BY expireTime",
+		memberID, TokenSession, now.Unix())
+	if err != nil {
+		return export, fmt.Errorf("query sessions err, %w", err)
+	}
+	for rows.Next() {
+		var expireTime, lastUseTime int64
+		if err := rows.Scan(&expireTime, &lastUseTime); err != nil {
+			rows.Close()
+			return export, fmt.Errorf("scan session err, %w", err)
+		}
+		export.Sessions = append(export.Sessions, ExportedSession{
+			ExpireTime: time.Unix(expireTime, 0).UTC(), LastUseTime: time.Unix(lastUseTime, 0).UTC()})
+	}
+	rows.Close()
+
+	_, confirmed, _, err := s.readTOTP(q, memberID)
+	if err != nil && !errors.Is(err, errNoTOTP) {
+		return export, err
+	}
+	export.TwoFactorEnabled = confirmed
+
+	if export.Reviews, err = readReviews(q, "memberId = ?", []interface{}{memberID}, 0, -1); err != nil {
+		return export, err
+	}
+	lists, err := ReadReadingLists(q, memberID)
+	if err != nil {
+		return export, err
+	}
+	for _, l := range lists {
+		isbns, err := queryStrings(q, "SELECT isbn FROM reading_list_item WHERE listId = ? ORDER BY rowid", l.ID)
+		if err != nil {
+			return export, fmt.Errorf("query reading list items err, %w", err)
+		}
+		export.ReadingLists = append(export.ReadingLists, ExportedList{ReadingList: l, ISBNs: isbns})
+	}
+	if export.DigitalLoans, err = readDigitalLoans(q, "memberId = ?", memberID); err != nil {
+		return export, err
+	}
+
+	if export.Member.Email != "" {
+		rows, err = q.Query("SELECT id, kind, email, ip, createTime FROM security_event WHERE email = ? COLLATE NOCASE ORDER BY id",
+			export.Member.Email)
+		if err != nil {
+			return export, fmt.Errorf("query security events err, %w", err)
+		}
+		for rows.Next() {
+			var e SecurityEvent
+			var createTime int64
+			if err := rows.Scan(&e.ID, &e.Kind, &e.Email, &e.IP, &createTime); err != nil {
+				rows.Close()
+				return export, fmt.Errorf("scan security event err, %w", err)
+			}
+			e.CreateTime = time.Unix(createTime, 0).UTC()
+			export.SecurityEvents = append(export.SecurityEvents, e)
+		}
+		rows.Close()
+	}
+	export.Erasure, err = findErasure(q, memberID)
+	return export, err
+}
+
+// ExportMember returns an archive of all the data of a member. Only the
+// member and the admins can export it.
+func (s *Server) ExportMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	memberID := mux.Vars(r)["memberId"]
+	if _, err := s.memberAccess(r, memberID); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	export, err := s.exportMember(s.db, memberID, time.Now())
+	if errors.Is(err, errNoMember) {
+		HandleErr(w, http.StatusNotFound, "The member does not exist")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to export the member")
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="member-`+memberID+`.json"`)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(export); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the export")
+		return
+	}
+}
+
+// erasedEmail is the email of an erased member, unique to the member since
+// member.email is unique.
+func erasedEmail(memberID string) string {
+	return "erased:" + memberID
+}
+
+// eraseMember removes the personal data of a member. The member and the
+// ratings of its reviews are kept, without the texts, so that the
+// statistics stay the same.
+func eraseMember(q Querier, m Member, erasedBy string, now time.Time) (MemberErasure, error) {
+	erasure := MemberErasure{MemberID: m.ID, ErasedBy: erasedBy, EraseTime: time.Unix(now.Unix(), 0).UTC()}
+	lists, err := ReadReadingLists(q, m.ID)
+	if err != nil {
+		return erasure, err
+	}
+	for _, l := range lists {
+		if err := DeleteReadingList(q, l.ID); err != nil {
+			return erasure, err
+		}
+	}
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		// The email and its hash must stay unique, so both are replaced by a
+		// tombstone which no longer matches the email
+		{"UPDATE member SET email = ?, emailHash = ?, phone = '', firstName = '', lastName = '', passwordHash = '', emailVerified = 0 WHERE id = ?",
+			[]interface{}{erasedEmail(m.ID), erasedEmail(m.ID), m.ID}},
+		{"UPDATE review SET text = '' WHERE memberId = ?", []interface{}{m.ID}},
+		{"DELETE FROM member_token WHERE memberId = ?", []interface{}{m.ID}},
+		{"DELETE FROM digital_loan WHERE memberId = ?", []interface{}{m.ID}},
+		{"DELETE FROM member_identity WHERE memberId = ?", []interface{}{m.ID}},
+		{"DELETE FROM member_role WHERE memberId = ?", []interface{}{m.ID}},
+		{"DELETE FROM member_totp WHERE memberId = ?", []interface{}{m.ID}},
+		{"DELETE FROM member_recovery_code WHERE memberId = ?", []interface{}{m.ID}},
+		{"UPDATE security_event SET email = '' WHERE email = ? COLLATE NOCASE", []interface{}{m.Email}},
+		{"DELETE FROM login_failure WHERE scope = ? AND key = ?", []interface{}{lockoutAccount, m.Email}},
+		{"DELETE FROM notification_delivery WHERE recipient = ? COLLATE NOCASE", []interface{}{m.Email}},
+		{"INSERT INTO member_erasure (memberId, erasedBy, eraseTime) VALUES(?,?,?)",
+			[]interface{}{m.ID, erasedBy, erasure.EraseTime.Unix()}},
+	}
+	for _, st := range statements {
+		if _, err := q.Exec(st.query, st.args...); err != nil {
+			return erasure, fmt.Errorf("erase member err, %w", err)
+		}
+	}
+	return erasure, nil
+}
+
+// EraseMember anonymizes a member for the right to erasure of the GDPR, and
+// keeps an audit record of it. Only the member and the admins can erase it.
+func (s *Server) EraseMember(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	memberID := mux.Vars(r)["memberId"]
+	requester, err := s.memberAccess(r, memberID)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var erasure MemberErasure
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		m, err := s.FindMember(tx, memberID)
+		if errors.Is(err, errNoMember) {
+			return &statusError{http.StatusNotFound, "The member does not exist"}
+		} else if err != nil {
+			return err
+		}
+		if previous, err := findErasure(tx, memberID); err != nil {
+			return err
+		} else if previous != nil {
+			return &statusError{http.StatusConflict, "The member has already been erased"}
+		}
+		erasure, err = eraseMember(tx, m, requester.ID, time.Now())
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to erase the member")
+		return
+	}
+	if requester.ID == memberID {
+		clearSessionCookie(w)
+	}
+	if err := json.NewEncoder(w).Encode(erasure); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the erasure")
+		return
+	}
+}
@@ -0,0 +1,123 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemberExportAndErasure(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	server := NewServer(db)
+
+	now := time.Now().UTC()
+	astrid := Member{ID: "astrid", Email: "astrid@example.com", FirstName: "astrid", LastName: "lindgren",
+		EmailVerified: true, CreateTime: now}
+	emil := Member{ID: "emil", Email: "emil@example.com", FirstName: "emil", LastName: "svensson",
+		EmailVerified: true, CreateTime: now}
+	admin := Member{ID: "admin", Email: "admin@example.com", FirstName: "ada", LastName: "admin",
+		EmailVerified: true, CreateTime: now}
+	for _, m := range []Member{astrid, emil, admin} {
+		require.NoError(t, server.InsertMember(db, m, ""))
+	}
+	require.NoError(t, setRoles(db, admin.ID, []string{RoleAdmin}))
+	admin.Roles = []string{RoleAdmin}
+
+	require.NoError(t, InsertReview(db, Review{ID: "r1", ISBN: "9780140328721", MemberID: astrid.ID, Rating: 5,
+		Text: "Wonderful", CreateTime: now}))
+	require.NoError(t, InsertReadingList(db, ReadingList{ID: "l1", MemberID: astrid.ID, Name: "Summer",
+		Kind: ListCustom, Visibility: VisibilityPrivate, CreateTime: now}))
+	require.NoError(t, AddListItem(db, "l1", "9780140328721", now))
+
+	session := func(m Member) string {
+		t.Helper()
+		s, err := server.startSession(db, m)
+		require.NoError(t, err)
+		return s.Token
+	}
+	serve := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	astridToken, emilToken, adminToken := session(astrid), session(emil), session(admin)
+
+	t.Run("Exports the data of the member", func(t *testing.T) {
+		response := serve(http.MethodGet, "/api/v1/members/astrid:export", astridToken)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Contains(t, response.Header().Get("Content-Disposition"), "member-astrid.json")
+		var export MemberExport
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&export))
+		require.Equal(t, astrid.Email, export.Member.Email)
+		require.Len(t, export.Sessions, 1)
+		require.Len(t, export.Reviews, 1)
+		require.Equal(t, "Wonderful", export.Reviews[0].Text)
+		require.Len(t, export.ReadingLists, 1)
+		require.Equal(t, []string{"9780140328721"}, export.ReadingLists[0].ISBNs)
+		require.Nil(t, export.Erasure)
+	})
+
+	t.Run("Only the member or an admin can export", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/members/astrid:export", "").Code)
+		require.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/v1/members/astrid:export", emilToken).Code)
+		require.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/members/astrid:export", adminToken).Code)
+		require.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/api/v1/members/astrid:erase", emilToken).Code)
+	})
+
+	t.Run("Erases the personal data", func(t *testing.T) {
+		response := serve(http.MethodPost, "/api/v1/members/astrid:erase", adminToken)
+		require.Equal(t, http.StatusOK, response.Code)
+		var erasure MemberErasure
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&erasure))
+		require.Equal(t, MemberErasure{MemberID: "astrid", ErasedBy: "admin", EraseTime: erasure.EraseTime}, erasure)
+
+		var email, firstName, lastName string
+		require.NoError(t, db.QueryRow("SELECT email, firstName, lastName FROM member WHERE id = 'astrid'").
+			Scan(&email, &firstName, &lastName))
+		require.Equal(t, []string{"erased:astrid", "", ""}, []string{email, firstName, lastName})
+
+		reviews, err := readReviews(db, "memberId = ?", []interface{}{"astrid"}, 0, -1)
+		require.NoError(t, err)
+		require.Len(t, reviews, 1)
+		require.Equal(t, 5, reviews[0].Rating, "the ratings are kept for the statistics")
+		require.Empty(t, reviews[0].Text)
+
+		lists, err := ReadReadingLists(db, "astrid")
+		require.NoError(t, err)
+		require.Empty(t, lists)
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/members/astrid:export", astridToken).Code,
+			"the sessions of the member are ended")
+
+		response = serve(http.MethodGet, "/api/v1/members/astrid:export", adminToken)
+		require.Equal(t, http.StatusOK, response.Code)
+		var export MemberExport
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&export))
+		require.NotNil(t, export.Erasure)
+		require.Equal(t, "admin", export.Erasure.ErasedBy)
+	})
+
+	t.Run("Erases more than one member", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/members/emil:erase", adminToken).Code)
+		for _, id := range []string{"astrid", "emil"} {
+			_, err := server.FindMember(db, id)
+			require.NoError(t, err)
+			erasure, err := findErasure(db, id)
+			require.NoError(t, err)
+			require.NotNil(t, erasure)
+		}
+	})
+
+	t.Run("Erases a member once", func(t *testing.T) {
+		require.Equal(t, http.StatusConflict, serve(http.MethodPost, "/api/v1/members/astrid:erase", adminToken).Code)
+		require.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/api/v1/members/nobody:erase", adminToken).Code)
+	})
+}
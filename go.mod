@@ -6,6 +6,8 @@ require github.com/gorilla/mux v1.8.0
 
 require modernc.org/sqlite v1.13.1
 
+require golang.org/x/text v0.3.7
+
 require (
 	github.com/golang-migrate/migrate/v4 v4.15.0
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
@@ -34,7 +36,7 @@ require (
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/zap v1.19.1
 	golang.org/x/tools v0.1.5 // indirect
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
 
 require go.uber.org/multierr v1.6.0 // indirect
@@ -0,0 +1,151 @@
+// Package ids generates the internal identifiers of entities. Every strategy
+// generates identifiers which sort in the order they were generated, give or
+// take clock skew between servers.
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Generator generates unique identifiers.
+type Generator interface {
+	NewID() string
+}
+
+// ByName returns the generator of the strategy with the given name, one of
+// uuidv7, ulid or snowflake. The node id is only used by snowflake.
+func ByName(name string, node int64) (Generator, error) {
+	switch name {
+	case "uuidv7":
+		return UUIDv7{}, nil
+	case "ulid":
+		return ULID{}, nil
+	case "snowflake":
+		return NewSnowflake(node)
+	}
+	return nil, fmt.Errorf("unknown id strategy %q", name)
+}
+
+// now is replaced by the tests.
+var now = time.Now
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand only fails if the OS has no source of randomness
+		panic(fmt.Sprintf("ids: read random bytes err, %v", err))
+	}
+}
+
+// UUIDv7 generates version 7 UUIDs as defined by RFC 9562, which start with
+// the millisecond timestamp, e.g. 01890a5d-ac96-774b-bcce-b302099a8057.
+type UUIDv7 struct{}
+
+// NewID implements Generator.
+func (UUIDv7) NewID() string {
+	var u [16]byte
+	randomBytes(u[6:])
+	ms := uint64(now().UnixMilli())
+	u[0], u[1], u[2] = byte(ms>>40), byte(ms>>32), byte(ms>>24)
+	u[3], u[4], u[5] = byte(ms>>16), byte(ms>>8), byte(ms)
+	u[6] = u[6]&0x0f | 0x70 // Version 7
+	u[8] = u[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// crockford is the Crockford base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates ULIDs, 26 characters of Crockford base32 which encode the
+// millisecond timestamp followed by 80 random bits.
+type ULID struct{}
+
+// NewID implements Generator.
+func (ULID) NewID() string {
+	var u [16]byte
+	randomBytes(u[6:])
+	ms := uint64(now().UnixMilli())
+	u[0], u[1], u[2] = byte(ms>>40), byte(ms>>32), byte(ms>>24)
+	u[3], u[4], u[5] = byte(ms>>16), byte(ms>>8), byte(ms)
+
+	// 128 bits are encoded as 26 characters of 5 bits, the first character
+	// only holds the 3 most significant bits
+	hi, lo := binary.BigEndian.Uint64(u[:8]), binary.BigEndian.Uint64(u[8:])
+	var buf [26]byte
+	for i := 25; i >= 0; i-- {
+		buf[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf[:])
+}
+
+// snowflakeEpoch is the start of the snowflake timestamps, 2021-01-01 UTC.
+const snowflakeEpoch = 1609459200000
+
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+	// MaxSnowflakeNode is the largest node id of a snowflake generator.
+	MaxSnowflakeNode = 1<<snowflakeNodeBits - 1
+)
+
+// Snowflake generates 63 bit integer ids made of a millisecond timestamp, the
+// id of the node and a sequence number. Every server which generates ids must
+// have a node id of its own.
+type Snowflake struct {
+	mu       sync.Mutex
+	node     int64
+	lastMS   int64
+	sequence int64
+}
+
+// NewSnowflake creates a snowflake generator for the given node.
+func NewSnowflake(node int64) (*Snowflake, error) {
+	if node < 0 || node > MaxSnowflakeNode {
+		return nil, fmt.Errorf("snowflake node must be between 0 and %d", MaxSnowflakeNode)
+	}
+	return &Snowflake{node: node}, nil
+}
+
+// NewID implements Generator. When more than 4096 ids are generated in a
+// millisecond it waits for the next millisecond.
+func (s *Snowflake) NewID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ms := now().UnixMilli() - snowflakeEpoch
+	if ms < s.lastMS {
+		// The clock went backwards, keep counting from the last timestamp
+		ms = s.lastMS
+	}
+	if ms == s.lastMS {
+		s.sequence = (s.sequence + 1) & (1<<snowflakeSequenceBits - 1)
+		if s.sequence == 0 {
+			for ms <= s.lastMS {
+				time.Sleep(100 * time.Microsecond)
+				ms = now().UnixMilli() - snowflakeEpoch
+			}
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastMS = ms
+	id := ms<<(snowflakeNodeBits+snowflakeSequenceBits) | s.node<<snowflakeSequenceBits | s.sequence
+	return strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,56 @@
+package ids
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerators(t *testing.T) {
+	defer func() { now = time.Now }()
+
+	for name, pattern := range map[string]*regexp.Regexp{
+		"uuidv7":    regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		"ulid":      regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+		"snowflake": regexp.MustCompile(`^[0-9]+$`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			g, err := ByName(name, 1)
+			require.NoError(t, err)
+
+			// Ids sort in the order they were generated
+			start := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+			var generated []string
+			for i := 0; i < 100; i++ {
+				now = func() time.Time { return start.Add(time.Duration(i) * time.Millisecond) }
+				id := g.NewID()
+				require.Regexp(t, pattern, id)
+				generated = append(generated, id)
+			}
+			require.True(t, sort.StringsAreSorted(generated))
+		})
+	}
+
+	_, err := ByName("autoincrement", 0)
+	require.Error(t, err)
+}
+
+func TestSnowflake(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC) }
+
+	s, err := NewSnowflake(MaxSnowflakeNode)
+	require.NoError(t, err)
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := s.NewID()
+		require.False(t, seen[id])
+		seen[id] = true
+	}
+
+	_, err = NewSnowflake(MaxSnowflakeNode + 1)
+	require.Error(t, err)
+}
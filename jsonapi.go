@@ -0,0 +1,266 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// jsonAPIContentType is the media type of JSON:API documents, see
+// https://jsonapi.org. Books are represented as JSON:API resources when it
+// is requested in the Accept header.
+const jsonAPIContentType = "application/vnd.api+json"
+
+// The relationships of a book which can be included in a JSON:API document
+// with the include query parameter.
+var jsonAPIIncludes = map[string]bool{"work": true, "series": true}
+
+// The number of books on a page of a JSON:API document unless page[size] is
+// given, and the largest page size which may be requested.
+const (
+	defaultJSONAPIPageSize = 20
+	maxJSONAPIPageSize     = 100
+)
+
+// jsonAPIDocument is the top level of a JSON:API document. Data is either a
+// single resource or a list of resources.
+type jsonAPIDocument struct {
+	Data     interface{}            `json:"data"`
+	Included []jsonAPIResource      `json:"included,omitempty"`
+	Links    map[string]string      `json:"links,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+}
+
+// jsonAPIResource is a resource object of a JSON:API document.
+type jsonAPIResource struct {
+	Type          string                         `json:"type"`
+	ID            string                         `json:"id"`
+	Attributes    map[string]interface{}         `json:"attributes,omitempty"`
+	Relationships map[string]jsonAPIRelationship `json:"relationships,omitempty"`
+	Links         map[string]string              `json:"links,omitempty"`
+}
+
+// jsonAPIRelationship is a to-one relationship, Data is nil if the resource
+// is not related to anything.
+type jsonAPIRelationship struct {
+	Data *jsonAPIIdentifier `json:"data"`
+}
+
+type jsonAPIIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// jsonAPIAttributes converts v to its JSON fields, leaving out the given
+// fields which are represented as the id or as relationships.
+func jsonAPIAttributes(v interface{}, omit ...string) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(b, &attributes); err != nil {
+		return nil, err
+	}
+	// id and type are reserved by JSON:API
+	for _, name := range append(omit, "id", "type") {
+		delete(attributes, name)
+	}
+	return attributes, nil
+}
+
+// toOne returns the relationship to the resource of the given type and id.
+func toOne(typ, id string) jsonAPIRelationship {
+	if id == "" {
+		return jsonAPIRelationship{}
+	}
+	return jsonAPIRelationship{Data: &jsonAPIIdentifier{Type: typ, ID: id}}
+}
+
+// bookResource represents a book as a JSON:API resource identified by its
+// ISBN, self is the URL of the book.
+func bookResource(b Book, self string) (jsonAPIResource, error) {
+	attributes, err := jsonAPIAttributes(b, "isbn", "workId", "seriesId")
+	if err != nil {
+		return jsonAPIResource{}, err
+	}
+	return jsonAPIResource{
+		Type:       "books",
+		ID:         b.ISBN,
+		Attributes: attributes,
+		Relationships: map[string]jsonAPIRelationship{
+			"work":   toOne("works", b.WorkID),
+			"series": toOne("series", b.SeriesID),
+		},
+		Links: map[string]string{"self": self},
+	}, nil
+}
+
+// parseInclude reads the include query parameter, e.g. ?include=work,series.
+func parseInclude(r *http.Request) (map[string]bool, error) {
+	include := make(map[string]bool)
+	param := r.URL.Query().Get("include")
+	if param == "" {
+		return include, nil
+	}
+	for _, name := range strings.Split(param, ",") {
+		if !jsonAPIIncludes[name] {
+			return nil, fmt.Errorf("include must be a list of work and series, %q can not be included", name)
+		}
+		include[name] = true
+	}
+	return include, nil
+}
+
+// includedResources reads the works and series of the books which are
+// included in the document, each resource is included once.
+func includedResources(db Querier, books []Book, include map[string]bool) ([]jsonAPIResource, error) {
+	included := []jsonAPIResource{}
+	seen := make(map[jsonAPIIdentifier]bool)
+	for _, b := range books {
+		if include["work"] && b.WorkID != "" && !seen[jsonAPIIdentifier{"works", b.WorkID}] {
+			seen[jsonAPIIdentifier{"works", b.WorkID}] = true
+			work, err := FindWork(db, b.WorkID)
+			if err != nil {
+				return nil, fmt.Errorf("find work err, %w", err)
+			}
+			attributes, err := jsonAPIAttributes(work)
+			if err != nil {
+				return nil, err
+			}
+			included = append(included, jsonAPIResource{Type: "works", ID: work.ID, Attributes: attributes})
+		}
+		if include["series"] && b.SeriesID != "" && !seen[jsonAPIIdentifier{"series", b.SeriesID}] {
+			seen[jsonAPIIdentifier{"series", b.SeriesID}] = true
+			series, err := FindSeries(db, b.SeriesID)
+			if err != nil {
+				return nil, fmt.Errorf("find series err, %w", err)
+			}
+			attributes, err := jsonAPIAttributes(series)
+			if err != nil {
+				return nil, err
+			}
+			included = append(included, jsonAPIResource{Type: "series", ID: series.ID, Attributes: attributes})
+		}
+	}
+	return included, nil
+}
+
+// parseJSONAPIPage reads the page[number] and page[size] query parameters,
+// pages are numbered from 1.
+func parseJSONAPIPage(r *http.Request) (number, size int, err error) {
+	number, size = 1, defaultJSONAPIPageSize
+	if v := r.URL.Query().Get("page[number]"); v != "" {
+		if number, err = strconv.Atoi(v); err != nil || number < 1 {
+			return 0, 0, fmt.Errorf("page[number] must be a positive number")
+		}
+	}
+	if v := r.URL.Query().Get("page[size]"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 1 || size > maxJSONAPIPageSize {
+			return 0, 0, fmt.Errorf("page[size] must be a number between 1 and %d", maxJSONAPIPageSize)
+		}
+	}
+	return number, size, nil
+}
+
+// pageLink returns the URL of the request with the given page number.
+func pageLink(r *http.Request, number int) string {
+	query := r.URL.Query()
+	query.Set("page[number]", strconv.Itoa(number))
+	return (&url.URL{Path: r.URL.Path, RawQuery: query.Encode()}).String()
+}
+
+// writeJSONAPI writes the document with the JSON:API content type.
+func writeJSONAPI(w http.ResponseWriter, doc jsonAPIDocument) error {
+	w.Header().Set("Content-Type", jsonAPIContentType)
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// writeBooksJSONAPI writes a page of the books as a JSON:API document with
+// links to the other pages.
+func (s *Server) writeBooksJSONAPI(w http.ResponseWriter, r *http.Request, books []Book) {
+	include, err := parseInclude(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	number, size, err := parseJSONAPIPage(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	last := (len(books) + size - 1) / size
+	if last == 0 {
+		last = 1
+	}
+	start, end := (number-1)*size, number*size
+	if start > len(books) {
+		start = len(books)
+	}
+	if end > len(books) {
+		end = len(books)
+	}
+	page := books[start:end]
+
+	data := make([]jsonAPIResource, len(page))
+	for i, b := range page {
+		if data[i], err = bookResource(b, r.URL.Path+"/"+b.ISBN); err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to encode the books")
+			return
+		}
+	}
+	doc := jsonAPIDocument{
+		Data: data,
+		Links: map[string]string{
+			"self":  pageLink(r, number),
+			"first": pageLink(r, 1),
+			"last":  pageLink(r, last),
+		},
+		Meta: map[string]interface{}{"total": len(books)},
+	}
+	if number > 1 {
+		doc.Links["prev"] = pageLink(r, number-1)
+	}
+	if number < last {
+		doc.Links["next"] = pageLink(r, number+1)
+	}
+	if len(include) != 0 {
+		if doc.Included, err = includedResources(s.db, page, include); err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the included resources")
+			return
+		}
+	}
+	if err := writeJSONAPI(w, doc); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the books")
+		return
+	}
+}
+
+// writeBookJSONAPI writes a single book as a JSON:API document.
+func (s *Server) writeBookJSONAPI(w http.ResponseWriter, r *http.Request, book Book) {
+	include, err := parseInclude(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resource, err := bookResource(book, r.URL.Path)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to encode the book")
+		return
+	}
+	doc := jsonAPIDocument{Data: resource, Links: map[string]string{"self": r.URL.Path}}
+	if len(include) != 0 {
+		if doc.Included, err = includedResources(s.db, []Book{book}, include); err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the included resources")
+			return
+		}
+	}
+	if err := writeJSONAPI(w, doc); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the book instance")
+		return
+	}
+}
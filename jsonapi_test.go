@@ -0,0 +1,90 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONAPI(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	jsonBytes, _ := json.Marshal(Series{Name: "Star Wars"})
+	response := createNewRequest(http.MethodPost, "/api/v1/series", jsonBytes, db)
+	require.Equal(t, http.StatusCreated, response.Code)
+	var series Series
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&series))
+	for isbn, volume := range map[string]int{"1233211233215": 1, "1233211233213": 2, "1233211233210": 3} {
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: starWarsTitles[isbn],
+			Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "lucasfilm",
+			SeriesID: series.ID, SeriesVolume: volume})
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Accept", jsonAPIContentType)
+		response := httptest.NewRecorder()
+		NewServer(db).ServeHTTP(response, request)
+		return response
+	}
+	type document struct {
+		Data     []jsonAPIResource `json:"data"`
+		Included []jsonAPIResource `json:"included"`
+		Links    map[string]string `json:"links"`
+	}
+
+	t.Run("Lists a page of the books", func(t *testing.T) {
+		response := get("/api/v1/books?series=" + series.ID + "&page[size]=2&include=series")
+		require.Equal(t, http.StatusOK, response.Code)
+		assertContentType(t, response, jsonAPIContentType, "Should have the JSON:API content type")
+		var doc document
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&doc))
+		require.Len(t, doc.Data, 2)
+		require.Equal(t, "books", doc.Data[0].Type)
+		require.Equal(t, "1233211233215", doc.Data[0].ID)
+		require.Equal(t, starWarsTitles["1233211233215"], doc.Data[0].Attributes["title"])
+		require.NotContains(t, doc.Data[0].Attributes, "isbn")
+		require.Equal(t, &jsonAPIIdentifier{Type: "series", ID: series.ID}, doc.Data[0].Relationships["series"].Data)
+		require.Nil(t, doc.Data[0].Relationships["work"].Data)
+		require.Equal(t, "/api/v1/books/1233211233215", doc.Data[0].Links["self"])
+
+		require.Len(t, doc.Included, 1)
+		require.Equal(t, "Star Wars", doc.Included[0].Attributes["name"])
+		require.Contains(t, doc.Links["next"], "page%5Bnumber%5D=2")
+		require.NotContains(t, doc.Links, "prev")
+
+		response = get(doc.Links["next"])
+		require.Equal(t, http.StatusOK, response.Code)
+		doc = document{}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&doc))
+		require.Len(t, doc.Data, 1)
+		require.NotContains(t, doc.Links, "next")
+	})
+
+	t.Run("Retrieves a single book", func(t *testing.T) {
+		response := get("/api/v1/books/1233211233213")
+		require.Equal(t, http.StatusOK, response.Code)
+		var doc struct {
+			Data jsonAPIResource `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&doc))
+		require.Equal(t, "1233211233213", doc.Data.ID)
+		require.EqualValues(t, 2, doc.Data.Attributes["seriesVolume"])
+	})
+
+	t.Run("Rejects invalid parameters", func(t *testing.T) {
+		for _, path := range []string{
+			"/api/v1/books?page[size]=1000",
+			"/api/v1/books?page[number]=0",
+			"/api/v1/books?include=author",
+			"/api/v1/books?fields=title",
+		} {
+			require.Equal(t, http.StatusBadRequest, get(path).Code, path)
+		}
+	})
+}
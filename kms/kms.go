@@ -0,0 +1,159 @@
+// Package kms wraps the data keys of envelope encryption with a key
+// encryption key. The data keys encrypt the data and are stored wrapped
+// next to it, while the key encryption key stays in a key file or in a key
+// management service.
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// KeyEncrypter wraps and unwraps data keys with a key encryption key.
+type KeyEncrypter interface {
+	WrapKey(ctx context.Context, key []byte) (string, error)
+	UnwrapKey(ctx context.Context, wrapped string) ([]byte, error)
+}
+
+// LocalKey is a key encryption key which is kept by the application, e.g.
+// in a key file. The data keys are wrapped with AES-256-GCM.
+type LocalKey struct {
+	aead cipher.AEAD
+}
+
+// NewLocalKey creates a key encryption key of 32 bytes.
+func NewLocalKey(key []byte) (*LocalKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("create local key err, the key is %d bytes, not 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create local key err, %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create local key err, %w", err)
+	}
+	return &LocalKey{aead: aead}, nil
+}
+
+// ReadKeyFile reads a key encryption key of 32 bytes from a file, in
+// base64. It can be created with e.g. openssl rand -base64 32.
+func ReadKeyFile(path string) (*LocalKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file err, %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("decode key file err, %w", err)
+	}
+	return NewLocalKey(key)
+}
+
+// WrapKey encrypts a data key.
+func (k *LocalKey) WrapKey(ctx context.Context, key []byte) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("create nonce err, %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(k.aead.Seal(nonce, nonce, key, nil)), nil
+}
+
+// UnwrapKey decrypts a data key of WrapKey.
+func (k *LocalKey) UnwrapKey(ctx context.Context, wrapped string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(b) < k.aead.NonceSize() {
+		return nil, errors.New("unwrap key err, malformed key")
+	}
+	key, err := k.aead.Open(nil, b[:k.aead.NonceSize()], b[k.aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key err, %w", err)
+	}
+	return key, nil
+}
+
+// VaultTransit is a key encryption key in the transit secrets engine of
+// HashiCorp Vault, which never leaves Vault.
+type VaultTransit struct {
+	URL        string // e.g. https://vault:8200
+	Mount      string // Defaults to transit
+	Key        string // The name of the key
+	Token      string
+	HTTPClient *http.Client // Defaults to http.DefaultClient
+}
+
+// do posts a request to an endpoint of the key and decodes the data of the
+// response into v.
+func (v *VaultTransit) do(ctx context.Context, action string, body, data interface{}) error {
+	mount := v.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(v.URL, "/")+"/v1/"+mount+"/"+action+"/"+v.Key, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("create vault request err, %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", v.Token)
+	httpClient := v.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault request err, %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("vault request err, unexpected status %s: %s", resp.Status, msg)
+	}
+	res := struct {
+		Data interface{} `json:"data"`
+	}{data}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("decode vault response err, %w", err)
+	}
+	return nil
+}
+
+// WrapKey encrypts a data key in Vault.
+func (v *VaultTransit) WrapKey(ctx context.Context, key []byte) (string, error) {
+	var data struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := v.do(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)}, &data)
+	return data.Ciphertext, err
+}
+
+// UnwrapKey decrypts a data key in Vault.
+func (v *VaultTransit) UnwrapKey(ctx context.Context, wrapped string) ([]byte, error) {
+	var data struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := v.do(ctx, "decrypt", map[string]string{"ciphertext": wrapped}, &data); err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(data.Plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("decode vault key err, %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,67 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.key")
+	require.NoError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))+"\n"), 0600))
+	kek, err := ReadKeyFile(path)
+	require.NoError(t, err)
+
+	wrapped, err := kek.WrapKey(context.Background(), []byte("data key"))
+	require.NoError(t, err)
+	key, err := kek.UnwrapKey(context.Background(), wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data key"), key)
+
+	other, err := NewLocalKey(bytes.Repeat([]byte{8}, 32))
+	require.NoError(t, err)
+	_, err = other.UnwrapKey(context.Background(), wrapped)
+	require.Error(t, err)
+
+	_, err = NewLocalKey([]byte("short"))
+	require.Error(t, err)
+}
+
+func TestVaultTransit(t *testing.T) {
+	// A fake transit engine which "encrypts" by prefixing the plaintext
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "s.token", r.Header.Get("X-Vault-Token"))
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/library":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]}})
+		case "/v1/transit/decrypt/library":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": strings.TrimPrefix(body["ciphertext"], "vault:v1:")}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	kek := &VaultTransit{URL: server.URL, Key: "library", Token: "s.token"}
+	wrapped, err := kek.WrapKey(context.Background(), []byte("data key"))
+	require.NoError(t, err)
+	require.Equal(t, "vault:v1:"+base64.StdEncoding.EncodeToString([]byte("data key")), wrapped)
+	key, err := kek.UnwrapKey(context.Background(), wrapped)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data key"), key)
+
+	kek.Key = "unknown"
+	_, err = kek.WrapKey(context.Background(), []byte("data key"))
+	require.Error(t, err)
+}
@@ -0,0 +1,219 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// The maximum number of labels in one request.
+const maxLabels = 1000
+
+// LabelLayout is the layout of the sheets of spine labels. The sizes are in
+// points, 1/72 inch. The labels fill the page between the margins, which
+// are the same on both sides, with gaps between the columns and rows.
+type LabelLayout struct {
+	PageWidth  float64 `json:"pageWidth"`
+	PageHeight float64 `json:"pageHeight"`
+	Columns    int     `json:"columns"`
+	Rows       int     `json:"rows"`
+	MarginTop  float64 `json:"marginTop"`
+	MarginLeft float64 `json:"marginLeft"`
+	ColumnGap  float64 `json:"columnGap"`
+	RowGap     float64 `json:"rowGap"`
+}
+
+// defaultLabelLayout is an A4 sheet of 3 by 8 labels of 70 by 37 mm.
+var defaultLabelLayout = LabelLayout{
+	PageWidth:  595.28,
+	PageHeight: 841.89,
+	Columns:    3,
+	Rows:       8,
+}
+
+// The smallest label which fits a call number and a barcode.
+const (
+	minLabelWidth  = 90
+	minLabelHeight = 45
+)
+
+// WithLabelLayout sets the default layout of the label sheets, e.g. to match
+// the label paper of the library. The default is an A4 sheet of 3 by 8
+// labels.
+func WithLabelLayout(l LabelLayout) ServerOption {
+	return func(s *Server) {
+		s.labelLayout = l
+	}
+}
+
+// labelSize returns the size of one label.
+func (l LabelLayout) labelSize() (width, height float64) {
+	width = (l.PageWidth - 2*l.MarginLeft - float64(l.Columns-1)*l.ColumnGap) / float64(l.Columns)
+	height = (l.PageHeight - 2*l.MarginTop - float64(l.Rows-1)*l.RowGap) / float64(l.Rows)
+	return width, height
+}
+
+func validateLabelLayout(l LabelLayout) error {
+	if l.Columns < 1 || l.Rows < 1 {
+		return errors.New("the layout must have at least one column and one row")
+	}
+	if l.MarginTop < 0 || l.MarginLeft < 0 || l.ColumnGap < 0 || l.RowGap < 0 {
+		return errors.New("the margins and gaps must not be negative")
+	}
+	if width, height := l.labelSize(); width < minLabelWidth || height < minLabelHeight {
+		return fmt.Errorf("the labels are %.0f by %.0f points, they must be at least %d by %d points",
+			width, height, minLabelWidth, minLabelHeight)
+	}
+	return nil
+}
+
+// The EAN-13 encodings of the digits. The right half of the barcode uses
+// the complements of the L codes.
+var (
+	ean13L = []string{"0001101", "0011001", "0010011", "0111101", "0100011",
+		"0110001", "0101111", "0111011", "0110111", "0001011"}
+	ean13G = []string{"0100111", "0110011", "0011011", "0100001", "0011101",
+		"0111001", "0000101", "0010001", "0001001", "0010111"}
+	ean13R = []string{"1110010", "1100110", "1101100", "1000010", "1011100",
+		"1001110", "1010000", "1000100", "1001000", "1110100"}
+	// The first digit is encoded by which digits of the left half use G codes
+	ean13Parity = []string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG",
+		"LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL"}
+)
+
+// ean13Modules returns the 95 modules of the EAN-13 barcode of an ISBN, "1"
+// for a bar and "0" for a space.
+func ean13Modules(isbn string) (string, error) {
+	if !isbnPattern.MatchString(isbn) {
+		return "", fmt.Errorf("invalid isbn %q", isbn)
+	}
+	digit := func(i int) int { return int(isbn[i] - '0') }
+	modules := "101"
+	for i, parity := range ean13Parity[digit(0)] {
+		if parity == 'L' {
+			modules += ean13L[digit(i+1)]
+		} else {
+			modules += ean13G[digit(i+1)]
+		}
+	}
+	modules += "01010"
+	for i := 7; i < 13; i++ {
+		modules += ean13R[digit(i)]
+	}
+	return modules + "101", nil
+}
+
+// drawLabel draws the spine label of a book with its bottom left corner at
+// x, y: the call number, the title and the barcode of the ISBN.
+func drawLabel(d *pdfDocument, b Book, x, y, width, height float64) error {
+	const padding = 6
+	inner := width - 2*padding
+	top := y + height - padding
+
+	callNumber := b.CallNumber
+	if callNumber == "" {
+		callNumber = b.Classification
+	}
+	d.text(pdfFontBold, 11, x+padding, top-11, fitText(11, inner, callNumber))
+	d.text(pdfFontRegular, 7, x+padding, top-21, fitText(7, inner, b.Title))
+
+	modules, err := ean13Modules(b.ISBN)
+	if err != nil {
+		return err
+	}
+	moduleWidth := inner / float64(len(modules))
+	if moduleWidth > 1.5 {
+		moduleWidth = 1.5
+	}
+	barHeight := height - 2*padding - 21 - 10
+	if barHeight > 40 {
+		barHeight = 40
+	}
+	barY := y + padding + 9
+	for i := 0; i < len(modules); {
+		j := i
+		for j < len(modules) && modules[j] == '1' {
+			j++
+		}
+		if j > i {
+			d.rect(x+padding+float64(i)*moduleWidth, barY, float64(j-i)*moduleWidth, barHeight)
+			i = j
+		} else {
+			i++
+		}
+	}
+	d.text(pdfFontRegular, 7, x+padding, y+padding, b.ISBN)
+	return nil
+}
+
+// renderLabels draws the labels of the books on as many sheets as needed.
+func renderLabels(books []Book, l LabelLayout) ([]byte, error) {
+	d := newPDFDocument(l.PageWidth, l.PageHeight)
+	width, height := l.labelSize()
+	perPage := l.Columns * l.Rows
+	for i, b := range books {
+		if i%perPage == 0 {
+			d.addPage()
+		}
+		column, row := (i%perPage)%l.Columns, (i%perPage)/l.Columns
+		x := l.MarginLeft + float64(column)*(width+l.ColumnGap)
+		y := l.PageHeight - l.MarginTop - float64(row+1)*height - float64(row)*l.RowGap
+		if err := drawLabel(d, b, x, y, width, height); err != nil {
+			return nil, err
+		}
+	}
+	return d.bytes(), nil
+}
+
+// LabelRequest lists the books to print spine labels for, one label per
+// ISBN. The layout of the server is used unless the request has its own.
+type LabelRequest struct {
+	ISBNs  []string     `json:"isbns"`
+	Layout *LabelLayout `json:"layout,omitempty"`
+}
+
+// PrintLabels renders the spine labels of a batch of books as a PDF, so that
+// new acquisitions can be labelled together.
+func (s *Server) PrintLabels(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req LabelRequest
+	if err := decodeJSON(r, &req); err != nil {
+		handleDecodeErr(w, err, "Failed to decode the label request")
+		return
+	}
+	if len(req.ISBNs) == 0 {
+		HandleErr(w, http.StatusBadRequest, "isbns must not be empty")
+		return
+	}
+	if len(req.ISBNs) > maxLabels {
+		HandleErr(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("At most %d labels can be printed at once", maxLabels))
+		return
+	}
+	layout := s.labelLayout
+	if req.Layout != nil {
+		layout = *req.Layout
+	}
+	if err := validateLabelLayout(layout); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Invalid label layout, "+err.Error())
+		return
+	}
+
+	books := make([]Book, len(req.ISBNs))
+	for i, isbn := range req.ISBNs {
+		books[i] = FindSpecificBook(s.db, isbn)
+		if books[i].ISBN == "" {
+			HandleErr(w, http.StatusNotFound, fmt.Sprintf("The book %s did not exist in the library", isbn))
+			return
+		}
+	}
+	pdf, err := renderLabels(books, layout)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to render the labels")
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="labels.pdf"`)
+	if _, err := w.Write(pdf); err != nil {
+		handleErr("failed to write the labels", err)
+	}
+}
@@ -0,0 +1,80 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEAN13(t *testing.T) {
+	modules, err := ean13Modules("9780140328721")
+	require.NoError(t, err)
+	require.Len(t, modules, 95)
+	require.Equal(t, "101", modules[:3])
+	require.Equal(t, "0111011", modules[3:10], "7 is an L code after the first digit 9")
+	require.Equal(t, "0001001", modules[10:17], "8 is a G code after the first digit 9")
+	require.Equal(t, "01010", modules[45:50])
+	require.Equal(t, "1000010", modules[50:57], "3 is an R code in the right half")
+	require.Equal(t, "101", modules[92:])
+
+	_, err = ean13Modules("978014032872")
+	require.Error(t, err)
+}
+
+func TestPrintLabels(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	lindgren := &Author{FirstName: "Astrid", LastName: "Lindgren"}
+	for _, b := range []Book{
+		{ISBN: "9789129688313", Title: "Pippi Långstrump", Classification: "Hc", Author: lindgren, Publisher: "raben"},
+		{ISBN: "9789129657470", Title: "Bröderna Lejonhjärta", Classification: "Hc", Author: lindgren, Publisher: "raben"},
+	} {
+		jsonBytes, _ := json.Marshal(b)
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+b.ISBN, jsonBytes, db).Code)
+	}
+
+	printLabels := func(req LabelRequest) (int, string) {
+		jsonBytes, _ := json.Marshal(req)
+		response := createNewRequest(http.MethodPost, "/api/v1/books:labels", jsonBytes, db)
+		return response.Code, response.Body.String()
+	}
+
+	t.Run("Renders a page of labels", func(t *testing.T) {
+		code, body := printLabels(LabelRequest{ISBNs: []string{"9789129688313", "9789129657470"}})
+		require.Equal(t, http.StatusOK, code, body)
+		require.True(t, strings.HasPrefix(body, "%PDF-1.4"))
+		require.True(t, strings.HasSuffix(body, "%%EOF\n"))
+		require.Contains(t, body, "/Count 1")
+		require.Contains(t, body, "(Hc L56)")
+		require.Contains(t, body, "(9789129688313)")
+		require.Contains(t, body, "(Pippi L\xe5ngstrump)", "the titles are in the WinAnsi encoding")
+	})
+
+	t.Run("Fills as many pages as needed", func(t *testing.T) {
+		isbns := make([]string, 7)
+		for i := range isbns {
+			isbns[i] = "9789129688313"
+		}
+		layout := LabelLayout{PageWidth: 300, PageHeight: 200, Columns: 2, Rows: 2, MarginTop: 10, MarginLeft: 10, RowGap: 5}
+		code, body := printLabels(LabelRequest{ISBNs: isbns, Layout: &layout})
+		require.Equal(t, http.StatusOK, code, body)
+		require.Contains(t, body, "/Count 2")
+		require.Contains(t, body, "/MediaBox [0 0 300.00 200.00]")
+		require.Equal(t, 7, bytes.Count([]byte(body), []byte("(9789129688313)")))
+	})
+
+	t.Run("Rejects invalid requests", func(t *testing.T) {
+		code, _ := printLabels(LabelRequest{ISBNs: []string{"9789129688313", "9780000000000"}})
+		require.Equal(t, http.StatusNotFound, code)
+		code, _ = printLabels(LabelRequest{})
+		require.Equal(t, http.StatusBadRequest, code)
+		code, body := printLabels(LabelRequest{ISBNs: []string{"9789129688313"}, Layout: &LabelLayout{PageWidth: 100, PageHeight: 100, Columns: 2, Rows: 2}})
+		require.Equal(t, http.StatusBadRequest, code)
+		require.Contains(t, body, "at least")
+	})
+}
@@ -0,0 +1,518 @@
+package library
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The kinds of reading lists. A member has at most one want-to-read and one
+// favorites list, and any number of custom lists.
+const (
+	ListWantToRead = "want-to-read"
+	ListFavorites  = "favorites"
+	ListCustom     = "custom"
+)
+
+// The visibilities of reading lists. Public lists can be read by anyone,
+// private lists only by the member and through their share URL.
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// defaultListNames name the lists which are created without a name.
+var defaultListNames = map[string]string{
+	ListWantToRead: "Want to read",
+	ListFavorites:  "Favorites",
+}
+
+// ReadingList is a list of books curated by a member.
+type ReadingList struct {
+	ID         string    `json:"id"`
+	MemberID   string    `json:"memberId"`
+	Name       string    `json:"name"`
+	Kind       string    `json:"kind"`
+	Visibility string    `json:"visibility"`
+	ShareURL   string    `json:"shareUrl,omitempty"` // Only shown to the member
+	CreateTime time.Time `json:"createTime"`
+	Books      []Book    `json:"books,omitempty"` // Only set when a single list is retrieved
+
+	shareToken string
+}
+
+func validateReadingList(l ReadingList) error {
+	var fieldErrors []string
+	if !titlePattern.MatchString(l.Name) {
+		fieldErrors = append(fieldErrors, " name ")
+	}
+	if l.Kind != ListWantToRead && l.Kind != ListFavorites && l.Kind != ListCustom {
+		fieldErrors = append(fieldErrors, " kind ")
+	}
+	if l.Visibility != VisibilityPublic && l.Visibility != VisibilityPrivate {
+		fieldErrors = append(fieldErrors, " visibility ")
+	}
+	if len(fieldErrors) != 0 {
+		return fmt.Errorf("validation failed, field error(s):%v. Fix these error before proceeding",
+			strings.Join(fieldErrors, ", "))
+	}
+	return nil
+}
+
+// InsertReadingList stores a reading list.
+func InsertReadingList(db Querier, l ReadingList) error {
+	_, err := db.Exec("INSERT INTO reading_list (id, memberId, name, kind, visibility, createTime) VALUES(?,?,?,?,?,?)",
+		l.ID, l.MemberID, l.Name, l.Kind, l.Visibility, l.CreateTime)
+	if err != nil {
+		return fmt.Errorf("insert reading list err, %w", err)
+	}
+	return nil
+}
+
+const readingListColumns = "id, memberId, name, kind, visibility, shareToken, createTime"
+
+func scanReadingList(row interface{ Scan(...interface{}) error }) (ReadingList, error) {
+	var l ReadingList
+	var token sql.NullString
+	err := row.Scan(&l.ID, &l.MemberID, &l.Name, &l.Kind, &l.Visibility, &token, &l.CreateTime)
+	l.shareToken = token.String
+	return l, err
+}
+
+// FindReadingList reads a reading list. It returns sql.ErrNoRows if there is
+// no such list.
+func FindReadingList(db Querier, id string) (ReadingList, error) {
+	return scanReadingList(db.QueryRow("SELECT "+readingListColumns+" FROM reading_list WHERE id = ?", id))
+}
+
+// FindSharedReadingList reads the reading list with the given share token.
+// It returns sql.ErrNoRows if no list is shared with the token.
+func FindSharedReadingList(db Querier, token string) (ReadingList, error) {
+	return scanReadingList(db.QueryRow("SELECT "+readingListColumns+" FROM reading_list WHERE shareToken = ?", token))
+}
+
+// ReadReadingLists reads the reading lists of a member ordered by creation.
+func ReadReadingLists(db Querier, memberID string) ([]ReadingList, error) {
+	rows, err := db.Query("SELECT "+readingListColumns+" FROM reading_list WHERE memberId = ? ORDER BY rowid", memberID)
+	if err != nil {
+		return nil, fmt.Errorf("query reading lists err, %w", err)
+	}
+	defer rows.Close()
+	var lists []ReadingList
+	for rows.Next() {
+		l, err := scanReadingList(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan reading list err, %w", err)
+		}
+		lists = append(lists, l)
+	}
+	return lists, rows.Err()
+}
+
+// UpdateReadingList stores the name and visibility of a reading list.
+func UpdateReadingList(db Querier, l ReadingList) error {
+	_, err := db.Exec("UPDATE reading_list SET name = ?, visibility = ? WHERE id = ?", l.Name, l.Visibility, l.ID)
+	if err != nil {
+		return fmt.Errorf("update reading list err, %w", err)
+	}
+	return nil
+}
+
+// SetShareToken shares the reading list with the token, an empty token
+// stops sharing the list.
+func SetShareToken(db Querier, id, token string) error {
+	_, err := db.Exec("UPDATE reading_list SET shareToken = ? WHERE id = ?",
+		sql.NullString{String: token, Valid: token != ""}, id)
+	if err != nil {
+		return fmt.Errorf("update share token err, %w", err)
+	}
+	return nil
+}
+
+// DeleteReadingList deletes a reading list and its items.
+func DeleteReadingList(db Querier, id string) error {
+	if _, err := db.Exec("DELETE FROM reading_list_item WHERE listId = ?", id); err != nil {
+		return fmt.Errorf("delete reading list items err, %w", err)
+	}
+	if _, err := db.Exec("DELETE FROM reading_list WHERE id = ?", id); err != nil {
+		return fmt.Errorf("delete reading list err, %w", err)
+	}
+	return nil
+}
+
+// AddListItem adds a book to a reading list, adding it twice has no effect.
+func AddListItem(db Querier, listID, isbn string, addTime time.Time) error {
+	_, err := db.Exec("INSERT OR IGNORE INTO reading_list_item (listId, isbn, addTime) VALUES(?,?,?)",
+		listID, isbn, addTime)
+	if err != nil {
+		return fmt.Errorf("insert reading list item err, %w", err)
+	}
+	return nil
+}
+
+// RemoveListItem removes a book from a reading list.
+func RemoveListItem(db Querier, listID, isbn string) error {
+	if _, err := db.Exec("DELETE FROM reading_list_item WHERE listId = ? AND isbn = ?", listID, isbn); err != nil {
+		return fmt.Errorf("delete reading list item err, %w", err)
+	}
+	return nil
+}
+
+// listBooks returns the public books of a reading list in the order they
+// were added. Books which were deleted or are embargoed are left out.
+func listBooks(db Querier, listID string, now time.Time) ([]Book, error) {
+	rows, err := db.Query("SELECT isbn FROM reading_list_item WHERE listId = ? ORDER BY rowid", listID)
+	if err != nil {
+		return nil, fmt.Errorf("query reading list items err, %w", err)
+	}
+	var isbns []string
+	for rows.Next() {
+		var isbn string
+		if err := rows.Scan(&isbn); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan reading list item err, %w", err)
+		}
+		isbns = append(isbns, isbn)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	books := []Book{}
+	for _, isbn := range isbns {
+		if b := FindPublicBook(db, isbn, now); b.ISBN != "" {
+			books = append(books, b)
+		}
+	}
+	return books, nil
+}
+
+// newShareToken returns a random token which is long enough not to be
+// guessed.
+func newShareToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand only fails if the OS has no source of randomness
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// shareURL returns the URL where the list can be read with its share
+// token, under the same API prefix as the request.
+func shareURL(r *http.Request, token string) string {
+	prefix := r.URL.Path[:strings.Index(r.URL.Path, "/members/")]
+	return externalURL(r, prefix+"/lists/shared/"+token)
+}
+
+// listMember checks that the request is logged in as the member whose
+// reading lists it reads or changes.
+func (s *Server) listMember(r *http.Request) error {
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		return &statusError{http.StatusUnauthorized, "The request is not logged in"}
+	} else if err != nil {
+		return err
+	}
+	if m.ID != mux.Vars(r)["memberId"] {
+		return &statusError{http.StatusForbidden, "Not allowed to use the reading lists of another member"}
+	}
+	return nil
+}
+
+// memberList finds the reading list of the request and writes a not found
+// error if it does not belong to the member of the request.
+func (s *Server) memberList(w http.ResponseWriter, r *http.Request) (ReadingList, bool) {
+	if err := s.listMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return ReadingList{}, false
+	}
+	vars := mux.Vars(r)
+	l, err := FindReadingList(s.db, vars["id"])
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && l.MemberID != vars["memberId"]) {
+		HandleErr(w, http.StatusNotFound, "The reading list does not exist")
+		return ReadingList{}, false
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the reading list")
+		return ReadingList{}, false
+	}
+	if l.shareToken != "" {
+		l.ShareURL = shareURL(r, l.shareToken)
+	}
+	return l, true
+}
+
+// writeReadingList writes the list with its books.
+func (s *Server) writeReadingList(w http.ResponseWriter, l ReadingList) {
+	books, err := listBooks(s.db, l.ID, time.Now())
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the reading list")
+		return
+	}
+	l.Books = books
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the reading list")
+		return
+	}
+}
+
+// GetReadingLists retrieves the reading lists of a member.
+func (s *Server) GetReadingLists(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.listMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	lists, err := ReadReadingLists(s.db, mux.Vars(r)["memberId"])
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the reading lists")
+		return
+	}
+	if lists == nil {
+		lists = []ReadingList{}
+	}
+	for i := range lists {
+		if lists[i].shareToken != "" {
+			lists[i].ShareURL = shareURL(r, lists[i].shareToken)
+		}
+	}
+	if err := json.NewEncoder(w).Encode(lists); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the reading lists")
+		return
+	}
+}
+
+// CreateReadingList creates a reading list for a member. Lists are custom
+// and private unless their kind and visibility are given.
+func (s *Server) CreateReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.listMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	memberID := mux.Vars(r)["memberId"]
+	var l ReadingList
+	if err := decodeJSON(r, &l); err != nil {
+		handleDecodeErr(w, err, "Failed to decode reading list")
+		return
+	}
+	if l.Kind == "" {
+		l.Kind = ListCustom
+	}
+	if l.Visibility == "" {
+		l.Visibility = VisibilityPrivate
+	}
+	if l.Name == "" {
+		l.Name = defaultListNames[l.Kind]
+	}
+	if err := validateReadingList(l); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	l.ID = s.idGenerator.NewID()
+	l.MemberID = memberID
+	l.CreateTime = time.Now()
+	l.ShareURL = ""
+	l.Books = nil
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if l.Kind != ListCustom {
+			lists, err := ReadReadingLists(tx, memberID)
+			if err != nil {
+				return err
+			}
+			for _, existing := range lists {
+				if existing.Kind == l.Kind {
+					return &statusError{http.StatusConflict, "The member already has a " + l.Kind + " list"}
+				}
+			}
+		}
+		return InsertReadingList(tx, l)
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to store the reading list")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the reading list")
+		return
+	}
+}
+
+// GetReadingList retrieves a reading list of a member with its books.
+func (s *Server) GetReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	l, ok := s.memberList(w, r)
+	if !ok {
+		return
+	}
+	s.writeReadingList(w, l)
+}
+
+// UpdateReadingList renames a reading list or changes its visibility.
+func (s *Server) UpdateReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	l, ok := s.memberList(w, r)
+	if !ok {
+		return
+	}
+	var update ReadingList
+	if err := decodeJSON(r, &update); err != nil {
+		handleDecodeErr(w, err, "Failed to decode reading list")
+		return
+	}
+	if update.Kind != "" && update.Kind != l.Kind {
+		HandleErr(w, http.StatusForbidden, "Not allowed to change the kind of a reading list")
+		return
+	}
+	l.Name, l.Visibility = update.Name, update.Visibility
+	if err := validateReadingList(l); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		return UpdateReadingList(tx, l)
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the reading list")
+		return
+	}
+	s.writeReadingList(w, l)
+}
+
+// DeleteReadingList deletes a reading list of a member.
+func (s *Server) DeleteReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	l, ok := s.memberList(w, r)
+	if !ok {
+		return
+	}
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		return DeleteReadingList(tx, l.ID)
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to delete the reading list")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AddToReadingList adds a book to a reading list.
+func (s *Server) AddToReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	l, ok := s.memberList(w, r)
+	if !ok {
+		return
+	}
+	isbn := mux.Vars(r)["isbn"]
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if FindPublicBook(tx, isbn, time.Now()).ISBN == "" {
+			return &statusError{http.StatusNotFound, "The book did not exist in the library"}
+		}
+		return AddListItem(tx, l.ID, isbn, time.Now())
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to store the reading list")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveFromReadingList removes a book from a reading list.
+func (s *Server) RemoveFromReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	l, ok := s.memberList(w, r)
+	if !ok {
+		return
+	}
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		return RemoveListItem(tx, l.ID, mux.Vars(r)["isbn"])
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the reading list")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ShareReadingList creates a share URL for a reading list, which lets anyone
+// with the URL read the list even if it is private. Sharing a list again
+// replaces the URL, so that the old one stops working.
+func (s *Server) ShareReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	l, ok := s.memberList(w, r)
+	if !ok {
+		return
+	}
+	token := newShareToken()
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		return SetShareToken(tx, l.ID, token)
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to share the reading list")
+		return
+	}
+	l.ShareURL = shareURL(r, token)
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the reading list")
+		return
+	}
+}
+
+// UnshareReadingList stops sharing a reading list.
+func (s *Server) UnshareReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	l, ok := s.memberList(w, r)
+	if !ok {
+		return
+	}
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		return SetShareToken(tx, l.ID, "")
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to unshare the reading list")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPublicReadingList retrieves a public reading list with its books.
+// Private lists are reported as missing.
+func (s *Server) GetPublicReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	l, err := FindReadingList(s.db, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && l.Visibility != VisibilityPublic) {
+		HandleErr(w, http.StatusNotFound, "The reading list does not exist")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the reading list")
+		return
+	}
+	s.writeReadingList(w, l)
+}
+
+// GetSharedReadingList retrieves the reading list shared with the token of
+// the request.
+func (s *Server) GetSharedReadingList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	l, err := FindSharedReadingList(s.db, mux.Vars(r)["token"])
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The reading list does not exist")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the reading list")
+		return
+	}
+	s.writeReadingList(w, l)
+}
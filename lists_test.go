@@ -0,0 +1,103 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadingLists(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	for _, isbn := range []string{"1233211233215", "1233211233213"} {
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: starWarsTitles[isbn],
+			Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "adlibris"})
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+	}
+	lists := "/api/v1/members/member-1/lists"
+	member, other := createMemberSession(t, db, "member-1"), createMemberSession(t, db, "member-2")
+	serve := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		return createNewMemberRequest(method, path, body, db, member)
+	}
+	createList := func(l ReadingList) *httptest.ResponseRecorder {
+		jsonBytes, _ := json.Marshal(l)
+		return serve(http.MethodPost, lists, jsonBytes)
+	}
+
+	var wantToRead ReadingList
+	t.Run("Creates lists of every kind", func(t *testing.T) {
+		response := createList(ReadingList{Kind: ListWantToRead})
+		require.Equal(t, http.StatusCreated, response.Code)
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&wantToRead))
+		require.Equal(t, "Want to read", wantToRead.Name)
+		require.Equal(t, VisibilityPrivate, wantToRead.Visibility)
+
+		require.Equal(t, http.StatusConflict, createList(ReadingList{Kind: ListWantToRead}).Code)
+		require.Equal(t, http.StatusCreated, createList(ReadingList{Name: "Space operas"}).Code)
+		require.Equal(t, http.StatusNotAcceptable, createList(ReadingList{Name: "x", Kind: "wishlist"}).Code)
+
+		response = serve(http.MethodGet, lists, nil)
+		var got []ReadingList
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Len(t, got, 2)
+	})
+
+	t.Run("Adds books to a list", func(t *testing.T) {
+		for _, isbn := range []string{"1233211233213", "1233211233215", "1233211233213"} {
+			response := serve(http.MethodPut, lists+"/"+wantToRead.ID+"/books/"+isbn, nil)
+			require.Equal(t, http.StatusNoContent, response.Code)
+		}
+		response := serve(http.MethodPut, lists+"/"+wantToRead.ID+"/books/1233211233210", nil)
+		require.Equal(t, http.StatusNotFound, response.Code)
+		response = serve(http.MethodDelete, lists+"/"+wantToRead.ID+"/books/1233211233215", nil)
+		require.Equal(t, http.StatusNoContent, response.Code)
+
+		response = serve(http.MethodGet, lists+"/"+wantToRead.ID, nil)
+		var got ReadingList
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Len(t, got.Books, 1)
+		require.Equal(t, "1233211233213", got.Books[0].ISBN)
+	})
+
+	t.Run("Hides private lists from others", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/lists/"+wantToRead.ID, nil, db)
+		require.Equal(t, http.StatusNotFound, response.Code)
+		response = createNewMemberRequest(http.MethodGet, "/api/v1/members/member-2/lists/"+wantToRead.ID, nil, db, other)
+		require.Equal(t, http.StatusNotFound, response.Code)
+		response = createNewMemberRequest(http.MethodGet, lists+"/"+wantToRead.ID, nil, db, other)
+		require.Equal(t, http.StatusForbidden, response.Code, "a member should not read the lists of another member")
+		response = createNewRequest(http.MethodGet, lists, nil, db)
+		require.Equal(t, http.StatusUnauthorized, response.Code)
+
+		jsonBytes, _ := json.Marshal(ReadingList{Name: "Want to read", Visibility: VisibilityPublic})
+		response = serve(http.MethodPut, lists+"/"+wantToRead.ID, jsonBytes)
+		require.Equal(t, http.StatusOK, response.Code)
+		response = createNewRequest(http.MethodGet, "/api/v1/lists/"+wantToRead.ID, nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Shares a list with a token URL", func(t *testing.T) {
+		response := serve(http.MethodPost, lists+"/"+wantToRead.ID+":share", nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		var shared ReadingList
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&shared))
+		path := shared.ShareURL[strings.Index(shared.ShareURL, "/api/v1/lists/shared/"):]
+
+		response = createNewRequest(http.MethodGet, path, nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var got ReadingList
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Equal(t, wantToRead.ID, got.ID)
+		require.Empty(t, got.ShareURL)
+
+		response = serve(http.MethodPost, lists+"/"+wantToRead.ID+":unshare", nil)
+		require.Equal(t, http.StatusNoContent, response.Code)
+		response = createNewRequest(http.MethodGet, path, nil, db)
+		require.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
@@ -0,0 +1,308 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The scopes of the failed logins.
+const (
+	lockoutAccount = "account" // Keyed by the email
+	lockoutIP      = "ip"      // Keyed by the IP address of the client
+)
+
+// The kinds of security events.
+const (
+	SecurityLoginSucceeded   = "login-succeeded"
+	SecurityLoginFailed      = "login-failed"
+	SecurityLoginBlocked     = "login-blocked" // A login while locked out
+	SecurityLockedOut        = "locked-out"
+	SecurityLockoutCleared   = "lockout-cleared"
+	SecurityTOTPEnrolled     = "totp-enrolled"
+	SecurityTOTPDisabled     = "totp-disabled"
+	SecurityRecoveryCodeUsed = "recovery-code-used"
+)
+
+// LockoutPolicy limits the failed logins. An account or IP address is
+// locked out after the given number of failures, for BaseDelay which
+// doubles with every further failure up to MaxDelay. The failures are
+// forgotten after a successful login, or ResetAfter without failures.
+type LockoutPolicy struct {
+	AccountFailures int
+	IPFailures      int // Higher than per account, since clients may share an IP address
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	ResetAfter      time.Duration
+}
+
+var defaultLockoutPolicy = LockoutPolicy{
+	AccountFailures: 5,
+	IPFailures:      20,
+	BaseDelay:       time.Minute,
+	MaxDelay:        24 * time.Hour,
+	ResetAfter:      24 * time.Hour,
+}
+
+// WithLockoutPolicy sets the policy of locking out accounts and IP
+// addresses after failed logins. The default locks out an account after 5
+// failures and an IP address after 20, for 1 minute up to 24 hours.
+func WithLockoutPolicy(p LockoutPolicy) ServerOption {
+	return func(s *Server) {
+		s.lockoutPolicy = p
+	}
+}
+
+// delay returns how long to lock out after the given number of failures,
+// zero if the limit has not been reached.
+func (p LockoutPolicy) delay(failures, limit int) time.Duration {
+	if failures < limit {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := limit; i < failures && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// Lockout is the failed logins of an account or IP address.
+type Lockout struct {
+	Scope           string     `json:"scope"`
+	Key             string     `json:"key"`
+	Failures        int        `json:"failures"`
+	LastFailureTime time.Time  `json:"lastFailureTime"`
+	LockedUntil     *time.Time `json:"lockedUntil,omitempty"` // nil unless locked out
+}
+
+// SecurityEvent is an entry of the security audit log.
+type SecurityEvent struct {
+	ID         int64     `json:"id"`
+	Kind       string    `json:"kind"`
+	Email      string    `json:"email,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	CreateTime time.Time `json:"createTime"`
+}
+
+// recordSecurityEvent writes an event to the security audit log.
+func recordSecurityEvent(q Querier, kind, email, ip string, now time.Time) error {
+	_, err := q.Exec("INSERT INTO security_event (kind, email, ip, createTime) VALUES(?,?,?,?)",
+		kind, email, ip, now.Unix())
+	if err != nil {
+		return fmt.Errorf("insert security event err, %w", err)
+	}
+	return nil
+}
+
+// clientIP returns the IP address of the client of a request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// lockedUntil returns when the lockout of the email or IP address ends, the
+// zero time if neither is locked out.
+func lockedUntil(q Querier, email, ip string, now time.Time) (time.Time, error) {
+	var until int64
+	err := q.QueryRow("SELECT COALESCE(MAX(lockedUntil), 0) FROM login_failure WHERE lockedUntil > ? AND ((scope = ? AND key = ?) OR (scope = ? AND key = ?))",
+		now.Unix(), lockoutAccount, email, lockoutIP, ip).Scan(&until)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("read lockout err, %w", err)
+	}
+	if until == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(until, 0), nil
+}
+
+// addLoginFailure counts a failed login of the scope and key and returns
+// when its lockout ends, the zero time if it is not locked out.
+func addLoginFailure(q Querier, scope, key string, limit int, p LockoutPolicy, now time.Time) (time.Time, error) {
+	var failures int
+	var lastFailureTime int64
+	err := q.QueryRow("SELECT failures, lastFailureTime FROM login_failure WHERE scope = ? AND key = ?", scope, key).
+		Scan(&failures, &lastFailureTime)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("read login failures err, %w", err)
+	}
+	if now.Sub(time.Unix(lastFailureTime, 0)) > p.ResetAfter {
+		failures = 0
+	}
+	failures++
+	var until time.Time
+	var untilUnix int64
+	if d := p.delay(failures, limit); d > 0 {
+		until = now.Add(d)
+		untilUnix = until.Unix()
+	}
+	_, err = q.Exec(`INSERT INTO login_failure (scope, key, failures, lastFailureTime, lockedUntil) VALUES(?,?,?,?,?)
+		ON CONFLICT (scope, key) DO UPDATE SET failures = excluded.failures,
+			lastFailureTime = excluded.lastFailureTime, lockedUntil = excluded.lockedUntil`,
+		scope, key, failures, now.Unix(), untilUnix)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("store login failure err, %w", err)
+	}
+	return until, nil
+}
+
+// recordLoginFailure counts a failed login of the email from the IP address
+// and audits it, and the lockouts it causes.
+func (s *Server) recordLoginFailure(q Querier, email, ip string, now time.Time) error {
+	if err := recordSecurityEvent(q, SecurityLoginFailed, email, ip, now); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		scope, key string
+		limit      int
+	}{
+		{lockoutAccount, email, s.lockoutPolicy.AccountFailures},
+		{lockoutIP, ip, s.lockoutPolicy.IPFailures},
+	} {
+		until, err := addLoginFailure(q, f.scope, f.key, f.limit, s.lockoutPolicy, now)
+		if err != nil {
+			return err
+		}
+		if !until.IsZero() {
+			if err := recordSecurityEvent(q, SecurityLockedOut, email, ip, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// clearLoginFailures forgets the failed logins of the scope and key.
+func clearLoginFailures(q Querier, scope, key string) error {
+	if _, err := q.Exec("DELETE FROM login_failure WHERE scope = ? AND key = ?", scope, key); err != nil {
+		return fmt.Errorf("delete login failures err, %w", err)
+	}
+	return nil
+}
+
+// ListLockouts lists the accounts and IP addresses with failed logins, the
+// locked out ones first, to an admin.
+func (s *Server) ListLockouts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	rows, err := s.db.Query("SELECT scope, key, failures, lastFailureTime, lockedUntil FROM login_failure ORDER BY lockedUntil DESC, lastFailureTime DESC")
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the lockouts")
+		return
+	}
+	defer rows.Close()
+	lockouts := []Lockout{}
+	now := time.Now()
+	for rows.Next() {
+		var l Lockout
+		var lastFailureTime, until int64
+		if err := rows.Scan(&l.Scope, &l.Key, &l.Failures, &lastFailureTime, &until); err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the lockouts")
+			return
+		}
+		l.LastFailureTime = time.Unix(lastFailureTime, 0).UTC()
+		if until > now.Unix() {
+			t := time.Unix(until, 0).UTC()
+			l.LockedUntil = &t
+		}
+		lockouts = append(lockouts, l)
+	}
+	if err := rows.Err(); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the lockouts")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(lockouts); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the lockouts")
+		return
+	}
+}
+
+// ClearLockout forgets the failed logins of an account or IP address, which
+// ends its lockout. Only admins can clear lockouts.
+func (s *Server) ClearLockout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	vars := mux.Vars(r)
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if err := clearLoginFailures(tx, vars["scope"], vars["key"]); err != nil {
+			return err
+		}
+		email, ip := vars["key"], ""
+		if vars["scope"] == lockoutIP {
+			email, ip = "", vars["key"]
+		}
+		return recordSecurityEvent(tx, SecurityLockoutCleared, email, ip, time.Now())
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to clear the lockout")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListSecurityEvents lists the latest events of the security audit log,
+// optionally of a single kind, e.g. ?kind=locked-out&limit=50, to an admin.
+func (s *Server) ListSecurityEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			HandleErr(w, http.StatusBadRequest, "The limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+	query, args := "SELECT id, kind, email, ip, createTime FROM security_event", []interface{}{}
+	if kind := strings.TrimSpace(r.URL.Query().Get("kind")); kind != "" {
+		query, args = query+" WHERE kind = ?", append(args, kind)
+	}
+	rows, err := s.db.Query(query+" ORDER BY id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the security events")
+		return
+	}
+	defer rows.Close()
+	securityEvents := []SecurityEvent{}
+	for rows.Next() {
+		var e SecurityEvent
+		var createTime int64
+		if err := rows.Scan(&e.ID, &e.Kind, &e.Email, &e.IP, &createTime); err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the security events")
+			return
+		}
+		e.CreateTime = time.Unix(createTime, 0).UTC()
+		securityEvents = append(securityEvents, e)
+	}
+	if err := rows.Err(); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the security events")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(securityEvents); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the security events")
+		return
+	}
+}
@@ -0,0 +1,119 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockoutPolicy(t *testing.T) {
+	p := LockoutPolicy{BaseDelay: time.Minute, MaxDelay: time.Hour}
+	require.Equal(t, time.Duration(0), p.delay(2, 3))
+	require.Equal(t, time.Minute, p.delay(3, 3))
+	require.Equal(t, 4*time.Minute, p.delay(5, 3))
+	require.Equal(t, time.Hour, p.delay(100, 3))
+}
+
+func TestLockout(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	server := NewServer(db, WithLockoutPolicy(LockoutPolicy{AccountFailures: 2, IPFailures: 4,
+		BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour}))
+
+	passwordHash, err := hashPassword("pippi longstocking")
+	require.NoError(t, err)
+	require.NoError(t, server.InsertMember(db, Member{ID: "astrid", Email: "astrid@example.com", FirstName: "astrid",
+		LastName: "lindgren", EmailVerified: true, CreateTime: time.Now().UTC()}, passwordHash))
+
+	admin := Member{ID: "admin", Email: "admin@example.com", FirstName: "admin", LastName: "admin",
+		EmailVerified: true, CreateTime: time.Now().UTC()}
+	require.NoError(t, server.InsertMember(db, admin, ""))
+	require.NoError(t, setRoles(db, admin.ID, []string{RoleAdmin}))
+	session, err := server.startSession(db, admin)
+	require.NoError(t, err)
+
+	serve := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		jsonBytes, _ := json.Marshal(body)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(method, path, bytes.NewReader(jsonBytes)))
+		return response
+	}
+	serveAdmin := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	login := func(email, password string) int {
+		return serve(http.MethodPost, "/api/v1/login", loginRequest{Email: email, Password: password}).Code
+	}
+
+	t.Run("Locks out the account after failed logins", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, login("astrid@example.com", "not the password"))
+		require.Equal(t, http.StatusUnauthorized, login("Astrid@example.com", "not the password"))
+		response := serve(http.MethodPost, "/api/v1/login", loginRequest{Email: "astrid@example.com", Password: "pippi longstocking"})
+		require.Equal(t, http.StatusTooManyRequests, response.Code)
+		require.NotEmpty(t, response.Header().Get("Retry-After"))
+
+		response = serveAdmin(http.MethodGet, "/api/v1/admin/lockouts", session.Token)
+		require.Equal(t, http.StatusOK, response.Code)
+		var lockouts []Lockout
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&lockouts))
+		require.Len(t, lockouts, 2)
+		require.Equal(t, lockoutAccount, lockouts[0].Scope)
+		require.Equal(t, 2, lockouts[0].Failures)
+		require.NotNil(t, lockouts[0].LockedUntil)
+		require.Nil(t, lockouts[1].LockedUntil, "the IP address should not be locked out yet")
+	})
+
+	t.Run("Audits the logins", func(t *testing.T) {
+		response := serveAdmin(http.MethodGet, "/api/v1/admin/security-events?kind="+SecurityLoginBlocked, session.Token)
+		require.Equal(t, http.StatusOK, response.Code)
+		var securityEvents []SecurityEvent
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&securityEvents))
+		require.Len(t, securityEvents, 1)
+		require.Equal(t, "astrid@example.com", securityEvents[0].Email)
+		require.Equal(t, "192.0.2.1", securityEvents[0].IP)
+
+		response = serveAdmin(http.MethodGet, "/api/v1/admin/security-events?kind="+SecurityLockedOut, session.Token)
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&securityEvents))
+		require.Len(t, securityEvents, 1)
+	})
+
+	t.Run("Only lets admins see and clear the lockouts", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, serveAdmin(http.MethodGet, "/api/v1/admin/lockouts", "").Code)
+		require.Equal(t, http.StatusUnauthorized, serveAdmin(http.MethodGet, "/api/v1/admin/security-events", "").Code)
+		require.Equal(t, http.StatusUnauthorized,
+			serveAdmin(http.MethodDelete, "/api/v1/admin/lockouts/account/astrid@example.com", "").Code)
+
+		librarian := Member{ID: "emil", Email: "emil@example.com", FirstName: "emil", LastName: "lonneberga",
+			EmailVerified: true, CreateTime: time.Now().UTC()}
+		require.NoError(t, server.InsertMember(db, librarian, ""))
+		require.NoError(t, setRoles(db, librarian.ID, []string{RoleLibrarian}))
+		s, err := server.startSession(db, librarian)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, serveAdmin(http.MethodGet, "/api/v1/admin/lockouts", s.Token).Code)
+		require.Equal(t, http.StatusForbidden,
+			serveAdmin(http.MethodDelete, "/api/v1/admin/lockouts/account/astrid@example.com", s.Token).Code)
+	})
+
+	t.Run("Clears the lockout", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, serveAdmin(http.MethodDelete, "/api/v1/admin/lockouts/account/astrid@example.com", session.Token).Code)
+		require.Equal(t, http.StatusOK, login("astrid@example.com", "pippi longstocking"))
+	})
+
+	t.Run("Locks out the IP address after failed logins", func(t *testing.T) {
+		for _, email := range []string{"emil@example.com", "karlsson@example.com"} {
+			require.Equal(t, http.StatusUnauthorized, login(email, "not the password"))
+		}
+		require.Equal(t, http.StatusTooManyRequests, login("astrid@example.com", "pippi longstocking"))
+	})
+}
@@ -0,0 +1,242 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultLockTTL is how long the editing lock of a book lasts by default.
+const defaultLockTTL = 5 * time.Minute
+
+// WithLockTTL sets how long the editing lock of a book lasts unless its
+// holder renews it. The default is 5 minutes.
+func WithLockTTL(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.lockTTL = d
+	}
+}
+
+// BookLock is an advisory lock which gives a librarian the exclusive right
+// to edit a book until it expires. The holder keeps the lock by renewing it
+// before then.
+type BookLock struct {
+	ISBN        string    `json:"isbn"`
+	MemberID    string    `json:"memberId"`
+	MemberName  string    `json:"memberName"`
+	AcquireTime time.Time `json:"acquireTime"`
+	ExpireTime  time.Time `json:"expireTime"`
+}
+
+// LockConflict is the response when a book is locked by another member.
+type LockConflict struct {
+	Error string   `json:"error"`
+	Lock  BookLock `json:"lock"`
+}
+
+// lockedError is returned when a book is edited while another member holds
+// its lock.
+type lockedError struct {
+	lock BookLock
+}
+
+func (e *lockedError) Error() string {
+	return fmt.Sprintf("The book is being edited by %s", e.lock.MemberName)
+}
+
+// writeLockConflict writes the lock of the error with status 423.
+func writeLockConflict(w http.ResponseWriter, err *lockedError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusLocked)
+	json.NewEncoder(w).Encode(LockConflict{Error: err.Error(), Lock: err.lock})
+}
+
+// readBookLock reads the lock of a book. It returns sql.ErrNoRows if the
+// book is not locked or the lock has expired.
+func readBookLock(db Querier, isbn string, now time.Time) (BookLock, error) {
+	l := BookLock{ISBN: isbn}
+	var acquireTime, expireTime int64
+	err := db.QueryRow("SELECT memberId, memberName, acquireTime, expireTime FROM book_lock WHERE isbn = ? AND expireTime > ?",
+		isbn, now.Unix()).Scan(&l.MemberID, &l.MemberName, &acquireTime, &expireTime)
+	if err != nil {
+		return BookLock{}, err
+	}
+	l.AcquireTime = time.Unix(acquireTime, 0).UTC()
+	l.ExpireTime = time.Unix(expireTime, 0).UTC()
+	return l, nil
+}
+
+// acquireBookLock locks the book for the member until now+ttl. A lock which
+// the member already holds is extended, and a lock of another member is
+// only taken over once it has expired. The check and the write are a single
+// statement like claimUpdate.
+func acquireBookLock(db Querier, isbn string, m Member, now time.Time, ttl time.Duration) (BookLock, error) {
+	res, err := db.Exec("INSERT INTO book_lock (isbn, memberId, memberName, acquireTime, expireTime) VALUES(?1, ?2, ?3, ?4, ?5) "+
+		"ON CONFLICT (isbn) DO UPDATE SET acquireTime = CASE WHEN memberId = ?2 THEN acquireTime ELSE ?4 END, "+
+		"memberId = ?2, memberName = ?3, expireTime = ?5 WHERE memberId = ?2 OR expireTime <= ?4",
+		isbn, m.ID, m.FirstName+" "+m.LastName, now.Unix(), now.Add(ttl).Unix())
+	if err != nil {
+		return BookLock{}, fmt.Errorf("acquire lock err, %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return BookLock{}, fmt.Errorf("acquire lock err, %w", err)
+	}
+	l, err := readBookLock(db, isbn, now)
+	if err != nil {
+		return BookLock{}, fmt.Errorf("read lock err, %w", err)
+	}
+	if n == 0 {
+		return BookLock{}, &lockedError{l}
+	}
+	return l, nil
+}
+
+// checkBookLock returns a lockedError if the book is locked by another
+// member than the editor. Editors which are not logged in have the id "".
+func checkBookLock(db Querier, isbn, editorID string, now time.Time) error {
+	l, err := readBookLock(db, isbn, now)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read lock err, %w", err)
+	}
+	if l.MemberID != editorID {
+		return &lockedError{l}
+	}
+	return nil
+}
+
+// editor returns the member who is logged in by the request, or an empty
+// member when it is not logged in. A request with a session which has ended
+// is refused rather than treated as not logged in, and once a member is a
+// trainee, edits which are not logged in are refused, since they would
+// bypass the review of the edits of trainees. It is read before the
+// transaction of an edit, since reading the session may write to the
+// database.
+func (s *Server) editor(r *http.Request) (Member, error) {
+	m, err := s.sessionMember(r)
+	if err == nil {
+		return m, nil
+	} else if !errors.Is(err, errNoMember) {
+		return Member{}, err
+	}
+	if s.sessionToken(r) != "" {
+		return Member{}, &statusError{http.StatusUnauthorized, "The session has ended"}
+	}
+	trainees, err := hasTrainees(s.db)
+	if err != nil {
+		return Member{}, err
+	}
+	if trainees {
+		return Member{}, &statusError{http.StatusUnauthorized, "The request is not logged in"}
+	}
+	return Member{}, nil
+}
+
+// lockingMember returns the member of the session when it may lock books,
+// which is allowed for librarians and admins.
+func (s *Server) lockingMember(r *http.Request) (Member, error) {
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		return Member{}, &statusError{http.StatusUnauthorized, "The request is not logged in"}
+	} else if err != nil {
+		return Member{}, err
+	}
+	if !hasRole(m, RoleLibrarian) && !hasRole(m, RoleAdmin) {
+		return Member{}, &statusError{http.StatusForbidden, "Only librarians and admins can lock books"}
+	}
+	return m, nil
+}
+
+// handleLockErr writes the lock of a lockedError, and otherwise reports the
+// error like handleMemberErr.
+func handleLockErr(w http.ResponseWriter, err error, message string) {
+	var le *lockedError
+	if errors.As(err, &le) {
+		writeLockConflict(w, le)
+		return
+	}
+	handleMemberErr(w, err, message)
+}
+
+// LockBook gives the librarian who is logged in an exclusive editing lock
+// of the book. The holder renews the lock by locking the book again, which
+// the admin UI does as a heartbeat while the book is open. Other members get
+// 423 Locked with the lock.
+func (s *Server) LockBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	isbn := mux.Vars(r)["isbn"]
+	m, err := s.lockingMember(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var l BookLock
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if FindSpecificBook(tx, isbn).ISBN == "" {
+			return &statusError{http.StatusNotFound, "The book did not exist in the library"}
+		}
+		var err error
+		l, err = acquireBookLock(tx, isbn, m, time.Now(), s.lockTTL)
+		return err
+	})
+	if err != nil {
+		handleLockErr(w, err, "Failed to lock the book")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		handleErr("Failed to encode lock", err)
+		return
+	}
+}
+
+// UnlockBook releases the lock of the book. Only the holder of the lock or
+// an admin can release it, and a book which is not locked stays unlocked.
+func (s *Server) UnlockBook(w http.ResponseWriter, r *http.Request) {
+	isbn := mux.Vars(r)["isbn"]
+	m, err := s.lockingMember(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if !hasRole(m, RoleAdmin) {
+			if err := checkBookLock(tx, isbn, m.ID, time.Now()); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("DELETE FROM book_lock WHERE isbn = ?", isbn); err != nil {
+			return fmt.Errorf("release lock err, %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		handleLockErr(w, err, "Failed to unlock the book")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetBookLock retrieves the lock of a book, so that editors can see who is
+// editing it.
+func (s *Server) GetBookLock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	l, err := readBookLock(s.db, mux.Vars(r)["isbn"], time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The book is not locked")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the lock")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		handleErr("Failed to encode lock", err)
+		return
+	}
+}
@@ -0,0 +1,126 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBookLocks(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	server := NewServer(db, WithLockTTL(time.Hour), WithMinDurationBetweenUpdates(0))
+
+	serve := func(method, path, token string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", jsonContentType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	session := func(id string, roles ...string) string {
+		t.Helper()
+		m := Member{ID: id, Email: id + "@example.com", FirstName: id, LastName: "Librarian", EmailVerified: true, CreateTime: time.Now()}
+		require.NoError(t, server.InsertMember(db, m, ""))
+		require.NoError(t, setRoles(db, id, roles))
+		s, err := server.startSession(db, m)
+		require.NoError(t, err)
+		return s.Token
+	}
+	astrid, emil, ida, admin := session("astrid", RoleLibrarian), session("emil", RoleLibrarian), session("ida"), session("admin", RoleAdmin)
+
+	isbn := "9789129688313"
+	book := Book{ISBN: isbn, Title: "Pippi Langstrump", Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben"}
+	jsonBytes, _ := json.Marshal(book)
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/"+isbn, "", jsonBytes).Code)
+	lock := func(token string) *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/api/v1/books/"+isbn+":lock", token, nil)
+	}
+
+	t.Run("Only lets librarians lock books", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, lock("").Code)
+		require.Equal(t, http.StatusForbidden, lock(ida).Code)
+		response := serve(http.MethodPost, "/api/v1/books/9780000000000:lock", astrid, nil)
+		require.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Gives the lock to one librarian at a time", func(t *testing.T) {
+		response := lock(astrid)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		var first BookLock
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&first))
+		require.Equal(t, "astrid", first.MemberID)
+
+		response = lock(emil)
+		require.Equal(t, http.StatusLocked, response.Code)
+		var conflict LockConflict
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&conflict))
+		require.Equal(t, "astrid Librarian", conflict.Lock.MemberName)
+
+		response = lock(astrid)
+		require.Equal(t, http.StatusOK, response.Code, "the holder renews the lock")
+		var renewed BookLock
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&renewed))
+		require.Equal(t, first.AcquireTime, renewed.AcquireTime)
+
+		response = serve(http.MethodGet, "/api/v1/books/"+isbn+"/lock", "", nil)
+		require.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Rejects edits by others while locked", func(t *testing.T) {
+		book.Title = "Pippi Longstocking"
+		jsonBytes, _ := json.Marshal(book)
+		require.Equal(t, http.StatusLocked, serve(http.MethodPut, "/api/v1/books/"+isbn, emil, jsonBytes).Code)
+		require.Equal(t, http.StatusLocked, serve(http.MethodPut, "/api/v1/books/"+isbn, "", jsonBytes).Code)
+		require.Equal(t, http.StatusLocked, serve(http.MethodDelete, "/api/v1/books/"+isbn, emil, nil).Code)
+		jsonBytes, _ = json.Marshal([]BatchOperation{{Method: "delete", ISBN: isbn}})
+		response := serve(http.MethodPost, "/api/v1/books:batch", emil, jsonBytes)
+		var results []BatchResult
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&results))
+		require.Equal(t, http.StatusLocked, results[0].Status)
+		require.Equal(t, http.StatusLocked, serve(http.MethodDelete, "/api/v1/books?publisher=raben&confirm=true", emil, nil).Code)
+		require.Equal(t, isbn, FindSpecificBook(db, isbn).ISBN, "a bulk delete keeps the locked book")
+
+		jsonBytes, _ = json.Marshal(book)
+		require.Equal(t, http.StatusOK, serve(http.MethodPut, "/api/v1/books/"+isbn, astrid, jsonBytes).Code)
+	})
+
+	t.Run("Undoes no operations on books locked by others", func(t *testing.T) {
+		other := Book{ISBN: "9789129688320", Title: "Emil i Lonneberga", Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben"}
+		jsonBytes, _ := json.Marshal([]BatchOperation{{Method: "create", ISBN: other.ISBN, Book: &other}})
+		response := serve(http.MethodPost, "/api/v1/books:batch", emil, jsonBytes)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		operationID := response.Header().Get(operationIDHeader)
+		require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/"+other.ISBN+":lock", astrid, nil).Code)
+
+		require.Equal(t, http.StatusLocked, serve(http.MethodPost, "/api/v1/operations/"+operationID+":undo", emil, nil).Code)
+		require.Equal(t, other.ISBN, FindSpecificBook(db, other.ISBN).ISBN)
+		require.Equal(t, http.StatusNoContent, serve(http.MethodPost, "/api/v1/books/"+other.ISBN+":unlock", astrid, nil).Code)
+	})
+
+	t.Run("Releases the lock", func(t *testing.T) {
+		require.Equal(t, http.StatusLocked, serve(http.MethodPost, "/api/v1/books/"+isbn+":unlock", emil, nil).Code)
+		require.Equal(t, http.StatusNoContent, serve(http.MethodPost, "/api/v1/books/"+isbn+":unlock", astrid, nil).Code)
+		require.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/api/v1/books/"+isbn+"/lock", "", nil).Code)
+
+		require.Equal(t, http.StatusOK, lock(emil).Code)
+		require.Equal(t, http.StatusNoContent, serve(http.MethodPost, "/api/v1/books/"+isbn+":unlock", admin, nil).Code)
+	})
+
+	t.Run("Takes over expired locks", func(t *testing.T) {
+		now := time.Now()
+		_, err := acquireBookLock(db, isbn, Member{ID: "astrid"}, now.Add(-2*time.Hour), time.Hour)
+		require.NoError(t, err)
+		l, err := acquireBookLock(db, isbn, Member{ID: "emil"}, now, time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, "emil", l.MemberID)
+	})
+}
@@ -0,0 +1,121 @@
+// Package marc reads MARC 21 records in the MARCXML format and retrieves them
+// from copy cataloging sources which speak SRU, the HTTP successor of Z39.50.
+// Targets which only speak the binary Z39.50 protocol are not supported.
+package marc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Record is a MARC 21 record.
+type Record struct {
+	Leader        string         `xml:"leader"`
+	ControlFields []ControlField `xml:"controlfield"`
+	DataFields    []DataField    `xml:"datafield"`
+}
+
+// ControlField is a field without indicators and subfields, e.g. 001.
+type ControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+// DataField is a field with indicators and subfields, e.g. 245.
+type DataField struct {
+	Tag       string     `xml:"tag,attr"`
+	Ind1      string     `xml:"ind1,attr"`
+	Ind2      string     `xml:"ind2,attr"`
+	Subfields []Subfield `xml:"subfield"`
+}
+
+// Subfield is a subfield of a data field.
+type Subfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Fields returns the data fields with the given tag.
+func (r Record) Fields(tag string) []DataField {
+	var fields []DataField
+	for _, f := range r.DataFields {
+		if f.Tag == tag {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Control returns the value of the control field with the given tag, or ""
+// if there is none.
+func (r Record) Control(tag string) string {
+	for _, f := range r.ControlFields {
+		if f.Tag == tag {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Subfield returns the first value of the subfield with the given code in
+// the first field with the given tag, or "" if there is none.
+func (r Record) Subfield(tag, code string) string {
+	for _, f := range r.Fields(tag) {
+		if v := f.Subfield(code); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Subfield returns the first value of the subfield with the given code, or
+// "" if there is none.
+func (f DataField) Subfield(code string) string {
+	for _, sf := range f.Subfields {
+		if sf.Code == code {
+			return sf.Value
+		}
+	}
+	return ""
+}
+
+// ReadRecords reads the records of a MARCXML document. Both a single record
+// and a collection of records are accepted, as well as records embedded in
+// another document, such as an SRU response.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read marcxml err, %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "record" || !isMARCRecord(start) {
+			continue
+		}
+		var rec Record
+		if err := dec.DecodeElement(&rec, &start); err != nil {
+			return nil, fmt.Errorf("decode marc record err, %w", err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// isMARCRecord reports whether the element is a MARCXML record. SRU responses
+// have record elements of their own, which wrap the MARCXML records.
+func isMARCRecord(start xml.StartElement) bool {
+	return start.Name.Space == "" ||
+		strings.HasPrefix(start.Name.Space, "http://www.loc.gov/MARC21/slim")
+}
+
+// TrimPunctuation removes the ISBD punctuation which catalogers put at the end
+// of subfields, e.g. "Pippi Longstocking /" becomes "Pippi Longstocking".
+func TrimPunctuation(s string) string {
+	return strings.TrimRight(strings.TrimSpace(s), " /:;,.=")
+}
@@ -0,0 +1,89 @@
+package marc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/marc"
+	"github.com/stretchr/testify/require"
+)
+
+const sruResponse = `<?xml version="1.0"?>
+<zs:searchRetrieveResponse xmlns:zs="http://www.loc.gov/zing/srw/">
+  <zs:version>1.1</zs:version>
+  <zs:numberOfRecords>1</zs:numberOfRecords>
+  <zs:records>
+    <zs:record>
+      <zs:recordSchema>marcxml</zs:recordSchema>
+      <zs:recordData>
+        <record xmlns="http://www.loc.gov/MARC21/slim">
+          <leader>01142cam  2200301 a 4500</leader>
+          <controlfield tag="001">4910214</controlfield>
+          <datafield tag="082" ind1="0" ind2="0">
+            <subfield code="a">839.73/7</subfield>
+          </datafield>
+          <datafield tag="100" ind1="1" ind2=" ">
+            <subfield code="a">Lindgren, Astrid,</subfield>
+            <subfield code="d">1907-2002.</subfield>
+          </datafield>
+          <datafield tag="245" ind1="1" ind2="0">
+            <subfield code="a">Pippi Longstocking /</subfield>
+            <subfield code="c">Astrid Lindgren.</subfield>
+          </datafield>
+          <datafield tag="260" ind1=" " ind2=" ">
+            <subfield code="a">New York :</subfield>
+            <subfield code="b">Viking,</subfield>
+          </datafield>
+        </record>
+      </zs:recordData>
+    </zs:record>
+  </zs:records>
+</zs:searchRetrieveResponse>`
+
+const emptySRUResponse = `<?xml version="1.0"?>
+<zs:searchRetrieveResponse xmlns:zs="http://www.loc.gov/zing/srw/">
+  <zs:numberOfRecords>0</zs:numberOfRecords>
+</zs:searchRetrieveResponse>`
+
+func TestReadRecords(t *testing.T) {
+	records, err := marc.ReadRecords(strings.NewReader(sruResponse))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	rec := records[0]
+	require.Equal(t, "4910214", rec.ControlFields[0].Value)
+	require.Equal(t, "Lindgren, Astrid,", rec.Subfield("100", "a"))
+	require.Equal(t, "1907-2002.", rec.Subfield("100", "d"))
+	require.Equal(t, "Pippi Longstocking", marc.TrimPunctuation(rec.Subfield("245", "a")))
+	require.Empty(t, rec.Subfield("245", "b"))
+}
+
+func TestSRUClient(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		if strings.Contains(query, "9780670557455") {
+			w.Write([]byte(sruResponse))
+			return
+		}
+		w.Write([]byte(emptySRUResponse))
+	}))
+	defer srv.Close()
+	c := marc.NewSRUClient(srv.URL)
+
+	t.Run("Finds the record by ISBN", func(t *testing.T) {
+		rec, err := c.FindByISBN(context.Background(), "9780670557455")
+		require.NoError(t, err)
+		require.Equal(t, `bath.isbn="9780670557455"`, query)
+		require.Equal(t, "Viking,", rec.Subfield("260", "b"))
+	})
+
+	t.Run("Returns ErrNotFound without records", func(t *testing.T) {
+		_, err := c.FindByISBN(context.Background(), "1233211233215")
+		require.True(t, errors.Is(err, marc.ErrNotFound))
+	})
+}
@@ -0,0 +1,72 @@
+package marc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ErrNotFound is returned when a source has no record for an ISBN.
+var ErrNotFound = errors.New("no record found")
+
+// SRUClient retrieves MARCXML records from an SRU server, e.g. the Library
+// of Congress at http://lx2.loc.gov:210/LCDB.
+type SRUClient struct {
+	BaseURL    string
+	HTTPClient *http.Client // Defaults to http.DefaultClient
+	// ISBNIndex is the CQL index which is searched for ISBNs, it defaults to
+	// bath.isbn
+	ISBNIndex string
+}
+
+// NewSRUClient creates a client for the SRU server at baseURL.
+func NewSRUClient(baseURL string) *SRUClient {
+	return &SRUClient{BaseURL: baseURL, ISBNIndex: "bath.isbn"}
+}
+
+// FindByISBN retrieves the first record with the given ISBN.
+func (c *SRUClient) FindByISBN(ctx context.Context, isbn string) (Record, error) {
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return Record{}, fmt.Errorf("parse sru url err, %w", err)
+	}
+	index := c.ISBNIndex
+	if index == "" {
+		index = "bath.isbn"
+	}
+	q := u.Query()
+	q.Set("version", "1.1")
+	q.Set("operation", "searchRetrieve")
+	q.Set("query", fmt.Sprintf("%s=%q", index, isbn))
+	q.Set("recordSchema", "marcxml")
+	q.Set("maximumRecords", "1")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Record{}, fmt.Errorf("create sru request err, %w", err)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Record{}, fmt.Errorf("sru request err, %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Record{}, fmt.Errorf("sru request err, unexpected status %s", resp.Status)
+	}
+
+	records, err := ReadRecords(resp.Body)
+	if err != nil {
+		return Record{}, err
+	}
+	if len(records) == 0 {
+		return Record{}, ErrNotFound
+	}
+	return records[0], nil
+}
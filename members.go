@@ -0,0 +1,608 @@
+package library
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NicolaiMordrup/library/notifications"
+)
+
+// Member is a member who signed up to the library online.
+type Member struct {
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	FirstName     string    `json:"firstName"`
+	LastName      string    `json:"lastName"`
+	Phone         string    `json:"phone,omitempty"`
+	EmailVerified bool      `json:"emailVerified"`
+	Roles         []string  `json:"roles,omitempty"` // e.g. RoleLibrarian
+	CreateTime    time.Time `json:"createTime"`
+}
+
+// The roles of the members.
+const (
+	RoleLibrarian = "librarian"
+	RoleAdmin     = "admin"
+	RoleTrainee   = "trainee"   // Edits are proposed as revisions, see Revision
+	RoleModerator = "moderator" // Hides abusive reviews, see Review
+)
+
+// The kinds of member tokens.
+const (
+	TokenVerifyEmail    = "verify-email"
+	TokenPasswordReset  = "password-reset"
+	TokenSession        = "session"
+	TokenTOTPEnrollment = "totp-enrollment" // Logged in, but must enroll an authenticator first
+)
+
+// How long the member tokens are valid. The sessions are valid for the
+// absolute timeout of SessionTimeouts.
+var tokenLifetimes = map[string]time.Duration{
+	TokenVerifyEmail:    24 * time.Hour,
+	TokenPasswordReset:  time.Hour,
+	TokenTOTPEnrollment: 15 * time.Minute,
+}
+
+// errNoMember is returned when there is no member with a given email, id or
+// token.
+var errNoMember = errors.New("no such member")
+
+// namePattern is the pattern of the names of the authors, which is used for
+// the members too.
+var namePattern = firstNamePattern
+
+// phonePattern is the pattern of the phone numbers, e.g. +46 8 123 456.
+var phonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{4,18}[0-9]$`)
+
+func validateMember(m Member) error {
+	var fieldErrors []string
+	if addr, err := mail.ParseAddress(m.Email); err != nil || addr.Address != m.Email {
+		fieldErrors = append(fieldErrors, " email ")
+	}
+	if !namePattern.MatchString(m.FirstName) {
+		fieldErrors = append(fieldErrors, " firstname ")
+	}
+	if !namePattern.MatchString(m.LastName) {
+		fieldErrors = append(fieldErrors, " lastname ")
+	}
+	if m.Phone != "" && !phonePattern.MatchString(m.Phone) {
+		fieldErrors = append(fieldErrors, " phone ")
+	}
+	if len(fieldErrors) != 0 {
+		return fmt.Errorf("validation failed, field error(s):%v. Fix these error before proceeding",
+			strings.Join(fieldErrors, ", "))
+	}
+	return nil
+}
+
+// InsertMember stores a member with the given password hash. The email and
+// phone number are encrypted if the server has a key encryption key.
+func (s *Server) InsertMember(db Querier, m Member, passwordHash string) error {
+	emailHash, err := s.keys.emailHash(db, m.Email, true)
+	if err != nil {
+		return err
+	}
+	email, err := s.keys.sealField(db, m.Email)
+	if err != nil {
+		return err
+	}
+	phone, err := s.keys.sealField(db, m.Phone)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO member (id, email, emailHash, phone, firstName, lastName, passwordHash, emailVerified, createTime) VALUES(?,?,?,?,?,?,?,?,?)",
+		m.ID, email, emailHash, phone, m.FirstName, m.LastName, passwordHash, m.EmailVerified, m.CreateTime)
+	if err != nil {
+		return fmt.Errorf("insert member err, %w", err)
+	}
+	return nil
+}
+
+// findMember reads the member matching where and its password hash.
+func (s *Server) findMember(db Querier, where string, args ...interface{}) (Member, string, error) {
+	var m Member
+	var passwordHash string
+	err := db.QueryRow("SELECT id, email, phone, firstName, lastName, emailVerified, createTime, passwordHash FROM member WHERE "+where, args...).
+		Scan(&m.ID, &m.Email, &m.Phone, &m.FirstName, &m.LastName, &m.EmailVerified, &m.CreateTime, &passwordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Member{}, "", errNoMember
+	}
+	if err != nil {
+		return Member{}, "", fmt.Errorf("find member err, %w", err)
+	}
+	if m.Email, err = s.keys.openField(db, m.Email); err != nil {
+		return Member{}, "", err
+	}
+	if m.Phone, err = s.keys.openField(db, m.Phone); err != nil {
+		return Member{}, "", err
+	}
+	if m.Roles, err = readRoles(db, m.ID); err != nil {
+		return Member{}, "", err
+	}
+	return m, passwordHash, nil
+}
+
+// hasRole reports whether the member has the role.
+func hasRole(m Member, role string) bool {
+	for _, r := range m.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// readRoles reads the roles of a member sorted by name.
+func readRoles(db Querier, memberID string) ([]string, error) {
+	rows, err := db.Query("SELECT role FROM member_role WHERE memberId = ? ORDER BY role", memberID)
+	if err != nil {
+		return nil, fmt.Errorf("query roles err, %w", err)
+	}
+	defer rows.Close()
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("scan role err, %w", err)
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// setRoles replaces the roles of a member.
+func setRoles(db Querier, memberID string, roles []string) error {
+	if _, err := db.Exec("DELETE FROM member_role WHERE memberId = ?", memberID); err != nil {
+		return fmt.Errorf("delete roles err, %w", err)
+	}
+	for _, role := range roles {
+		if _, err := db.Exec("INSERT OR IGNORE INTO member_role (memberId, role) VALUES(?,?)", memberID, role); err != nil {
+			return fmt.Errorf("insert role err, %w", err)
+		}
+	}
+	return nil
+}
+
+// FindMember reads the member with the given id.
+func (s *Server) FindMember(db Querier, id string) (Member, error) {
+	m, _, err := s.findMember(db, "id = ?", id)
+	return m, err
+}
+
+// FindMemberByEmail reads the member with the given email, ignoring case.
+func (s *Server) FindMemberByEmail(db Querier, email string) (Member, error) {
+	m, _, err := s.findMemberByEmail(db, email)
+	return m, err
+}
+
+// hashToken returns the stored form of a token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns 256 random bits as an URL safe string.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token err, %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// issueToken creates a token of the given kind for the member, which is
+// valid for the given lifetime.
+func issueToken(db Querier, memberID, kind string, now time.Time, lifetime time.Duration) (string, time.Time, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expireTime := now.Add(lifetime)
+	_, err = db.Exec("INSERT INTO member_token (hash, memberId, kind, expireTime, lastUseTime) VALUES(?,?,?,?,?)",
+		hashToken(token), memberID, kind, expireTime.Unix(), now.Unix())
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("insert token err, %w", err)
+	}
+	return token, expireTime, nil
+}
+
+// findMemberByEmail reads the member with the given email, ignoring case,
+// and its password hash. The email is found by its hash, since it may be
+// encrypted.
+func (s *Server) findMemberByEmail(db Querier, email string) (Member, string, error) {
+	hashes, err := s.keys.emailHashes(db, email)
+	if err != nil {
+		return Member{}, "", err
+	}
+	return s.findMember(db, "emailHash IN (?"+strings.Repeat(",?", len(hashes)-1)+")", hashes...)
+}
+
+// tokenMember returns the member of an unexpired token of the given kind.
+func (s *Server) tokenMember(db Querier, token, kind string, now time.Time) (Member, error) {
+	m, _, err := s.findMember(db, "id = (SELECT memberId FROM member_token WHERE hash = ? AND kind = ? AND expireTime > ?)",
+		hashToken(token), kind, now.Unix())
+	return m, err
+}
+
+// revokeTokens deletes the tokens of the given kind of the member.
+func revokeTokens(db Querier, memberID, kind string) error {
+	if _, err := db.Exec("DELETE FROM member_token WHERE memberId = ? AND kind = ?", memberID, kind); err != nil {
+		return fmt.Errorf("delete tokens err, %w", err)
+	}
+	return nil
+}
+
+// revokeToken deletes a single token.
+func revokeToken(db Querier, token string) error {
+	if _, err := db.Exec("DELETE FROM member_token WHERE hash = ?", hashToken(token)); err != nil {
+		return fmt.Errorf("delete token err, %w", err)
+	}
+	return nil
+}
+
+// queueMemberEmail sends a token to the member by email.
+func (s *Server) queueMemberEmail(kind string, m Member, token string) error {
+	_, err := QueueNotification(s.db, notifications.NewQueue(s.db), kind, m.Email,
+		map[string]interface{}{"Member": m, "Token": token})
+	return err
+}
+
+// handleMemberErr writes the status of a statusError, and otherwise an
+// internal error with the given message.
+func handleMemberErr(w http.ResponseWriter, err error, message string) {
+	var se *statusError
+	if errors.As(err, &se) {
+		HandleErr(w, se.code, se.msg)
+		return
+	}
+	HandleErr(w, http.StatusInternalServerError, message)
+}
+
+// bearerToken returns the token of the Authorization header.
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(h[len(prefix):])
+}
+
+// signupRequest is the body of a signup.
+type signupRequest struct {
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Phone     string `json:"phone,omitempty"`
+}
+
+// Signup creates a member with a password. The member can log in once the
+// email address has been verified with the code which is sent to it.
+func (s *Server) Signup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req signupRequest
+	if err := decodeJSON(r, &req); err != nil {
+		handleDecodeErr(w, err, "Failed to decode signup")
+		return
+	}
+	m := Member{Email: strings.TrimSpace(req.Email), FirstName: req.FirstName, LastName: req.LastName,
+		Phone: strings.TrimSpace(req.Phone)}
+	if err := validateMember(m); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	if err := validatePassword(req.Password); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	passwordHash, err := hashPassword(req.Password)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the member")
+		return
+	}
+	m.ID = s.idGenerator.NewID()
+	m.CreateTime = time.Now()
+
+	var token string
+	var existing *Member
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if found, err := s.FindMemberByEmail(tx, m.Email); err == nil {
+			existing = &found
+			return nil
+		} else if !errors.Is(err, errNoMember) {
+			return err
+		}
+		if err := s.InsertMember(tx, m, passwordHash); err != nil {
+			return err
+		}
+		token, _, err = issueToken(tx, m.ID, TokenVerifyEmail, m.CreateTime, tokenLifetimes[TokenVerifyEmail])
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to store the member")
+		return
+	}
+	// A signup with the email of an existing member gets the same response
+	// as a new one, so that signups cannot be used to find out which
+	// addresses have accounts. The member is told by email instead.
+	if existing != nil {
+		err = s.queueMemberEmail(notifications.KindAccountExists, *existing, "")
+	} else {
+		err = s.queueMemberEmail(notifications.KindVerifyEmail, m, token)
+	}
+	if err != nil {
+		handleErr("failed to queue the signup email", err)
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(m); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the member")
+		return
+	}
+}
+
+// tokenRequest is the body of the requests which use an emailed token.
+type tokenRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password,omitempty"` // The new password of a reset
+}
+
+// VerifyEmail verifies the email address of a member with the code which
+// was sent to it at signup.
+func (s *Server) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req tokenRequest
+	if err := decodeJSON(r, &req); err != nil {
+		handleDecodeErr(w, err, "Failed to decode verification")
+		return
+	}
+	var m Member
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		var err error
+		if m, err = s.tokenMember(tx, req.Token, TokenVerifyEmail, time.Now()); errors.Is(err, errNoMember) {
+			return &statusError{http.StatusBadRequest, "The verification code is invalid or has expired"}
+		} else if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE member SET emailVerified = 1 WHERE id = ?", m.ID); err != nil {
+			return fmt.Errorf("verify member err, %w", err)
+		}
+		m.EmailVerified = true
+		return revokeTokens(tx, m.ID, TokenVerifyEmail)
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to verify the member")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(m); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the member")
+		return
+	}
+}
+
+// loginRequest is the body of a login.
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"` // The TOTP or recovery code, if enrolled
+}
+
+// Session is a logged in session of a member. The token is sent in the
+// Authorization header as a bearer token, or by browsers in the session
+// cookie which is set when logging in.
+// When TwoFactorEnrollmentRequired is set the token can only be used to
+// enroll an authenticator, see StartTOTPEnrollment.
+type Session struct {
+	Token                       string    `json:"token"`
+	ExpireTime                  time.Time `json:"expireTime"`
+	Member                      Member    `json:"member"`
+	TwoFactorEnrollmentRequired bool      `json:"twoFactorEnrollmentRequired,omitempty"`
+}
+
+// dummyPasswordHash is checked when there is no member with the email, so
+// that a login takes as long whether or not the member exists.
+const dummyPasswordHash = "pbkdf2-sha256$600000$Pje7Awul7VoLVFzbLj49Qw$p/oxnFBEBQSvieEqG/48RPe8RIuGffWalk3LAz/nggE"
+
+// Login checks the password, and the TOTP code if enrolled, of a member and
+// starts a session. Accounts and IP addresses with too many failed logins
+// are locked out for a while, see LockoutPolicy.
+func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req loginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		handleDecodeErr(w, err, "Failed to decode login")
+		return
+	}
+	email, ip, now := strings.TrimSpace(req.Email), clientIP(r), time.Now()
+	until, err := lockedUntil(s.db, email, ip, now)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the lockout")
+		return
+	}
+	if !until.IsZero() {
+		if err := s.inTx(r.Context(), func(tx *sql.Tx) error { return recordSecurityEvent(tx, SecurityLoginBlocked, email, ip, now) }); err != nil {
+			handleErr("failed to audit blocked login", err)
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(until.Sub(now).Seconds())+1))
+		HandleErr(w, http.StatusTooManyRequests, "Too many failed logins, try again later")
+		return
+	}
+	m, passwordHash, err := s.findMemberByEmail(s.db, email)
+	if errors.Is(err, errNoMember) {
+		passwordHash = dummyPasswordHash
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the member")
+		return
+	}
+	ok, checkErr := checkPassword(passwordHash, req.Password)
+	if checkErr != nil {
+		handleErr("failed to check password", checkErr)
+	}
+	if err != nil || !ok {
+		if err := s.inTx(r.Context(), func(tx *sql.Tx) error { return s.recordLoginFailure(tx, email, ip, now) }); err != nil {
+			handleErr("failed to record failed login", err)
+		}
+		HandleErr(w, http.StatusUnauthorized, "The email or password is incorrect")
+		return
+	}
+	if !m.EmailVerified {
+		HandleErr(w, http.StatusForbidden, "The email address has not been verified")
+		return
+	}
+
+	var session Session
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		enrolled, err := s.checkSecondFactor(tx, m, req.Code, ip, now)
+		if err != nil {
+			return err
+		}
+		if err := clearLoginFailures(tx, lockoutAccount, email); err != nil {
+			return err
+		}
+		if err := recordSecurityEvent(tx, SecurityLoginSucceeded, email, ip, now); err != nil {
+			return err
+		}
+		if !enrolled && s.twoFactorRequired(m) {
+			session = Session{Member: m, TwoFactorEnrollmentRequired: true}
+			session.Token, session.ExpireTime, err = issueToken(tx, m.ID, TokenTOTPEnrollment, now, tokenLifetimes[TokenTOTPEnrollment])
+			return err
+		}
+		session, err = s.startSession(tx, m)
+		return err
+	})
+	if errors.Is(err, errSecondFactorIncorrect) {
+		if err := s.inTx(r.Context(), func(tx *sql.Tx) error { return s.recordLoginFailure(tx, email, ip, now) }); err != nil {
+			handleErr("failed to record failed login", err)
+		}
+	}
+	if err != nil {
+		handleMemberErr(w, err, "Failed to start the session")
+		return
+	}
+	if !session.TwoFactorEnrollmentRequired {
+		s.writeSessionCookie(w, session)
+	}
+	if err := json.NewEncoder(w).Encode(session); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the session")
+		return
+	}
+}
+
+// Logout ends the session of the request.
+func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	token := s.sessionToken(r)
+	if token == "" {
+		HandleErr(w, http.StatusUnauthorized, "The request is not logged in")
+		return
+	}
+	if err := s.inTx(r.Context(), func(tx *sql.Tx) error { return revokeToken(tx, token) }); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to end the session")
+		return
+	}
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMe retrieves the member which is logged in.
+func (s *Server) GetMe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		HandleErr(w, http.StatusUnauthorized, "The request is not logged in")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the session")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(m); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the member")
+		return
+	}
+}
+
+// RequestPasswordReset sends a code to reset the password to the member
+// with the given email. The response is the same whether or not there is
+// such a member, so that it can not be used to find the members.
+func (s *Server) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := decodeJSON(r, &req); err != nil {
+		handleDecodeErr(w, err, "Failed to decode password reset")
+		return
+	}
+	var m Member
+	var token string
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		var err error
+		if m, err = s.FindMemberByEmail(tx, strings.TrimSpace(req.Email)); err != nil {
+			return err
+		}
+		token, _, err = issueToken(tx, m.ID, TokenPasswordReset, time.Now(), tokenLifetimes[TokenPasswordReset])
+		return err
+	})
+	if err == nil {
+		err = s.queueMemberEmail(notifications.KindPasswordReset, m, token)
+	}
+	if err != nil && !errors.Is(err, errNoMember) {
+		HandleErr(w, http.StatusInternalServerError, "Failed to send the password reset")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with the code of a password reset. The
+// sessions of the member are ended.
+func (s *Server) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req tokenRequest
+	if err := decodeJSON(r, &req); err != nil {
+		handleDecodeErr(w, err, "Failed to decode password reset")
+		return
+	}
+	if err := validatePassword(req.Password); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	passwordHash, err := hashPassword(req.Password)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the password")
+		return
+	}
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		m, err := s.tokenMember(tx, req.Token, TokenPasswordReset, time.Now())
+		if errors.Is(err, errNoMember) {
+			return &statusError{http.StatusBadRequest, "The password reset code is invalid or has expired"}
+		} else if err != nil {
+			return err
+		}
+		// The code was sent to the email address, which verifies it
+		if _, err := tx.Exec("UPDATE member SET passwordHash = ?, emailVerified = 1 WHERE id = ?", passwordHash, m.ID); err != nil {
+			return fmt.Errorf("update password err, %w", err)
+		}
+		if err := revokeTokens(tx, m.ID, TokenPasswordReset); err != nil {
+			return err
+		}
+		return revokeTokens(tx, m.ID, TokenSession)
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to store the password")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
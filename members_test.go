@@ -0,0 +1,123 @@
+package library
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/notifications"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// The test vector of RFC 7914, section 11
+	want, _ := hex.DecodeString("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783")
+	require.Equal(t, want, pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64))
+
+	hash, err := hashPassword("correct horse battery staple")
+	require.NoError(t, err)
+	ok, err := checkPassword(hash, "correct horse battery staple")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = checkPassword(hash, "correct horse battery stapler")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestMembers(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	server := NewServer(db)
+
+	serve := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		jsonBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(jsonBytes))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	tokenPattern := regexp.MustCompile(`[A-Za-z0-9_-]{43}`)
+	emailedToken := func(kind string) string {
+		t.Helper()
+		var body string
+		require.NoError(t, db.QueryRow("SELECT body FROM notification_delivery WHERE kind = ? ORDER BY id DESC LIMIT 1", kind).Scan(&body))
+		token := tokenPattern.FindString(body)
+		require.NotEmpty(t, token)
+		return token
+	}
+	login := func(password string) *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/api/v1/login", "", loginRequest{Email: "Astrid@example.com", Password: password})
+	}
+	signup := signupRequest{Email: "astrid@example.com", Password: "pippi longstocking", FirstName: "astrid", LastName: "lindgren"}
+
+	t.Run("Signs up members", func(t *testing.T) {
+		require.Equal(t, http.StatusCreated, serve(http.MethodPost, "/api/v1/signup", "", signup).Code)
+
+		again := signup
+		again.Email, again.Password = "ASTRID@example.com", "another password"
+		response := serve(http.MethodPost, "/api/v1/signup", "", again)
+		require.Equal(t, http.StatusCreated, response.Code)
+		var m Member
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&m))
+		require.Equal(t, again.Email, m.Email)
+		var to string
+		require.NoError(t, db.QueryRow("SELECT recipient FROM notification_delivery WHERE kind = ?", notifications.KindAccountExists).Scan(&to))
+		require.Equal(t, "astrid@example.com", to)
+
+		weak := signup
+		weak.Email, weak.Password = "karlsson@example.com", "short"
+		require.Equal(t, http.StatusNotAcceptable, serve(http.MethodPost, "/api/v1/signup", "", weak).Code)
+	})
+
+	t.Run("Requires a verified email to log in", func(t *testing.T) {
+		require.Equal(t, http.StatusForbidden, login(signup.Password).Code)
+		require.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/api/v1/signup:verify", "", tokenRequest{Token: "wrong"}).Code)
+		require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/signup:verify", "",
+			tokenRequest{Token: emailedToken(notifications.KindVerifyEmail)}).Code)
+	})
+
+	var session Session
+	t.Run("Logs in with the password", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, login("not the password").Code)
+		response := login(signup.Password)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&session))
+
+		response = serve(http.MethodGet, "/api/v1/me", session.Token, nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		var m Member
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&m))
+		require.Equal(t, "astrid@example.com", m.Email)
+		require.True(t, m.EmailVerified)
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/me", "", nil).Code)
+	})
+
+	t.Run("Resets the password", func(t *testing.T) {
+		require.Equal(t, http.StatusAccepted, serve(http.MethodPost, "/api/v1/password-reset", "",
+			map[string]string{"email": "nobody@example.com"}).Code)
+		require.Equal(t, http.StatusAccepted, serve(http.MethodPost, "/api/v1/password-reset", "",
+			map[string]string{"email": "astrid@example.com"}).Code)
+		require.Equal(t, http.StatusNoContent, serve(http.MethodPost, "/api/v1/password-reset:confirm", "",
+			tokenRequest{Token: emailedToken(notifications.KindPasswordReset), Password: "emil i lönneberga"}).Code)
+
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/me", session.Token, nil).Code,
+			"the sessions should end when the password is reset")
+		require.Equal(t, http.StatusUnauthorized, login(signup.Password).Code)
+		require.Equal(t, http.StatusOK, login("emil i lönneberga").Code)
+	})
+
+	t.Run("Logs out", func(t *testing.T) {
+		response := login("emil i lönneberga")
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&session))
+		require.Equal(t, http.StatusNoContent, serve(http.MethodPost, "/api/v1/logout", session.Token, nil).Code)
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/me", session.Token, nil).Code)
+	})
+}
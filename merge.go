@@ -0,0 +1,776 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// BookMerge is the audit record of a duplicate book which was merged into
+// another book. The ISBN of the merged book redirects to the target.
+type BookMerge struct {
+	ISBN       string    `json:"isbn"`
+	TargetISBN string    `json:"targetIsbn"`
+	MergedBy   string    `json:"mergedBy,omitempty"` // The member who merged, if logged in
+	MergeTime  time.Time `json:"mergeTime"`
+	// The number of records which were moved to the target
+	Reviews   int64 `json:"reviews"`
+	ListItems int64 `json:"listItems"`
+	Subjects  int64 `json:"subjects"`
+}
+
+// DuplicateReport is a book and the books which are likely its duplicates.
+type DuplicateReport struct {
+	Book       Book   `json:"book"`
+	Duplicates []Book `json:"duplicates"`
+}
+
+// FindMergeTarget returns the ISBN which a merged ISBN redirects to. It
+// returns sql.ErrNoRows if the ISBN was not merged.
+func FindMergeTarget(db Querier, isbn string) (string, error) {
+	var target string
+	err := db.QueryRow("SELECT targetIsbn FROM book_merge WHERE isbn = ?", isbn).Scan(&target)
+	return target, err
+}
+
+// errMergeSelf is returned when a book is merged into itself.
+var errMergeSelf = &statusError{http.StatusBadRequest, "A book can not be merged into itself"}
+
+// errMergeFilesChanged is returned when the files of a book change while it
+// is merged, after they were copied to the target.
+var errMergeFilesChanged = &statusError{http.StatusConflict, "The files of the book changed during the merge, try again"}
+
+// mergedFiles are the files of a merged book outside of the database, which
+// are copied to the target before the merge, see copyMergedFiles.
+type mergedFiles struct {
+	isbn, target string
+	attachments  map[string]bool   // The ids of the copied attachments
+	cover        bool              // Whether the cover was copied
+	ebook        bool              // Whether the e-book file was copied
+	blobs        map[string]string // The keys of the original blobs by the keys of the copies
+}
+
+// copyMergedFiles copies the files of a book which is merged into the
+// target: the blobs of its attachments, its cover unless the target has a
+// cover of its own and its e-book file unless the target has an e-book. The
+// files are copied before the transaction of the merge, since the blob
+// store can not take part in it.
+func (s *Server) copyMergedFiles(ctx context.Context, isbn, target string) (mergedFiles, error) {
+	files := mergedFiles{isbn: isbn, target: target, attachments: make(map[string]bool), blobs: make(map[string]string)}
+	copyBlob := func(from, to string) error {
+		rc, err := s.blobStore.Get(ctx, from)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if _, err := s.blobStore.Put(ctx, to, rc); err != nil {
+			return err
+		}
+		files.blobs[to] = from
+		return nil
+	}
+	if s.blobStore != nil {
+		attachments, err := readAttachments(s.db, "isbn = ?", isbn)
+		if err != nil {
+			return mergedFiles{}, err
+		}
+		for _, a := range attachments {
+			copied := a
+			copied.ISBN = target
+			if err := copyBlob(s.attachmentKey(a), s.attachmentKey(copied)); err != nil {
+				s.removeMergedFiles(ctx, files)
+				return mergedFiles{}, fmt.Errorf("copy attachment err, %w", err)
+			}
+			files.attachments[a.ID] = true
+		}
+		if _, err := FindCover(s.db, isbn); err == nil {
+			if _, err := FindCover(s.db, target); errors.Is(err, sql.ErrNoRows) {
+				for _, size := range coverSizes {
+					if err := copyBlob(s.coverKey(isbn, size.name), s.coverKey(target, size.name)); err != nil {
+						s.removeMergedFiles(ctx, files)
+						return mergedFiles{}, fmt.Errorf("copy cover err, %w", err)
+					}
+				}
+				files.cover = true
+			}
+		}
+	}
+	if e, err := FindEbook(s.db, isbn, time.Now()); err == nil && e.HasFile {
+		if _, err := FindEbook(s.db, target, time.Now()); errors.Is(err, sql.ErrNoRows) {
+			if err := copyFile(s.ebookPath(isbn), s.ebookPath(target)); err != nil {
+				s.removeMergedFiles(ctx, files)
+				return mergedFiles{}, fmt.Errorf("copy ebook file err, %w", err)
+			}
+			files.ebook = true
+		}
+	}
+	return files, nil
+}
+
+// removeMergedFiles removes the copies of the files of a merge which
+// failed. The originals of a merge which succeeded are kept until it can no
+// longer be undone, see removeMergeOriginals.
+func (s *Server) removeMergedFiles(ctx context.Context, files mergedFiles) {
+	var keys, paths []string
+	for to := range files.blobs {
+		keys = append(keys, to)
+	}
+	if files.ebook {
+		paths = append(paths, s.ebookPath(files.target))
+	}
+	s.removeFiles(ctx, keys, paths)
+}
+
+// removeFiles removes blobs and e-book files of merges, the failures are
+// only logged.
+func (s *Server) removeFiles(ctx context.Context, keys, paths []string) {
+	for _, key := range keys {
+		if err := s.blobStore.Delete(ctx, key); err != nil {
+			handleErr("Failed to delete the blob of a merged book", err)
+		}
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			handleErr("Failed to delete the e-book file of a merged book", err)
+		}
+	}
+}
+
+// copyFile copies the file from to the path to, which is replaced once the
+// copy is complete.
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.CreateTemp(filepath.Dir(to), filepath.Base(to)+".*.copy")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(dst.Name(), to)
+}
+
+// mergeBook moves the reviews, the reading list items, the subjects, the
+// attachments, the cover and the e-book with its ended loans of a book to
+// the target and deletes the book. The target keeps its own review when a
+// member reviewed both books, and its own cover and e-book. A book can not
+// be merged while it has e-book loans which have not ended. The files of the
+// book must have been copied to the target, see copyMergedFiles.
+func (s *Server) mergeBook(q Querier, m BookMerge, files mergedFiles) (BookMerge, error) {
+	if m.ISBN == m.TargetISBN {
+		return m, errMergeSelf
+	}
+	if FindSpecificBook(q, m.TargetISBN).ISBN == "" {
+		return m, &statusError{http.StatusNotFound, "The target book did not exist in the library"}
+	}
+	if FindSpecificBook(q, m.ISBN).ISBN == "" {
+		return m, &statusError{http.StatusNotFound, "The book did not exist in the library"}
+	}
+	if err := mergeBookFiles(q, m, files); err != nil {
+		return m, err
+	}
+	if _, err := q.Exec("DELETE FROM review WHERE isbn = ? AND memberId IN (SELECT memberId FROM review WHERE isbn = ?)",
+		m.ISBN, m.TargetISBN); err != nil {
+		return m, fmt.Errorf("merge reviews err, %w", err)
+	}
+	moves := []struct {
+		table string
+		n     *int64
+	}{
+		{"review", &m.Reviews},
+		{"reading_list_item", &m.ListItems},
+		{"book_subject", &m.Subjects},
+	}
+	for _, move := range moves {
+		// The rows which the target already has are left behind and deleted
+		res, err := q.Exec("UPDATE OR IGNORE "+move.table+" SET isbn = ? WHERE isbn = ?", m.TargetISBN, m.ISBN)
+		if err != nil {
+			return m, fmt.Errorf("merge %s err, %w", move.table, err)
+		}
+		if *move.n, err = res.RowsAffected(); err != nil {
+			return m, fmt.Errorf("merge %s err, %w", move.table, err)
+		}
+		if _, err := q.Exec("DELETE FROM "+move.table+" WHERE isbn = ?", m.ISBN); err != nil {
+			return m, fmt.Errorf("merge %s err, %w", move.table, err)
+		}
+	}
+	if _, err := s.deleteBook(q, m.ISBN); err != nil {
+		return m, err
+	}
+	// The books which were merged into this book redirect to the target too
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE book_merge SET targetIsbn = ? WHERE targetIsbn = ? AND isbn <> ?", []interface{}{m.TargetISBN, m.ISBN, m.TargetISBN}},
+		// A recreated book which is merged again replaces its earlier merge
+		{"INSERT OR REPLACE INTO book_merge (isbn, targetIsbn, mergedBy, mergeTime, reviews, listItems, subjects) VALUES(?,?,?,?,?,?,?)",
+			[]interface{}{m.ISBN, m.TargetISBN, m.MergedBy, m.MergeTime.Unix(), m.Reviews, m.ListItems, m.Subjects}},
+	}
+	for _, st := range statements {
+		if _, err := q.Exec(st.query, st.args...); err != nil {
+			return m, fmt.Errorf("record merge err, %w", err)
+		}
+	}
+	return m, nil
+}
+
+// mergeBookFiles moves the e-book and its loans, the attachments and the
+// cover of a merged book to the target, see mergeBook.
+func mergeBookFiles(q Querier, m BookMerge, files mergedFiles) error {
+	var active int
+	err := q.QueryRow("SELECT COUNT(*) FROM digital_loan WHERE isbn = ? AND endTime > ?", m.ISBN, m.MergeTime.Unix()).Scan(&active)
+	if err != nil {
+		return fmt.Errorf("read digital loans err, %w", err)
+	}
+	if active > 0 {
+		return &statusError{http.StatusConflict, "The book can not be merged while its e-book is lent out"}
+	}
+
+	var statements []string
+	if e, err := FindEbook(q, m.ISBN, m.MergeTime); err == nil {
+		if _, err := FindEbook(q, m.TargetISBN, m.MergeTime); errors.Is(err, sql.ErrNoRows) {
+			if e.HasFile && !files.ebook {
+				return errMergeFilesChanged
+			}
+			statements = append(statements, "UPDATE ebook SET isbn = ? WHERE isbn = ?")
+		} else if err != nil {
+			return fmt.Errorf("read ebook err, %w", err)
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("read ebook err, %w", err)
+	}
+	statements = append(statements, "UPDATE digital_loan SET isbn = ? WHERE isbn = ?")
+
+	attachments, err := readAttachments(q, "isbn = ?", m.ISBN)
+	if err != nil {
+		return err
+	}
+	for _, a := range attachments {
+		if !files.attachments[a.ID] {
+			return errMergeFilesChanged
+		}
+	}
+	statements = append(statements, "UPDATE attachment SET isbn = ? WHERE isbn = ?")
+
+	if _, err := FindCover(q, m.ISBN); err == nil {
+		if _, err := FindCover(q, m.TargetISBN); errors.Is(err, sql.ErrNoRows) {
+			if !files.cover {
+				return errMergeFilesChanged
+			}
+			statements = append(statements, "UPDATE cover SET isbn = ? WHERE isbn = ?")
+		} else if err != nil {
+			return fmt.Errorf("read cover err, %w", err)
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("read cover err, %w", err)
+	}
+
+	for _, st := range statements {
+		if _, err := q.Exec(st, m.TargetISBN, m.ISBN); err != nil {
+			return fmt.Errorf("merge files err, %w", err)
+		}
+	}
+	// The e-book and cover which the target already had are kept
+	for _, table := range []string{"ebook", "cover"} {
+		if _, err := q.Exec("DELETE FROM "+table+" WHERE isbn = ?", m.ISBN); err != nil {
+			return fmt.Errorf("merge %s err, %w", table, err)
+		}
+	}
+	return nil
+}
+
+// mergeChanges records what a merge changed besides deleting the merged
+// book, so that the merge can be undone, see unmergeBook. It is journaled
+// with the change of the merged book.
+type mergeChanges struct {
+	Target string `json:"target"`
+	// The rows which were moved to the target
+	Reviews     []string `json:"reviews,omitempty"`    // By id
+	ListIDs     []string `json:"listIds,omitempty"`    // The lists of the moved items
+	SubjectIDs  []string `json:"subjectIds,omitempty"` // The moved subjects
+	Attachments []string `json:"attachments,omitempty"`
+	Loans       []string `json:"loans,omitempty"`
+	// The rows which were left behind and deleted since the target had its own
+	DeletedReviews    []Review             `json:"deletedReviews,omitempty"`
+	DeletedListItems  map[string]time.Time `json:"deletedListItems,omitempty"` // The add times by list id
+	DeletedSubjectIDs []string             `json:"deletedSubjectIds,omitempty"`
+	// The e-book and cover of the book, which were moved unless the target
+	// had its own
+	Ebook      *mergedEbook `json:"ebook,omitempty"`
+	EbookMoved bool         `json:"ebookMoved,omitempty"`
+	Cover      *Cover       `json:"cover,omitempty"`
+	CoverMoved bool         `json:"coverMoved,omitempty"`
+	// The books which redirected to the book, and the earlier merge of the
+	// book which the merge replaced
+	Redirects     []string   `json:"redirects,omitempty"`
+	PreviousMerge *BookMerge `json:"previousMerge,omitempty"`
+}
+
+// mergedEbook is the row of the e-book of a merged book.
+type mergedEbook struct {
+	URL      string `json:"url,omitempty"`
+	FileType string `json:"fileType,omitempty"`
+	Licenses int    `json:"licenses"`
+	LoanDays int    `json:"loanDays"`
+}
+
+// readMergeChanges reads what merging the book into the target will change,
+// see mergeBook.
+func readMergeChanges(q Querier, isbn, target string) (mergeChanges, error) {
+	c := mergeChanges{Target: target, DeletedListItems: make(map[string]time.Time)}
+	reviews, err := readReviews(q, "isbn = ?", []interface{}{isbn}, 0, -1)
+	if err != nil {
+		return c, err
+	}
+	for _, r := range reviews {
+		var exists bool
+		err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM review WHERE isbn = ? AND memberId = ?)", target, r.MemberID).Scan(&exists)
+		if err != nil {
+			return c, fmt.Errorf("read reviews err, %w", err)
+		}
+		if exists {
+			c.DeletedReviews = append(c.DeletedReviews, r)
+		} else {
+			c.Reviews = append(c.Reviews, r.ID)
+		}
+	}
+
+	rows, err := q.Query(`SELECT listId, addTime, EXISTS(SELECT 1 FROM reading_list_item t WHERE t.listId = i.listId AND t.isbn = ?)
+		FROM reading_list_item i WHERE isbn = ?`, target, isbn)
+	if err != nil {
+		return c, fmt.Errorf("read list items err, %w", err)
+	}
+	for rows.Next() {
+		var listID string
+		var addTime time.Time
+		var exists bool
+		if err := rows.Scan(&listID, &addTime, &exists); err != nil {
+			rows.Close()
+			return c, fmt.Errorf("read list items err, %w", err)
+		}
+		if exists {
+			c.DeletedListItems[listID] = addTime
+		} else {
+			c.ListIDs = append(c.ListIDs, listID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c, fmt.Errorf("read list items err, %w", err)
+	}
+
+	rows, err = q.Query(`SELECT subjectId, EXISTS(SELECT 1 FROM book_subject t WHERE t.subjectId = s.subjectId AND t.isbn = ?)
+		FROM book_subject s WHERE isbn = ?`, target, isbn)
+	if err != nil {
+		return c, fmt.Errorf("read subjects err, %w", err)
+	}
+	for rows.Next() {
+		var subjectID string
+		var exists bool
+		if err := rows.Scan(&subjectID, &exists); err != nil {
+			rows.Close()
+			return c, fmt.Errorf("read subjects err, %w", err)
+		}
+		if exists {
+			c.DeletedSubjectIDs = append(c.DeletedSubjectIDs, subjectID)
+		} else {
+			c.SubjectIDs = append(c.SubjectIDs, subjectID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c, fmt.Errorf("read subjects err, %w", err)
+	}
+
+	attachments, err := readAttachments(q, "isbn = ?", isbn)
+	if err != nil {
+		return c, err
+	}
+	for _, a := range attachments {
+		c.Attachments = append(c.Attachments, a.ID)
+	}
+	loans, err := readDigitalLoans(q, "isbn = ?", isbn)
+	if err != nil {
+		return c, err
+	}
+	for _, l := range loans {
+		c.Loans = append(c.Loans, l.ID)
+	}
+
+	now := time.Now()
+	if e, err := FindEbook(q, isbn, now); err == nil {
+		c.Ebook = &mergedEbook{URL: e.URL, FileType: e.fileType, Licenses: e.Licenses, LoanDays: e.LoanDays}
+		if _, err := FindEbook(q, target, now); errors.Is(err, sql.ErrNoRows) {
+			c.EbookMoved = true
+		} else if err != nil {
+			return c, fmt.Errorf("read ebook err, %w", err)
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return c, fmt.Errorf("read ebook err, %w", err)
+	}
+	if cover, err := FindCover(q, isbn); err == nil {
+		c.Cover = &cover
+		if _, err := FindCover(q, target); errors.Is(err, sql.ErrNoRows) {
+			c.CoverMoved = true
+		} else if err != nil {
+			return c, fmt.Errorf("read cover err, %w", err)
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return c, fmt.Errorf("read cover err, %w", err)
+	}
+
+	rows, err = q.Query("SELECT isbn FROM book_merge WHERE targetIsbn = ? AND isbn <> ?", isbn, target)
+	if err != nil {
+		return c, fmt.Errorf("read merges err, %w", err)
+	}
+	for rows.Next() {
+		var redirect string
+		if err := rows.Scan(&redirect); err != nil {
+			rows.Close()
+			return c, fmt.Errorf("read merges err, %w", err)
+		}
+		c.Redirects = append(c.Redirects, redirect)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c, fmt.Errorf("read merges err, %w", err)
+	}
+	previous, err := findBookMerge(q, isbn)
+	if err == nil {
+		c.PreviousMerge = &previous
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return c, fmt.Errorf("read merge err, %w", err)
+	}
+	return c, nil
+}
+
+// findBookMerge reads the merge of a book, sql.ErrNoRows is returned if it
+// was not merged.
+func findBookMerge(q Querier, isbn string) (BookMerge, error) {
+	m, err := scanBookMerge(q.QueryRow("SELECT isbn, targetIsbn, mergedBy, mergeTime, reviews, listItems, subjects FROM book_merge WHERE isbn = ?", isbn))
+	return m, err
+}
+
+func scanBookMerge(row interface{ Scan(...interface{}) error }) (BookMerge, error) {
+	var m BookMerge
+	var mergeTime int64
+	if err := row.Scan(&m.ISBN, &m.TargetISBN, &m.MergedBy, &mergeTime, &m.Reviews, &m.ListItems, &m.Subjects); err != nil {
+		return BookMerge{}, err
+	}
+	m.MergeTime = time.Unix(mergeTime, 0).UTC()
+	return m, nil
+}
+
+// errUnmergeChanged is returned when the records which a merge moved to the
+// target have changed since, so that the merge can no longer be undone.
+var errUnmergeChanged = &statusError{http.StatusConflict, "The records which the merge moved have been changed since the merge"}
+
+// unmergeBook moves the records which a merge moved to the target back to
+// the merged book, and restores the records of the merged book which the
+// merge deleted. The merged book itself must have been restored. The files
+// of the merged book are kept until the merge can no longer be undone, see
+// removeMergeOriginals.
+func unmergeBook(q Querier, isbn string, c mergeChanges, now time.Time) error {
+	if FindSpecificBook(q, c.Target).ISBN == "" {
+		return errUnmergeChanged
+	}
+	for _, id := range c.Attachments {
+		if _, err := FindAttachment(q, c.Target, id); errors.Is(err, sql.ErrNoRows) {
+			return errUnmergeChanged
+		} else if err != nil {
+			return err
+		}
+	}
+	if c.EbookMoved {
+		if _, err := FindEbook(q, c.Target, now); errors.Is(err, sql.ErrNoRows) {
+			return errUnmergeChanged
+		} else if err != nil {
+			return fmt.Errorf("read ebook err, %w", err)
+		}
+		var active int
+		err := q.QueryRow("SELECT COUNT(*) FROM digital_loan WHERE isbn = ? AND endTime > ?", c.Target, now.Unix()).Scan(&active)
+		if err != nil {
+			return fmt.Errorf("read digital loans err, %w", err)
+		}
+		if active > 0 {
+			return &statusError{http.StatusConflict, "The merge can not be undone while the e-book is lent out"}
+		}
+	}
+	if c.CoverMoved {
+		cover, err := FindCover(q, c.Target)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !cover.UpdateTime.Equal(c.Cover.UpdateTime)) {
+			return errUnmergeChanged
+		} else if err != nil {
+			return fmt.Errorf("read cover err, %w", err)
+		}
+	}
+
+	type statement struct {
+		query string
+		args  []interface{}
+	}
+	var statements []statement
+	for _, id := range c.Reviews {
+		statements = append(statements, statement{"UPDATE OR IGNORE review SET isbn = ? WHERE id = ? AND isbn = ?", []interface{}{isbn, id, c.Target}})
+	}
+	for _, r := range c.DeletedReviews {
+		statements = append(statements, statement{"INSERT OR IGNORE INTO review (id, isbn, memberId, rating, text, createTime, hidden) VALUES(?,?,?,?,?,?,?)",
+			[]interface{}{r.ID, isbn, r.MemberID, r.Rating, r.Text, r.CreateTime, r.Hidden}})
+	}
+	for _, listID := range c.ListIDs {
+		statements = append(statements, statement{"UPDATE OR IGNORE reading_list_item SET isbn = ? WHERE listId = ? AND isbn = ?", []interface{}{isbn, listID, c.Target}})
+	}
+	for listID, addTime := range c.DeletedListItems {
+		statements = append(statements, statement{"INSERT OR IGNORE INTO reading_list_item (listId, isbn, addTime) VALUES(?,?,?)", []interface{}{listID, isbn, addTime}})
+	}
+	for _, subjectID := range c.SubjectIDs {
+		statements = append(statements, statement{"UPDATE OR IGNORE book_subject SET isbn = ? WHERE subjectId = ? AND isbn = ?", []interface{}{isbn, subjectID, c.Target}})
+	}
+	for _, subjectID := range c.DeletedSubjectIDs {
+		statements = append(statements, statement{"INSERT OR IGNORE INTO book_subject (isbn, subjectId) VALUES(?,?)", []interface{}{isbn, subjectID}})
+	}
+	for _, id := range c.Attachments {
+		statements = append(statements, statement{"UPDATE attachment SET isbn = ? WHERE id = ?", []interface{}{isbn, id}})
+	}
+	for _, id := range c.Loans {
+		statements = append(statements, statement{"UPDATE digital_loan SET isbn = ? WHERE id = ? AND isbn = ?", []interface{}{isbn, id, c.Target}})
+	}
+	switch {
+	case c.EbookMoved:
+		statements = append(statements, statement{"UPDATE ebook SET isbn = ? WHERE isbn = ?", []interface{}{isbn, c.Target}})
+	case c.Ebook != nil:
+		statements = append(statements, statement{"INSERT INTO ebook (isbn, url, fileType, licenses, loanDays) VALUES(?,?,?,?,?)",
+			[]interface{}{isbn, c.Ebook.URL, c.Ebook.FileType, c.Ebook.Licenses, c.Ebook.LoanDays}})
+	}
+	switch {
+	case c.CoverMoved:
+		statements = append(statements, statement{"UPDATE cover SET isbn = ? WHERE isbn = ?", []interface{}{isbn, c.Target}})
+	case c.Cover != nil:
+		statements = append(statements, statement{"INSERT INTO cover (isbn, width, height, updateTime) VALUES(?,?,?,?)",
+			[]interface{}{isbn, c.Cover.Width, c.Cover.Height, c.Cover.UpdateTime}})
+	}
+	statements = append(statements, statement{"DELETE FROM book_merge WHERE isbn = ?", []interface{}{isbn}})
+	if m := c.PreviousMerge; m != nil {
+		statements = append(statements, statement{"INSERT INTO book_merge (isbn, targetIsbn, mergedBy, mergeTime, reviews, listItems, subjects) VALUES(?,?,?,?,?,?,?)",
+			[]interface{}{m.ISBN, m.TargetISBN, m.MergedBy, m.MergeTime.Unix(), m.Reviews, m.ListItems, m.Subjects}})
+	}
+	for _, redirect := range c.Redirects {
+		statements = append(statements, statement{"UPDATE book_merge SET targetIsbn = ? WHERE isbn = ? AND targetIsbn = ?", []interface{}{isbn, redirect, c.Target}})
+	}
+	for _, st := range statements {
+		if _, err := q.Exec(st.query, st.args...); err != nil {
+			return fmt.Errorf("undo merge err, %w", err)
+		}
+	}
+	return nil
+}
+
+// removeMergeCopies removes the copies of the files which an undone merge
+// made for the target, see copyMergedFiles.
+func (s *Server) removeMergeCopies(ctx context.Context, c mergeChanges) {
+	var keys, paths []string
+	if s.blobStore != nil {
+		for _, id := range c.Attachments {
+			keys = append(keys, s.attachmentKey(Attachment{ISBN: c.Target, ID: id}))
+		}
+		if c.CoverMoved {
+			for _, size := range coverSizes {
+				keys = append(keys, s.coverKey(c.Target, size.name))
+			}
+		}
+	}
+	if c.EbookMoved && c.Ebook.FileType != "" && s.ebookDir != "" {
+		paths = append(paths, s.ebookPath(c.Target))
+	}
+	s.removeFiles(ctx, keys, paths)
+}
+
+// removeMergeOriginals removes the original files of a merged book once the
+// merge can no longer be undone. The cover and the e-book file are kept if
+// the ISBN has been used by a new book since, whose files they now are.
+func (s *Server) removeMergeOriginals(ctx context.Context, q Querier, isbn string, c mergeChanges) error {
+	var keys, paths []string
+	if s.blobStore != nil {
+		for _, id := range c.Attachments {
+			keys = append(keys, s.attachmentKey(Attachment{ISBN: isbn, ID: id}))
+		}
+		if c.Cover != nil {
+			if _, err := FindCover(q, isbn); errors.Is(err, sql.ErrNoRows) {
+				for _, size := range coverSizes {
+					keys = append(keys, s.coverKey(isbn, size.name))
+				}
+			} else if err != nil {
+				return fmt.Errorf("read cover err, %w", err)
+			}
+		}
+	}
+	if c.Ebook != nil && c.Ebook.FileType != "" && s.ebookDir != "" {
+		if _, err := FindEbook(q, isbn, time.Now()); errors.Is(err, sql.ErrNoRows) {
+			paths = append(paths, s.ebookPath(isbn))
+		} else if err != nil {
+			return fmt.Errorf("read ebook err, %w", err)
+		}
+	}
+	s.removeFiles(ctx, keys, paths)
+	return nil
+}
+
+// MergeBook merges a duplicate book into the target book, see mergeBook.
+// The merge is rejected while another member holds the lock of either book,
+// and can be undone through the operation in the X-Operation-ID header.
+func (s *Server) MergeBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	m := BookMerge{ISBN: vars["isbn"], TargetISBN: vars["target"], MergeTime: time.Unix(time.Now().Unix(), 0).UTC()}
+	member, err := s.editor(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if isTrainee(member) {
+		handleMemberErr(w, errTraineeEdit, "Failed to merge the book")
+		return
+	}
+	m.MergedBy = member.ID
+	if m.ISBN == m.TargetISBN {
+		handleMemberErr(w, errMergeSelf, "Failed to merge the book")
+		return
+	}
+	files, err := s.copyMergedFiles(r.Context(), m.ISBN, m.TargetISBN)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to copy the files of the book")
+		return
+	}
+	var operationID string
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		for _, isbn := range []string{m.ISBN, m.TargetISBN} {
+			if err := checkBookLock(tx, isbn, m.MergedBy, m.MergeTime); err != nil {
+				return err
+			}
+		}
+		j := &journal{q: tx}
+		before := FindSpecificBook(tx, m.ISBN)
+		changes, err := readMergeChanges(tx, m.ISBN, m.TargetISBN)
+		if err != nil {
+			return err
+		}
+		if m, err = s.mergeBook(tx, m, files); err != nil {
+			return err
+		}
+		j.recordMerge(m.ISBN, before, changes)
+		operationID, err = s.journalOperation(tx, OperationMerge, j)
+		return err
+	})
+	if err != nil {
+		s.removeMergedFiles(r.Context(), files)
+		handleLockErr(w, err, "Failed to merge the book")
+		return
+	}
+	w.Header().Set(operationIDHeader, operationID)
+	if err := json.NewEncoder(w).Encode(m); err != nil {
+		handleErr("Failed to encode merge", err)
+		return
+	}
+}
+
+// redirectMergedBook redirects a request of a merged book to its target,
+// and reports whether it did.
+func (s *Server) redirectMergedBook(w http.ResponseWriter, r *http.Request, isbn string) bool {
+	target, err := FindMergeTarget(s.db, isbn)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			handleErr("Failed to read the merge of the book", err)
+		}
+		return false
+	}
+	u := *r.URL
+	u.Path = strings.TrimSuffix(u.Path, isbn) + target
+	http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+	return true
+}
+
+// ListBookMerges lists the audit records of the merged books, the latest
+// first. Only librarians and admins can list them.
+func (s *Server) ListBookMerges(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.librarianMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	rows, err := s.db.Query("SELECT isbn, targetIsbn, mergedBy, mergeTime, reviews, listItems, subjects FROM book_merge ORDER BY mergeTime DESC, isbn")
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the merges")
+		return
+	}
+	defer rows.Close()
+	merges := []BookMerge{}
+	for rows.Next() {
+		m, err := scanBookMerge(rows)
+		if err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the merges")
+			return
+		}
+		merges = append(merges, m)
+	}
+	if err := rows.Err(); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the merges")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(merges); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the merges")
+		return
+	}
+}
+
+// GetDuplicateReport lists the books which are likely duplicates of other
+// books, see FindDuplicates. Each pair of duplicates is listed once, under
+// the book with the lowest ISBN. Only librarians and admins can read the
+// report, since it lists the drafts too.
+func (s *Server) GetDuplicateReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.librarianMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	reports := []DuplicateReport{}
+	for _, b := range ReadDatabaseList(s.db) {
+		duplicates, err := FindDuplicates(s.db, b)
+		if err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to find the duplicates")
+			return
+		}
+		report := DuplicateReport{Book: b}
+		for _, d := range duplicates {
+			if d.ISBN > b.ISBN {
+				report.Duplicates = append(report.Duplicates, d)
+			}
+		}
+		if len(report.Duplicates) > 0 {
+			reports = append(reports, report)
+		}
+	}
+	if err := json.NewEncoder(w).Encode(reports); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the duplicates")
+		return
+	}
+}
@@ -0,0 +1,303 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/NicolaiMordrup/library/blobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeBooks(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	lindgren := &Author{FirstName: "Astrid", LastName: "Lindgren"}
+	original, duplicate, other := "9789129688313", "9789129688320", "9789129657470"
+	for _, b := range []Book{
+		{ISBN: original, Title: "Pippi Langstrump", Author: lindgren, Publisher: "raben"},
+		{ISBN: duplicate, Title: "Pippi Långstrump", Author: lindgren, Publisher: "raben"},
+		{ISBN: other, Title: "Bröderna Lejonhjärta", Author: lindgren, Publisher: "raben"},
+	} {
+		jsonBytes, _ := json.Marshal(b)
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+b.ISBN+"?force=true", jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	}
+	sessions := map[string]string{
+		"member-1": createMemberSession(t, db, "member-1"),
+		"member-2": createMemberSession(t, db, "member-2"),
+	}
+	librarian := createMemberSession(t, db, "astrid", RoleLibrarian)
+	for _, r := range []struct {
+		isbn   string
+		review Review
+	}{
+		{original, Review{MemberID: "member-1", Rating: 5}},
+		{duplicate, Review{MemberID: "member-1", Rating: 1}},
+		{duplicate, Review{MemberID: "member-2", Rating: 4}},
+	} {
+		jsonBytes, _ := json.Marshal(r.review)
+		response := createNewMemberRequest(http.MethodPost, "/api/v1/books/"+r.isbn+"/reviews", jsonBytes, db, sessions[r.review.MemberID])
+		require.Equal(t, http.StatusCreated, response.Code, response.Body.String())
+	}
+	lists := "/api/v1/members/member-1/lists"
+	jsonBytes, _ := json.Marshal(ReadingList{Kind: ListWantToRead})
+	response := createNewMemberRequest(http.MethodPost, lists, jsonBytes, db, sessions["member-1"])
+	require.Equal(t, http.StatusCreated, response.Code)
+	var list ReadingList
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&list))
+	response = createNewMemberRequest(http.MethodPut, lists+"/"+list.ID+"/books/"+duplicate, nil, db, sessions["member-1"])
+	require.Equal(t, http.StatusNoContent, response.Code)
+
+	t.Run("Lets only librarians and admins read the merges", func(t *testing.T) {
+		for _, path := range []string{"/api/v1/books:duplicates", "/api/v1/admin/merges"} {
+			require.Equal(t, http.StatusUnauthorized, createNewRequest(http.MethodGet, path, nil, db).Code, path)
+			response := createNewMemberRequest(http.MethodGet, path, nil, db, sessions["member-1"])
+			require.Equal(t, http.StatusForbidden, response.Code, path)
+		}
+	})
+
+	t.Run("Reports the likely duplicates", func(t *testing.T) {
+		response := createNewMemberRequest(http.MethodGet, "/api/v1/books:duplicates", nil, db, librarian)
+		require.Equal(t, http.StatusOK, response.Code)
+		var reports []DuplicateReport
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&reports))
+		require.Len(t, reports, 1)
+		require.Equal(t, original, reports[0].Book.ISBN)
+		require.Len(t, reports[0].Duplicates, 1)
+		require.Equal(t, duplicate, reports[0].Duplicates[0].ISBN)
+	})
+
+	t.Run("Rejects invalid merges", func(t *testing.T) {
+		for path, status := range map[string]int{
+			"/api/v1/books/" + duplicate + ":merge_into/" + duplicate:  http.StatusBadRequest,
+			"/api/v1/books/" + duplicate + ":merge_into/1233211233215": http.StatusNotFound,
+			"/api/v1/books/1233211233215:merge_into/" + original:       http.StatusNotFound,
+		} {
+			response := createNewRequest(http.MethodPost, path, nil, db)
+			require.Equal(t, status, response.Code, path)
+		}
+	})
+
+	t.Run("Moves the records of the duplicate to the target", func(t *testing.T) {
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+duplicate+":merge_into/"+original, nil, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		var merge BookMerge
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&merge))
+		require.Equal(t, int64(1), merge.Reviews)
+		require.Equal(t, int64(1), merge.ListItems)
+
+		reviews, _, err := ReadReviews(db, original, 0, defaultReviewPageSize)
+		require.NoError(t, err)
+		require.Len(t, reviews, 2)
+		for _, r := range reviews {
+			if r.MemberID == "member-1" {
+				require.Equal(t, 5, r.Rating)
+			}
+		}
+		response = createNewMemberRequest(http.MethodGet, lists+"/"+list.ID, nil, db, sessions["member-1"])
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Contains(t, response.Body.String(), original)
+		require.NotContains(t, response.Body.String(), duplicate)
+	})
+
+	var operationID string
+	t.Run("Redirects the merged book to the target", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books/"+duplicate+"?fields=title", nil, db)
+		require.Equal(t, http.StatusMovedPermanently, response.Code)
+		require.Equal(t, "/api/v1/books/"+original+"?fields=title", response.Header().Get("Location"))
+
+		response = createNewRequest(http.MethodPost, "/api/v1/books/"+original+":merge_into/"+other, nil, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		operationID = response.Header().Get(operationIDHeader)
+		require.NotEmpty(t, operationID)
+		response = createNewRequest(http.MethodGet, "/api/v1/books/"+duplicate, nil, db)
+		require.Equal(t, "/api/v1/books/"+other, response.Header().Get("Location"))
+	})
+
+	t.Run("Lists the merges", func(t *testing.T) {
+		response := createNewMemberRequest(http.MethodGet, "/api/v1/admin/merges", nil, db, librarian)
+		require.Equal(t, http.StatusOK, response.Code)
+		var merges []BookMerge
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&merges))
+		require.Len(t, merges, 2)
+		for _, m := range merges {
+			require.Equal(t, other, m.TargetISBN)
+		}
+	})
+
+	t.Run("Undoes the merge", func(t *testing.T) {
+		response := createNewRequest(http.MethodPost, "/api/v1/operations/"+operationID+":undo", nil, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+
+		require.Equal(t, original, FindSpecificBook(db, original).ISBN)
+		reviews, _, err := ReadReviews(db, original, 0, defaultReviewPageSize)
+		require.NoError(t, err)
+		require.Len(t, reviews, 2)
+		reviews, _, err = ReadReviews(db, other, 0, defaultReviewPageSize)
+		require.NoError(t, err)
+		require.Empty(t, reviews)
+		response = createNewMemberRequest(http.MethodGet, lists+"/"+list.ID, nil, db, sessions["member-1"])
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Contains(t, response.Body.String(), original)
+		require.NotContains(t, response.Body.String(), other)
+
+		response = createNewRequest(http.MethodGet, "/api/v1/books/"+duplicate, nil, db)
+		require.Equal(t, "/api/v1/books/"+original, response.Header().Get("Location"))
+		response = createNewMemberRequest(http.MethodGet, "/api/v1/admin/merges", nil, db, librarian)
+		require.Equal(t, http.StatusOK, response.Code)
+		var merges []BookMerge
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&merges))
+		require.Len(t, merges, 1)
+		require.Equal(t, duplicate, merges[0].ISBN)
+		require.Equal(t, original, merges[0].TargetISBN)
+	})
+}
+
+func TestMergeBookFiles(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	store, err := blobs.NewDir(t.TempDir())
+	require.NoError(t, err)
+	ebookDir := t.TempDir()
+	server := NewServer(db, WithBlobStore(store), WithEbookDir(ebookDir))
+	serve := func(method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+
+	lindgren := &Author{FirstName: "Astrid", LastName: "Lindgren"}
+	original, duplicate := "9789129688313", "9789129688320"
+	for _, isbn := range []string{original, duplicate} {
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: "Pippi Langstrump", Format: FormatEbook, Author: lindgren, Publisher: "raben"})
+		response := serve(http.MethodPost, "/api/v1/books/"+isbn+"?force=true", jsonContentType, jsonBytes)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	}
+	jsonBytes, _ := json.Marshal(Ebook{Licenses: 1, LoanDays: 14})
+	require.Equal(t, http.StatusOK, serve(http.MethodPut, "/api/v1/books/"+duplicate+"/ebook", jsonContentType, jsonBytes).Code)
+	require.Equal(t, http.StatusNoContent, serve(http.MethodPut, "/api/v1/books/"+duplicate+"/ebook/file", "application/epub+zip", []byte("epub")).Code)
+	response := serve(http.MethodPost, "/api/v1/books/"+duplicate+"/attachments?name=errata.txt", "text/plain", []byte("errata"))
+	require.Equal(t, http.StatusCreated, response.Code, response.Body.String())
+	var attachment Attachment
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&attachment))
+	var cover bytes.Buffer
+	require.NoError(t, png.Encode(&cover, image.NewGray(image.Rect(0, 0, 4, 6))))
+	require.Equal(t, http.StatusOK, serve(http.MethodPut, "/api/v1/books/"+duplicate+"/cover", "image/png", cover.Bytes()).Code)
+
+	exists := func(key string) bool {
+		rc, err := store.Get(context.Background(), key)
+		if err != nil {
+			require.ErrorIs(t, err, blobs.ErrNotFound)
+			return false
+		}
+		rc.Close()
+		return true
+	}
+	merge := func() *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/api/v1/books/"+duplicate+":merge_into/"+original, jsonContentType, nil)
+	}
+
+	t.Run("Rejects the merge while the e-book is lent out", func(t *testing.T) {
+		_, err := db.Exec("INSERT INTO digital_loan (id, isbn, memberId, startTime, endTime) VALUES ('loan', ?, 'member', ?, ?)",
+			duplicate, time.Now().Add(-time.Hour).Unix(), time.Now().Add(time.Hour).Unix())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusConflict, merge().Code)
+		require.Equal(t, duplicate, FindSpecificBook(db, duplicate).ISBN)
+		_, err = store.Get(context.Background(), server.coverKey(original, "thumb"))
+		require.ErrorIs(t, err, blobs.ErrNotFound, "the copies are removed when the merge fails")
+
+		_, err = db.Exec("UPDATE digital_loan SET endTime = ? WHERE id = 'loan'", time.Now().Add(-time.Minute).Unix())
+		require.NoError(t, err)
+	})
+
+	t.Run("Moves the e-book, attachments and cover to the target", func(t *testing.T) {
+		response := merge()
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+
+		e, err := FindEbook(db, original, time.Now())
+		require.NoError(t, err)
+		require.True(t, e.HasFile)
+		content, err := os.ReadFile(server.ebookPath(original))
+		require.NoError(t, err)
+		require.Equal(t, "epub", string(content))
+		loans, err := readDigitalLoans(db, "isbn = ?", original)
+		require.NoError(t, err)
+		require.Len(t, loans, 1)
+
+		attachment.ISBN = original
+		_, err = FindAttachment(db, original, attachment.ID)
+		require.NoError(t, err)
+		rc, err := store.Get(context.Background(), server.attachmentKey(attachment))
+		require.NoError(t, err)
+		rc.Close()
+		_, err = FindCover(db, original)
+		require.NoError(t, err)
+		rc, err = store.Get(context.Background(), server.coverKey(original, "thumb"))
+		require.NoError(t, err)
+		rc.Close()
+		for _, table := range []string{"ebook", "digital_loan", "attachment", "cover"} {
+			var n int
+			require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE isbn = ?", duplicate).Scan(&n))
+			require.Zero(t, n, table)
+		}
+		require.True(t, exists(server.coverKey(duplicate, "thumb")), "the originals are kept while the merge can be undone")
+		_, err = os.Stat(server.ebookPath(duplicate))
+		require.NoError(t, err)
+
+		response = serve(http.MethodPost, "/api/v1/operations/"+response.Header().Get(operationIDHeader)+":undo", jsonContentType, nil)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	})
+
+	t.Run("Moves the files back when the merge is undone", func(t *testing.T) {
+		e, err := FindEbook(db, duplicate, time.Now())
+		require.NoError(t, err)
+		require.True(t, e.HasFile)
+		_, err = FindCover(db, duplicate)
+		require.NoError(t, err)
+		attachment.ISBN = duplicate
+		_, err = FindAttachment(db, duplicate, attachment.ID)
+		require.NoError(t, err)
+		loans, err := readDigitalLoans(db, "isbn = ?", duplicate)
+		require.NoError(t, err)
+		require.Len(t, loans, 1)
+
+		require.True(t, exists(server.attachmentKey(attachment)))
+		require.True(t, exists(server.coverKey(duplicate, "thumb")))
+		attachment.ISBN = original
+		require.False(t, exists(server.attachmentKey(attachment)), "the copies are removed")
+		require.False(t, exists(server.coverKey(original, "thumb")))
+		_, err = os.Stat(server.ebookPath(original))
+		require.True(t, os.IsNotExist(err))
+		for _, table := range []string{"ebook", "digital_loan", "attachment", "cover"} {
+			var n int
+			require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE isbn = ?", original).Scan(&n))
+			require.Zero(t, n, table)
+		}
+	})
+
+	t.Run("Removes the originals once the merge can no longer be undone", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, merge().Code)
+		expired := NewServer(db, WithBlobStore(store), WithEbookDir(ebookDir), WithUndoWindow(time.Nanosecond))
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/books/"+original, nil)
+		response := httptest.NewRecorder()
+		expired.ServeHTTP(response, req)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+
+		attachment.ISBN = duplicate
+		require.False(t, exists(server.attachmentKey(attachment)))
+		require.False(t, exists(server.coverKey(duplicate, "thumb")))
+		_, err = os.Stat(server.ebookPath(duplicate))
+		require.True(t, os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,232 @@
+package library
+
+import (
+	"net/http"
+
+	"golang.org/x/text/language"
+)
+
+// MessageCode identifies an error message independently of its language.
+type MessageCode string
+
+// The codes of the error messages which are translated.
+const (
+	MsgNotLoggedIn           MessageCode = "not-logged-in"
+	MsgBookNotFound          MessageCode = "book-not-found"
+	MsgMemberNotFound        MessageCode = "member-not-found"
+	MsgReviewNotFound        MessageCode = "review-not-found"
+	MsgReadingListNotFound   MessageCode = "reading-list-not-found"
+	MsgSeriesNotFound        MessageCode = "series-not-found"
+	MsgWorkNotFound          MessageCode = "work-not-found"
+	MsgSubjectNotFound       MessageCode = "subject-not-found"
+	MsgTenantNotFound        MessageCode = "tenant-not-found"
+	MsgTemplateNotFound      MessageCode = "template-not-found"
+	MsgOperationNotFound     MessageCode = "operation-not-found"
+	MsgBodyTooLarge          MessageCode = "body-too-large"
+	MsgQueryEmpty            MessageCode = "query-empty"
+	MsgInvalidLanguage       MessageCode = "invalid-language"
+	MsgInvalidLimit          MessageCode = "invalid-limit"
+	MsgInvalidPublication    MessageCode = "invalid-publication-year"
+	MsgInvalidModifiedSince  MessageCode = "invalid-modified-since"
+	MsgInvalidSort           MessageCode = "invalid-sort"
+	MsgInvalidSearchSort     MessageCode = "invalid-search-sort"
+	MsgTooManyOperations     MessageCode = "too-many-operations"
+	MsgIncorrectLogin        MessageCode = "incorrect-login"
+	MsgEmailNotVerified      MessageCode = "email-not-verified"
+	MsgTooManyFailedLogins   MessageCode = "too-many-failed-logins"
+	MsgLoginNotVerified      MessageCode = "login-not-verified"
+	MsgAlreadyReviewed       MessageCode = "already-reviewed"
+	MsgListKindChanged       MessageCode = "list-kind-changed"
+	MsgTwoFactorRole         MessageCode = "two-factor-role"
+	MsgTwoFactorNotEnrolled  MessageCode = "two-factor-not-enrolled"
+	MsgRequestQuotaExceeded  MessageCode = "request-quota-exceeded"
+	MsgBookQuotaExceeded     MessageCode = "book-quota-exceeded"
+	MsgStorageQuotaExceeded  MessageCode = "storage-quota-exceeded"
+	MsgDatabaseUnreachable   MessageCode = "database-unreachable"
+	MsgWarmingUp             MessageCode = "warming-up"
+	MsgMemberAccessForbidden MessageCode = "member-access-forbidden"
+	MsgMemberAlreadyErased   MessageCode = "member-already-erased"
+)
+
+// MessageCatalog is the error messages of one language by their codes.
+type MessageCatalog map[MessageCode]string
+
+// englishMessages is the fallback catalog. Its messages are the ones which
+// are written by the handlers.
+var englishMessages = MessageCatalog{
+	MsgNotLoggedIn:           "The request is not logged in",
+	MsgBookNotFound:          "The book did not exist in the library",
+	MsgMemberNotFound:        "The member does not exist",
+	MsgReviewNotFound:        "The review does not exist",
+	MsgReadingListNotFound:   "The reading list does not exist",
+	MsgSeriesNotFound:        "The series does not exist",
+	MsgWorkNotFound:          "The work does not exist",
+	MsgSubjectNotFound:       "The subject does not exist",
+	MsgTenantNotFound:        "The tenant does not exist",
+	MsgTemplateNotFound:      "The template does not exist",
+	MsgOperationNotFound:     "The operation did not exist or can no longer be undone",
+	MsgBodyTooLarge:          "The request body is too large",
+	MsgQueryEmpty:            "q must not be empty",
+	MsgInvalidLanguage:       "language must be a BCP 47 language tag",
+	MsgInvalidLimit:          "The limit must be a positive number",
+	MsgInvalidPublication:    "publication_year must be a year",
+	MsgInvalidModifiedSince:  "modified_since must be an RFC3339 timestamp",
+	MsgInvalidSort:           "sort must be one of title, -title, author, -author, callNumber or -callNumber",
+	MsgInvalidSearchSort:     "sort must be one of relevance or recency",
+	MsgTooManyOperations:     "Too many operations in batch",
+	MsgIncorrectLogin:        "The email or password is incorrect",
+	MsgEmailNotVerified:      "The email address has not been verified",
+	MsgTooManyFailedLogins:   "Too many failed logins, try again later",
+	MsgLoginNotVerified:      "The login at the provider could not be verified",
+	MsgAlreadyReviewed:       "The member has already reviewed this book",
+	MsgListKindChanged:       "Not allowed to change the kind of a reading list",
+	MsgTwoFactorRole:         "Only librarians and admins can enroll two-factor authentication",
+	MsgTwoFactorNotEnrolled:  "Two-factor authentication is not configured",
+	MsgRequestQuotaExceeded:  "The tenant has used its quota of requests, please try again later",
+	MsgBookQuotaExceeded:     "The tenant has reached its quota of books",
+	MsgStorageQuotaExceeded:  "The tenant has reached its quota of storage",
+	MsgDatabaseUnreachable:   "The database can not be reached",
+	MsgWarmingUp:             "Warming up",
+	MsgMemberAccessForbidden: "Only the member or an admin can access the data of the member",
+	MsgMemberAlreadyErased:   "The member has already been erased",
+}
+
+var swedishMessages = MessageCatalog{
+	MsgNotLoggedIn:           "Förfrågan är inte inloggad",
+	MsgBookNotFound:          "Boken finns inte i biblioteket",
+	MsgMemberNotFound:        "Medlemmen finns inte",
+	MsgReviewNotFound:        "Recensionen finns inte",
+	MsgReadingListNotFound:   "Läslistan finns inte",
+	MsgSeriesNotFound:        "Serien finns inte",
+	MsgWorkNotFound:          "Verket finns inte",
+	MsgSubjectNotFound:       "Ämnet finns inte",
+	MsgTenantNotFound:        "Hyresgästen finns inte",
+	MsgTemplateNotFound:      "Mallen finns inte",
+	MsgOperationNotFound:     "Åtgärden finns inte eller kan inte längre ångras",
+	MsgBodyTooLarge:          "Förfrågan är för stor",
+	MsgQueryEmpty:            "q får inte vara tom",
+	MsgInvalidLanguage:       "language måste vara en språkkod enligt BCP 47",
+	MsgInvalidLimit:          "Gränsen måste vara ett positivt tal",
+	MsgInvalidPublication:    "publication_year måste vara ett årtal",
+	MsgInvalidModifiedSince:  "modified_since måste vara en tidpunkt enligt RFC3339",
+	MsgInvalidSort:           "sort måste vara title, -title, author, -author, callNumber eller -callNumber",
+	MsgInvalidSearchSort:     "sort måste vara relevance eller recency",
+	MsgTooManyOperations:     "För många åtgärder i satsen",
+	MsgIncorrectLogin:        "E-postadressen eller lösenordet är fel",
+	MsgEmailNotVerified:      "E-postadressen har inte bekräftats",
+	MsgTooManyFailedLogins:   "För många misslyckade inloggningar, försök igen senare",
+	MsgLoginNotVerified:      "Inloggningen hos leverantören kunde inte bekräftas",
+	MsgAlreadyReviewed:       "Medlemmen har redan recenserat boken",
+	MsgListKindChanged:       "Det är inte tillåtet att ändra typen av en läslista",
+	MsgTwoFactorRole:         "Endast bibliotekarier och administratörer kan aktivera tvåfaktorsautentisering",
+	MsgTwoFactorNotEnrolled:  "Tvåfaktorsautentisering är inte aktiverad",
+	MsgRequestQuotaExceeded:  "Hyresgästen har använt sin kvot av förfrågningar, försök igen senare",
+	MsgBookQuotaExceeded:     "Hyresgästen har nått sin kvot av böcker",
+	MsgStorageQuotaExceeded:  "Hyresgästen har nått sin lagringskvot",
+	MsgDatabaseUnreachable:   "Databasen kan inte nås",
+	MsgWarmingUp:             "Värms upp",
+	MsgMemberAccessForbidden: "Endast medlemmen eller en administratör kan läsa medlemmens data",
+	MsgMemberAlreadyErased:   "Medlemmen har redan raderats",
+}
+
+// messageCatalogs selects the catalog of the language of a request. The
+// first catalog is English, which is used when no other language matches
+// and for the messages which a catalog lacks.
+type messageCatalogs struct {
+	tags     []language.Tag
+	catalogs []MessageCatalog
+	codes    map[string]MessageCode // The codes of the English messages
+	matcher  language.Matcher
+}
+
+func newMessageCatalogs() *messageCatalogs {
+	m := &messageCatalogs{codes: make(map[string]MessageCode)}
+	m.register(language.English, englishMessages)
+	m.register(language.Swedish, swedishMessages)
+	return m
+}
+
+// register adds a catalog, or adds its messages to the catalog of the same
+// language.
+func (m *messageCatalogs) register(tag language.Tag, catalog MessageCatalog) {
+	if tag == language.English {
+		for code, msg := range catalog {
+			m.codes[msg] = code
+		}
+	}
+	for i, t := range m.tags {
+		if t == tag {
+			merged := make(MessageCatalog, len(m.catalogs[i])+len(catalog))
+			for code, msg := range m.catalogs[i] {
+				merged[code] = msg
+			}
+			for code, msg := range catalog {
+				merged[code] = msg
+			}
+			m.catalogs[i] = merged
+			return
+		}
+	}
+	m.tags = append(m.tags, tag)
+	m.catalogs = append(m.catalogs, catalog)
+	m.matcher = language.NewMatcher(m.tags)
+}
+
+// translate returns the message in the language which best matches the
+// Accept-Language header, and the language of the message.
+func (m *messageCatalogs) translate(message, acceptLanguage string) (string, language.Tag) {
+	code, ok := m.codes[message]
+	if !ok || acceptLanguage == "" {
+		return message, language.English
+	}
+	desired, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil {
+		return message, language.English
+	}
+	_, index, confidence := m.matcher.Match(desired...)
+	if confidence == language.No {
+		return message, language.English
+	}
+	if translated, ok := m.catalogs[index][code]; ok {
+		return translated, m.tags[index]
+	}
+	return message, language.English
+}
+
+// WithMessageCatalog registers the error messages of a language. The
+// messages of a catalog of a language which is already registered, e.g.
+// Swedish, replace the built-in ones. English and Swedish are built in.
+func WithMessageCatalog(lang language.Tag, catalog MessageCatalog) ServerOption {
+	return func(s *Server) {
+		s.messages.register(lang, catalog)
+	}
+}
+
+// messageWriter lets HandleErr translate the error messages to the language
+// of the request.
+type messageWriter struct {
+	http.ResponseWriter
+	messages       *messageCatalogs
+	acceptLanguage string
+}
+
+// withMessages passes the language of the request to HandleErr.
+func (s *Server) withMessages(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(&messageWriter{ResponseWriter: w, messages: s.messages, acceptLanguage: r.Header.Get("Accept-Language")}, r)
+	}
+}
+
+// localizeMessage translates an error message written to w, if w knows the
+// language of the request.
+func localizeMessage(w http.ResponseWriter, message string) string {
+	mw, ok := w.(*messageWriter)
+	if !ok {
+		return message
+	}
+	translated, tag := mw.messages.translate(message, mw.acceptLanguage)
+	if translated != message {
+		mw.Header().Set("Content-Language", tag.String())
+	}
+	return translated
+}
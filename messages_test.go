@@ -0,0 +1,47 @@
+package library
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+)
+
+func TestErrorMessages(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	server := NewServer(db,
+		WithMessageCatalog(language.German, MessageCatalog{MsgBookNotFound: "Das Buch existiert nicht"}),
+		WithMessageCatalog(language.Swedish, MessageCatalog{MsgNotLoggedIn: "Du är inte inloggad"}))
+
+	get := func(path, acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+
+	for _, tc := range []struct {
+		name, path, acceptLanguage string
+		want, contentLanguage      string
+	}{
+		{"English by default", "/api/v1/books/9780000000000", "", "The book did not exist in the library", ""},
+		{"Swedish", "/api/v1/books/9780000000000", "sv-SE,sv;q=0.9,en;q=0.8", "Boken finns inte i biblioteket", "sv"},
+		{"Registered catalog", "/api/v1/books/9780000000000", "de", "Das Buch existiert nicht", "de"},
+		{"Overridden message", "/api/v1/me", "sv", "Du är inte inloggad", "sv"},
+		{"Unsupported language", "/api/v1/books/9780000000000", "fi", "The book did not exist in the library", ""},
+		{"Missing from the catalog", "/api/v1/me", "de", "The request is not logged in", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			response := get(tc.path, tc.acceptLanguage)
+			require.GreaterOrEqual(t, response.Code, 400)
+			require.Equal(t, tc.want, response.Body.String())
+			require.Equal(t, tc.contentLanguage, response.Header().Get("Content-Language"))
+		})
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE authority_variant;
+DROP TABLE authority;
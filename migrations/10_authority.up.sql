@@ -0,0 +1,15 @@
+-- Authorized headings of names and subjects, imported from MARC authority files
+CREATE TABLE authority(
+    controlNumber TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    heading TEXT NOT NULL,
+    updateTime timestamp NOT NULL
+);
+CREATE INDEX authority_kind_heading ON authority (kind, heading);
+
+-- Variant forms of the headings, e.g. other spellings of a name
+CREATE TABLE authority_variant(
+    controlNumber TEXT NOT NULL,
+    variant TEXT NOT NULL
+);
+CREATE INDEX authority_variant_controlNumber ON authority_variant (controlNumber);
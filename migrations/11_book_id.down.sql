@@ -0,0 +1,3 @@
+DROP INDEX library_id;
+ALTER TABLE library
+DROP COLUMN id;
@@ -0,0 +1,6 @@
+-- Internal surrogate id of a book. Existing books get random ids since the
+-- configured generator is not available to the migration.
+ALTER TABLE library
+ADD id TEXT NOT NULL DEFAULT '';
+UPDATE library SET id = lower(hex(randomblob(16)));
+CREATE UNIQUE INDEX library_id ON library (id);
@@ -0,0 +1,4 @@
+DROP INDEX library_workId;
+ALTER TABLE library
+DROP COLUMN workId;
+DROP TABLE work;
@@ -0,0 +1,11 @@
+-- A work groups the editions (books) of the same intellectual creation
+CREATE TABLE work(
+    id TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    firstName TEXT NOT NULL,
+    lastName TEXT NOT NULL,
+    createTime timestamp NOT NULL
+);
+ALTER TABLE library
+ADD workId TEXT NOT NULL DEFAULT '';
+CREATE INDEX library_workId ON library (workId);
@@ -0,0 +1,6 @@
+DROP INDEX library_seriesId;
+ALTER TABLE library
+DROP COLUMN seriesVolume;
+ALTER TABLE library
+DROP COLUMN seriesId;
+DROP TABLE series;
@@ -0,0 +1,11 @@
+-- A series orders its books by volume number
+CREATE TABLE series(
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    createTime timestamp NOT NULL
+);
+ALTER TABLE library
+ADD seriesId TEXT NOT NULL DEFAULT '';
+ALTER TABLE library
+ADD seriesVolume INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX library_seriesId ON library (seriesId);
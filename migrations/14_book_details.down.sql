@@ -0,0 +1,10 @@
+ALTER TABLE library
+DROP COLUMN format;
+ALTER TABLE library
+DROP COLUMN publicationYear;
+ALTER TABLE library
+DROP COLUMN language;
+ALTER TABLE library
+DROP COLUMN pageCount;
+ALTER TABLE library
+DROP COLUMN description;
@@ -0,0 +1,11 @@
+-- Descriptive and physical details of a book
+ALTER TABLE library
+ADD description TEXT NOT NULL DEFAULT '';
+ALTER TABLE library
+ADD pageCount INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE library
+ADD language TEXT NOT NULL DEFAULT '';
+ALTER TABLE library
+ADD publicationYear INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE library
+ADD format TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+-- Ratings and reviews of books by members, hidden reviews are moderated
+CREATE TABLE review(
+    id TEXT PRIMARY KEY,
+    isbn TEXT NOT NULL,
+    memberId TEXT NOT NULL,
+    rating INTEGER NOT NULL,
+    text TEXT NOT NULL DEFAULT '',
+    createTime timestamp NOT NULL,
+    hidden INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (isbn, memberId)
+);
@@ -0,0 +1,4 @@
+DROP TABLE reading_list_item;
+DROP INDEX reading_list_shareToken;
+DROP INDEX reading_list_memberId;
+DROP TABLE reading_list;
@@ -0,0 +1,18 @@
+-- Reading lists curated by members, e.g. the books they want to read
+CREATE TABLE reading_list(
+    id TEXT PRIMARY KEY,
+    memberId TEXT NOT NULL,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    visibility TEXT NOT NULL,
+    shareToken TEXT,
+    createTime timestamp NOT NULL
+);
+CREATE INDEX reading_list_memberId ON reading_list (memberId);
+CREATE UNIQUE INDEX reading_list_shareToken ON reading_list (shareToken);
+CREATE TABLE reading_list_item(
+    listId TEXT NOT NULL,
+    isbn TEXT NOT NULL,
+    addTime timestamp NOT NULL,
+    PRIMARY KEY (listId, isbn)
+);
@@ -0,0 +1,8 @@
+-- The journal of destructive operations, used to undo them
+CREATE TABLE operation(
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    changes TEXT NOT NULL,
+    createTime timestamp NOT NULL,
+    undoTime timestamp
+);
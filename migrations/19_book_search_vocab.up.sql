@@ -0,0 +1,2 @@
+-- The terms of the full-text index, used to correct misspelled searches
+CREATE VIRTUAL TABLE book_search_vocab USING fts5vocab(book_search, row);
@@ -0,0 +1,5 @@
+-- The books whose changes have not yet been sent to the search backend
+CREATE TABLE search_index_queue(
+    isbn TEXT PRIMARY KEY,
+    queueTime timestamp NOT NULL
+);
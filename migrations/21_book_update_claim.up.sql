@@ -0,0 +1,6 @@
+-- The time of the latest update of each book in Unix milliseconds, claimed
+-- with a compare-and-set so that the update cooldown holds across processes
+CREATE TABLE book_update_claim(
+    isbn TEXT PRIMARY KEY,
+    updateTime INTEGER NOT NULL
+);
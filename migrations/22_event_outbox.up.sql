@@ -0,0 +1,9 @@
+-- The events which have not yet been published to the message bus, they are
+-- written in the transaction of the change which they describe
+CREATE TABLE event_outbox(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    data TEXT,
+    createTime timestamp NOT NULL
+);
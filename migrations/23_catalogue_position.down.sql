@@ -0,0 +1 @@
+DROP TABLE catalogue_position;
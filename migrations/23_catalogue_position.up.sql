@@ -0,0 +1,5 @@
+-- The id of the latest event applied from each upstream catalogue
+CREATE TABLE catalogue_position(
+    source TEXT PRIMARY KEY,
+    eventId INTEGER NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE member_token;
+DROP TABLE member;
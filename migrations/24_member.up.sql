@@ -0,0 +1,20 @@
+-- The members who signed up to the library online
+CREATE TABLE member(
+    id TEXT PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    firstName TEXT NOT NULL,
+    lastName TEXT NOT NULL,
+    passwordHash TEXT NOT NULL,
+    emailVerified INTEGER NOT NULL DEFAULT 0,
+    createTime timestamp NOT NULL
+);
+
+-- The tokens of the members, e.g. sessions. Only the SHA-256 hash of a token
+-- is stored, so that a leaked database does not leak usable tokens.
+CREATE TABLE member_token(
+    hash TEXT PRIMARY KEY,
+    memberId TEXT NOT NULL REFERENCES member(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    expireTime INTEGER NOT NULL
+);
+CREATE INDEX member_token_member ON member_token(memberId);
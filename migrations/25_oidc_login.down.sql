@@ -0,0 +1,3 @@
+DROP TABLE member_role;
+DROP TABLE member_identity;
+DROP TABLE oidc_login;
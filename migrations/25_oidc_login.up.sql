@@ -0,0 +1,21 @@
+-- The OIDC logins in progress, the state of the callback identifies the login
+CREATE TABLE oidc_login(
+    state TEXT PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    expireTime INTEGER NOT NULL
+);
+
+-- The OIDC subjects which log in as members
+CREATE TABLE member_identity(
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    memberId TEXT NOT NULL REFERENCES member(id) ON DELETE CASCADE,
+    PRIMARY KEY (issuer, subject)
+);
+
+-- The roles of the members, e.g. librarian
+CREATE TABLE member_role(
+    memberId TEXT NOT NULL REFERENCES member(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    PRIMARY KEY (memberId, role)
+);
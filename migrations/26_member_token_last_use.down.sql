@@ -0,0 +1 @@
+ALTER TABLE member_token DROP COLUMN lastUseTime;
@@ -0,0 +1,2 @@
+-- When a session was last used, sessions end after a period without use
+ALTER TABLE member_token ADD COLUMN lastUseTime INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,18 @@
+-- The failed logins per account (the email) and per IP address
+CREATE TABLE login_failure(
+    scope TEXT NOT NULL,
+    key TEXT NOT NULL COLLATE NOCASE,
+    failures INTEGER NOT NULL,
+    lastFailureTime INTEGER NOT NULL,
+    lockedUntil INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (scope, key)
+);
+
+-- The security audit log, e.g. of failed logins and lockouts
+CREATE TABLE security_event(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    email TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    createTime INTEGER NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE member_recovery_code;
+DROP TABLE member_totp;
@@ -0,0 +1,14 @@
+-- The TOTP authenticators of the members, the secrets are encrypted
+CREATE TABLE member_totp(
+    memberId TEXT PRIMARY KEY REFERENCES member(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    confirmed INTEGER NOT NULL DEFAULT 0,
+    lastStep INTEGER NOT NULL DEFAULT 0
+);
+
+-- The hashes of the unused recovery codes of the members
+CREATE TABLE member_recovery_code(
+    memberId TEXT NOT NULL REFERENCES member(id) ON DELETE CASCADE,
+    hash TEXT NOT NULL,
+    PRIMARY KEY (memberId, hash)
+);
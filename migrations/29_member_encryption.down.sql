@@ -0,0 +1,4 @@
+DROP INDEX member_email_hash;
+ALTER TABLE member DROP COLUMN phone;
+ALTER TABLE member DROP COLUMN emailHash;
+DROP TABLE data_key;
@@ -0,0 +1,16 @@
+-- The data keys of the envelope encryption, wrapped with the key encryption
+-- key. The latest data key encrypts, the index key hashes the email
+-- addresses so that the members can be found by email.
+CREATE TABLE data_key(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    wrappedKey TEXT NOT NULL,
+    createTime INTEGER NOT NULL
+);
+
+-- The email is encrypted when there is a key encryption key, and is then
+-- found by the keyed hash of emailHash instead
+ALTER TABLE member ADD COLUMN emailHash TEXT;
+ALTER TABLE member ADD COLUMN phone TEXT NOT NULL DEFAULT '';
+UPDATE member SET emailHash = lower(email);
+CREATE UNIQUE INDEX member_email_hash ON member(emailHash);
@@ -0,0 +1,6 @@
+-- The audit records of the erased members, whose personal data was removed
+CREATE TABLE member_erasure(
+    memberId TEXT PRIMARY KEY,
+    erasedBy TEXT NOT NULL,
+    eraseTime INTEGER NOT NULL
+);
@@ -0,0 +1,2 @@
+DROP TABLE book_subject;
+DROP TABLE subject;
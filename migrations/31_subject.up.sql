@@ -0,0 +1,15 @@
+-- Subject headings form a thesaurus, a subject without a broader subject is
+-- at the top of the hierarchy
+CREATE TABLE subject(
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL COLLATE NOCASE,
+    broaderId TEXT NOT NULL DEFAULT '',
+    createTime timestamp NOT NULL,
+    UNIQUE (broaderId, name)
+);
+CREATE TABLE book_subject(
+    isbn TEXT NOT NULL,
+    subjectId TEXT NOT NULL,
+    PRIMARY KEY (isbn, subjectId)
+);
+CREATE INDEX book_subject_subjectId ON book_subject (subjectId);
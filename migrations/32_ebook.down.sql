@@ -0,0 +1,2 @@
+DROP TABLE digital_loan;
+DROP TABLE ebook;
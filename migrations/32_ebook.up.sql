@@ -0,0 +1,20 @@
+-- The e-books which are lent digitally. The content is either at an external
+-- URL or in a file in the e-book directory, named by the ISBN.
+CREATE TABLE ebook(
+    isbn TEXT PRIMARY KEY,
+    url TEXT NOT NULL DEFAULT '',
+    fileType TEXT NOT NULL DEFAULT '',
+    licenses INTEGER NOT NULL,
+    loanDays INTEGER NOT NULL
+);
+-- A digital loan gives access to the e-book until its end time, which is set
+-- to the time of return when it is returned early
+CREATE TABLE digital_loan(
+    id TEXT PRIMARY KEY,
+    isbn TEXT NOT NULL,
+    memberId TEXT NOT NULL,
+    startTime INTEGER NOT NULL,
+    endTime INTEGER NOT NULL
+);
+CREATE INDEX digital_loan_isbn ON digital_loan (isbn, endTime);
+CREATE INDEX digital_loan_memberId ON digital_loan (memberId);
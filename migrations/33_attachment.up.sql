@@ -0,0 +1,11 @@
+-- The supplementary files of books, e.g. a table of contents or errata. The
+-- content is in the blob store under attachments/{isbn}/{id}.
+CREATE TABLE attachment(
+    id TEXT PRIMARY KEY,
+    isbn TEXT NOT NULL,
+    name TEXT NOT NULL,
+    contentType TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    createTime timestamp NOT NULL
+);
+CREATE INDEX attachment_isbn ON attachment (isbn);
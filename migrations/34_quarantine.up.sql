@@ -0,0 +1,13 @@
+-- The uploads which were rejected by the malware scanner. Only the metadata
+-- is kept, the content is deleted.
+CREATE TABLE quarantine(
+    id TEXT PRIMARY KEY,
+    kind TEXT NOT NULL,
+    isbn TEXT NOT NULL,
+    name TEXT NOT NULL,
+    contentType TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    signature TEXT NOT NULL,
+    memberId TEXT NOT NULL DEFAULT '',
+    createTime timestamp NOT NULL
+);
@@ -0,0 +1,8 @@
+-- The covers of the books. The renditions are in the blob store under
+-- covers/{isbn}/{size}.jpg.
+CREATE TABLE cover(
+    isbn TEXT PRIMARY KEY,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    updateTime timestamp NOT NULL
+);
@@ -0,0 +1,12 @@
+-- Duplicate books which were merged into another book. The merged ISBN
+-- redirects to the target, and the counts are the records which were moved.
+CREATE TABLE book_merge(
+    isbn TEXT PRIMARY KEY,
+    targetIsbn TEXT NOT NULL,
+    mergedBy TEXT NOT NULL DEFAULT '',
+    mergeTime timestamp NOT NULL,
+    reviews INTEGER NOT NULL,
+    listItems INTEGER NOT NULL,
+    subjects INTEGER NOT NULL
+);
+CREATE INDEX book_merge_targetIsbn ON book_merge (targetIsbn);
@@ -0,0 +1,9 @@
+-- The editing locks of the books. A lock is held by a member until its
+-- expire time in Unix seconds, unless the member renews it.
+CREATE TABLE book_lock(
+    isbn TEXT PRIMARY KEY,
+    memberId TEXT NOT NULL,
+    memberName TEXT NOT NULL,
+    acquireTime INTEGER NOT NULL,
+    expireTime INTEGER NOT NULL
+);
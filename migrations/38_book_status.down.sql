@@ -0,0 +1,2 @@
+ALTER TABLE library
+DROP COLUMN status;
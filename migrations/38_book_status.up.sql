@@ -0,0 +1,3 @@
+-- The workflow status of the books, the existing books are published
+ALTER TABLE library
+ADD status TEXT NOT NULL DEFAULT 'published';
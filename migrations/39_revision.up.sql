@@ -0,0 +1,16 @@
+-- The changes of books proposed by trainees. The book is the JSON of the
+-- proposed book, and baseUpdateTime is the update time of the book when the
+-- change was proposed.
+CREATE TABLE revision(
+    id TEXT PRIMARY KEY,
+    isbn TEXT NOT NULL,
+    status TEXT NOT NULL,
+    book TEXT NOT NULL,
+    proposedBy TEXT NOT NULL,
+    createTime INTEGER NOT NULL,
+    reviewedBy TEXT NOT NULL DEFAULT '',
+    reviewTime INTEGER,
+    comment TEXT NOT NULL DEFAULT '',
+    baseUpdateTime timestamp NOT NULL
+);
+CREATE INDEX revision_status ON revision (status, createTime);
@@ -0,0 +1,9 @@
+-- Every edit of a template is stored as a new version
+CREATE TABLE email_template(
+    name TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    createTime timestamp NOT NULL,
+    PRIMARY KEY (name, version)
+);
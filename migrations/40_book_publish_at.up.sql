@@ -0,0 +1,4 @@
+-- Drafts with a publication time are published by the scheduler once it
+-- has passed
+ALTER TABLE library
+ADD publishAt timestamp;
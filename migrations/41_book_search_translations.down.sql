@@ -0,0 +1,15 @@
+DROP TABLE book_search_vocab;
+DROP TABLE book_search;
+CREATE VIRTUAL TABLE book_search USING fts5(
+    isbn UNINDEXED,
+    title,
+    author,
+    publisher,
+    description,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+INSERT INTO book_search (book_search, rank) VALUES ('rank', 'bm25(0.0, 10.0, 5.0, 1.0, 1.0)');
+INSERT INTO book_search (isbn, title, author, publisher, description)
+SELECT library.isbn, library.title, author.firstName || ' ' || author.lastName, library.publisher, library.description
+FROM library JOIN author ON author.isbn = library.isbn;
+CREATE VIRTUAL TABLE book_search_vocab USING fts5vocab(book_search, row);
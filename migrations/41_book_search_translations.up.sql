@@ -0,0 +1,22 @@
+-- The translated titles and descriptions are indexed too, so that a book is
+-- found by its title in any of its languages. A translated title ranks as
+-- high as the title.
+DROP TABLE book_search_vocab;
+DROP TABLE book_search;
+CREATE VIRTUAL TABLE book_search USING fts5(
+    isbn UNINDEXED,
+    title,
+    author,
+    publisher,
+    description,
+    translatedTitles,
+    translatedDescriptions,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+INSERT INTO book_search (book_search, rank) VALUES ('rank', 'bm25(0.0, 10.0, 5.0, 1.0, 1.0, 10.0, 1.0)');
+INSERT INTO book_search (isbn, title, author, publisher, description, translatedTitles, translatedDescriptions)
+SELECT library.isbn, library.title, author.firstName || ' ' || author.lastName, library.publisher, library.description,
+    (SELECT group_concat(title, char(10)) FROM book_translation t WHERE t.isbn = library.isbn),
+    (SELECT group_concat(description, char(10)) FROM book_translation t WHERE t.isbn = library.isbn)
+FROM library JOIN author ON author.isbn = library.isbn;
+CREATE VIRTUAL TABLE book_search_vocab USING fts5vocab(book_search, row);
@@ -0,0 +1,10 @@
+-- Translated variants of the catalog fields of a book
+CREATE TABLE book_translation(
+    isbn TEXT NOT NULL,
+    language TEXT NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL,
+    PRIMARY KEY (isbn, language)
+);
+
+CREATE INDEX book_translation_language_title ON book_translation(language, title);
@@ -0,0 +1,5 @@
+-- Deleted books are remembered so that sync clients learn about deletions
+CREATE TABLE book_tombstone(
+    isbn TEXT PRIMARY KEY,
+    deleteTime timestamp NOT NULL
+);
@@ -0,0 +1,8 @@
+-- The accessible formats (large print, braille, ...) a book is available in
+CREATE TABLE book_accessibility(
+    isbn TEXT NOT NULL,
+    format TEXT NOT NULL,
+    PRIMARY KEY (isbn, format)
+);
+
+CREATE INDEX book_accessibility_format ON book_accessibility(format);
@@ -0,0 +1,3 @@
+-- Books are hidden from the public endpoints until availableFrom has passed
+ALTER TABLE library
+ADD availableFrom timestamp;
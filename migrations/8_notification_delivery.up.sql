@@ -0,0 +1,16 @@
+-- Outgoing notifications waiting to be sent, sent or given up on. nextAttempt
+-- is stored in unix seconds so that it can be compared in queries.
+CREATE TABLE notification_delivery(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    lastError TEXT NOT NULL,
+    createTime timestamp NOT NULL,
+    nextAttempt INTEGER NOT NULL
+);
+
+CREATE INDEX notification_delivery_status ON notification_delivery(status, nextAttempt);
@@ -0,0 +1,4 @@
+ALTER TABLE library
+DROP COLUMN callNumber;
+ALTER TABLE library
+DROP COLUMN classification;
@@ -0,0 +1,5 @@
+-- The call number is generated from the classification unless set manually
+ALTER TABLE library
+ADD classification TEXT NOT NULL DEFAULT '';
+ALTER TABLE library
+ADD callNumber TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,113 @@
+package library
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	xmlContentType  = "application/xml"
+	yamlContentType = "application/yaml"
+)
+
+// mediaTypes maps the accepted media types (and their aliases) to the content
+// type used in the response.
+var mediaTypes = map[string]string{
+	"application/json":   jsonContentType,
+	"application/xml":    xmlContentType,
+	"text/xml":           xmlContentType,
+	"application/yaml":   yamlContentType,
+	"application/x-yaml": yamlContentType,
+	"text/yaml":          yamlContentType,
+	jsonAPIContentType:   jsonAPIContentType,
+}
+
+// bookList wraps a list of books so that it has a root element in XML.
+type bookList struct {
+	XMLName xml.Name `xml:"books"`
+	Books   []Book   `xml:"book"`
+}
+
+// negotiateContentType picks the response content type from the Accept
+// header. Media types are tried in order of their quality value and JSON is
+// used when none of them are supported.
+func negotiateContentType(accept string) string {
+	type acceptRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qStr, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(qStr, 64); err == nil {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		if contentType, ok := mediaTypes[r.mediaType]; ok && r.q > 0 {
+			return contentType
+		}
+	}
+	return jsonContentType
+}
+
+// writeEncoded sets the negotiated content type and writes v to the response
+// in that format. Only books have a JSON:API representation, see jsonapi.go,
+// other responses are written as plain JSON.
+func writeEncoded(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	contentType := negotiateContentType(r.Header.Get("Accept"))
+	if contentType == jsonAPIContentType {
+		contentType = jsonContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	switch contentType {
+	case xmlContentType:
+		if books, ok := v.([]Book); ok {
+			v = bookList{Books: books}
+		}
+		return xml.NewEncoder(w).Encode(v)
+	case yamlContentType:
+		return yaml.NewEncoder(w).Encode(v)
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+// decodeBody decodes the request body into v based on the Content-Type of the
+// request. Bodies without a known content type are decoded as JSON. Unknown
+// fields are rejected so that misspelled fields are not silently dropped,
+// except in XML which has no strict mode.
+func decodeBody(r *http.Request, v interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaTypes[mediaType] {
+	case xmlContentType:
+		return xml.NewDecoder(r.Body).Decode(v)
+	case yamlContentType:
+		dec := yaml.NewDecoder(r.Body)
+		dec.KnownFields(true)
+		return dec.Decode(v)
+	}
+	return decodeJSON(r, v)
+}
+
+// decodeJSON decodes the JSON request body into v, rejecting unknown fields.
+func decodeJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,76 @@
+// Package notifications sends email notifications to library members. Messages
+// are put in a delivery queue stored in the database and sent by a background
+// dispatcher, which retries failed deliveries with an increasing delay.
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// The kinds of notifications sent by the library.
+const (
+	KindHoldAvailable   = "hold-available"
+	KindDueDateReminder = "due-date-reminder"
+	KindOverdueNotice   = "overdue-notice"
+	KindVerifyEmail     = "verify-email"
+	KindPasswordReset   = "password-reset"
+	KindAccountExists   = "account-exists"
+)
+
+// Message is a rendered notification.
+type Message struct {
+	Kind    string `json:"kind"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Sender delivers a message.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPSender sends messages as plain text emails through an SMTP server.
+type SMTPSender struct {
+	Addr string    // host:port of the SMTP server
+	From string    // The sender address
+	Auth smtp.Auth // Optional authentication
+}
+
+// NewSMTPSender creates a sender for the SMTP server at addr. The username
+// and password are optional.
+func NewSMTPSender(addr, from, username, password string) *SMTPSender {
+	s := &SMTPSender{Addr: addr, From: from}
+	if username != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i != -1 {
+			host = addr[:i]
+		}
+		s.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return s
+}
+
+// Send sends the message. The context is not used since net/smtp does not
+// support cancellation.
+func (s *SMTPSender) Send(_ context.Context, msg Message) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	if err := smtp.SendMail(s.Addr, s.Auth, s.From, []string{msg.To}, []byte(b.String())); err != nil {
+		return fmt.Errorf("send mail err, %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"time"
+)
+
+// The statuses of a delivery.
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed" // Given up after MaxAttempts
+)
+
+const (
+	// MaxAttempts is the number of times a delivery is tried before it is
+	// marked as failed.
+	MaxAttempts = 5
+	// retryDelay is the delay before the first retry, it doubles for every
+	// attempt.
+	retryDelay = time.Minute
+)
+
+// Delivery is a message in the delivery queue.
+type Delivery struct {
+	ID          int64     `json:"id"`
+	Message               // The message to deliver
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError,omitempty"`
+	CreateTime  time.Time `json:"createTime"`
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// Queue is the delivery queue, stored in the notification_delivery table.
+type Queue struct {
+	db      *sql.DB
+	onError func(err error)
+}
+
+// QueueOption configures optional settings of the queue.
+type QueueOption func(*Queue)
+
+// WithErrorHandler sets a function which Run calls when processing the
+// deliveries fails, e.g. to fire an alert. The error is logged either way.
+func WithErrorHandler(f func(err error)) QueueOption {
+	return func(q *Queue) {
+		q.onError = f
+	}
+}
+
+// NewQueue creates a delivery queue stored in db.
+func NewQueue(db *sql.DB, opts ...QueueOption) *Queue {
+	q := &Queue{db: db}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Enqueue adds the message to the queue, it is sent by the next call to
+// ProcessDue.
+func (q *Queue) Enqueue(msg Message) (int64, error) {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return 0, fmt.Errorf("invalid recipient %q, %w", msg.To, err)
+	}
+	now := time.Now()
+	res, err := q.db.Exec("INSERT INTO notification_delivery (kind, recipient, subject, body, status, attempts, lastError, createTime, nextAttempt) VALUES(?,?,?,?,?,?,?,?,?)",
+		msg.Kind, msg.To, msg.Subject, msg.Body, StatusPending, 0, "", now, now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("insert delivery err, %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// ProcessDue sends every pending delivery whose next attempt is due. Failed
+// sends are retried later with a doubled delay, until MaxAttempts is reached.
+func (q *Queue) ProcessDue(ctx context.Context, sender Sender) error {
+	due, err := q.list("SELECT id, kind, recipient, subject, body, status, attempts, lastError, createTime, nextAttempt FROM notification_delivery WHERE status = ? AND nextAttempt <= ? ORDER BY id",
+		StatusPending, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		d.Attempts++
+		sendErr := sender.Send(ctx, d.Message)
+		switch {
+		case sendErr == nil:
+			d.Status, d.LastError = StatusSent, ""
+		case d.Attempts >= MaxAttempts:
+			d.Status, d.LastError = StatusFailed, sendErr.Error()
+		default:
+			d.LastError = sendErr.Error()
+			d.NextAttempt = time.Now().Add(retryDelay << (d.Attempts - 1))
+		}
+		_, err := q.db.Exec("UPDATE notification_delivery SET status = ?, attempts = ?, lastError = ?, nextAttempt = ? WHERE id = ?",
+			d.Status, d.Attempts, d.LastError, d.NextAttempt.Unix(), d.ID)
+		if err != nil {
+			return fmt.Errorf("update delivery err, %w", err)
+		}
+	}
+	return nil
+}
+
+// Run calls ProcessDue every interval until the context is cancelled.
+func (q *Queue) Run(ctx context.Context, sender Sender, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := q.ProcessDue(ctx, sender); err != nil && ctx.Err() == nil {
+			log.Printf("notifications: failed to process deliveries, %v\n", err)
+			if q.onError != nil {
+				q.onError(err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListFailed reads the deliveries which were given up on, newest first.
+func (q *Queue) ListFailed() ([]Delivery, error) {
+	return q.list("SELECT id, kind, recipient, subject, body, status, attempts, lastError, createTime, nextAttempt FROM notification_delivery WHERE status = ? ORDER BY id DESC",
+		StatusFailed)
+}
+
+// Filter selects the deliveries of the log, an empty field matches every
+// delivery.
+type Filter struct {
+	Kind   string
+	To     string // Matched without regard to case
+	Status string
+}
+
+// List reads the deliveries which match the filter, newest first, skipping
+// offset deliveries and reading at most limit.
+func (q *Queue) List(f Filter, offset, limit int) ([]Delivery, error) {
+	return q.list("SELECT id, kind, recipient, subject, body, status, attempts, lastError, createTime, nextAttempt FROM notification_delivery "+
+		"WHERE (?1 = '' OR kind = ?1) AND (?2 = '' OR recipient = ?2 COLLATE NOCASE) AND (?3 = '' OR status = ?3) "+
+		"ORDER BY id DESC LIMIT ?4 OFFSET ?5",
+		f.Kind, f.To, f.Status, limit, offset)
+}
+
+// ErrNotFailed is returned when a delivery which has not failed is resent.
+var ErrNotFailed = errors.New("the delivery has not failed")
+
+// Resend queues a failed delivery to be sent again by the next call to
+// ProcessDue, with MaxAttempts new attempts. It returns sql.ErrNoRows if
+// there is no such delivery.
+func (q *Queue) Resend(id int64) error {
+	res, err := q.db.Exec("UPDATE notification_delivery SET status = ?, attempts = 0, nextAttempt = ? WHERE id = ? AND status = ?",
+		StatusPending, time.Now().Unix(), id, StatusFailed)
+	if err != nil {
+		return fmt.Errorf("resend delivery err, %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("resend delivery err, %w", err)
+	} else if n > 0 {
+		return nil
+	}
+	var status string
+	if err := q.db.QueryRow("SELECT status FROM notification_delivery WHERE id = ?", id).Scan(&status); err != nil {
+		return err
+	}
+	return ErrNotFailed
+}
+
+func (q *Queue) list(query string, args ...interface{}) ([]Delivery, error) {
+	rows, err := q.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query deliveries err, %w", err)
+	}
+	defer rows.Close()
+	var deliveries []Delivery
+	for rows.Next() {
+		var d Delivery
+		var nextAttempt int64
+		err := rows.Scan(&d.ID, &d.Kind, &d.To, &d.Subject, &d.Body, &d.Status,
+			&d.Attempts, &d.LastError, &d.CreateTime, &nextAttempt)
+		if err != nil {
+			return nil, fmt.Errorf("scan delivery err, %w", err)
+		}
+		d.NextAttempt = time.Unix(nextAttempt, 0)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
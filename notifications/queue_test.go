@@ -0,0 +1,120 @@
+package notifications_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	library "github.com/NicolaiMordrup/library"
+	"github.com/NicolaiMordrup/library/notifications"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSender struct {
+	err  error
+	sent []notifications.Message
+}
+
+func (s *fakeSender) Send(_ context.Context, msg notifications.Message) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func TestQueue(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "")
+	require.NoError(t, err)
+	defer os.Remove(tempFile.Name())
+	defer os.Remove(tempFile.Name() + "-wal")
+	defer os.Remove(tempFile.Name() + "-shm")
+	db, err := library.NewDB(tempFile.Name())
+	require.NoError(t, err)
+	require.NoError(t, library.EnsureSchema(db))
+	q := notifications.NewQueue(db)
+
+	data := map[string]interface{}{
+		"Member":  map[string]string{"FirstName": "Astrid"},
+		"Book":    library.Book{Title: "Pippi Longstocking"},
+		"DueDate": time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("Sends the rendered default template", func(t *testing.T) {
+		_, err := library.QueueNotification(db, q, notifications.KindOverdueNotice,
+			"astrid@example.com", data)
+		require.NoError(t, err)
+
+		sender := &fakeSender{}
+		require.NoError(t, q.ProcessDue(context.Background(), sender))
+		require.Len(t, sender.sent, 1)
+		require.Equal(t, "Pippi Longstocking is overdue", sender.sent[0].Subject)
+
+		// Sent deliveries are not sent again
+		require.NoError(t, q.ProcessDue(context.Background(), sender))
+		require.Len(t, sender.sent, 1)
+	})
+
+	t.Run("Gives up after MaxAttempts failed sends", func(t *testing.T) {
+		id, err := library.QueueNotification(db, q, notifications.KindHoldAvailable,
+			"astrid@example.com", data)
+		require.NoError(t, err)
+
+		sender := &fakeSender{err: errors.New("connection refused")}
+		for i := 0; i < notifications.MaxAttempts; i++ {
+			require.NoError(t, q.ProcessDue(context.Background(), sender))
+			// Make the retry due immediately
+			_, err := db.Exec("UPDATE notification_delivery SET nextAttempt = 0 WHERE id = ?", id)
+			require.NoError(t, err)
+		}
+
+		failed, err := q.ListFailed()
+		require.NoError(t, err)
+		require.Len(t, failed, 1)
+		require.Equal(t, id, failed[0].ID)
+		require.Equal(t, notifications.MaxAttempts, failed[0].Attempts)
+		require.Equal(t, "connection refused", failed[0].LastError)
+	})
+
+	t.Run("Searches the delivery log", func(t *testing.T) {
+		log, err := q.List(notifications.Filter{To: "ASTRID@example.com"}, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, log, 2)
+		require.Equal(t, notifications.StatusFailed, log[0].Status)
+		require.Equal(t, notifications.StatusSent, log[1].Status)
+
+		log, err = q.List(notifications.Filter{Status: notifications.StatusSent}, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, log, 1)
+		require.Equal(t, notifications.KindOverdueNotice, log[0].Kind)
+
+		log, err = q.List(notifications.Filter{}, 1, 10)
+		require.NoError(t, err)
+		require.Len(t, log, 1)
+	})
+
+	t.Run("Resends failed deliveries", func(t *testing.T) {
+		failed, err := q.ListFailed()
+		require.NoError(t, err)
+		require.ErrorIs(t, q.Resend(failed[0].ID-1), notifications.ErrNotFailed)
+		require.ErrorIs(t, q.Resend(1000), sql.ErrNoRows)
+
+		require.NoError(t, q.Resend(failed[0].ID))
+		sender := &fakeSender{}
+		require.NoError(t, q.ProcessDue(context.Background(), sender))
+		require.Len(t, sender.sent, 1)
+		failed, err = q.ListFailed()
+		require.NoError(t, err)
+		require.Empty(t, failed)
+	})
+
+	t.Run("Rejects invalid recipients", func(t *testing.T) {
+		_, err := q.Enqueue(notifications.Message{
+			To: "astrid@example.com\r\nBcc: everyone@example.com",
+		})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,181 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/NicolaiMordrup/library/notifications"
+	"github.com/gorilla/mux"
+)
+
+// defaultEmailTemplates are used for notifications whose template has not
+// been stored through the template admin API.
+var defaultEmailTemplates = map[string]EmailTemplate{
+	notifications.KindHoldAvailable: {
+		Name:    notifications.KindHoldAvailable,
+		Subject: "{{.Book.Title}} is ready to be picked up",
+		Body: "Hi {{.Member.FirstName}},\n\n" +
+			"The book {{.Book.Title}} which you placed a hold on is now available " +
+			"for you to pick up.\n",
+	},
+	notifications.KindDueDateReminder: {
+		Name:    notifications.KindDueDateReminder,
+		Subject: "{{.Book.Title}} is due {{date .DueDate \"2006-01-02\"}}",
+		Body: "Hi {{.Member.FirstName}},\n\n" +
+			"This is a reminder that {{.Book.Title}} should be returned by " +
+			"{{date .DueDate \"2006-01-02\"}}.\n",
+	},
+	notifications.KindOverdueNotice: {
+		Name:    notifications.KindOverdueNotice,
+		Subject: "{{.Book.Title}} is overdue",
+		Body: "Hi {{.Member.FirstName}},\n\n" +
+			"{{.Book.Title}} was due {{date .DueDate \"2006-01-02\"}}, please " +
+			"return it as soon as possible.\n",
+	},
+	notifications.KindVerifyEmail: {
+		Name:    notifications.KindVerifyEmail,
+		Subject: "Verify your email address",
+		Body: "Hi {{.Member.FirstName}},\n\n" +
+			"Welcome to the library! Verify your email address with this code " +
+			"within 24 hours:\n\n{{.Token}}\n",
+	},
+	notifications.KindPasswordReset: {
+		Name:    notifications.KindPasswordReset,
+		Subject: "Reset your password",
+		Body: "Hi {{.Member.FirstName}},\n\n" +
+			"Reset your password with this code within an hour:\n\n{{.Token}}\n\n" +
+			"If you did not ask to reset your password you can ignore this email.\n",
+	},
+	notifications.KindAccountExists: {
+		Name:    notifications.KindAccountExists,
+		Subject: "You already have an account",
+		Body: "Hi {{.Member.FirstName}},\n\n" +
+			"Someone tried to sign up to the library with this email address, " +
+			"which already has an account. Log in with it, or reset your password " +
+			"if you have forgotten it.\n\n" +
+			"If you did not try to sign up you can ignore this email.\n",
+	},
+}
+
+// QueueNotification renders the template of the given kind of notification
+// and puts the message in the delivery queue. The latest stored version of
+// the template is used if there is one, otherwise the default template.
+func QueueNotification(db *sql.DB, q *notifications.Queue, kind, to string, data interface{}) (int64, error) {
+	t, err := FindEmailTemplate(db, kind, 0)
+	if errors.Is(err, sql.ErrNoRows) {
+		var ok bool
+		if t, ok = defaultEmailTemplates[kind]; !ok {
+			return 0, fmt.Errorf("no template for notification kind %q", kind)
+		}
+	} else if err != nil {
+		return 0, fmt.Errorf("read template err, %w", err)
+	}
+
+	subject, body, err := RenderEmailTemplate(t, data)
+	if err != nil {
+		return 0, err
+	}
+	return q.Enqueue(notifications.Message{Kind: kind, To: to, Subject: subject, Body: body})
+}
+
+// ListFailedDeliveries retrieves the notifications which could not be
+// delivered after all retries. Only admins can list them, since they hold
+// the addresses and the messages of the members.
+func (s *Server) ListFailedDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	deliveries, err := notifications.NewQueue(s.db).ListFailed()
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the deliveries")
+		return
+	}
+	if deliveries == nil {
+		deliveries = []notifications.Delivery{}
+	}
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the deliveries")
+		return
+	}
+}
+
+// DeliveryPage is a page of the delivery log.
+type DeliveryPage struct {
+	Deliveries    []notifications.Delivery `json:"deliveries"`
+	NextPageToken string                   `json:"nextPageToken,omitempty"`
+}
+
+// ListDeliveries retrieves a page of the delivery log, newest first. The log
+// is searched by the kind, to and status query parameters, e.g.
+// ?to=astrid@example.com&status=failed, and paged with page_size and
+// page_token. Only admins can read the log.
+func (s *Server) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	query := r.URL.Query()
+	f := notifications.Filter{Kind: query.Get("kind"), To: query.Get("to"), Status: query.Get("status")}
+	switch f.Status {
+	case "", notifications.StatusPending, notifications.StatusSent, notifications.StatusFailed:
+	default:
+		HandleErr(w, http.StatusBadRequest, "status must be one of pending, sent or failed")
+		return
+	}
+	offset, size, err := parsePage(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// One more delivery is read to tell whether there is a next page
+	deliveries, err := notifications.NewQueue(s.db).List(f, offset, size+1)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the deliveries")
+		return
+	}
+	page := DeliveryPage{Deliveries: []notifications.Delivery{}}
+	if len(deliveries) > size {
+		deliveries = deliveries[:size]
+		page.NextPageToken = pageToken(offset + size)
+	}
+	page.Deliveries = append(page.Deliveries, deliveries...)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the deliveries")
+		return
+	}
+}
+
+// ResendDelivery queues a failed delivery to be sent again. Only admins can
+// resend deliveries.
+func (s *Server) ResendDelivery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		HandleErr(w, http.StatusNotFound, "The delivery did not exist")
+		return
+	}
+	err = notifications.NewQueue(s.db).Resend(id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		HandleErr(w, http.StatusNotFound, "The delivery did not exist")
+		return
+	case errors.Is(err, notifications.ErrNotFailed):
+		HandleErr(w, http.StatusConflict, "Only failed deliveries can be resent")
+		return
+	case err != nil:
+		HandleErr(w, http.StatusInternalServerError, "Failed to resend the delivery")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/notifications"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliveries(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	id, err := notifications.NewQueue(db).Enqueue(notifications.Message{
+		Kind: notifications.KindOverdueNotice, To: "astrid@example.com", Subject: "Overdue", Body: "Return it"})
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE notification_delivery SET status = ? WHERE id = ?", notifications.StatusFailed, id)
+	require.NoError(t, err)
+	_, err = notifications.NewQueue(db).Enqueue(notifications.Message{
+		Kind: notifications.KindOverdueNotice, To: "emil@example.com", Subject: "Overdue", Body: "Return it"})
+	require.NoError(t, err)
+	path := "/api/v1/admin/notifications/failed"
+	resend := "/api/v1/admin/notifications/" + strconv.FormatInt(id, 10) + ":resend"
+	admin := createMemberSession(t, db, "admin", RoleAdmin)
+
+	t.Run("Lets only admins read and resend the deliveries", func(t *testing.T) {
+		librarian := createMemberSession(t, db, "astrid", RoleLibrarian)
+		for _, r := range []struct{ method, path string }{
+			{http.MethodGet, path},
+			{http.MethodGet, "/api/v1/admin/notifications"},
+			{http.MethodPost, resend},
+		} {
+			require.Equal(t, http.StatusUnauthorized, createNewRequest(r.method, r.path, nil, db).Code, r.path)
+			response := createNewMemberRequest(r.method, r.path, nil, db, librarian)
+			require.Equal(t, http.StatusForbidden, response.Code, r.path)
+		}
+	})
+
+	t.Run("Searches the delivery log", func(t *testing.T) {
+		response := createNewMemberRequest(http.MethodGet, "/api/v1/admin/notifications?page_size=1", nil, db, admin)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		var page DeliveryPage
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&page))
+		require.Len(t, page.Deliveries, 1)
+		require.Equal(t, "emil@example.com", page.Deliveries[0].To)
+		require.NotEmpty(t, page.NextPageToken)
+
+		response = createNewMemberRequest(http.MethodGet, "/api/v1/admin/notifications?to=astrid@example.com", nil, db, admin)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		page = DeliveryPage{}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&page))
+		require.Len(t, page.Deliveries, 1)
+		require.Equal(t, id, page.Deliveries[0].ID)
+		require.Empty(t, page.NextPageToken)
+
+		response = createNewMemberRequest(http.MethodGet, "/api/v1/admin/notifications?status=lost", nil, db, admin)
+		require.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Lists the failed deliveries", func(t *testing.T) {
+		response := createNewMemberRequest(http.MethodGet, path, nil, db, admin)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		var deliveries []notifications.Delivery
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&deliveries))
+		require.Len(t, deliveries, 1)
+		require.Equal(t, "astrid@example.com", deliveries[0].To)
+	})
+
+	t.Run("Resends the failed deliveries", func(t *testing.T) {
+		response := createNewMemberRequest(http.MethodPost, resend, nil, db, admin)
+		require.Equal(t, http.StatusNoContent, response.Code, response.Body.String())
+		response = createNewMemberRequest(http.MethodPost, resend, nil, db, admin)
+		require.Equal(t, http.StatusConflict, response.Code)
+		response = createNewMemberRequest(http.MethodPost, "/api/v1/admin/notifications/1000:resend", nil, db, admin)
+		require.Equal(t, http.StatusNotFound, response.Code)
+
+		failed, err := notifications.NewQueue(db).ListFailed()
+		require.NoError(t, err)
+		require.Empty(t, failed)
+	})
+}
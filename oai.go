@@ -0,0 +1,539 @@
+package library
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OAIRepository describes the repository in the responses to the OAI-PMH
+// Identify verb.
+type OAIRepository struct {
+	Name       string
+	Identifier string // Used in record identifiers, e.g. oai:{Identifier}:{isbn}
+	AdminEmail string
+}
+
+// WithOAIRepository sets the description of the OAI-PMH repository.
+func WithOAIRepository(repo OAIRepository) ServerOption {
+	return func(s *Server) {
+		s.oaiRepository = repo
+	}
+}
+
+const (
+	oaiDateFormat    = "2006-01-02T15:04:05Z"
+	oaiDayFormat     = "2006-01-02"
+	oaiDCPrefix      = "oai_dc"
+	oaiDCNamespace   = "http://www.openarchives.org/OAI/2.0/oai_dc/"
+	oaiDCSchema      = "http://www.openarchives.org/OAI/2.0/oai_dc.xsd"
+	oaiPMHNamespace  = "http://www.openarchives.org/OAI/2.0/"
+	oaiPMHSchemaLoc  = "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd"
+	dublinCoreNS     = "http://purl.org/dc/elements/1.1/"
+	xmlSchemaInstNS  = "http://www.w3.org/2001/XMLSchema-instance"
+	oaiDCSchemaLoc   = oaiDCNamespace + " " + oaiDCSchema
+	oaiStatusDeleted = "deleted"
+)
+
+// oaiPageSize is the number of records in each response to the list verbs,
+// the rest of the list is retrieved with the resumption token.
+var oaiPageSize = 100
+
+// The arguments which are allowed for each verb, true if it is required.
+// The resumption token is exclusive, it must be the only argument.
+var oaiVerbArgs = map[string]map[string]bool{
+	"Identify":            {},
+	"ListMetadataFormats": {"identifier": false},
+	"ListSets":            {"resumptionToken": false},
+	"GetRecord":           {"identifier": true, "metadataPrefix": true},
+	"ListIdentifiers":     {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+	"ListRecords":         {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+}
+
+type oaiResponse struct {
+	XMLName             xml.Name            `xml:"OAI-PMH"`
+	Xmlns               string              `xml:"xmlns,attr"`
+	XmlnsXSI            string              `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string              `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string              `xml:"responseDate"`
+	Request             oaiRequest          `xml:"request"`
+	Errors              []oaiError          `xml:"error,omitempty"`
+	Identify            *oaiIdentify        `xml:"Identify,omitempty"`
+	ListMetadataFormats *oaiMetadataFormats `xml:"ListMetadataFormats,omitempty"`
+	GetRecord           *oaiRecordList      `xml:"GetRecord,omitempty"`
+	ListIdentifiers     *oaiHeaderList      `xml:"ListIdentifiers,omitempty"`
+	ListRecords         *oaiRecordList      `xml:"ListRecords,omitempty"`
+}
+
+type oaiRequest struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	BaseURL         string `xml:",chardata"`
+}
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+type oaiIdentify struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type oaiMetadataFormats struct {
+	Formats []oaiMetadataFormat `xml:"metadataFormat"`
+}
+
+type oaiMetadataFormat struct {
+	Prefix    string `xml:"metadataPrefix"`
+	Schema    string `xml:"schema"`
+	Namespace string `xml:"metadataNamespace"`
+}
+
+type oaiHeader struct {
+	Status     string `xml:"status,attr,omitempty"`
+	Identifier string `xml:"identifier"`
+	Datestamp  string `xml:"datestamp"`
+}
+
+type oaiRecord struct {
+	Header   oaiHeader    `xml:"header"`
+	Metadata *oaiMetadata `xml:"metadata,omitempty"`
+}
+
+type oaiMetadata struct {
+	DC dublinCore `xml:"oai_dc:dc"`
+}
+
+type oaiRecordList struct {
+	Records         []oaiRecord         `xml:"record"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiHeaderList struct {
+	Headers         []oaiHeader         `xml:"header"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiResumptionToken struct {
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+	Token            string `xml:",chardata"`
+}
+
+// dublinCore is the unqualified Dublin Core description of a book.
+type dublinCore struct {
+	XmlnsOAIDC     string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	XmlnsXSI       string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title          []string `xml:"dc:title"`
+	Creator        []string `xml:"dc:creator,omitempty"`
+	Publisher      []string `xml:"dc:publisher,omitempty"`
+	Subject        []string `xml:"dc:subject,omitempty"`
+	Description    []string `xml:"dc:description,omitempty"`
+	Date           []string `xml:"dc:date,omitempty"`
+	Language       []string `xml:"dc:language,omitempty"`
+	Identifier     []string `xml:"dc:identifier"`
+	Type           []string `xml:"dc:type"`
+}
+
+// dublinCoreFromBook maps a book to Dublin Core.
+func dublinCoreFromBook(b Book) dublinCore {
+	dc := dublinCore{
+		XmlnsOAIDC:     oaiDCNamespace,
+		XmlnsDC:        dublinCoreNS,
+		XmlnsXSI:       xmlSchemaInstNS,
+		SchemaLocation: oaiDCSchemaLoc,
+		Title:          []string{b.Title},
+		Identifier:     []string{"urn:isbn:" + b.ISBN},
+		Type:           []string{"Text"},
+	}
+	if b.Author != nil && b.Author.LastName != "" {
+		dc.Creator = append(dc.Creator, strings.TrimSuffix(b.Author.LastName+", "+b.Author.FirstName, ", "))
+	}
+	if b.Publisher != "" {
+		dc.Publisher = append(dc.Publisher, b.Publisher)
+	}
+	if b.Classification != "" {
+		dc.Subject = append(dc.Subject, b.Classification)
+	}
+	if b.Description != "" {
+		dc.Description = append(dc.Description, b.Description)
+	}
+	for _, t := range b.Translations {
+		if t.Description != "" {
+			dc.Description = append(dc.Description, t.Description)
+		}
+	}
+	if b.PublicationYear != 0 {
+		dc.Date = append(dc.Date, strconv.Itoa(b.PublicationYear))
+	}
+	if b.Language != "" {
+		dc.Language = append(dc.Language, b.Language)
+	}
+	return dc
+}
+
+// oaiItem is a record or deleted record which can be harvested.
+type oaiItem struct {
+	isbn      string
+	datestamp time.Time
+	book      *Book // nil if the book was deleted
+}
+
+// oaiDatestamp returns the time of the last change of the book which is
+// visible to harvesters, the end of an embargo counts as a change.
+func oaiDatestamp(b Book) time.Time {
+	latest := b.CreateTime
+	if b.UpdateTime.After(latest) {
+		latest = b.UpdateTime
+	}
+	if b.AvailableFrom != nil && b.AvailableFrom.After(latest) {
+		latest = *b.AvailableFrom
+	}
+	return latest.UTC()
+}
+
+// readOAIItems reads the public books and deleted books ordered by
+// datestamp.
+func readOAIItems(db Querier, now time.Time) ([]oaiItem, error) {
+	var items []oaiItem
+	for _, b := range ReadPublicBookList(db, now) {
+		b := b
+		items = append(items, oaiItem{isbn: b.ISBN, datestamp: oaiDatestamp(b), book: &b})
+	}
+	tombstones, err := ReadTombstones(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tombstones {
+		items = append(items, oaiItem{isbn: t.ISBN, datestamp: t.DeleteTime.UTC()})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].datestamp.Equal(items[j].datestamp) {
+			return items[i].datestamp.Before(items[j].datestamp)
+		}
+		return items[i].isbn < items[j].isbn
+	})
+	return items, nil
+}
+
+func (s *Server) oaiIdentifier(isbn string) string {
+	return "oai:" + s.oaiRepository.Identifier + ":" + isbn
+}
+
+func (s *Server) oaiRecord(item oaiItem) oaiRecord {
+	rec := oaiRecord{Header: oaiHeader{
+		Identifier: s.oaiIdentifier(item.isbn),
+		Datestamp:  item.datestamp.Format(oaiDateFormat),
+	}}
+	if item.book == nil {
+		rec.Header.Status = oaiStatusDeleted
+		return rec
+	}
+	rec.Metadata = &oaiMetadata{DC: dublinCoreFromBook(*item.book)}
+	return rec
+}
+
+// oaiListState is encoded in the resumption tokens, which makes them
+// stateless.
+type oaiListState struct {
+	Prefix string `json:"p"`
+	From   string `json:"f,omitempty"`
+	Until  string `json:"u,omitempty"`
+	Cursor int    `json:"c"`
+}
+
+func (st oaiListState) token() string {
+	b, _ := json.Marshal(st)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parseOAIListState(token string) (oaiListState, error) {
+	var st oaiListState
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, err
+	}
+	if st.Cursor < 0 {
+		return st, fmt.Errorf("negative cursor")
+	}
+	return st, nil
+}
+
+// parseOAIDate parses a from or until argument. Dates without a time are
+// expanded to the start of the day for from and the end of the day for
+// until.
+func parseOAIDate(s string, until bool) (time.Time, error) {
+	if t, err := time.Parse(oaiDateFormat, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(oaiDayFormat, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if until {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// OAIPMH is the OAI-PMH 2.0 provider which lets aggregators harvest the
+// public books as Dublin Core records.
+func (s *Server) OAIPMH(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	now := time.Now()
+	baseURL := externalURL(r, r.URL.Path)
+	resp := oaiResponse{
+		Xmlns:          oaiPMHNamespace,
+		XmlnsXSI:       xmlSchemaInstNS,
+		SchemaLocation: oaiPMHSchemaLoc,
+		ResponseDate:   now.UTC().Format(oaiDateFormat),
+		Request:        oaiRequest{BaseURL: baseURL},
+	}
+	if err := r.ParseForm(); err != nil {
+		resp.Errors = append(resp.Errors, oaiError{"badArgument", "The request could not be parsed"})
+		writeOAI(w, resp)
+		return
+	}
+	if err := s.handleOAIVerb(&resp, r.Form, now, baseURL); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the records")
+		return
+	}
+	writeOAI(w, resp)
+}
+
+// handleOAIVerb fills in the response to the verb of the request. Protocol
+// errors are reported in the response, the returned error is only for
+// failures to read the records.
+func (s *Server) handleOAIVerb(resp *oaiResponse, form url.Values, now time.Time, baseURL string) error {
+	verb := form.Get("verb")
+	allowed, ok := oaiVerbArgs[verb]
+	if !ok || len(form["verb"]) != 1 {
+		resp.Errors = append(resp.Errors, oaiError{"badVerb", "Illegal or missing verb"})
+		return nil
+	}
+	resp.Request.Verb = verb
+	for arg, values := range form {
+		if _, ok := allowed[arg]; (!ok && arg != "verb") || len(values) != 1 {
+			resp.Errors = append(resp.Errors, oaiError{"badArgument", fmt.Sprintf("Illegal or repeated argument %s", arg)})
+			return nil
+		}
+	}
+	token := form.Get("resumptionToken")
+	if token != "" && len(form) != 2 {
+		resp.Errors = append(resp.Errors, oaiError{"badArgument", "The resumptionToken argument must be the only argument"})
+		return nil
+	}
+	for arg, required := range allowed {
+		if required && token == "" && form.Get(arg) == "" {
+			resp.Errors = append(resp.Errors, oaiError{"badArgument", fmt.Sprintf("Missing required argument %s", arg)})
+			return nil
+		}
+	}
+	resp.Request.Identifier = form.Get("identifier")
+	resp.Request.MetadataPrefix = form.Get("metadataPrefix")
+	resp.Request.From = form.Get("from")
+	resp.Request.Until = form.Get("until")
+	resp.Request.Set = form.Get("set")
+	resp.Request.ResumptionToken = token
+
+	switch verb {
+	case "Identify":
+		return s.oaiIdentify(resp, now, baseURL)
+	case "ListMetadataFormats":
+		if id := form.Get("identifier"); id != "" {
+			item, ok, err := s.findOAIItem(id, now)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				resp.Errors = append(resp.Errors, oaiError{"idDoesNotExist", "No such record"})
+				return nil
+			}
+			if item.book == nil {
+				resp.Errors = append(resp.Errors, oaiError{"noMetadataFormats", "The record was deleted"})
+				return nil
+			}
+		}
+		resp.ListMetadataFormats = &oaiMetadataFormats{Formats: []oaiMetadataFormat{
+			{Prefix: oaiDCPrefix, Schema: oaiDCSchema, Namespace: oaiDCNamespace},
+		}}
+	case "ListSets":
+		resp.Errors = append(resp.Errors, oaiError{"noSetHierarchy", "The repository does not support sets"})
+	case "GetRecord":
+		if form.Get("metadataPrefix") != oaiDCPrefix {
+			resp.Errors = append(resp.Errors, oaiError{"cannotDisseminateFormat", "Only oai_dc is supported"})
+			return nil
+		}
+		item, ok, err := s.findOAIItem(form.Get("identifier"), now)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			resp.Errors = append(resp.Errors, oaiError{"idDoesNotExist", "No such record"})
+			return nil
+		}
+		resp.GetRecord = &oaiRecordList{Records: []oaiRecord{s.oaiRecord(item)}}
+	case "ListIdentifiers", "ListRecords":
+		return s.oaiList(resp, verb, form, now)
+	}
+	return nil
+}
+
+func (s *Server) oaiIdentify(resp *oaiResponse, now time.Time, baseURL string) error {
+	items, err := readOAIItems(s.db, now)
+	if err != nil {
+		return err
+	}
+	earliest := time.Unix(0, 0).UTC()
+	if len(items) != 0 {
+		earliest = items[0].datestamp
+	}
+	resp.Identify = &oaiIdentify{
+		RepositoryName:    s.oaiRepository.Name,
+		BaseURL:           baseURL,
+		ProtocolVersion:   "2.0",
+		AdminEmail:        s.oaiRepository.AdminEmail,
+		EarliestDatestamp: earliest.Format(oaiDateFormat),
+		// Tombstones are removed when a book is created again
+		DeletedRecord: "transient",
+		Granularity:   "YYYY-MM-DDThh:mm:ssZ",
+	}
+	return nil
+}
+
+// findOAIItem finds the record with the given OAI identifier.
+func (s *Server) findOAIItem(identifier string, now time.Time) (oaiItem, bool, error) {
+	isbn := strings.TrimPrefix(identifier, s.oaiIdentifier(""))
+	if isbn == identifier {
+		return oaiItem{}, false, nil
+	}
+	items, err := readOAIItems(s.db, now)
+	if err != nil {
+		return oaiItem{}, false, err
+	}
+	for _, item := range items {
+		if item.isbn == isbn {
+			return item, true, nil
+		}
+	}
+	return oaiItem{}, false, nil
+}
+
+func (s *Server) oaiList(resp *oaiResponse, verb string, form url.Values, now time.Time) error {
+	st := oaiListState{Prefix: form.Get("metadataPrefix"), From: form.Get("from"), Until: form.Get("until")}
+	if token := form.Get("resumptionToken"); token != "" {
+		var err error
+		if st, err = parseOAIListState(token); err != nil {
+			resp.Errors = append(resp.Errors, oaiError{"badResumptionToken", "The resumption token is invalid"})
+			return nil
+		}
+	}
+	if st.Prefix != oaiDCPrefix {
+		resp.Errors = append(resp.Errors, oaiError{"cannotDisseminateFormat", "Only oai_dc is supported"})
+		return nil
+	}
+	if form.Get("set") != "" {
+		resp.Errors = append(resp.Errors, oaiError{"noSetHierarchy", "The repository does not support sets"})
+		return nil
+	}
+	var from, until time.Time
+	var err error
+	if st.From != "" {
+		if from, err = parseOAIDate(st.From, false); err != nil {
+			resp.Errors = append(resp.Errors, oaiError{"badArgument", "Invalid from date"})
+			return nil
+		}
+	}
+	if st.Until != "" {
+		if until, err = parseOAIDate(st.Until, true); err != nil {
+			resp.Errors = append(resp.Errors, oaiError{"badArgument", "Invalid until date"})
+			return nil
+		}
+	}
+	if st.From != "" && st.Until != "" && (len(st.From) != len(st.Until) || until.Before(from)) {
+		resp.Errors = append(resp.Errors, oaiError{"badArgument", "Invalid from and until dates"})
+		return nil
+	}
+
+	items, err := readOAIItems(s.db, now)
+	if err != nil {
+		return err
+	}
+	var matching []oaiItem
+	for _, item := range items {
+		if (st.From == "" || !item.datestamp.Before(from)) && (st.Until == "" || !item.datestamp.After(until)) {
+			matching = append(matching, item)
+		}
+	}
+	if len(matching) == 0 {
+		resp.Errors = append(resp.Errors, oaiError{"noRecordsMatch", "No records match the arguments"})
+		return nil
+	}
+	if st.Cursor >= len(matching) {
+		resp.Errors = append(resp.Errors, oaiError{"badResumptionToken", "The resumption token is invalid"})
+		return nil
+	}
+
+	end := st.Cursor + oaiPageSize
+	if end > len(matching) {
+		end = len(matching)
+	}
+	var token *oaiResumptionToken
+	if end < len(matching) || st.Cursor != 0 {
+		// The last response of an incomplete list has an empty token
+		token = &oaiResumptionToken{CompleteListSize: len(matching), Cursor: st.Cursor}
+		if end < len(matching) {
+			next := st
+			next.Cursor = end
+			token.Token = next.token()
+		}
+	}
+
+	page := matching[st.Cursor:end]
+	if verb == "ListIdentifiers" {
+		list := &oaiHeaderList{ResumptionToken: token}
+		for _, item := range page {
+			list.Headers = append(list.Headers, s.oaiRecord(item).Header)
+		}
+		resp.ListIdentifiers = list
+		return nil
+	}
+	list := &oaiRecordList{ResumptionToken: token}
+	for _, item := range page {
+		list.Records = append(list.Records, s.oaiRecord(item))
+	}
+	resp.ListRecords = list
+	return nil
+}
+
+func writeOAI(w http.ResponseWriter, resp oaiResponse) {
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(resp); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to Encode the OAI-PMH response")
+		return
+	}
+}
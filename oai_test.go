@@ -0,0 +1,127 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// oaiTestResponse decodes the parts of OAI-PMH responses checked by the tests.
+type oaiTestResponse struct {
+	Errors []struct {
+		Code string `xml:"code,attr"`
+	} `xml:"error"`
+	Identify struct {
+		RepositoryName string `xml:"repositoryName"`
+		BaseURL        string `xml:"baseURL"`
+	} `xml:"Identify"`
+	Records []struct {
+		Header struct {
+			Status     string `xml:"status,attr"`
+			Identifier string `xml:"identifier"`
+		} `xml:"header"`
+		Title   string `xml:"metadata>dc>title"`
+		Creator string `xml:"metadata>dc>creator"`
+	} `xml:"ListRecords>record"`
+	GetRecord []struct {
+		Identifier string `xml:"header>identifier"`
+		Title      string `xml:"metadata>dc>title"`
+	} `xml:"GetRecord>record"`
+	ResumptionToken string `xml:"ListRecords>resumptionToken"`
+}
+
+func harvest(t *testing.T, db *sql.DB, args url.Values) oaiTestResponse {
+	t.Helper()
+	response := createNewRequest(http.MethodGet, "/oai?"+args.Encode(), nil, db)
+	require.Equal(t, http.StatusOK, response.Code)
+	assertContentType(t, response, "text/xml; charset=utf-8", "Should get an xml response")
+	var got oaiTestResponse
+	require.NoError(t, xml.NewDecoder(response.Body).Decode(&got))
+	return got
+}
+
+func TestOAIPMH(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	for _, isbn := range []string{"1233211233215", "1233211233213", "1233211233210"} {
+		jsonBytes, _ := json.Marshal(Book{
+			ISBN:      isbn,
+			Title:     starWarsTitles[isbn],
+			Author:    &Author{FirstName: "george", LastName: "lucas"},
+			Publisher: "adlibris",
+		})
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code)
+	}
+	response := createNewRequest(http.MethodDelete, "/api/v1/books/1233211233210", nil, db)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	t.Run("Identify", func(t *testing.T) {
+		got := harvest(t, db, url.Values{"verb": {"Identify"}})
+		require.Empty(t, got.Errors)
+		require.Equal(t, "Library", got.Identify.RepositoryName)
+	})
+
+	t.Run("ListRecords includes deleted records", func(t *testing.T) {
+		got := harvest(t, db, url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"oai_dc"}})
+		require.Empty(t, got.Errors)
+		require.Len(t, got.Records, 3)
+		statuses := make(map[string]string)
+		for _, rec := range got.Records {
+			statuses[rec.Header.Identifier] = rec.Header.Status
+			if rec.Header.Status == "" {
+				require.Equal(t, starWarsTitles[strings.TrimPrefix(rec.Header.Identifier, "oai:library:")], rec.Title)
+				require.Equal(t, "lucas, george", rec.Creator)
+			}
+		}
+		require.Equal(t, "deleted", statuses["oai:library:1233211233210"])
+		require.Equal(t, "", statuses["oai:library:1233211233215"])
+	})
+
+	t.Run("ListRecords pages with resumption tokens", func(t *testing.T) {
+		defer func(size int) { oaiPageSize = size }(oaiPageSize)
+		oaiPageSize = 2
+
+		got := harvest(t, db, url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"oai_dc"}})
+		require.Len(t, got.Records, 2)
+		require.NotEmpty(t, got.ResumptionToken)
+
+		got = harvest(t, db, url.Values{"verb": {"ListRecords"}, "resumptionToken": {got.ResumptionToken}})
+		require.Empty(t, got.Errors)
+		require.Len(t, got.Records, 1)
+		require.Empty(t, got.ResumptionToken)
+	})
+
+	t.Run("GetRecord", func(t *testing.T) {
+		got := harvest(t, db, url.Values{"verb": {"GetRecord"}, "metadataPrefix": {"oai_dc"},
+			"identifier": {"oai:library:1233211233215"}})
+		require.Empty(t, got.Errors)
+		require.Len(t, got.GetRecord, 1)
+		require.Equal(t, "a new hope", got.GetRecord[0].Title)
+	})
+
+	for name, tc := range map[string]struct {
+		args url.Values
+		code string
+	}{
+		"Unknown verb":        {url.Values{"verb": {"Harvest"}}, "badVerb"},
+		"Missing argument":    {url.Values{"verb": {"ListRecords"}}, "badArgument"},
+		"Unknown format":      {url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"marc21"}}, "cannotDisseminateFormat"},
+		"Unknown record":      {url.Values{"verb": {"GetRecord"}, "metadataPrefix": {"oai_dc"}, "identifier": {"oai:library:1"}}, "idDoesNotExist"},
+		"Invalid token":       {url.Values{"verb": {"ListRecords"}, "resumptionToken": {"nope"}}, "badResumptionToken"},
+		"No matching records": {url.Values{"verb": {"ListRecords"}, "metadataPrefix": {"oai_dc"}, "until": {"2000-01-01"}}, "noRecordsMatch"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got := harvest(t, db, tc.args)
+			require.Len(t, got.Errors, 1)
+			require.Equal(t, tc.code, got.Errors[0].Code)
+		})
+	}
+}
@@ -0,0 +1,325 @@
+// Package oidc logs in users through an OpenID Connect provider, e.g.
+// Keycloak or Google, with the authorization code flow. Only ID tokens
+// signed with RS256 are accepted.
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is the leeway given when checking the expiry of an ID token.
+const clockSkew = time.Minute
+
+// Claims are the claims of a verified ID token.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+	// Raw holds every claim of the token, e.g. the groups of the user
+	Raw map[string]interface{}
+}
+
+// Strings returns a claim which is a string or a list of strings, e.g. the
+// groups of the user.
+func (c Claims) Strings(name string) []string {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// discovery is the part of the provider metadata which is used, see
+// https://openid.net/specs/openid-connect-discovery-1_0.html.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider which the library is registered
+// at as a client. The metadata and keys of the provider are fetched on first
+// use, and the keys are fetched again when a token is signed by an unknown
+// key.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // The callback of the library
+	Scopes       []string // Defaults to openid, email and profile
+	HTTPClient   *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// NewProvider creates a provider for the issuer, e.g.
+// https://keycloak.example.com/realms/library.
+func NewProvider(issuer, clientID, clientSecret, redirectURL string) *Provider {
+	return &Provider{Issuer: strings.TrimSuffix(issuer, "/"), ClientID: clientID,
+		ClientSecret: clientSecret, RedirectURL: redirectURL}
+}
+
+func (p *Provider) httpClient() *http.Client {
+	if p.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return p.HTTPClient
+}
+
+// getJSON fetches a JSON document.
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, u)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// metadata returns the metadata of the provider, fetching it once.
+func (p *Provider) metadata(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d discovery
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("oidc discovery err, %w", err)
+	}
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc discovery err, issuer %q does not match %q", d.Issuer, p.Issuer)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// AuthCodeURL returns the URL of the provider which the user is redirected
+// to, to log in. The state and nonce are checked on the callback.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.ClientID},
+		"redirect_uri":  {p.RedirectURL},
+		"scope":         {strings.Join(scopes, " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Login exchanges the authorization code of the callback for an ID token,
+// and verifies the token.
+func (p *Provider) Login(ctx context.Context, code, nonce string) (Claims, error) {
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.RedirectURL},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return Claims{}, fmt.Errorf("oidc token request err, %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return Claims{}, fmt.Errorf("oidc token request err, unexpected status %s: %s", resp.Status, msg)
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return Claims{}, fmt.Errorf("decode oidc token response err, %w", err)
+	}
+	if token.IDToken == "" {
+		return Claims{}, errors.New("oidc token response has no id_token")
+	}
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token and returns its claims.
+func (p *Provider) Verify(ctx context.Context, rawToken, nonce string) (Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, errors.New("malformed id token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("malformed id token header, %w", err)
+	}
+	if header.Alg != "RS256" {
+		return Claims{}, fmt.Errorf("unsupported id token algorithm %q", header.Alg)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("malformed id token signature, %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return Claims{}, errors.New("invalid id token signature")
+	}
+
+	var raw map[string]interface{}
+	dec := json.NewDecoder(base64.NewDecoder(base64.RawURLEncoding, strings.NewReader(parts[1])))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return Claims{}, fmt.Errorf("malformed id token claims, %w", err)
+	}
+	c := Claims{Raw: raw}
+	c.Issuer, _ = raw["iss"].(string)
+	c.Subject, _ = raw["sub"].(string)
+	c.Email, _ = raw["email"].(string)
+	c.EmailVerified, _ = raw["email_verified"].(bool)
+	c.GivenName, _ = raw["given_name"].(string)
+	c.FamilyName, _ = raw["family_name"].(string)
+	if c.Issuer != p.Issuer {
+		return Claims{}, fmt.Errorf("id token issuer %q does not match %q", c.Issuer, p.Issuer)
+	}
+	if c.Subject == "" {
+		return Claims{}, errors.New("id token has no subject")
+	}
+	if !contains(c.Strings("aud"), p.ClientID) {
+		return Claims{}, errors.New("id token is not issued to this client")
+	}
+	exp, ok := raw["exp"].(json.Number)
+	if !ok {
+		return Claims{}, errors.New("id token has no expiry")
+	}
+	expSeconds, err := exp.Int64()
+	if err != nil || time.Now().After(time.Unix(expSeconds, 0).Add(clockSkew)) {
+		return Claims{}, errors.New("id token has expired")
+	}
+	if tokenNonce, _ := raw["nonce"].(string); tokenNonce != nonce {
+		return Claims{}, errors.New("id token nonce does not match")
+	}
+	return c, nil
+}
+
+// key returns the signing key with the given id, fetching the keys of the
+// provider again if it is unknown.
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	d, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("oidc keys err, %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown id token key %q", kid)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
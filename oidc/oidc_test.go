@@ -0,0 +1,120 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sign creates an RS256 token of the claims.
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	var issuer string
+	var idToken string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/auth",
+			"token_endpoint":         issuer + "/token",
+			"jwks_uri":               issuer + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "library" || secret != "secret" || r.FormValue("code") != "the-code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	issuer = server.URL
+
+	p := NewProvider(issuer, "library", "secret", "https://library.example.com/api/v1/login/oidc/callback")
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": issuer, "sub": "42", "aud": "library", "exp": time.Now().Add(time.Hour).Unix(),
+			"nonce": "the-nonce", "email": "astrid@example.com", "email_verified": true,
+			"groups": []string{"librarians", "readers"},
+		}
+	}
+
+	t.Run("Redirects to the provider", func(t *testing.T) {
+		u, err := p.AuthCodeURL(context.Background(), "the-state", "the-nonce")
+		require.NoError(t, err)
+		parsed, err := url.Parse(u)
+		require.NoError(t, err)
+		require.Equal(t, "/auth", parsed.Path)
+		require.Equal(t, "the-state", parsed.Query().Get("state"))
+		require.Equal(t, "openid email profile", parsed.Query().Get("scope"))
+	})
+
+	t.Run("Logs in with the code", func(t *testing.T) {
+		idToken = sign(t, key, "1", claims())
+		c, err := p.Login(context.Background(), "the-code", "the-nonce")
+		require.NoError(t, err)
+		require.Equal(t, "42", c.Subject)
+		require.Equal(t, "astrid@example.com", c.Email)
+		require.True(t, c.EmailVerified)
+		require.Equal(t, []string{"librarians", "readers"}, c.Strings("groups"))
+
+		_, err = p.Login(context.Background(), "wrong-code", "the-nonce")
+		require.Error(t, err)
+	})
+
+	t.Run("Rejects invalid tokens", func(t *testing.T) {
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		for name, tc := range map[string]struct {
+			key   *rsa.PrivateKey
+			claim string
+			value interface{}
+			nonce string
+		}{
+			"wrong nonce":     {key, "", nil, "another-nonce"},
+			"wrong audience":  {key, "aud", "another-client", "the-nonce"},
+			"wrong issuer":    {key, "iss", "https://evil.example.com", "the-nonce"},
+			"expired":         {key, "exp", time.Now().Add(-time.Hour).Unix(), "the-nonce"},
+			"wrong signature": {otherKey, "", nil, "the-nonce"},
+		} {
+			c := claims()
+			if tc.claim != "" {
+				c[tc.claim] = tc.value
+			}
+			_, err := p.Verify(context.Background(), sign(t, tc.key, "1", c), tc.nonce)
+			require.Error(t, err, name)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/NicolaiMordrup/library/oidc"
+)
+
+// oidcLoginLifetime is how long a member has to log in at the provider.
+const oidcLoginLifetime = 10 * time.Minute
+
+// OIDCProvider is an OpenID Connect provider, e.g. an oidc.Provider.
+type OIDCProvider interface {
+	AuthCodeURL(ctx context.Context, state, nonce string) (string, error)
+	Login(ctx context.Context, code, nonce string) (oidc.Claims, error)
+}
+
+// OIDCLogin configures the login through an OpenID Connect provider. The
+// roles of a member are replaced on every login by the roles of the groups
+// in the ID token.
+type OIDCLogin struct {
+	Provider    OIDCProvider
+	GroupsClaim string            // Defaults to "groups"
+	GroupRoles  map[string]string // The role of the members of each group
+}
+
+// WithOIDCLogin lets members log in through an OpenID Connect provider.
+func WithOIDCLogin(l OIDCLogin) ServerOption {
+	return func(s *Server) {
+		s.oidcLogin = &l
+	}
+}
+
+// roles returns the roles of the groups in the claims.
+func (l *OIDCLogin) roles(c oidc.Claims) []string {
+	claim := l.GroupsClaim
+	if claim == "" {
+		claim = "groups"
+	}
+	var roles []string
+	for _, group := range c.Strings(claim) {
+		if role, ok := l.GroupRoles[group]; ok {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// oidcMember finds the member of the subject of the claims, and creates it
+// on the first login. A member who signed up with a password is linked to
+// the subject if the provider has verified their email address.
+func (s *Server) oidcMember(q Querier, c oidc.Claims) (Member, error) {
+	var memberID string
+	err := q.QueryRow("SELECT memberId FROM member_identity WHERE issuer = ? AND subject = ?", c.Issuer, c.Subject).Scan(&memberID)
+	if err == nil {
+		return s.FindMember(q, memberID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Member{}, fmt.Errorf("find identity err, %w", err)
+	}
+	if c.Email == "" {
+		return Member{}, &statusError{http.StatusForbidden, "The provider did not share an email address"}
+	}
+
+	m, err := s.FindMemberByEmail(q, c.Email)
+	switch {
+	case err == nil && !c.EmailVerified:
+		return Member{}, &statusError{http.StatusConflict, "A member with this email already exists"}
+	case errors.Is(err, errNoMember):
+		// Members of the provider have no password, so that they can only
+		// log in through the provider
+		m = Member{ID: s.idGenerator.NewID(), Email: c.Email, FirstName: c.GivenName, LastName: c.FamilyName,
+			EmailVerified: c.EmailVerified, CreateTime: time.Now()}
+		if err := s.InsertMember(q, m, ""); err != nil {
+			return Member{}, err
+		}
+	case err != nil:
+		return Member{}, err
+	}
+	_, err = q.Exec("INSERT INTO member_identity (issuer, subject, memberId) VALUES(?,?,?)", c.Issuer, c.Subject, m.ID)
+	if err != nil {
+		return Member{}, fmt.Errorf("insert identity err, %w", err)
+	}
+	return m, nil
+}
+
+// StartOIDCLogin redirects to the provider to log in. The provider redirects
+// back to OIDCCallback.
+func (s *Server) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if s.oidcLogin == nil {
+		HandleErr(w, http.StatusConflict, "No OIDC provider is configured")
+		return
+	}
+	state, err := randomToken()
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to start the login")
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to start the login")
+		return
+	}
+	authURL, err := s.oidcLogin.Provider.AuthCodeURL(r.Context(), state, nonce)
+	if err != nil {
+		handleErr("failed to reach the OIDC provider", err)
+		HandleErr(w, http.StatusBadGateway, "Failed to reach the OIDC provider")
+		return
+	}
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		now := time.Now()
+		if _, err := tx.Exec("DELETE FROM oidc_login WHERE expireTime <= ?", now.Unix()); err != nil {
+			return fmt.Errorf("delete expired logins err, %w", err)
+		}
+		_, err := tx.Exec("INSERT INTO oidc_login (state, nonce, expireTime) VALUES(?,?,?)",
+			state, nonce, now.Add(oidcLoginLifetime).Unix())
+		return err
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to start the login")
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a login at the provider and starts a session of the
+// member, whose roles are set from the groups of the ID token.
+func (s *Server) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if s.oidcLogin == nil {
+		HandleErr(w, http.StatusConflict, "No OIDC provider is configured")
+		return
+	}
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		HandleErr(w, http.StatusUnauthorized, "The login was not completed: "+strings.TrimSpace(e+" "+query.Get("error_description")))
+		return
+	}
+
+	// The state can only be used once
+	var nonce string
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		err := tx.QueryRow("SELECT nonce FROM oidc_login WHERE state = ? AND expireTime > ?", query.Get("state"), time.Now().Unix()).Scan(&nonce)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &statusError{http.StatusBadRequest, "The login is unknown or has expired, please log in again"}
+		}
+		if err != nil {
+			return fmt.Errorf("find login err, %w", err)
+		}
+		_, err = tx.Exec("DELETE FROM oidc_login WHERE state = ?", query.Get("state"))
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to complete the login")
+		return
+	}
+	claims, err := s.oidcLogin.Provider.Login(r.Context(), query.Get("code"), nonce)
+	if err != nil {
+		handleErr("failed to complete the OIDC login", err)
+		HandleErr(w, http.StatusUnauthorized, "The login at the provider could not be verified")
+		return
+	}
+
+	var session Session
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		m, err := s.oidcMember(tx, claims)
+		if err != nil {
+			return err
+		}
+		m.Roles = s.oidcLogin.roles(claims)
+		if err := setRoles(tx, m.ID, m.Roles); err != nil {
+			return err
+		}
+		session, err = s.startSession(tx, m)
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to start the session")
+		return
+	}
+	s.writeSessionCookie(w, session)
+	if err := json.NewEncoder(w).Encode(session); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the session")
+		return
+	}
+}
@@ -0,0 +1,111 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/oidc"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOIDCProvider logs in the code "good" with its claims.
+type fakeOIDCProvider struct {
+	claims oidc.Claims
+	nonces map[string]string // By state
+}
+
+func (f *fakeOIDCProvider) AuthCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	f.nonces[state] = nonce
+	return "https://idp.example.com/auth?" + url.Values{"state": {state}}.Encode(), nil
+}
+
+func (f *fakeOIDCProvider) Login(ctx context.Context, code, nonce string) (oidc.Claims, error) {
+	if code != "good" {
+		return oidc.Claims{}, errors.New("invalid code")
+	}
+	for _, n := range f.nonces {
+		if n == nonce {
+			return f.claims, nil
+		}
+	}
+	return oidc.Claims{}, errors.New("nonce does not match")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	provider := &fakeOIDCProvider{nonces: make(map[string]string)}
+	server := NewServer(db, WithOIDCLogin(OIDCLogin{
+		Provider:   provider,
+		GroupRoles: map[string]string{"librarians": RoleLibrarian},
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		return response
+	}
+	// login starts a login and returns the state of the callback
+	login := func() string {
+		t.Helper()
+		response := serve("/api/v1/login/oidc")
+		require.Equal(t, http.StatusFound, response.Code)
+		u, err := url.Parse(response.Header().Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, "idp.example.com", u.Host)
+		return u.Query().Get("state")
+	}
+	callback := func(state, code string) *httptest.ResponseRecorder {
+		return serve("/api/v1/login/oidc/callback?" + url.Values{"state": {state}, "code": {code}}.Encode())
+	}
+	provider.claims = oidc.Claims{Issuer: "https://idp.example.com", Subject: "42", Email: "astrid@example.com",
+		EmailVerified: true, GivenName: "Astrid", FamilyName: "Lindgren",
+		Raw: map[string]interface{}{"groups": []interface{}{"librarians", "readers"}}}
+
+	var first Session
+	t.Run("Creates the member on the first login", func(t *testing.T) {
+		response := callback(login(), "good")
+		require.Equal(t, http.StatusOK, response.Code)
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&first))
+		require.Equal(t, "astrid@example.com", first.Member.Email)
+		require.Equal(t, []string{RoleLibrarian}, first.Member.Roles)
+	})
+
+	t.Run("Maps the subject to the same member", func(t *testing.T) {
+		provider.claims.Raw = map[string]interface{}{"groups": "readers"}
+		response := callback(login(), "good")
+		require.Equal(t, http.StatusOK, response.Code)
+		var session Session
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&session))
+		require.Equal(t, first.Member.ID, session.Member.ID)
+		require.Empty(t, session.Member.Roles, "the roles should follow the groups of the latest login")
+	})
+
+	t.Run("Rejects unknown and reused states", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, callback("unknown", "good").Code)
+		state := login()
+		require.Equal(t, http.StatusOK, callback(state, "good").Code)
+		require.Equal(t, http.StatusBadRequest, callback(state, "good").Code)
+	})
+
+	t.Run("Rejects failed logins", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, callback(login(), "bad").Code)
+		require.Equal(t, http.StatusUnauthorized, serve("/api/v1/login/oidc/callback?error=access_denied").Code)
+	})
+
+	t.Run("Links members only by verified email", func(t *testing.T) {
+		provider.claims.Subject, provider.claims.EmailVerified = "43", false
+		require.Equal(t, http.StatusConflict, callback(login(), "good").Code)
+	})
+
+	t.Run("Requires a provider", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		NewServer(db).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v1/login/oidc", nil))
+		require.Equal(t, http.StatusConflict, response.Code)
+	})
+}
@@ -0,0 +1,172 @@
+// Package opensearch mirrors the books into an OpenSearch (or Elasticsearch)
+// index and searches it, using the REST API.
+package opensearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Document is a book in the index, identified by its ISBN.
+type Document struct {
+	ISBN        string `json:"isbn"`
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	Publisher   string `json:"publisher"`
+	Description string `json:"description"`
+	// The titles and descriptions of the translations of the book
+	TranslatedTitles       []string `json:"translatedTitles,omitempty"`
+	TranslatedDescriptions []string `json:"translatedDescriptions,omitempty"`
+}
+
+// Hit is a document which matched a search. Highlights are HTML snippets of
+// the matched fields with the matched terms in <em> tags.
+type Hit struct {
+	ISBN       string
+	Score      float64
+	Highlights map[string]string
+}
+
+// Client indexes and searches the documents of an index, e.g. "books" at
+// http://localhost:9200.
+type Client struct {
+	URL        string
+	Index      string
+	HTTPClient *http.Client // Defaults to http.DefaultClient
+}
+
+// NewClient creates a client for the index at the given URL.
+func NewClient(url, index string) *Client {
+	return &Client{URL: strings.TrimSuffix(url, "/"), Index: index}
+}
+
+// do sends a request to the index and decodes the JSON response into v.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+"/"+c.Index+path, body)
+	if err != nil {
+		return fmt.Errorf("create opensearch request err, %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("opensearch request err, %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("opensearch request err, unexpected status %s: %s", resp.Status, msg)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode opensearch response err, %w", err)
+	}
+	return nil
+}
+
+// Bulk indexes the documents and deletes the documents with the given ISBNs
+// in a single request. It fails if any of the actions failed, the actions
+// are idempotent so the whole request can be retried.
+func (c *Client) Bulk(ctx context.Context, docs []Document, deletes []string) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, d := range docs {
+		enc.Encode(map[string]interface{}{"index": map[string]string{"_id": d.ISBN}})
+		enc.Encode(d)
+	}
+	for _, isbn := range deletes {
+		enc.Encode(map[string]interface{}{"delete": map[string]string{"_id": isbn}})
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+	var res struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", &body, &res); err != nil {
+		return err
+	}
+	if !res.Errors {
+		return nil
+	}
+	for _, item := range res.Items {
+		for action, r := range item {
+			// Deleting a document which is not indexed is not an error
+			if r.Status/100 != 2 && !(action == "delete" && r.Status == http.StatusNotFound) {
+				return fmt.Errorf("opensearch bulk %s err, %s", action, r.Error.Reason)
+			}
+		}
+	}
+	return nil
+}
+
+// Search returns the documents matching all of the terms of the query, most
+// relevant first. Misspelled terms match with the fuzziness of OpenSearch.
+func (c *Client) Search(ctx context.Context, query string, limit int) ([]Hit, error) {
+	fields := []string{"title", "author", "description"}
+	highlight := make(map[string]interface{})
+	for _, f := range fields {
+		highlight[f] = map[string]interface{}{}
+	}
+	req := map[string]interface{}{
+		"size": limit,
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":     query,
+				"fields":    []string{"title^10", "translatedTitles^10", "author^5", "publisher", "description", "translatedDescriptions"},
+				"operator":  "and",
+				"fuzziness": "AUTO",
+			},
+		},
+		"highlight": map[string]interface{}{
+			"pre_tags":  []string{"<em>"},
+			"post_tags": []string{"</em>"},
+			"encoder":   "html",
+			"fields":    highlight,
+		},
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Hits struct {
+			Hits []struct {
+				ID        string              `json:"_id"`
+				Score     float64             `json:"_score"`
+				Highlight map[string][]string `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/_search", "application/json", bytes.NewReader(body), &res); err != nil {
+		return nil, err
+	}
+	hits := make([]Hit, len(res.Hits.Hits))
+	for i, h := range res.Hits.Hits {
+		hits[i] = Hit{ISBN: h.ID, Score: h.Score, Highlights: make(map[string]string)}
+		for field, fragments := range h.Highlight {
+			hits[i].Highlights[field] = strings.Join(fragments, "…")
+		}
+	}
+	return hits, nil
+}
+
+// DeleteAll deletes every document of the index, e.g. before reindexing.
+func (c *Client) DeleteAll(ctx context.Context) error {
+	body := strings.NewReader(`{"query":{"match_all":{}}}`)
+	var res struct{}
+	return c.do(ctx, http.MethodPost, "/_delete_by_query?refresh=true", "application/json", body, &res)
+}
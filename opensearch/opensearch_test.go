@@ -0,0 +1,83 @@
+package opensearch_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/opensearch"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	var path string
+	var lines []map[string]interface{}
+	bulkResponse := `{"errors": false, "items": []}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		lines = nil
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			lines = append(lines, line)
+		}
+		switch r.URL.Path {
+		case "/books/_bulk":
+			w.Write([]byte(bulkResponse))
+		case "/books/_search":
+			w.Write([]byte(`{"hits": {"hits": [
+				{"_id": "1233211233215", "_score": 2.5, "highlight": {"title": ["the <em>empire</em>"]}}
+			]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	c := opensearch.NewClient(srv.URL+"/", "books")
+	ctx := context.Background()
+
+	t.Run("Indexes and deletes documents in bulk", func(t *testing.T) {
+		err := c.Bulk(ctx, []opensearch.Document{{ISBN: "1233211233215", Title: "the empire"}}, []string{"1233211233213"})
+		require.NoError(t, err)
+		require.Equal(t, "/books/_bulk", path)
+		require.Len(t, lines, 3)
+		require.Equal(t, map[string]interface{}{"_id": "1233211233215"}, lines[0]["index"])
+		require.Equal(t, "the empire", lines[1]["title"])
+		require.Equal(t, map[string]interface{}{"_id": "1233211233213"}, lines[2]["delete"])
+	})
+
+	t.Run("Reports failed actions", func(t *testing.T) {
+		bulkResponse = `{"errors": true, "items": [
+			{"delete": {"status": 404}},
+			{"index": {"status": 400, "error": {"reason": "mapper_parsing_exception"}}}
+		]}`
+		err := c.Bulk(ctx, []opensearch.Document{{ISBN: "1233211233215"}}, []string{"1233211233213"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "mapper_parsing_exception")
+
+		bulkResponse = `{"errors": true, "items": [{"delete": {"status": 404}}]}`
+		require.NoError(t, c.Bulk(ctx, nil, []string{"1233211233213"}))
+	})
+
+	t.Run("Searches the documents", func(t *testing.T) {
+		hits, err := c.Search(ctx, "empire", 10)
+		require.NoError(t, err)
+		require.Equal(t, "/books/_search", path)
+		require.Equal(t, []opensearch.Hit{{
+			ISBN:       "1233211233215",
+			Score:      2.5,
+			Highlights: map[string]string{"title": "the <em>empire</em>"},
+		}}, hits)
+		require.EqualValues(t, 10, lines[0]["size"])
+	})
+
+	t.Run("Fails on unexpected statuses", func(t *testing.T) {
+		c := opensearch.NewClient(srv.URL, "missing")
+		_, err := c.Search(ctx, "empire", 10)
+		require.Error(t, err)
+	})
+}
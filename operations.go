@@ -0,0 +1,409 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NicolaiMordrup/library/events"
+	"github.com/gorilla/mux"
+)
+
+// The kinds of journaled operations.
+const (
+	OperationDelete     = "delete"
+	OperationBulkDelete = "bulk-delete"
+	OperationBatch      = "batch"
+	OperationImport     = "import"
+	OperationMerge      = "merge"
+)
+
+// operationIDHeader names the journal entry of a destructive operation in
+// its response, the id is used to undo the operation.
+const operationIDHeader = "X-Operation-ID"
+
+// defaultUndoWindow is how long an operation can be undone by default.
+const defaultUndoWindow = 24 * time.Hour
+
+// WithUndoWindow sets how long destructive operations can be undone. The
+// default is 24 hours.
+func WithUndoWindow(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.undoWindow = d
+	}
+}
+
+// Operation is a journaled operation which changed one or more books.
+type Operation struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"` // One of OperationDelete, OperationBulkDelete, OperationBatch, OperationImport or OperationMerge
+	ISBNs      []string   `json:"isbns"`
+	CreateTime time.Time  `json:"createTime"`
+	ExpireTime time.Time  `json:"expireTime"` // The operation can not be undone after this time
+	UndoTime   *time.Time `json:"undoTime,omitempty"`
+
+	changes []bookChange
+}
+
+// bookChange records the state of a book before and after an operation, a
+// nil book did not exist. A merged book also records what the merge changed.
+type bookChange struct {
+	ISBN   string        `json:"isbn"`
+	Before *Book         `json:"before,omitempty"`
+	After  *Book         `json:"after,omitempty"`
+	Merge  *mergeChanges `json:"merge,omitempty"`
+}
+
+// journal collects the changes of an operation while it runs.
+type journal struct {
+	q       Querier
+	changes []bookChange
+}
+
+// record records the change of the book with the given isbn. The before
+// image is passed in since the book has already been changed, the after
+// image is read back from the database.
+func (j *journal) record(isbn string, before Book) {
+	c := bookChange{ISBN: isbn}
+	if before.ISBN != "" {
+		c.Before = &before
+	}
+	if after := FindSpecificBook(j.q, isbn); after.ISBN != "" {
+		c.After = &after
+	}
+	j.changes = append(j.changes, c)
+}
+
+// recordMerge records the merge of the book with the given isbn, see record.
+func (j *journal) recordMerge(isbn string, before Book, merge mergeChanges) {
+	j.record(isbn, before)
+	j.changes[len(j.changes)-1].Merge = &merge
+}
+
+// InsertOperation stores an operation in the journal.
+func InsertOperation(db Querier, op Operation) error {
+	changes, err := json.Marshal(op.changes)
+	if err != nil {
+		return fmt.Errorf("marshal changes err, %w", err)
+	}
+	_, err = db.Exec("INSERT INTO operation (id, kind, changes, createTime) VALUES(?,?,?,?)",
+		op.ID, op.Kind, string(changes), op.CreateTime)
+	if err != nil {
+		return fmt.Errorf("insert operation err, %w", err)
+	}
+	return nil
+}
+
+// FindOperation reads an operation from the journal, sql.ErrNoRows is
+// returned if it does not exist.
+func FindOperation(db Querier, id string) (Operation, error) {
+	return scanOperation(db.QueryRow("SELECT id, kind, changes, createTime, undoTime FROM operation WHERE id = ?", id))
+}
+
+// ReadOperations reads the operations in the journal which were created
+// after since, newest first. Timestamps are stored as text, so they are
+// compared here rather than in the query, see pruneOperations.
+func ReadOperations(db Querier, since time.Time) ([]Operation, error) {
+	rows, err := db.Query("SELECT id, kind, changes, createTime, undoTime FROM operation ORDER BY rowid DESC")
+	if err != nil {
+		return nil, fmt.Errorf("query operations err, %w", err)
+	}
+	defer rows.Close()
+	ops := []Operation{}
+	for rows.Next() {
+		op, err := scanOperation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan operation err, %w", err)
+		}
+		if op.CreateTime.After(since) {
+			ops = append(ops, op)
+		}
+	}
+	return ops, rows.Err()
+}
+
+func scanOperation(row interface{ Scan(...interface{}) error }) (Operation, error) {
+	var op Operation
+	var changes string
+	var undoTime sql.NullTime
+	if err := row.Scan(&op.ID, &op.Kind, &changes, &op.CreateTime, &undoTime); err != nil {
+		return Operation{}, err
+	}
+	if err := json.Unmarshal([]byte(changes), &op.changes); err != nil {
+		return Operation{}, fmt.Errorf("unmarshal changes err, %w", err)
+	}
+	if undoTime.Valid {
+		op.UndoTime = &undoTime.Time
+	}
+	op.ISBNs = make([]string, len(op.changes))
+	for i, c := range op.changes {
+		op.ISBNs[i] = c.ISBN
+	}
+	return op, nil
+}
+
+// MarkOperationUndone records that an operation was undone.
+func MarkOperationUndone(db Querier, id string, undoTime time.Time) error {
+	if _, err := db.Exec("UPDATE operation SET undoTime = ? WHERE id = ?", undoTime, id); err != nil {
+		return fmt.Errorf("update operation err, %w", err)
+	}
+	return nil
+}
+
+// pruneOperations deletes the operations which can no longer be undone.
+// Timestamps are stored as text, so the expired operations are found here
+// rather than in the query.
+func pruneOperations(db Querier, expired time.Time) error {
+	rows, err := db.Query("SELECT id, createTime FROM operation")
+	if err != nil {
+		return fmt.Errorf("query operations err, %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		var createTime time.Time
+		if err := rows.Scan(&id, &createTime); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan operation err, %w", err)
+		}
+		if createTime.Before(expired) {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("query operations err, %w", err)
+	}
+	for _, id := range ids {
+		if _, err := db.Exec("DELETE FROM operation WHERE id = ?", id); err != nil {
+			return fmt.Errorf("delete operation err, %w", err)
+		}
+	}
+	return nil
+}
+
+// journalOperation stores the changes of an operation in the journal and
+// returns its id. Operations without changes are not journaled.
+func (s *Server) journalOperation(q Querier, kind string, j *journal) (string, error) {
+	if len(j.changes) == 0 {
+		return "", nil
+	}
+	now := time.Now()
+	if err := s.removeExpiredMerges(q, now.Add(-s.undoWindow)); err != nil {
+		return "", err
+	}
+	if err := pruneOperations(q, now.Add(-s.undoWindow)); err != nil {
+		return "", err
+	}
+	op := Operation{ID: s.idGenerator.NewID(), Kind: kind, CreateTime: now, changes: j.changes}
+	if err := InsertOperation(q, op); err != nil {
+		return "", err
+	}
+	return op.ID, nil
+}
+
+// removeExpiredMerges removes the original files of the merged books whose
+// merges are about to be pruned, since the merges can no longer be undone.
+// The files are kept until then, see unmergeBook.
+func (s *Server) removeExpiredMerges(q Querier, expired time.Time) error {
+	ops, err := ReadOperations(q, time.Time{})
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if op.Kind != OperationMerge || op.UndoTime != nil || !op.CreateTime.Before(expired) {
+			continue
+		}
+		for _, c := range op.changes {
+			if c.Merge == nil {
+				continue
+			}
+			if err := s.removeMergeOriginals(context.Background(), q, c.ISBN, *c.Merge); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// undoOperation restores the books changed by the operation to their state
+// before it. The changes are reverted last to first, and the undo fails if
+// any of the books has been changed since or is locked by another member
+// than the editor. An undone merge also moves the records of the merged
+// book back from the target, see unmergeBook.
+func (s *Server) undoOperation(q Querier, id, editorID string) (Operation, error) {
+	op, err := FindOperation(q, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Operation{}, &statusError{http.StatusNotFound, "The operation did not exist or can no longer be undone"}
+	}
+	if err != nil {
+		return Operation{}, err
+	}
+	op.ExpireTime = op.CreateTime.Add(s.undoWindow)
+	if op.UndoTime != nil {
+		return Operation{}, &statusError{http.StatusConflict, "The operation has already been undone"}
+	}
+	if time.Now().After(op.ExpireTime) {
+		return Operation{}, &statusError{http.StatusGone, "The operation can no longer be undone"}
+	}
+
+	for i := len(op.changes) - 1; i >= 0; i-- {
+		c := op.changes[i]
+		current := FindSpecificBook(q, c.ISBN)
+		if !sameVersion(current, c.After) {
+			return Operation{}, &statusError{http.StatusConflict,
+				fmt.Sprintf("The book %s has been changed since the operation", c.ISBN)}
+		}
+		if err := checkBookLock(q, c.ISBN, editorID, time.Now()); err != nil {
+			return Operation{}, err
+		}
+		if c.Merge != nil {
+			if err := checkBookLock(q, c.Merge.Target, editorID, time.Now()); err != nil {
+				return Operation{}, err
+			}
+		}
+		if current.ISBN != "" {
+			if err := DeleteBookFromDB(q, c.ISBN); err != nil {
+				return Operation{}, err
+			}
+		}
+		if c.Before == nil {
+			if err := InsertTombstone(q, c.ISBN, time.Now()); err != nil {
+				return Operation{}, err
+			}
+			if err := s.recordEvent(q, events.TypeBookDeleted, c.ISBN, nil); err != nil {
+				return Operation{}, err
+			}
+			continue
+		}
+		if current.ISBN == "" {
+			// The book was deleted by the operation and is added back
+			if err := s.checkBookQuota(q); err != nil {
+				return Operation{}, err
+			}
+		}
+		if err := InsertIntoDatabase(q, *c.Before); err != nil {
+			return Operation{}, err
+		}
+		if err := RemoveTombstone(q, c.ISBN); err != nil {
+			return Operation{}, err
+		}
+		typ := events.TypeBookUpdated
+		if current.ISBN == "" {
+			typ = events.TypeBookCreated
+		}
+		if err := s.recordEvent(q, typ, c.ISBN, c.Before); err != nil {
+			return Operation{}, err
+		}
+		if c.Merge != nil {
+			if err := unmergeBook(q, c.ISBN, *c.Merge, time.Now()); err != nil {
+				return Operation{}, err
+			}
+		}
+	}
+
+	now := time.Now()
+	if err := MarkOperationUndone(q, id, now); err != nil {
+		return Operation{}, err
+	}
+	op.UndoTime = &now
+	return op, nil
+}
+
+// sameVersion reports whether the current book is the one recorded after an
+// operation, a nil recorded book must not exist.
+func sameVersion(current Book, recorded *Book) bool {
+	if recorded == nil {
+		return current.ISBN == ""
+	}
+	return current.ISBN != "" &&
+		current.CreateTime.Equal(recorded.CreateTime) &&
+		current.UpdateTime.Equal(recorded.UpdateTime)
+}
+
+// GetOperation retrieves a journaled operation.
+func (s *Server) GetOperation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	op, err := FindOperation(s.db, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The operation did not exist or can no longer be undone")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the operation")
+		return
+	}
+	op.ExpireTime = op.CreateTime.Add(s.undoWindow)
+	if err := json.NewEncoder(w).Encode(op); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the operation")
+		return
+	}
+}
+
+// ListOperations retrieves the operations which are within the undo window,
+// newest first, so that an admin can undo the operations of any member.
+func (s *Server) ListOperations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	ops, err := ReadOperations(s.db, time.Now().Add(-s.undoWindow))
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the operations")
+		return
+	}
+	for i := range ops {
+		ops[i].ExpireTime = ops[i].CreateTime.Add(s.undoWindow)
+	}
+	if err := json.NewEncoder(w).Encode(ops); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the operations")
+		return
+	}
+}
+
+// AdminUndoOperation lets an admin undo any operation in the undo window,
+// see UndoOperation.
+func (s *Server) AdminUndoOperation(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.adminMember(r); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	s.UndoOperation(w, r)
+}
+
+// UndoOperation undoes a journaled operation within the undo window by
+// restoring the books it changed. Books which are locked by another member
+// are not restored, see LockBook.
+func (s *Server) UndoOperation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	editor, err := s.bookEditor(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var op Operation
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		var err error
+		op, err = s.undoOperation(tx, mux.Vars(r)["id"], editor.ID)
+		return err
+	})
+	if err != nil {
+		handleBookErr(w, err)
+		return
+	}
+	for _, c := range op.changes {
+		if c.Merge != nil {
+			s.removeMergeCopies(r.Context(), *c.Merge)
+		}
+	}
+	if err := json.NewEncoder(w).Encode(op); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the operation")
+		return
+	}
+}
@@ -0,0 +1,122 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndoOperation(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	createBook := func(isbn string) {
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: starWarsTitles[isbn],
+			Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "withdrawn",
+			Translations: []Translation{{Language: "sv", Title: "stjärnornas krig"}}})
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+	}
+	undo := func(id string) *httptest.ResponseRecorder {
+		return createNewRequest(http.MethodPost, "/api/v1/operations/"+id+":undo", nil, db)
+	}
+	createBook("1233211233215")
+	createBook("1233211233213")
+
+	t.Run("Undoes a delete", func(t *testing.T) {
+		before := FindSpecificBook(db, "1233211233215")
+		response := createNewRequest(http.MethodDelete, "/api/v1/books/1233211233215", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		id := response.Header().Get(operationIDHeader)
+		require.NotEmpty(t, id)
+
+		response = undo(id)
+		require.Equal(t, http.StatusOK, response.Code)
+		var op Operation
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&op))
+		require.Equal(t, OperationDelete, op.Kind)
+		require.Equal(t, []string{"1233211233215"}, op.ISBNs)
+		require.NotNil(t, op.UndoTime)
+
+		restored := FindSpecificBook(db, "1233211233215")
+		require.Equal(t, before.ID, restored.ID)
+		require.True(t, before.CreateTime.Equal(restored.CreateTime))
+		require.Equal(t, before.Translations, restored.Translations)
+		tombstones, err := ReadTombstones(db)
+		require.NoError(t, err)
+		require.Empty(t, tombstones)
+
+		require.Equal(t, http.StatusConflict, undo(id).Code)
+	})
+
+	t.Run("Undoes a bulk delete", func(t *testing.T) {
+		response := createNewRequest(http.MethodDelete, "/api/v1/books?publisher=withdrawn&confirm=true", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var res BulkDeleteResult
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		require.Empty(t, ReadDatabaseList(db))
+
+		require.Equal(t, http.StatusOK, undo(res.OperationID).Code)
+		require.Len(t, ReadDatabaseList(db), 2)
+	})
+
+	t.Run("Undoes a batch", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal([]BatchOperation{
+			{Method: "delete", ISBN: "1233211233213"},
+			{Method: "create", ISBN: "1233211233210", Book: &Book{ISBN: "1233211233210",
+				Title: starWarsTitles["1233211233210"], Author: &Author{FirstName: "george", LastName: "lucas"},
+				Publisher: "adlibris"}},
+		})
+		response := createNewRequest(http.MethodPost, "/api/v1/books:batch", jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		require.Equal(t, http.StatusOK, undo(response.Header().Get(operationIDHeader)).Code)
+		require.NotEmpty(t, FindSpecificBook(db, "1233211233213").ISBN)
+		require.Empty(t, FindSpecificBook(db, "1233211233210").ISBN)
+	})
+
+	t.Run("Refuses to undo when the book was changed since", func(t *testing.T) {
+		response := createNewRequest(http.MethodDelete, "/api/v1/books/1233211233213", nil, db)
+		id := response.Header().Get(operationIDHeader)
+		createBook("1233211233213")
+		require.Equal(t, http.StatusConflict, undo(id).Code)
+	})
+
+	t.Run("Refuses to undo after the undo window", func(t *testing.T) {
+		response := createNewRequest(http.MethodDelete, "/api/v1/books/1233211233215", nil, db)
+		id := response.Header().Get(operationIDHeader)
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/operations/"+id+":undo", nil)
+		recorder := httptest.NewRecorder()
+		NewServer(db, WithUndoWindow(time.Nanosecond)).ServeHTTP(recorder, request)
+		require.Equal(t, http.StatusGone, recorder.Code)
+	})
+
+	t.Run("Returns 404 for unknown operations", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, undo("unknown").Code)
+	})
+
+	t.Run("Lets admins list and undo the operations of anyone", func(t *testing.T) {
+		admin, librarian := createMemberSession(t, db, "admin", RoleAdmin), createMemberSession(t, db, "librarian", RoleLibrarian)
+		response := createNewRequest(http.MethodDelete, "/api/v1/books/1233211233213", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		id := response.Header().Get(operationIDHeader)
+
+		require.Equal(t, http.StatusUnauthorized, createNewRequest(http.MethodGet, "/api/admin/operations", nil, db).Code)
+		require.Equal(t, http.StatusForbidden, createNewMemberRequest(http.MethodGet, "/api/admin/operations", nil, db, librarian).Code)
+		response = createNewMemberRequest(http.MethodGet, "/api/admin/operations", nil, db, admin)
+		require.Equal(t, http.StatusOK, response.Code)
+		var ops []Operation
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&ops))
+		require.Equal(t, id, ops[0].ID)
+		require.Equal(t, OperationDelete, ops[0].Kind)
+		require.True(t, ops[0].ExpireTime.After(time.Now()))
+
+		path := "/api/admin/operations/" + id + ":undo"
+		require.Equal(t, http.StatusForbidden, createNewMemberRequest(http.MethodPost, path, nil, db, librarian).Code)
+		require.Equal(t, http.StatusOK, createNewMemberRequest(http.MethodPost, path, nil, db, admin).Code)
+		require.Equal(t, "1233211233213", FindSpecificBook(db, "1233211233213").ISBN)
+	})
+}
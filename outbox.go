@@ -0,0 +1,115 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/NicolaiMordrup/library/events"
+)
+
+// WithEventOutbox writes an event for every change of a book to the outbox,
+// in the transaction of the change. The events are published by an
+// OutboxDispatcher, so that no event is lost if the message bus is down
+// during the change.
+func WithEventOutbox() ServerOption {
+	return func(s *Server) {
+		s.recordEvents = true
+	}
+}
+
+// recordEvent writes an event of the given type to the outbox if the server
+// records events. book is the book after the change, nil if it was deleted.
+func (s *Server) recordEvent(q Querier, typ, isbn string, book *Book) error {
+	if !s.recordEvents {
+		return nil
+	}
+	var data []byte
+	if book != nil {
+		var err error
+		if data, err = json.Marshal(book); err != nil {
+			return fmt.Errorf("encode event err, %w", err)
+		}
+	}
+	_, err := q.Exec("INSERT INTO event_outbox (type, subject, data, createTime) VALUES(?,?,?,?)",
+		typ, isbn, data, time.Now())
+	if err != nil {
+		return fmt.Errorf("insert event err, %w", err)
+	}
+	return nil
+}
+
+// readOutbox reads the oldest events of the outbox.
+func readOutbox(db Querier, limit int) ([]events.Event, error) {
+	rows, err := db.Query("SELECT id, type, subject, data, createTime FROM event_outbox ORDER BY id LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("query outbox err, %w", err)
+	}
+	defer rows.Close()
+	var outbox []events.Event
+	for rows.Next() {
+		e := events.Event{Version: events.SchemaVersion}
+		var data []byte
+		if err := rows.Scan(&e.ID, &e.Type, &e.Subject, &data, &e.Time); err != nil {
+			return nil, fmt.Errorf("scan outbox err, %w", err)
+		}
+		if len(data) != 0 {
+			e.Data = data
+		}
+		outbox = append(outbox, e)
+	}
+	return outbox, rows.Err()
+}
+
+// OutboxDispatcher publishes the events of the outbox every Interval, in the
+// order of the changes. An event is removed from the outbox once it has been
+// published, a failed event is retried on the next run before any later
+// event is published.
+type OutboxDispatcher struct {
+	Publisher events.Publisher
+	Interval  time.Duration
+	BatchSize int // Defaults to 100
+	// OnError is called when an event could not be published, e.g. to alert
+	// that the message bus is down.
+	OnError func(error)
+}
+
+// Run publishes the events until the context is done.
+func (d OutboxDispatcher) Run(ctx context.Context, db Querier) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		if err := d.Flush(ctx, db); err != nil && d.OnError != nil {
+			d.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush publishes the events until the outbox is empty. An event may be
+// published twice if it could not be removed after it was published.
+func (d OutboxDispatcher) Flush(ctx context.Context, db Querier) error {
+	size := d.BatchSize
+	if size == 0 {
+		size = 100
+	}
+	for {
+		outbox, err := readOutbox(db, size)
+		if err != nil || len(outbox) == 0 {
+			return err
+		}
+		for _, e := range outbox {
+			if err := d.Publisher.Publish(ctx, e); err != nil {
+				return err
+			}
+			if _, err := db.Exec("DELETE FROM event_outbox WHERE id = ?", e.ID); err != nil {
+				return fmt.Errorf("delete from outbox err, %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/events"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePublisher keeps the published events in memory.
+type fakePublisher struct {
+	published []events.Event
+	err       error
+}
+
+func (f *fakePublisher) Publish(ctx context.Context, e events.Event) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.published = append(f.published, e)
+	return nil
+}
+
+func TestEventOutbox(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	publisher := &fakePublisher{}
+	dispatcher := OutboxDispatcher{Publisher: publisher}
+	server := NewServer(db, WithEventOutbox(), WithMinDurationBetweenUpdates(0))
+	serve := func(method, path string, body []byte) int {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		return response.Code
+	}
+
+	isbn := "1233211233215"
+	book := Book{ISBN: isbn, Title: starWarsTitles[isbn],
+		Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "lucasfilm"}
+	jsonBytes, _ := json.Marshal(book)
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes))
+	book.Title = "star wars"
+	jsonBytes, _ = json.Marshal(book)
+	require.Equal(t, http.StatusOK, serve(http.MethodPut, "/api/v1/books/"+isbn, jsonBytes))
+
+	t.Run("Keeps the events while the message bus is down", func(t *testing.T) {
+		publisher.err = errors.New("unavailable")
+		require.Error(t, dispatcher.Flush(context.Background(), db))
+		outbox, err := readOutbox(db, 10)
+		require.NoError(t, err)
+		require.Len(t, outbox, 2)
+	})
+
+	t.Run("Publishes the events in order", func(t *testing.T) {
+		publisher.err = nil
+		require.Equal(t, http.StatusOK, serve(http.MethodDelete, "/api/v1/books/"+isbn, nil))
+		require.NoError(t, dispatcher.Flush(context.Background(), db))
+
+		var types []string
+		for _, e := range publisher.published {
+			require.Equal(t, isbn, e.Subject)
+			types = append(types, e.Type)
+		}
+		require.Equal(t, []string{events.TypeBookCreated, events.TypeBookUpdated, events.TypeBookDeleted}, types)
+
+		var updated Book
+		require.NoError(t, json.Unmarshal(publisher.published[1].Data, &updated))
+		require.Equal(t, "star wars", updated.Title)
+		require.Nil(t, publisher.published[2].Data)
+
+		outbox, err := readOutbox(db, 10)
+		require.NoError(t, err)
+		require.Empty(t, outbox)
+	})
+
+	t.Run("Writes no events unless enabled", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(book)
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+		outbox, err := readOutbox(db, 10)
+		require.NoError(t, err)
+		require.Empty(t, outbox)
+	})
+}
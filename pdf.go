@@ -0,0 +1,114 @@
+package library
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// The fonts of the PDF documents, which are built into every PDF reader.
+const (
+	pdfFontRegular = "F1" // Helvetica
+	pdfFontBold    = "F2" // Helvetica-Bold
+)
+
+// pdfDocument is a minimal PDF writer for printing text and filled
+// rectangles. Coordinates are in points from the bottom left corner of the
+// page.
+type pdfDocument struct {
+	width, height float64
+	pages         []*bytes.Buffer
+}
+
+func newPDFDocument(width, height float64) *pdfDocument {
+	return &pdfDocument{width: width, height: height}
+}
+
+// addPage starts a new page, which the following drawing goes to.
+func (d *pdfDocument) addPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *pdfDocument) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+// text draws a line of text with its baseline starting at x, y.
+func (d *pdfDocument) text(font string, size, x, y float64, s string) {
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfString(s))
+}
+
+// rect draws a filled black rectangle.
+func (d *pdfDocument) rect(x, y, w, h float64) {
+	fmt.Fprintf(d.page(), "%.2f %.2f %.2f %.2f re f\n", x, y, w, h)
+}
+
+// textWidth estimates the width of a text, from the average width of the
+// characters of Helvetica.
+func textWidth(size float64, s string) float64 {
+	return float64(len([]rune(s))) * size * 0.55
+}
+
+// fitText shortens a text with an ellipsis until it fits in the width.
+func fitText(size, width float64, s string) string {
+	runes := []rune(s)
+	if textWidth(size, s) <= width {
+		return s
+	}
+	for len(runes) > 0 && textWidth(size, string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "..."
+}
+
+// pdfEncoder converts the text to the WinAnsi encoding of the fonts.
+var pdfEncoder = encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder())
+
+// pdfString escapes a text for a PDF string literal.
+func pdfString(s string) string {
+	encoded, err := pdfEncoder.String(s)
+	if err != nil {
+		encoded = s
+	}
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`, "\r", " ", "\n", " ").Replace(encoded)
+}
+
+// bytes returns the PDF file.
+func (d *pdfDocument) bytes() []byte {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(format string, args ...interface{}) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&buf, format, args...)
+		buf.WriteString("\nendobj\n")
+	}
+
+	// The catalog, the page tree and the fonts are objects 1 to 4, and every
+	// page is followed by its content
+	buf.WriteString("%PDF-1.4\n")
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, content := range d.pages {
+		object("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> /Contents %d 0 R >>",
+			d.width, d.height, pdfFontRegular, pdfFontBold, 6+2*i)
+		object("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String())
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
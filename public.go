@@ -0,0 +1,214 @@
+package library
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sitemapSize is the largest number of URLs which search engines accept in
+// a sitemap. A larger catalogue is split into pages listed by a sitemap
+// index.
+const sitemapSize = 50000
+
+// bookPage is the public page of a book, parsed together with its layout.
+var bookPage = template.Must(template.ParseFS(uiTemplates, "ui/templates/public.html", "ui/templates/public_book.html"))
+
+// schemaFormats maps the formats of the books to the BookFormatType of
+// schema.org.
+var schemaFormats = map[string]string{
+	FormatHardcover: "https://schema.org/Hardcover",
+	FormatPaperback: "https://schema.org/Paperback",
+	FormatEbook:     "https://schema.org/EBook",
+	FormatAudiobook: "https://schema.org/AudiobookFormat",
+}
+
+type schemaThing struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type schemaRating struct {
+	Type        string  `json:"@type"`
+	RatingValue float64 `json:"ratingValue"`
+	RatingCount int     `json:"ratingCount"`
+	BestRating  int     `json:"bestRating"`
+	WorstRating int     `json:"worstRating"`
+}
+
+// schemaBook is the schema.org Book of a book, embedded in its public page
+// as JSON-LD.
+type schemaBook struct {
+	Context         string        `json:"@context"`
+	Type            string        `json:"@type"`
+	ID              string        `json:"@id"`
+	URL             string        `json:"url"`
+	Name            string        `json:"name"`
+	ISBN            string        `json:"isbn"`
+	Author          *schemaThing  `json:"author,omitempty"`
+	Publisher       *schemaThing  `json:"publisher,omitempty"`
+	DatePublished   string        `json:"datePublished,omitempty"`
+	InLanguage      string        `json:"inLanguage,omitempty"`
+	NumberOfPages   int           `json:"numberOfPages,omitempty"`
+	BookFormat      string        `json:"bookFormat,omitempty"`
+	Description     string        `json:"description,omitempty"`
+	AggregateRating *schemaRating `json:"aggregateRating,omitempty"`
+}
+
+// schemaBookFromBook maps a book to schema.org, url is the public page of
+// the book. The rating is left out when the book has no reviews.
+func schemaBookFromBook(b Book, url string, rating float64, ratings int) schemaBook {
+	sb := schemaBook{
+		Context:       "https://schema.org",
+		Type:          "Book",
+		ID:            url,
+		URL:           url,
+		Name:          b.Title,
+		ISBN:          b.ISBN,
+		InLanguage:    b.Language,
+		NumberOfPages: b.PageCount,
+		BookFormat:    schemaFormats[b.Format],
+		Description:   b.Description,
+	}
+	if name := authorName(b.Author); name != "" {
+		sb.Author = &schemaThing{Type: "Person", Name: name}
+	}
+	if b.Publisher != "" {
+		sb.Publisher = &schemaThing{Type: "Organization", Name: b.Publisher}
+	}
+	if b.PublicationYear != 0 {
+		sb.DatePublished = strconv.Itoa(b.PublicationYear)
+	}
+	if ratings != 0 {
+		sb.AggregateRating = &schemaRating{Type: "AggregateRating", RatingValue: rating, RatingCount: ratings,
+			BestRating: 5, WorstRating: 1}
+	}
+	return sb
+}
+
+// authorName returns the full name of an author, "" if it is unknown.
+func authorName(a *Author) string {
+	switch {
+	case a == nil:
+		return ""
+	case a.FirstName == "":
+		return a.LastName
+	case a.LastName == "":
+		return a.FirstName
+	}
+	return a.FirstName + " " + a.LastName
+}
+
+// publicBookPage is the data of the public page of a book.
+type publicBookPage struct {
+	Book           Book
+	Author         string
+	URL            string
+	Rating         float64
+	Ratings        int
+	StructuredData template.JS
+}
+
+// GetBookPage is the public HTML page of a book, which search engines index.
+// The page embeds the book as a schema.org Book in JSON-LD. Drafts and
+// embargoed books are not found, and merged books redirect to the book they
+// were merged into.
+func (s *Server) GetBookPage(w http.ResponseWriter, r *http.Request) {
+	isbn := mux.Vars(r)["isbn"]
+	book := FindPublicBook(s.db, isbn, time.Now())
+	if book.ISBN == "" {
+		if s.redirectMergedBook(w, r, isbn) {
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	rating, ratings, err := ReadRating(s.db, isbn)
+	if err != nil {
+		handleErr("Failed to read the rating of the book", err)
+	}
+	page := publicBookPage{Book: book, Author: authorName(book.Author), URL: externalURL(r, "/books/"+isbn),
+		Rating: rating, Ratings: ratings}
+	// json escapes <, > and &, so the JSON cannot end the script element
+	data, err := json.Marshal(schemaBookFromBook(book, page.URL, rating, ratings))
+	if err != nil {
+		handleErr("Failed to encode the structured data of the book", err)
+	}
+	page.StructuredData = template.JS(data)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := bookPage.ExecuteTemplate(w, "public.html", page); err != nil {
+		handleErr("Failed to render the book page", err)
+	}
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// GetSitemap lists the public pages of the public books for search engines,
+// with the date of the latest change of each book. When there are more books
+// than fit in a sitemap the response is a sitemap index of the pages
+// /sitemap.xml?page=1, 2 and so on.
+func (s *Server) GetSitemap(w http.ResponseWriter, r *http.Request) {
+	books := ReadPublicBookList(s.db, time.Now())
+	pages := (len(books) + sitemapSize - 1) / sitemapSize
+	var res interface{}
+	switch p := r.URL.Query().Get("page"); {
+	case p == "" && pages > 1:
+		index := sitemapIndex{}
+		for i := 1; i <= pages; i++ {
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: externalURL(r, "/sitemap.xml?page="+strconv.Itoa(i))})
+		}
+		res = index
+	default:
+		page := 1
+		if p != "" {
+			var err error
+			if page, err = strconv.Atoi(p); err != nil || page < 1 || (page > pages && page != 1) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		start := (page - 1) * sitemapSize
+		end := start + sitemapSize
+		if end > len(books) {
+			end = len(books)
+		}
+		set := sitemapURLSet{URLs: []sitemapURL{}}
+		for _, b := range books[start:end] {
+			set.URLs = append(set.URLs, sitemapURL{
+				Loc:     externalURL(r, "/books/"+b.ISBN),
+				LastMod: oaiDatestamp(b).Format("2006-01-02"),
+			})
+		}
+		res = set
+	}
+
+	w.Header().Set("Content-Type", xmlContentType+"; charset=utf-8")
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		handleErr("Failed to encode the sitemap", err)
+	}
+}
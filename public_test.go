@@ -0,0 +1,61 @@
+package library
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublicBookPages(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	isbn := "9789129688313"
+	jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: "Pippi Langstrump", PublicationYear: 1945,
+		Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben", Format: FormatHardcover,
+		Description: "</script><b>bold</b>"})
+	require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+	draft, _ := json.Marshal(Book{ISBN: "9789129657470", Title: "Draft", Status: StatusDraft})
+	require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/9789129657470", draft, db).Code)
+
+	t.Run("Embeds the book as schema.org JSON-LD", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/books/"+isbn, nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		assertContentType(t, response, "text/html; charset=utf-8", "Should get an html page")
+		body := response.Body.String()
+		require.Contains(t, body, `<script type="application/ld+json">`)
+		require.Contains(t, body, `"@type":"Book"`)
+		require.Contains(t, body, `"author":{"@type":"Person","name":"Astrid Lindgren"}`)
+		require.Contains(t, body, `"bookFormat":"https://schema.org/Hardcover"`)
+		require.Contains(t, body, `\u003c/script\u003e`, "The description should not end the script")
+		require.NotContains(t, body, "<b>bold</b>")
+	})
+
+	t.Run("Does not show drafts", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, createNewRequest(http.MethodGet, "/books/9789129657470", nil, db).Code)
+	})
+
+	t.Run("Lists the public books in the sitemap", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/sitemap.xml", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var set sitemapURLSet
+		require.NoError(t, xml.NewDecoder(response.Body).Decode(&set))
+		require.Len(t, set.URLs, 1)
+		require.True(t, strings.HasSuffix(set.URLs[0].Loc, "/books/"+isbn), set.URLs[0].Loc)
+		require.Len(t, set.URLs[0].LastMod, len("2006-01-02"))
+		require.Equal(t, http.StatusNotFound, createNewRequest(http.MethodGet, "/sitemap.xml?page=2", nil, db).Code)
+	})
+}
+
+func TestSchemaBookFromBook(t *testing.T) {
+	sb := schemaBookFromBook(Book{ISBN: "9789129688313", Title: "Edda"}, "http://example.com/books/9789129688313", 0, 0)
+	require.Nil(t, sb.Author)
+	require.Nil(t, sb.AggregateRating)
+	sb = schemaBookFromBook(Book{Author: &Author{LastName: "Snorri"}}, "", 4.5, 2)
+	require.Equal(t, "Snorri", sb.Author.Name)
+	require.Equal(t, 4.5, sb.AggregateRating.RatingValue)
+}
@@ -0,0 +1,96 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// scheduledDrafts reads the drafts which have a publication time, the
+// earliest first. The times are compared in Go since the driver does not
+// store them in an order which sorts.
+func scheduledDrafts(db Querier) []Book {
+	scheduled := []Book{}
+	for _, b := range ReadDatabaseList(db) {
+		if b.Status == StatusDraft && b.PublishAt != nil {
+			scheduled = append(scheduled, b)
+		}
+	}
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		return scheduled[i].PublishAt.Before(*scheduled[j].PublishAt)
+	})
+	return scheduled
+}
+
+// publishDue publishes the scheduled drafts whose publication time has
+// passed and returns how many were published. Each draft is published in a
+// transaction of its own, after checking that it is still due, so that a
+// draft which was edited meanwhile is not published by mistake. A draft which
+// can not be published is reported to onError, if set, and does not hold
+// back the drafts after it.
+func (s *Server) publishDue(ctx context.Context, now time.Time, onError func(error)) int {
+	published := 0
+	for _, b := range scheduledDrafts(s.db) {
+		if b.PublishAt.After(now) || ctx.Err() != nil {
+			break
+		}
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			current := FindSpecificBook(tx, b.ISBN)
+			if current.Status != StatusDraft || current.PublishAt == nil || current.PublishAt.After(now) {
+				return nil
+			}
+			if _, err := s.changeStatus(tx, b.ISBN, StatusPublished, now); err != nil {
+				return err
+			}
+			published++
+			return nil
+		})
+		if err != nil && onError != nil {
+			onError(fmt.Errorf("publish %s err, %w", b.ISBN, err))
+		}
+	}
+	return published
+}
+
+// PublicationScheduler publishes the scheduled drafts every Interval. The
+// schedule is kept in the database, so drafts which became due while the
+// server was down are published on the first run after a restart.
+type PublicationScheduler struct {
+	Interval time.Duration
+	// OnError is called when a draft could not be published, e.g. because
+	// it became invalid after it was scheduled.
+	OnError func(error)
+}
+
+// Run publishes the due drafts until the context is done.
+func (p PublicationScheduler) Run(ctx context.Context, s *Server) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		s.publishDue(ctx, time.Now(), p.OnError)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListScheduledPublications lists the drafts which are scheduled to be
+// published, the earliest first. Only librarians and admins can see the
+// drafts.
+func (s *Server) ListScheduledPublications(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.librarianMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(scheduledDrafts(s.db)); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the scheduled publications")
+		return
+	}
+}
@@ -0,0 +1,77 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduledPublication(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	server := NewServer(db)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	due, later := now.Add(-time.Hour), now.Add(time.Hour)
+	book := func(isbn, title string, publishAt *time.Time) Book {
+		return Book{ISBN: isbn, Title: title, Status: StatusDraft, PublishAt: publishAt,
+			Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben"}
+	}
+
+	t.Run("Only schedules complete drafts", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(Book{ISBN: "9789129657470", Title: "Lejonhjarta", Status: StatusDraft, PublishAt: &due})
+		require.Equal(t, http.StatusNotAcceptable, createNewRequest(http.MethodPost, "/api/v1/books/9789129657470", jsonBytes, db).Code)
+
+		published := book("9789129657470", "Lejonhjarta", &due)
+		published.Status = StatusPublished
+		jsonBytes, _ = json.Marshal(published)
+		require.Equal(t, http.StatusNotAcceptable, createNewRequest(http.MethodPost, "/api/v1/books/9789129657470", jsonBytes, db).Code)
+	})
+
+	invalid := due.Add(-time.Hour)
+	for _, b := range []Book{book("9789129688313", "Pippi Langstrump", &due), book("9789129657470", "Lejonhjarta", &later),
+		book("1233211233215", "Mio min Mio", &invalid)} {
+		jsonBytes, _ := json.Marshal(b)
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+b.ISBN, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	}
+
+	t.Run("Lists the scheduled drafts", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, createNewRequest(http.MethodGet, "/api/v1/admin/publications", nil, db).Code)
+		librarian := createMemberSession(t, db, "astrid", RoleLibrarian)
+		response := createNewMemberRequest(http.MethodGet, "/api/v1/admin/publications", nil, db, librarian)
+		require.Equal(t, http.StatusOK, response.Code)
+		var scheduled []Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&scheduled))
+		require.Len(t, scheduled, 3)
+		require.Equal(t, "1233211233215", scheduled[0].ISBN)
+	})
+
+	t.Run("Publishes the due drafts", func(t *testing.T) {
+		// A draft which became invalid after it was scheduled
+		_, err := db.Exec("UPDATE library SET publisher = '' WHERE isbn = '1233211233215'")
+		require.NoError(t, err)
+		var errs []error
+		n := server.publishDue(context.Background(), now, func(err error) { errs = append(errs, err) })
+		require.Equal(t, 1, n, "the invalid draft should not hold back the others")
+		require.Len(t, errs, 1)
+		require.Contains(t, errs[0].Error(), "1233211233215")
+		require.Equal(t, StatusDraft, FindSpecificBook(db, "1233211233215").Status)
+
+		b := FindSpecificBook(db, "9789129688313")
+		require.Equal(t, StatusPublished, b.Status)
+		require.Nil(t, b.PublishAt)
+		require.Equal(t, StatusDraft, FindSpecificBook(db, "9789129657470").Status)
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodGet, "/api/v1/books/9789129688313", nil, db).Code)
+	})
+
+	t.Run("Publishes drafts which became due while the server was down", func(t *testing.T) {
+		n := NewServer(db).publishDue(context.Background(), later.Add(time.Minute), nil)
+		require.Equal(t, 1, n)
+		require.Equal(t, StatusPublished, FindSpecificBook(db, "9789129657470").Status)
+	})
+}
@@ -0,0 +1,95 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/NicolaiMordrup/library/antivirus"
+)
+
+// QuarantinedUpload is an upload which the malware scanner rejected. Only
+// its metadata is kept, so that the admins can follow up on it.
+type QuarantinedUpload struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"` // The kind of upload, e.g. attachment
+	ISBN        string    `json:"isbn"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	Signature   string    `json:"signature"`          // The malware which was found
+	MemberID    string    `json:"memberId,omitempty"` // The uploader, if logged in
+	CreateTime  time.Time `json:"createTime"`
+}
+
+// WithScanner scans the uploaded files for malware before they are made
+// available.
+func WithScanner(scanner antivirus.Scanner) ServerOption {
+	return func(s *Server) {
+		s.scanner = scanner
+	}
+}
+
+// scanUpload scans the content of an upload. The metadata of infected
+// uploads is quarantined, and the caller discards the content unless it is
+// clean.
+func (s *Server) scanUpload(r *http.Request, content io.Reader, q QuarantinedUpload) (antivirus.Result, error) {
+	result, err := s.scanner.Scan(r.Context(), content)
+	if err != nil {
+		return result, fmt.Errorf("scan upload err, %w", err)
+	}
+	if !result.Infected {
+		return result, nil
+	}
+	q.ID = s.idGenerator.NewID()
+	q.Signature = result.Signature
+	q.CreateTime = time.Now()
+	if m, err := s.sessionMember(r); err == nil {
+		q.MemberID = m.ID
+	}
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO quarantine (id, kind, isbn, name, contentType, size, signature, memberId, createTime) VALUES(?,?,?,?,?,?,?,?,?)",
+			q.ID, q.Kind, q.ISBN, q.Name, q.ContentType, q.Size, q.Signature, q.MemberID, q.CreateTime)
+		return err
+	})
+	if err != nil {
+		return result, fmt.Errorf("quarantine upload err, %w", err)
+	}
+	return result, nil
+}
+
+// ListQuarantine lists the uploads which were rejected by the malware
+// scanner, the latest first. Only admins can see the quarantine.
+func (s *Server) ListQuarantine(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	rows, err := s.db.Query("SELECT id, kind, isbn, name, contentType, size, signature, memberId, createTime FROM quarantine ORDER BY createTime DESC, id DESC")
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the quarantine")
+		return
+	}
+	defer rows.Close()
+	uploads := []QuarantinedUpload{}
+	for rows.Next() {
+		var q QuarantinedUpload
+		if err := rows.Scan(&q.ID, &q.Kind, &q.ISBN, &q.Name, &q.ContentType, &q.Size, &q.Signature, &q.MemberID, &q.CreateTime); err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the quarantine")
+			return
+		}
+		uploads = append(uploads, q)
+	}
+	if err := rows.Err(); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the quarantine")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(uploads); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the quarantine")
+		return
+	}
+}
@@ -0,0 +1,90 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/antivirus"
+	"github.com/NicolaiMordrup/library/blobs"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeScanner finds the EICAR test string, and fails on content which
+// contains "unscannable".
+type fakeScanner struct{}
+
+func (fakeScanner) Scan(ctx context.Context, r io.Reader) (antivirus.Result, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return antivirus.Result{}, err
+	}
+	if bytes.Contains(b, []byte("unscannable")) {
+		return antivirus.Result{}, errors.New("clamd is down")
+	}
+	if bytes.Contains(b, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+		return antivirus.Result{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return antivirus.Result{}, nil
+}
+
+func TestQuarantine(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	dir := t.TempDir()
+	store, err := blobs.NewDir(dir)
+	require.NoError(t, err)
+	server := NewServer(db, WithBlobStore(store), WithScanner(fakeScanner{}))
+
+	serve := func(method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	jsonBytes, _ := json.Marshal(Book{ISBN: "9789129688313", Title: "Pippi Langstrump",
+		Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben"})
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/9789129688313", jsonContentType, jsonBytes).Code)
+	const path = "/api/v1/books/9789129688313/attachments"
+
+	response := serve(http.MethodPost, path+"?name=clean.txt", "text/plain", []byte("1. Pippi"))
+	require.Equal(t, http.StatusCreated, response.Code)
+
+	response = serve(http.MethodPost, path+"?name=errata.pdf", "application/pdf",
+		[]byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`))
+	require.Equal(t, http.StatusUnprocessableEntity, response.Code)
+	require.Contains(t, response.Body.String(), "Eicar-Test-Signature")
+
+	response = serve(http.MethodPost, path+"?name=other.txt", "text/plain", []byte("unscannable"))
+	require.Equal(t, http.StatusInternalServerError, response.Code, "the uploads are not accepted unscanned")
+
+	var attachments []Attachment
+	require.NoError(t, json.NewDecoder(serve(http.MethodGet, path, "", nil).Body).Decode(&attachments))
+	require.Len(t, attachments, 1)
+	require.Equal(t, "clean.txt", attachments[0].Name)
+	files, err := os.ReadDir(filepath.Join(dir, "attachments", "9789129688313"))
+	require.NoError(t, err)
+	require.Len(t, files, 1, "only the clean upload is kept")
+
+	require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/admin/quarantine", "", nil).Code)
+	librarian, admin := createMemberSession(t, db, "astrid", RoleLibrarian), createMemberSession(t, db, "admin", RoleAdmin)
+	require.Equal(t, http.StatusForbidden,
+		createNewMemberRequest(http.MethodGet, "/api/v1/admin/quarantine", nil, db, librarian).Code)
+	response = createNewMemberRequest(http.MethodGet, "/api/v1/admin/quarantine", nil, db, admin)
+	require.Equal(t, http.StatusOK, response.Code)
+	var quarantine []QuarantinedUpload
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&quarantine))
+	require.Len(t, quarantine, 1)
+	require.Equal(t, "attachment", quarantine[0].Kind)
+	require.Equal(t, "errata.pdf", quarantine[0].Name)
+	require.Equal(t, "Eicar-Test-Signature", quarantine[0].Signature)
+	require.Equal(t, int64(68), quarantine[0].Size)
+}
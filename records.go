@@ -0,0 +1,158 @@
+package library
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The formats of the record of a single book, given by ?format.
+const (
+	RecordFormatDublinCore = "dc"
+	RecordFormatBibTeX     = "bibtex"
+)
+
+const bibTeXContentType = "application/x-bibtex"
+
+// dublinCoreRecord is a standalone Dublin Core description of a book, in
+// the oai_dc container which is used by OAI-PMH as well.
+type dublinCoreRecord struct {
+	XMLName xml.Name `xml:"oai_dc:dc"`
+	dublinCore
+}
+
+// writeRecord writes the record of a book in the given format, one of
+// RecordFormatDublinCore or RecordFormatBibTeX.
+func writeRecord(w http.ResponseWriter, b Book, format string) error {
+	switch format {
+	case RecordFormatDublinCore:
+		w.Header().Set("Content-Type", xmlContentType+"; charset=utf-8")
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		return enc.Encode(dublinCoreRecord{dublinCore: dublinCoreFromBook(b)})
+	case RecordFormatBibTeX:
+		w.Header().Set("Content-Type", bibTeXContentType+"; charset=utf-8")
+		_, err := io.WriteString(w, bibTeXEntry(b))
+		return err
+	}
+	return fmt.Errorf("unknown record format %q", format)
+}
+
+// validRecordFormat reports whether the record of a book can be retrieved in
+// the format.
+func validRecordFormat(format string) bool {
+	return format == RecordFormatDublinCore || format == RecordFormatBibTeX
+}
+
+// bibTeXEntry returns the @book entry of a book. The fields are in braces,
+// so that only the characters which are special to TeX are escaped, and the
+// author is written as "Last, First", which BibTeX parses unambiguously.
+func bibTeXEntry(b Book) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "@book{%s,\n", bibTeXKey(b))
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "  %s = {%s},\n", name, value)
+		}
+	}
+	field("author", bibTeXName(b.Author))
+	// The double braces keep the capitalization of the title, which styles
+	// would otherwise change
+	if b.Title != "" {
+		field("title", "{"+escapeBibTeX(b.Title)+"}")
+	}
+	field("publisher", escapeBibTeX(b.Publisher))
+	if b.PublicationYear != 0 {
+		field("year", strconv.Itoa(b.PublicationYear))
+	}
+	field("isbn", b.ISBN)
+	field("language", escapeBibTeX(b.Language))
+	field("abstract", escapeBibTeX(b.Description))
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// bibTeXName formats an author as "Last, First". A name which contains the
+// word "and" is put in braces, since BibTeX would split it into two authors.
+func bibTeXName(a *Author) string {
+	if a == nil || (a.FirstName == "" && a.LastName == "") {
+		return ""
+	}
+	protect := func(name string) string {
+		name = escapeBibTeX(name)
+		for _, word := range strings.Fields(name) {
+			if strings.EqualFold(word, "and") {
+				return "{" + name + "}"
+			}
+		}
+		return name
+	}
+	switch {
+	case a.FirstName == "":
+		return protect(a.LastName)
+	case a.LastName == "":
+		return protect(a.FirstName)
+	}
+	return protect(a.LastName) + ", " + protect(a.FirstName)
+}
+
+// bibTeXEscapes are the replacements of the characters which are special to
+// TeX.
+var bibTeXEscapes = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`^`, `\textasciicircum{}`,
+	`~`, `\textasciitilde{}`,
+)
+
+// escapeBibTeX escapes the characters of s which are special to TeX. Other
+// characters are kept as UTF-8, which BibLaTeX and the citation managers
+// read.
+func escapeBibTeX(s string) string {
+	return bibTeXEscapes.Replace(s)
+}
+
+// bibTeXKey returns the citation key of a book, the last name of the author,
+// the year and the first word of the title, e.g. lindgren1945pippi. The key
+// is ASCII, with the accents of letters removed, and the ISBN is used when
+// there is neither an author nor a title.
+func bibTeXKey(b Book) string {
+	ascii := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r < unicode.MaxASCII && r != ' ' {
+				return r
+			}
+			return -1
+		}, normalizeText(s))
+	}
+	key := ""
+	if b.Author != nil {
+		key = ascii(b.Author.LastName)
+	}
+	if b.PublicationYear != 0 {
+		key += strconv.Itoa(b.PublicationYear)
+	}
+	for _, word := range strings.Fields(b.Title) {
+		if w := ascii(word); w != "" {
+			key += w
+			break
+		}
+	}
+	if key == "" || key == strconv.Itoa(b.PublicationYear) {
+		return b.ISBN
+	}
+	return key
+}
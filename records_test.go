@@ -0,0 +1,57 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBookRecords(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	isbn := "9789129688313"
+	jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: "Pippi & the 100% {Pirates}", PublicationYear: 1945,
+		Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben", Language: "sv"})
+	require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+
+	t.Run("Writes Dublin Core", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books/"+isbn+"?format=dc", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Contains(t, response.Header().Get("Content-Type"), xmlContentType)
+		body := response.Body.String()
+		require.Contains(t, body, `<oai_dc:dc xmlns:oai_dc="http://www.openarchives.org/OAI/2.0/oai_dc/"`)
+		require.Contains(t, body, "<dc:title>Pippi &amp; the 100% {Pirates}</dc:title>")
+		require.Contains(t, body, "<dc:creator>Lindgren, Astrid</dc:creator>")
+		require.Contains(t, body, "<dc:date>1945</dc:date>")
+		require.Contains(t, body, "<dc:identifier>urn:isbn:"+isbn+"</dc:identifier>")
+	})
+
+	t.Run("Writes BibTeX", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books/"+isbn+"?format=bibtex", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Contains(t, response.Header().Get("Content-Type"), bibTeXContentType)
+		require.Equal(t, `@book{lindgren1945pippi,
+  author = {Lindgren, Astrid},
+  title = {{Pippi \& the 100\% \{Pirates\}}},
+  publisher = {raben},
+  year = {1945},
+  isbn = {9789129688313},
+  language = {sv},
+}
+`, response.Body.String())
+	})
+
+	t.Run("Rejects unknown formats", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, createNewRequest(http.MethodGet, "/api/v1/books/"+isbn+"?format=marc", nil, db).Code)
+	})
+}
+
+func TestBibTeXName(t *testing.T) {
+	require.Equal(t, "{Smith and Sons}", bibTeXName(&Author{LastName: "Smith and Sons"}))
+	require.Equal(t, "von Trier, Lars", bibTeXName(&Author{FirstName: "Lars", LastName: "von Trier"}))
+	require.Equal(t, "", bibTeXName(&Author{}))
+	require.Equal(t, "ostergren2001alfa", bibTeXKey(Book{Title: "Älfa", PublicationYear: 2001, Author: &Author{LastName: "Östergren"}}))
+}
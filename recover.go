@@ -0,0 +1,120 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// panicsRecovered counts the panics in handlers, it is published with the
+// other expvar variables at /debug/vars.
+var panicsRecovered = expvar.NewInt("library_panics_recovered_total")
+
+// GetDebugVars publishes the expvar variables. Only admins can read them,
+// since they include the command line and the memory statistics of the
+// server.
+func (s *Server) GetDebugVars(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.adminMember(r); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
+
+// requestIDHeader carries the id of a request. A client or proxy may set it,
+// otherwise the server generates one, and it is echoed in the response so
+// that a failed request can be found in the logs.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// withRequestID stores the id of the request in its context and sets it on
+// the response.
+func (s *Server) withRequestID(w http.ResponseWriter, req *http.Request) *http.Request {
+	id := req.Header.Get(requestIDHeader)
+	if id == "" || len(id) > 128 {
+		id = s.idGenerator.NewID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	return req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+}
+
+// requestID returns the id of the request, or "" if it has none.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ErrorEnvelope is the structured body of server errors.
+type ErrorEnvelope struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error. RequestID refers to the log entries of the
+// failed request.
+type ErrorDetail struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// writeErrorEnvelope writes an error as an ErrorEnvelope.
+func writeErrorEnvelope(w http.ResponseWriter, r *http.Request, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(ErrorEnvelope{ErrorDetail{
+		Code: code, Message: message, RequestID: requestID(r.Context()),
+	}})
+	if err != nil {
+		log.Printf("%v, %v \n", message, err)
+	}
+}
+
+// headerWrittenWriter remembers whether the response has been started.
+type headerWrittenWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *headerWrittenWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWrittenWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// recoverPanics turns a panic in next into a 500 response, so that one bad
+// record does not kill the connection without a response. The stack trace
+// is logged with the request id.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headerWrittenWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// Aborting the handler is how net/http expects it to be done
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			panicsRecovered.Add(1)
+			log.Printf("panic serving %s %s, request id %s: %v\n%s",
+				r.Method, r.URL.Path, requestID(r.Context()), v, debug.Stack())
+			if hw.written {
+				// The status has been sent, all that can be done is to
+				// stop the response
+				panic(http.ErrAbortHandler)
+			}
+			writeErrorEnvelope(w, r, http.StatusInternalServerError, "Internal server error")
+		}()
+		next.ServeHTTP(hw, r)
+	})
+}
@@ -0,0 +1,66 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	server := NewServer(db)
+	server.router.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("bad record")
+	})
+	before := panicsRecovered.Value()
+
+	t.Run("Returns a 500 envelope with the request id", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/panic", nil)
+		request.Header.Set(requestIDHeader, "req-1")
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		require.Equal(t, http.StatusInternalServerError, response.Code)
+		require.Equal(t, "req-1", response.Header().Get(requestIDHeader))
+		var got ErrorEnvelope
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Equal(t, ErrorEnvelope{ErrorDetail{
+			Code: http.StatusInternalServerError, Message: "Internal server error", RequestID: "req-1",
+		}}, got)
+		require.Equal(t, before+1, panicsRecovered.Value())
+	})
+
+	t.Run("Recovers panics in routes with a timeout", func(t *testing.T) {
+		server.route("/panic-route", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			panic("bad record")
+		})
+		request, _ := http.NewRequest(http.MethodGet, "/panic-route", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		require.Equal(t, http.StatusInternalServerError, response.Code)
+	})
+
+	t.Run("Only admins read the debug variables", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, createNewRequest(http.MethodGet, "/debug/vars", nil, db).Code)
+		member := createMemberSession(t, db, "debugmember")
+		require.Equal(t, http.StatusForbidden, createNewMemberRequest(http.MethodGet, "/debug/vars", nil, db, member).Code)
+
+		admin := createMemberSession(t, db, "debugadmin", RoleAdmin)
+		response := createNewMemberRequest(http.MethodGet, "/debug/vars", nil, db, admin)
+		require.Equal(t, http.StatusOK, response.Code)
+		var vars map[string]interface{}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&vars))
+		require.Contains(t, vars, "library_panics_recovered_total")
+	})
+
+	t.Run("Generates request ids", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.NotEmpty(t, response.Header().Get(requestIDHeader))
+	})
+}
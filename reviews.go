@@ -0,0 +1,334 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Review is the rating, and optionally a written review, of a book by a
+// member. Hidden reviews were removed by a moderator and are left out of the
+// listings and the average rating.
+type Review struct {
+	ID         string    `json:"id" xml:"id" yaml:"id"`
+	ISBN       string    `json:"isbn" xml:"isbn" yaml:"isbn"`
+	MemberID   string    `json:"memberId" xml:"memberId" yaml:"memberId"`
+	Rating     int       `json:"rating" xml:"rating" yaml:"rating"` // From 1 to 5
+	Text       string    `json:"text,omitempty" xml:"text,omitempty" yaml:"text,omitempty"`
+	CreateTime time.Time `json:"createTime" xml:"createTime" yaml:"createTime"`
+	Hidden     bool      `json:"hidden,omitempty" xml:"hidden,omitempty" yaml:"hidden,omitempty"`
+}
+
+// ReviewPage is a page of the reviews of a book. NextPageToken is empty on
+// the last page.
+type ReviewPage struct {
+	Reviews       []Review `json:"reviews"`
+	NextPageToken string   `json:"nextPageToken,omitempty"`
+}
+
+// The number of reviews on a page unless page_size is given, and the
+// largest page size which may be requested.
+const (
+	defaultReviewPageSize = 20
+	maxReviewPageSize     = 100
+)
+
+// maxReviewLength limits the length of the text of a review, in bytes.
+const maxReviewLength = 10000
+
+func validateReview(r Review) error {
+	var fieldErrors []string
+	if strings.TrimSpace(r.MemberID) == "" {
+		fieldErrors = append(fieldErrors, " memberId ")
+	}
+	if r.Rating < 1 || r.Rating > 5 {
+		fieldErrors = append(fieldErrors, " rating ")
+	}
+	if len(r.Text) > maxReviewLength {
+		fieldErrors = append(fieldErrors, " text ")
+	}
+	if len(fieldErrors) != 0 {
+		return fmt.Errorf("validation failed, field error(s):%v. Fix these error before proceeding",
+			strings.Join(fieldErrors, ", "))
+	}
+	return nil
+}
+
+// InsertReview stores a review.
+func InsertReview(db Querier, r Review) error {
+	_, err := db.Exec("INSERT INTO review (id, isbn, memberId, rating, text, createTime, hidden) VALUES(?,?,?,?,?,?,?)",
+		r.ID, r.ISBN, r.MemberID, r.Rating, r.Text, r.CreateTime, r.Hidden)
+	if err != nil {
+		return fmt.Errorf("insert review err, %w", err)
+	}
+	return nil
+}
+
+// hasReviewed reports whether the member has already reviewed the book.
+func hasReviewed(db Querier, isbn, memberID string) (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM review WHERE isbn = ? AND memberId = ?", isbn, memberID).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("count reviews err, %w", err)
+	}
+	return n != 0, nil
+}
+
+// readReviews reads the reviews matching where, newest first, skipping the
+// first offset reviews.
+func readReviews(db Querier, where string, args []interface{}, offset, limit int) ([]Review, error) {
+	rows, err := db.Query("SELECT id, isbn, memberId, rating, text, createTime, hidden FROM review WHERE "+
+		where+" ORDER BY rowid DESC LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("query reviews err, %w", err)
+	}
+	defer rows.Close()
+	reviews := []Review{}
+	for rows.Next() {
+		var r Review
+		if err := rows.Scan(&r.ID, &r.ISBN, &r.MemberID, &r.Rating, &r.Text, &r.CreateTime, &r.Hidden); err != nil {
+			return nil, fmt.Errorf("scan review err, %w", err)
+		}
+		reviews = append(reviews, r)
+	}
+	return reviews, rows.Err()
+}
+
+// ReadReviews reads a page of the visible reviews of a book, newest first.
+// One more review than the limit is read to tell whether there is a next
+// page.
+func ReadReviews(db Querier, isbn string, offset, limit int) ([]Review, bool, error) {
+	reviews, err := readReviews(db, "isbn = ? AND hidden = 0", []interface{}{isbn}, offset, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(reviews) > limit {
+		return reviews[:limit], true, nil
+	}
+	return reviews, false, nil
+}
+
+// ReadRating returns the average of the visible ratings of a book and the
+// number of ratings.
+func ReadRating(db Querier, isbn string) (float64, int, error) {
+	var average sql.NullFloat64
+	var count int
+	err := db.QueryRow("SELECT AVG(rating), COUNT(*) FROM review WHERE isbn = ? AND hidden = 0", isbn).
+		Scan(&average, &count)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read rating err, %w", err)
+	}
+	return average.Float64, count, nil
+}
+
+// SetReviewHidden hides or shows a review. It returns sql.ErrNoRows if there
+// is no such review.
+func SetReviewHidden(db Querier, id string, hidden bool) error {
+	res, err := db.Exec("UPDATE review SET hidden = ? WHERE id = ?", hidden, id)
+	if err != nil {
+		return fmt.Errorf("update review err, %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// pageToken encodes the offset of the next page. The tokens are opaque
+// to clients so that the paging can change without breaking them.
+func pageToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func parsePageToken(token string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(string(b))
+	if err != nil || offset < 0 {
+		return 0, errors.New("invalid page token")
+	}
+	return offset, nil
+}
+
+// parsePage reads the page_size and page_token query parameters.
+func parsePage(r *http.Request) (offset, size int, err error) {
+	size = defaultReviewPageSize
+	if v := r.URL.Query().Get("page_size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 1 || size > maxReviewPageSize {
+			return 0, 0, fmt.Errorf("page_size must be between 1 and %d", maxReviewPageSize)
+		}
+	}
+	if token := r.URL.Query().Get("page_token"); token != "" {
+		if offset, err = parsePageToken(token); err != nil {
+			return 0, 0, errors.New("page_token is invalid")
+		}
+	}
+	return offset, size, nil
+}
+
+// GetReviews retrieves a page of the visible reviews of a book.
+func (s *Server) GetReviews(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	isbn := mux.Vars(r)["isbn"]
+	if FindPublicBook(s.db, isbn, time.Now()).ISBN == "" {
+		HandleErr(w, http.StatusNotFound, "The book did not exist in the library")
+		return
+	}
+	offset, size, err := parsePage(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reviews, more, err := ReadReviews(s.db, isbn, offset, size)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the reviews")
+		return
+	}
+	page := ReviewPage{Reviews: reviews}
+	if more {
+		page.NextPageToken = pageToken(offset + size)
+	}
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the reviews")
+		return
+	}
+}
+
+// moderatingMember returns the member of the session when it may moderate
+// reviews, which is allowed for moderators and admins.
+func (s *Server) moderatingMember(r *http.Request) (Member, error) {
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		return Member{}, &statusError{http.StatusUnauthorized, "The request is not logged in"}
+	} else if err != nil {
+		return Member{}, err
+	}
+	if !hasRole(m, RoleModerator) && !hasRole(m, RoleAdmin) {
+		return Member{}, &statusError{http.StatusForbidden, "Only moderators and admins can moderate reviews"}
+	}
+	return m, nil
+}
+
+// CreateReview rates and reviews a book by the member of the session. A
+// member reviews a book once.
+func (s *Server) CreateReview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	isbn := mux.Vars(r)["isbn"]
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		HandleErr(w, http.StatusUnauthorized, "The request is not logged in")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the session")
+		return
+	}
+	var review Review
+	if err := decodeJSON(r, &review); err != nil {
+		handleDecodeErr(w, err, "Failed to decode review")
+		return
+	}
+	if review.MemberID != "" && review.MemberID != m.ID {
+		HandleErr(w, http.StatusForbidden, "Not allowed to review a book for another member")
+		return
+	}
+	review.MemberID = m.ID
+	if err := validateReview(review); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	review.ID = s.idGenerator.NewID()
+	review.ISBN = isbn
+	review.CreateTime = time.Now()
+	review.Hidden = false
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if FindPublicBook(tx, isbn, time.Now()).ISBN == "" {
+			return &statusError{http.StatusNotFound, "The book did not exist in the library"}
+		}
+		reviewed, err := hasReviewed(tx, isbn, review.MemberID)
+		if err != nil {
+			return err
+		}
+		if reviewed {
+			return &statusError{http.StatusConflict, "The member has already reviewed this book"}
+		}
+		return InsertReview(tx, review)
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to store the review")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the review")
+		return
+	}
+}
+
+// ListHiddenReviews retrieves a page of the reviews which were hidden by a
+// moderator, so that they can be reviewed again.
+func (s *Server) ListHiddenReviews(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.moderatingMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	offset, size, err := parsePage(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reviews, err := readReviews(s.db, "hidden = 1", nil, offset, size+1)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the reviews")
+		return
+	}
+	page := ReviewPage{Reviews: reviews}
+	if len(reviews) > size {
+		page.Reviews = reviews[:size]
+		page.NextPageToken = pageToken(offset + size)
+	}
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the reviews")
+		return
+	}
+}
+
+// HideReview hides an abusive review from the listings and the rating.
+func (s *Server) HideReview(w http.ResponseWriter, r *http.Request) {
+	s.setReviewHidden(w, r, true)
+}
+
+// UnhideReview shows a review which was hidden by mistake.
+func (s *Server) UnhideReview(w http.ResponseWriter, r *http.Request) {
+	s.setReviewHidden(w, r, false)
+}
+
+func (s *Server) setReviewHidden(w http.ResponseWriter, r *http.Request, hidden bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.moderatingMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		return SetReviewHidden(tx, mux.Vars(r)["id"], hidden)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The review does not exist")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to update the review")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
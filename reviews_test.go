@@ -0,0 +1,134 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReviews(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	isbn := "1233211233215"
+	jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: "a new hope",
+		Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "adlibris"})
+	require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+
+	sessions := make(map[string]string)
+	for i := 0; i < 4; i++ {
+		id := fmt.Sprintf("member-%d", i)
+		sessions[id] = createMemberSession(t, db, id)
+	}
+	moderator := createMemberSession(t, db, "moderator", RoleModerator)
+	review := func(r Review) *Review {
+		t.Helper()
+		jsonBytes, _ := json.Marshal(r)
+		response := createNewMemberRequest(http.MethodPost, "/api/v1/books/"+isbn+"/reviews", jsonBytes, db, sessions[r.MemberID])
+		require.Equal(t, http.StatusCreated, response.Code, response.Body.String())
+		var got Review
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		return &got
+	}
+	var abusive *Review
+	for i, rating := range []int{5, 4, 3, 1} {
+		got := review(Review{MemberID: fmt.Sprintf("member-%d", i), Rating: rating})
+		if rating == 1 {
+			abusive = got
+		}
+	}
+	readBook := func() Book {
+		response := createNewRequest(http.MethodGet, "/api/v1/books/"+isbn, nil, db)
+		var book Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&book))
+		return book
+	}
+
+	t.Run("Averages the ratings on the book", func(t *testing.T) {
+		book := readBook()
+		require.Equal(t, 3.25, book.AverageRating)
+		require.Equal(t, 4, book.RatingCount)
+	})
+
+	t.Run("Embeds the reviews in the book", func(t *testing.T) {
+		require.Empty(t, readBook().Reviews)
+		response := createNewRequest(http.MethodGet, "/api/v1/books/"+isbn+"?expand=reviews", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var book Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&book))
+		require.Len(t, book.Reviews, 4)
+		require.Equal(t, abusive.ID, book.Reviews[0].ID)
+
+		response = createNewRequest(http.MethodGet, "/api/v1/books/"+isbn+"?expand=reviews,loans", nil, db)
+		require.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Pages through the reviews", func(t *testing.T) {
+		var ratings []int
+		path := "/api/v1/books/" + isbn + "/reviews?page_size=3"
+		for {
+			response := createNewRequest(http.MethodGet, path, nil, db)
+			require.Equal(t, http.StatusOK, response.Code)
+			var page ReviewPage
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&page))
+			for _, r := range page.Reviews {
+				ratings = append(ratings, r.Rating)
+			}
+			if page.NextPageToken == "" {
+				break
+			}
+			path = "/api/v1/books/" + isbn + "/reviews?page_size=3&page_token=" + page.NextPageToken
+		}
+		require.Equal(t, []int{1, 3, 4, 5}, ratings)
+
+		response := createNewRequest(http.MethodGet, "/api/v1/books/"+isbn+"/reviews?page_size=1000", nil, db)
+		assertStatus(t, response.Code, http.StatusBadRequest, "Should get status "+
+			"code 400: status bad request")
+	})
+
+	t.Run("Hides abusive reviews", func(t *testing.T) {
+		response := createNewRequest(http.MethodPost, "/api/v1/admin/reviews/"+abusive.ID+":hide", nil, db)
+		require.Equal(t, http.StatusUnauthorized, response.Code)
+		response = createNewMemberRequest(http.MethodPost, "/api/v1/admin/reviews/"+abusive.ID+":hide", nil, db, sessions["member-0"])
+		require.Equal(t, http.StatusForbidden, response.Code, "only moderators should hide reviews")
+		response = createNewMemberRequest(http.MethodPost, "/api/v1/admin/reviews/"+abusive.ID+":hide", nil, db, moderator)
+		require.Equal(t, http.StatusNoContent, response.Code)
+
+		book := readBook()
+		require.Equal(t, 4.0, book.AverageRating)
+		require.Equal(t, 3, book.RatingCount)
+
+		response = createNewMemberRequest(http.MethodGet, "/api/v1/admin/reviews", nil, db, moderator)
+		var hidden ReviewPage
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&hidden))
+		require.Len(t, hidden.Reviews, 1)
+		require.Equal(t, abusive.ID, hidden.Reviews[0].ID)
+
+		response = createNewMemberRequest(http.MethodPost, "/api/v1/admin/reviews/missing:hide", nil, db, moderator)
+		require.Equal(t, http.StatusNotFound, response.Code)
+	})
+
+	t.Run("Rejects invalid reviews", func(t *testing.T) {
+		for _, r := range []Review{{Rating: 6}, {Rating: 0}} {
+			jsonBytes, _ := json.Marshal(r)
+			response := createNewMemberRequest(http.MethodPost, "/api/v1/books/"+isbn+"/reviews", jsonBytes, db, moderator)
+			assertStatus(t, response.Code, http.StatusNotAcceptable, "Should get status "+
+				"code 406: status not acceptable")
+		}
+
+		jsonBytes, _ := json.Marshal(Review{MemberID: "member-0", Rating: 2})
+		response := createNewMemberRequest(http.MethodPost, "/api/v1/books/"+isbn+"/reviews", jsonBytes, db, sessions["member-0"])
+		assertStatus(t, response.Code, http.StatusConflict, "Should get status "+
+			"code 409: status conflict")
+		response = createNewRequest(http.MethodPost, "/api/v1/books/"+isbn+"/reviews", jsonBytes, db)
+		require.Equal(t, http.StatusUnauthorized, response.Code)
+		response = createNewMemberRequest(http.MethodPost, "/api/v1/books/"+isbn+"/reviews", jsonBytes, db, moderator)
+		require.Equal(t, http.StatusForbidden, response.Code, "a member should not review for another member")
+
+		response = createNewMemberRequest(http.MethodPost, "/api/v1/books/1233211233213/reviews", jsonBytes, db, sessions["member-0"])
+		require.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
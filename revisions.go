@@ -0,0 +1,298 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The statuses of a revision.
+const (
+	RevisionPending  = "pending"
+	RevisionApproved = "approved"
+	RevisionRejected = "rejected"
+)
+
+// Revision is a change of a book which was proposed by a trainee and waits
+// for a librarian or an admin to approve or reject it.
+type Revision struct {
+	ID         string     `json:"id"`
+	ISBN       string     `json:"isbn"`
+	Status     string     `json:"status"` // One of RevisionPending, RevisionApproved or RevisionRejected
+	Book       Book       `json:"book"`   // The book as the trainee wants it
+	ProposedBy string     `json:"proposedBy"`
+	CreateTime time.Time  `json:"createTime"`
+	ReviewedBy string     `json:"reviewedBy,omitempty"`
+	ReviewTime *time.Time `json:"reviewTime,omitempty"`
+	Comment    string     `json:"comment,omitempty"` // Why the revision was rejected
+	// Fields are the fields which the revision changes and Current is the
+	// book as it is, only set when a single revision is retrieved
+	Fields  []string `json:"fields,omitempty"`
+	Current *Book    `json:"current,omitempty"`
+
+	baseUpdateTime time.Time // The update time of the book which was revised
+}
+
+// revisionReview is the body of a rejection.
+type revisionReview struct {
+	Comment string `json:"comment"`
+}
+
+// isTrainee reports whether the edits of the member must be approved, which
+// is the case for trainees who are not also librarians or admins.
+func isTrainee(m Member) bool {
+	return hasRole(m, RoleTrainee) && !hasRole(m, RoleLibrarian) && !hasRole(m, RoleAdmin)
+}
+
+// hasTrainees reports whether any member is a trainee.
+func hasTrainees(db Querier) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM member_role WHERE role = ?)", RoleTrainee).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("read trainees err, %w", err)
+	}
+	return exists, nil
+}
+
+// errTraineeEdit is returned when a trainee makes a change which can not be
+// proposed as a revision, e.g. a deletion.
+var errTraineeEdit = &statusError{http.StatusForbidden, "Trainees can only propose changes to books"}
+
+// bookEditor returns the editor of the request, see editor, for a change
+// which can not be proposed as a revision, so trainees are refused.
+func (s *Server) bookEditor(r *http.Request) (Member, error) {
+	m, err := s.editor(r)
+	if err != nil {
+		return Member{}, err
+	}
+	if isTrainee(m) {
+		return Member{}, errTraineeEdit
+	}
+	return m, nil
+}
+
+// proposeRevision stores the change of a book by a trainee as a pending
+// revision. The change is validated like an update, so that only changes
+// which can be applied are proposed.
+func (s *Server) proposeRevision(q Querier, isbn string, book Book, m Member, now time.Time) (Revision, error) {
+	exists := FindSpecificBook(q, isbn)
+	if exists.ISBN == "" {
+		return Revision{}, &statusError{http.StatusNotFound, "The book did not exist in the library"}
+	}
+	if book.ISBN != isbn {
+		return Revision{}, &statusError{http.StatusForbidden, "Not allowed to change ISBN"}
+	}
+	// The fields which the server maintains are kept, so that the revision
+	// only differs from the book by the proposed change
+	book.ID, book.CreateTime, book.UpdateTime, book.Status = exists.ID, exists.CreateTime, exists.UpdateTime, exists.Status
+	if err := validate(book); err != nil {
+		return Revision{}, &statusError{http.StatusNotAcceptable, err.Error()}
+	}
+	rev := Revision{ID: s.idGenerator.NewID(), ISBN: isbn, Status: RevisionPending, Book: book,
+		ProposedBy: m.ID, CreateTime: time.Unix(now.Unix(), 0).UTC(), baseUpdateTime: exists.UpdateTime}
+	data, err := json.Marshal(book)
+	if err != nil {
+		return Revision{}, fmt.Errorf("encode revision err, %w", err)
+	}
+	_, err = q.Exec("INSERT INTO revision (id, isbn, status, book, proposedBy, createTime, baseUpdateTime) VALUES(?,?,?,?,?,?,?)",
+		rev.ID, rev.ISBN, rev.Status, string(data), rev.ProposedBy, rev.CreateTime.Unix(), rev.baseUpdateTime)
+	if err != nil {
+		return Revision{}, fmt.Errorf("insert revision err, %w", err)
+	}
+	return rev, nil
+}
+
+// readRevisions reads the revisions matching the where clause, the oldest
+// first.
+func readRevisions(db Querier, where string, args ...interface{}) ([]Revision, error) {
+	rows, err := db.Query("SELECT id, isbn, status, book, proposedBy, createTime, reviewedBy, reviewTime, comment, baseUpdateTime "+
+		"FROM revision WHERE "+where+" ORDER BY createTime, id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query revisions err, %w", err)
+	}
+	defer rows.Close()
+	revisions := []Revision{}
+	for rows.Next() {
+		var rev Revision
+		var book string
+		var createTime int64
+		var reviewTime sql.NullInt64
+		err := rows.Scan(&rev.ID, &rev.ISBN, &rev.Status, &book, &rev.ProposedBy, &createTime,
+			&rev.ReviewedBy, &reviewTime, &rev.Comment, &rev.baseUpdateTime)
+		if err != nil {
+			return nil, fmt.Errorf("scan revision err, %w", err)
+		}
+		if err := json.Unmarshal([]byte(book), &rev.Book); err != nil {
+			return nil, fmt.Errorf("decode revision err, %w", err)
+		}
+		rev.CreateTime = time.Unix(createTime, 0).UTC()
+		if reviewTime.Valid {
+			t := time.Unix(reviewTime.Int64, 0).UTC()
+			rev.ReviewTime = &t
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// findRevision reads a single revision.
+func findRevision(db Querier, id string) (Revision, error) {
+	revisions, err := readRevisions(db, "id = ?", id)
+	if err != nil {
+		return Revision{}, err
+	}
+	if len(revisions) == 0 {
+		return Revision{}, &statusError{http.StatusNotFound, "The revision does not exist"}
+	}
+	return revisions[0], nil
+}
+
+// reviewRevision approves or rejects a pending revision. An approved
+// revision is applied to the book in the same transaction, unless the book
+// changed after the revision was proposed.
+func (s *Server) reviewRevision(q Querier, id, status string, reviewer Member, comment string, now time.Time) (Revision, error) {
+	rev, err := findRevision(q, id)
+	if err != nil {
+		return Revision{}, err
+	}
+	if rev.Status != RevisionPending {
+		return Revision{}, &statusError{http.StatusConflict, "The revision has already been reviewed"}
+	}
+	if status == RevisionApproved {
+		current := FindSpecificBook(q, rev.ISBN)
+		if current.ISBN == "" {
+			return Revision{}, &statusError{http.StatusNotFound, "The book did not exist in the library"}
+		}
+		if !current.UpdateTime.Equal(rev.baseUpdateTime) {
+			return Revision{}, &statusError{http.StatusConflict, "The book has changed since the revision was proposed"}
+		}
+		if err := checkBookLock(q, rev.ISBN, reviewer.ID, now); err != nil {
+			return Revision{}, err
+		}
+		if rev.Book, err = s.updateBook(q, rev.ISBN, rev.Book); err != nil {
+			return Revision{}, err
+		}
+	}
+	reviewTime := time.Unix(now.Unix(), 0).UTC()
+	rev.Status, rev.ReviewedBy, rev.ReviewTime, rev.Comment = status, reviewer.ID, &reviewTime, comment
+	_, err = q.Exec("UPDATE revision SET status = ?, reviewedBy = ?, reviewTime = ?, comment = ? WHERE id = ?",
+		rev.Status, rev.ReviewedBy, reviewTime.Unix(), rev.Comment, rev.ID)
+	if err != nil {
+		return Revision{}, fmt.Errorf("review revision err, %w", err)
+	}
+	return rev, nil
+}
+
+// reviewingMember returns the member of the session when it may review
+// revisions, which is allowed for librarians and admins.
+func (s *Server) reviewingMember(r *http.Request) (Member, error) {
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		return Member{}, &statusError{http.StatusUnauthorized, "The request is not logged in"}
+	} else if err != nil {
+		return Member{}, err
+	}
+	if !hasRole(m, RoleLibrarian) && !hasRole(m, RoleAdmin) {
+		return Member{}, &statusError{http.StatusForbidden, "Only librarians and admins can review revisions"}
+	}
+	return m, nil
+}
+
+// writeRevision writes the revision to the stream with the given status.
+func writeRevision(w http.ResponseWriter, code int, rev Revision) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(rev); err != nil {
+		handleErr("Failed to encode revision", err)
+	}
+}
+
+// ListRevisions lists the revisions with the status of ?status, by default
+// the pending ones, the oldest first.
+func (s *Server) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.reviewingMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = RevisionPending
+	}
+	if status != RevisionPending && status != RevisionApproved && status != RevisionRejected {
+		HandleErr(w, http.StatusBadRequest, "status must be one of pending, approved or rejected")
+		return
+	}
+	revisions, err := readRevisions(s.db, "status = ?", status)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the revisions")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(revisions); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the revisions")
+		return
+	}
+}
+
+// GetRevision retrieves a revision with the current book and the fields
+// which the revision changes in it.
+func (s *Server) GetRevision(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.reviewingMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	rev, err := findRevision(s.db, mux.Vars(r)["id"])
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the revision")
+		return
+	}
+	if current := FindSpecificBook(s.db, rev.ISBN); current.ISBN != "" {
+		rev.Current = &current
+		rev.Fields = changedFields(current, rev.Book)
+	}
+	writeRevision(w, http.StatusOK, rev)
+}
+
+// ApproveRevision applies a pending revision to its book, see
+// reviewRevision.
+func (s *Server) ApproveRevision(w http.ResponseWriter, r *http.Request) {
+	s.writeReview(w, r, RevisionApproved, "")
+}
+
+// RejectRevision rejects a pending revision, with an optional comment to
+// the trainee in the body.
+func (s *Server) RejectRevision(w http.ResponseWriter, r *http.Request) {
+	var review revisionReview
+	if r.ContentLength != 0 {
+		if err := decodeJSON(r, &review); err != nil {
+			handleDecodeErr(w, err, "Failed to decode review")
+			return
+		}
+	}
+	s.writeReview(w, r, RevisionRejected, review.Comment)
+}
+
+// writeReview reviews the revision in the path and writes it to the stream.
+func (s *Server) writeReview(w http.ResponseWriter, r *http.Request, status, comment string) {
+	reviewer, err := s.reviewingMember(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var rev Revision
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		var err error
+		rev, err = s.reviewRevision(tx, mux.Vars(r)["id"], status, reviewer, comment, time.Now())
+		return err
+	})
+	if err != nil {
+		handleBookErr(w, err)
+		return
+	}
+	writeRevision(w, http.StatusOK, rev)
+}
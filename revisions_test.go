@@ -0,0 +1,150 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevisions(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	server := NewServer(db, WithMinDurationBetweenUpdates(0))
+
+	serve := func(method, path, token string, v interface{}) *httptest.ResponseRecorder {
+		var body []byte
+		if v != nil {
+			body, _ = json.Marshal(v)
+		}
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", jsonContentType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	session := func(id string, roles ...string) string {
+		t.Helper()
+		m := Member{ID: id, Email: id + "@example.com", FirstName: id, LastName: "Staff", EmailVerified: true, CreateTime: time.Now()}
+		require.NoError(t, server.InsertMember(db, m, ""))
+		require.NoError(t, setRoles(db, id, roles))
+		s, err := server.startSession(db, m)
+		require.NoError(t, err)
+		return s.Token
+	}
+	trainee, librarian := session("tove", RoleTrainee), session("astrid", RoleLibrarian)
+
+	isbn := "9789129688313"
+	book := Book{ISBN: isbn, Title: "Pippi Langstrump", Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben"}
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/"+isbn, librarian, book).Code)
+	propose := func(title string) Revision {
+		t.Helper()
+		changed := book
+		changed.Title = title
+		response := serve(http.MethodPut, "/api/v1/books/"+isbn, trainee, changed)
+		require.Equal(t, http.StatusAccepted, response.Code, response.Body.String())
+		var rev Revision
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&rev))
+		return rev
+	}
+
+	var rev Revision
+	t.Run("Stores the edits of trainees as revisions", func(t *testing.T) {
+		rev = propose("Pippi Longstocking")
+		require.Equal(t, RevisionPending, rev.Status)
+		require.Equal(t, "tove", rev.ProposedBy)
+		require.Equal(t, "Pippi Langstrump", FindSpecificBook(db, isbn).Title)
+
+		require.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "/api/v1/books/"+isbn, trainee, nil).Code)
+		require.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/api/v1/books:batch", trainee, []BatchOperation{}).Code)
+	})
+
+	t.Run("Does not let trainees bypass the review by logging out", func(t *testing.T) {
+		changed := book
+		changed.Title = "Pippi Longstocking"
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodPut, "/api/v1/books/"+isbn, "", changed).Code)
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodDelete, "/api/v1/books/"+isbn, "", nil).Code)
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/api/v1/books:batch", "", []BatchOperation{}).Code)
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/api/v1/books/"+isbn+":withdraw", "", nil).Code)
+		require.Equal(t, "Pippi Langstrump", FindSpecificBook(db, isbn).Title)
+	})
+
+	t.Run("Does not let trainees change books without a revision", func(t *testing.T) {
+		other := book
+		other.ISBN = "9789129688320"
+		for _, req := range []struct {
+			method, path string
+			body         interface{}
+		}{
+			{http.MethodPost, "/api/v1/books/" + other.ISBN, other},
+			{http.MethodDelete, "/api/v1/books?publisher=raben&confirm=true", nil},
+			{http.MethodPost, "/api/v1/operations/unknown:undo", nil},
+			{http.MethodDelete, "/api/v1/books/" + isbn + "/cover", nil},
+			{http.MethodDelete, "/api/v1/books/" + isbn + "/attachments/unknown", nil},
+			{http.MethodPut, "/api/v1/books/" + isbn + "/subjects/unknown", nil},
+			{http.MethodPost, "/api/v1/works", Work{Title: "Pippi"}},
+			{http.MethodPost, "/api/v1/series", Series{Name: "Pippi"}},
+		} {
+			response := serve(req.method, req.path, trainee, req.body)
+			require.Equal(t, http.StatusForbidden, response.Code, req.method+" "+req.path)
+		}
+		require.Equal(t, "", FindSpecificBook(db, other.ISBN).ISBN)
+		require.Equal(t, isbn, FindSpecificBook(db, isbn).ISBN)
+	})
+
+	t.Run("Refuses edits with a session which has ended", func(t *testing.T) {
+		response := serve(http.MethodDelete, "/api/v1/books/"+isbn, "ended", nil)
+		require.Equal(t, http.StatusUnauthorized, response.Code)
+		require.Equal(t, isbn, FindSpecificBook(db, isbn).ISBN)
+	})
+
+	t.Run("Lists and diffs the revisions for reviewers", func(t *testing.T) {
+		require.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/v1/revisions", trainee, nil).Code)
+		response := serve(http.MethodGet, "/api/v1/revisions", librarian, nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		var revisions []Revision
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&revisions))
+		require.Len(t, revisions, 1)
+
+		response = serve(http.MethodGet, "/api/v1/revisions/"+rev.ID, librarian, nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		var got Revision
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Equal(t, []string{"title"}, got.Fields)
+		require.Equal(t, "Pippi Langstrump", got.Current.Title)
+	})
+
+	t.Run("Applies approved revisions", func(t *testing.T) {
+		response := serve(http.MethodPost, "/api/v1/revisions/"+rev.ID+":approve", librarian, nil)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		require.Equal(t, "Pippi Longstocking", FindSpecificBook(db, isbn).Title)
+		require.Equal(t, http.StatusConflict, serve(http.MethodPost, "/api/v1/revisions/"+rev.ID+":approve", librarian, nil).Code)
+	})
+
+	t.Run("Rejects revisions", func(t *testing.T) {
+		rejected := propose("Pippi Lungstrump")
+		response := serve(http.MethodPost, "/api/v1/revisions/"+rejected.ID+":reject", librarian, revisionReview{Comment: "Misspelled"})
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		var got Revision
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Equal(t, RevisionRejected, got.Status)
+		require.Equal(t, "Misspelled", got.Comment)
+		require.Equal(t, "Pippi Longstocking", FindSpecificBook(db, isbn).Title)
+	})
+
+	t.Run("Does not apply stale revisions", func(t *testing.T) {
+		stale := propose("Pippi Lang")
+		changed := book
+		changed.Publisher = "bonnier"
+		require.Equal(t, http.StatusOK, serve(http.MethodPut, "/api/v1/books/"+isbn, librarian, changed).Code)
+		response := serve(http.MethodPost, "/api/v1/revisions/"+stale.ID+":approve", librarian, nil)
+		require.Equal(t, http.StatusConflict, response.Code)
+	})
+}
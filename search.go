@@ -0,0 +1,299 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+// searchTable is the full-text index of the books, see the book_search
+// migration.
+const searchTable = "book_search"
+
+// The markers around the matched terms in the index. They can not occur in
+// the indexed text, so that the text can be escaped before the markers are
+// replaced by <em> tags.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// The sort orders of search results.
+const (
+	SearchSortRelevance = "relevance"
+	SearchSortRecency   = "recency"
+)
+
+// SearchHit is a book which matched a search. Highlights are HTML snippets
+// of the matched fields with the matched terms in <em> tags, they are only
+// set when requested with ?highlight=true.
+type SearchHit struct {
+	Book       Book              `json:"book"`
+	Score      float64           `json:"score"` // Higher is more relevant
+	Highlights map[string]string `json:"highlights,omitempty"`
+	// Fuzzy is set if the book matched the corrected query in DidYouMean
+	// rather than the query
+	Fuzzy bool `json:"fuzzy,omitempty"`
+}
+
+// SearchResult is a page of the books which matched a search.
+type SearchResult struct {
+	Hits          []SearchHit `json:"hits"`
+	Total         int         `json:"total"`
+	NextPageToken string      `json:"nextPageToken,omitempty"`
+	// DidYouMean is the query with its misspelled terms corrected, it is
+	// only suggested when the query has few results
+	DidYouMean string `json:"didYouMean,omitempty"`
+	// Facets count all of the results by the facets requested with
+	// ?facets=publisher,language
+	Facets map[string][]FacetCount `json:"facets,omitempty"`
+}
+
+// indexBook adds the book to the full-text index, and queues it to be sent
+// to the search backend. The translations are indexed with the book, so that
+// it is found by its title in any of its languages.
+func indexBook(db Querier, b Book) error {
+	titles, descriptions := translatedText(b.Translations)
+	_, err := db.Exec("INSERT INTO book_search (isbn, title, author, publisher, description, translatedTitles, translatedDescriptions) VALUES(?,?,?,?,?,?,?)",
+		b.ISBN, b.Title, b.Author.FirstName+" "+b.Author.LastName, b.Publisher, b.Description,
+		strings.Join(titles, "\n"), strings.Join(descriptions, "\n"))
+	if err != nil {
+		return fmt.Errorf("index book err, %w", err)
+	}
+	return queueSearchUpdate(db, b.ISBN)
+}
+
+// rebuildSearchIndex indexes all books again, e.g. after a restore, and
+// queues them to be sent to the search backend.
+func rebuildSearchIndex(db Querier) error {
+	if _, err := db.Exec("DELETE FROM book_search"); err != nil {
+		return fmt.Errorf("clear search index err, %w", err)
+	}
+	_, err := db.Exec("INSERT INTO book_search (isbn, title, author, publisher, description, translatedTitles, translatedDescriptions) " +
+		"SELECT library.isbn, library.title, author.firstName || ' ' || author.lastName, library.publisher, library.description, " +
+		"(SELECT group_concat(title, char(10)) FROM book_translation t WHERE t.isbn = library.isbn), " +
+		"(SELECT group_concat(description, char(10)) FROM book_translation t WHERE t.isbn = library.isbn) " +
+		"FROM library JOIN author ON author.isbn = library.isbn")
+	if err != nil {
+		return fmt.Errorf("rebuild search index err, %w", err)
+	}
+	_, err = queueAllSearchUpdates(db)
+	return err
+}
+
+// translatedText returns the titles and the descriptions of the
+// translations, the translations without a description are skipped in the
+// latter.
+func translatedText(translations []Translation) (titles, descriptions []string) {
+	for _, t := range translations {
+		titles = append(titles, t.Title)
+		if t.Description != "" {
+			descriptions = append(descriptions, t.Description)
+		}
+	}
+	return titles, descriptions
+}
+
+// matchExpression turns a search query into an FTS5 query which matches the
+// books containing all of the terms. The terms are quoted so that the FTS5
+// syntax in a query is searched for rather than interpreted.
+func matchExpression(query string) string {
+	terms := strings.Fields(query)
+	for i, t := range terms {
+		terms[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// searchMatch is a row of the full-text index which matched a search.
+type searchMatch struct {
+	isbn       string
+	score      float64
+	highlights map[string]string
+}
+
+// searchBooks searches the full-text index, the matches are ordered by
+// relevance. The relevance is the BM25 rank of the match with the title and
+// the translated titles weighted highest, then the author.
+func searchBooks(db Querier, query string) ([]searchMatch, error) {
+	rows, err := db.Query("SELECT isbn, rank, "+
+		"highlight(book_search, 1, ?1, ?2), highlight(book_search, 2, ?1, ?2), "+
+		"snippet(book_search, 4, ?1, ?2, '…', 16) "+
+		"FROM book_search WHERE book_search MATCH ?3 ORDER BY rank",
+		matchStart, matchEnd, matchExpression(query))
+	if err != nil {
+		return nil, fmt.Errorf("search books err, %w", err)
+	}
+	defer rows.Close()
+	var matches []searchMatch
+	for rows.Next() {
+		var m searchMatch
+		var title, author, description string
+		if err := rows.Scan(&m.isbn, &m.score, &title, &author, &description); err != nil {
+			return nil, fmt.Errorf("scan search match err, %w", err)
+		}
+		// BM25 ranks are negative with the best match lowest
+		m.score = -m.score
+		m.highlights = make(map[string]string)
+		for field, text := range map[string]string{"title": title, "author": author, "description": description} {
+			if strings.Contains(text, matchStart) {
+				m.highlights[field] = highlightHTML(text)
+			}
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// filterHits returns the hits whose book keep returns true for.
+func filterHits(hits []SearchHit, keep func(Book) bool) []SearchHit {
+	kept := []SearchHit{}
+	for _, h := range hits {
+		if keep(h.Book) {
+			kept = append(kept, h)
+		}
+	}
+	return kept
+}
+
+// highlightHTML escapes the text and replaces the match markers by <em>
+// tags.
+func highlightHTML(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, matchStart, "<em>")
+	return strings.ReplaceAll(text, matchEnd, "</em>")
+}
+
+// SearchBookList searches the books by title, author, publisher and
+// description. Embargoed books are left out. The results are sorted by
+// relevance unless ?sort=recency, which lists the newest books first, and
+// paged with page_size and page_token. The results can be counted by facets,
+// e.g. ?facets=publisher, and filtered by publisher, format and language. A
+// query with few results suggests a corrected query and includes its
+// results, so that "geroge lucsa" finds George Lucas.
+func (s *Server) SearchBookList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		HandleErr(w, http.StatusBadRequest, "q must not be empty")
+		return
+	}
+	sortKey := r.URL.Query().Get("sort")
+	if sortKey == "" {
+		sortKey = SearchSortRelevance
+	}
+	if sortKey != SearchSortRelevance && sortKey != SearchSortRecency {
+		HandleErr(w, http.StatusBadRequest, "sort must be one of relevance or recency")
+		return
+	}
+	offset, size, err := parsePage(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	facets, err := parseFacets(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lang := language.Und
+	if v := r.URL.Query().Get("language"); v != "" {
+		if lang, err = language.Parse(v); err != nil {
+			HandleErr(w, http.StatusBadRequest, "language must be a BCP 47 language tag")
+			return
+		}
+	}
+
+	public := make(map[string]Book)
+	for _, b := range localizeAll(ReadPublicBookList(s.db, time.Now()), r.Header.Get("Accept-Language")) {
+		public[b.ISBN] = b
+	}
+	highlight := r.URL.Query().Get("highlight") == "true"
+	hits := []SearchHit{}
+	seen := make(map[string]bool)
+	addHits := func(query string, fuzzy bool) error {
+		matches, err := s.matchBooks(r.Context(), query)
+		if err != nil {
+			return err
+		}
+		for _, m := range matches {
+			b, ok := public[m.isbn]
+			if !ok || seen[m.isbn] {
+				continue
+			}
+			seen[m.isbn] = true
+			hit := SearchHit{Book: b, Score: m.score, Fuzzy: fuzzy}
+			if highlight && len(m.highlights) != 0 {
+				hit.Highlights = m.highlights
+			}
+			hits = append(hits, hit)
+		}
+		return nil
+	}
+	if err := addHits(query, false); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to search the books")
+		return
+	}
+	// Misspelled queries find the books of the corrected query after the
+	// exact results
+	var didYouMean string
+	if len(hits) < sparseResults {
+		corrected, ok, err := correctQuery(s.db, query)
+		if err == nil && ok {
+			didYouMean = corrected
+			err = addHits(corrected, true)
+		}
+		if err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to search the books")
+			return
+		}
+	}
+	// The facets are also filters, so that the results can be narrowed down
+	// by a value of a facet
+	if publisher := r.URL.Query().Get("publisher"); publisher != "" {
+		hits = filterHits(hits, func(b Book) bool { return b.Publisher == publisher })
+	}
+	if format := r.URL.Query().Get("format"); format != "" {
+		hits = filterHits(hits, func(b Book) bool { return b.Format == format })
+	}
+	if lang != language.Und {
+		hits = filterHits(hits, func(b Book) bool { return matchesLanguage(b, lang) })
+	}
+	if sortKey == SearchSortRecency {
+		sort.SliceStable(hits, func(i, j int) bool {
+			return hits[i].Book.CreateTime.After(hits[j].Book.CreateTime)
+		})
+	}
+
+	res := SearchResult{Hits: []SearchHit{}, Total: len(hits), DidYouMean: didYouMean}
+	if len(facets) != 0 {
+		isbns := make([]string, len(hits))
+		for i, h := range hits {
+			isbns[i] = h.Book.ISBN
+		}
+		res.Facets = make(map[string][]FacetCount)
+		for _, facet := range facets {
+			if res.Facets[facet], err = CountFacet(s.db, facet, isbns); err != nil {
+				HandleErr(w, http.StatusInternalServerError, "Failed to count the facets")
+				return
+			}
+		}
+	}
+	if offset < len(hits) {
+		res.Hits = hits[offset:]
+	}
+	if len(res.Hits) > size {
+		res.Hits = res.Hits[:size]
+		res.NextPageToken = pageToken(offset + size)
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the search result")
+		return
+	}
+}
@@ -0,0 +1,145 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchBooks(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	lucas := &Author{FirstName: "george", LastName: "lucas"}
+	embargo := time.Now().Add(time.Hour)
+	for _, b := range []Book{
+		{ISBN: "1233211233215", Title: "the empire strikes back"},
+		{ISBN: "1233211233213", Title: "a new hope", Description: "The rebels <fight> the empire",
+			Translations: []Translation{{Language: "sv", Title: "Ett nytt hopp", Description: "Rebellerna kämpar mot imperiet"}}},
+		{ISBN: "1233211233210", Title: "return of the jedi", Description: "The empire falls", AvailableFrom: &embargo},
+	} {
+		b.Author, b.Publisher = lucas, "lucasfilm"
+		jsonBytes, _ := json.Marshal(b)
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+b.ISBN, jsonBytes, db).Code)
+		// The creation times must differ for the recency order
+		time.Sleep(10 * time.Millisecond)
+	}
+	search := func(query url.Values) SearchResult {
+		t.Helper()
+		response := createNewRequest(http.MethodGet, "/api/v1/books:search?"+query.Encode(), nil, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		var res SearchResult
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		return res
+	}
+	isbns := func(res SearchResult) []string {
+		var isbns []string
+		for _, h := range res.Hits {
+			isbns = append(isbns, h.Book.ISBN)
+		}
+		return isbns
+	}
+
+	t.Run("Ranks title matches first", func(t *testing.T) {
+		res := search(url.Values{"q": {"Empire"}})
+		require.Equal(t, 2, res.Total)
+		require.Equal(t, []string{"1233211233215", "1233211233213"}, isbns(res))
+		require.Greater(t, res.Hits[0].Score, res.Hits[1].Score)
+		require.Nil(t, res.Hits[0].Highlights)
+	})
+
+	t.Run("Sorts by recency", func(t *testing.T) {
+		res := search(url.Values{"q": {"empire"}, "sort": {"recency"}})
+		require.Equal(t, []string{"1233211233213", "1233211233215"}, isbns(res))
+	})
+
+	t.Run("Highlights the matches", func(t *testing.T) {
+		res := search(url.Values{"q": {"empire"}, "highlight": {"true"}})
+		require.Equal(t, map[string]string{"title": "the <em>empire</em> strikes back"}, res.Hits[0].Highlights)
+		require.Equal(t, map[string]string{"description": "The rebels &lt;fight&gt; the <em>empire</em>"}, res.Hits[1].Highlights)
+	})
+
+	t.Run("Matches all of the terms", func(t *testing.T) {
+		res := search(url.Values{"q": {`lucas "hope`}})
+		require.Equal(t, []string{"1233211233213"}, isbns(res))
+	})
+
+	t.Run("Matches the translations", func(t *testing.T) {
+		require.Equal(t, []string{"1233211233213"}, isbns(search(url.Values{"q": {"nytt hopp"}})))
+		require.Equal(t, []string{"1233211233213"}, isbns(search(url.Values{"q": {"imperiet"}})))
+
+		require.NoError(t, rebuildSearchIndex(db))
+		require.Equal(t, []string{"1233211233213"}, isbns(search(url.Values{"q": {"nytt hopp"}})))
+	})
+
+	t.Run("Corrects misspelled queries", func(t *testing.T) {
+		res := search(url.Values{"q": {"geroge lucsa"}})
+		require.Equal(t, "george lucas", res.DidYouMean)
+		require.Equal(t, 2, res.Total)
+		require.True(t, res.Hits[0].Fuzzy)
+
+		res = search(url.Values{"q": {"empir strikes"}})
+		require.Equal(t, "empire strikes", res.DidYouMean)
+		require.Equal(t, []string{"1233211233215"}, isbns(res))
+
+		res = search(url.Values{"q": {"xyzzy"}})
+		require.Empty(t, res.DidYouMean)
+		require.Empty(t, res.Hits)
+	})
+
+	t.Run("Counts the facets of the results", func(t *testing.T) {
+		res := search(url.Values{"q": {"lucas"}, "facets": {"publisher,format"}})
+		require.Equal(t, map[string][]FacetCount{
+			"publisher": {{Value: "lucasfilm", Count: 2}},
+			"format":    {},
+		}, res.Facets)
+
+		res = search(url.Values{"q": {"lucas"}, "publisher": {"adlibris"}, "facets": {"publisher"}})
+		require.Empty(t, res.Hits)
+		require.Equal(t, map[string][]FacetCount{"publisher": {}}, res.Facets)
+
+		response := createNewRequest(http.MethodGet, "/api/v1/books:search?q=lucas&facets=tag", nil, db)
+		require.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Pages through the results", func(t *testing.T) {
+		res := search(url.Values{"q": {"lucas"}, "page_size": {"1"}})
+		require.Equal(t, 2, res.Total)
+		require.Len(t, res.Hits, 1)
+		res = search(url.Values{"q": {"lucas"}, "page_size": {"1"}, "page_token": {res.NextPageToken}})
+		require.Len(t, res.Hits, 1)
+		require.Empty(t, res.NextPageToken)
+	})
+
+	t.Run("Forgets deleted books", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodDelete, "/api/v1/books/1233211233215", nil, db).Code)
+		require.Equal(t, []string{"1233211233213"}, isbns(search(url.Values{"q": {"empire"}})))
+	})
+
+	t.Run("Rejects invalid searches", func(t *testing.T) {
+		for _, query := range []string{"", "q=empire&sort=title"} {
+			response := createNewRequest(http.MethodGet, "/api/v1/books:search?"+query, nil, db)
+			require.Equal(t, http.StatusBadRequest, response.Code, query)
+		}
+	})
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"lucas", "lucas", 0},
+		{"lucsa", "lucas", 1},
+		{"geroge", "george", 1},
+		{"empir", "empire", 1},
+		{"hope", "rope", 1},
+		{"jedi", "", 4},
+	} {
+		require.Equal(t, tc.want, editDistance(tc.a, tc.b), "%s %s", tc.a, tc.b)
+	}
+}
@@ -0,0 +1,207 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NicolaiMordrup/library/opensearch"
+)
+
+// SearchBackend is an external search engine which the books are mirrored
+// into, e.g. an opensearch.Client.
+type SearchBackend interface {
+	Bulk(ctx context.Context, docs []opensearch.Document, deletes []string) error
+	Search(ctx context.Context, query string, limit int) ([]opensearch.Hit, error)
+	DeleteAll(ctx context.Context) error
+}
+
+// maxBackendHits limits the number of results read from the search backend,
+// the results are paged and counted by the server.
+const maxBackendHits = 1000
+
+// WithSearchBackend routes searches to the backend, with the full-text index
+// in the database as a fallback when the backend fails. The backend is kept
+// up to date by a SearchIndexer.
+func WithSearchBackend(b SearchBackend) ServerOption {
+	return func(s *Server) {
+		s.searchBackend = b
+	}
+}
+
+// queueSearchUpdate queues the book with the given isbn to be sent to the
+// search backend. The queue is written in the transaction of the change, so
+// that no change is lost if the backend is down. Without a backend the queue
+// holds at most one row per book.
+func queueSearchUpdate(db Querier, isbn string) error {
+	if _, err := db.Exec("INSERT OR REPLACE INTO search_index_queue (isbn, queueTime) VALUES(?,?)", isbn, time.Now()); err != nil {
+		return fmt.Errorf("queue search update err, %w", err)
+	}
+	return nil
+}
+
+// queueAllSearchUpdates queues every book to be sent to the search backend.
+func queueAllSearchUpdates(db Querier) (int64, error) {
+	res, err := db.Exec("INSERT OR REPLACE INTO search_index_queue (isbn, queueTime) SELECT isbn, ? FROM library", time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("queue search updates err, %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// queuedSearchUpdate is a row of the queue. The rowid changes when a book is
+// queued again, so that a change made while the previous one was being sent
+// is not removed from the queue.
+type queuedSearchUpdate struct {
+	rowid int64
+	isbn  string
+}
+
+func readSearchQueue(db Querier, limit int) ([]queuedSearchUpdate, error) {
+	rows, err := db.Query("SELECT rowid, isbn FROM search_index_queue ORDER BY rowid LIMIT ?", limit)
+	if err != nil {
+		return nil, fmt.Errorf("query search queue err, %w", err)
+	}
+	defer rows.Close()
+	var queue []queuedSearchUpdate
+	for rows.Next() {
+		var u queuedSearchUpdate
+		if err := rows.Scan(&u.rowid, &u.isbn); err != nil {
+			return nil, fmt.Errorf("scan search queue err, %w", err)
+		}
+		queue = append(queue, u)
+	}
+	return queue, rows.Err()
+}
+
+// searchDocument converts a book to its document in the search backend.
+func searchDocument(b Book) opensearch.Document {
+	titles, descriptions := translatedText(b.Translations)
+	return opensearch.Document{
+		ISBN:                   b.ISBN,
+		Title:                  b.Title,
+		Author:                 b.Author.FirstName + " " + b.Author.LastName,
+		Publisher:              b.Publisher,
+		Description:            b.Description,
+		TranslatedTitles:       titles,
+		TranslatedDescriptions: descriptions,
+	}
+}
+
+// SearchIndexer sends the queued changes of the books to the search backend
+// every Interval, BatchSize books per request. Failed requests are retried
+// on the next run.
+type SearchIndexer struct {
+	Backend   SearchBackend
+	Interval  time.Duration
+	BatchSize int // Defaults to 500
+	// OnError is called when the changes could not be sent, e.g. to alert
+	// that the search results are getting stale.
+	OnError func(error)
+}
+
+// Run sends the queued changes until the context is done.
+func (ix SearchIndexer) Run(ctx context.Context, db Querier) {
+	ticker := time.NewTicker(ix.Interval)
+	defer ticker.Stop()
+	for {
+		if err := ix.Flush(ctx, db); err != nil && ix.OnError != nil {
+			ix.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush sends the queued changes until the queue is empty. Embargoed books
+// are indexed too, since an embargo ends without a write, and are left out
+// of the results by the server.
+func (ix SearchIndexer) Flush(ctx context.Context, db Querier) error {
+	size := ix.BatchSize
+	if size == 0 {
+		size = 500
+	}
+	for {
+		queue, err := readSearchQueue(db, size)
+		if err != nil || len(queue) == 0 {
+			return err
+		}
+		var docs []opensearch.Document
+		var deletes []string
+		for _, u := range queue {
+			if b := FindSpecificBook(db, u.isbn); b.ISBN != "" {
+				docs = append(docs, searchDocument(b))
+			} else {
+				deletes = append(deletes, u.isbn)
+			}
+		}
+		if err := ix.Backend.Bulk(ctx, docs, deletes); err != nil {
+			return err
+		}
+		for _, u := range queue {
+			if _, err := db.Exec("DELETE FROM search_index_queue WHERE rowid = ?", u.rowid); err != nil {
+				return fmt.Errorf("delete from search queue err, %w", err)
+			}
+		}
+	}
+}
+
+// matchBooks searches the search backend if there is one, and otherwise the
+// full-text index in the database.
+func (s *Server) matchBooks(ctx context.Context, query string) ([]searchMatch, error) {
+	if s.searchBackend != nil {
+		hits, err := s.searchBackend.Search(ctx, query, maxBackendHits)
+		if err == nil {
+			matches := make([]searchMatch, len(hits))
+			for i, h := range hits {
+				matches[i] = searchMatch{isbn: h.ISBN, score: h.Score, highlights: h.Highlights}
+			}
+			return matches, nil
+		}
+		// The database has a full-text index of its own to fall back to
+		handleErr("search backend failed, searching the database instead", err)
+	}
+	return searchBooks(s.db, query)
+}
+
+// ReindexSearch sends every book to the search backend again, after
+// deleting the documents in it. The books are sent by the SearchIndexer in
+// the background. Only admins can reindex.
+func (s *Server) ReindexSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.adminMember(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if s.searchBackend == nil {
+		HandleErr(w, http.StatusConflict, "No search backend is configured")
+		return
+	}
+	if err := s.searchBackend.DeleteAll(r.Context()); err != nil {
+		HandleErr(w, http.StatusBadGateway, "Failed to clear the search backend")
+		return
+	}
+	var queued int64
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		var err error
+		queued, err = queueAllSearchUpdates(tx)
+		return err
+	})
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to queue the books")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(struct {
+		Queued int64 `json:"queued"`
+	}{queued}); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the result")
+		return
+	}
+}
@@ -0,0 +1,140 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/opensearch"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSearchBackend is a search backend which keeps the documents in memory.
+type fakeSearchBackend struct {
+	docs map[string]opensearch.Document
+	err  error
+}
+
+func (f *fakeSearchBackend) Bulk(ctx context.Context, docs []opensearch.Document, deletes []string) error {
+	if f.err != nil {
+		return f.err
+	}
+	for _, d := range docs {
+		f.docs[d.ISBN] = d
+	}
+	for _, isbn := range deletes {
+		delete(f.docs, isbn)
+	}
+	return nil
+}
+
+func (f *fakeSearchBackend) Search(ctx context.Context, query string, limit int) ([]opensearch.Hit, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var hits []opensearch.Hit
+	for isbn, d := range f.docs {
+		if d.Publisher == query {
+			hits = append(hits, opensearch.Hit{ISBN: isbn, Score: 1})
+		}
+	}
+	return hits, nil
+}
+
+func (f *fakeSearchBackend) DeleteAll(ctx context.Context) error {
+	f.docs = make(map[string]opensearch.Document)
+	return f.err
+}
+
+func TestSearchBackend(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	backend := &fakeSearchBackend{docs: make(map[string]opensearch.Document)}
+	indexer := SearchIndexer{Backend: backend}
+	server := NewServer(db, WithSearchBackend(backend))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(method, path, nil))
+		return response
+	}
+	admin := createMemberSession(t, db, "admin", RoleAdmin)
+	search := func(query string) []string {
+		t.Helper()
+		response := serve(http.MethodGet, "/api/v1/books:search?"+url.Values{"q": {query}}.Encode())
+		require.Equal(t, http.StatusOK, response.Code)
+		var res SearchResult
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		var isbns []string
+		for _, h := range res.Hits {
+			isbns = append(isbns, h.Book.ISBN)
+		}
+		return isbns
+	}
+
+	for _, isbn := range []string{"1233211233215", "1233211233213"} {
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: starWarsTitles[isbn],
+			Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "lucasfilm",
+			Translations: []Translation{{Language: "sv", Title: "Stjärnornas krig"}}})
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code)
+	}
+
+	t.Run("Sends the queued changes", func(t *testing.T) {
+		require.NoError(t, indexer.Flush(context.Background(), db))
+		require.Len(t, backend.docs, 2)
+		require.Equal(t, []string{"Stjärnornas krig"}, backend.docs["1233211233215"].TranslatedTitles)
+		queue, err := readSearchQueue(db, 10)
+		require.NoError(t, err)
+		require.Empty(t, queue)
+	})
+
+	t.Run("Searches the backend", func(t *testing.T) {
+		require.ElementsMatch(t, []string{"1233211233215", "1233211233213"}, search("lucasfilm"))
+	})
+
+	t.Run("Retries failed changes", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodDelete, "/api/v1/books/1233211233213", nil, db).Code)
+		backend.err = errors.New("unavailable")
+		require.Error(t, indexer.Flush(context.Background(), db))
+		require.Len(t, backend.docs, 2)
+
+		backend.err = nil
+		require.NoError(t, indexer.Flush(context.Background(), db))
+		require.Len(t, backend.docs, 1)
+	})
+
+	t.Run("Falls back to the database when the backend fails", func(t *testing.T) {
+		backend.err = errors.New("unavailable")
+		defer func() { backend.err = nil }()
+		require.Equal(t, []string{"1233211233215"}, search("hope"))
+	})
+
+	t.Run("Lets only admins reindex", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "/api/v1/admin/search:reindex").Code)
+		librarian := createMemberSession(t, db, "astrid", RoleLibrarian)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/search:reindex", nil)
+		req.Header.Set("Authorization", "Bearer "+librarian)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		require.Equal(t, http.StatusForbidden, response.Code)
+		require.Len(t, backend.docs, 1)
+	})
+
+	t.Run("Reindexes every book", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/search:reindex", nil)
+		req.Header.Set("Authorization", "Bearer "+admin)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		require.Equal(t, http.StatusAccepted, response.Code)
+		require.Empty(t, backend.docs)
+		require.NoError(t, indexer.Flush(context.Background(), db))
+		require.Len(t, backend.docs, 1)
+
+		response = createNewMemberRequest(http.MethodPost, "/api/v1/admin/search:reindex", nil, db, admin)
+		require.Equal(t, http.StatusConflict, response.Code)
+	})
+}
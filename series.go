@@ -0,0 +1,209 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Series is a named sequence of books, e.g. the volumes of a trilogy. A book
+// joins a series by setting its seriesId and seriesVolume.
+type Series struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	CreateTime time.Time `json:"createTime"`
+	Volumes    []Book    `json:"volumes,omitempty"` // Only set when a single series is retrieved
+}
+
+func validateSeries(s Series) error {
+	var fieldErrors []string
+	if !titlePattern.MatchString(s.Name) {
+		fieldErrors = append(fieldErrors, " name ")
+	}
+	if len(fieldErrors) != 0 {
+		return fmt.Errorf("validation failed, field error(s):%v. Fix these error before proceeding",
+			strings.Join(fieldErrors, ", "))
+	}
+	return nil
+}
+
+// InsertSeries stores a series.
+func InsertSeries(db Querier, s Series) error {
+	_, err := db.Exec("INSERT INTO series (id, name, createTime) VALUES(?,?,?)",
+		s.ID, s.Name, s.CreateTime)
+	if err != nil {
+		return fmt.Errorf("insert series err, %w", err)
+	}
+	return nil
+}
+
+// FindSeries reads a series. It returns sql.ErrNoRows if there is no such
+// series.
+func FindSeries(db Querier, id string) (Series, error) {
+	var s Series
+	err := db.QueryRow("SELECT id, name, createTime FROM series WHERE id = ?", id).
+		Scan(&s.ID, &s.Name, &s.CreateTime)
+	return s, err
+}
+
+// ReadSeriesList reads all series ordered by name.
+func ReadSeriesList(db Querier) ([]Series, error) {
+	rows, err := db.Query("SELECT id, name, createTime FROM series ORDER BY name, id")
+	if err != nil {
+		return nil, fmt.Errorf("query series err, %w", err)
+	}
+	defer rows.Close()
+	var series []Series
+	for rows.Next() {
+		var s Series
+		if err := rows.Scan(&s.ID, &s.Name, &s.CreateTime); err != nil {
+			return nil, fmt.Errorf("scan series err, %w", err)
+		}
+		series = append(series, s)
+	}
+	return series, rows.Err()
+}
+
+// DeleteSeries deletes a series, its volumes are kept outside of any series.
+func DeleteSeries(db Querier, id string) error {
+	if _, err := db.Exec("UPDATE library SET seriesId = '', seriesVolume = 0 WHERE seriesId = ?", id); err != nil {
+		return fmt.Errorf("remove volumes err, %w", err)
+	}
+	if _, err := db.Exec("DELETE FROM series WHERE id = ?", id); err != nil {
+		return fmt.Errorf("delete series err, %w", err)
+	}
+	return nil
+}
+
+// volumesOf returns the books in the series ordered by volume.
+func volumesOf(books []Book, seriesID string) []Book {
+	volumes := filterBooks(books, func(b Book) bool { return b.SeriesID == seriesID })
+	sort.SliceStable(volumes, func(i, j int) bool {
+		return volumes[i].SeriesVolume < volumes[j].SeriesVolume
+	})
+	return volumes
+}
+
+// nextInSeries returns the ISBN of the public volume following the book in
+// its series. Volumes may be missing from the catalog, so this is the
+// volume with the lowest number after the book's.
+func nextInSeries(db Querier, b Book, now time.Time) string {
+	if b.SeriesID == "" {
+		return ""
+	}
+	for _, volume := range volumesOf(ReadPublicBookList(db, now), b.SeriesID) {
+		if volume.SeriesVolume > b.SeriesVolume {
+			return volume.ISBN
+		}
+	}
+	return ""
+}
+
+// checkSeriesExists returns a validation error if the book refers to a
+// series which does not exist.
+func checkSeriesExists(q Querier, b Book) error {
+	if b.SeriesID == "" {
+		return nil
+	}
+	_, err := FindSeries(q, b.SeriesID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &statusError{http.StatusNotAcceptable, "validation failed, field error(s): series . Fix these error before proceeding"}
+	}
+	return err
+}
+
+// GetSeriesList retrieves all series.
+func (s *Server) GetSeriesList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	series, err := ReadSeriesList(s.db)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the series")
+		return
+	}
+	if series == nil {
+		series = []Series{}
+	}
+	if err := json.NewEncoder(w).Encode(series); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the series")
+		return
+	}
+}
+
+// GetSeries retrieves a series with its public volumes in order.
+func (s *Server) GetSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	series, err := FindSeries(s.db, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The series does not exist")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the series")
+		return
+	}
+	series.Volumes = volumesOf(ReadPublicBookList(s.db, time.Now()), series.ID)
+	if err := json.NewEncoder(w).Encode(series); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the series")
+		return
+	}
+}
+
+// CreateSeries creates a series.
+func (s *Server) CreateSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var series Series
+	if err := decodeJSON(r, &series); err != nil {
+		handleDecodeErr(w, err, "Failed to decode series")
+		return
+	}
+	if err := validateSeries(series); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	series.ID = s.idGenerator.NewID()
+	series.CreateTime = time.Now()
+	series.Volumes = nil
+	if err := InsertSeries(s.db, series); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the series")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(series); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the series")
+		return
+	}
+}
+
+// DeleteSeries deletes a series, its volumes are kept.
+func (s *Server) DeleteSeries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if _, err := FindSeries(tx, id); errors.Is(err, sql.ErrNoRows) {
+			return &statusError{http.StatusNotFound, "The series does not exist"}
+		} else if err != nil {
+			return err
+		}
+		return DeleteSeries(tx, id)
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to delete the series")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,88 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeries(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	var series Series
+	t.Run("Creates a series", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(Series{Name: "Star Wars"})
+		response := createNewRequest(http.MethodPost, "/api/v1/series", jsonBytes, db)
+		require.Equal(t, http.StatusCreated, response.Code)
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&series))
+		require.NotEmpty(t, series.ID)
+	})
+
+	lucas := &Author{FirstName: "George", LastName: "Lucas"}
+	createVolume := func(isbn string, volume int) int {
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: starWarsTitles[isbn], Author: lucas,
+			Publisher: "lucasfilm", SeriesID: series.ID, SeriesVolume: volume})
+		return createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db).Code
+	}
+	// Volume 2 is missing so the next volume after 1 is 3
+	require.Equal(t, http.StatusOK, createVolume("1233211233210", 4))
+	require.Equal(t, http.StatusOK, createVolume("1233211233215", 1))
+	require.Equal(t, http.StatusOK, createVolume("1233211233213", 3))
+
+	t.Run("Lists the volumes in order", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books?series="+series.ID, nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var got []Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Len(t, got, 3)
+		require.Equal(t, "1233211233215", got[0].ISBN)
+		require.Equal(t, "1233211233213", got[1].ISBN)
+		require.Equal(t, "1233211233210", got[2].ISBN)
+
+		response = createNewRequest(http.MethodGet, "/api/v1/series/"+series.ID, nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var detail Series
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&detail))
+		require.Len(t, detail.Volumes, 3)
+	})
+
+	t.Run("Returns the next volume in the series", func(t *testing.T) {
+		for isbn, next := range map[string]string{
+			"1233211233215": "1233211233213",
+			"1233211233213": "1233211233210",
+			"1233211233210": "",
+		} {
+			response := createNewRequest(http.MethodGet, "/api/v1/books/"+isbn, nil, db)
+			var book Book
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&book))
+			require.Equal(t, next, book.NextInSeries, isbn)
+		}
+	})
+
+	t.Run("Rejects invalid series membership", func(t *testing.T) {
+		for _, book := range []Book{
+			{ISBN: "1233211233218", Title: "Rogue One", Author: lucas, Publisher: "lucasfilm", SeriesID: "missing", SeriesVolume: 1},
+			{ISBN: "1233211233218", Title: "Rogue One", Author: lucas, Publisher: "lucasfilm", SeriesID: series.ID},
+			{ISBN: "1233211233218", Title: "Rogue One", Author: lucas, Publisher: "lucasfilm", SeriesVolume: 2},
+		} {
+			jsonBytes, _ := json.Marshal(book)
+			response := createNewRequest(http.MethodPost, "/api/v1/books/1233211233218", jsonBytes, db)
+			assertStatus(t, response.Code, http.StatusNotAcceptable, "Should get status "+
+				"code 406: status not acceptable")
+		}
+	})
+
+	t.Run("Deleting the series keeps its volumes", func(t *testing.T) {
+		response := createNewRequest(http.MethodDelete, "/api/v1/series/"+series.ID, nil, db)
+		require.Equal(t, http.StatusNoContent, response.Code)
+		book := FindSpecificBook(db, "1233211233215")
+		require.Empty(t, book.SeriesID)
+		require.Zero(t, book.SeriesVolume)
+
+		response = createNewRequest(http.MethodGet, "/api/v1/series/"+series.ID, nil, db)
+		require.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
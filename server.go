@@ -2,17 +2,25 @@ package library
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/NicolaiMordrup/library/antivirus"
+	"github.com/NicolaiMordrup/library/blobs"
+	"github.com/NicolaiMordrup/library/events"
+	"github.com/NicolaiMordrup/library/ids"
 	"github.com/gorilla/mux"
+	"golang.org/x/text/language"
 )
 
 type BookErr string
 
-//TODO fixa så att dessa stämmer
+// TODO fixa så att dessa stämmer
 const (
 	jsonContentType = "application/json"
 	ErrEncodeFail   = BookErr("Failed to Encode the book instance")
@@ -28,33 +36,346 @@ type Server struct {
 	router                    *mux.Router
 	db                        *sql.DB
 	minDurationBetweenUpdates time.Duration
+	allowedMethods            map[string][]string // Methods per path template
+	locale                    language.Tag        // Used to sort titles and names
+	callNumberScheme          CallNumberScheme    // Generates call numbers of new books
+	catalogSources            []CatalogSource     // Used to pre-fill new books
+	oaiRepository             OAIRepository
+	warm                      *int32 // Set to 1 by Warmup, nil without warmup
+	idGenerator               ids.Generator
+	captures                  *captureBuffer // nil unless capturing is enabled
+	maxBodyBytes              int64
+	timeouts                  Timeouts
+	undoWindow                time.Duration // How long destructive operations can be undone
+	lockTTL                   time.Duration // How long the editing lock of a book lasts
+	suggestions               *suggestIndex
+	searchBackend             SearchBackend // nil unless searches are routed to a search engine
+	recordEvents              bool          // Whether the changes are written to the event outbox
+	oidcLogin                 *OIDCLogin    // nil unless members can log in through OIDC
+	sessionTimeouts           SessionTimeouts
+	sessionKey                []byte // Signs the session and CSRF cookies, and the download URLs
+	lockoutPolicy             LockoutPolicy
+	keys                      *keyring          // nil unless secrets can be stored, see WithKeyEncrypter
+	twoFactorRoles            []string          // The roles which must log in with a TOTP code
+	messages                  *messageCatalogs  // The translations of the error messages
+	labelLayout               LabelLayout       // The default layout of the spine labels
+	ebookDir                  string            // Where e-book files are stored, "" if they can not be uploaded
+	namespace                 string            // Keeps the files apart from other servers, see WithNamespace
+	blobStore                 blobs.Store       // nil unless files can be attached to books
+	scanner                   antivirus.Scanner // nil unless uploads are scanned for malware
+	writeMu                   chan struct{}     // Serializes the transactions, see inTx
+	maxBooks                  func() int        // The quota of books of a tenant, nil or 0 if unlimited
+	// The storage quota of a tenant and the size of its database, nil if unlimited
+	storageQuota func() (max, databaseBytes int64, err error)
+}
+
+// ServerOption configures optional settings of the server.
+type ServerOption func(*Server)
+
+// WithLocale sets the locale whose collation rules are used when sorting
+// books. The default is language.Und, the root collation.
+func WithLocale(locale language.Tag) ServerOption {
+	return func(s *Server) {
+		s.locale = locale
+	}
+}
+
+// WithIDGenerator sets the strategy used to generate the internal ids of new
+// entities. The default is ids.UUIDv7.
+func WithIDGenerator(g ids.Generator) ServerOption {
+	return func(s *Server) {
+		s.idGenerator = g
+	}
+}
+
+// defaultMaxBodyBytes is the default limit of the size of request bodies.
+const defaultMaxBodyBytes = 1 << 20
+
+// WithMaxBodySize limits the size of request bodies, larger requests are
+// rejected with 413 Request Entity Too Large. The limit applies to file
+// imports as well, but not to restores of backups. The default is 1 MiB.
+func WithMaxBodySize(n int64) ServerOption {
+	return func(s *Server) {
+		s.maxBodyBytes = n
+	}
+}
+
+// WithCallNumberScheme sets the scheme used to generate the call number of
+// books which are cataloged without one. The default is CutterScheme.
+func WithCallNumberScheme(scheme CallNumberScheme) ServerOption {
+	return func(s *Server) {
+		s.callNumberScheme = scheme
+	}
 }
 
 // NewServer creates a new server instance.
-func NewServer(datab *sql.DB) *Server {
-	s := &Server{}
+func NewServer(datab *sql.DB, opts ...ServerOption) *Server {
+	s := &Server{
+		router:                    mux.NewRouter(),
+		allowedMethods:            make(map[string][]string),
+		locale:                    language.Und,
+		callNumberScheme:          CutterScheme{},
+		idGenerator:               ids.UUIDv7{},
+		maxBodyBytes:              defaultMaxBodyBytes,
+		timeouts:                  defaultTimeouts,
+		undoWindow:                defaultUndoWindow,
+		lockTTL:                   defaultLockTTL,
+		minDurationBetweenUpdates: defaultMinDurationBetweenUpdates,
+		sessionTimeouts:           defaultSessionTimeouts,
+		lockoutPolicy:             defaultLockoutPolicy,
+		suggestions:               newSuggestIndex(),
+		messages:                  newMessageCatalogs(),
+		labelLayout:               defaultLabelLayout,
+		writeMu:                   make(chan struct{}, 1),
+		oaiRepository: OAIRepository{
+			Name:       "Library",
+			Identifier: "library",
+			AdminEmail: "admin@localhost",
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.sessionKey == nil {
+		s.sessionKey = randomSessionKey()
+	}
+
+	// /api/v1 is the canonical API and /api is a deprecated alias of it. A
+	// new version of the API registers its own routes under its own prefix.
+	s.routesV1("/api/v1", noMiddleware)
+	s.routesV1("/api", deprecatedAlias("/api", "/api/v1"))
+
+	// OAI-PMH is a protocol of its own and is not versioned with the API
+	s.route("/oai", http.MethodGet, s.OAIPMH)
+	s.route("/oai", http.MethodPost, s.OAIPMH)
+	s.route("/readyz", http.MethodGet, s.Readiness)
+	// The public pages are for search engines and browsers, not the API
+	s.route("/sitemap.xml", http.MethodGet, s.GetSitemap)
+	s.route("/books/{isbn}", http.MethodGet, s.GetBookPage)
+	s.route("/debug/vars", http.MethodGet, s.GetDebugVars)
+	// The pages of the admin UI are only for the staff, see adminPage
+	s.route("/admin", http.MethodGet, s.AdminHome)
+	s.route(adminLoginPath, http.MethodGet, s.AdminLogin)
+	s.route("/admin/books", http.MethodGet, s.adminPage(s.AdminListBooks))
+	s.route("/admin/books/{isbn}", http.MethodGet, s.adminPage(s.AdminGetBook))
+	s.route("/admin/books/{isbn}", http.MethodPost, s.adminPage(s.AdminUpdateBook))
+	s.route("/admin/import", http.MethodGet, s.adminPage(s.AdminImport))
+	s.route(assetsPath+"{name}", http.MethodGet, s.ServeAsset)
 
-	router := mux.NewRouter()
-	router.HandleFunc("/api/books", s.GetBooks).Methods("GET")
-	router.HandleFunc("/api/books/{isbn}", s.GetBook).Methods("GET")
-	router.HandleFunc("/api/books/{isbn}", s.CreateBook).Methods("POST")
-	router.HandleFunc("/api/books/{isbn}", s.UpdateBook).Methods("PUT")
-	router.HandleFunc("/api/books/{isbn}", s.DeleteBook).Methods("DELETE")
+	// OPTIONS is registered last so that it advertises every method of a path
+	for path, methods := range s.allowedMethods {
+		s.router.HandleFunc(path, s.Options(methods)).Methods(http.MethodOptions)
+	}
 
-	s.router = router
 	s.db = datab
 	return s
 }
 
+// routesV1 registers the routes of version 1 of the API under prefix. Every
+// handler is wrapped by mw.
+func (s *Server) routesV1(prefix string, mw middleware) {
+	s.route(prefix+"/books", http.MethodGet, mw(s.GetBooks))
+	s.route(prefix+"/books", http.MethodDelete, mw(s.DeleteBooks))
+	s.route(prefix+"/books:batch", http.MethodPost, mw(s.BatchBooks))
+	s.route(prefix+"/books:import", http.MethodPost, mw(s.ImportBooks))
+	s.route(prefix+"/books:search", http.MethodGet, mw(s.SearchBookList))
+	s.route(prefix+"/books:labels", http.MethodPost, mw(s.PrintLabels))
+	s.route(prefix+"/books:duplicates", http.MethodGet, mw(s.GetDuplicateReport))
+	s.route(prefix+"/books/feed.atom", http.MethodGet, mw(s.GetBookFeed))
+	// The custom methods are registered before /books/{isbn}, which would
+	// otherwise match them with the method as part of the ISBN
+	s.route(prefix+"/books/{isbn:[^/:]+}:lock", http.MethodPost, mw(s.LockBook))
+	s.route(prefix+"/books/{isbn:[^/:]+}:unlock", http.MethodPost, mw(s.UnlockBook))
+	s.route(prefix+"/books/{isbn:[^/:]+}:publish", http.MethodPost, mw(s.PublishBook))
+	s.route(prefix+"/books/{isbn:[^/:]+}:withdraw", http.MethodPost, mw(s.WithdrawBook))
+	s.route(prefix+"/books/{isbn}", http.MethodGet, mw(s.GetBook))
+	s.route(prefix+"/books/{isbn}", http.MethodPost, mw(s.CreateBook))
+	s.route(prefix+"/books/{isbn}", http.MethodPut, mw(s.UpdateBook))
+	s.route(prefix+"/books/{isbn}", http.MethodDelete, mw(s.DeleteBook))
+	s.route(prefix+"/books/{isbn:[^/:]+}:merge_into/{target}", http.MethodPost, mw(s.MergeBook))
+	s.route(prefix+"/books/{isbn}/lock", http.MethodGet, mw(s.GetBookLock))
+	s.route(prefix+"/books/{isbn}/citation", http.MethodGet, mw(s.GetCitation))
+	s.route(prefix+"/books/{isbn}/reviews", http.MethodGet, mw(s.GetReviews))
+	s.route(prefix+"/books/{isbn}/reviews", http.MethodPost, mw(s.CreateReview))
+	s.route(prefix+"/books/{isbn}/subjects", http.MethodGet, mw(s.GetBookSubjects))
+	s.route(prefix+"/books/{isbn}/subjects/{id}", http.MethodPut, mw(s.AddBookSubject))
+	s.route(prefix+"/books/{isbn}/subjects/{id}", http.MethodDelete, mw(s.RemoveBookSubject))
+	s.route(prefix+"/books/{isbn}/ebook", http.MethodGet, mw(s.GetEbook))
+	s.route(prefix+"/books/{isbn}/ebook", http.MethodPut, mw(s.PutEbook))
+	s.route(prefix+"/books/{isbn}/ebook/file", http.MethodPut, mw(s.UploadEbookFile))
+	s.route(prefix+"/books/{isbn}/ebook:borrow", http.MethodPost, mw(s.BorrowEbook))
+	s.route(prefix+"/ebooks/download/{token}", http.MethodGet, mw(s.DownloadEbook))
+	s.route(prefix+"/books/{isbn}/cover", http.MethodGet, mw(s.GetCover))
+	s.route(prefix+"/books/{isbn}/cover", http.MethodPut, mw(s.PutCover))
+	s.route(prefix+"/books/{isbn}/cover", http.MethodDelete, mw(s.DeleteCover))
+	s.route(prefix+"/books/{isbn}/attachments", http.MethodGet, mw(s.GetAttachments))
+	s.route(prefix+"/books/{isbn}/attachments", http.MethodPost, mw(s.UploadAttachment))
+	s.route(prefix+"/books/{isbn}/attachments/{id}", http.MethodGet, mw(s.DownloadAttachment))
+	s.route(prefix+"/books/{isbn}/attachments/{id}", http.MethodDelete, mw(s.DeleteAttachment))
+	s.route(prefix+"/classifications:suggest", http.MethodGet, mw(s.GetClassificationSuggestions))
+
+	s.route(prefix+"/works", http.MethodGet, mw(s.GetWorks))
+	s.route(prefix+"/works", http.MethodPost, mw(s.CreateWork))
+	s.route(prefix+"/works:suggestions", http.MethodGet, mw(s.GetWorkSuggestions))
+	s.route(prefix+"/works/{id}", http.MethodGet, mw(s.GetWork))
+	s.route(prefix+"/works/{id}", http.MethodDelete, mw(s.DeleteWork))
+	s.route(prefix+"/suggest", http.MethodGet, mw(s.Suggest))
+
+	s.route(prefix+"/series", http.MethodGet, mw(s.GetSeriesList))
+	s.route(prefix+"/series", http.MethodPost, mw(s.CreateSeries))
+	s.route(prefix+"/series/{id}", http.MethodGet, mw(s.GetSeries))
+	s.route(prefix+"/series/{id}", http.MethodDelete, mw(s.DeleteSeries))
+
+	s.route(prefix+"/subjects", http.MethodPost, mw(s.CreateSubject))
+	s.route(prefix+"/subjects/tree", http.MethodGet, mw(s.GetSubjectTree))
+	s.route(prefix+"/subjects/{id}", http.MethodGet, mw(s.GetSubject))
+	s.route(prefix+"/subjects/{id}", http.MethodPut, mw(s.UpdateSubject))
+	s.route(prefix+"/subjects/{id}", http.MethodDelete, mw(s.DeleteSubject))
+	s.route(prefix+"/subjects/{id}/books", http.MethodGet, mw(s.GetSubjectBooks))
+
+	s.route(prefix+"/members/{memberId:[^/:]+}:export", http.MethodGet, mw(s.ExportMember))
+	s.route(prefix+"/members/{memberId:[^/:]+}:erase", http.MethodPost, mw(s.EraseMember))
+	s.route(prefix+"/members/{memberId}/lists", http.MethodGet, mw(s.GetReadingLists))
+	s.route(prefix+"/members/{memberId}/lists", http.MethodPost, mw(s.CreateReadingList))
+	s.route(prefix+"/members/{memberId}/lists/{id:[^/:]+}", http.MethodGet, mw(s.GetReadingList))
+	s.route(prefix+"/members/{memberId}/lists/{id:[^/:]+}", http.MethodPut, mw(s.UpdateReadingList))
+	s.route(prefix+"/members/{memberId}/lists/{id:[^/:]+}", http.MethodDelete, mw(s.DeleteReadingList))
+	s.route(prefix+"/members/{memberId}/lists/{id:[^/:]+}:share", http.MethodPost, mw(s.ShareReadingList))
+	s.route(prefix+"/members/{memberId}/lists/{id:[^/:]+}:unshare", http.MethodPost, mw(s.UnshareReadingList))
+	s.route(prefix+"/members/{memberId}/lists/{id:[^/:]+}/books/{isbn}", http.MethodPut, mw(s.AddToReadingList))
+	s.route(prefix+"/members/{memberId}/lists/{id:[^/:]+}/books/{isbn}", http.MethodDelete, mw(s.RemoveFromReadingList))
+	s.route(prefix+"/lists/shared/{token}", http.MethodGet, mw(s.GetSharedReadingList))
+	s.route(prefix+"/lists/{id}", http.MethodGet, mw(s.GetPublicReadingList))
+
+	s.route(prefix+"/revisions", http.MethodGet, mw(s.ListRevisions))
+	s.route(prefix+"/revisions/{id:[^/:]+}", http.MethodGet, mw(s.GetRevision))
+	s.route(prefix+"/revisions/{id:[^/:]+}:approve", http.MethodPost, mw(s.ApproveRevision))
+	s.route(prefix+"/revisions/{id:[^/:]+}:reject", http.MethodPost, mw(s.RejectRevision))
+
+	s.route(prefix+"/operations/{id:[^/:]+}", http.MethodGet, mw(s.GetOperation))
+	s.route(prefix+"/operations/{id:[^/:]+}:undo", http.MethodPost, mw(s.UndoOperation))
+
+	s.route(prefix+"/signup", http.MethodPost, mw(s.Signup))
+	s.route(prefix+"/signup:verify", http.MethodPost, mw(s.VerifyEmail))
+	s.route(prefix+"/login", http.MethodPost, mw(s.Login))
+	s.route(prefix+"/login/oidc", http.MethodGet, mw(s.StartOIDCLogin))
+	s.route(prefix+"/login/oidc/callback", http.MethodGet, mw(s.OIDCCallback))
+	s.route(prefix+"/logout", http.MethodPost, mw(s.Logout))
+	s.route(prefix+"/logout:everywhere", http.MethodPost, mw(s.LogoutEverywhere))
+	s.route(prefix+"/password-reset", http.MethodPost, mw(s.RequestPasswordReset))
+	s.route(prefix+"/password-reset:confirm", http.MethodPost, mw(s.ResetPassword))
+	s.route(prefix+"/me", http.MethodGet, mw(s.GetMe))
+	s.route(prefix+"/me/totp", http.MethodPost, mw(s.StartTOTPEnrollment))
+	s.route(prefix+"/me/totp", http.MethodDelete, mw(s.DisableTOTP))
+	s.route(prefix+"/me/totp:confirm", http.MethodPost, mw(s.ConfirmTOTPEnrollment))
+	s.route(prefix+"/me/totp/recovery-codes", http.MethodPost, mw(s.RegenerateRecoveryCodes))
+	s.route(prefix+"/me/digital-loans", http.MethodGet, mw(s.GetDigitalLoans))
+	s.route(prefix+"/me/digital-loans/{id:[^/:]+}:return", http.MethodPost, mw(s.ReturnDigitalLoan))
+
+	s.route(prefix+"/stats", http.MethodGet, mw(s.GetStats))
+	s.route(prefix+"/stats/{metric:[a-z-]+}.csv", http.MethodGet, mw(s.GetStatsReport))
+
+	s.route(prefix+"/admin/templates/{name:[^/:]+}", http.MethodGet, mw(s.GetEmailTemplate))
+	s.route(prefix+"/admin/templates/{name:[^/:]+}", http.MethodPut, mw(s.UpdateEmailTemplate))
+	s.route(prefix+"/admin/templates/{name:[^/:]+}/versions", http.MethodGet, mw(s.ListEmailTemplateVersions))
+	s.route(prefix+"/admin/templates/{name:[^/:]+}:preview", http.MethodPost, mw(s.PreviewEmailTemplate))
+	s.route(prefix+"/admin/notifications", http.MethodGet, mw(s.ListDeliveries))
+	s.route(prefix+"/admin/notifications/failed", http.MethodGet, mw(s.ListFailedDeliveries))
+	s.route(prefix+"/admin/notifications/{id:[0-9]+}:resend", http.MethodPost, mw(s.ResendDelivery))
+	s.route(prefix+"/admin/captures", http.MethodGet, mw(s.ListCaptures))
+	s.route(prefix+"/admin/reviews", http.MethodGet, mw(s.ListHiddenReviews))
+	s.route(prefix+"/admin/reviews/{id:[^/:]+}:hide", http.MethodPost, mw(s.HideReview))
+	s.route(prefix+"/admin/reviews/{id:[^/:]+}:unhide", http.MethodPost, mw(s.UnhideReview))
+	s.route(prefix+"/admin/authorities", http.MethodGet, mw(s.ListAuthorities))
+	s.route(prefix+"/admin/authorities:import", http.MethodPost, mw(s.ImportAuthorityFile))
+	s.route(prefix+"/admin/lockouts", http.MethodGet, mw(s.ListLockouts))
+	s.route(prefix+"/admin/lockouts/{scope:account|ip}/{key}", http.MethodDelete, mw(s.ClearLockout))
+	s.route(prefix+"/admin/security-events", http.MethodGet, mw(s.ListSecurityEvents))
+	s.route(prefix+"/admin/quarantine", http.MethodGet, mw(s.ListQuarantine))
+	s.route(prefix+"/admin/merges", http.MethodGet, mw(s.ListBookMerges))
+	s.route(prefix+"/admin/operations", http.MethodGet, mw(s.ListOperations))
+	s.route(prefix+"/admin/operations/{id:[^/:]+}:undo", http.MethodPost, mw(s.AdminUndoOperation))
+	s.route(prefix+"/admin/books.xlsx", http.MethodGet, mw(s.ExportBooks))
+	s.route(prefix+"/admin/publications", http.MethodGet, mw(s.ListScheduledPublications))
+	s.route(prefix+"/admin/search:reindex", http.MethodPost, mw(s.ReindexSearch))
+	s.route(prefix+"/admin/backup", http.MethodPost, mw(s.Backup))
+	s.route(prefix+"/admin/restore", http.MethodPost, mw(s.Restore))
+}
+
+// middleware wraps a handler with extra behaviour.
+type middleware func(http.HandlerFunc) http.HandlerFunc
+
+func noMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return h
+}
+
+// deprecatedAlias marks responses of the alias prefix as deprecated and links
+// to the same resource under the successor prefix.
+func deprecatedAlias(alias, successor string) middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			successorPath := successor + strings.TrimPrefix(r.URL.Path, alias)
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successorPath))
+			h(w, r)
+		}
+	}
+}
+
+// route registers the handler for the given path and method, limited by the
+// body size limit and timeout of the route. GET routes also answer HEAD requests. The methods are
+// recorded so that OPTIONS requests can advertise them.
+func (s *Server) route(path, method string, handler http.HandlerFunc) {
+	methods := []string{method}
+	if method == http.MethodGet {
+		methods = append(methods, http.MethodHead)
+	}
+	handler = withBodyLimit(s.bodyLimit(path), s.withMessages(handler))
+	s.router.HandleFunc(path, withTimeout(s.timeouts.timeout(path, method), handler)).Methods(methods...)
+	s.allowedMethods[path] = append(s.allowedMethods[path], methods...)
+}
+
 // ServeHTTP is needed to be implemented when we use the router in the struct.
 func (r *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	r.router.ServeHTTP(w, req)
+	req = r.withRequestID(w, req)
+	if req.Method == http.MethodHead {
+		w = headResponseWriter{w}
+	}
+	handler := recoverPanics(r.router)
+	if r.captures != nil {
+		r.serveCaptured(w, req, handler)
+		return
+	}
+	handler.ServeHTTP(w, req)
+}
+
+// headResponseWriter discards the body so that HEAD requests get the same
+// headers and status as GET but no body.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Options returns a handler which responds to OPTIONS requests by listing the
+// allowed methods of the route in the Allow header.
+func (s *Server) Options(methods []string) http.HandlerFunc {
+	allow := strings.Join(
+		append(append([]string{}, methods...), http.MethodOptions), ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusNoContent)
+	}
 }
 
 // HandleErr for when we get an error.
 // If succesfull it writes what type of error in the header we get and then
-// display the error message for the user.
+// display the error message for the user, translated to the language of
+// the request when the message is in the catalogs.
 func HandleErr(w http.ResponseWriter, code int, message string) {
+	message = localizeMessage(w, message)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	_, err := w.Write([]byte(message))
@@ -63,66 +384,409 @@ func HandleErr(w http.ResponseWriter, code int, message string) {
 	}
 }
 
+// withBodyLimit rejects request bodies larger than limit bytes.
+func withBodyLimit(limit int64, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		h(w, r)
+	}
+}
+
+// handleDecodeErr writes the error of decoding a request body. Bodies over
+// the size limit get 413 and other errors 400, with the cause so that for
+// example a misspelled field name can be found.
+func handleDecodeErr(w http.ResponseWriter, err error, message string) {
+	// http.MaxBytesReader has no error type of its own until Go 1.19
+	if strings.Contains(err.Error(), "request body too large") {
+		HandleErr(w, http.StatusRequestEntityTooLarge, "The request body is too large")
+		return
+	}
+	HandleErr(w, http.StatusBadRequest, message+", "+err.Error())
+}
+
 // GetBooks retreives all the books that exists in the library structure.
-// if succesfull, it writes the JSON encoding of the books slice to the stream
+// if succesfull, it writes the JSON encoding of the books slice to the stream.
+// The fields query parameter selects the fields of the books to write.
 // Note(sn): Change to "ListBooks"
 func (s *Server) GetBooks(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	book := ReadDatabaseList(s.db)
+	if modifiedSince := r.URL.Query().Get("modified_since"); modifiedSince != "" {
+		s.listChangedBooks(w, r, modifiedSince)
+		return
+	}
+	fields, err := parseFields(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sortKey := r.URL.Query().Get("sort")
+	if sortKey != "" && !validSortKey(sortKey) {
+		HandleErr(w, http.StatusBadRequest, "sort must be one of title, -title, author, -author, callNumber or -callNumber")
+		return
+	}
+	formats := r.URL.Query()["accessible_format"]
+	if err := validateAccessibleFormats(formats); err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && !validFormat(format) {
+		HandleErr(w, http.StatusBadRequest, "format must be one of "+strings.Join(Formats, ", "))
+		return
+	}
+	lang := language.Und
+	if v := r.URL.Query().Get("language"); v != "" {
+		var err error
+		if lang, err = language.Parse(v); err != nil {
+			HandleErr(w, http.StatusBadRequest, "language must be a BCP 47 language tag")
+			return
+		}
+	}
+	var year int
+	if v := r.URL.Query().Get("publication_year"); v != "" {
+		var err error
+		if year, err = strconv.Atoi(v); err != nil {
+			HandleErr(w, http.StatusBadRequest, "publication_year must be a year")
+			return
+		}
+	}
+	book := localizeAll(ReadPublicBookList(s.db, time.Now()), r.Header.Get("Accept-Language"))
+	if len(formats) != 0 {
+		book = filterBooks(book, func(b Book) bool { return hasAccessibleFormats(b, formats) })
+	}
+	if format != "" {
+		book = filterBooks(book, func(b Book) bool { return b.Format == format })
+	}
+	if year != 0 {
+		book = filterBooks(book, func(b Book) bool { return b.PublicationYear == year })
+	}
+	if lang != language.Und {
+		book = filterBooks(book, func(b Book) bool { return matchesLanguage(b, lang) })
+	}
+	// The volumes of a series are listed in order unless sorted otherwise
+	if seriesID := r.URL.Query().Get("series"); seriesID != "" {
+		book = volumesOf(book, seriesID)
+	}
+	if sortKey != "" {
+		sortBooks(book, sortKey, s.collationLocale(lang))
+	}
+
+	if negotiateContentType(r.Header.Get("Accept")) == jsonAPIContentType {
+		s.writeBooksJSONAPI(w, r, book)
+		return
+	}
+	selected, err := fields.apply(book)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to select the fields of the books")
+		return
+	}
+	if err := writeEncoded(w, r, selected); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the book instance")
+		return
+	}
+}
+
+// filterBooks returns the books for which keep returns true.
+func filterBooks(books []Book, keep func(Book) bool) []Book {
+	var kept []Book
+	for _, b := range books {
+		if keep(b) {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
 
-	if err := json.NewEncoder(w).Encode(book); err != nil {
+// listChangedBooks writes the books which were created, updated or deleted
+// after modifiedSince to the stream, so that clients can sync incrementally.
+func (s *Server) listChangedBooks(w http.ResponseWriter, r *http.Request, modifiedSince string) {
+	since, err := time.Parse(time.RFC3339, modifiedSince)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, "modified_since must be an RFC3339 timestamp")
+		return
+	}
+	changes, err := ReadChangesSince(s.db, since)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the changed books")
+		return
+	}
+	changes.Books = localizeAll(changes.Books, r.Header.Get("Accept-Language"))
+	if err := writeEncoded(w, r, changes); err != nil {
 		HandleErr(w, http.StatusBadRequest, "Failed to Encode the book instance")
 		return
 	}
 }
 
 // GetBook retreives a specific book that exists in the library structure.
-// if succesfull, it writes the JSON encoding of the specific book to the stream
+// if succesfull, it writes the JSON encoding of the specific book to the stream.
+// The first page of reviews is embedded with ?expand=reviews. A book which was
+// merged into another book redirects to it. With ?format=dc or
+// ?format=bibtex the record is written as Dublin Core XML or as a BibTeX
+// entry instead, see writeRecord.
 func (s *Server) GetBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	params := mux.Vars(r) // Fetches the parameters of the http.Request URL
+	fields, err := parseFields(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	expand, err := parseExpand(r)
+	if err != nil {
+		HandleErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && !validRecordFormat(format) {
+		HandleErr(w, http.StatusBadRequest, "format must be one of dc or bibtex")
+		return
+	}
 
-	book := FindSpecificBook(s.db, params["isbn"])
-	if (Book{} == book) {
+	now := time.Now()
+	book := FindPublicBook(s.db, params["isbn"], now)
+	if book.ISBN == "" {
+		if s.redirectMergedBook(w, r, params["isbn"]) {
+			return
+		}
 		HandleErr(w, http.StatusNotFound, "The book did not exist in the library")
 		return
 	}
+	if format != "" {
+		if err := writeRecord(w, book, format); err != nil {
+			handleErr("Failed to write the record of the book", err)
+		}
+		return
+	}
+	book.OtherEditions = otherEditions(s.db, book, now)
+	book.NextInSeries = nextInSeries(s.db, book, now)
+	if book.AverageRating, book.RatingCount, err = ReadRating(s.db, book.ISBN); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the rating of the book")
+		return
+	}
+	if expand["reviews"] {
+		if book.Reviews, _, err = ReadReviews(s.db, book.ISBN, 0, defaultReviewPageSize); err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the reviews of the book")
+			return
+		}
+	}
+	book, lang := localize(book, r.Header.Get("Accept-Language"))
+	if lang != "" {
+		w.Header().Set("Content-Language", lang)
+	}
 
-	if err := json.NewEncoder(w).Encode(book); err != nil {
+	if negotiateContentType(r.Header.Get("Accept")) == jsonAPIContentType {
+		s.writeBookJSONAPI(w, r, book)
+		return
+	}
+	selected, err := fields.apply(book)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to select the fields of the book")
+		return
+	}
+	if err := writeEncoded(w, r, selected); err != nil {
 		HandleErr(w, http.StatusBadRequest, "Failed to Encode the book instance")
 		return
 	}
 }
 
-// CreateBook creates a Book instance and checks that the right information have
-// been passed If the information is validated then we store the information in
-// our local memory and it writes the JSON encoding of the specific book to the
-// stream
-func (s *Server) CreateBook(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("content-Type", "application/json")
-	var book Book
+// statusError is an error which should be reported to the client with the
+// given status code.
+type statusError struct {
+	code int
+	msg  string
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		HandleErr(w, http.StatusBadRequest, "Failed to decode book")
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+// handleBookErr reports an error returned by one of the book operations.
+func handleBookErr(w http.ResponseWriter, err error) {
+	var de *duplicateError
+	if errors.As(err, &de) {
+		writeDuplicateConflict(w, de)
 		return
 	}
-	if exists := FindSpecificBook(s.db, book.ISBN); (exists != Book{}) {
-		HandleErr(w, http.StatusConflict, "A book with this ISBN already exits")
+	var le *lockedError
+	if errors.As(err, &le) {
+		writeLockConflict(w, le)
 		return
 	}
-	if !(book.CreateTime.IsZero() && book.UpdateTime.IsZero()) {
-		HandleErr(w, http.StatusForbidden, "Not allowed to change CreateTime or UpdateTime")
+	var se *statusError
+	if errors.As(err, &se) {
+		HandleErr(w, se.code, se.msg)
 		return
 	}
+	HandleErr(w, http.StatusInternalServerError, "Failed to store the book")
+}
+
+// createBook checks that the book may be created and stores it. Books which
+// resemble existing books are only created if force is set. Books are
+// published unless they are created as drafts.
+func (s *Server) createBook(q Querier, book Book, force bool) (Book, error) {
+	if exists := FindSpecificBook(q, book.ISBN); exists.ISBN != "" {
+		return Book{}, &statusError{http.StatusConflict, "A book with this ISBN already exits"}
+	}
+	if !(book.CreateTime.IsZero() && book.UpdateTime.IsZero()) {
+		return Book{}, &statusError{http.StatusForbidden, "Not allowed to change CreateTime or UpdateTime"}
+	}
+	if book.Status == StatusWithdrawn {
+		return Book{}, &statusError{http.StatusNotAcceptable, "A new book must be a draft or published"}
+	}
+	if book.Status == "" {
+		book.Status = StatusPublished
+	}
 	if err := validate(book); err != nil {
-		HandleErr(w, http.StatusNotAcceptable, err.Error())
-		return
+		return Book{}, &statusError{http.StatusNotAcceptable, err.Error()}
+	}
+	book.Language = canonicalLanguage(book.Language)
+	if err := checkWorkExists(q, book); err != nil {
+		return Book{}, err
+	}
+	if err := checkSeriesExists(q, book); err != nil {
+		return Book{}, err
+	}
+	if !force {
+		candidates, err := FindDuplicates(q, book)
+		if err != nil {
+			return Book{}, err
+		}
+		if len(candidates) != 0 {
+			return Book{}, &duplicateError{candidates}
+		}
 	}
 
 	// Note(sn): set update time as well (same value as create time)
 	book.CreateTime = time.Now()
-	InsertIntoDatabase(s.db, book)
-	if err := json.NewEncoder(w).Encode(book); err != nil {
+	book.ID = s.idGenerator.NewID()
+	book.CallNumber = s.callNumber(book)
+	if book.Author == nil {
+		// Drafts may be created without an author
+		book.Author = &Author{}
+	}
+	if err := s.checkBookQuota(q); err != nil {
+		return Book{}, err
+	}
+	if err := InsertIntoDatabase(q, book); err != nil {
+		return Book{}, err
+	}
+	if err := RemoveTombstone(q, book.ISBN); err != nil {
+		return Book{}, err
+	}
+	if err := s.recordEvent(q, events.TypeBookCreated, book.ISBN, &book); err != nil {
+		return Book{}, err
+	}
+	return book, nil
+}
+
+// updateBook checks that the book with the given isbn may be replaced by book
+// and stores it. The status is kept, it is changed by publishing or
+// withdrawing the book.
+func (s *Server) updateBook(q Querier, isbn string, book Book) (Book, error) {
+	// Note(sn): rename to existing book
+	exists := FindSpecificBook(q, isbn)
+	if exists.ISBN == "" {
+		return Book{}, &statusError{http.StatusNotFound, "The book did not exist in the library"}
+	}
+
+	createdTime := exists.CreateTime
+	if book.ISBN != isbn {
+		return Book{}, &statusError{http.StatusForbidden, "Not allowed to change ISBN"}
+	}
+	now := time.Now()
+	claimed, err := claimUpdate(q, isbn, now, s.minDurationBetweenUpdates)
+	if err != nil {
+		return Book{}, err
+	}
+	if !claimed {
+		return Book{}, &statusError{http.StatusTooEarly, "Updated a few seconds ago, please wait a moment before updating again"}
+	}
+	book.Status = exists.Status
+	if err := validate(book); err != nil {
+		return Book{}, &statusError{http.StatusNotAcceptable, err.Error()}
+	}
+	book.Language = canonicalLanguage(book.Language)
+	if err := checkWorkExists(q, book); err != nil {
+		return Book{}, err
+	}
+	if err := checkSeriesExists(q, book); err != nil {
+		return Book{}, err
+	}
+
+	book.ID = exists.ID
+	book.CreateTime = createdTime
+	book.UpdateTime = now
+	book.CallNumber = s.callNumber(book)
+	if err := DeleteBookFromDB(q, exists.ISBN); err != nil {
+		return Book{}, err
+	}
+	if err := InsertIntoDatabase(q, book); err != nil {
+		return Book{}, err
+	}
+	if err := s.recordEvent(q, events.TypeBookUpdated, isbn, &book); err != nil {
+		return Book{}, err
+	}
+	return book, nil
+}
+
+// callNumber returns the call number of the book, a call number which was
+// set manually takes precedence over the generated one.
+func (s *Server) callNumber(book Book) string {
+	if book.CallNumber != "" || book.Classification == "" {
+		return book.CallNumber
+	}
+	return s.callNumberScheme.CallNumber(book)
+}
+
+// deleteBook deletes the book with the given isbn if it exists and returns
+// the deleted book.
+func (s *Server) deleteBook(q Querier, isbn string) (Book, error) {
+	exists := FindSpecificBook(q, isbn)
+	if exists.ISBN == "" {
+		return Book{}, &statusError{http.StatusNotFound, "The book did not exist in the library or was already deleted"}
+	}
+	if err := DeleteBookFromDB(q, isbn); err != nil {
+		return Book{}, err
+	}
+	if err := releaseUpdateClaim(q, isbn); err != nil {
+		return Book{}, err
+	}
+	if err := s.recordEvent(q, events.TypeBookDeleted, isbn, nil); err != nil {
+		return Book{}, err
+	}
+	return exists, InsertTombstone(q, isbn, time.Now())
+}
+
+// CreateBook creates a Book instance and checks that the right information have
+// been passed If the information is validated then we store the information in
+// our local memory and it writes the JSON encoding of the specific book to the
+// stream. Trainees can not create books.
+func (s *Server) CreateBook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var book Book
+
+	if err := decodeBody(r, &book); err != nil {
+		handleDecodeErr(w, err, "Failed to decode book")
+		return
+	}
+	book = s.prefillBook(r.Context(), book)
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		var err error
+		book, err = s.createBook(tx, book, r.URL.Query().Get("force") == "true")
+		return err
+	})
+	if err != nil {
+		handleBookErr(w, err)
+		return
+	}
+
+	if err := writeEncoded(w, r, book); err != nil {
 		HandleErr(w, http.StatusBadRequest, "Failed to Encode the book instance")
 		return
 	}
@@ -130,19 +794,45 @@ func (s *Server) CreateBook(w http.ResponseWriter, r *http.Request) {
 
 // DeleteBook deletes a book instance from the library.
 // if succesfull, it writes the JSON encoding of the new book slice
-// without the removed book to the stream
+// without the removed book to the stream. The deletion can be undone through
+// the operation in the X-Operation-ID header. A book which is locked by
+// another member is not deleted, see LockBook, and trainees can not delete
+// books.
 func (s *Server) DeleteBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-Type", "application/json")
 	params := mux.Vars(r)
+	editor, err := s.editor(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if isTrainee(editor) {
+		handleBookErr(w, errTraineeEdit)
+		return
+	}
 
-	if exists := FindSpecificBook(s.db, params["isbn"]); (exists == Book{}) {
-		HandleErr(w, http.StatusNotFound, "The book did not exist in the library or was already deleted")
+	var operationID string
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if err := checkBookLock(tx, params["isbn"], editor.ID, time.Now()); err != nil {
+			return err
+		}
+		j := &journal{q: tx}
+		deleted, err := s.deleteBook(tx, params["isbn"])
+		if err != nil {
+			return err
+		}
+		j.record(params["isbn"], deleted)
+		operationID, err = s.journalOperation(tx, OperationDelete, j)
+		return err
+	})
+	if err != nil {
+		handleBookErr(w, err)
 		return
 	}
+	w.Header().Set(operationIDHeader, operationID)
 
-	DeleteBookFromDB(s.db, params["isbn"])
-	books := ReadDatabaseList(s.db)
-	if err := json.NewEncoder(w).Encode(books); err != nil {
+	books := ReadPublicBookList(s.db, time.Now())
+	if err := writeEncoded(w, r, books); err != nil {
 		HandleErr(w, http.StatusBadRequest, "Failed to Encode the book instance")
 		return
 	}
@@ -151,48 +841,57 @@ func (s *Server) DeleteBook(w http.ResponseWriter, r *http.Request) {
 // UpdateBook updates a book instance and checks that the right information have
 // been passed If the information is validated then we store the information in
 // our local memory and it writes the JSON encoding of the specific book to the
-// stream
+// stream. A book which is locked by another member is not updated, see
+// LockBook. The changes of trainees are stored as a revision for review
+// instead, and answered with 202 Accepted.
 func (s *Server) UpdateBook(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-Type", "application/json")
 	params := mux.Vars(r)
-	// Note(sn): rename to existing book
-	exists := FindSpecificBook(s.db, params["isbn"])
-	if (exists == Book{}) {
+	if exists := FindSpecificBook(s.db, params["isbn"]); exists.ISBN == "" {
 		HandleErr(w, http.StatusNotFound, "The book did not exist in the library")
 		return
 	}
 
-	createdTime := exists.CreateTime
-	updatedTime := exists.UpdateTime
 	// Note(sn): maybe call this new book?
 	var book Book
 
-	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		HandleErr(w, http.StatusBadRequest, "Failed to decode book")
+	if err := decodeBody(r, &book); err != nil {
+		handleDecodeErr(w, err, "Failed to decode book")
 		return
 	}
-	if book.ISBN != params["isbn"] {
-		HandleErr(w, http.StatusForbidden, "Not allowed to change ISBN")
+	editor, err := s.editor(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
 		return
 	}
-	// Note(sn): use configured value, this will make it easier to test
-	// time.Now().Sub(updatedTime) < s.minDurationBetweenUpdates
-	// time.Now().After(updatedTime.Add(s.minDurationBetweenUpdates))
-	if (time.Now().Unix() - updatedTime.Unix()) < 10 {
-		HandleErr(w, http.StatusTooEarly, "Updated a few seconds ago, please wait a moment before updating again")
+	if isTrainee(editor) {
+		var rev Revision
+		err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+			var err error
+			rev, err = s.proposeRevision(tx, params["isbn"], book, editor, time.Now())
+			return err
+		})
+		if err != nil {
+			handleBookErr(w, err)
+			return
+		}
+		writeRevision(w, http.StatusAccepted, rev)
 		return
 	}
-	if err := validate(book); err != nil {
-		HandleErr(w, http.StatusNotAcceptable, err.Error())
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if err := checkBookLock(tx, params["isbn"], editor.ID, time.Now()); err != nil {
+			return err
+		}
+		var err error
+		book, err = s.updateBook(tx, params["isbn"], book)
+		return err
+	})
+	if err != nil {
+		handleBookErr(w, err)
 		return
 	}
 
-	book.CreateTime = createdTime
-	book.UpdateTime = time.Now()
-	DeleteBookFromDB(s.db, exists.ISBN)
-	InsertIntoDatabase(s.db, book)
-
-	if err := json.NewEncoder(w).Encode(book); err != nil {
+	if err := writeEncoded(w, r, book); err != nil {
 		HandleErr(w, http.StatusBadRequest, "Failed to Encode the book instance")
 		return
 	}
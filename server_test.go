@@ -4,14 +4,19 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"encoding/xml"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v3"
 )
 
 // Note(sn): create valid and invalid examples here and share between tests.
@@ -50,7 +55,7 @@ func assertStatus(t testing.TB, got, want int, warningMessage string) {
 func assertDeletedBook(t *testing.T, isbn string, db *sql.DB, usage string) {
 	t.Helper()
 	book := FindSpecificBook(db, isbn)
-	if (book != Book{}) {
+	if book.ISBN != "" {
 		t.Errorf("The book with the isbn %q should have been deleted", isbn)
 	}
 }
@@ -80,10 +85,13 @@ func createTempDatabase(t *testing.T) (*sql.DB, func() error) {
 	t.Helper()
 	tempFile, err := os.CreateTemp("", "")
 	require.NoError(t, err)
-	db, err := sql.Open("sqlite", tempFile.Name())
+	db, err := NewDB(tempFile.Name())
 	require.NoError(t, err)
 	require.NoError(t, EnsureSchema(db))
 	cleanup := func() error {
+		// The write-ahead log and its index are removed with the database
+		os.Remove(tempFile.Name() + "-wal")
+		os.Remove(tempFile.Name() + "-shm")
 		return os.Remove(tempFile.Name()) // Removes the temporary file
 	}
 	return db, cleanup
@@ -102,6 +110,31 @@ func createNewRequest(
 	return response
 }
 
+// createMemberSession creates a member with the given roles and returns the
+// token of a session of the member.
+func createMemberSession(t *testing.T, db *sql.DB, id string, roles ...string) string {
+	t.Helper()
+	server := NewServer(db)
+	m := Member{ID: id, Email: id + "@example.com", FirstName: id, LastName: "Member", EmailVerified: true,
+		CreateTime: time.Now()}
+	require.NoError(t, server.InsertMember(db, m, ""))
+	require.NoError(t, setRoles(db, id, roles))
+	session, err := server.startSession(db, m)
+	require.NoError(t, err)
+	return session.Token
+}
+
+// createNewMemberRequest is createNewRequest with the session token of a
+// member.
+func createNewMemberRequest(httpMethod, urlPath string, jsonBytes []byte, db *sql.DB, token string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(httpMethod, urlPath, bytes.NewReader(jsonBytes))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+	response := httptest.NewRecorder()
+	NewServer(db).ServeHTTP(response, request)
+	return response
+}
+
 func TestCREATEBookMETHOD(t *testing.T) {
 	db, cleanup := createTempDatabase(t)
 	defer cleanup()
@@ -132,6 +165,7 @@ func TestCREATEBookMETHOD(t *testing.T) {
 		assertStatus(t, response.Code, http.StatusOK, "Should get status code 200:"+
 			"status OK")
 		assertEqualBook(t, got, want, "Should be equal")
+		require.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-7`, got.ID, "Should get a UUIDv7 id")
 	})
 
 	t.Run("Creates a book that already exists in the library", func(t *testing.T) {
@@ -214,6 +248,28 @@ func TestCREATEBookMETHOD(t *testing.T) {
 		assertError(t, string(b), "validation failed, field error(s):"+
 			" isbn . Fix these error before proceeding")
 	})
+
+	t.Run("Rejects unknown fields", func(t *testing.T) {
+		jsonBytes := []byte(`{"isbn":"1233211233210","title":"star wars","author":` +
+			`{"firstName":"george","lastName":"lucas"},"publsher":"adlibris"}`)
+		response := createNewRequest(http.MethodPost, "/api/books/1233211233210", jsonBytes, db)
+		b, _ := ioutil.ReadAll(response.Body)
+
+		assertStatus(t, response.Code, http.StatusBadRequest, "Should get status"+
+			" code 400: status bad request")
+		require.Contains(t, string(b), `unknown field "publsher"`)
+	})
+
+	t.Run("Rejects bodies over the size limit", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(Book{ISBN: "1233211233210", Title: strings.Repeat("a", 200),
+			Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "adlibris"})
+		request, _ := http.NewRequest(http.MethodPost, "/api/books/1233211233210", bytes.NewReader(jsonBytes))
+		response := httptest.NewRecorder()
+		NewServer(db, WithMaxBodySize(100)).ServeHTTP(response, request)
+
+		assertStatus(t, response.Code, http.StatusRequestEntityTooLarge, "Should get status"+
+			" code 413: request entity too large")
+	})
 }
 
 func TestGETBooksMETHOD(t *testing.T) { //List
@@ -540,3 +596,334 @@ func TestUpdateBooks(t *testing.T) {
 			"moment before updating again")
 	})
 }
+
+func TestHEADAndOPTIONSMETHOD(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	t.Run("HEAD returns the headers of GET without a body", func(t *testing.T) {
+		response := createNewRequest(http.MethodHead, "/api/books", nil, db)
+
+		assertContentType(t, response, jsonContentType, "Should have the json "+
+			"content type application/json")
+		assertStatus(t, response.Code, http.StatusOK, "Should get status "+
+			"code 200: status OK")
+		require.Zero(t, response.Body.Len())
+	})
+
+	t.Run("OPTIONS advertises the allowed methods of a route", func(t *testing.T) {
+		response := createNewRequest(http.MethodOptions, "/api/books/1233211233215",
+			nil, db)
+
+		assertStatus(t, response.Code, http.StatusNoContent, "Should get status "+
+			"code 204: status no content")
+		require.Equal(t, "GET, HEAD, POST, PUT, DELETE, OPTIONS",
+			response.Result().Header.Get("Allow"))
+	})
+}
+
+func TestContentNegotiation(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	isbn := "1233211233215"
+	want := Book{
+		ISBN:  isbn,
+		Title: "star wars",
+		Author: &Author{
+			FirstName: "george",
+			LastName:  "lucas"},
+		Publisher: "adlibris"}
+
+	t.Run("Creates a book from an XML body", func(t *testing.T) {
+		xmlBytes, err := xml.Marshal(want)
+		require.NoError(t, err)
+		request, _ := http.NewRequest(http.MethodPost, "/api/books/"+isbn,
+			bytes.NewReader(xmlBytes))
+		request.Header.Set("Content-Type", "application/xml")
+		request.Header.Set("Accept", "application/xml")
+		response := httptest.NewRecorder()
+		NewServer(db).ServeHTTP(response, request)
+
+		assertContentType(t, response, xmlContentType, "Should have the xml "+
+			"content type application/xml")
+		assertStatus(t, response.Code, http.StatusOK, "Should get status "+
+			"code 200: status OK")
+		assertEqualBook(t, FindSpecificBook(db, isbn), want, "Should be equal")
+	})
+
+	t.Run("Lists the books as XML", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/api/books", nil)
+		request.Header.Set("Accept", "text/html;q=0.9, application/xml;q=0.8")
+		response := httptest.NewRecorder()
+		NewServer(db).ServeHTTP(response, request)
+
+		var got bookList
+		require.NoError(t, xml.NewDecoder(response.Body).Decode(&got))
+		assertContentType(t, response, xmlContentType, "Should have the xml "+
+			"content type application/xml")
+		assertEqualBooks(t, got.Books, []Book{want}, "Should be equal")
+	})
+
+	t.Run("Gets a book as YAML", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/api/books/"+isbn, nil)
+		request.Header.Set("Accept", "application/yaml")
+		response := httptest.NewRecorder()
+		NewServer(db).ServeHTTP(response, request)
+
+		var got Book
+		require.NoError(t, yaml.NewDecoder(response.Body).Decode(&got))
+		assertContentType(t, response, yamlContentType, "Should have the yaml "+
+			"content type application/yaml")
+		assertEqualBook(t, got, want, "Should be equal")
+	})
+}
+
+func TestAPIVersions(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	t.Run("/api/v1 is the canonical path", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books", nil, db)
+
+		assertStatus(t, response.Code, http.StatusOK, "Should get status "+
+			"code 200: status OK")
+		require.Empty(t, response.Result().Header.Get("Deprecation"))
+	})
+
+	t.Run("/api is a deprecated alias of /api/v1", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/books/1233211233215",
+			nil, db)
+
+		assertStatus(t, response.Code, http.StatusNotFound, "Should get status "+
+			"code 404: status not found")
+		require.Equal(t, "true", response.Result().Header.Get("Deprecation"))
+		require.Equal(t, `</api/v1/books/1233211233215>; rel="successor-version"`,
+			response.Result().Header.Get("Link"))
+	})
+}
+
+func TestSortBooks(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	languages := []string{"sv-SE", "sv", "sv-fi", "en"}
+	for i, title := range []string{"Ödet", "Zebra", "Åke", "Ärlighet"} {
+		isbn := fmt.Sprintf("123321123321%d", i)
+		jsonBytes, _ := json.Marshal(Book{
+			ISBN:      isbn,
+			Title:     title,
+			Author:    &Author{FirstName: "george", LastName: "lucas"},
+			Publisher: "adlibris",
+			Language:  languages[i],
+		})
+		_ = createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db)
+	}
+
+	listTitles := func(t *testing.T, server *Server, query string) []string {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodGet, "/api/v1/books?sort="+query, nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+		var books []Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&books))
+		var titles []string
+		for _, b := range books {
+			titles = append(titles, b.Title)
+		}
+		return titles
+	}
+
+	t.Run("Sorts using the root collation by default", func(t *testing.T) {
+		require.Equal(t, []string{"Åke", "Ärlighet", "Ödet", "Zebra"},
+			listTitles(t, NewServer(db), "title"))
+	})
+
+	t.Run("Sorts using the configured locale", func(t *testing.T) {
+		server := NewServer(db, WithLocale(language.Swedish))
+		require.Equal(t, []string{"Zebra", "Åke", "Ärlighet", "Ödet"},
+			listTitles(t, server, "title"))
+		require.Equal(t, []string{"Ödet", "Ärlighet", "Åke", "Zebra"},
+			listTitles(t, server, "-title"))
+	})
+
+	t.Run("Filters and sorts using the requested language", func(t *testing.T) {
+		require.Equal(t, "sv-FI", FindSpecificBook(db, "1233211233212").Language)
+		require.Equal(t, []string{"Zebra", "Åke", "Ödet"},
+			listTitles(t, NewServer(db), "title&language=sv"))
+		require.Equal(t, []string{"Åke"}, listTitles(t, NewServer(db), "title&language=sv-FI"))
+		require.Equal(t, []string{"Ärlighet"}, listTitles(t, NewServer(db), "title&language=en"))
+
+		response := createNewRequest(http.MethodGet, "/api/v1/books?language=12", nil, db)
+		assertStatus(t, response.Code, http.StatusBadRequest, "Should get status "+
+			"code 400: status bad request")
+	})
+
+	t.Run("Rejects unknown sort orders", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books?sort=isbn", nil, db)
+		assertStatus(t, response.Code, http.StatusBadRequest, "Should get status "+
+			"code 400: status bad request")
+	})
+}
+
+// starWarsTitles are distinct titles for tests which create several books,
+// since similar books by the same author are rejected as duplicates.
+var starWarsTitles = map[string]string{
+	"1233211233215": "a new hope",
+	"1233211233213": "the empire strikes back",
+	"1233211233210": "return of the jedi",
+}
+
+func TestAccessibleFormats(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	for isbn, formats := range map[string][]string{
+		"1233211233215": {FormatBraille, FormatLargePrint},
+		"1233211233213": AccessibleFormatsFromONIX([]string{"B106", "A204"}),
+		"1233211233210": nil,
+	} {
+		jsonBytes, _ := json.Marshal(Book{
+			ISBN:              isbn,
+			Title:             starWarsTitles[isbn],
+			Author:            &Author{FirstName: "george", LastName: "lucas"},
+			Publisher:         "adlibris",
+			AccessibleFormats: formats,
+		})
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code)
+	}
+
+	t.Run("Filters the books by accessible format", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet,
+			"/api/v1/books?accessible_format=daisy-audio", nil, db)
+
+		var got []Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Len(t, got, 1)
+		require.Equal(t, "1233211233213", got[0].ISBN)
+		require.Equal(t, []string{FormatDAISYAudio, FormatDAISYText}, got[0].AccessibleFormats)
+	})
+
+	t.Run("Rejects unknown accessible formats", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet,
+			"/api/v1/books?accessible_format=audio", nil, db)
+
+		assertStatus(t, response.Code, http.StatusBadRequest, "Should get status "+
+			"code 400: status bad request")
+	})
+}
+
+func TestBookDetails(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	years := map[string]int{"1233211233215": 1977, "1233211233213": 1980, "1233211233210": 1983}
+	for isbn, format := range map[string]string{
+		"1233211233215": FormatHardcover,
+		"1233211233213": FormatPaperback,
+		"1233211233210": FormatAudiobook,
+	} {
+		jsonBytes, _ := json.Marshal(Book{
+			ISBN:            isbn,
+			Title:           starWarsTitles[isbn],
+			Author:          &Author{FirstName: "george", LastName: "lucas"},
+			Publisher:       "adlibris",
+			Description:     "A long time ago in a galaxy far, far away",
+			PageCount:       320,
+			Language:        "en-US",
+			PublicationYear: years[isbn],
+			Format:          format,
+		})
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+	}
+
+	t.Run("Stores the details", func(t *testing.T) {
+		book := FindSpecificBook(db, "1233211233215")
+		require.Equal(t, "A long time ago in a galaxy far, far away", book.Description)
+		require.Equal(t, 320, book.PageCount)
+		require.Equal(t, "en-US", book.Language)
+		require.Equal(t, 1977, book.PublicationYear)
+		require.Equal(t, FormatHardcover, book.Format)
+	})
+
+	t.Run("Filters the books by format and publication year", func(t *testing.T) {
+		for query, want := range map[string]string{
+			"format=paperback":                       "1233211233213",
+			"publication_year=1977":                  "1233211233215",
+			"format=audiobook&publication_year=1977": "",
+		} {
+			response := createNewRequest(http.MethodGet, "/api/v1/books?"+query, nil, db)
+			require.Equal(t, http.StatusOK, response.Code)
+			var got []Book
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+			if want == "" {
+				require.Empty(t, got, query)
+				continue
+			}
+			require.Len(t, got, 1, query)
+			require.Equal(t, want, got[0].ISBN, query)
+		}
+
+		response := createNewRequest(http.MethodGet, "/api/v1/books?format=vinyl", nil, db)
+		assertStatus(t, response.Code, http.StatusBadRequest, "Should get status "+
+			"code 400: status bad request")
+	})
+
+	t.Run("Rejects invalid details", func(t *testing.T) {
+		for _, book := range []Book{
+			{PageCount: -1},
+			{Language: "not a language"},
+			{PublicationYear: 1200},
+			{Format: "vinyl"},
+		} {
+			book.ISBN = "1233211233218"
+			book.Title = "rogue one"
+			book.Author = &Author{FirstName: "george", LastName: "lucas"}
+			book.Publisher = "adlibris"
+			jsonBytes, _ := json.Marshal(book)
+			response := createNewRequest(http.MethodPost, "/api/v1/books/1233211233218", jsonBytes, db)
+			assertStatus(t, response.Code, http.StatusNotAcceptable, "Should get status "+
+				"code 406: status not acceptable")
+		}
+	})
+}
+
+func TestEmbargo(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	future := time.Now().Add(time.Hour)
+	isbn := "1233211233215"
+	jsonBytes, _ := json.Marshal(Book{
+		ISBN:          isbn,
+		Title:         "star wars",
+		Author:        &Author{FirstName: "george", LastName: "lucas"},
+		Publisher:     "adlibris",
+		AvailableFrom: &future,
+	})
+	response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	t.Run("Embargoed books are hidden from the public endpoints", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books/"+isbn, nil, db)
+		assertStatus(t, response.Code, http.StatusNotFound, "Should get status "+
+			"code 404: status not found")
+
+		response = createNewRequest(http.MethodGet, "/api/v1/books", nil, db)
+		var got []Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Empty(t, got)
+	})
+
+	t.Run("Embargoed books are visible once the date has passed", func(t *testing.T) {
+		books := ReadPublicBookList(db, future.Add(time.Second))
+		require.Len(t, books, 1)
+		require.True(t, future.Equal(*books[0].AvailableFrom))
+
+		changes, err := ReadChangesSince(db, time.Now())
+		require.NoError(t, err)
+		require.Empty(t, changes.Books)
+	})
+}
@@ -0,0 +1,215 @@
+package library
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The cookies of the browser sessions.
+const (
+	sessionCookie = "library_session"
+	csrfCookie    = "library_csrf"
+	csrfField     = "csrf_token" // The form field with the CSRF token
+)
+
+// SessionTimeouts limit how long a member stays logged in. A session ends
+// when it has not been used for the idle timeout, and at the latest after
+// the absolute timeout.
+type SessionTimeouts struct {
+	Idle     time.Duration
+	Absolute time.Duration
+}
+
+var defaultSessionTimeouts = SessionTimeouts{
+	Idle:     24 * time.Hour,
+	Absolute: 30 * 24 * time.Hour,
+}
+
+// sessionTouchInterval is how often the last use of a session is stored,
+// so that not every request writes to the database.
+const sessionTouchInterval = time.Minute
+
+// WithSessionTimeouts sets the idle and absolute timeouts of the sessions.
+// The default is 24h idle and 30 days absolute.
+func WithSessionTimeouts(t SessionTimeouts) ServerOption {
+	return func(s *Server) {
+		s.sessionTimeouts = t
+	}
+}
+
+// WithSessionKey sets the key which signs the session cookies. The default
+// is a random key, which logs out the browsers when the server restarts.
+func WithSessionKey(key []byte) ServerOption {
+	return func(s *Server) {
+		s.sessionKey = key
+	}
+}
+
+// randomSessionKey creates a key for signing the session cookies.
+func randomSessionKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("failed to create session key, %v", err))
+	}
+	return key
+}
+
+// signCookie appends a signature to the value of a cookie.
+func (s *Server) signCookie(value string) string {
+	mac := hmac.New(sha256.New, s.sessionKey)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyCookie returns the value of a signed cookie, or "" when the
+// signature does not match.
+func (s *Server) verifyCookie(signed string) string {
+	i := strings.LastIndexByte(signed, '.')
+	if i < 0 {
+		return ""
+	}
+	if !hmac.Equal([]byte(s.signCookie(signed[:i])), []byte(signed)) {
+		return ""
+	}
+	return signed[:i]
+}
+
+// startSession issues a session token for the member.
+func (s *Server) startSession(db Querier, m Member) (Session, error) {
+	token, expireTime, err := issueToken(db, m.ID, TokenSession, time.Now(), s.sessionTimeouts.Absolute)
+	return Session{Token: token, ExpireTime: expireTime, Member: m}, err
+}
+
+// writeSessionCookie sets the session cookie of a browser. The cookie can
+// not be read by scripts and is not sent by cross-site requests, except
+// when following a link.
+func (s *Server) writeSessionCookie(w http.ResponseWriter, session Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    s.signCookie(session.Token),
+		Path:     "/",
+		Expires:  session.ExpireTime,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearSessionCookie removes the session cookie of a browser.
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionToken returns the session token of the Authorization header, or
+// else of the session cookie.
+func (s *Server) sessionToken(r *http.Request) string {
+	if token := bearerToken(r); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return s.verifyCookie(c.Value)
+	}
+	return ""
+}
+
+// sessionMember returns the member which is logged in by the request. The
+// last use of the session is stored to enforce the idle timeout.
+func (s *Server) sessionMember(r *http.Request) (Member, error) {
+	token := s.sessionToken(r)
+	if token == "" {
+		return Member{}, errNoMember
+	}
+	now := time.Now()
+	var lastUseTime int64
+	err := s.db.QueryRow("SELECT lastUseTime FROM member_token WHERE hash = ? AND kind = ? AND expireTime > ? AND lastUseTime > ?",
+		hashToken(token), TokenSession, now.Unix(), now.Add(-s.sessionTimeouts.Idle).Unix()).Scan(&lastUseTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Member{}, errNoMember
+	} else if err != nil {
+		return Member{}, fmt.Errorf("read session err, %w", err)
+	}
+	if now.Sub(time.Unix(lastUseTime, 0)) >= sessionTouchInterval {
+		err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+			_, err := tx.Exec("UPDATE member_token SET lastUseTime = ? WHERE hash = ?", now.Unix(), hashToken(token))
+			return err
+		})
+		if err != nil {
+			return Member{}, fmt.Errorf("update session err, %w", err)
+		}
+	}
+	return s.tokenMember(s.db, token, TokenSession, now)
+}
+
+// LogoutEverywhere ends every session of the member which is logged in,
+// e.g. after losing a device.
+func (s *Server) LogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		HandleErr(w, http.StatusUnauthorized, "The request is not logged in")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the session")
+		return
+	}
+	if err := s.inTx(r.Context(), func(tx *sql.Tx) error { return revokeTokens(tx, m.ID, TokenSession) }); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to end the sessions")
+		return
+	}
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// csrfToken returns the CSRF token of the browser, which is put in the
+// forms of the admin UI. A new token is set in a cookie when the browser
+// has none. Since other sites can neither read the cookie nor set it, a
+// form post whose field matches the cookie comes from the admin UI.
+func (s *Server) csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil {
+		if token := s.verifyCookie(c.Value); token != "" {
+			return c.Value
+		}
+	}
+	token, err := randomToken()
+	if err != nil {
+		handleErr("failed to create CSRF token", err)
+		return ""
+	}
+	signed := s.signCookie(token)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    signed,
+		Path:     "/admin",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return signed
+}
+
+// checkCSRF reports whether the CSRF token of a form post matches the
+// cookie of the browser. The form must have been parsed.
+func (s *Server) checkCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || s.verifyCookie(c.Value) == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostForm.Get(csrfField))) == 1
+}
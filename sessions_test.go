@@ -0,0 +1,77 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	server := NewServer(db, WithSessionTimeouts(SessionTimeouts{Idle: time.Hour, Absolute: 24 * time.Hour}))
+
+	passwordHash, err := hashPassword("pippi longstocking")
+	require.NoError(t, err)
+	m := Member{ID: "astrid", Email: "astrid@example.com", FirstName: "astrid", LastName: "lindgren",
+		EmailVerified: true, CreateTime: time.Now().UTC()}
+	require.NoError(t, server.InsertMember(db, m, passwordHash))
+
+	serve := func(method, path string, cookie *http.Cookie, body interface{}) *httptest.ResponseRecorder {
+		jsonBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(jsonBytes))
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	login := func() *http.Cookie {
+		t.Helper()
+		response := serve(http.MethodPost, "/api/v1/login", nil, loginRequest{Email: m.Email, Password: "pippi longstocking"})
+		require.Equal(t, http.StatusOK, response.Code)
+		cookies := response.Result().Cookies()
+		require.Len(t, cookies, 1)
+		return cookies[0]
+	}
+
+	t.Run("Sets a secure session cookie", func(t *testing.T) {
+		cookie := login()
+		require.Equal(t, sessionCookie, cookie.Name)
+		require.True(t, cookie.HttpOnly)
+		require.True(t, cookie.Secure)
+		require.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		require.WithinDuration(t, time.Now().Add(24*time.Hour), cookie.Expires, time.Minute)
+		require.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/me", cookie, nil).Code)
+
+		forged := *cookie
+		forged.Value = forged.Value[:len(forged.Value)-1] + "A"
+		if forged.Value == cookie.Value {
+			forged.Value = forged.Value[:len(forged.Value)-1] + "B"
+		}
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/me", &forged, nil).Code)
+	})
+
+	t.Run("Ends idle sessions", func(t *testing.T) {
+		cookie := login()
+		_, err := db.Exec("UPDATE member_token SET lastUseTime = ? WHERE kind = ?",
+			time.Now().Add(-2*time.Hour).Unix(), TokenSession)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/me", cookie, nil).Code)
+	})
+
+	t.Run("Logs out everywhere", func(t *testing.T) {
+		first, second := login(), login()
+		response := serve(http.MethodPost, "/api/v1/logout:everywhere", first, nil)
+		require.Equal(t, http.StatusNoContent, response.Code)
+		require.Equal(t, -1, response.Result().Cookies()[0].MaxAge)
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/me", first, nil).Code)
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/me", second, nil).Code)
+	})
+}
@@ -0,0 +1,266 @@
+package library
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NicolaiMordrup/library/xlsx"
+)
+
+// importFields are the fields of a book which the columns of a workbook can
+// be mapped to. author is a full name, e.g. "Lindgren, Astrid", which is
+// used when there are no columns of the first and last name, and tags are
+// the names of subjects.
+var importFields = []string{"isbn", "title", "firstName", "lastName", "author", "publisher", "description",
+	"pageCount", "language", "publicationYear", "format", "classification", "callNumber", "tags"}
+
+// headerFields maps the normalized headers which are mapped to a field by
+// default, see normalizeHeader. The headers of the export are among them,
+// so that an export can be imported again.
+var headerFields = map[string]string{
+	"isbn": "isbn", "isbn13": "isbn",
+	"title":     "title",
+	"firstname": "firstName", "authorfirstname": "firstName",
+	"lastname": "lastName", "authorlastname": "lastName",
+	"author": "author", "authorlf": "author",
+	"publisher":   "publisher",
+	"description": "description",
+	"pages":       "pageCount", "pagecount": "pageCount", "numberofpages": "pageCount",
+	"language": "language",
+	"year":     "publicationYear", "publicationyear": "publicationYear", "yearpublished": "publicationYear",
+	"format":         "format",
+	"classification": "classification",
+	"callnumber":     "callNumber",
+	"tags":           "tags", "subjects": "tags", "shelves": "tags", "bookshelves": "tags",
+}
+
+func isImportField(field string) bool {
+	for _, f := range importFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportColumn is a column of the header of an imported workbook and the
+// field it is mapped to, "" if it is not imported.
+type ImportColumn struct {
+	Header string `json:"header"`
+	Field  string `json:"field"`
+}
+
+// normalizeHeader normalizes a header for headerFields, e.g. "Author last
+// name" to "authorlastname".
+func normalizeHeader(h string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return -1
+	}, h)
+}
+
+// readXLSX reads the rows of the first worksheet of a workbook. The
+// workbook is read into memory since it is a zip archive, the size of the
+// body is limited by the server.
+func readXLSX(r io.Reader) ([][]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := xlsx.ReadFirstSheet(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, xlsx.ErrNoWorksheet
+	}
+	return rows, nil
+}
+
+// spreadsheetColumns maps the header of a workbook to the fields of a book.
+// The headers are mapped by headerFields unless the mapping is overridden by
+// a parameter of the form field:header, e.g. "title:Book name". A parameter
+// without a header, e.g. "title:", leaves the field out. It returns the
+// mapping of each header and the column of each field.
+func spreadsheetColumns(rows [][]string, overrides []string) ([]ImportColumn, map[string]int, error) {
+	header := rows[0]
+	columns := make([]ImportColumn, len(header))
+	for i, h := range header {
+		columns[i] = ImportColumn{Header: strings.TrimSpace(h), Field: headerFields[normalizeHeader(h)]}
+	}
+	for _, o := range overrides {
+		parts := strings.SplitN(o, ":", 2)
+		if len(parts) != 2 || !isImportField(parts[0]) {
+			return nil, nil, fmt.Errorf("column must be field:header, where field is one of %s", strings.Join(importFields, ", "))
+		}
+		field, h := parts[0], strings.TrimSpace(parts[1])
+		found := h == ""
+		for i := range columns {
+			switch {
+			case columns[i].Header == h && h != "":
+				columns[i].Field, found = field, true
+			case columns[i].Field == field:
+				columns[i].Field = ""
+			}
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("the workbook has no column %q", h)
+		}
+	}
+	mapping := make(map[string]int)
+	for i, c := range columns {
+		if _, ok := mapping[c.Field]; c.Field != "" && !ok {
+			mapping[c.Field] = i
+		}
+	}
+	return columns, mapping, nil
+}
+
+// spreadsheetBook maps a row of a workbook to a book, by the fields of
+// importFields.
+func spreadsheetBook(field func(string) string) importedBook {
+	author := &Author{FirstName: field("firstName"), LastName: field("lastName")}
+	if *author == (Author{}) {
+		author = importAuthor(field("author"))
+	}
+	return importedBook{book: Book{
+		ISBN:            importISBN(field("isbn")),
+		Title:           field("title"),
+		Author:          author,
+		Publisher:       field("publisher"),
+		Description:     field("description"),
+		PageCount:       importNumber(field("pageCount")),
+		Language:        field("language"),
+		PublicationYear: importNumber(field("publicationYear")),
+		Format:          field("format"),
+		Classification:  field("classification"),
+		CallNumber:      field("callNumber"),
+	}, tags: importTags(field("tags"))}
+}
+
+// importNumber reads a whole number of a cell, which is "" if the cell is
+// empty and may have decimals if it is a number cell.
+func importNumber(s string) int {
+	f, _ := strconv.ParseFloat(s, 64)
+	return int(f)
+}
+
+// exportColumns are the columns of the books sheet of an export.
+var exportColumns = []struct {
+	xlsx.Column
+	value func(Book) interface{}
+}{
+	{xlsx.Column{Header: "ISBN", Width: 16}, func(b Book) interface{} { return b.ISBN }},
+	{xlsx.Column{Header: "Title", Width: 40}, func(b Book) interface{} { return b.Title }},
+	{xlsx.Column{Header: "Author first name", Width: 18}, func(b Book) interface{} { return b.Author.FirstName }},
+	{xlsx.Column{Header: "Author last name", Width: 18}, func(b Book) interface{} { return b.Author.LastName }},
+	{xlsx.Column{Header: "Publisher", Width: 20}, func(b Book) interface{} { return b.Publisher }},
+	{xlsx.Column{Header: "Publication year"}, func(b Book) interface{} { return exportNumber(b.PublicationYear) }},
+	{xlsx.Column{Header: "Pages"}, func(b Book) interface{} { return exportNumber(b.PageCount) }},
+	{xlsx.Column{Header: "Language"}, func(b Book) interface{} { return b.Language }},
+	{xlsx.Column{Header: "Format"}, func(b Book) interface{} { return b.Format }},
+	{xlsx.Column{Header: "Classification", Width: 14}, func(b Book) interface{} { return b.Classification }},
+	{xlsx.Column{Header: "Call number", Width: 14}, func(b Book) interface{} { return b.CallNumber }},
+	{xlsx.Column{Header: "Status"}, func(b Book) interface{} { return b.Status }},
+	{xlsx.Column{Header: "Created", Width: 20}, func(b Book) interface{} { return b.CreateTime.UTC().Format(time.RFC3339) }},
+	{xlsx.Column{Header: "Updated", Width: 20}, func(b Book) interface{} { return b.UpdateTime.UTC().Format(time.RFC3339) }},
+}
+
+// exportNumber leaves the cells of unknown numbers empty.
+func exportNumber(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// ExportBooks downloads every book, including drafts and withdrawn books, as
+// an Excel workbook. The books are written to the response as they are read
+// from the database, and the counts of the books by status and format are
+// written to a summary sheet after them. Only librarians and admins can
+// export the books.
+func (s *Server) ExportBooks(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.librarianMember(r); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	rows, err := s.db.Query(selectBooks + " ORDER BY library.isbn;")
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the books")
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", xlsx.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "books.xlsx"))
+	xw := xlsx.NewWriter(w)
+	columns := make([]xlsx.Column, len(exportColumns))
+	for i, c := range exportColumns {
+		columns[i] = c.Column
+	}
+	if err := xw.AddSheet("Books", columns); err != nil {
+		handleErr("Failed to write the export", err)
+		return
+	}
+	total := 0
+	statuses := make(map[string]int)
+	formats := make(map[string]int)
+	values := make([]interface{}, len(exportColumns))
+	for rows.Next() {
+		b := scanBook(rows)
+		for i, c := range exportColumns {
+			values[i] = c.value(b)
+		}
+		if err := xw.WriteRow(values...); err != nil {
+			handleErr("Failed to write the export", err)
+			return
+		}
+		total++
+		statuses[b.Status]++
+		formats[b.Format]++
+	}
+	if err := rows.Err(); err != nil {
+		// The response has started, so the error can only be logged
+		handleErr("Failed to read the books of the export", err)
+		return
+	}
+
+	if err := xw.AddSheet("Summary", []xlsx.Column{{Header: "Books", Width: 24}, {Header: "Count"}}); err != nil {
+		handleErr("Failed to write the export", err)
+		return
+	}
+	summary := [][]interface{}{{"Total", total}}
+	for _, status := range Statuses {
+		summary = append(summary, []interface{}{"Status: " + status, statuses[status]})
+	}
+	for _, format := range Formats {
+		if formats[format] != 0 {
+			summary = append(summary, []interface{}{"Format: " + format, formats[format]})
+		}
+	}
+	if formats[""] != 0 {
+		summary = append(summary, []interface{}{"Format: unknown", formats[""]})
+	}
+	summary = append(summary, []interface{}{"Exported", time.Now().UTC().Format(time.RFC3339)})
+	for _, row := range summary {
+		if err := xw.WriteRow(row...); err != nil {
+			handleErr("Failed to write the export", err)
+			return
+		}
+	}
+	if err := xw.Close(); err != nil {
+		handleErr("Failed to write the export", err)
+		return
+	}
+}
@@ -0,0 +1,87 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/xlsx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpreadsheets(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	librarian := createMemberSession(t, db, "astrid", RoleLibrarian)
+	patron := createMemberSession(t, db, "emil")
+
+	var workbook bytes.Buffer
+	w := xlsx.NewWriter(&workbook)
+	require.NoError(t, w.AddSheet("Stock", []xlsx.Column{{Header: "ISBN"}, {Header: "Book name"}, {Header: "Author"},
+		{Header: "Publisher"}, {Header: "Year"}, {Header: "Shelf"}}))
+	require.NoError(t, w.WriteRow("9789129688313", "Pippi Langstrump", "Astrid Lindgren", "raben", 1945, "A1"))
+	require.NoError(t, w.WriteRow("9789129657470", "Mio min Mio", "Lindgren, Astrid", "raben", 1954, "A2"))
+	require.NoError(t, w.Close())
+
+	importWorkbook := func(columns ...string) (int, BookImport) {
+		t.Helper()
+		query := url.Values{"format": {"xlsx"}, "column": columns}
+		response := createNewRequest(http.MethodPost, "/api/v1/books:import?"+query.Encode(), workbook.Bytes(), db)
+		var res BookImport
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&res), response.Body.String())
+		return response.Code, res
+	}
+
+	t.Run("Maps the columns of a workbook", func(t *testing.T) {
+		code, res := importWorkbook()
+		require.Equal(t, http.StatusUnprocessableEntity, code, "the title is not mapped")
+		require.Equal(t, ImportColumn{Header: "Book name"}, res.Columns[1])
+		require.Equal(t, ImportColumn{Header: "Year", Field: "publicationYear"}, res.Columns[4])
+
+		code, res = importWorkbook("title:Book name", "publicationYear:")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, 2, res.Imported)
+		b := FindSpecificBook(db, "9789129688313")
+		require.Equal(t, "Pippi Langstrump", b.Title)
+		require.Equal(t, Author{FirstName: "Astrid", LastName: "Lindgren"}, *b.Author)
+		require.Zero(t, b.PublicationYear)
+		require.Equal(t, "Lindgren", FindSpecificBook(db, "9789129657470").Author.LastName)
+	})
+
+	t.Run("Rejects unknown fields and columns", func(t *testing.T) {
+		response := createNewRequest(http.MethodPost, "/api/v1/books:import?format=xlsx&column=shelf:Shelf", workbook.Bytes(), db)
+		require.Equal(t, http.StatusBadRequest, response.Code)
+		response = createNewRequest(http.MethodPost, "/api/v1/books:import?format=xlsx&column=title:Name", workbook.Bytes(), db)
+		require.Equal(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("Exports the books with a summary", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, createNewRequest(http.MethodGet, "/api/v1/admin/books.xlsx", nil, db).Code)
+		require.Equal(t, http.StatusForbidden,
+			createNewMemberRequest(http.MethodGet, "/api/v1/admin/books.xlsx", nil, db, patron).Code)
+		response := createNewMemberRequest(http.MethodGet, "/api/v1/admin/books.xlsx", nil, db, librarian)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, xlsx.ContentType, response.Header().Get("Content-Type"))
+		data := response.Body.Bytes()
+		rows, err := xlsx.ReadFirstSheet(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		require.Len(t, rows, 3)
+		require.Equal(t, []string{"ISBN", "Title", "Author first name"}, rows[0][:3])
+		require.Equal(t, []string{"9789129657470", "Mio min Mio", "Astrid"}, rows[1][:3])
+	})
+
+	t.Run("Imports exports", func(t *testing.T) {
+		data := createNewMemberRequest(http.MethodGet, "/api/v1/admin/books.xlsx", nil, db, librarian).Body.Bytes()
+		rows, err := xlsx.ReadFirstSheet(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		columns, mapping, err := spreadsheetColumns(rows, nil)
+		require.NoError(t, err)
+		require.Equal(t, "lastName", columns[3].Field)
+		books := mapImportRows(rows, mapping, spreadsheetBook)
+		require.Len(t, books, 2)
+		require.Equal(t, "Mio min Mio", books[0].book.Title)
+		require.Equal(t, "raben", books[0].book.Publisher)
+	})
+}
@@ -0,0 +1,99 @@
+package library
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Stats summarizes the catalog for reporting.
+type Stats struct {
+	TotalBooks         int          `json:"totalBooks"`
+	BooksAddedPerMonth []MonthCount `json:"booksAddedPerMonth"`
+}
+
+// MonthCount is a count for a month, formatted as YYYY-MM.
+type MonthCount struct {
+	Month string `json:"month"`
+	Count int    `json:"count"`
+}
+
+// statsReports are the metrics which can be downloaded as CSV, with the
+// function that writes the rows of each.
+var statsReports = map[string]func(Stats) [][]string{
+	"total-books": func(st Stats) [][]string {
+		return [][]string{{"totalBooks"}, {strconv.Itoa(st.TotalBooks)}}
+	},
+	"books-added-per-month": func(st Stats) [][]string {
+		rows := [][]string{{"month", "count"}}
+		for _, m := range st.BooksAddedPerMonth {
+			rows = append(rows, []string{m.Month, strconv.Itoa(m.Count)})
+		}
+		return rows
+	},
+}
+
+// ReadStats computes the statistics of the catalog.
+func ReadStats(db Querier) (Stats, error) {
+	st := Stats{BooksAddedPerMonth: []MonthCount{}}
+	if err := db.QueryRow("SELECT COUNT(*) FROM library").Scan(&st.TotalBooks); err != nil {
+		return Stats{}, fmt.Errorf("count books err, %w", err)
+	}
+
+	// The timestamps are stored as text starting with the date, in the time
+	// zone of the server
+	rows, err := db.Query("SELECT substr(createTime, 1, 7) AS month, COUNT(*) FROM library GROUP BY month ORDER BY month")
+	if err != nil {
+		return Stats{}, fmt.Errorf("query books per month err, %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m MonthCount
+		if err := rows.Scan(&m.Month, &m.Count); err != nil {
+			return Stats{}, fmt.Errorf("scan books per month err, %w", err)
+		}
+		st.BooksAddedPerMonth = append(st.BooksAddedPerMonth, m)
+	}
+	return st, rows.Err()
+}
+
+// GetStats retrieves the statistics of the catalog.
+func (s *Server) GetStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	st, err := ReadStats(s.db)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to compute the statistics")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the statistics")
+		return
+	}
+}
+
+// GetStatsReport downloads a metric of the statistics as CSV.
+func (s *Server) GetStatsReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	metric := mux.Vars(r)["metric"]
+	report, ok := statsReports[metric]
+	if !ok {
+		HandleErr(w, http.StatusNotFound, "Unknown metric, must be one of total-books or books-added-per-month")
+		return
+	}
+	st, err := ReadStats(s.db)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to compute the statistics")
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", metric+".csv"))
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(report(st)); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to write the report")
+		return
+	}
+}
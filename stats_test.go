@@ -0,0 +1,52 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	for _, isbn := range []string{"1233211233215", "1233211233213"} {
+		jsonBytes, _ := json.Marshal(Book{
+			ISBN:      isbn,
+			Title:     starWarsTitles[isbn],
+			Author:    &Author{FirstName: "george", LastName: "lucas"},
+			Publisher: "adlibris",
+		})
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code)
+	}
+	month := time.Now().Format("2006-01")
+
+	t.Run("Counts the books", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/stats", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		var got Stats
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Equal(t, Stats{
+			TotalBooks:         2,
+			BooksAddedPerMonth: []MonthCount{{Month: month, Count: 2}},
+		}, got)
+	})
+
+	t.Run("Downloads a metric as CSV", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/stats/books-added-per-month.csv", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		assertContentType(t, response, "text/csv; charset=utf-8", "Should get a csv report")
+		require.Equal(t, "month,count\n"+month+",2\n", response.Body.String())
+	})
+
+	t.Run("Rejects unknown metrics", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/stats/active-loans.csv", nil, db)
+		assertStatus(t, response.Code, http.StatusNotFound, "Should get status "+
+			"code 404: status not found")
+	})
+}
@@ -0,0 +1,427 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Subject is a topical heading of a thesaurus. A subject has at most one
+// broader subject, e.g. "Cats" is narrower than "Animals", and subjects
+// without a broader subject are the top terms.
+type Subject struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	BroaderID  string    `json:"broaderId,omitempty"`
+	CreateTime time.Time `json:"createTime"`
+	// Narrower is only set when a single subject or the tree is retrieved
+	Narrower []Subject `json:"narrower,omitempty"`
+}
+
+func validateSubject(s Subject) error {
+	var fieldErrors []string
+	if strings.TrimSpace(s.Name) == "" {
+		fieldErrors = append(fieldErrors, " name ")
+	}
+	if s.BroaderID != "" && s.BroaderID == s.ID {
+		fieldErrors = append(fieldErrors, " broaderId ")
+	}
+	if len(fieldErrors) != 0 {
+		return fmt.Errorf("validation failed, field error(s):%v. Fix these error before proceeding",
+			strings.Join(fieldErrors, ", "))
+	}
+	return nil
+}
+
+// InsertSubject stores a subject.
+func InsertSubject(db Querier, s Subject) error {
+	_, err := db.Exec("INSERT INTO subject (id, name, broaderId, createTime) VALUES(?,?,?,?)",
+		s.ID, s.Name, s.BroaderID, s.CreateTime)
+	if err != nil {
+		return fmt.Errorf("insert subject err, %w", err)
+	}
+	return nil
+}
+
+// UpdateSubject stores the name and the broader subject of a subject.
+func UpdateSubject(db Querier, s Subject) error {
+	_, err := db.Exec("UPDATE subject SET name = ?, broaderId = ? WHERE id = ?", s.Name, s.BroaderID, s.ID)
+	if err != nil {
+		return fmt.Errorf("update subject err, %w", err)
+	}
+	return nil
+}
+
+// FindSubject reads a subject. It returns sql.ErrNoRows if there is no such
+// subject.
+func FindSubject(db Querier, id string) (Subject, error) {
+	var s Subject
+	err := db.QueryRow("SELECT id, name, broaderId, createTime FROM subject WHERE id = ?", id).
+		Scan(&s.ID, &s.Name, &s.BroaderID, &s.CreateTime)
+	return s, err
+}
+
+// readSubjects reads the subjects matching the where clause ordered by name.
+func readSubjects(db Querier, where string, args ...interface{}) ([]Subject, error) {
+	rows, err := db.Query("SELECT id, name, broaderId, createTime FROM subject WHERE "+where+" ORDER BY name, id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query subjects err, %w", err)
+	}
+	defer rows.Close()
+	subjects := []Subject{}
+	for rows.Next() {
+		var s Subject
+		if err := rows.Scan(&s.ID, &s.Name, &s.BroaderID, &s.CreateTime); err != nil {
+			return nil, fmt.Errorf("scan subject err, %w", err)
+		}
+		subjects = append(subjects, s)
+	}
+	return subjects, rows.Err()
+}
+
+// ReadSubjectTree reads every subject, with the narrower subjects nested in
+// their broader subject.
+func ReadSubjectTree(db Querier) ([]Subject, error) {
+	subjects, err := readSubjects(db, "1")
+	if err != nil {
+		return nil, err
+	}
+	narrower := make(map[string][]Subject)
+	for _, s := range subjects {
+		narrower[s.BroaderID] = append(narrower[s.BroaderID], s)
+	}
+	var nest func(broaderID string) []Subject
+	nest = func(broaderID string) []Subject {
+		children := narrower[broaderID]
+		for i := range children {
+			children[i].Narrower = nest(children[i].ID)
+		}
+		return children
+	}
+	tree := nest("")
+	if tree == nil {
+		tree = []Subject{}
+	}
+	return tree, nil
+}
+
+// subjectIDs returns the id of the subject and, if narrower is set, the ids
+// of all of its narrower subjects.
+func subjectIDs(db Querier, id string, narrower bool) ([]string, error) {
+	if !narrower {
+		return []string{id}, nil
+	}
+	ids, err := queryStrings(db, `WITH RECURSIVE tree(id) AS (
+			SELECT ? UNION SELECT subject.id FROM subject INNER JOIN tree ON subject.broaderId = tree.id)
+		SELECT id FROM tree`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query narrower subjects err, %w", err)
+	}
+	return ids, nil
+}
+
+// checkBroaderSubject checks that the broader subject of a subject exists,
+// and that the subject is not one of the subjects above it.
+func checkBroaderSubject(db Querier, s Subject) error {
+	if s.BroaderID == "" {
+		return nil
+	}
+	if _, err := FindSubject(db, s.BroaderID); errors.Is(err, sql.ErrNoRows) {
+		return &statusError{http.StatusNotAcceptable, "The broader subject does not exist"}
+	} else if err != nil {
+		return err
+	}
+	if s.ID == "" {
+		return nil
+	}
+	below, err := subjectIDs(db, s.ID, true)
+	if err != nil {
+		return err
+	}
+	for _, id := range below {
+		if id == s.BroaderID {
+			return &statusError{http.StatusConflict, "A subject can not be narrower than one of its narrower subjects"}
+		}
+	}
+	return nil
+}
+
+// DeleteSubject deletes a subject and removes it from its books.
+func DeleteSubject(db Querier, id string) error {
+	if _, err := db.Exec("DELETE FROM book_subject WHERE subjectId = ?", id); err != nil {
+		return fmt.Errorf("delete book subjects err, %w", err)
+	}
+	if _, err := db.Exec("DELETE FROM subject WHERE id = ?", id); err != nil {
+		return fmt.Errorf("delete subject err, %w", err)
+	}
+	return nil
+}
+
+// checkSubjectName checks that no other subject under the same broader
+// subject has the name of the subject.
+func checkSubjectName(db Querier, s Subject) error {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM subject WHERE broaderId = ? AND name = ? AND id != ?",
+		s.BroaderID, s.Name, s.ID).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("query subject names err, %w", err)
+	}
+	if n > 0 {
+		return &statusError{http.StatusConflict, "The broader subject already has a subject with this name"}
+	}
+	return nil
+}
+
+// GetSubjectTree retrieves the hierarchy of all subjects, the top terms
+// first.
+func (s *Server) GetSubjectTree(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	tree, err := ReadSubjectTree(s.db)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the subjects")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(tree); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the subjects")
+		return
+	}
+}
+
+// GetSubject retrieves a subject with its narrower subjects.
+func (s *Server) GetSubject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subject, err := FindSubject(s.db, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The subject does not exist")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the subject")
+		return
+	}
+	if subject.Narrower, err = readSubjects(s.db, "broaderId = ?", subject.ID); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the subject")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(subject); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the subject")
+		return
+	}
+}
+
+// CreateSubject creates a subject, under its broader subject if it has one.
+func (s *Server) CreateSubject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var subject Subject
+	if err := decodeJSON(r, &subject); err != nil {
+		handleDecodeErr(w, err, "Failed to decode subject")
+		return
+	}
+	subject.ID = ""
+	if err := validateSubject(subject); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	subject.ID = s.idGenerator.NewID()
+	subject.CreateTime = time.Now()
+	subject.Narrower = nil
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if err := checkBroaderSubject(tx, Subject{BroaderID: subject.BroaderID}); err != nil {
+			return err
+		}
+		if err := checkSubjectName(tx, subject); err != nil {
+			return err
+		}
+		return InsertSubject(tx, subject)
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to store the subject")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(subject); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the subject")
+		return
+	}
+}
+
+// UpdateSubject renames a subject or moves it under another broader subject.
+func (s *Server) UpdateSubject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var subject Subject
+	if err := decodeJSON(r, &subject); err != nil {
+		handleDecodeErr(w, err, "Failed to decode subject")
+		return
+	}
+	subject.ID = mux.Vars(r)["id"]
+	if err := validateSubject(subject); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		existing, err := FindSubject(tx, subject.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &statusError{http.StatusNotFound, "The subject does not exist"}
+		} else if err != nil {
+			return err
+		}
+		if err := checkBroaderSubject(tx, subject); err != nil {
+			return err
+		}
+		if err := checkSubjectName(tx, subject); err != nil {
+			return err
+		}
+		subject.CreateTime = existing.CreateTime
+		return UpdateSubject(tx, subject)
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to store the subject")
+		return
+	}
+	subject.Narrower = nil
+	if err := json.NewEncoder(w).Encode(subject); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the subject")
+		return
+	}
+}
+
+// DeleteSubject deletes a subject which has no narrower subjects.
+func (s *Server) DeleteSubject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if _, err := FindSubject(tx, id); errors.Is(err, sql.ErrNoRows) {
+			return &statusError{http.StatusNotFound, "The subject does not exist"}
+		} else if err != nil {
+			return err
+		}
+		narrower, err := readSubjects(tx, "broaderId = ?", id)
+		if err != nil {
+			return err
+		}
+		if len(narrower) > 0 {
+			return &statusError{http.StatusConflict, "The subject has narrower subjects, delete or move them first"}
+		}
+		return DeleteSubject(tx, id)
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to delete the subject")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSubjectBooks retrieves the public books with the subject or, unless
+// narrower=false, with any of its narrower subjects.
+func (s *Server) GetSubjectBooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	if _, err := FindSubject(s.db, id); errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The subject does not exist")
+		return
+	}
+	ids, err := subjectIDs(s.db, id, r.URL.Query().Get("narrower") != "false")
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the subject")
+		return
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	isbns, err := queryStrings(s.db, "SELECT DISTINCT isbn FROM book_subject WHERE subjectId IN ("+placeholders+")", args...)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the books")
+		return
+	}
+	withSubject := make(map[string]bool, len(isbns))
+	for _, isbn := range isbns {
+		withSubject[isbn] = true
+	}
+	books := filterBooks(ReadPublicBookList(s.db, time.Now()), func(b Book) bool { return withSubject[b.ISBN] })
+	books = localizeAll(books, r.Header.Get("Accept-Language"))
+	sortBooks(books, "title", s.locale)
+	if books == nil {
+		books = []Book{}
+	}
+	if err := json.NewEncoder(w).Encode(books); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the books")
+		return
+	}
+}
+
+// GetBookSubjects retrieves the subjects of a book.
+func (s *Server) GetBookSubjects(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	isbn := mux.Vars(r)["isbn"]
+	if FindSpecificBook(s.db, isbn).ISBN == "" {
+		HandleErr(w, http.StatusNotFound, "The book did not exist in the library")
+		return
+	}
+	subjects, err := readSubjects(s.db, "id IN (SELECT subjectId FROM book_subject WHERE isbn = ?)", isbn)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the subjects")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(subjects); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the subjects")
+		return
+	}
+}
+
+// AddBookSubject assigns a subject to a book.
+func (s *Server) AddBookSubject(w http.ResponseWriter, r *http.Request) {
+	s.changeBookSubject(w, r, "INSERT OR IGNORE INTO book_subject (isbn, subjectId) VALUES(?,?)")
+}
+
+// RemoveBookSubject removes a subject from a book.
+func (s *Server) RemoveBookSubject(w http.ResponseWriter, r *http.Request) {
+	s.changeBookSubject(w, r, "DELETE FROM book_subject WHERE isbn = ? AND subjectId = ?")
+}
+
+// changeBookSubject executes the statement on the ISBN and the subject id
+// of the request, after checking that both exist.
+func (s *Server) changeBookSubject(w http.ResponseWriter, r *http.Request, statement string) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	vars := mux.Vars(r)
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if FindSpecificBook(tx, vars["isbn"]).ISBN == "" {
+			return &statusError{http.StatusNotFound, "The book did not exist in the library"}
+		}
+		if _, err := FindSubject(tx, vars["id"]); errors.Is(err, sql.ErrNoRows) {
+			return &statusError{http.StatusNotFound, "The subject does not exist"}
+		} else if err != nil {
+			return err
+		}
+		_, err := tx.Exec(statement, vars["isbn"], vars["id"])
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to store the subjects of the book")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,109 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubjects(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	createSubject := func(name, broaderID string) Subject {
+		t.Helper()
+		jsonBytes, _ := json.Marshal(Subject{Name: name, BroaderID: broaderID})
+		response := createNewRequest(http.MethodPost, "/api/v1/subjects", jsonBytes, db)
+		require.Equal(t, http.StatusCreated, response.Code, response.Body.String())
+		var s Subject
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&s))
+		return s
+	}
+	animals := createSubject("Animals", "")
+	cats := createSubject("Cats", animals.ID)
+	lions := createSubject("Lions", cats.ID)
+	dogs := createSubject("Dogs", animals.ID)
+	space := createSubject("Space", "")
+
+	lindgren := &Author{FirstName: "Astrid", LastName: "Lindgren"}
+	for _, b := range []Book{
+		{ISBN: "9789129688313", Title: "Pelle Svanslos", Author: lindgren, Publisher: "raben"},
+		{ISBN: "9789129657470", Title: "Lejonhjarta", Author: lindgren, Publisher: "raben"},
+		{ISBN: "9789129703788", Title: "Bamse", Author: lindgren, Publisher: "raben"},
+	} {
+		jsonBytes, _ := json.Marshal(b)
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+b.ISBN, jsonBytes, db).Code)
+	}
+	for isbn, id := range map[string]string{"9789129688313": cats.ID, "9789129657470": lions.ID, "9789129703788": dogs.ID} {
+		response := createNewRequest(http.MethodPut, "/api/v1/books/"+isbn+"/subjects/"+id, nil, db)
+		require.Equal(t, http.StatusNoContent, response.Code, response.Body.String())
+	}
+
+	t.Run("Rejects invalid subjects", func(t *testing.T) {
+		for _, s := range []Subject{{Name: ""}, {Name: "Birds", BroaderID: "missing"}} {
+			jsonBytes, _ := json.Marshal(s)
+			require.Equal(t, http.StatusNotAcceptable, createNewRequest(http.MethodPost, "/api/v1/subjects", jsonBytes, db).Code)
+		}
+		jsonBytes, _ := json.Marshal(Subject{Name: "cats", BroaderID: animals.ID})
+		require.Equal(t, http.StatusConflict, createNewRequest(http.MethodPost, "/api/v1/subjects", jsonBytes, db).Code)
+	})
+
+	t.Run("Retrieves the tree", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/subjects/tree", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var tree []Subject
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&tree))
+		require.Len(t, tree, 2)
+		require.Equal(t, "Animals", tree[0].Name)
+		require.Equal(t, "Space", tree[1].Name)
+		require.Len(t, tree[0].Narrower, 2)
+		require.Equal(t, "Cats", tree[0].Narrower[0].Name)
+		require.Equal(t, "Lions", tree[0].Narrower[0].Narrower[0].Name)
+	})
+
+	t.Run("Browses the books of a subject", func(t *testing.T) {
+		books := func(path string) []string {
+			t.Helper()
+			response := createNewRequest(http.MethodGet, path, nil, db)
+			require.Equal(t, http.StatusOK, response.Code)
+			var books []Book
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&books))
+			var isbns []string
+			for _, b := range books {
+				isbns = append(isbns, b.ISBN)
+			}
+			return isbns
+		}
+		require.Equal(t, []string{"9789129657470", "9789129688313"}, books("/api/v1/subjects/"+cats.ID+"/books"))
+		require.Equal(t, []string{"9789129688313"}, books("/api/v1/subjects/"+cats.ID+"/books?narrower=false"))
+		require.Len(t, books("/api/v1/subjects/"+animals.ID+"/books"), 3)
+		require.Empty(t, books("/api/v1/subjects/"+space.ID+"/books"))
+	})
+
+	t.Run("Moves a subject", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(Subject{Name: "Cats", BroaderID: lions.ID})
+		response := createNewRequest(http.MethodPut, "/api/v1/subjects/"+cats.ID, jsonBytes, db)
+		require.Equal(t, http.StatusConflict, response.Code, "a subject can not be below itself")
+
+		jsonBytes, _ = json.Marshal(Subject{Name: "Felines", BroaderID: animals.ID})
+		response = createNewRequest(http.MethodPut, "/api/v1/subjects/"+cats.ID, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		response = createNewRequest(http.MethodGet, "/api/v1/books/9789129688313/subjects", nil, db)
+		var subjects []Subject
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&subjects))
+		require.Len(t, subjects, 1)
+		require.Equal(t, "Felines", subjects[0].Name)
+	})
+
+	t.Run("Deletes subjects without narrower subjects", func(t *testing.T) {
+		require.Equal(t, http.StatusConflict, createNewRequest(http.MethodDelete, "/api/v1/subjects/"+cats.ID, nil, db).Code)
+		require.Equal(t, http.StatusNoContent, createNewRequest(http.MethodDelete, "/api/v1/subjects/"+lions.ID, nil, db).Code)
+		require.Equal(t, http.StatusNotFound, createNewRequest(http.MethodGet, "/api/v1/subjects/"+lions.ID, nil, db).Code)
+		response := createNewRequest(http.MethodDelete, "/api/v1/books/9789129688313/subjects/"+cats.ID, nil, db)
+		require.Equal(t, http.StatusNoContent, response.Code)
+		require.Equal(t, http.StatusNoContent, createNewRequest(http.MethodDelete, "/api/v1/subjects/"+cats.ID, nil, db).Code)
+	})
+}
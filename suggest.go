@@ -0,0 +1,159 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The number of suggestions unless limit is given, and the largest limit
+// which may be requested.
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 50
+)
+
+// The fields which suggestions are taken from.
+const (
+	SuggestTitle  = "title"
+	SuggestAuthor = "author"
+)
+
+// Suggestion is a completion of a partly typed search.
+type Suggestion struct {
+	Text  string `json:"text"`
+	Field string `json:"field"`          // One of SuggestTitle or SuggestAuthor
+	ISBN  string `json:"isbn,omitempty"` // The book of a title suggestion
+}
+
+// suggestEntry is a key of the prefix index. Every word of a title or an
+// author starts a key, so that "str" completes "the empire strikes back".
+type suggestEntry struct {
+	key           string // The normalized text from a word onwards
+	start         bool   // Whether the key is the whole text
+	suggestion    Suggestion
+	availableFrom *time.Time
+}
+
+// suggestIndex is an in-memory prefix index of the titles and authors. It is
+// marked as stale by the writes of the server and rebuilt on the next
+// lookup. Writes by other processes are not noticed.
+type suggestIndex struct {
+	mu      sync.Mutex
+	stale   bool
+	entries []suggestEntry // Sorted by key
+}
+
+func newSuggestIndex() *suggestIndex {
+	return &suggestIndex{stale: true}
+}
+
+// invalidate marks the index as stale after a write.
+func (idx *suggestIndex) invalidate() {
+	idx.mu.Lock()
+	idx.stale = true
+	idx.mu.Unlock()
+}
+
+// suggestKeys returns the keys of text, one starting at each word.
+func suggestKeys(text string) []string {
+	words := strings.Fields(normalizeText(text))
+	keys := make([]string, len(words))
+	for i := range words {
+		keys[i] = strings.Join(words[i:], " ")
+	}
+	return keys
+}
+
+// buildSuggestEntries indexes the titles and authors of the books.
+func buildSuggestEntries(books []Book) []suggestEntry {
+	var entries []suggestEntry
+	add := func(b Book, s Suggestion) {
+		for i, key := range suggestKeys(s.Text) {
+			entries = append(entries, suggestEntry{key: key, start: i == 0, suggestion: s, availableFrom: b.AvailableFrom})
+		}
+	}
+	for _, b := range books {
+		add(b, Suggestion{Text: b.Title, Field: SuggestTitle, ISBN: b.ISBN})
+		if b.Author != nil {
+			add(b, Suggestion{Text: strings.TrimSpace(b.Author.FirstName + " " + b.Author.LastName), Field: SuggestAuthor})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries
+}
+
+// lookup returns the entries whose key starts with prefix, rebuilding the
+// index first if it is stale.
+func (idx *suggestIndex) lookup(db Querier, prefix string) []suggestEntry {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.stale {
+		idx.entries = buildSuggestEntries(ReadDatabaseList(db))
+		idx.stale = false
+	}
+	i := sort.Search(len(idx.entries), func(i int) bool { return idx.entries[i].key >= prefix })
+	j := i
+	for j < len(idx.entries) && strings.HasPrefix(idx.entries[j].key, prefix) {
+		j++
+	}
+	return idx.entries[i:j]
+}
+
+// Suggest completes a partly typed search with titles and authors, e.g.
+// ?q=sta&field=title. Completions of the beginning of a title or name come
+// first. Embargoed books are not suggested.
+func (s *Server) Suggest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	prefix := normalizeText(r.URL.Query().Get("q"))
+	if prefix == "" {
+		HandleErr(w, http.StatusBadRequest, "q must not be empty")
+		return
+	}
+	field := r.URL.Query().Get("field")
+	if field != "" && field != SuggestTitle && field != SuggestAuthor {
+		HandleErr(w, http.StatusBadRequest, "field must be one of title or author")
+		return
+	}
+	limit := defaultSuggestLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxSuggestLimit {
+			HandleErr(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxSuggestLimit))
+			return
+		}
+	}
+
+	now := time.Now()
+	var matches []suggestEntry
+	for _, e := range s.suggestions.lookup(s.db, prefix) {
+		if (field == "" || e.suggestion.Field == field) && (e.availableFrom == nil || !e.availableFrom.After(now)) {
+			matches = append(matches, e)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].start != matches[j].start {
+			return matches[i].start
+		}
+		return matches[i].key < matches[j].key
+	})
+	suggestions := []Suggestion{}
+	seen := make(map[Suggestion]bool)
+	for _, e := range matches {
+		if len(suggestions) == limit {
+			break
+		}
+		if !seen[e.suggestion] {
+			seen[e.suggestion] = true
+			suggestions = append(suggestions, e.suggestion)
+		}
+	}
+	if err := json.NewEncoder(w).Encode(suggestions); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the suggestions")
+		return
+	}
+}
@@ -0,0 +1,77 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuggest(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	embargo := time.Now().Add(time.Hour)
+	for _, b := range []Book{
+		{ISBN: "1233211233215", Title: "Star Wars", Author: &Author{FirstName: "george", LastName: "lucas"}},
+		{ISBN: "1233211233213", Title: "Stardust", Author: &Author{FirstName: "neil", LastName: "gaiman"}},
+		{ISBN: "1233211233210", Title: "The Stand", Author: &Author{FirstName: "stephen", LastName: "king"}},
+		{ISBN: "1233211233211", Title: "Starship Troopers", Author: &Author{FirstName: "robert", LastName: "heinlein"},
+			AvailableFrom: &embargo},
+	} {
+		b.Publisher = "adlibris"
+		jsonBytes, _ := json.Marshal(b)
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodPost, "/api/v1/books/"+b.ISBN, jsonBytes, db).Code)
+	}
+
+	// The same server is used throughout, since the index is kept in memory
+	server := NewServer(db)
+	serve := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		return response
+	}
+	suggest := func(query string) []Suggestion {
+		t.Helper()
+		response := serve(http.MethodGet, "/api/v1/suggest?"+query, nil)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		var got []Suggestion
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		return got
+	}
+
+	t.Run("Completes the beginning of titles first", func(t *testing.T) {
+		require.Equal(t, []Suggestion{
+			{Text: "Star Wars", Field: SuggestTitle, ISBN: "1233211233215"},
+			{Text: "Stardust", Field: SuggestTitle, ISBN: "1233211233213"},
+			{Text: "The Stand", Field: SuggestTitle, ISBN: "1233211233210"},
+		}, suggest("q=sta&field=title"))
+	})
+
+	t.Run("Completes authors", func(t *testing.T) {
+		require.Equal(t, []Suggestion{{Text: "stephen king", Field: SuggestAuthor}}, suggest("q=ste&field=author"))
+		require.Equal(t, []Suggestion{{Text: "george lucas", Field: SuggestAuthor}}, suggest("q=luc"))
+	})
+
+	t.Run("Limits the suggestions", func(t *testing.T) {
+		require.Len(t, suggest("q=sta&limit=2"), 2)
+	})
+
+	t.Run("Rebuilds the index after writes", func(t *testing.T) {
+		require.Empty(t, suggest("q=dune"))
+		jsonBytes, _ := json.Marshal(Book{ISBN: "1233211233212", Title: "Dune",
+			Author: &Author{FirstName: "frank", LastName: "herbert"}, Publisher: "adlibris"})
+		require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/1233211233212", jsonBytes).Code)
+		require.Len(t, suggest("q=dune"), 1)
+	})
+
+	t.Run("Rejects invalid requests", func(t *testing.T) {
+		for _, query := range []string{"", "q=sta&field=publisher", "q=sta&limit=0"} {
+			require.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/api/v1/suggest?"+query, nil).Code, query)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package library
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// Tombstone records that a book was deleted.
+type Tombstone struct {
+	ISBN       string    `json:"isbn" xml:"isbn" yaml:"isbn"`
+	DeleteTime time.Time `json:"deleteTime" xml:"deleteTime" yaml:"deleteTime"`
+}
+
+// BookChanges is the response to an incremental sync request. SyncTime
+// should be used as modified_since in the next sync request.
+type BookChanges struct {
+	XMLName  xml.Name    `json:"-" xml:"changes" yaml:"-"`
+	Books    []Book      `json:"books" xml:"books>book" yaml:"books"`
+	Deleted  []Tombstone `json:"deleted" xml:"deleted>tombstone" yaml:"deleted"`
+	SyncTime time.Time   `json:"syncTime" xml:"syncTime" yaml:"syncTime"`
+}
+
+// InsertTombstone records that the book with the given isbn was deleted.
+func InsertTombstone(db Querier, isbn string, deleteTime time.Time) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO book_tombstone (isbn, deleteTime) VALUES(?,?)",
+		isbn, deleteTime)
+	if err != nil {
+		return fmt.Errorf("insert tombstone err, %w", err)
+	}
+	return nil
+}
+
+// RemoveTombstone forgets the deletion of a book, used when a book is
+// created again.
+func RemoveTombstone(db Querier, isbn string) error {
+	if _, err := db.Exec("DELETE FROM book_tombstone WHERE isbn=?", isbn); err != nil {
+		return fmt.Errorf("delete tombstone err, %w", err)
+	}
+	return nil
+}
+
+// ReadTombstones reads the tombstones of all deleted books.
+func ReadTombstones(db Querier) ([]Tombstone, error) {
+	rows, err := db.Query("SELECT isbn, deleteTime FROM book_tombstone ORDER BY isbn")
+	if err != nil {
+		return nil, fmt.Errorf("query tombstones err, %w", err)
+	}
+	defer rows.Close()
+	var tombstones []Tombstone
+	for rows.Next() {
+		var t Tombstone
+		if err := rows.Scan(&t.ISBN, &t.DeleteTime); err != nil {
+			return nil, fmt.Errorf("scan tombstone err, %w", err)
+		}
+		tombstones = append(tombstones, t)
+	}
+	return tombstones, rows.Err()
+}
+
+// ReadChangesSince reads the books which were created, updated or deleted
+// after the given time. Embargoed books are left out, and a book counts as
+// changed when its embargo ends so that clients which synced while it was
+// hidden will get it.
+//
+// The timestamps are compared here rather than in the query since the sqlite
+// driver stores them as strings which do not sort chronologically.
+func ReadChangesSince(db Querier, since time.Time) (BookChanges, error) {
+	changes := BookChanges{SyncTime: time.Now()}
+	for _, b := range ReadPublicBookList(db, changes.SyncTime) {
+		changed := b.CreateTime.After(since) || b.UpdateTime.After(since) ||
+			(b.AvailableFrom != nil && b.AvailableFrom.After(since))
+		if changed {
+			changes.Books = append(changes.Books, b)
+		}
+	}
+	tombstones, err := ReadTombstones(db)
+	if err != nil {
+		return BookChanges{}, err
+	}
+	for _, t := range tombstones {
+		if t.DeleteTime.After(since) {
+			changes.Deleted = append(changes.Deleted, t)
+		}
+	}
+	return changes, nil
+}
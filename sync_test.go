@@ -0,0 +1,58 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestModifiedSince(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	create := func(isbn, title string) {
+		jsonBytes, err := json.Marshal(Book{
+			ISBN:      isbn,
+			Title:     title,
+			Author:    &Author{FirstName: "george", LastName: "lucas"},
+			Publisher: "adlibris",
+		})
+		require.NoError(t, err)
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code)
+	}
+
+	create("1233211233215", "star wars")
+	create("1233211233213", "revenge of the sith")
+	since := time.Now()
+	create("1233211233210", "phantom menace")
+	response := createNewRequest(http.MethodDelete, "/api/v1/books/1233211233213", nil, db)
+	require.Equal(t, http.StatusOK, response.Code)
+
+	t.Run("Returns the books created, updated or deleted since", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/books?modified_since="+
+			url.QueryEscape(since.Format(time.RFC3339Nano)), nil, db)
+
+		var got BookChanges
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		assertStatus(t, response.Code, http.StatusOK, "Should get status code 200: "+
+			"status OK")
+		require.Len(t, got.Books, 1)
+		require.Equal(t, "1233211233210", got.Books[0].ISBN)
+		require.Len(t, got.Deleted, 1)
+		require.Equal(t, "1233211233213", got.Deleted[0].ISBN)
+		require.True(t, got.SyncTime.After(since))
+	})
+
+	t.Run("Rejects timestamps which are not RFC3339", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet,
+			"/api/v1/books?modified_since=yesterday", nil, db)
+
+		assertStatus(t, response.Code, http.StatusBadRequest, "Should get status "+
+			"code 400: status bad request")
+	})
+}
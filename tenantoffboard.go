@@ -0,0 +1,312 @@
+package library
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// offboardedDir is the directory of the export archives of the offboarded
+// tenants, below the directory of the tenants.
+const offboardedDir = "offboarded"
+
+// Offboarding is the audit record of an offboarded tenant, whose data was
+// exported to an archive before it was deleted.
+type Offboarding struct {
+	Tenant       string    `json:"tenant"`
+	Archive      string    `json:"archive"` // The file name of the archive
+	Size         int64     `json:"size"`
+	SHA256       string    `json:"sha256"`
+	Books        int       `json:"books"`
+	Files        int       `json:"files"` // The e-book files and blobs in the archive
+	OffboardTime time.Time `json:"offboardTime"`
+}
+
+func (t *Tenants) offboardedPath(name string) string {
+	return filepath.Join(t.dir, offboardedDir, name)
+}
+
+// exportTenant writes the export archive of a tenant which gets no new
+// requests. The archive holds the database as library.db, the quota as
+// quota.json, the e-book files below ebooks/ and the blobs of the covers and
+// attachments below blobs/, by their keys in the namespace of the tenant.
+// The archive is only given its name once it is complete.
+func (t *Tenants) exportTenant(ctx context.Context, id string, tn *tenant, now time.Time) (Offboarding, error) {
+	o := Offboarding{Tenant: id, Archive: fmt.Sprintf("%s-%s.zip", id, now.UTC().Format("20060102T150405Z")), OffboardTime: now}
+	var err error
+	if o.Books, err = countBooks(tn.db); err != nil {
+		return o, err
+	}
+	if err := os.MkdirAll(t.offboardedPath(""), 0o755); err != nil {
+		return o, fmt.Errorf("create offboarded dir err, %w", err)
+	}
+	f, err := os.CreateTemp(t.offboardedPath(""), o.Archive+".*.tmp")
+	if err != nil {
+		return o, fmt.Errorf("create archive err, %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(f, hash)}
+	archive := zip.NewWriter(counter)
+	add := func(name string, r io.Reader) error {
+		w, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
+	}
+	addFile := func(name, path string) error {
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		return add(name, src)
+	}
+
+	backup, err := tempPath("offboard")
+	if err != nil {
+		return o, fmt.Errorf("export database err, %w", err)
+	}
+	defer os.RemoveAll(filepath.Dir(backup))
+	if err := BackupTo(tn.db, backup); err != nil {
+		return o, err
+	}
+	if err := addFile("library.db", backup); err != nil {
+		return o, fmt.Errorf("export database err, %w", err)
+	}
+	if err := addFile("quota.json", t.quotaPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return o, fmt.Errorf("export quota err, %w", err)
+	}
+
+	s := tn.server
+	if s.ebookDir != "" {
+		root := s.ebookFiles()
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if errors.Is(err, os.ErrNotExist) && path == root {
+				return fs.SkipDir
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			o.Files++
+			return addFile("ebooks/"+filepath.ToSlash(rel), path)
+		})
+		if err != nil {
+			return o, fmt.Errorf("export e-book files err, %w", err)
+		}
+	}
+	if s.blobStore != nil {
+		keys, err := s.blobKeys()
+		if err != nil {
+			return o, err
+		}
+		for _, key := range keys {
+			rc, err := s.blobStore.Get(ctx, key)
+			if err != nil {
+				return o, fmt.Errorf("export blob %s err, %w", key, err)
+			}
+			err = add("blobs/"+strings.TrimPrefix(key, s.blobKey("")), rc)
+			rc.Close()
+			if err != nil {
+				return o, fmt.Errorf("export blob %s err, %w", key, err)
+			}
+			o.Files++
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return o, fmt.Errorf("write archive err, %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return o, fmt.Errorf("write archive err, %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return o, fmt.Errorf("write archive err, %w", err)
+	}
+	if err := os.Rename(f.Name(), t.offboardedPath(o.Archive)); err != nil {
+		return o, fmt.Errorf("write archive err, %w", err)
+	}
+	o.Size, o.SHA256 = counter.n, hex.EncodeToString(hash.Sum(nil))
+	return o, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// offboardingLog is the audit log of the offboarded tenants, one record per
+// line.
+func (t *Tenants) offboardingLog() string {
+	return t.offboardedPath("offboardings.jsonl")
+}
+
+// appendOffboarding appends the record to the audit log. The lock must be
+// held.
+func (t *Tenants) appendOffboarding(o Offboarding) error {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(t.offboardingLog(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open offboarding log err, %w", err)
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write offboarding log err, %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("write offboarding log err, %w", err)
+	}
+	return f.Close()
+}
+
+// readOffboardings reads the audit log, oldest first.
+func (t *Tenants) readOffboardings() ([]Offboarding, error) {
+	offboardings := []Offboarding{}
+	f, err := os.Open(t.offboardingLog())
+	if errors.Is(err, os.ErrNotExist) {
+		return offboardings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open offboarding log err, %w", err)
+	}
+	defer f.Close()
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var o Offboarding
+		if err := json.Unmarshal(lines.Bytes(), &o); err != nil {
+			return nil, fmt.Errorf("read offboarding log err, %w", err)
+		}
+		offboardings = append(offboardings, o)
+	}
+	return offboardings, lines.Err()
+}
+
+// OffboardTenant exports the data and the files of a tenant to an archive
+// and then deletes the tenant, see DeleteTenant. The tenant gets no new
+// requests while it is offboarded, and is served again if the export or the
+// deletion fails, so that the offboarding can be retried. An audit record of
+// the offboarding is kept, see ListOffboardings.
+func (t *Tenants) OffboardTenant(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	t.mu.Lock()
+	tn, ok := t.find(id)
+	if ok {
+		tn.deleting = true
+	}
+	t.mu.Unlock()
+	if !ok {
+		HandleErr(w, http.StatusNotFound, "The tenant does not exist")
+		return
+	}
+	tn.requests.Wait()
+	o, err := t.exportTenant(r.Context(), id, tn, time.Now())
+	if err != nil {
+		handleErr("Failed to export a tenant", err)
+		t.restore(id, tn, false)
+		HandleErr(w, http.StatusInternalServerError, "Failed to export the tenant")
+		return
+	}
+	if err := t.deleteTenant(r.Context(), id, tn); err != nil {
+		os.Remove(t.offboardedPath(o.Archive))
+		handleMemberErr(w, err, "Failed to delete the tenant")
+		return
+	}
+	t.mu.Lock()
+	err = t.appendOffboarding(o)
+	t.mu.Unlock()
+	if err != nil {
+		// The tenant is gone, so the archive is kept and the record is
+		// returned even though it could not be logged
+		handleErr("Failed to log the offboarding of a tenant", err)
+	}
+	if err := json.NewEncoder(w).Encode(o); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the offboarding")
+		return
+	}
+}
+
+// ListOffboardings retrieves the audit records of the offboarded tenants,
+// the latest first.
+func (t *Tenants) ListOffboardings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	t.mu.RLock()
+	offboardings, err := t.readOffboardings()
+	t.mu.RUnlock()
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the offboardings")
+		return
+	}
+	for i, j := 0, len(offboardings)-1; i < j; i, j = i+1, j-1 {
+		offboardings[i], offboardings[j] = offboardings[j], offboardings[i]
+	}
+	if err := json.NewEncoder(w).Encode(offboardings); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the offboardings")
+		return
+	}
+}
+
+// GetOffboardingArchive downloads the export archive of an offboarded
+// tenant. Only the archives in the audit log can be downloaded.
+func (t *Tenants) GetOffboardingArchive(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["archive"]
+	t.mu.RLock()
+	offboardings, err := t.readOffboardings()
+	t.mu.RUnlock()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the offboardings")
+		return
+	}
+	for _, o := range offboardings {
+		if o.Archive != name {
+			continue
+		}
+		f, err := os.Open(t.offboardedPath(o.Archive))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			HandleErr(w, http.StatusNotFound, "The archive has been removed")
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", o.Archive))
+		http.ServeContent(w, r, o.Archive, o.OffboardTime, f)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	HandleErr(w, http.StatusNotFound, "The archive does not exist")
+}
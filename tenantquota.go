@@ -0,0 +1,268 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// requestPeriod is the period of the request quota of a tenant.
+const requestPeriod = time.Hour
+
+// Quota limits the usage of a tenant, a zero limit is unlimited.
+type Quota struct {
+	MaxBooks           int   `json:"maxBooks,omitempty"`
+	MaxStorageBytes    int64 `json:"maxStorageBytes,omitempty"` // The size of the database and the attachments
+	MaxRequestsPerHour int64 `json:"maxRequestsPerHour,omitempty"`
+}
+
+// Usage is the usage of a tenant. The requests are counted since the server
+// started.
+type Usage struct {
+	Requests           int64     `json:"requests"`
+	RequestsThisPeriod int64     `json:"requestsThisPeriod"` // Since PeriodStart
+	PeriodStart        time.Time `json:"periodStart"`
+	Books              int       `json:"books"`
+	StorageBytes       int64     `json:"storageBytes"` // The size of the database
+	AttachmentBytes    int64     `json:"attachmentBytes"`
+	Quota              Quota     `json:"quota"`
+}
+
+func validQuota(q Quota) bool {
+	return q.MaxBooks >= 0 && q.MaxStorageBytes >= 0 && q.MaxRequestsPerHour >= 0
+}
+
+// meter counts the requests of a tenant in fixed periods.
+type meter struct {
+	mu          sync.Mutex
+	total       int64
+	periodStart time.Time
+	period      int64
+}
+
+// count counts a request and returns the number of requests in the current
+// period, including this one, and when the period ends.
+func (m *meter) count(now time.Time) (int64, time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.periodStart) >= requestPeriod {
+		m.periodStart, m.period = now.Truncate(requestPeriod), 0
+	}
+	m.total++
+	m.period++
+	return m.period, m.periodStart.Add(requestPeriod)
+}
+
+func (m *meter) read() (total, period int64, start time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.total, m.period, m.periodStart
+}
+
+// quotaPath is the file which stores the quota of the tenant, next to its
+// database.
+func (t *Tenants) quotaPath(id string) string {
+	return filepath.Join(t.dir, id+".quota.json")
+}
+
+func (t *Tenants) readQuota(id string) (Quota, error) {
+	var q Quota
+	b, err := os.ReadFile(t.quotaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return q, err
+	}
+	return q, json.Unmarshal(b, &q)
+}
+
+func (t *Tenants) writeQuota(id string, q Quota) error {
+	b, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(t.quotaPath(id), b, 0o644)
+}
+
+// storageBytes returns the size of the database of the tenant, including
+// its write-ahead log.
+func (t *Tenants) storageBytes(id string) (int64, error) {
+	var size int64
+	for _, suffix := range []string{"", "-wal"} {
+		info, err := os.Stat(t.path(id) + suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+func countBooks(db Querier) (int, error) {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM library").Scan(&n); err != nil {
+		return 0, fmt.Errorf("count books err, %w", err)
+	}
+	return n, nil
+}
+
+// attachmentBytes returns the total size of the attachments.
+func attachmentBytes(db Querier) (int64, error) {
+	var n int64
+	if err := db.QueryRow("SELECT COALESCE(SUM(size), 0) FROM attachment").Scan(&n); err != nil {
+		return 0, fmt.Errorf("sum attachment sizes err, %w", err)
+	}
+	return n, nil
+}
+
+// checkStorageQuota fails with 403 if adding an attachment of size bytes
+// would take the tenant over its storage quota, which counts the database
+// and the attachments. It is called in the transaction which adds the
+// attachment, once the size of the upload is known.
+func (s *Server) checkStorageQuota(q Querier, size int64) error {
+	if s.storageQuota == nil {
+		return nil
+	}
+	max, used, err := s.storageQuota()
+	if err != nil || max == 0 {
+		return err
+	}
+	attachments, err := attachmentBytes(q)
+	if err != nil {
+		return err
+	}
+	if used+attachments+size > max {
+		return &statusError{http.StatusForbidden, "The tenant has reached its quota of storage"}
+	}
+	return nil
+}
+
+// checkBookQuota fails with 403 if the tenant has reached its quota of
+// books. It is called in the transaction which adds the book, so that the
+// books of imports, batches and concurrent requests are all counted.
+func (s *Server) checkBookQuota(q Querier) error {
+	if s.maxBooks == nil {
+		return nil
+	}
+	max := s.maxBooks()
+	if max == 0 {
+		return nil
+	}
+	books, err := countBooks(q)
+	if err != nil {
+		return err
+	}
+	if books >= max {
+		return &statusError{http.StatusForbidden, "The tenant has reached its quota of books"}
+	}
+	return nil
+}
+
+// checkQuota counts the request and checks it against the quota of the
+// tenant. Requests over the request quota get 429, and POST and PUT
+// requests get 403 when the tenant has reached its storage quota. The book
+// quota is checked when the books are added, see checkBookQuota.
+func (t *Tenants) checkQuota(w http.ResponseWriter, r *http.Request, id string, tn *tenant, quota Quota) bool {
+	requests, periodEnd := tn.meter.count(time.Now())
+	if quota.MaxRequestsPerHour != 0 && requests > quota.MaxRequestsPerHour {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(periodEnd).Seconds())+1))
+		HandleErr(w, http.StatusTooManyRequests, "The tenant has used its quota of requests, please try again later")
+		return false
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		return true
+	}
+	if quota.MaxStorageBytes != 0 {
+		size, err := t.storageBytes(id)
+		if err == nil {
+			var attachments int64
+			attachments, err = attachmentBytes(tn.db)
+			size += attachments
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			HandleErr(w, http.StatusInternalServerError, "Failed to read the usage of the tenant")
+			return false
+		}
+		if size >= quota.MaxStorageBytes {
+			w.Header().Set("Content-Type", "application/json")
+			HandleErr(w, http.StatusForbidden, "The tenant has reached its quota of storage")
+			return false
+		}
+	}
+	return true
+}
+
+// GetTenantUsage retrieves the usage and quota of a tenant.
+func (t *Tenants) GetTenantUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	tn, ok := t.find(id)
+	if !ok {
+		HandleErr(w, http.StatusNotFound, "The tenant does not exist")
+		return
+	}
+	usage := Usage{Quota: tn.quota}
+	usage.Requests, usage.RequestsThisPeriod, usage.PeriodStart = tn.meter.read()
+	var err error
+	if usage.Books, err = countBooks(tn.db); err == nil {
+		usage.StorageBytes, err = t.storageBytes(id)
+	}
+	if err == nil {
+		usage.AttachmentBytes, err = attachmentBytes(tn.db)
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the usage of the tenant")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(usage); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the usage")
+		return
+	}
+}
+
+// UpdateTenantQuota replaces the quota of a tenant.
+func (t *Tenants) UpdateTenantQuota(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	var quota Quota
+	if err := decodeJSON(r, &quota); err != nil {
+		handleDecodeErr(w, err, "Failed to decode quota")
+		return
+	}
+	if !validQuota(quota) {
+		HandleErr(w, http.StatusBadRequest, "The limits of a quota must not be negative")
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tn, ok := t.find(id)
+	if !ok {
+		HandleErr(w, http.StatusNotFound, "The tenant does not exist")
+		return
+	}
+	if err := t.writeQuota(id, quota); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the quota")
+		return
+	}
+	tn.quota = quota
+	if err := json.NewEncoder(w).Encode(quota); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the quota")
+		return
+	}
+}
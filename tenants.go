@@ -0,0 +1,453 @@
+package library
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// tenantHeader names the tenant of a request which is not sent to the
+// subdomain of a tenant.
+const tenantHeader = "X-Tenant-ID"
+
+// tenantIDPattern limits tenant ids to valid DNS labels, so that every
+// tenant can have a subdomain.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// namespaceDir is the directory of the namespaces in the storage which the
+// tenants share, e.g. tenants/stockholm/ in the e-book directory. An ISBN
+// can not be named so, so the files of the tenants do not clash with the
+// files of a server without a namespace.
+const namespaceDir = "tenants"
+
+// WithNamespace keeps the files of the server apart from the files of other
+// servers which share the e-book directory or the blob store, by storing
+// them below a directory or key prefix of their own. The tenants are
+// namespaced by their ids.
+func WithNamespace(namespace string) ServerOption {
+	return func(s *Server) {
+		s.namespace = namespace
+	}
+}
+
+// Tenant is a library hosted in a deployment with several libraries.
+type Tenant struct {
+	ID    string `json:"id"`
+	Quota *Quota `json:"quota,omitempty"` // Only read when the tenant is created
+}
+
+type tenant struct {
+	db     *sql.DB
+	server *Server
+	quota  Quota // Guarded by the lock of the Tenants
+	meter  *meter
+	// requests are the requests being served, which are drained before the
+	// tenant is deleted
+	requests sync.WaitGroup
+	// deleting is set while the tenant is being deleted, so that it gets no
+	// new requests. Guarded by the lock of the Tenants
+	deleting bool
+}
+
+// Tenants hosts several libraries in one deployment. Every tenant has a
+// database of its own, <id>.db in the directory, so that a query can not
+// read or change the books of another tenant. The tenant of a request is
+// given by its subdomain, e.g. stockholm.library.example.com, or by the
+// X-Tenant-ID header.
+//
+// The tenants are managed through /api/admin/tenants on the domain itself,
+// not on the subdomain of a tenant, with the admin token as the bearer
+// token. Background jobs such as backups and the search indexer are not run
+// for the tenants, so the search backend and the event outbox are not used
+// by them. The files of a tenant are kept apart from the others, see
+// WithNamespace. The usage of a tenant is limited by its quota, see Quota.
+type Tenants struct {
+	dir        string
+	domain     string // The tenants are subdomains of the domain, if set
+	adminToken string // The bearer token of the tenant admin API
+	opts       []ServerOption
+	router     *mux.Router
+	mu         sync.RWMutex
+	tenants    map[string]*tenant
+}
+
+// NewTenants hosts the tenants whose databases are in dir. Every tenant has
+// a server configured by opts. The tenant admin API is only served to
+// requests with adminToken as the bearer token, and is disabled if it is "".
+func NewTenants(dir, domain, adminToken string, opts ...ServerOption) (*Tenants, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create tenant dir err, %w", err)
+	}
+	t := &Tenants{dir: dir, domain: domain, adminToken: adminToken, opts: opts, router: mux.NewRouter(),
+		tenants: make(map[string]*tenant)}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.db"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".db")
+		if !tenantIDPattern.MatchString(id) {
+			continue
+		}
+		if t.tenants[id], err = t.open(id); err != nil {
+			t.Close()
+			return nil, fmt.Errorf("open tenant %s err, %w", id, err)
+		}
+	}
+	for _, prefix := range []string{"/api/v1", "/api"} {
+		t.router.HandleFunc(prefix+"/admin/tenants", t.withAdminToken(t.ListTenants)).Methods(http.MethodGet)
+		t.router.HandleFunc(prefix+"/admin/tenants", t.withAdminToken(t.CreateTenant)).Methods(http.MethodPost)
+		t.router.HandleFunc(prefix+"/admin/tenants/{id}", t.withAdminToken(t.DeleteTenant)).Methods(http.MethodDelete)
+		t.router.HandleFunc(prefix+"/admin/tenants/{id}:offboard", t.withAdminToken(t.OffboardTenant)).Methods(http.MethodPost)
+		t.router.HandleFunc(prefix+"/admin/offboardings", t.withAdminToken(t.ListOffboardings)).Methods(http.MethodGet)
+		t.router.HandleFunc(prefix+"/admin/offboardings/{archive}", t.withAdminToken(t.GetOffboardingArchive)).Methods(http.MethodGet)
+		t.router.HandleFunc(prefix+"/admin/tenants/{id}/usage", t.withAdminToken(t.GetTenantUsage)).Methods(http.MethodGet)
+		t.router.HandleFunc(prefix+"/admin/tenants/{id}/quota", t.withAdminToken(t.UpdateTenantQuota)).Methods(http.MethodPut)
+	}
+	return t, nil
+}
+
+// open opens the database of the tenant, creating it if needed.
+func (t *Tenants) open(id string) (*tenant, error) {
+	quota, err := t.readQuota(id)
+	if err != nil {
+		return nil, err
+	}
+	db, err := NewDB(t.path(id))
+	if err != nil {
+		return nil, err
+	}
+	if err := EnsureSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// The search indexer and the event dispatcher do not run for the tenants,
+	// and would mix the books of the tenants in one index or stream
+	tn := &tenant{db: db, quota: quota, meter: &meter{}}
+	opts := append(append([]ServerOption{}, t.opts...), WithNamespace(id), func(s *Server) {
+		s.searchBackend = nil
+		s.recordEvents = false
+		s.maxBooks = func() int {
+			t.mu.RLock()
+			defer t.mu.RUnlock()
+			return tn.quota.MaxBooks
+		}
+		s.storageQuota = func() (int64, int64, error) {
+			t.mu.RLock()
+			max := tn.quota.MaxStorageBytes
+			t.mu.RUnlock()
+			if max == 0 {
+				return 0, 0, nil
+			}
+			size, err := t.storageBytes(id)
+			return max, size, err
+		}
+	})
+	tn.server = NewServer(db, opts...)
+	return tn, nil
+}
+
+// purgeFiles deletes the files which the server of a tenant stored outside
+// of its database, the e-book files and the blobs of the covers and
+// attachments, see blobKeys.
+func (s *Server) purgeFiles(ctx context.Context) error {
+	if s.namespace == "" {
+		return errors.New("only the files of a namespace can be purged")
+	}
+	if s.ebookDir != "" {
+		if err := os.RemoveAll(s.ebookFiles()); err != nil {
+			return fmt.Errorf("remove e-book files err, %w", err)
+		}
+	}
+	if s.blobStore == nil {
+		return nil
+	}
+	keys, err := s.blobKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.blobStore.Delete(ctx, key); err != nil {
+			return fmt.Errorf("delete blob %s err, %w", key, err)
+		}
+	}
+	return nil
+}
+
+// blobKeys returns the keys of the blobs of the covers and attachments in
+// the database. The blob stores can not list the keys of a namespace, so the
+// blobs are found through the database.
+func (s *Server) blobKeys() ([]string, error) {
+	var keys []string
+	rows, err := s.db.Query("SELECT isbn FROM cover")
+	if err != nil {
+		return nil, fmt.Errorf("query covers err, %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var isbn string
+		if err := rows.Scan(&isbn); err != nil {
+			return nil, fmt.Errorf("scan cover err, %w", err)
+		}
+		for _, size := range coverSizes {
+			keys = append(keys, s.coverKey(isbn, size.name))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query covers err, %w", err)
+	}
+	attachments, err := readAttachments(s.db, "1 = 1")
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attachments {
+		keys = append(keys, s.attachmentKey(a))
+	}
+	return keys, nil
+}
+
+// find returns the tenant with the given id, unless it is being deleted.
+// The lock must be held.
+func (t *Tenants) find(id string) (*tenant, bool) {
+	tn, ok := t.tenants[id]
+	if !ok || tn.deleting {
+		return nil, false
+	}
+	return tn, true
+}
+
+func (t *Tenants) path(id string) string {
+	return filepath.Join(t.dir, id+".db")
+}
+
+// Close closes the databases of the tenants.
+func (t *Tenants) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var errs []string
+	for _, tn := range t.tenants {
+		if err := tn.db.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// subdomain returns the tenant of the subdomain the request was sent to, ""
+// if it was sent to the domain itself or there is no domain.
+func (t *Tenants) subdomain(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if t.domain != "" && strings.HasSuffix(host, "."+t.domain) {
+		return strings.TrimSuffix(host, "."+t.domain)
+	}
+	return ""
+}
+
+// tenantID returns the tenant of the request, from the subdomain if the
+// request was sent to one and otherwise from the header.
+func (t *Tenants) tenantID(r *http.Request) string {
+	if id := t.subdomain(r); id != "" {
+		return id
+	}
+	return r.Header.Get(tenantHeader)
+}
+
+// withAdminToken only lets requests with the admin token as the bearer
+// token through to the tenant admin API.
+func (t *Tenants) withAdminToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if t.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(t.adminToken)) != 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			HandleErr(w, http.StatusUnauthorized, "The request must have the tenant admin token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// ServeHTTP serves the tenant admin API on the domain itself, and passes any
+// other request, including every request to the subdomain of a tenant, to
+// the server of its tenant.
+func (t *Tenants) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var match mux.RouteMatch
+	if t.subdomain(r) == "" && t.router.Match(r, &match) {
+		t.router.ServeHTTP(w, r)
+		return
+	}
+	id := t.tenantID(r)
+	if id == "" {
+		w.Header().Set("Content-Type", "application/json")
+		HandleErr(w, http.StatusBadRequest, "The tenant must be given by the subdomain or the X-Tenant-ID header")
+		return
+	}
+	// The lock is only held to look up the tenant, since requests such as
+	// downloads can take long. The request is counted instead, so that the
+	// tenant is not deleted while its database is in use
+	t.mu.RLock()
+	tn, ok := t.find(id)
+	var quota Quota
+	if ok {
+		tn.requests.Add(1)
+		quota = tn.quota
+	}
+	t.mu.RUnlock()
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		HandleErr(w, http.StatusNotFound, "The tenant does not exist")
+		return
+	}
+	defer tn.requests.Done()
+	if !t.checkQuota(w, r, id, tn, quota) {
+		return
+	}
+	tn.server.ServeHTTP(w, r)
+}
+
+// ListTenants retrieves the tenants sorted by id.
+func (t *Tenants) ListTenants(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	t.mu.RLock()
+	tenants := make([]Tenant, 0, len(t.tenants))
+	for id, tn := range t.tenants {
+		if !tn.deleting {
+			tenants = append(tenants, Tenant{ID: id})
+		}
+	}
+	t.mu.RUnlock()
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	if err := json.NewEncoder(w).Encode(tenants); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the tenants")
+		return
+	}
+}
+
+// CreateTenant creates a tenant with an empty library.
+func (t *Tenants) CreateTenant(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var tenant Tenant
+	if err := decodeJSON(r, &tenant); err != nil {
+		handleDecodeErr(w, err, "Failed to decode tenant")
+		return
+	}
+	if !tenantIDPattern.MatchString(tenant.ID) {
+		HandleErr(w, http.StatusBadRequest, "id must be lowercase letters, digits and dashes, at most 63 characters")
+		return
+	}
+	if tenant.Quota != nil && !validQuota(*tenant.Quota) {
+		HandleErr(w, http.StatusBadRequest, "The limits of a quota must not be negative")
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.tenants[tenant.ID]; ok {
+		HandleErr(w, http.StatusConflict, "A tenant with this id already exists")
+		return
+	}
+	if tenant.Quota != nil {
+		if err := t.writeQuota(tenant.ID, *tenant.Quota); err != nil {
+			HandleErr(w, http.StatusInternalServerError, "Failed to store the quota")
+			return
+		}
+	}
+	tn, err := t.open(tenant.ID)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to create the tenant database")
+		return
+	}
+	t.tenants[tenant.ID] = tn
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(tenant); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the tenant")
+		return
+	}
+}
+
+// DeleteTenant deletes a tenant and its database. The tenant gets no new
+// requests while it is deleted, and its files and database are deleted when
+// the requests which are being served are done. The tenant is only removed
+// once it has been deleted, if the deletion fails it is served again so that
+// the deletion can be retried.
+func (t *Tenants) DeleteTenant(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	t.mu.Lock()
+	tn, ok := t.find(id)
+	if ok {
+		tn.deleting = true
+	}
+	t.mu.Unlock()
+	if !ok {
+		HandleErr(w, http.StatusNotFound, "The tenant does not exist")
+		return
+	}
+	tn.requests.Wait()
+	if err := t.deleteTenant(r.Context(), id, tn); err != nil {
+		handleMemberErr(w, err, "Failed to delete the tenant")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteTenant deletes the files and the database of a tenant which gets no
+// new requests, and removes it. The tenant is served again if the deletion
+// fails, with its database opened again if it was closed.
+func (t *Tenants) deleteTenant(ctx context.Context, id string, tn *tenant) error {
+	if err := tn.server.purgeFiles(ctx); err != nil {
+		t.restore(id, tn, false)
+		return &statusError{http.StatusInternalServerError, "Failed to delete the files of the tenant"}
+	}
+	if err := tn.db.Close(); err != nil {
+		t.restore(id, tn, true)
+		return &statusError{http.StatusInternalServerError, "Failed to close the tenant database"}
+	}
+	for _, path := range []string{t.path(id), t.path(id) + "-wal", t.path(id) + "-shm", t.quotaPath(id)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.restore(id, tn, true)
+			return &statusError{http.StatusInternalServerError, "Failed to delete the tenant database"}
+		}
+	}
+	t.mu.Lock()
+	delete(t.tenants, id)
+	t.mu.Unlock()
+	return nil
+}
+
+// restore serves a tenant whose deletion failed again. A tenant whose
+// database was closed is opened again, and removed if that fails.
+func (t *Tenants) restore(id string, tn *tenant, closed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !closed {
+		tn.deleting = false
+		return
+	}
+	reopened, err := t.open(id)
+	if err != nil {
+		handleErr("Failed to open the database of a tenant which could not be deleted", err)
+		delete(t.tenants, id)
+		return
+	}
+	reopened.meter = tn.meter
+	t.tenants[id] = reopened
+}
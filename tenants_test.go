@@ -0,0 +1,363 @@
+package library
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NicolaiMordrup/library/blobs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenants(t *testing.T) {
+	dir := t.TempDir()
+	tenants, err := NewTenants(dir, "library.example.com", "secret")
+	require.NoError(t, err)
+	defer tenants.Close()
+
+	serve := func(method, path, tenant string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if tenant != "" {
+			req.Header.Set(tenantHeader, tenant)
+		}
+		response := httptest.NewRecorder()
+		tenants.ServeHTTP(response, req)
+		return response
+	}
+	admin := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://library.example.com"+path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		response := httptest.NewRecorder()
+		tenants.ServeHTTP(response, req)
+		return response
+	}
+	for _, id := range []string{"stockholm", "malmo"} {
+		jsonBytes, _ := json.Marshal(Tenant{ID: id})
+		require.Equal(t, http.StatusCreated, admin(http.MethodPost, "/api/v1/admin/tenants", jsonBytes).Code)
+	}
+	isbn := "1233211233215"
+	jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: starWarsTitles[isbn],
+		Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "lucasfilm"})
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/"+isbn, "stockholm", jsonBytes).Code)
+
+	t.Run("Isolates the books of the tenants", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/books/"+isbn, "stockholm", nil).Code)
+		require.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/api/v1/books/"+isbn, "malmo", nil).Code)
+	})
+
+	t.Run("Takes the tenant from the subdomain", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://stockholm.library.example.com:8080/api/v1/books/"+isbn, nil)
+		req.Header.Set(tenantHeader, "malmo")
+		response := httptest.NewRecorder()
+		tenants.ServeHTTP(response, req)
+		require.Equal(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("Requires a known tenant", func(t *testing.T) {
+		require.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/api/v1/books", "", nil).Code)
+		require.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/api/v1/books", "uppsala", nil).Code)
+	})
+
+	t.Run("Rejects invalid and existing ids", func(t *testing.T) {
+		for id, want := range map[string]int{"Göteborg": http.StatusBadRequest, "-lund": http.StatusBadRequest, "malmo": http.StatusConflict} {
+			jsonBytes, _ := json.Marshal(Tenant{ID: id})
+			require.Equal(t, want, admin(http.MethodPost, "/api/admin/tenants", jsonBytes).Code, id)
+		}
+	})
+
+	t.Run("Requires the admin token on the domain", func(t *testing.T) {
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/admin/tenants", "", nil).Code)
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodDelete, "/api/v1/admin/tenants/malmo", "", nil).Code)
+
+		req := httptest.NewRequest(http.MethodDelete, "http://stockholm.library.example.com/api/v1/admin/tenants/malmo", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		response := httptest.NewRecorder()
+		tenants.ServeHTTP(response, req)
+		require.Equal(t, http.StatusNotFound, response.Code, "the subdomains of the tenants should not serve the admin API")
+		require.Equal(t, http.StatusOK, admin(http.MethodGet, "/api/v1/admin/tenants/malmo/usage", nil).Code)
+	})
+
+	t.Run("Opens the existing tenants", func(t *testing.T) {
+		reopened, err := NewTenants(dir, "", "secret")
+		require.NoError(t, err)
+		defer reopened.Close()
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/tenants", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		response := httptest.NewRecorder()
+		reopened.ServeHTTP(response, req)
+		var list []Tenant
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&list))
+		require.Equal(t, []Tenant{{ID: "malmo"}, {ID: "stockholm"}}, list)
+	})
+
+	t.Run("Deletes tenants", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, admin(http.MethodDelete, "/api/v1/admin/tenants/malmo", nil).Code)
+		require.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/api/v1/books", "malmo", nil).Code)
+		require.Equal(t, http.StatusNotFound, admin(http.MethodDelete, "/api/v1/admin/tenants/malmo", nil).Code)
+	})
+
+	t.Run("Drains the requests of a deleted tenant", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(Tenant{ID: "uppsala"})
+		require.Equal(t, http.StatusCreated, admin(http.MethodPost, "/api/v1/admin/tenants", jsonBytes).Code)
+		body, upload := io.Pipe()
+		served := make(chan int)
+		go func() {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/books:import?format=goodreads", body)
+			req.Header.Set(tenantHeader, "uppsala")
+			response := httptest.NewRecorder()
+			tenants.ServeHTTP(response, req)
+			served <- response.Code
+		}()
+		// The write returns when the import has started reading the upload
+		_, err := upload.Write([]byte("Title,ISBN\n"))
+		require.NoError(t, err)
+
+		jsonBytes, _ = json.Marshal(Tenant{ID: "lund"})
+		require.Equal(t, http.StatusCreated, admin(http.MethodPost, "/api/v1/admin/tenants", jsonBytes).Code,
+			"a long request should not hold up the admin API")
+		deleted := make(chan int)
+		go func() { deleted <- admin(http.MethodDelete, "/api/v1/admin/tenants/uppsala", nil).Code }()
+		select {
+		case <-deleted:
+			t.Fatal("the tenant should not be deleted while it serves a request")
+		case <-time.After(50 * time.Millisecond):
+		}
+		require.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/api/v1/books", "uppsala", nil).Code,
+			"the tenant should get no new requests")
+		upload.Close()
+		require.Equal(t, http.StatusOK, <-served)
+		require.Equal(t, http.StatusNoContent, <-deleted)
+	})
+}
+
+func TestTenantQuota(t *testing.T) {
+	tenants, err := NewTenants(t.TempDir(), "", "secret")
+	require.NoError(t, err)
+	defer tenants.Close()
+
+	serve := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set(tenantHeader, "stockholm")
+		if strings.HasPrefix(path, "/api/v1/admin/tenants") {
+			// The admin token is not a session of the tenants
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		response := httptest.NewRecorder()
+		tenants.ServeHTTP(response, req)
+		return response
+	}
+	createBook := func(isbn string) int {
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: starWarsTitles[isbn],
+			Author: &Author{FirstName: "george", LastName: "lucas"}, Publisher: "lucasfilm"})
+		return serve(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes).Code
+	}
+	jsonBytes, _ := json.Marshal(Tenant{ID: "stockholm", Quota: &Quota{MaxBooks: 1}})
+	require.Equal(t, http.StatusCreated, serve(http.MethodPost, "/api/v1/admin/tenants", jsonBytes).Code)
+
+	t.Run("Enforces the book quota", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, createBook("1233211233215"))
+		require.Equal(t, http.StatusForbidden, createBook("1233211233213"))
+
+		jsonBytes, _ := json.Marshal([]BatchOperation{{Method: "create", ISBN: "1233211233213", Book: &Book{
+			ISBN: "1233211233213", Title: starWarsTitles["1233211233213"], Author: &Author{FirstName: "george", LastName: "lucas"},
+			Publisher: "lucasfilm"}}})
+		response := serve(http.MethodPost, "/api/v1/books:batch", jsonBytes)
+		require.Equal(t, http.StatusUnprocessableEntity, response.Code, response.Body.String())
+		var results []BatchResult
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&results))
+		require.Equal(t, http.StatusForbidden, results[0].Status, "the books of a batch should be counted")
+	})
+
+	t.Run("Enforces the request quota", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(Quota{MaxRequestsPerHour: 4})
+		require.Equal(t, http.StatusOK, serve(http.MethodPut, "/api/v1/admin/tenants/stockholm/quota", jsonBytes).Code)
+		require.Equal(t, http.StatusOK, createBook("1233211233213"), "the book quota should be lifted")
+
+		response := serve(http.MethodGet, "/api/v1/books", nil)
+		require.Equal(t, http.StatusTooManyRequests, response.Code)
+		require.NotEmpty(t, response.Header().Get("Retry-After"))
+	})
+
+	t.Run("Reports the usage", func(t *testing.T) {
+		response := serve(http.MethodGet, "/api/v1/admin/tenants/stockholm/usage", nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		var usage Usage
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&usage))
+		require.Equal(t, int64(5), usage.Requests)
+		require.Equal(t, 2, usage.Books)
+		require.NotZero(t, usage.StorageBytes)
+		require.Equal(t, Quota{MaxRequestsPerHour: 4}, usage.Quota)
+	})
+}
+
+// failingDeletes is a blob store whose deletes fail while err is set.
+type failingDeletes struct {
+	blobs.Store
+	err error
+}
+
+func (f *failingDeletes) Delete(ctx context.Context, key string) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.Store.Delete(ctx, key)
+}
+
+func TestTenantFiles(t *testing.T) {
+	ebookDir := t.TempDir()
+	dir, err := blobs.NewDir(t.TempDir())
+	require.NoError(t, err)
+	store := &failingDeletes{Store: dir}
+	tenants, err := NewTenants(t.TempDir(), "", "secret", WithEbookDir(ebookDir), WithBlobStore(store),
+		WithSearchBackend(&fakeSearchBackend{}), WithEventOutbox())
+	require.NoError(t, err)
+	defer tenants.Close()
+
+	serve := func(method, path, tenant, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set(tenantHeader, tenant)
+		req.Header.Set("Content-Type", contentType)
+		if strings.HasPrefix(path, "/api/v1/admin/tenants") || strings.HasPrefix(path, "/api/v1/admin/offboardings") {
+			// The admin token is not a session of the tenants
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		response := httptest.NewRecorder()
+		tenants.ServeHTTP(response, req)
+		return response
+	}
+	isbn := "9789129688313"
+	attachments := make(map[string]string)
+	for _, id := range []string{"stockholm", "malmo"} {
+		jsonBytes, _ := json.Marshal(Tenant{ID: id})
+		require.Equal(t, http.StatusCreated, serve(http.MethodPost, "/api/v1/admin/tenants", "", jsonContentType, jsonBytes).Code)
+		jsonBytes, _ = json.Marshal(Book{ISBN: isbn, Title: "Pippi Langstrump", Format: FormatEbook,
+			Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben"})
+		require.Equal(t, http.StatusOK, serve(http.MethodPost, "/api/v1/books/"+isbn, id, jsonContentType, jsonBytes).Code)
+		jsonBytes, _ = json.Marshal(Ebook{Licenses: 1, LoanDays: 14})
+		require.Equal(t, http.StatusOK, serve(http.MethodPut, "/api/v1/books/"+isbn+"/ebook", id, jsonContentType, jsonBytes).Code)
+		require.Equal(t, http.StatusNoContent, serve(http.MethodPut, "/api/v1/books/"+isbn+"/ebook/file", id, "application/epub+zip", []byte(id)).Code)
+
+		response := serve(http.MethodPost, "/api/v1/books/"+isbn+"/attachments?name=notes.txt", id, "text/plain", []byte(id))
+		require.Equal(t, http.StatusCreated, response.Code, response.Body.String())
+		var a Attachment
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&a))
+		attachments[id] = tenants.tenants[id].server.attachmentKey(a)
+	}
+
+	t.Run("Keeps the files of the tenants apart", func(t *testing.T) {
+		for _, id := range []string{"stockholm", "malmo"} {
+			content, err := os.ReadFile(filepath.Join(ebookDir, namespaceDir, id, isbn))
+			require.NoError(t, err)
+			require.Equal(t, id, string(content))
+			require.True(t, strings.HasPrefix(attachments[id], namespaceDir+"/"+id+"/"), attachments[id])
+		}
+		require.NotEqual(t, tenants.tenants["stockholm"].server.coverKey(isbn, "thumb"),
+			tenants.tenants["malmo"].server.coverKey(isbn, "thumb"))
+	})
+
+	t.Run("Does not share the search backend or the event outbox", func(t *testing.T) {
+		server := tenants.tenants["stockholm"].server
+		require.Nil(t, server.searchBackend)
+		require.False(t, server.recordEvents)
+	})
+
+	t.Run("Meters the attachments in the storage quota", func(t *testing.T) {
+		response := serve(http.MethodGet, "/api/v1/admin/tenants/stockholm/usage", "", "", nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		var usage Usage
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&usage))
+		require.Equal(t, int64(len("stockholm")), usage.AttachmentBytes)
+
+		jsonBytes, _ := json.Marshal(Quota{MaxStorageBytes: usage.StorageBytes + usage.AttachmentBytes + 1024})
+		require.Equal(t, http.StatusOK, serve(http.MethodPut, "/api/v1/admin/tenants/stockholm/quota", "", jsonContentType, jsonBytes).Code)
+		response = serve(http.MethodPost, "/api/v1/books/"+isbn+"/attachments?name=scan.txt", "stockholm", "text/plain",
+			bytes.Repeat([]byte("a"), 2048))
+		require.Equal(t, http.StatusForbidden, response.Code, "an upload should not take the tenant over its quota")
+		jsonBytes, _ = json.Marshal(Quota{})
+		require.Equal(t, http.StatusOK, serve(http.MethodPut, "/api/v1/admin/tenants/stockholm/quota", "", jsonContentType, jsonBytes).Code)
+	})
+
+	t.Run("Serves a tenant again when its deletion fails", func(t *testing.T) {
+		store.err = errors.New("unavailable")
+		defer func() { store.err = nil }()
+		require.Equal(t, http.StatusInternalServerError, serve(http.MethodDelete, "/api/v1/admin/tenants/malmo", "", "", nil).Code)
+		require.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/books/"+isbn, "malmo", "", nil).Code)
+		require.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/admin/tenants/malmo/usage", "", "", nil).Code)
+	})
+
+	t.Run("Deletes the files of a deleted tenant", func(t *testing.T) {
+		require.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/api/v1/admin/tenants/malmo", "", "", nil).Code)
+		_, err := os.Stat(filepath.Join(ebookDir, namespaceDir, "malmo"))
+		require.True(t, os.IsNotExist(err))
+		_, err = store.Get(context.Background(), attachments["malmo"])
+		require.Error(t, err)
+
+		_, err = os.Stat(filepath.Join(ebookDir, namespaceDir, "stockholm", isbn))
+		require.NoError(t, err)
+		content, err := store.Get(context.Background(), attachments["stockholm"])
+		require.NoError(t, err)
+		content.Close()
+	})
+
+	t.Run("Exports a tenant before it is offboarded", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/tenants/stockholm:offboard", nil)
+		response := httptest.NewRecorder()
+		tenants.ServeHTTP(response, req)
+		require.Equal(t, http.StatusUnauthorized, response.Code, "the admin token is required")
+
+		response = serve(http.MethodPost, "/api/v1/admin/tenants/stockholm:offboard", "", "", nil)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		var o Offboarding
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&o))
+		require.Equal(t, "stockholm", o.Tenant)
+		require.Equal(t, 1, o.Books)
+		require.Equal(t, 2, o.Files)
+
+		require.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/api/v1/books", "stockholm", "", nil).Code)
+		_, err := os.Stat(filepath.Join(ebookDir, namespaceDir, "stockholm"))
+		require.True(t, os.IsNotExist(err))
+		_, err = store.Get(context.Background(), attachments["stockholm"])
+		require.Error(t, err)
+
+		response = serve(http.MethodGet, "/api/v1/admin/offboardings", "", "", nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		var offboardings []Offboarding
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&offboardings))
+		require.Len(t, offboardings, 1)
+		require.Equal(t, o.SHA256, offboardings[0].SHA256)
+
+		response = serve(http.MethodGet, "/api/v1/admin/offboardings/"+o.Archive, "", "", nil)
+		require.Equal(t, http.StatusOK, response.Code)
+		sum := sha256.Sum256(response.Body.Bytes())
+		require.Equal(t, o.SHA256, hex.EncodeToString(sum[:]))
+		archive, err := zip.NewReader(bytes.NewReader(response.Body.Bytes()), int64(response.Body.Len()))
+		require.NoError(t, err)
+		files := make(map[string]string)
+		for _, f := range archive.File {
+			rc, err := f.Open()
+			require.NoError(t, err)
+			content, err := io.ReadAll(rc)
+			rc.Close()
+			require.NoError(t, err)
+			files[f.Name] = string(content)
+		}
+		require.Equal(t, "stockholm", files["ebooks/"+isbn])
+		require.Equal(t, "stockholm", files["blobs/"+strings.TrimPrefix(attachments["stockholm"], namespaceDir+"/stockholm/")])
+		require.True(t, strings.HasPrefix(files["library.db"], string(sqliteHeader)))
+
+		response = serve(http.MethodGet, "/api/v1/admin/offboardings/malmo.zip", "", "", nil)
+		require.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
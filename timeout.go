@@ -0,0 +1,204 @@
+package library
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeouts limit the time spent on a request. When the time is up the
+// context of the request is canceled and 504 Gateway Timeout is returned. A
+// zero duration disables the timeout. Exports, e.g. of XLSX, backups and
+// the downloads of files are streamed and have no timeout unless Routes sets
+// one.
+type Timeouts struct {
+	Read   time.Duration            // GET and HEAD requests
+	Write  time.Duration            // Requests with other methods
+	Import time.Duration            // File imports, restores and uploads
+	Routes map[string]time.Duration // Overrides by path template, e.g. "/api/v1/books:batch"
+}
+
+// defaultTimeouts leave room for copy cataloging when books are created.
+var defaultTimeouts = Timeouts{
+	Read:   2 * time.Second,
+	Write:  10 * time.Second,
+	Import: time.Minute,
+}
+
+// WithTimeouts sets the timeouts of the routes. The default is 2s for reads,
+// 10s for writes and a minute for imports.
+func WithTimeouts(t Timeouts) ServerOption {
+	return func(s *Server) {
+		s.timeouts = t
+	}
+}
+
+// timeout returns the timeout of the route.
+func (t Timeouts) timeout(path, method string) time.Duration {
+	if d, ok := t.Routes[path]; ok {
+		return d
+	}
+	switch {
+	case strings.HasSuffix(path, ".xlsx"), strings.HasSuffix(path, "/admin/backup"),
+		strings.Contains(path, "/ebooks/download/"),
+		strings.HasSuffix(path, "/attachments/{id}") && method == http.MethodGet,
+		strings.HasSuffix(path, "/cover") && method == http.MethodGet:
+		// Exports, backups and downloads are streamed, which buffering the
+		// response would undo
+		return 0
+	case strings.HasSuffix(path, ":import"), strings.HasSuffix(path, "/admin/restore"),
+		strings.HasSuffix(path, "/ebook/file"),
+		strings.HasSuffix(path, "/attachments") && method == http.MethodPost,
+		strings.HasSuffix(path, "/cover") && method == http.MethodPut:
+		return t.Import
+	case method == http.MethodGet || method == http.MethodHead:
+		return t.Read
+	}
+	return t.Write
+}
+
+// withTimeout cancels the context of the request after d and responds with
+// 504 unless the handler is done. The response of the handler is buffered
+// until it is done, so that a late handler can not write after the timeout
+// response. A zero duration neither times out nor buffers the response.
+func withTimeout(d time.Duration, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := newHandlerContext(r.Context())
+		r = r.WithContext(ctx)
+		if d <= 0 {
+			defer ctx.finish()
+			h(w, r)
+			return
+		}
+		timer := time.AfterFunc(d, ctx.cancel)
+		defer timer.Stop()
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer ctx.finish()
+			defer func() {
+				if v := recover(); v != nil {
+					panicked <- v
+				}
+			}()
+			h(tw, r)
+			close(done)
+		}()
+
+		select {
+		case v := <-panicked:
+			// Let recoverPanics handle it in the goroutine of the request
+			panic(v)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for k, v := range tw.header {
+				w.Header()[k] = v
+			}
+			if tw.code == 0 {
+				tw.code = http.StatusOK
+			}
+			w.WriteHeader(tw.code)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			writeErrorEnvelope(w, r, http.StatusGatewayTimeout, "The request timed out")
+		}
+	}
+}
+
+// handlerContext is the context of a handler. It ends when the request is
+// canceled or times out while the handler runs, but not after the handler
+// is done. The SQLite driver interrupts the connection of a statement when
+// its context ends, and may do so after the statement is done, which would
+// interrupt the next statement on the connection.
+type handlerContext struct {
+	context.Context
+	mu       sync.Mutex
+	end      context.CancelFunc
+	finished bool
+	stop     chan struct{}
+}
+
+// newHandlerContext returns the context of a handler of a request with the
+// context parent, whose values it keeps.
+func newHandlerContext(parent context.Context) *handlerContext {
+	ctx, cancel := context.WithCancel(valuesContext{parent})
+	c := &handlerContext{Context: ctx, end: cancel, stop: make(chan struct{})}
+	go func() {
+		select {
+		case <-parent.Done():
+			c.cancel()
+		case <-c.stop:
+		}
+	}()
+	return c
+}
+
+// cancel ends the context unless the handler is done.
+func (c *handlerContext) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.finished {
+		c.end()
+	}
+}
+
+// finish marks the handler as done, after which the context does not end.
+func (c *handlerContext) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.finished {
+		c.finished = true
+		close(c.stop)
+	}
+}
+
+// valuesContext has the values of a context but never ends.
+type valuesContext struct {
+	context.Context
+}
+
+func (valuesContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesContext) Done() <-chan struct{}       { return nil }
+func (valuesContext) Err() error                  { return nil }
+
+// timeoutWriter buffers the response of a handler which may time out.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
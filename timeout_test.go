@@ -0,0 +1,110 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeouts(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	server := NewServer(db, WithTimeouts(Timeouts{
+		Read:   time.Second,
+		Routes: map[string]time.Duration{"/slow": 10 * time.Millisecond},
+	}))
+	canceled := make(chan bool, 1)
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			canceled <- true
+		case <-time.After(time.Second):
+			canceled <- false
+		}
+		w.Write([]byte("too late"))
+	}
+	server.route("/slow", http.MethodGet, slow)
+	server.route("/fast", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("done"))
+	})
+
+	t.Run("Returns 504 and cancels the request when the route times out", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/slow", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		require.Equal(t, http.StatusGatewayTimeout, response.Code)
+		var got ErrorEnvelope
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Equal(t, http.StatusGatewayTimeout, got.Error.Code)
+		require.True(t, <-canceled)
+	})
+
+	t.Run("Rolls back the transaction of a request which times out", func(t *testing.T) {
+		txErr := make(chan error, 1)
+		server.timeouts.Routes["/slow-write"] = 10 * time.Millisecond
+		server.route("/slow-write", http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			txErr <- server.inTx(r.Context(), func(tx *sql.Tx) error {
+				if _, err := tx.Exec("INSERT INTO subject (id, name, createTime) VALUES ('late', 'Late', 0)"); err != nil {
+					return err
+				}
+				<-r.Context().Done()
+				return nil
+			})
+		})
+		request, _ := http.NewRequest(http.MethodPost, "/slow-write", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		require.Equal(t, http.StatusGatewayTimeout, response.Code)
+		require.Error(t, <-txErr)
+		var n int
+		require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM subject WHERE id = 'late'").Scan(&n))
+		require.Zero(t, n)
+	})
+
+	t.Run("Does not end the context after the handler is done", func(t *testing.T) {
+		ctx := make(chan context.Context, 1)
+		server.route("/context", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			ctx <- r.Context()
+		})
+		parent, cancel := context.WithCancel(context.Background())
+		request, _ := http.NewRequestWithContext(parent, http.MethodGet, "/context", nil)
+		server.ServeHTTP(httptest.NewRecorder(), request)
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, (<-ctx).Err())
+	})
+
+	t.Run("Passes on responses within the timeout", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/fast", nil)
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, request)
+
+		require.Equal(t, http.StatusAccepted, response.Code)
+		require.Equal(t, "text/plain", response.Header().Get("Content-Type"))
+		require.Equal(t, "done", response.Body.String())
+	})
+
+	t.Run("Selects the timeout of the route", func(t *testing.T) {
+		timeouts := Timeouts{Read: 1, Write: 2, Import: 3, Routes: map[string]time.Duration{"/api/v1/books:batch": 4}}
+		require.Equal(t, time.Duration(1), timeouts.timeout("/api/v1/books", http.MethodGet))
+		require.Equal(t, time.Duration(2), timeouts.timeout("/api/v1/books/{isbn}", http.MethodPost))
+		require.Equal(t, time.Duration(3), timeouts.timeout("/api/v1/admin/authorities:import", http.MethodPost))
+		require.Equal(t, time.Duration(4), timeouts.timeout("/api/v1/books:batch", http.MethodPost))
+		require.Equal(t, time.Duration(0), timeouts.timeout("/api/v1/admin/backup", http.MethodPost), "backups are streamed")
+		require.Equal(t, time.Duration(0), timeouts.timeout("/api/v1/ebooks/download/{token}", http.MethodGet), "downloads are streamed")
+		require.Equal(t, time.Duration(0), timeouts.timeout("/api/v1/books/{isbn}/attachments/{id}", http.MethodGet))
+		require.Equal(t, time.Duration(0), timeouts.timeout("/api/v1/books/{isbn}/cover", http.MethodGet))
+		require.Equal(t, time.Duration(3), timeouts.timeout("/api/v1/books/{isbn}/cover", http.MethodPut))
+	})
+}
@@ -0,0 +1,403 @@
+package library
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The parameters of the TOTP codes (RFC 6238), which are the defaults of
+// the authenticator apps.
+const (
+	totpDigits = 6
+	totpPeriod = 30 // Seconds
+	totpSkew   = 1  // Steps accepted before and after the current one, for clock drift
+	totpIssuer = "Library"
+
+	recoveryCodeCount = 10
+)
+
+// The second factor errors of a login.
+var (
+	errSecondFactorRequired  = &statusError{http.StatusUnauthorized, "A two-factor code is required"}
+	errSecondFactorIncorrect = &statusError{http.StatusUnauthorized, "The two-factor code is incorrect"}
+)
+
+// errNoTOTP is returned when a member has no TOTP authenticator.
+var errNoTOTP = errors.New("no totp authenticator")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// WithTwoFactorRoles requires the members with any of the roles to log in
+// with a TOTP code. Members who have not enrolled an authenticator get a
+// token which can only be used to enroll one.
+func WithTwoFactorRoles(roles ...string) ServerOption {
+	return func(s *Server) {
+		s.twoFactorRoles = roles
+	}
+}
+
+// twoFactorRequired reports whether the member must log in with a TOTP code.
+func (s *Server) twoFactorRequired(m Member) bool {
+	for _, role := range m.Roles {
+		for _, required := range s.twoFactorRoles {
+			if role == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canEnrollTOTP reports whether the member may enroll an authenticator,
+// which is allowed for librarians and admins.
+func canEnrollTOTP(m Member) bool {
+	return hasRole(m, RoleLibrarian) || hasRole(m, RoleAdmin)
+}
+
+// hotp returns the HOTP code (RFC 4226) of the counter.
+func hotp(secret []byte, counter uint64) string {
+	mac := hmac.New(sha1.New, secret)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// matchTOTP returns the time step of the code if it is valid at the given
+// time and later than lastStep, so that a code can not be used twice.
+func matchTOTP(secret []byte, code string, now time.Time, lastStep int64) (int64, bool) {
+	step := now.Unix() / totpPeriod
+	for s := step - totpSkew; s <= step+totpSkew; s++ {
+		if s > lastStep && subtle.ConstantTimeCompare([]byte(hotp(secret, uint64(s))), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI returns the otpauth URI of the secret, which the authenticator
+// apps read from a QR code.
+func totpURI(account, secret string) string {
+	return (&url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + totpIssuer + ":" + account,
+		RawQuery: url.Values{
+			"secret": {secret},
+			"issuer": {totpIssuer},
+			"digits": {fmt.Sprint(totpDigits)},
+			"period": {fmt.Sprint(totpPeriod)},
+		}.Encode(),
+	}).String()
+}
+
+// readTOTP returns the decrypted TOTP secret of the member.
+func (s *Server) readTOTP(q Querier, memberID string) (secret []byte, confirmed bool, lastStep int64, err error) {
+	var encrypted string
+	err = q.QueryRow("SELECT secret, confirmed, lastStep FROM member_totp WHERE memberId = ?", memberID).
+		Scan(&encrypted, &confirmed, &lastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, 0, errNoTOTP
+	} else if err != nil {
+		return nil, false, 0, fmt.Errorf("read totp err, %w", err)
+	}
+	secret, err = s.keys.decrypt(q, encrypted)
+	return secret, confirmed, lastStep, err
+}
+
+// newRecoveryCodes replaces the recovery codes of the member. Only the
+// hashes are stored, so the codes can only be shown once.
+func newRecoveryCodes(q Querier, memberID string) ([]string, error) {
+	if _, err := q.Exec("DELETE FROM member_recovery_code WHERE memberId = ?", memberID); err != nil {
+		return nil, fmt.Errorf("delete recovery codes err, %w", err)
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("create recovery code err, %w", err)
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		if _, err := q.Exec("INSERT INTO member_recovery_code (memberId, hash) VALUES(?,?)", memberID, hashToken(code)); err != nil {
+			return nil, fmt.Errorf("insert recovery code err, %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// useRecoveryCode deletes the recovery code of the member, and reports
+// whether there was such a code.
+func useRecoveryCode(q Querier, memberID, code string) (bool, error) {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	res, err := q.Exec("DELETE FROM member_recovery_code WHERE memberId = ? AND hash = ?", memberID, hashToken(code))
+	if err != nil {
+		return false, fmt.Errorf("delete recovery code err, %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// checkSecondFactor checks the TOTP code, or a recovery code, of a member
+// with a confirmed authenticator. It reports whether the member has one.
+func (s *Server) checkSecondFactor(q Querier, m Member, code, ip string, now time.Time) (bool, error) {
+	secret, confirmed, lastStep, err := s.readTOTP(q, m.ID)
+	if errors.Is(err, errNoTOTP) || (err == nil && !confirmed) {
+		return false, nil
+	} else if err != nil {
+		return true, err
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return true, errSecondFactorRequired
+	}
+	if step, ok := matchTOTP(secret, code, now, lastStep); ok {
+		if _, err := q.Exec("UPDATE member_totp SET lastStep = ? WHERE memberId = ?", step, m.ID); err != nil {
+			return true, fmt.Errorf("update totp err, %w", err)
+		}
+		return true, nil
+	}
+	ok, err := useRecoveryCode(q, m.ID, code)
+	if err != nil {
+		return true, err
+	}
+	if !ok {
+		return true, errSecondFactorIncorrect
+	}
+	return true, recordSecurityEvent(q, SecurityRecoveryCodeUsed, m.Email, ip, now)
+}
+
+// totpMember returns the member of a session, or of a token which may only
+// be used to enroll an authenticator. The token is "" for sessions.
+func (s *Server) totpMember(r *http.Request) (Member, string, error) {
+	m, err := s.sessionMember(r)
+	if !errors.Is(err, errNoMember) {
+		return m, "", err
+	}
+	token := bearerToken(r)
+	if token == "" {
+		return Member{}, "", errNoMember
+	}
+	m, err = s.tokenMember(s.db, token, TokenTOTPEnrollment, time.Now())
+	return m, token, err
+}
+
+// TOTPEnrollment is the secret of a new authenticator. The URI is shown as
+// a QR code for the authenticator app to scan, the secret can be typed in
+// instead.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// totpCodeRequest is the body of the requests which check a TOTP code.
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes are the codes which log in once each when the authenticator
+// is lost. The session is set when an enrollment completes a login.
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+	Session       *Session `json:"session,omitempty"`
+}
+
+// StartTOTPEnrollment creates the secret of a new authenticator of the
+// member, which is enabled once a code of it is confirmed.
+func (s *Server) StartTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	m, _, err := s.totpMember(r)
+	if errors.Is(err, errNoMember) {
+		HandleErr(w, http.StatusUnauthorized, "The request is not logged in")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the session")
+		return
+	}
+	if !canEnrollTOTP(m) {
+		HandleErr(w, http.StatusForbidden, "Only librarians and admins can enroll two-factor authentication")
+		return
+	}
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to create the secret")
+		return
+	}
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if _, confirmed, _, err := s.readTOTP(tx, m.ID); err == nil && confirmed {
+			return &statusError{http.StatusConflict, "Two-factor authentication is already enrolled"}
+		} else if err != nil && !errors.Is(err, errNoTOTP) {
+			return err
+		}
+		encrypted, err := s.keys.encrypt(tx, secret)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO member_totp (memberId, secret) VALUES(?,?)
+			ON CONFLICT (memberId) DO UPDATE SET secret = excluded.secret`, m.ID, encrypted)
+		return err
+	})
+	if errors.Is(err, errNoEncryptionKey) {
+		HandleErr(w, http.StatusNotImplemented, "Two-factor authentication is not configured")
+		return
+	}
+	if err != nil {
+		handleMemberErr(w, err, "Failed to store the secret")
+		return
+	}
+	enrollment := TOTPEnrollment{Secret: totpEncoding.EncodeToString(secret)}
+	enrollment.URI = totpURI(m.Email, enrollment.Secret)
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(enrollment); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the enrollment")
+		return
+	}
+}
+
+// ConfirmTOTPEnrollment enables the new authenticator of the member with a
+// code of it, and returns the recovery codes. When the member logged in
+// without an authenticator the session starts now.
+func (s *Server) ConfirmTOTPEnrollment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	m, enrollmentToken, err := s.totpMember(r)
+	if errors.Is(err, errNoMember) {
+		HandleErr(w, http.StatusUnauthorized, "The request is not logged in")
+		return
+	} else if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the session")
+		return
+	}
+	var req totpCodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		handleDecodeErr(w, err, "Failed to decode the code")
+		return
+	}
+	var res RecoveryCodes
+	now := time.Now()
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		secret, confirmed, _, err := s.readTOTP(tx, m.ID)
+		if errors.Is(err, errNoTOTP) {
+			return &statusError{http.StatusNotFound, "There is no two-factor enrollment to confirm"}
+		} else if err != nil {
+			return err
+		}
+		if confirmed {
+			return &statusError{http.StatusConflict, "Two-factor authentication is already enrolled"}
+		}
+		step, ok := matchTOTP(secret, strings.TrimSpace(req.Code), now, 0)
+		if !ok {
+			return errSecondFactorIncorrect
+		}
+		if _, err := tx.Exec("UPDATE member_totp SET confirmed = 1, lastStep = ? WHERE memberId = ?", step, m.ID); err != nil {
+			return fmt.Errorf("update totp err, %w", err)
+		}
+		if res.RecoveryCodes, err = newRecoveryCodes(tx, m.ID); err != nil {
+			return err
+		}
+		if err := recordSecurityEvent(tx, SecurityTOTPEnrolled, m.Email, clientIP(r), now); err != nil {
+			return err
+		}
+		if enrollmentToken == "" {
+			return nil
+		}
+		if err := revokeToken(tx, enrollmentToken); err != nil {
+			return err
+		}
+		session, err := s.startSession(tx, m)
+		res.Session = &session
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to enroll two-factor authentication")
+		return
+	}
+	if res.Session != nil {
+		s.writeSessionCookie(w, *res.Session)
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the recovery codes")
+		return
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the member, which
+// requires a code of the authenticator.
+func (s *Server) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var res RecoveryCodes
+	err := s.withSecondFactor(r, func(tx *sql.Tx, m Member) error {
+		var err error
+		res.RecoveryCodes, err = newRecoveryCodes(tx, m.ID)
+		return err
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to create the recovery codes")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the recovery codes")
+		return
+	}
+}
+
+// DisableTOTP removes the authenticator of the member, which requires a
+// code of it. Members whose roles require two-factor authentication can not
+// remove it.
+func (s *Server) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := s.withSecondFactor(r, func(tx *sql.Tx, m Member) error {
+		if s.twoFactorRequired(m) {
+			return &statusError{http.StatusForbidden, "Two-factor authentication is required for the roles of the member"}
+		}
+		if _, err := tx.Exec("DELETE FROM member_totp WHERE memberId = ?", m.ID); err != nil {
+			return fmt.Errorf("delete totp err, %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM member_recovery_code WHERE memberId = ?", m.ID); err != nil {
+			return fmt.Errorf("delete recovery codes err, %w", err)
+		}
+		return recordSecurityEvent(tx, SecurityTOTPDisabled, m.Email, clientIP(r), time.Now())
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to disable two-factor authentication")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// withSecondFactor runs f in a transaction when the request is logged in
+// and has a code of the authenticator of the member.
+func (s *Server) withSecondFactor(r *http.Request, f func(tx *sql.Tx, m Member) error) error {
+	m, err := s.sessionMember(r)
+	if errors.Is(err, errNoMember) {
+		return &statusError{http.StatusUnauthorized, "The request is not logged in"}
+	} else if err != nil {
+		return err
+	}
+	var req totpCodeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		return &statusError{http.StatusBadRequest, "Failed to decode the code"}
+	}
+	return s.inTx(r.Context(), func(tx *sql.Tx) error {
+		enrolled, err := s.checkSecondFactor(tx, m, req.Code, clientIP(r), time.Now())
+		if err != nil {
+			return err
+		}
+		if !enrolled {
+			return &statusError{http.StatusNotFound, "Two-factor authentication is not enrolled"}
+		}
+		return f(tx, m)
+	})
+}
@@ -0,0 +1,122 @@
+package library
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NicolaiMordrup/library/kms"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCodes(t *testing.T) {
+	// The SHA1 test vectors of RFC 6238, appendix B, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	for unix, code := range map[int64]string{59: "287082", 1111111109: "081804", 2000000000: "279037"} {
+		step, ok := matchTOTP(secret, code, time.Unix(unix, 0), 0)
+		require.True(t, ok)
+		require.Equal(t, unix/totpPeriod, step)
+		_, ok = matchTOTP(secret, code, time.Unix(unix, 0), step)
+		require.False(t, ok, "a code should not be accepted twice")
+	}
+	_, ok := matchTOTP(secret, "287082", time.Unix(59+3*totpPeriod, 0), 0)
+	require.False(t, ok)
+}
+
+func TestTOTP(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+	kek, err := kms.NewLocalKey(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	server := NewServer(db, WithKeyEncrypter(kek), WithTwoFactorRoles(RoleLibrarian))
+
+	passwordHash, err := hashPassword("pippi longstocking")
+	require.NoError(t, err)
+	for _, m := range []Member{
+		{ID: "astrid", Email: "astrid@example.com", FirstName: "astrid", LastName: "lindgren", EmailVerified: true},
+		{ID: "emil", Email: "emil@example.com", FirstName: "emil", LastName: "svensson", EmailVerified: true},
+	} {
+		require.NoError(t, server.InsertMember(db, m, passwordHash))
+	}
+	require.NoError(t, setRoles(db, "astrid", []string{RoleLibrarian}))
+
+	serve := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		jsonBytes, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(jsonBytes))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		server.ServeHTTP(response, req)
+		return response
+	}
+	login := func(email, code string) (*httptest.ResponseRecorder, Session) {
+		response := serve(http.MethodPost, "/api/v1/login", "", loginRequest{Email: email, Password: "pippi longstocking", Code: code})
+		var session Session
+		json.NewDecoder(bytes.NewReader(response.Body.Bytes())).Decode(&session)
+		return response, session
+	}
+
+	var secret []byte
+	var recoveryCodes []string
+	t.Run("Requires librarians to enroll an authenticator", func(t *testing.T) {
+		response, session := login("astrid@example.com", "")
+		require.Equal(t, http.StatusOK, response.Code)
+		require.True(t, session.TwoFactorEnrollmentRequired)
+		require.Empty(t, response.Result().Cookies())
+		require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "/api/v1/me", session.Token, nil).Code)
+
+		response = serve(http.MethodPost, "/api/v1/me/totp", session.Token, nil)
+		require.Equal(t, http.StatusCreated, response.Code)
+		var enrollment TOTPEnrollment
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&enrollment))
+		require.Contains(t, enrollment.URI, "otpauth://totp/Library:astrid@example.com?")
+		secret, err = totpEncoding.DecodeString(enrollment.Secret)
+		require.NoError(t, err)
+
+		var stored string
+		require.NoError(t, db.QueryRow("SELECT secret FROM member_totp WHERE memberId = 'astrid'").Scan(&stored))
+		require.NotContains(t, stored, enrollment.Secret, "the secret should be encrypted")
+
+		response = serve(http.MethodPost, "/api/v1/me/totp:confirm", session.Token, totpCodeRequest{Code: "000000"})
+		require.Equal(t, http.StatusUnauthorized, response.Code)
+		code := hotp(secret, uint64(time.Now().Unix()/totpPeriod))
+		response = serve(http.MethodPost, "/api/v1/me/totp:confirm", session.Token, totpCodeRequest{Code: code})
+		require.Equal(t, http.StatusOK, response.Code)
+		var res RecoveryCodes
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&res))
+		require.Len(t, res.RecoveryCodes, recoveryCodeCount)
+		require.NotNil(t, res.Session)
+		recoveryCodes = res.RecoveryCodes
+		require.Equal(t, http.StatusOK, serve(http.MethodGet, "/api/v1/me", res.Session.Token, nil).Code)
+	})
+
+	t.Run("Logs in with a code", func(t *testing.T) {
+		response, _ := login("astrid@example.com", "")
+		require.Equal(t, http.StatusUnauthorized, response.Code)
+		response, _ = login("astrid@example.com", hotp(secret, uint64(time.Now().Unix()/totpPeriod)))
+		require.Equal(t, http.StatusUnauthorized, response.Code, "the code of the enrollment should not be accepted again")
+		response, session := login("astrid@example.com", hotp(secret, uint64(time.Now().Unix()/totpPeriod+1)))
+		require.Equal(t, http.StatusOK, response.Code)
+		require.False(t, session.TwoFactorEnrollmentRequired)
+
+		require.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "/api/v1/me/totp", session.Token,
+			totpCodeRequest{Code: recoveryCodes[1]}).Code)
+	})
+
+	t.Run("Logs in with a recovery code once", func(t *testing.T) {
+		response, _ := login("astrid@example.com", recoveryCodes[0])
+		require.Equal(t, http.StatusOK, response.Code)
+		response, _ = login("astrid@example.com", recoveryCodes[0])
+		require.Equal(t, http.StatusUnauthorized, response.Code)
+	})
+
+	t.Run("Only allows librarians and admins to enroll", func(t *testing.T) {
+		response, session := login("emil@example.com", "")
+		require.Equal(t, http.StatusOK, response.Code)
+		require.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/api/v1/me/totp", session.Token, nil).Code)
+	})
+}
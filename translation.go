@@ -0,0 +1,98 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Translation is a variant of the catalog fields of a book in another
+// language, e.g. the translated title of the book.
+type Translation struct {
+	Language    string `json:"language" xml:"language,attr" yaml:"language"` // BCP 47 language tag
+	Title       string `json:"title" xml:"title" yaml:"title"`
+	Description string `json:"description,omitempty" xml:"description,omitempty" yaml:"description,omitempty"`
+}
+
+// validateTranslations checks that every translation has a title and a valid
+// language tag, and that no language is translated twice.
+func validateTranslations(translations []Translation) error {
+	seen := make(map[language.Tag]bool, len(translations))
+	for _, t := range translations {
+		tag, err := language.Parse(t.Language)
+		if err != nil {
+			return fmt.Errorf("invalid language %q, %w", t.Language, err)
+		}
+		if seen[tag] {
+			return fmt.Errorf("language %q translated twice", t.Language)
+		}
+		seen[tag] = true
+		if !titlePattern.MatchString(t.Title) {
+			return errors.New("missing translated title")
+		}
+	}
+	return nil
+}
+
+// localize returns the book with its title and description replaced by the
+// translation which best matches the Accept-Language header. The chosen
+// language is returned as well, or an empty string if the book was not
+// localized.
+func localize(b Book, acceptLanguage string) (Book, string) {
+	if acceptLanguage == "" || len(b.Translations) == 0 {
+		return b, ""
+	}
+	desired, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil {
+		return b, ""
+	}
+
+	// The untranslated book is the first, and therefore fallback, option
+	supported := []language.Tag{language.Und}
+	for _, t := range b.Translations {
+		supported = append(supported, language.Make(t.Language))
+	}
+	_, index, confidence := language.NewMatcher(supported).Match(desired...)
+	if index == 0 || confidence == language.No {
+		return b, ""
+	}
+
+	t := b.Translations[index-1]
+	b.OriginalTitle = b.Title
+	b.Title = t.Title
+	if t.Description != "" {
+		b.Description = t.Description
+	}
+	return b, t.Language
+}
+
+// localizeAll localizes every book in the list.
+func localizeAll(books []Book, acceptLanguage string) []Book {
+	for i := range books {
+		books[i], _ = localize(books[i], acceptLanguage)
+	}
+	return books
+}
+
+// canonicalLanguage returns the canonical form of a valid BCP 47 language
+// tag, e.g. "sv-se" becomes "sv-SE", so that stored tags can be compared.
+func canonicalLanguage(lang string) string {
+	if lang == "" {
+		return ""
+	}
+	tag, err := language.Parse(lang)
+	if err != nil {
+		return lang
+	}
+	return tag.String()
+}
+
+// matchesLanguage reports whether the language of the book is within the
+// range of want, using basic filtering from RFC 4647: "sv" matches both
+// "sv" and "sv-FI", while "sv-FI" only matches "sv-FI".
+func matchesLanguage(b Book, want language.Tag) bool {
+	lang := canonicalLanguage(b.Language)
+	return lang == want.String() || strings.HasPrefix(lang, want.String()+"-")
+}
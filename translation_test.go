@@ -0,0 +1,71 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslations(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	isbn := "9789129688313"
+	book := Book{
+		ISBN:      isbn,
+		Title:     "Pippi Langstrump",
+		Author:    &Author{FirstName: "astrid", LastName: "lindgren"},
+		Publisher: "raben",
+		Translations: []Translation{
+			{Language: "en", Title: "Pippi Longstocking"},
+			{Language: "de", Title: "Pippi Langstrumpf"},
+		},
+	}
+	jsonBytes, err := json.Marshal(book)
+	require.NoError(t, err)
+	response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db)
+	assertStatus(t, response.Code, http.StatusOK, "Should get status code 200: "+
+		"status OK")
+
+	getBook := func(acceptLanguage string) (*httptest.ResponseRecorder, Book) {
+		request, _ := http.NewRequest(http.MethodGet, "/api/v1/books/"+isbn, nil)
+		request.Header.Set("Accept-Language", acceptLanguage)
+		response := httptest.NewRecorder()
+		NewServer(db).ServeHTTP(response, request)
+		var got Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		return response, got
+	}
+
+	t.Run("Returns the translation matching Accept-Language", func(t *testing.T) {
+		response, got := getBook("en-GB, sv;q=0.5")
+
+		require.Equal(t, "en", response.Result().Header.Get("Content-Language"))
+		require.Equal(t, "Pippi Longstocking", got.Title)
+		require.Equal(t, "Pippi Langstrump", got.OriginalTitle)
+		require.Len(t, got.Translations, 2)
+	})
+
+	t.Run("Returns the original without a matching translation", func(t *testing.T) {
+		response, got := getBook("fr")
+
+		require.Empty(t, response.Result().Header.Get("Content-Language"))
+		require.Equal(t, "Pippi Langstrump", got.Title)
+		require.Empty(t, got.OriginalTitle)
+	})
+
+	t.Run("Rejects invalid language tags", func(t *testing.T) {
+		invalid := book
+		invalid.ISBN = "9789129688314"
+		invalid.Translations = []Translation{{Language: "not a tag", Title: "x"}}
+		jsonBytes, _ := json.Marshal(invalid)
+		response := createNewRequest(http.MethodPost,
+			"/api/v1/books/"+invalid.ISBN, jsonBytes, db)
+
+		assertStatus(t, response.Code, http.StatusNotAcceptable, "Should get "+
+			"status code 406: status not acceptable")
+	})
+}
@@ -0,0 +1,144 @@
+// Warn before leaving a form with unsaved changes.
+document.querySelectorAll("form[method=post]").forEach(function (form) {
+  var dirty = false;
+  form.addEventListener("input", function () { dirty = true; });
+  form.addEventListener("submit", function () { dirty = false; });
+  window.addEventListener("beforeunload", function (e) {
+    if (dirty) {
+      e.preventDefault();
+      e.returnValue = "";
+    }
+  });
+});
+
+// Renew the editing lock of the book while its form is open.
+document.querySelectorAll("form[data-lock]").forEach(function (form) {
+  var interval = parseInt(form.dataset.lockInterval, 10) * 1000;
+  setInterval(function () {
+    fetch(form.dataset.lock, { method: "POST", credentials: "same-origin" });
+  }, interval);
+});
+
+// Preview an import with the mapping of the columns of the workbook, and
+// import the file once the mapping is right.
+document.querySelectorAll("form[data-import]").forEach(function (form) {
+  var columns = form.querySelector("table.columns");
+  var rows = form.querySelector("table.rows");
+  var result = form.querySelector("p.result");
+  var fieldSelect = form.querySelector("template.field");
+
+  function clear(table) {
+    while (table.rows.length > 1) {
+      table.deleteRow(1);
+    }
+  }
+
+  function showColumns(mapped) {
+    clear(columns);
+    (mapped || []).forEach(function (c) {
+      var tr = columns.insertRow();
+      tr.insertCell().textContent = c.header;
+      var select = fieldSelect.content.firstElementChild.cloneNode(true);
+      select.dataset.header = c.header;
+      select.value = c.field;
+      tr.insertCell().appendChild(select);
+    });
+    columns.hidden = !mapped;
+  }
+
+  function showRows(res) {
+    clear(rows);
+    res.rows.forEach(function (row) {
+      var tr = rows.insertRow();
+      var book = row.book || {};
+      var author = book.author ? book.author.firstName + " " + book.author.lastName : "";
+      [row.row, row.isbn, book.title, author, (row.tags || []).join(", "),
+        row.status === 200 ? "OK" : row.error || "Not imported"].forEach(function (v) {
+        tr.insertCell().textContent = v === undefined ? "" : v;
+      });
+    });
+    rows.hidden = false;
+    result.textContent = res.preview ? "Preview, nothing was imported." : res.imported + " books were imported.";
+    result.hidden = false;
+  }
+
+  form.addEventListener("change", function (e) {
+    if (e.target.name === "file" || e.target.name === "format") {
+      showColumns(null);
+    }
+  });
+
+  form.addEventListener("submit", function (e) {
+    e.preventDefault();
+    var file = form.elements.file.files[0];
+    if (!file) {
+      return;
+    }
+    var params = new URLSearchParams({ format: form.elements.format.value, preview: e.submitter.value });
+    columns.querySelectorAll("select").forEach(function (select) {
+      if (select.value) {
+        params.append("column", select.value + ":" + select.dataset.header);
+      }
+    });
+    // Once the columns are shown, the fields which no column is mapped to
+    // are left out
+    var selects = Array.prototype.slice.call(columns.querySelectorAll("select"));
+    Array.prototype.forEach.call(fieldSelect.content.firstElementChild.options, function (option) {
+      if (selects.length && option.value && !selects.some(function (s) { return s.value === option.value; })) {
+        params.append("column", option.value + ":");
+      }
+    });
+    fetch(form.dataset.import + "?" + params, { method: "POST", credentials: "same-origin", body: file })
+      .then(function (response) { return response.text(); })
+      .then(function (text) {
+        // Errors are plain messages, the results of imports are objects
+        try {
+          return JSON.parse(text);
+        } catch (err) {
+          throw new Error(text);
+        }
+      })
+      .then(function (res) {
+        showColumns(res.columns);
+        showRows(res);
+      })
+      .catch(function (err) {
+        result.textContent = err.message;
+        result.hidden = false;
+      });
+  });
+});
+
+// Log in through the API, which sets the session cookie, and go back to the
+// page which asked for the login.
+document.querySelectorAll("form[data-login]").forEach(function (form) {
+  var error = form.querySelector("p.error");
+  form.addEventListener("submit", function (e) {
+    e.preventDefault();
+    var body = { email: form.elements.email.value, password: form.elements.password.value, code: form.elements.code.value };
+    fetch(form.dataset.login, {
+      method: "POST",
+      credentials: "same-origin",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(body),
+    })
+      .then(function (response) {
+        return response.text().then(function (text) {
+          if (!response.ok) {
+            throw new Error(text);
+          }
+          return JSON.parse(text);
+        });
+      })
+      .then(function (session) {
+        if (session.twoFactorEnrollmentRequired) {
+          throw new Error("Enroll an authenticator before logging in to the admin UI.");
+        }
+        window.location = form.dataset.next;
+      })
+      .catch(function (err) {
+        error.textContent = err.message;
+        error.hidden = false;
+      });
+  });
+});
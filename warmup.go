@@ -0,0 +1,70 @@
+package library
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// WithWarmup makes the readiness probe fail until Warmup has run, so that
+// traffic is not routed to the server before it is warm.
+func WithWarmup() ServerOption {
+	return func(s *Server) {
+		s.warm = new(int32)
+	}
+}
+
+// Warmup primes the database connections and page cache with the queries
+// of the hot endpoints, and loads the collation tables used for sorting. The
+// server is marked as ready afterwards, also when warmup fails since a cold
+// server is still a working server.
+func (s *Server) Warmup(ctx context.Context) error {
+	defer s.markReady()
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("warmup ping err, %w", err)
+	}
+	books := ReadPublicBookList(s.db, time.Now())
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sortBooks(books, "title", s.locale)
+	if _, err := ReadTombstones(s.db); err != nil {
+		return fmt.Errorf("warmup err, %w", err)
+	}
+	if _, err := readOAIItems(s.db, time.Now()); err != nil {
+		return fmt.Errorf("warmup err, %w", err)
+	}
+	return ctx.Err()
+}
+
+func (s *Server) markReady() {
+	if s.warm != nil {
+		atomic.StoreInt32(s.warm, 1)
+	}
+}
+
+// ready reports whether the server should receive traffic.
+func (s *Server) ready() bool {
+	return s.warm == nil || atomic.LoadInt32(s.warm) == 1
+}
+
+// Readiness is the readiness probe. It fails until the server is warm and
+// when the database can not be reached.
+func (s *Server) Readiness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !s.ready() {
+		HandleErr(w, http.StatusServiceUnavailable, "Warming up")
+		return
+	}
+	if err := s.db.PingContext(r.Context()); err != nil {
+		HandleErr(w, http.StatusServiceUnavailable, "The database can not be reached")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(map[string]string{"status": "ready"}); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the status")
+		return
+	}
+}
@@ -0,0 +1,33 @@
+package library
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmup(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	probe := func(s *Server) int {
+		request, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+		response := httptest.NewRecorder()
+		s.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	t.Run("Is not ready until warm", func(t *testing.T) {
+		s := NewServer(db, WithWarmup())
+		require.Equal(t, http.StatusServiceUnavailable, probe(s))
+		require.NoError(t, s.Warmup(context.Background()))
+		require.Equal(t, http.StatusOK, probe(s))
+	})
+
+	t.Run("Is ready immediately without warmup", func(t *testing.T) {
+		require.Equal(t, http.StatusOK, probe(NewServer(db)))
+	})
+}
@@ -0,0 +1,109 @@
+package library
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/NicolaiMordrup/library/events"
+	"github.com/gorilla/mux"
+)
+
+// statusTransitions are the statuses which a book can change to from each
+// status. A withdrawn book can be published again.
+var statusTransitions = map[string][]string{
+	StatusDraft:     {StatusPublished},
+	StatusPublished: {StatusWithdrawn},
+	StatusWithdrawn: {StatusPublished},
+}
+
+// canTransition reports whether a book can change status from one status to
+// another.
+func canTransition(from, to string) bool {
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// changeStatus changes the status of the book with the given isbn. A book is
+// only published when it passes the validation of published books, so that
+// drafts can not be published incomplete.
+func (s *Server) changeStatus(q Querier, isbn, status string, now time.Time) (Book, error) {
+	book := FindSpecificBook(q, isbn)
+	if book.ISBN == "" {
+		return Book{}, &statusError{http.StatusNotFound, "The book did not exist in the library"}
+	}
+	if !canTransition(book.Status, status) {
+		return Book{}, &statusError{http.StatusConflict, fmt.Sprintf("The book can not change from %s to %s", book.Status, status)}
+	}
+	// A scheduled publication is done once the book is published
+	book.Status, book.PublishAt = status, nil
+	if err := validate(book); err != nil {
+		return Book{}, &statusError{http.StatusNotAcceptable, err.Error()}
+	}
+	// The update time changes so that synced clients and feeds learn about
+	// the published book
+	book.UpdateTime = now
+	if _, err := q.Exec("UPDATE library SET status = ?, updateTime = ?, publishAt = NULL WHERE isbn = ?", status, now, isbn); err != nil {
+		return Book{}, fmt.Errorf("change status err, %w", err)
+	}
+	if err := queueSearchUpdate(q, isbn); err != nil {
+		return Book{}, err
+	}
+	if err := s.recordEvent(q, events.TypeBookUpdated, isbn, &book); err != nil {
+		return Book{}, err
+	}
+	return book, nil
+}
+
+// writeStatusChange changes the status of the book in the path and writes
+// the book to the stream. A book which is locked by another member keeps its
+// status.
+func (s *Server) writeStatusChange(w http.ResponseWriter, r *http.Request, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	isbn := mux.Vars(r)["isbn"]
+	editor, err := s.editor(r)
+	if err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	if isTrainee(editor) {
+		handleBookErr(w, errTraineeEdit)
+		return
+	}
+	var book Book
+	err = s.inTx(r.Context(), func(tx *sql.Tx) error {
+		now := time.Now()
+		if err := checkBookLock(tx, isbn, editor.ID, now); err != nil {
+			return err
+		}
+		var err error
+		book, err = s.changeStatus(tx, isbn, status, now)
+		return err
+	})
+	if err != nil {
+		handleBookErr(w, err)
+		return
+	}
+	if err := writeEncoded(w, r, book); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the book instance")
+		return
+	}
+}
+
+// PublishBook publishes a draft or a withdrawn book, which shows it on the
+// public endpoints. Incomplete drafts are rejected with the fields which
+// are missing.
+func (s *Server) PublishBook(w http.ResponseWriter, r *http.Request) {
+	s.writeStatusChange(w, r, StatusPublished)
+}
+
+// WithdrawBook withdraws a published book. The record and its history are
+// kept, but the book can not be borrowed anymore.
+func (s *Server) WithdrawBook(w http.ResponseWriter, r *http.Request) {
+	s.writeStatusChange(w, r, StatusWithdrawn)
+}
@@ -0,0 +1,64 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBookWorkflow(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	isbn := "9789129688313"
+	readStatus := func() string {
+		t.Helper()
+		return FindSpecificBook(db, isbn).Status
+	}
+
+	t.Run("Creates incomplete drafts", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: "Pippi Langstrump", Status: StatusDraft})
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		require.Equal(t, StatusDraft, readStatus())
+
+		jsonBytes, _ = json.Marshal(Book{ISBN: "9789129657470", Title: "Lejonhjarta", Status: StatusWithdrawn})
+		require.Equal(t, http.StatusNotAcceptable, createNewRequest(http.MethodPost, "/api/v1/books/9789129657470", jsonBytes, db).Code)
+	})
+
+	t.Run("Hides drafts from the public", func(t *testing.T) {
+		require.Equal(t, http.StatusNotFound, createNewRequest(http.MethodGet, "/api/v1/books/"+isbn, nil, db).Code)
+		response := createNewRequest(http.MethodGet, "/api/v1/books:search?q=pippi", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		require.NotContains(t, response.Body.String(), isbn)
+	})
+
+	t.Run("Publishes complete drafts", func(t *testing.T) {
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn+":publish", nil, db)
+		require.Equal(t, http.StatusNotAcceptable, response.Code)
+		require.Contains(t, response.Body.String(), "Publishers name")
+
+		jsonBytes, _ := json.Marshal(Book{ISBN: isbn, Title: "Pippi Langstrump", Status: StatusPublished,
+			Author: &Author{FirstName: "Astrid", LastName: "Lindgren"}, Publisher: "raben"})
+		response = createNewRequest(http.MethodPut, "/api/v1/books/"+isbn, jsonBytes, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		require.Equal(t, StatusDraft, readStatus(), "updates keep the status")
+
+		response = createNewRequest(http.MethodPost, "/api/v1/books/"+isbn+":publish", nil, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		require.Equal(t, StatusPublished, readStatus())
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodGet, "/api/v1/books/"+isbn, nil, db).Code)
+		require.Equal(t, http.StatusConflict, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn+":publish", nil, db).Code)
+	})
+
+	t.Run("Withdraws published books", func(t *testing.T) {
+		response := createNewRequest(http.MethodPost, "/api/v1/books/"+isbn+":withdraw", nil, db)
+		require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		require.Equal(t, StatusWithdrawn, readStatus())
+		require.Equal(t, http.StatusOK, createNewRequest(http.MethodGet, "/api/v1/books/"+isbn, nil, db).Code,
+			"withdrawn books keep their record")
+		require.Equal(t, http.StatusConflict, createNewRequest(http.MethodPost, "/api/v1/books/"+isbn+":withdraw", nil, db).Code)
+	})
+}
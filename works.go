@@ -0,0 +1,280 @@
+package library
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Work groups the editions of the same intellectual creation, e.g. the
+// hardcover, paperback and translations of a novel.
+type Work struct {
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	Author     *Author   `json:"author"`
+	CreateTime time.Time `json:"createTime"`
+	Editions   []Book    `json:"editions,omitempty"` // Only set when a single work is retrieved
+}
+
+// WorkSuggestion is a group of books without a work which appear to be
+// editions of the same work.
+type WorkSuggestion struct {
+	Title  string   `json:"title"`
+	Author *Author  `json:"author"`
+	ISBNs  []string `json:"isbns"`
+}
+
+func validateWork(w Work) error {
+	var fieldErrors []string
+	if !titlePattern.MatchString(w.Title) {
+		fieldErrors = append(fieldErrors, " title ")
+	}
+	if w.Author == nil || !LastNamePattern.MatchString(w.Author.LastName) {
+		fieldErrors = append(fieldErrors, " authors lastname ")
+	}
+	if len(fieldErrors) != 0 {
+		return fmt.Errorf("validation failed, field error(s):%v. Fix these error before proceeding",
+			strings.Join(fieldErrors, ", "))
+	}
+	return nil
+}
+
+// InsertWork stores a work.
+func InsertWork(db Querier, w Work) error {
+	_, err := db.Exec("INSERT INTO work (id, title, firstName, lastName, createTime) VALUES(?,?,?,?,?)",
+		w.ID, w.Title, w.Author.FirstName, w.Author.LastName, w.CreateTime)
+	if err != nil {
+		return fmt.Errorf("insert work err, %w", err)
+	}
+	return nil
+}
+
+// FindWork reads a work. It returns sql.ErrNoRows if there is no such work.
+func FindWork(db Querier, id string) (Work, error) {
+	w := Work{Author: &Author{}}
+	err := db.QueryRow("SELECT id, title, firstName, lastName, createTime FROM work WHERE id = ?", id).
+		Scan(&w.ID, &w.Title, &w.Author.FirstName, &w.Author.LastName, &w.CreateTime)
+	return w, err
+}
+
+// ReadWorks reads all works ordered by title.
+func ReadWorks(db Querier) ([]Work, error) {
+	rows, err := db.Query("SELECT id, title, firstName, lastName, createTime FROM work ORDER BY title, id")
+	if err != nil {
+		return nil, fmt.Errorf("query works err, %w", err)
+	}
+	defer rows.Close()
+	var works []Work
+	for rows.Next() {
+		w := Work{Author: &Author{}}
+		if err := rows.Scan(&w.ID, &w.Title, &w.Author.FirstName, &w.Author.LastName, &w.CreateTime); err != nil {
+			return nil, fmt.Errorf("scan work err, %w", err)
+		}
+		works = append(works, w)
+	}
+	return works, rows.Err()
+}
+
+// DeleteWork deletes a work, its editions are kept without a work.
+func DeleteWork(db Querier, id string) error {
+	if _, err := db.Exec("UPDATE library SET workId = '' WHERE workId = ?", id); err != nil {
+		return fmt.Errorf("ungroup editions err, %w", err)
+	}
+	if _, err := db.Exec("DELETE FROM work WHERE id = ?", id); err != nil {
+		return fmt.Errorf("delete work err, %w", err)
+	}
+	return nil
+}
+
+// editionsOf returns the books which are editions of the work.
+func editionsOf(books []Book, workID string) []Book {
+	return filterBooks(books, func(b Book) bool { return b.WorkID == workID })
+}
+
+// otherEditions returns the ISBNs of the other public editions of the
+// work of the book.
+func otherEditions(db Querier, b Book, now time.Time) []string {
+	if b.WorkID == "" {
+		return nil
+	}
+	var isbns []string
+	for _, edition := range editionsOf(ReadPublicBookList(db, now), b.WorkID) {
+		if edition.ISBN != b.ISBN {
+			isbns = append(isbns, edition.ISBN)
+		}
+	}
+	return isbns
+}
+
+// checkWorkExists returns a validation error if the book refers to a work
+// which does not exist.
+func checkWorkExists(q Querier, b Book) error {
+	if b.WorkID == "" {
+		return nil
+	}
+	_, err := FindWork(q, b.WorkID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &statusError{http.StatusNotAcceptable, "validation failed, field error(s): work . Fix these error before proceeding"}
+	}
+	return err
+}
+
+// SuggestWorks groups the books without a work by their normalized author
+// and title, the groups with more than one book are likely editions of the
+// same work.
+func SuggestWorks(db Querier) ([]WorkSuggestion, error) {
+	aliases, err := readAuthorAliases(db)
+	if err != nil {
+		return nil, err
+	}
+	type group struct {
+		suggestion WorkSuggestion
+		title      string
+	}
+	byAuthor := make(map[string][]*group)
+	var groups []*group
+	for _, b := range ReadDatabaseList(db) {
+		if b.WorkID != "" || b.Author == nil {
+			continue
+		}
+		author := authorKey(b.Author.LastName, b.Author.FirstName)
+		if heading, ok := aliases[author]; ok {
+			author = heading
+		}
+		title := normalizeTitle(b.Title)
+		var match *group
+		for _, g := range byAuthor[author] {
+			if similarity(title, g.title) >= duplicateThreshold {
+				match = g
+				break
+			}
+		}
+		if match == nil {
+			match = &group{suggestion: WorkSuggestion{Title: b.Title, Author: b.Author}, title: title}
+			byAuthor[author] = append(byAuthor[author], match)
+			groups = append(groups, match)
+		}
+		match.suggestion.ISBNs = append(match.suggestion.ISBNs, b.ISBN)
+	}
+
+	suggestions := []WorkSuggestion{}
+	for _, g := range groups {
+		if len(g.suggestion.ISBNs) > 1 {
+			sort.Strings(g.suggestion.ISBNs)
+			suggestions = append(suggestions, g.suggestion)
+		}
+	}
+	return suggestions, nil
+}
+
+// GetWorks retrieves all works.
+func (s *Server) GetWorks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	works, err := ReadWorks(s.db)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the works")
+		return
+	}
+	if works == nil {
+		works = []Work{}
+	}
+	if err := json.NewEncoder(w).Encode(works); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the works")
+		return
+	}
+}
+
+// GetWork retrieves a work with its public editions.
+func (s *Server) GetWork(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	work, err := FindWork(s.db, mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		HandleErr(w, http.StatusNotFound, "The work does not exist")
+		return
+	}
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the work")
+		return
+	}
+	work.Editions = editionsOf(ReadPublicBookList(s.db, time.Now()), work.ID)
+	if err := json.NewEncoder(w).Encode(work); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the work")
+		return
+	}
+}
+
+// CreateWork creates a work. Books are added to the work by setting their
+// workId.
+func (s *Server) CreateWork(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	var work Work
+	if err := decodeJSON(r, &work); err != nil {
+		handleDecodeErr(w, err, "Failed to decode work")
+		return
+	}
+	if err := validateWork(work); err != nil {
+		HandleErr(w, http.StatusNotAcceptable, err.Error())
+		return
+	}
+	work.ID = s.idGenerator.NewID()
+	work.CreateTime = time.Now()
+	work.Editions = nil
+	if err := InsertWork(s.db, work); err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to store the work")
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(work); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the work")
+		return
+	}
+}
+
+// DeleteWork deletes a work, its editions are kept.
+func (s *Server) DeleteWork(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := s.bookEditor(r); err != nil {
+		handleMemberErr(w, err, "Failed to read the session")
+		return
+	}
+	id := mux.Vars(r)["id"]
+	err := s.inTx(r.Context(), func(tx *sql.Tx) error {
+		if _, err := FindWork(tx, id); errors.Is(err, sql.ErrNoRows) {
+			return &statusError{http.StatusNotFound, "The work does not exist"}
+		} else if err != nil {
+			return err
+		}
+		return DeleteWork(tx, id)
+	})
+	if err != nil {
+		handleMemberErr(w, err, "Failed to delete the work")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWorkSuggestions retrieves groups of books without a work which appear
+// to be editions of the same work.
+func (s *Server) GetWorkSuggestions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	suggestions, err := SuggestWorks(s.db)
+	if err != nil {
+		HandleErr(w, http.StatusInternalServerError, "Failed to read the books")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(suggestions); err != nil {
+		HandleErr(w, http.StatusBadRequest, "Failed to Encode the suggestions")
+		return
+	}
+}
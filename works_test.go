@@ -0,0 +1,89 @@
+package library
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorks(t *testing.T) {
+	db, cleanup := createTempDatabase(t)
+	defer cleanup()
+
+	createBook := func(path string, book Book) int {
+		jsonBytes, _ := json.Marshal(book)
+		return createNewRequest(http.MethodPost, path, jsonBytes, db).Code
+	}
+	lindgren := &Author{FirstName: "Astrid", LastName: "Lindgren"}
+	require.Equal(t, http.StatusOK, createBook("/api/v1/books/1233211233215", Book{
+		ISBN: "1233211233215", Title: "Pippi Longstocking", Author: lindgren, Publisher: "raben",
+	}))
+	require.Equal(t, http.StatusOK, createBook("/api/v1/books/1233211233213?force=true", Book{
+		ISBN: "1233211233213", Title: "Pippi Longstocking: illustrated", Author: lindgren, Publisher: "oxford",
+	}))
+
+	t.Run("Suggests works for similar books", func(t *testing.T) {
+		response := createNewRequest(http.MethodGet, "/api/v1/works:suggestions", nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+
+		var got []WorkSuggestion
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Len(t, got, 1)
+		require.Equal(t, []string{"1233211233213", "1233211233215"}, got[0].ISBNs)
+	})
+
+	var work Work
+	t.Run("Creates a work", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(Work{Title: "Pippi Longstocking", Author: lindgren})
+		response := createNewRequest(http.MethodPost, "/api/v1/works", jsonBytes, db)
+		require.Equal(t, http.StatusCreated, response.Code)
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&work))
+		require.NotEmpty(t, work.ID)
+	})
+
+	t.Run("Groups editions in the work", func(t *testing.T) {
+		for _, isbn := range []string{"1233211233215", "1233211233213"} {
+			book := FindSpecificBook(db, isbn)
+			book.WorkID = work.ID
+			jsonBytes, _ := json.Marshal(book)
+			response := createNewRequest(http.MethodPut, "/api/v1/books/"+isbn, jsonBytes, db)
+			require.Equal(t, http.StatusOK, response.Code, response.Body.String())
+		}
+
+		// New editions of the work are not duplicates of each other
+		require.Equal(t, http.StatusOK, createBook("/api/v1/books/1233211233210", Book{
+			ISBN: "1233211233210", Title: "Pippi Longstockings", Author: lindgren,
+			Publisher: "puffin", WorkID: work.ID,
+		}))
+
+		response := createNewRequest(http.MethodGet, "/api/v1/works/"+work.ID, nil, db)
+		require.Equal(t, http.StatusOK, response.Code)
+		var got Work
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&got))
+		require.Len(t, got.Editions, 3)
+
+		response = createNewRequest(http.MethodGet, "/api/v1/books/1233211233215", nil, db)
+		var book Book
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&book))
+		require.ElementsMatch(t, []string{"1233211233213", "1233211233210"}, book.OtherEditions)
+	})
+
+	t.Run("Rejects books of unknown works", func(t *testing.T) {
+		jsonBytes, _ := json.Marshal(Book{ISBN: "1233211233218", Title: "Emil",
+			Author: lindgren, Publisher: "raben", WorkID: "missing"})
+		response := createNewRequest(http.MethodPost, "/api/v1/books/1233211233218", jsonBytes, db)
+		assertStatus(t, response.Code, http.StatusNotAcceptable, "Should get status "+
+			"code 406: status not acceptable")
+	})
+
+	t.Run("Deletes the work but keeps the editions", func(t *testing.T) {
+		response := createNewRequest(http.MethodDelete, "/api/v1/works/"+work.ID, nil, db)
+		require.Equal(t, http.StatusNoContent, response.Code)
+		require.Equal(t, "", FindSpecificBook(db, "1233211233215").WorkID)
+
+		response = createNewRequest(http.MethodGet, "/api/v1/works/"+work.ID, nil, db)
+		require.Equal(t, http.StatusNotFound, response.Code)
+	})
+}
@@ -0,0 +1,469 @@
+// Package xlsx reads the first worksheet of Excel workbooks and writes
+// workbooks whose rows are streamed to the output. Only the parts of Office
+// Open XML which spreadsheets of plain values need are supported, formulas
+// are read as their cached values.
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of XLSX workbooks.
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// ErrNoWorksheet is returned when a workbook has no worksheets.
+var ErrNoWorksheet = errors.New("xlsx: the workbook has no worksheets")
+
+// ErrTooLarge is returned when a workbook does not fit in the limits of the
+// reader, so that a small compressed workbook can not exhaust the memory.
+var ErrTooLarge = errors.New("xlsx: the workbook is too large")
+
+// The limits of the worksheets which are read. maxRows and maxColumns are
+// the limits of Excel, 1048576 rows and the columns A to XFD. maxCells
+// limits the cells of the rows which are read, including the empty cells
+// before the cells with values, and maxPartSize the uncompressed size of
+// every part of the workbook.
+const (
+	maxRows     = 1 << 20
+	maxColumns  = 1 << 14
+	maxCells    = 1 << 23
+	maxPartSize = 256 << 20
+)
+
+type relationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type workbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		// The relationship id is namespaced, which encoding/xml matches by
+		// the local name
+		RID string `xml:"id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// richText is a shared or inline string, which is either plain text or runs
+// of formatted text.
+type richText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t richText) String() string {
+	if len(t.Runs) == 0 {
+		return t.T
+	}
+	var sb strings.Builder
+	for _, r := range t.Runs {
+		sb.WriteString(r.T)
+	}
+	return sb.String()
+}
+
+type cell struct {
+	Ref    string    `xml:"r,attr"`
+	Type   string    `xml:"t,attr"`
+	Value  string    `xml:"v"`
+	Inline *richText `xml:"is"`
+}
+
+// ReadFirstSheet reads the rows of the first worksheet of a workbook as
+// text. Empty cells are "", and the rows are as long as their last cell
+// with a value.
+func ReadFirstSheet(r io.ReaderAt, size int64) ([][]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("xlsx: open workbook err, %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	var wb workbook
+	if err := decodeFile(files, "xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+	if len(wb.Sheets) == 0 {
+		return nil, ErrNoWorksheet
+	}
+	var rels relationships
+	if err := decodeFile(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	sheetPath := ""
+	for _, rel := range rels.Relationships {
+		if rel.ID == wb.Sheets[0].RID {
+			sheetPath = rel.Target
+		}
+	}
+	if sheetPath == "" {
+		return nil, ErrNoWorksheet
+	}
+	if strings.HasPrefix(sheetPath, "/") {
+		sheetPath = sheetPath[1:]
+	} else {
+		sheetPath = path.Join("xl", sheetPath)
+	}
+
+	var shared []string
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		var sst struct {
+			Items []richText `xml:"si"`
+		}
+		if err := decodeFile(files, "xl/sharedStrings.xml", &sst); err != nil {
+			return nil, err
+		}
+		for _, item := range sst.Items {
+			shared = append(shared, item.String())
+		}
+	}
+
+	f, ok := files[sheetPath]
+	if !ok {
+		return nil, ErrNoWorksheet
+	}
+	rc, err := openPart(f)
+	if err != nil {
+		return nil, fmt.Errorf("xlsx: open worksheet err, %w", err)
+	}
+	defer rc.Close()
+	// The worksheet is decoded a cell at a time, since it is the part which
+	// grows with the number of rows
+	var rows [][]string
+	cells := 0
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("xlsx: read worksheet err, %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "row":
+			rowNum := len(rows) + 1
+			for _, a := range start.Attr {
+				if a.Name.Local == "r" {
+					if n, err := strconv.Atoi(a.Value); err == nil && n > len(rows) {
+						rowNum = n
+					}
+				}
+			}
+			if rowNum > maxRows {
+				return nil, fmt.Errorf("%w, row %d is beyond the last row of a worksheet", ErrTooLarge, rowNum)
+			}
+			for len(rows) < rowNum {
+				rows = append(rows, nil)
+			}
+		case "c":
+			var c cell
+			if err := dec.DecodeElement(&c, &start); err != nil {
+				return nil, fmt.Errorf("xlsx: read cell err, %w", err)
+			}
+			if len(rows) == 0 {
+				rows = append(rows, nil)
+			}
+			row := &rows[len(rows)-1]
+			col := len(*row)
+			if c.Ref != "" {
+				if n, ok := columnIndex(c.Ref); ok {
+					col = n
+				}
+			}
+			if col >= maxColumns {
+				return nil, fmt.Errorf("%w, cell %s is beyond the last column of a worksheet", ErrTooLarge, c.Ref)
+			}
+			value := c.Value
+			switch c.Type {
+			case "s":
+				i, err := strconv.Atoi(c.Value)
+				if err != nil || i < 0 || i >= len(shared) {
+					return nil, fmt.Errorf("xlsx: cell %s refers to a missing shared string", c.Ref)
+				}
+				value = shared[i]
+			case "inlineStr":
+				if c.Inline != nil {
+					value = c.Inline.String()
+				}
+			}
+			if value == "" {
+				continue
+			}
+			if col >= len(*row) {
+				if cells += col + 1 - len(*row); cells > maxCells {
+					return nil, fmt.Errorf("%w, the worksheet has more than %d cells", ErrTooLarge, maxCells)
+				}
+			}
+			for len(*row) <= col {
+				*row = append(*row, "")
+			}
+			(*row)[col] = value
+		}
+	}
+	return rows, nil
+}
+
+func decodeFile(files map[string]*zip.File, name string, v interface{}) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("xlsx: the workbook has no %s", name)
+	}
+	rc, err := openPart(f)
+	if err != nil {
+		return fmt.Errorf("xlsx: open %s err, %w", name, err)
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("xlsx: decode %s err, %w", name, err)
+	}
+	return nil
+}
+
+// openPart opens a part of a workbook, which fails with ErrTooLarge when it
+// is read beyond maxPartSize.
+func openPart(f *zip.File) (io.ReadCloser, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&partReader{r: rc, n: maxPartSize}, rc}, nil
+}
+
+// partReader reads at most n bytes of a part.
+type partReader struct {
+	r io.Reader
+	n int64
+}
+
+func (p *partReader) Read(b []byte) (int, error) {
+	if p.n <= 0 {
+		return 0, fmt.Errorf("%w, a part is larger than %d bytes", ErrTooLarge, maxPartSize)
+	}
+	if int64(len(b)) > p.n {
+		b = b[:p.n]
+	}
+	n, err := p.r.Read(b)
+	p.n -= int64(n)
+	return n, err
+}
+
+// columnIndex returns the zero-based column of a cell reference, e.g. 27 of
+// AB12. Columns beyond maxColumns are returned as maxColumns, so that the
+// letters of a long reference can not overflow the index.
+func columnIndex(ref string) (int, bool) {
+	n := 0
+	i := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		if n = n*26 + int(ref[i]-'A') + 1; n > maxColumns {
+			return maxColumns, true
+		}
+	}
+	return n - 1, i > 0
+}
+
+// columnName returns the letters of a zero-based column, e.g. AB of 27.
+func columnName(col int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name
+}
+
+// Writer writes a workbook. The rows of a sheet are written to the output
+// as they are added, so that a sheet of any size can be written with
+// bounded memory. The sheets are written one at a time, in order.
+type Writer struct {
+	zw     *zip.Writer
+	sheets []string
+	sheet  io.Writer
+	rows   int
+	cols   int
+	err    error
+}
+
+// NewWriter returns a writer of a workbook to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{zw: zip.NewWriter(w)}
+}
+
+// Column describes a column of a sheet.
+type Column struct {
+	Header string
+	Width  float64 // In characters, 0 is the default width
+}
+
+// AddSheet starts a new sheet with a styled header row of the columns. The
+// header row is frozen and filters the rows below it.
+func (w *Writer) AddSheet(name string, columns []Column) error {
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+	w.sheets = append(w.sheets, name)
+	w.sheet, w.err = w.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(w.sheets)))
+	if w.err != nil {
+		return w.err
+	}
+	w.rows, w.cols = 0, len(columns)
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	buf.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	buf.WriteString("<cols>")
+	for i, c := range columns {
+		width := c.Width
+		if width == 0 {
+			width = 12
+		}
+		fmt.Fprintf(&buf, `<col min="%d" max="%d" width="%g" customWidth="1"/>`, i+1, i+1, width)
+	}
+	buf.WriteString("</cols><sheetData>")
+	if _, w.err = w.sheet.Write(buf.Bytes()); w.err != nil {
+		return w.err
+	}
+	headers := make([]interface{}, len(columns))
+	for i, c := range columns {
+		headers[i] = c.Header
+	}
+	return w.writeRow(headers, styleHeader)
+}
+
+// The styles of the cells, the indexes of the cellXfs of styles.xml.
+const (
+	styleDefault = 0
+	styleHeader  = 1
+)
+
+// WriteRow adds a row to the current sheet. Ints and floats are written as
+// numbers, nil as an empty cell and other values as text.
+func (w *Writer) WriteRow(values ...interface{}) error {
+	if w.sheet == nil {
+		return errors.New("xlsx: no sheet has been added")
+	}
+	return w.writeRow(values, styleDefault)
+}
+
+func (w *Writer) writeRow(values []interface{}, style int) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.rows++
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<row r="%d">`, w.rows)
+	for i, v := range values {
+		ref := columnName(i) + strconv.Itoa(w.rows)
+		switch v := v.(type) {
+		case nil:
+			continue
+		case int:
+			fmt.Fprintf(&buf, `<c r="%s" s="%d"><v>%d</v></c>`, ref, style, v)
+		case float64:
+			fmt.Fprintf(&buf, `<c r="%s" s="%d"><v>%s</v></c>`, ref, style, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			fmt.Fprintf(&buf, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+			xml.EscapeText(&buf, []byte(fmt.Sprint(v)))
+			buf.WriteString("</t></is></c>")
+		}
+	}
+	buf.WriteString("</row>")
+	_, w.err = w.sheet.Write(buf.Bytes())
+	return w.err
+}
+
+func (w *Writer) endSheet() error {
+	if w.err != nil || w.sheet == nil {
+		return w.err
+	}
+	tail := "</sheetData>"
+	if w.rows > 1 {
+		tail += fmt.Sprintf(`<autoFilter ref="A1:%s%d"/>`, columnName(w.cols-1), w.rows)
+	}
+	_, w.err = io.WriteString(w.sheet, tail+"</worksheet>")
+	w.sheet = nil
+	return w.err
+}
+
+// Close ends the last sheet and writes the parts of the workbook which list
+// the sheets. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+	if len(w.sheets) == 0 {
+		return ErrNoWorksheet
+	}
+	var types, sheets, rels bytes.Buffer
+	for i, name := range w.sheets {
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+		fmt.Fprintf(&sheets, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(name), i+1, i+1)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	stylesID := len(w.sheets) + 1
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+			types.String() + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+			`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>` + sheets.String() + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + rels.String() +
+			fmt.Sprintf(`<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, stylesID) +
+			`</Relationships>`},
+		{"xl/styles.xml", styles},
+	}
+	for _, p := range parts {
+		f, err := w.zw.Create(p.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, xml.Header+p.body); err != nil {
+			return err
+		}
+	}
+	return w.zw.Close()
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// styles has the default style and the style of the header rows, bold
+// white text on a dark blue fill.
+const styles = `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font>` +
+	`<font><b/><sz val="11"/><color rgb="FFFFFFFF"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="3"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill>` +
+	`<fill><patternFill patternType="solid"><fgColor rgb="FF1F4E78"/><bgColor indexed="64"/></patternFill></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="2" borderId="0" xfId="0" applyFont="1" applyFill="1"/></cellXfs>` +
+	`</styleSheet>`
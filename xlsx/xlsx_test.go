@@ -0,0 +1,123 @@
+package xlsx_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/NicolaiMordrup/library/xlsx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := xlsx.NewWriter(&buf)
+	require.NoError(t, w.AddSheet("Books", []xlsx.Column{{Header: "ISBN", Width: 16}, {Header: "Title"}, {Header: "Pages"}}))
+	require.NoError(t, w.WriteRow("9789129688313", "Pippi <Långstrump>", 160))
+	require.NoError(t, w.WriteRow("9789129657470", nil, 2.5))
+	require.NoError(t, w.AddSheet("Summary", []xlsx.Column{{Header: "Books"}}))
+	require.NoError(t, w.WriteRow(2))
+	require.NoError(t, w.Close())
+
+	rows, err := xlsx.ReadFirstSheet(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"ISBN", "Title", "Pages"},
+		{"9789129688313", "Pippi <Långstrump>", "160"},
+		{"9789129657470", "", "2.5"},
+	}, rows)
+}
+
+func TestReadSharedStrings(t *testing.T) {
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+			<sheets><sheet name="Stock" sheetId="1" r:id="rId3"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+			<Relationship Id="rId3" Type="worksheet" Target="worksheets/stock.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+			<si><t>Title</t></si><si><r><t>Mio </t></r><r><t>min Mio</t></r></si></sst>`,
+		"xl/worksheets/stock.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+			<row r="1"><c r="B1" t="s"><v>0</v></c></row>
+			<row r="3"><c r="B3" t="s"><v>1</v></c><c r="C3"><v>1954</v></c></row>
+		</sheetData></worksheet>`,
+	}
+	data := zipFiles(t, files)
+	rows, err := xlsx.ReadFirstSheet(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"", "Title"}, nil, {"", "Mio min Mio", "1954"}}, rows)
+}
+
+func zipFiles(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		f, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestReadLimits(t *testing.T) {
+	sheet := func(rows string) map[string]string {
+		return map[string]string{
+			"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+				<sheets><sheet name="Stock" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+			"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+				<Relationship Id="rId1" Type="worksheet" Target="worksheets/sheet1.xml"/></Relationships>`,
+			"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+				rows + `</sheetData></worksheet>`,
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		rows string
+	}{
+		{"Rejects a row beyond the last row", `<row r="1048577"><c r="A1048577"><v>1</v></c></row>`},
+		{"Rejects a column beyond XFD", `<row r="1"><c r="XFE1"><v>1</v></c></row>`},
+		{"Rejects a column which overflows", `<row r="1"><c r="AAAAAAAAAAAAAAAA1"><v>1</v></c></row>`},
+		{"Rejects too many cells", strings.Repeat(`<row><c r="XFD1"><v>1</v></c></row>`, 1024)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := zipFiles(t, sheet(tc.rows))
+			_, err := xlsx.ReadFirstSheet(bytes.NewReader(data), int64(len(data)))
+			require.ErrorIs(t, err, xlsx.ErrTooLarge)
+		})
+	}
+
+	t.Run("Reads the last cell of a worksheet", func(t *testing.T) {
+		data := zipFiles(t, sheet(`<row r="1048576"><c r="XFD1048576"><v>1</v></c></row>`))
+		rows, err := xlsx.ReadFirstSheet(bytes.NewReader(data), int64(len(data)))
+		require.NoError(t, err)
+		require.Len(t, rows, 1<<20)
+		require.Len(t, rows[len(rows)-1], 1<<14)
+	})
+
+	t.Run("Rejects a part which decompresses beyond the limit", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, body := range sheet("") {
+			f, err := zw.Create(name)
+			require.NoError(t, err)
+			_, err = f.Write([]byte(body))
+			require.NoError(t, err)
+		}
+		// A shared string of 257 MiB, which compresses to a few hundred KiB
+		f, err := zw.Create("xl/sharedStrings.xml")
+		require.NoError(t, err)
+		_, err = f.Write([]byte(`<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><si><t>`))
+		require.NoError(t, err)
+		chunk := bytes.Repeat([]byte("a"), 1<<20)
+		for i := 0; i < 257; i++ {
+			_, err = f.Write(chunk)
+			require.NoError(t, err)
+		}
+		require.NoError(t, zw.Close())
+		_, err = xlsx.ReadFirstSheet(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.ErrorIs(t, err, xlsx.ErrTooLarge)
+	})
+}